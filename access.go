@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/go-github/v58/github"
+)
+
+type senderKey struct{}
+
+// withSender returns a context carrying the user who triggered the command.
+func withSender(ctx context.Context, sender *github.User) context.Context {
+	return context.WithValue(ctx, senderKey{}, sender)
+}
+
+// commandSender returns the user who triggered the command, or nil for
+// automatic triggers such as newly opened issues.
+func commandSender(ctx context.Context) *github.User {
+	sender, _ := ctx.Value(senderKey{}).(*github.User)
+	return sender
+}
+
+// isMaintainer reports whether login has the maintain or admin role on owner/repo.
+func isMaintainer(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+	level, _, err := client.Repositories.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		return false, fmt.Errorf("checking permission of %s on %s/%s: %w", login, owner, repo, err)
+	}
+	if level.GetPermission() == "admin" {
+		return true, nil
+	}
+	perms := level.GetUser().Permissions
+	return perms["admin"] || perms["maintain"], nil
+}
+
+// requireMaintainer checks that the command sender maintains the repository,
+// replying on the issue and returning false when they don't.
+func (b *Bot) requireMaintainer(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, command string) bool {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	sender := commandSender(ctx)
+	if sender == nil {
+		return false
+	}
+	ok, err := isMaintainer(ctx, client, owner, name, sender.GetLogin())
+	if err != nil {
+		b.postComment(ctx, client, owner, name, issueNum, fmt.Sprintf("I couldn't verify your permissions to run `%s`. Please try again later.", command))
+		return false
+	}
+	if !ok {
+		b.postComment(ctx, client, owner, name, issueNum, fmt.Sprintf("@%s, only repository maintainers and admins can run `%s`.", sender.GetLogin(), command))
+		return false
+	}
+	return true
+}
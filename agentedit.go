@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	gl "cloud.google.com/go/ai/generativelanguage/apiv1beta"
+	pb "cloud.google.com/go/ai/generativelanguage/apiv1beta/generativelanguagepb"
+	"github.com/google/go-github/v58/github"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// promptAgentEdit names the system prompt of the model editing a checkout
+// through tools.
+const promptAgentEdit = "agent_edit"
+
+const (
+	// maxAgentToolCalls bounds the tool calls of one edit, so a model that
+	// keeps exploring can't run forever.
+	maxAgentToolCalls = 40
+	// maxToolOutput is the most bytes of a tool result sent to the model.
+	maxToolOutput = 16 * 1024
+	// maxSearchMatches is the most lines search_code returns.
+	maxSearchMatches = 50
+	// maxSearchFileSize skips files too large to be source code.
+	maxSearchFileSize = 1 << 20
+)
+
+// ToolGenerator is implemented by generators that let the model call tools
+// before it answers.
+type ToolGenerator interface {
+	// GenerateWithTools sends prompt and runs the tools the model calls,
+	// returning its final answer. It fails when the model makes more than
+	// maxCalls calls.
+	GenerateWithTools(ctx context.Context, prompt string, tools []modelTool, maxCalls int) (string, error)
+}
+
+// modelTool is a function the model may call. Its parameters are strings.
+type modelTool struct {
+	Name        string
+	Description string
+	Params      []toolParam
+	// Run returns the result shown to the model. Failures are results too,
+	// so the model can correct its call.
+	Run func(args map[string]string) string
+}
+
+type toolParam struct {
+	Name        string
+	Description string
+	Optional    bool
+}
+
+// agentWorkspace is the checkout a model explores and edits through tools.
+type agentWorkspace struct {
+	root    string
+	tests   *TestsConfig
+	runner  func(dir, name string, args ...string) (string, error)
+	written []string // paths written, in order of their first write
+}
+
+// resolve maps path, relative to the checkout, to a file in it. Paths can't
+// leave the checkout, through ".." or symlinks, or reach into .git.
+func (w *agentWorkspace) resolve(path string) (string, error) {
+	rel := strings.TrimPrefix(filepath.Clean("/"+filepath.FromSlash(path)), string(filepath.Separator))
+	if rel == ".git" || strings.HasPrefix(rel, ".git"+string(filepath.Separator)) {
+		return "", errors.New("the .git directory is off limits")
+	}
+	full := filepath.Join(w.root, rel)
+	// The deepest existing ancestor must stay in the checkout once symlinks
+	// are followed; the rest of the path doesn't exist yet.
+	existing := full
+	for {
+		if _, err := os.Lstat(existing); err == nil || existing == w.root {
+			break
+		}
+		existing = filepath.Dir(existing)
+	}
+	real, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	root, err := filepath.EvalSymlinks(w.root)
+	if err != nil {
+		return "", err
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside the repository", path)
+	}
+	return full, nil
+}
+
+// relative returns full as a slash-separated path relative to the checkout.
+func (w *agentWorkspace) relative(full string) string {
+	rel, err := filepath.Rel(w.root, full)
+	if err != nil {
+		return full
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (w *agentWorkspace) listDir(args map[string]string) string {
+	dir, err := w.resolve(args["path"])
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	var lines []string
+	for _, entry := range entries {
+		switch {
+		case entry.Name() == ".git":
+		case entry.IsDir():
+			lines = append(lines, entry.Name()+"/")
+		default:
+			lines = append(lines, entry.Name())
+		}
+	}
+	if len(lines) == 0 {
+		return "(empty directory)"
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (w *agentWorkspace) readFile(args map[string]string) string {
+	file, err := w.resolve(args["path"])
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if isBinary(data) {
+		return "Error: " + args["path"] + " is a binary file"
+	}
+	return truncateToolOutput(numberLines(string(data)))
+}
+
+func (w *agentWorkspace) searchCode(args map[string]string) string {
+	query := args["query"]
+	if query == "" {
+		return "Error: query is required"
+	}
+	pattern, err := regexp.Compile(query)
+	if err != nil {
+		pattern = regexp.MustCompile(regexp.QuoteMeta(query))
+	}
+	var matches []string
+	errLimit := errors.New("limit reached")
+	err = filepath.WalkDir(w.root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info, err := d.Info(); err != nil || !info.Mode().IsRegular() || info.Size() > maxSearchFileSize {
+			return nil
+		}
+		data, err := os.ReadFile(path)
+		if err != nil || isBinary(data) {
+			return nil
+		}
+		for i, line := range strings.Split(string(data), "\n") {
+			if pattern.MatchString(line) {
+				matches = append(matches, fmt.Sprintf("%s:%d: %s", w.relative(path), i+1, strings.TrimSpace(line)))
+				if len(matches) == maxSearchMatches {
+					return errLimit
+				}
+			}
+		}
+		return nil
+	})
+	if len(matches) == 0 {
+		return "No matches."
+	}
+	out := strings.Join(matches, "\n")
+	if errors.Is(err, errLimit) {
+		out += fmt.Sprintf("\n(only the first %d matches are shown; narrow the query)", maxSearchMatches)
+	}
+	return truncateToolOutput(out)
+}
+
+func (w *agentWorkspace) runTests(args map[string]string) string {
+	if _, err := os.Stat(filepath.Join(w.root, "go.mod")); err != nil {
+		return "Error: only the tests of Go modules can run"
+	}
+	pkg := args["package"]
+	if pkg == "" {
+		pkg = "./..."
+	}
+	if strings.HasPrefix(pkg, "-") {
+		return "Error: package must be an import path or a relative pattern like ./export/..."
+	}
+	testArgs := []string{"test", "-count=1"}
+	if w.tests != nil && w.tests.Timeout != "" {
+		if _, err := time.ParseDuration(w.tests.Timeout); err == nil {
+			testArgs = append(testArgs, "-timeout="+w.tests.Timeout)
+		}
+	}
+	out, err := w.runner(w.root, "go", append(testArgs, pkg)...)
+	result := "PASS"
+	if err != nil {
+		result = "FAIL"
+	}
+	// The end of the output holds the failures and the summary.
+	if out = strings.TrimSpace(out); len(out) > maxToolOutput {
+		out = "…" + out[len(out)-maxToolOutput:]
+	}
+	return strings.TrimSpace(result + "\n" + out)
+}
+
+func (w *agentWorkspace) writeFile(args map[string]string) string {
+	file, err := w.resolve(args["path"])
+	if err != nil {
+		return "Error: " + err.Error()
+	}
+	if file == w.root {
+		return "Error: path must name a file"
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(file); err == nil {
+		if info.IsDir() {
+			return "Error: " + args["path"] + " is a directory"
+		}
+		mode = info.Mode().Perm()
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+		return "Error: " + err.Error()
+	}
+	if err := os.WriteFile(file, []byte(args["content"]), mode); err != nil {
+		return "Error: " + err.Error()
+	}
+	if rel := w.relative(file); !slices.Contains(w.written, rel) {
+		w.written = append(w.written, rel)
+	}
+	return fmt.Sprintf("Wrote %d bytes to %s.", len(args["content"]), w.relative(file))
+}
+
+// tools returns the tools the model explores and edits the checkout with.
+func (w *agentWorkspace) tools() []modelTool {
+	return []modelTool{
+		{
+			Name:        "list_dir",
+			Description: "Lists the files and directories in a directory of the repository. Directories end with a slash.",
+			Params:      []toolParam{{Name: "path", Description: "Directory relative to the repository root; \".\" is the root."}},
+			Run:         w.listDir,
+		},
+		{
+			Name:        "read_file",
+			Description: "Returns the content of a file of the repository, each line prefixed with its number.",
+			Params:      []toolParam{{Name: "path", Description: "File relative to the repository root."}},
+			Run:         w.readFile,
+		},
+		{
+			Name:        "search_code",
+			Description: fmt.Sprintf("Searches the files of the repository for lines matching a regular expression and returns up to %d of them as path:line: text.", maxSearchMatches),
+			Params:      []toolParam{{Name: "query", Description: "RE2 regular expression, or plain text."}},
+			Run:         w.searchCode,
+		},
+		{
+			Name:        "run_tests",
+			Description: "Runs the Go tests of a package with go test and returns PASS or FAIL followed by the output.",
+			Params:      []toolParam{{Name: "package", Description: "Package pattern, e.g. ./export or ./...; every package when omitted.", Optional: true}},
+			Run:         w.runTests,
+		},
+		{
+			Name:        "write_file",
+			Description: "Replaces the content of a file of the repository, creating it and its directories when missing.",
+			Params: []toolParam{
+				{Name: "path", Description: "File relative to the repository root."},
+				{Name: "content", Description: "The complete new content of the file."},
+			},
+			Run: w.writeFile,
+		},
+	}
+}
+
+// truncateToolOutput keeps tool results within maxToolOutput.
+func truncateToolOutput(out string) string {
+	if len(out) <= maxToolOutput {
+		return out
+	}
+	return out[:maxToolOutput] + "\n… (truncated)"
+}
+
+// runAgentEdit has the model implement issue in the checkout at dir, listing,
+// reading and searching it and running its tests through tools before and
+// while it edits, following plan when one was approved and fixing failures,
+// the errors of its previous edit, when given.
+func (b *Bot) runAgentEdit(ctx context.Context, generator ToolGenerator, dir string, issue *github.Issue, files []string, plan, failures string, tests *TestsConfig) error {
+	ws := &agentWorkspace{root: dir, tests: tests, runner: b.runner}
+	var approved string
+	if plan != "" {
+		approved = fmt.Sprintf("\n\nFollow this approved implementation plan:\n%s", plan)
+	}
+	approved += fixRequest(failures)
+	prompt := fmt.Sprintf(`Implement the feature described in the following GitHub issue in the repository you can reach through your tools.
+
+**Issue Title:** %s
+
+**Issue Body:**
+%s%s
+
+The issue names these files to change: %s.
+
+Explore before you edit: list directories, read the files you change and the code they call, and search for the functions and types involved. Write each changed or new file in full with write_file. When the repository has tests, run those of the packages you changed and fix what fails. When you are done, reply with a short summary of your changes.`, issue.GetTitle(), issue.GetBody(), approved, strings.Join(files, ", "))
+
+	summary, err := generator.GenerateWithTools(withSystemPrompt(ctx, promptAgentEdit), prompt, ws.tools(), maxAgentToolCalls)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrEditFailed, err)
+	}
+	if len(ws.written) == 0 {
+		return fmt.Errorf("%w: the model didn't write any file: %s", ErrEditFailed, strings.TrimSpace(summary))
+	}
+	log.Printf("The model edited %s for issue #%d: %s", strings.Join(ws.written, ", "), issue.GetNumber(), strings.TrimSpace(summary))
+	return nil
+}
+
+// GenerateWithTools implements ToolGenerator with Gemini function calling:
+// the calls of each model turn run in order and their results go back to the
+// model until it answers with text. The conversation goes through the
+// generative language client rather than the SDK's chat sessions, which only
+// stream their responses.
+func (g *geminiGenerator) GenerateWithTools(ctx context.Context, prompt string, tools []modelTool, maxCalls int) (string, error) {
+	prompt, err := guardPrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	client, err := gl.NewGenerativeRESTClient(ctx, g.clientOptions(modelAPIKey(ctx))...)
+	if err != nil {
+		return "", modelError(err)
+	}
+	defer client.Close()
+
+	name := g.modelName()
+	if model := contextModel(ctx); model != "" {
+		name = model
+	}
+	if !strings.Contains(name, "/") {
+		name = "models/" + name
+	}
+	byName := make(map[string]modelTool, len(tools))
+	declarations := make([]*pb.FunctionDeclaration, 0, len(tools))
+	for _, tool := range tools {
+		byName[tool.Name] = tool
+		params := &pb.Schema{Type: pb.Type_OBJECT, Properties: make(map[string]*pb.Schema)}
+		for _, p := range tool.Params {
+			params.Properties[p.Name] = &pb.Schema{Type: pb.Type_STRING, Description: p.Description}
+			if !p.Optional {
+				params.Required = append(params.Required, p.Name)
+			}
+		}
+		declarations = append(declarations, &pb.FunctionDeclaration{Name: tool.Name, Description: tool.Description, Parameters: params})
+	}
+	req := &pb.GenerateContentRequest{
+		Model:    name,
+		Contents: []*pb.Content{{Role: "user", Parts: []*pb.Part{{Data: &pb.Part_Text{Text: prompt}}}}},
+		Tools:    []*pb.Tool{{FunctionDeclarations: declarations}},
+	}
+	if system := systemPrompt(ctx); system != "" {
+		req.SystemInstruction = &pb.Content{Parts: []*pb.Part{{Data: &pb.Part_Text{Text: system}}}}
+	}
+
+	for calls := 0; ; {
+		resp, err := client.GenerateContent(ctx, req)
+		if err != nil {
+			return "", modelError(err)
+		}
+		if usage := resp.GetUsageMetadata(); usage != nil {
+			meterTokens(ctx, int64(usage.GetPromptTokenCount()), int64(usage.GetCandidatesTokenCount()))
+		}
+		if reason := resp.GetPromptFeedback().GetBlockReason(); reason != pb.GenerateContentResponse_PromptFeedback_BLOCK_REASON_UNSPECIFIED {
+			return "", fmt.Errorf("%w: the prompt was blocked (%s)", ErrModelBlocked, reason)
+		}
+		if len(resp.GetCandidates()) == 0 {
+			return "", modelError(errors.New("the model returned no candidates"))
+		}
+		candidate := resp.GetCandidates()[0]
+		if candidate.GetFinishReason() == pb.Candidate_SAFETY {
+			return "", fmt.Errorf("%w: the response was stopped by the safety filters", ErrModelBlocked)
+		}
+
+		var text strings.Builder
+		var results []*pb.Part
+		for _, part := range candidate.GetContent().GetParts() {
+			call := part.GetFunctionCall()
+			if call == nil {
+				text.WriteString(part.GetText())
+				continue
+			}
+			if calls++; calls > maxCalls {
+				return "", fmt.Errorf("the model made more than %d tool calls", maxCalls)
+			}
+			result := fmt.Sprintf("Error: there is no tool named %q.", call.GetName())
+			if tool, ok := byName[call.GetName()]; ok {
+				args := make(map[string]string)
+				for name, value := range call.GetArgs().AsMap() {
+					if s, ok := value.(string); ok {
+						args[name] = s
+					} else {
+						args[name] = fmt.Sprint(value)
+					}
+				}
+				result = tool.Run(args)
+			}
+			response, err := structpb.NewStruct(map[string]any{"result": strings.ToValidUTF8(result, "�")})
+			if err != nil {
+				return "", err
+			}
+			results = append(results, &pb.Part{Data: &pb.Part_FunctionResponse{FunctionResponse: &pb.FunctionResponse{Name: call.GetName(), Response: response}}})
+		}
+		if len(results) == 0 {
+			return text.String(), nil
+		}
+		req.Contents = append(req.Contents, candidate.GetContent(), &pb.Content{Role: "user", Parts: results})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImplementFeatureWithAgentEditor(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  editor: agent\ntests:\n  timeout: 2m\n")
+	goModuleRunner(t, env)
+	var written string
+	env.runner.effects["go test -count=1 -timeout=2m ./export"] = func(dir string) {
+		data, _ := os.ReadFile(filepath.Join(dir, "export", "csv.go"))
+		written = string(data)
+	}
+	env.gemini.callTools("in the repository you can reach through your tools",
+		geminiCall{"list_dir", map[string]any{"path": "."}},
+		geminiCall{"read_file", map[string]any{"path": "go.mod"}},
+		geminiCall{"search_code", map[string]any{"query": "^module "}},
+		geminiCall{"write_file", map[string]any{"path": "export/csv.go", "content": "package export\n"}},
+		geminiCall{"run_tests", map[string]any{"package": "./export"}},
+		geminiCall{"read_file", map[string]any{"path": ".git/config"}},
+	)
+	env.gemini.on("in the repository you can reach through your tools", "Added the CSV exporter.")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	for _, line := range env.runner.executed() {
+		if strings.HasPrefix(line, "gemini ") {
+			t.Errorf("the Gemini CLI shouldn't run with the agent editor: %q", line)
+		}
+	}
+	results := env.gemini.receivedToolResults()
+	want := []string{
+		"list_dir: go.mod",
+		"read_file: 1: module ex.com/m\n",
+		"search_code: go.mod:1: module ex.com/m",
+		"write_file: Wrote 15 bytes to export/csv.go.",
+		"run_tests: PASS",
+		"read_file: Error: the .git directory is off limits",
+	}
+	if !slices.Equal(results, want) {
+		t.Errorf("tool results = %q, want %q", results, want)
+	}
+	if written != "package export\n" {
+		t.Errorf("the tests should see the written file, got %q", written)
+	}
+	if !slices.Contains(env.gemini.receivedSystemPrompts(), systemPrompts[promptAgentEdit]) {
+		t.Error("the edit should use the agent_edit system prompt")
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 1 {
+		t.Fatalf("expected a pull request, got %d", len(pulls))
+	}
+}
+
+func TestAgentEditWithoutWrites(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  editor: agent\n")
+	env.gemini.on("in the repository you can reach through your tools", "I need more details.")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("no pull request should open without changes, got %d", len(pulls))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) == 0 || !strings.Contains(comments[len(comments)-1].GetBody(), "The model failed to modify the files") {
+		t.Errorf("the failure should be reported: %v", comments)
+	}
+}
+
+func TestAgentWorkspaceStaysInCheckout(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("token"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks unsupported: %v", err)
+	}
+	ws := &agentWorkspace{root: root}
+
+	if got := ws.readFile(map[string]string{"path": "link/secret"}); !strings.Contains(got, "outside the repository") {
+		t.Errorf("reading through a symlink out of the checkout returned %q", got)
+	}
+	if got := ws.writeFile(map[string]string{"path": "link/new", "content": "x"}); !strings.Contains(got, "outside the repository") {
+		t.Errorf("writing through a symlink out of the checkout returned %q", got)
+	}
+	if got := ws.writeFile(map[string]string{"path": "../../escape.go", "content": "package x\n"}); got != "Wrote 10 bytes to escape.go." {
+		t.Errorf("relative paths should stay in the checkout, got %q", got)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.go")); err != nil {
+		t.Errorf("the file should be written in the checkout: %v", err)
+	}
+	if got := ws.listDir(map[string]string{"path": ".git"}); !strings.Contains(got, "off limits") {
+		t.Errorf("listing .git returned %q", got)
+	}
+}
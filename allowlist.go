@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// bucketAllowlist holds the allowlist set through the API under
+	// allowlistKey. It takes precedence over ALLOWLIST.
+	bucketAllowlist = "allowlist"
+	allowlistKey    = "current"
+)
+
+// ignoredWebhooksTotal counts the webhooks dropped because their repository
+// isn't allowlisted.
+var ignoredWebhooksTotal = newCounterVec("agent_prd_ignored_webhooks_total", "Webhooks ignored because the repository or installation isn't allowlisted, by event type.", "event")
+
+// Allowlist lists the installations and repositories the bot acts on, so an
+// instance anyone can install only works for approved ones. A repository is
+// allowed when it matches Repos or was installed by one of Installations.
+type Allowlist struct {
+	Repos         []string `json:"repos,omitempty"` // "owner/repo", or "owner/*" for every repository of owner
+	Installations []int64  `json:"installations,omitempty"`
+}
+
+// allows reports whether the bot may act on the repository, as installed by
+// installationID (zero when unknown). repoFullName is "" for events about
+// the installation itself.
+func (a *Allowlist) allows(installationID int64, repoFullName string) bool {
+	if installationID != 0 && slices.Contains(a.Installations, installationID) {
+		return true
+	}
+	for _, pattern := range a.Repos {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(repoFullName)); ok && repoFullName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// validate reports malformed entries.
+func (a *Allowlist) validate() error {
+	for _, pattern := range a.Repos {
+		owner, _, ok := strings.Cut(pattern, "/")
+		if !ok || owner == "" {
+			return fmt.Errorf("invalid repository %q: want owner/repo or owner/*", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// parseAllowlist parses the ALLOWLIST environment variable: comma-separated
+// "owner/repo", "owner/*" and installation IDs, for example
+// "acme/*,other/tools,12345". An empty value returns nil, allowing every
+// repository.
+func parseAllowlist(value string) (*Allowlist, error) {
+	var list Allowlist
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+		case strings.Contains(entry, "/"):
+			list.Repos = append(list.Repos, entry)
+		default:
+			id, err := strconv.ParseInt(entry, 10, 64)
+			if err != nil || id <= 0 {
+				return nil, fmt.Errorf("invalid entry %q: want a repository or an installation ID", entry)
+			}
+			list.Installations = append(list.Installations, id)
+		}
+	}
+	if len(list.Repos) == 0 && len(list.Installations) == 0 {
+		return nil, nil
+	}
+	if err := list.validate(); err != nil {
+		return nil, err
+	}
+	return &list, nil
+}
+
+// currentAllowlist returns the allowlist in effect and where it comes from,
+// or nil when every repository is allowed. When the stored allowlist can't
+// be read, it falls back to ALLOWLIST, or else allows nothing, since an
+// unreadable allowlist shouldn't open the bot to every repository.
+func (b *Bot) currentAllowlist() (*Allowlist, string) {
+	var list Allowlist
+	ok, err := b.store.Get(bucketAllowlist, allowlistKey, &list)
+	switch {
+	case err != nil && b.allowlist != nil:
+		log.Printf("Error loading the allowlist, using ALLOWLIST: %v", err)
+		return b.allowlist, "env"
+	case err != nil:
+		log.Printf("Error loading the allowlist, allowing no repository: %v", err)
+		return &Allowlist{}, "error"
+	case ok:
+		return &list, "store"
+	case b.allowlist != nil:
+		return b.allowlist, "env"
+	}
+	return nil, "none"
+}
+
+// allowed reports whether the bot may act on the repository.
+func (b *Bot) allowed(installationID int64, repoFullName string) bool {
+	list, _ := b.currentAllowlist()
+	return list == nil || list.allows(installationID, repoFullName)
+}
+
+// webhookAllowed reports whether the bot may act on the webhook payload, by
+// the installation and repository it names.
+func (b *Bot) webhookAllowed(payload []byte) bool {
+	var target struct {
+		Installation struct {
+			ID int64 `json:"id"`
+		} `json:"installation"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	// Payloads that don't parse are rejected later, when they are handled.
+	if err := json.Unmarshal(payload, &target); err != nil {
+		return true
+	}
+	return b.allowed(target.Installation.ID, target.Repository.FullName)
+}
+
+// allowlistStatus is the allowlist as reported by the allowlist API.
+type allowlistStatus struct {
+	Source    string     `json:"source"` // "none", "env", "store" or "error"
+	Allowlist *Allowlist `json:"allowlist,omitempty"`
+}
+
+// handleAllowlist shows, stores or deletes the allowlist: GET /allowlist,
+// PUT /allowlist with an Allowlist body, overriding ALLOWLIST, and
+// DELETE /allowlist to fall back to ALLOWLIST.
+func (b *Bot) handleAllowlist(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		list, source := b.currentAllowlist()
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(allowlistStatus{Source: source, Allowlist: list}); err != nil {
+			log.Printf("Error encoding the allowlist: %v", err)
+		}
+	case http.MethodDelete:
+		if err := b.store.Delete(bucketAllowlist, allowlistKey); err != nil {
+			log.Printf("Error deleting the allowlist: %v", err)
+			http.Error(w, "Error deleting the allowlist", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Allowlist reset.")
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		var list Allowlist
+		if err := json.NewDecoder(r.Body).Decode(&list); err != nil {
+			http.Error(w, "Invalid allowlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := list.validate(); err != nil {
+			http.Error(w, "Invalid allowlist: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := b.store.Put(bucketAllowlist, allowlistKey, &list); err != nil {
+			log.Printf("Error saving the allowlist: %v", err)
+			http.Error(w, "Error saving the allowlist", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Allowlist updated: %+v", list)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
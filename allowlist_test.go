@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func allowlistRequest(t *testing.T, bot *Bot, method, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /allowlist", bot.handleAllowlist)
+	mux.HandleFunc("PUT /allowlist", bot.handleAllowlist)
+	mux.HandleFunc("DELETE /allowlist", bot.handleAllowlist)
+	req := httptest.NewRequest(method, "/allowlist", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestParseAllowlist(t *testing.T) {
+	list, err := parseAllowlist("acme/*, other/tools,12345")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		installation int64
+		repo         string
+		want         bool
+	}{
+		{7, "acme/widgets", true},
+		{7, "Other/Tools", true},
+		{12345, "someone/else", true},
+		{12345, "", true},
+		{7, "someone/else", false},
+		{7, "", false},
+	} {
+		if got := list.allows(tt.installation, tt.repo); got != tt.want {
+			t.Errorf("allows(%d, %q) = %v, want %v", tt.installation, tt.repo, got, tt.want)
+		}
+	}
+
+	if list, err := parseAllowlist(" "); err != nil || list != nil {
+		t.Errorf("an empty ALLOWLIST should allow everything, got %+v, %v", list, err)
+	}
+	for _, bad := range []string{"acme", "/widgets", "acme/[", "-3"} {
+		if _, err := parseAllowlist(bad); err == nil {
+			t.Errorf("parseAllowlist(%q) should fail", bad)
+		}
+	}
+}
+
+func TestWebhookFromUnlistedRepositoryIsIgnored(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.allowlist = &Allowlist{Repos: []string{"acme/other"}}
+	before := ignoredWebhooksTotal.Value("issue_comment")
+
+	if rec := env.comment(t, "@prd-bot need_prd"); rec.Code != http.StatusOK {
+		t.Fatalf("ignored webhooks should be acknowledged, got %d", rec.Code)
+	}
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("acme/widgets isn't allowlisted, but the model was called %d times", len(prompts))
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Errorf("acme/widgets isn't allowlisted, but the bot commented: %v", comments)
+	}
+	if got := ignoredWebhooksTotal.Value("issue_comment") - before; got != 1 {
+		t.Errorf("ignored webhooks counted %v times, want 1", got)
+	}
+	if docs, _ := env.bot.store.List(bucketWebhooks); len(docs) != 0 {
+		t.Errorf("ignored webhooks shouldn't be stored, found %d", len(docs))
+	}
+}
+
+// allowlistErrorStore is a store whose allowlist can't be read.
+type allowlistErrorStore struct {
+	Store
+}
+
+func (s allowlistErrorStore) Get(bucket, key string, v any) (bool, error) {
+	if bucket == bucketAllowlist {
+		return false, errors.New("disk unavailable")
+	}
+	return s.Store.Get(bucket, key, v)
+}
+
+func TestUnreadableAllowlistFailsClosed(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.store = allowlistErrorStore{env.bot.store}
+	before := ignoredWebhooksTotal.Value("issue_comment")
+
+	env.comment(t, "@prd-bot need_prd")
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("the allowlist can't be read, but the model was called %d times", len(prompts))
+	}
+	if got := ignoredWebhooksTotal.Value("issue_comment") - before; got != 1 {
+		t.Errorf("ignored webhooks counted %v times, want 1", got)
+	}
+
+	env.bot.allowlist = &Allowlist{Installations: []int64{7}}
+	if !env.bot.allowed(7, "acme/widgets") {
+		t.Error("ALLOWLIST should apply when the stored allowlist can't be read")
+	}
+	if env.bot.allowed(8, "acme/other") {
+		t.Error("repositories missing from ALLOWLIST should stay denied")
+	}
+}
+
+func TestAllowlistAPIOverridesEnvironment(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	env.bot.allowlist = &Allowlist{Repos: []string{"acme/other"}}
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	if rec := allowlistRequest(t, env.bot, http.MethodPut, `{"installations":[7]}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT /allowlist = %d: %s", rec.Code, rec.Body.String())
+	}
+	if rec := allowlistRequest(t, env.bot, http.MethodGet, ""); !strings.Contains(rec.Body.String(), `"source":"store"`) {
+		t.Errorf("GET /allowlist = %s", rec.Body.String())
+	}
+	env.comment(t, "@prd-bot need_prd")
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) == 0 {
+		t.Error("installation 7 is allowlisted through the API, but the command didn't run")
+	}
+
+	allowlistRequest(t, env.bot, http.MethodDelete, "")
+	if env.bot.allowed(7, "acme/widgets") {
+		t.Error("deleting the stored allowlist should restore ALLOWLIST")
+	}
+	if rec := allowlistRequest(t, env.bot, http.MethodPut, `{"repos":["widgets"]}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("an invalid allowlist returned %d", rec.Code)
+	}
+}
+
+func TestBackgroundWorkSkipsUnlistedRepositories(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.allowlist = &Allowlist{Repos: []string{"acme/other"}}
+	client, _ := env.github.Client(7)
+	repo := &github.Repository{Name: github.String("widgets"), FullName: github.String("acme/widgets"), Owner: &github.User{Login: github.String("acme")}}
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+
+	if err := env.bot.pollRepository(context.Background(), pollTarget{Owner: "acme", Repo: "widgets", InstallationID: 7}); err != nil {
+		t.Fatalf("pollRepository: %v", err)
+	}
+	if ok, _ := env.bot.store.Get(bucketPoll, "acme/widgets", &pollCursor{}); ok {
+		t.Error("acme/widgets isn't allowlisted, but it was polled")
+	}
+	before := rejectedCommandsTotal.Value("allowlist")
+	if env.bot.runCommandHandler(client, env.bot.commands[CommandGeneratePRD], CommandGeneratePRD, nil, issue, repo, 7, &github.User{Login: github.String("alice")}) {
+		t.Error("a command on a repository that isn't allowlisted shouldn't run")
+	}
+	if got := rejectedCommandsTotal.Value("allowlist") - before; got != 1 {
+		t.Errorf("rejected commands counted %v times, want 1", got)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Errorf("acme/widgets isn't allowlisted, but the bot commented: %v", comments)
+	}
+	env.bot.store.Put(bucketInstallations, "acme/widgets", int64(7))
+	if env.bot.installationClient("acme", "widgets") != nil {
+		t.Error("reminders and digests shouldn't get a client for a repository that isn't allowlisted")
+	}
+}
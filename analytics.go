@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// AnalyticsEventsIdentifier marks comments produced by the
+	// need_analytics_events command.
+	AnalyticsEventsIdentifier = "### Analytics Events"
+
+	ArtifactAnalyticsEvents = "analytics_events"
+
+	// analyticsSchemaLinkPrefix starts the line linking the schema from the PRD.
+	analyticsSchemaLinkPrefix = "**Analytics Event Schema:**"
+)
+
+// analyticsSchemaPath is where the event schema of an issue is committed.
+func analyticsSchemaPath(issueNum int) string {
+	return fmt.Sprintf("schemas/analytics/issue-%d.schema.json", issueNum)
+}
+
+// analyticsEvent is an event the model proposes to track a success metric.
+type analyticsEvent struct {
+	Name        string              `json:"name"`
+	Description string              `json:"description"`
+	Metric      string              `json:"metric"` // the success metric it measures
+	Properties  []analyticsProperty `json:"properties"`
+}
+
+type analyticsProperty struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type"`
+	Description string   `json:"description"`
+	Required    bool     `json:"required"`
+	Enum        []string `json:"enum,omitempty"`
+}
+
+var (
+	snakeCase           = regexp.MustCompile(`^[a-z][a-z0-9]*(_[a-z0-9]+)*$`)
+	analyticsPropTypes  = []string{"string", "integer", "number", "boolean"}
+	reservedEventFields = []string{"event", "timestamp"}
+)
+
+// validateAnalyticsEvents checks that events can be turned into a schema:
+// snake_case unique names, supported property types and enums only on
+// strings.
+func validateAnalyticsEvents(events []analyticsEvent) error {
+	if len(events) == 0 {
+		return fmt.Errorf("%w: no events", ErrModelInvalid)
+	}
+	seen := make(map[string]bool)
+	for _, event := range events {
+		if !snakeCase.MatchString(event.Name) {
+			return fmt.Errorf("%w: event name %q is not snake_case", ErrModelInvalid, event.Name)
+		}
+		if seen[event.Name] {
+			return fmt.Errorf("%w: duplicate event %q", ErrModelInvalid, event.Name)
+		}
+		seen[event.Name] = true
+		props := make(map[string]bool)
+		for _, prop := range event.Properties {
+			switch {
+			case !snakeCase.MatchString(prop.Name):
+				return fmt.Errorf("%w: property %q of %s is not snake_case", ErrModelInvalid, prop.Name, event.Name)
+			case props[prop.Name] || slices.Contains(reservedEventFields, prop.Name):
+				return fmt.Errorf("%w: property %q of %s is duplicated or reserved", ErrModelInvalid, prop.Name, event.Name)
+			case !slices.Contains(analyticsPropTypes, prop.Type):
+				return fmt.Errorf("%w: property %s.%s has unsupported type %q", ErrModelInvalid, event.Name, prop.Name, prop.Type)
+			case len(prop.Enum) > 0 && prop.Type != "string":
+				return fmt.Errorf("%w: property %s.%s has an enum but is not a string", ErrModelInvalid, event.Name, prop.Name)
+			}
+			props[prop.Name] = true
+		}
+	}
+	return nil
+}
+
+// analyticsJSONSchema renders events as a JSON Schema (draft 2020-12) that
+// accepts any one of the events. Every event carries its name in `event` and
+// an RFC 3339 `timestamp`.
+func analyticsJSONSchema(repo *github.Repository, issue *github.Issue, events []analyticsEvent) ([]byte, error) {
+	defs := make(map[string]any)
+	var oneOf []any
+	for _, event := range events {
+		properties := map[string]any{
+			"event":     map[string]any{"const": event.Name},
+			"timestamp": map[string]any{"type": "string", "format": "date-time"},
+		}
+		required := []string{"event", "timestamp"}
+		for _, prop := range event.Properties {
+			p := map[string]any{"type": prop.Type, "description": prop.Description}
+			if len(prop.Enum) > 0 {
+				p["enum"] = prop.Enum
+			}
+			properties[prop.Name] = p
+			if prop.Required {
+				required = append(required, prop.Name)
+			}
+		}
+		def := map[string]any{
+			"type":                 "object",
+			"description":          event.Description,
+			"properties":           properties,
+			"required":             required,
+			"additionalProperties": false,
+		}
+		if event.Metric != "" {
+			def["x-success-metric"] = event.Metric
+		}
+		defs[event.Name] = def
+		oneOf = append(oneOf, map[string]any{"$ref": "#/$defs/" + event.Name})
+	}
+	schema := map[string]any{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"$id":         fmt.Sprintf("%s/blob/%s/%s", repo.GetHTMLURL(), repo.GetDefaultBranch(), analyticsSchemaPath(issue.GetNumber())),
+		"title":       fmt.Sprintf("Analytics events for #%d: %s", issue.GetNumber(), issue.GetTitle()),
+		"description": "Events tracking the success metrics of the feature's PRD.",
+		"oneOf":       oneOf,
+		"$defs":       defs,
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// processAnalyticsEvents derives typed analytics events from the PRD's
+// Success Metrics, opens a pull request adding their JSON Schema under
+// schemas/analytics and links the schema from the PRD.
+func (b *Bot) processAnalyticsEvents(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, _ []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandAnalyticsEvents, issueNum, repoOwner, repoName)
+	fail := func(reason string, err error) {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "design the analytics events", reason, err)
+	}
+
+	prdComment, err := b.findPRDComment(ctx, client, repoOwner, repoName, issueNum)
+	if err != nil || prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting analytics events.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to derive analytics events from. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	doc, ok := parsePRDDocument(prdComment.GetBody())
+	metricsSection, _ := findPRDSection("success_metrics")
+	metrics, found := "", false
+	if ok {
+		metrics, found = prdSectionContent(doc.English, metricsSection, false)
+	}
+	if !found || metrics == "" {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't find a **Success Metrics** section in the PRD. Add one, for example with `regen_section success_metrics`, and try again.")
+		return
+	}
+
+	events, err := generateAnalyticsEvents(ctx, b.llm, doc.English, metrics)
+	if err != nil {
+		fail("Could not generate the analytics events", err)
+		return
+	}
+	schema, err := analyticsJSONSchema(repo, issue, events)
+	if err != nil {
+		fail("Could not build the event schema", err)
+		return
+	}
+
+	path := analyticsSchemaPath(issueNum)
+	pr, err := openFilePullRequest(ctx, client, repo, filePullRequest{
+		Branch:  fmt.Sprintf("analytics/issue-%d-%d", issueNum, time.Now().Unix()),
+		Path:    path,
+		Content: string(schema),
+		Message: fmt.Sprintf("feat: Add analytics event schema for #%d", issueNum),
+		Title:   fmt.Sprintf("Add analytics event schema for #%d", issueNum),
+		Body:    fmt.Sprintf("This PR adds the analytics events measuring the success metrics of #%d as a JSON Schema. It was automatically generated by @%s.\n\n%s", issueNum, b.appName, formatAnalyticsEvents(events)),
+	})
+	if err != nil {
+		fail("Could not open the schema pull request", err)
+		return
+	}
+
+	// Link the schema from the PRD, replacing an earlier link.
+	link := fmt.Sprintf("%s [`%s`](%s) (proposed in #%d)", analyticsSchemaLinkPrefix, path, pr.GetHTMLURL(), pr.GetNumber())
+	lines := slices.DeleteFunc(strings.Split(strings.TrimRight(doc.English, "\n"), "\n"), func(l string) bool {
+		return strings.HasPrefix(l, analyticsSchemaLinkPrefix)
+	})
+	doc.English = strings.TrimRight(strings.Join(lines, "\n"), "\n") + "\n\n" + link + "\n"
+	doc.revise(fmt.Sprintf("Linked the analytics event schema proposed in #%d.", pr.GetNumber()))
+	prdBody := doc.String()
+	if edited, _, err := client.Issues.EditComment(ctx, repoOwner, repoName, prdComment.GetID(), &github.IssueComment{Body: github.String(b.stampArtifact(ctx, ArtifactPRD, prdBody))}); err != nil {
+		log.Printf("Error linking the analytics schema from the PRD of issue #%d: %v", issueNum, err)
+	} else {
+		b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, prdBody, edited)
+	}
+
+	body := fmt.Sprintf("%s\n\nI've opened a Pull Request adding the event schema to `%s`: %s\n\n%s", AnalyticsEventsIdentifier, path, pr.GetHTMLURL(), formatAnalyticsEvents(events))
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactAnalyticsEvents, body)
+	b.saveArtifact(ArtifactAnalyticsEvents, repoOwner, repoName, issue, body, comment)
+}
+
+func generateAnalyticsEvents(ctx context.Context, llm Generator, prd, metrics string) ([]analyticsEvent, error) {
+	prompt := fmt.Sprintf(
+		"Design the analytics events needed to measure the following success metrics of a Product Requirements Document (PRD).\n\n"+
+			"Respond with JSON only, in this format:\n"+
+			`{"events": [{"name": "report_exported", "description": "A user exported a report.", "metric": "the success metric it measures", "properties": [{"name": "format", "type": "string", "description": "Export format.", "required": true, "enum": ["csv", "pdf"]}]}]}`+"\n\n"+
+			"Use snake_case names. Property types must be one of string, integer, number or boolean; `enum` is only allowed on strings. Do not define `event` or `timestamp` properties, they are added to every event. Never include personal data such as names or email addresses.\n\n"+
+			"**Success Metrics:**\n%s\n\n"+
+			"**Full PRD:**\n%s",
+		metrics, prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, CommandAnalyticsEvents), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate analytics events: %w", err)
+	}
+	var result struct {
+		Events []analyticsEvent `json:"events"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	if err := validateAnalyticsEvents(result.Events); err != nil {
+		return nil, err
+	}
+	return result.Events, nil
+}
+
+// formatAnalyticsEvents renders events as a Markdown table.
+func formatAnalyticsEvents(events []analyticsEvent) string {
+	var b strings.Builder
+	b.WriteString("| Event | Measures | Properties |\n|---|---|---|\n")
+	for _, event := range events {
+		var props []string
+		for _, p := range event.Properties {
+			prop := fmt.Sprintf("`%s` (%s", p.Name, p.Type)
+			if p.Required {
+				prop += ", required"
+			}
+			props = append(props, prop+")")
+		}
+		metric := strings.ReplaceAll(strings.ReplaceAll(event.Metric, "|", "\\|"), "\n", " ")
+		fmt.Fprintf(&b, "| `%s` | %s | %s |\n", event.Name, metric, strings.Join(props, ", "))
+	}
+	return b.String()
+}
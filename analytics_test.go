@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const analyticsResponse = "```json\n" + `{"events": [
+  {"name": "report_exported", "description": "A user exported a report.", "metric": "30% of weekly active analysts use CSV export", "properties": [
+    {"name": "format", "type": "string", "description": "Export format.", "required": true, "enum": ["csv"]},
+    {"name": "row_count", "type": "integer", "description": "Rows exported."}
+  ]}
+]}` + "\n```"
+
+func TestValidateAnalyticsEvents(t *testing.T) {
+	valid := analyticsEvent{Name: "report_exported", Properties: []analyticsProperty{{Name: "format", Type: "string", Enum: []string{"csv"}}}}
+	if err := validateAnalyticsEvents([]analyticsEvent{valid}); err != nil {
+		t.Errorf("valid events rejected: %v", err)
+	}
+	for name, events := range map[string][]analyticsEvent{
+		"empty":          nil,
+		"camel case":     {{Name: "reportExported"}},
+		"duplicate":      {valid, valid},
+		"reserved":       {{Name: "a", Properties: []analyticsProperty{{Name: "timestamp", Type: "string"}}}},
+		"bad type":       {{Name: "a", Properties: []analyticsProperty{{Name: "at", Type: "date"}}}},
+		"enum on number": {{Name: "a", Properties: []analyticsProperty{{Name: "n", Type: "number", Enum: []string{"1"}}}}},
+	} {
+		if err := validateAnalyticsEvents(events); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestAnalyticsEventsOpensSchemaPullRequest(t *testing.T) {
+	env := newTestEnv(t)
+	english := readFixture(t, "prd_en.md")
+	prd := env.github.addComment("acme", "widgets", 42, (&PRDDocument{English: english}).String())
+	env.gemini.on("Design the analytics events", analyticsResponse)
+
+	env.comment(t, "@prd-bot need_analytics_events")
+
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "**Success Metrics:**\n30% of weekly active analysts use CSV export.") {
+		t.Errorf("prompt should quote the success metrics:\n%s", prompt)
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || !strings.HasPrefix(pulls[0].GetHead().GetRef(), "analytics/issue-42-") {
+		t.Fatalf("expected an analytics pull request, got %+v", pulls)
+	}
+	content, ok := env.github.committed("acme", "widgets", pulls[0].GetHead().GetRef(), "schemas/analytics/issue-42.schema.json")
+	if !ok {
+		t.Fatal("the schema was not committed")
+	}
+	var schema struct {
+		Defs map[string]struct {
+			Required   []string                  `json:"required"`
+			Properties map[string]map[string]any `json:"properties"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal([]byte(content), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v\n%s", err, content)
+	}
+	event := schema.Defs["report_exported"]
+	if strings.Join(event.Required, ",") != "event,timestamp,format" || event.Properties["row_count"]["type"] != "integer" {
+		t.Errorf("unexpected event schema: %+v", event)
+	}
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if !strings.Contains(comments[0].GetBody(), analyticsSchemaLinkPrefix+" [`schemas/analytics/issue-42.schema.json`]("+pulls[0].GetHTMLURL()+")") || comments[0].GetID() != prd.GetID() {
+		t.Errorf("the PRD should link the schema:\n%s", comments[0].GetBody())
+	}
+	if body := comments[len(comments)-1].GetBody(); !strings.HasPrefix(body, AnalyticsEventsIdentifier) || !strings.Contains(body, "| `report_exported` |") {
+		t.Errorf("unexpected analytics comment:\n%s", body)
+	}
+}
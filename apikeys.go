@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandAPIKey manages the Google API key of the installation:
+	// `api_key set <key>`, `api_key remove` or `api_key status`.
+	CommandAPIKey = "api_key"
+
+	// bucketAPIKeys holds the installations' own Google API keys, sealed
+	// with STORE_ENCRYPTION_KEY and keyed by installation id.
+	bucketAPIKeys = "apikeys"
+)
+
+// installationAPIKey is the Google API key an installation supplied, so its
+// generations are billed to its own project rather than the operator's.
+type installationAPIKey struct {
+	Sealed    string    `json:"sealed"`
+	Hint      string    `json:"hint"` // last characters of the key, to tell keys apart
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func apiKeyLabel(installationID int64) string {
+	return bucketAPIKeys + "/" + strconv.FormatInt(installationID, 10)
+}
+
+func apiKeyHint(key string) string {
+	return "…" + key[max(0, len(key)-4):]
+}
+
+// setInstallationAPIKey encrypts and stores key for an installation.
+func (b *Bot) setInstallationAPIKey(installationID int64, key, updatedBy string) error {
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " \t\r\n") {
+		return errors.New("the API key must be a single word")
+	}
+	sealed, err := b.secrets.seal(key, apiKeyLabel(installationID))
+	if err != nil {
+		return err
+	}
+	record := installationAPIKey{Sealed: sealed, Hint: apiKeyHint(key), UpdatedBy: updatedBy, UpdatedAt: time.Now().UTC()}
+	return b.store.Put(bucketAPIKeys, strconv.FormatInt(installationID, 10), &record)
+}
+
+// loadInstallationAPIKey returns the stored key record of an installation,
+// or nil when it uses the operator's key.
+func (b *Bot) loadInstallationAPIKey(installationID int64) (*installationAPIKey, error) {
+	var record installationAPIKey
+	found, err := b.store.Get(bucketAPIKeys, strconv.FormatInt(installationID, 10), &record)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &record, nil
+}
+
+type modelKeyKey struct{}
+
+// withInstallation returns a context whose model requests use the
+// installation's own API key, when it supplied one. Requests fall back to
+// the operator's key when the stored key can't be read, and are then metered
+// against the installation's budget.
+func (b *Bot) withInstallation(ctx context.Context, installationID int64) context.Context {
+	operatorKey := withTokenMeter(ctx, func(inputTokens, outputTokens int64) {
+		b.recordSpend(installationID, inputTokens, outputTokens)
+	})
+	record, err := b.loadInstallationAPIKey(installationID)
+	if err != nil {
+		log.Printf("Error loading the API key of installation %d, using the default key: %v", installationID, err)
+		return operatorKey
+	}
+	if record == nil {
+		return operatorKey
+	}
+	key, err := b.secrets.open(record.Sealed, apiKeyLabel(installationID))
+	if err != nil {
+		log.Printf("Error decrypting the API key of installation %d, using the default key: %v", installationID, err)
+		return operatorKey
+	}
+	return context.WithValue(ctx, modelKeyKey{}, key)
+}
+
+// modelAPIKey returns the API key model requests made with ctx should use,
+// or "" for the operator's key.
+func modelAPIKey(ctx context.Context) string {
+	key, _ := ctx.Value(modelKeyKey{}).(string)
+	return key
+}
+
+// deleteSecretComment deletes a comment carrying an API key as soon as the
+// webhook arrives, before the command runs.
+func (b *Bot) deleteSecretComment(client *github.Client, repo *github.Repository, commentID int64) {
+	if _, err := client.Issues.DeleteComment(context.Background(), repo.GetOwner().GetLogin(), repo.GetName(), commentID); err != nil {
+		log.Printf("Error deleting comment %d carrying an API key in %s: %v", commentID, repo.GetFullName(), err)
+	}
+}
+
+// processAPIKey lets maintainers supply the installation's own Google API
+// key, remove it or check which key is used.
+func (b *Bot) processAPIKey(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	if !b.requireMaintainer(ctx, client, repo, issueNum, CommandAPIKey) {
+		return
+	}
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	sender := commandSender(ctx).GetLogin()
+	switch action {
+	case "set":
+		if len(args) < 2 {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Usage: `@%s %s set <Google API key>`.", b.appName, CommandAPIKey))
+			return
+		}
+		if err := b.setInstallationAPIKey(installationID, args[1], sender); errors.Is(err, errNoSecretBox) {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, "This bot can't store API keys: its operator hasn't configured an encryption key. Your comment was deleted and the key was not saved.")
+			return
+		} else if err != nil {
+			b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "save the API key", "Could not save the API key", err)
+			return
+		}
+		log.Printf("Installation %d API key set by %s.", installationID, sender)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've saved the Google API key ending in `%s`. Generations for every repository of this installation now use it. I deleted your comment, but GitHub may already have sent it in notification emails; rotate the key if that's a concern.", apiKeyHint(args[1])))
+	case "remove":
+		if err := b.store.Delete(bucketAPIKeys, strconv.FormatInt(installationID, 10)); err != nil {
+			b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "remove the API key", "Could not remove the API key", err)
+			return
+		}
+		log.Printf("Installation %d API key removed by %s.", installationID, sender)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I've removed this installation's Google API key. Generations use the bot's default key again.")
+	case "status":
+		record, err := b.loadInstallationAPIKey(installationID)
+		if err != nil {
+			b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "check the API key", "Could not load the API key", err)
+			return
+		}
+		msg := "This installation uses the bot's default API key."
+		if record != nil {
+			msg = fmt.Sprintf("This installation uses its own Google API key ending in `%s`, set by `%s` on %s.", record.Hint, record.UpdatedBy, record.UpdatedAt.Format(time.DateOnly))
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+	default:
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Unknown `%s` action `%s`: expected `set`, `remove` or `status`.", CommandAPIKey, action))
+	}
+}
+
+// apiKeyStatus describes an installation's API key without revealing it.
+type apiKeyStatus struct {
+	InstallationID int64      `json:"installation_id"`
+	Configured     bool       `json:"configured"`
+	Hint           string     `json:"hint,omitempty"`
+	UpdatedBy      string     `json:"updated_by,omitempty"`
+	UpdatedAt      *time.Time `json:"updated_at,omitempty"`
+}
+
+// handleInstallationAPIKey reports, sets or removes an installation's API key:
+// GET /installations/{id}/apikey, PUT with {"api_key": "..."}, DELETE
+func (b *Bot) handleInstallationAPIKey(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	installationID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || installationID <= 0 {
+		http.Error(w, "invalid installation id", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		var body struct {
+			APIKey string `json:"api_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := b.setInstallationAPIKey(installationID, body.APIKey, "api"); errors.Is(err, errNoSecretBox) {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		} else if err != nil {
+			http.Error(w, "Error saving API key: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Installation %d API key set through the API.", installationID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodDelete:
+		if err := b.store.Delete(bucketAPIKeys, strconv.FormatInt(installationID, 10)); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Installation %d API key removed through the API.", installationID)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	record, err := b.loadInstallationAPIKey(installationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := apiKeyStatus{InstallationID: installationID}
+	if record != nil {
+		status.Configured, status.Hint, status.UpdatedBy, status.UpdatedAt = true, record.Hint, record.UpdatedBy, &record.UpdatedAt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Printf("Error encoding API key status: %v", err)
+	}
+}
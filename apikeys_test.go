@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testEncryptionKey = "MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=" // 32 bytes
+
+func newSecretsEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := newTestEnv(t)
+	secrets, err := newSecretBox(testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.bot.secrets = secrets
+	return env
+}
+
+func TestAPIKeyCommandUsesInstallationKey(t *testing.T) {
+	env := newSecretsEnv(t)
+	env.github.setRole("alice", "maintain")
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+
+	env.comment(t, "@prd-bot api_key set AIza-tenant-1234")
+	env.github.mu.Lock()
+	deleted := slices.Clone(env.github.deletedComments)
+	env.github.mu.Unlock()
+	if !slices.Equal(deleted, []int64{1}) {
+		t.Errorf("the comment carrying the key should be deleted, got %v", deleted)
+	}
+	var stored installationAPIKey
+	if ok, _ := env.bot.store.Get(bucketAPIKeys, "7", &stored); !ok || strings.Contains(stored.Sealed, "AIza") || stored.Hint != "…1234" || stored.UpdatedBy != "alice" {
+		t.Fatalf("expected an encrypted key, got %+v", stored)
+	}
+
+	env.comment(t, "@prd-bot need_sub_task")
+	env.comment(t, "@prd-bot api_key status")
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "its own Google API key ending in `…1234`, set by `alice`") {
+		t.Errorf("unexpected status:\n%s", body)
+	}
+
+	env.comment(t, "@prd-bot api_key remove")
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+	if keys := env.gemini.receivedKeys(); !slices.Equal(keys, []string{"AIza-tenant-1234", ""}) {
+		t.Errorf("requests should use the installation's key until it is removed, got %q", keys)
+	}
+}
+
+func TestAPIKeyCommandRequiresMaintainer(t *testing.T) {
+	env := newSecretsEnv(t)
+
+	env.comment(t, "@prd-bot api_key set AIza-tenant-1234")
+
+	if ok, _ := env.bot.store.Get(bucketAPIKeys, "7", &installationAPIKey{}); ok {
+		t.Error("a non-maintainer should not set the key")
+	}
+	if len(env.github.deletedComments) != 1 {
+		t.Error("the key should be deleted even when the sender can't set it")
+	}
+}
+
+func TestAPIKeyCommandWithoutEncryptionKey(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.setRole("alice", "admin")
+
+	env.comment(t, "@prd-bot api_key set AIza-tenant-1234")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "hasn't configured an encryption key") {
+		t.Errorf("unexpected comments %v", comments)
+	}
+}
+
+func TestInstallationAPIKeyAPI(t *testing.T) {
+	env := newSecretsEnv(t)
+	env.bot.apiToken = "s3cret"
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /installations/{id}/apikey", env.bot.handleInstallationAPIKey)
+	mux.HandleFunc("PUT /installations/{id}/apikey", env.bot.handleInstallationAPIKey)
+	mux.HandleFunc("DELETE /installations/{id}/apikey", env.bot.handleInstallationAPIKey)
+	call := func(method, url, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := call(http.MethodPut, "/installations/x/apikey", `{"api_key": "k"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid id returned %d", rec.Code)
+	}
+	if rec := call(http.MethodPut, "/installations/9/apikey", `{"api_key": ""}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("empty key returned %d", rec.Code)
+	}
+	if rec := call(http.MethodPut, "/installations/9/apikey", `{"api_key": "AIza-api-5678"}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT returned %d %s", rec.Code, rec.Body.String())
+	}
+	rec := call(http.MethodGet, "/installations/9/apikey", "")
+	if body := rec.Body.String(); rec.Code != http.StatusOK || !strings.Contains(body, `"configured":true`) || !strings.Contains(body, `"hint":"…5678"`) || strings.Contains(body, "AIza") {
+		t.Errorf("GET returned %d %s", rec.Code, body)
+	}
+	if key := modelAPIKey(env.bot.withInstallation(context.Background(), 9)); key != "AIza-api-5678" {
+		t.Errorf("installation 9 should use its key, got %q", key)
+	}
+	if key := modelAPIKey(env.bot.withInstallation(context.Background(), 7)); key != "" {
+		t.Errorf("installation 7 should use the default key, got %q", key)
+	}
+
+	if rec := call(http.MethodDelete, "/installations/9/apikey", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("DELETE returned %d", rec.Code)
+	}
+	if rec := call(http.MethodGet, "/installations/9/apikey", ""); !strings.Contains(rec.Body.String(), `"configured":false`) {
+		t.Errorf("GET after DELETE returned %s", rec.Body.String())
+	}
+}
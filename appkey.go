@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"golang.org/x/oauth2/google"
+)
+
+// Prefixes of GITHUB_APP_PRIVATE_KEY that name a secret instead of holding
+// the key.
+const (
+	gcpSecretPrefix = "gcpsm://" // gcpsm://projects/P/secrets/S[/versions/V]
+	awsSecretPrefix = "awssm://" // awssm://<secret name or ARN>
+)
+
+const (
+	defaultAppKeyRefresh = 5 * time.Minute
+
+	gcpSecretManagerURL = "https://secretmanager.googleapis.com"
+	gcpPlatformScope    = "https://www.googleapis.com/auth/cloud-platform"
+)
+
+// SecretManager reads secrets from a secrets manager.
+type SecretManager interface {
+	// AccessSecret returns the current value of the secret named name.
+	AccessSecret(ctx context.Context, name string) ([]byte, error)
+}
+
+// appKey is where the private key of the GitHub App comes from: the
+// setting itself, a file, or a secrets manager. Keys in files and secrets
+// managers can be rotated while the bot runs.
+type appKey struct {
+	inline  string
+	path    string
+	secret  string
+	manager SecretManager
+}
+
+// newAppKey reads GITHUB_APP_PRIVATE_KEY, which holds the key as PEM or
+// base64-encoded PEM, the path of a file holding it, or a secret named
+// with gcpSecretPrefix or awsSecretPrefix.
+func newAppKey(ctx context.Context, cfg *StartupConfig) (*appKey, error) {
+	value := strings.TrimSpace(cfg.AppPrivateKey)
+	switch {
+	case strings.HasPrefix(value, gcpSecretPrefix):
+		name := strings.TrimPrefix(value, gcpSecretPrefix)
+		if !strings.Contains(name, "/versions/") {
+			name += "/versions/latest"
+		}
+		client, err := google.DefaultClient(ctx, gcpPlatformScope)
+		if err != nil {
+			return nil, fmt.Errorf("finding Google Cloud credentials for Secret Manager: %w", err)
+		}
+		return &appKey{secret: name, manager: &gcpSecretManager{client: client, endpoint: gcpSecretManagerURL}}, nil
+	case strings.HasPrefix(value, awsSecretPrefix):
+		name := strings.TrimPrefix(value, awsSecretPrefix)
+		manager, err := newAWSSecretManager(cfg, name)
+		if err != nil {
+			return nil, err
+		}
+		return &appKey{secret: name, manager: manager}, nil
+	case strings.Contains(value, "-----BEGIN"):
+		return &appKey{inline: value}, nil
+	}
+	if info, err := os.Stat(value); err == nil && info.Mode().IsRegular() {
+		return &appKey{path: value}, nil
+	}
+	return &appKey{inline: value}, nil
+}
+
+func (k *appKey) String() string {
+	switch {
+	case k.manager != nil:
+		return "secret " + k.secret
+	case k.path != "":
+		return k.path
+	}
+	return "GITHUB_APP_PRIVATE_KEY"
+}
+
+// rotates reports whether the key can change while the bot runs.
+func (k *appKey) rotates() bool {
+	return k.inline == ""
+}
+
+// load returns the current key as PEM.
+func (k *appKey) load(ctx context.Context) ([]byte, error) {
+	var data []byte
+	var err error
+	switch {
+	case k.manager != nil:
+		data, err = k.manager.AccessSecret(ctx, k.secret)
+	case k.path != "":
+		data, err = os.ReadFile(k.path)
+	default:
+		data = []byte(k.inline)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodePrivateKey(data)
+}
+
+// decodePrivateKey returns the PEM key in data, which holds PEM, PEM with
+// escaped newlines as environment variables often do, or base64-encoded
+// PEM.
+func decodePrivateKey(data []byte) ([]byte, error) {
+	data = bytes.TrimSpace(data)
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		decoded, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(data)), ""))
+		if err != nil {
+			return nil, errors.New("the private key is neither PEM nor base64-encoded PEM")
+		}
+		data = bytes.TrimSpace(decoded)
+	}
+	data = bytes.ReplaceAll(data, []byte(`\n`), []byte("\n"))
+	if _, err := jwt.ParseRSAPrivateKeyFromPEM(data); err != nil {
+		return nil, fmt.Errorf("could not parse the private key: %w", err)
+	}
+	return data, nil
+}
+
+// rotatingSigner signs the App's JWTs with its current private key, so the
+// key can be replaced without recreating the transports that use it.
+type rotatingSigner struct {
+	current atomic.Pointer[ghinstallation.RSASigner]
+}
+
+func (s *rotatingSigner) Sign(claims jwt.Claims) (string, error) {
+	return s.current.Load().Sign(claims)
+}
+
+// set makes the PEM key privateKey sign the next JWTs.
+func (s *rotatingSigner) set(privateKey []byte) error {
+	key, err := jwt.ParseRSAPrivateKeyFromPEM(privateKey)
+	if err != nil {
+		return fmt.Errorf("could not parse private key: %w", err)
+	}
+	s.current.Store(ghinstallation.NewRSASigner(jwt.SigningMethodRS256, key))
+	return nil
+}
+
+// watchAppKey reloads the App's private key on SIGHUP and every interval
+// until ctx is cancelled, switching clients to it when it was rotated.
+// current is the key in use.
+func watchAppKey(ctx context.Context, key *appKey, clients *appClientFactory, current []byte, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-ticker.C:
+		}
+		next, err := key.load(ctx)
+		if err != nil {
+			log.Printf("Keeping the current GitHub App private key, could not reload %s: %v", key, err)
+			continue
+		}
+		if bytes.Equal(next, current) {
+			continue
+		}
+		if err := clients.rotateKey(next); err != nil {
+			log.Printf("Keeping the current GitHub App private key: %v", err)
+			continue
+		}
+		current = next
+		log.Printf("Rotated the GitHub App private key from %s.", key)
+	}
+}
+
+// gcpSecretManager reads secrets from Google Cloud Secret Manager through
+// its REST API.
+type gcpSecretManager struct {
+	client   *http.Client // authorized with the default credentials
+	endpoint string
+}
+
+// AccessSecret reads the secret version name, e.g.
+// projects/P/secrets/S/versions/latest.
+func (m *gcpSecretManager) AccessSecret(ctx context.Context, name string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.endpoint+"/v1/"+name+":access", nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Payload struct {
+			Data []byte `json:"data"` // base64 in JSON
+		} `json:"payload"`
+	}
+	if err := doSecretRequest(m.client, req, &result); err != nil {
+		return nil, fmt.Errorf("reading %s from Secret Manager: %w", name, err)
+	}
+	return result.Payload.Data, nil
+}
+
+// awsSecretManager reads secrets from AWS Secrets Manager, signing its
+// requests with the access key of the startup configuration.
+type awsSecretManager struct {
+	client       *http.Client
+	endpoint     string
+	region       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	now          func() time.Time
+}
+
+// newAWSSecretManager returns the client of the region of secret, an ARN
+// or a name in AWS_REGION.
+func newAWSSecretManager(cfg *StartupConfig, secret string) (*awsSecretManager, error) {
+	region := cfg.AWSRegion
+	if parts := strings.Split(secret, ":"); len(parts) > 3 && parts[0] == "arn" {
+		region = parts[3]
+	}
+	if region == "" {
+		return nil, errors.New("AWS_REGION is required to read the private key from AWS Secrets Manager")
+	}
+	if cfg.AWSAccessKeyID == "" || cfg.AWSSecretAccessKey == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required to read the private key from AWS Secrets Manager")
+	}
+	return &awsSecretManager{
+		client:       &http.Client{Transport: sharedTransport, Timeout: githubRequestTimeout},
+		endpoint:     "https://secretsmanager." + region + ".amazonaws.com",
+		region:       region,
+		accessKey:    cfg.AWSAccessKeyID,
+		secretKey:    cfg.AWSSecretAccessKey,
+		sessionToken: cfg.AWSSessionToken,
+		now:          time.Now,
+	}, nil
+}
+
+// AccessSecret reads the current version of the secret name, a name or an
+// ARN.
+func (m *awsSecretManager) AccessSecret(ctx context.Context, name string) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": name})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.endpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	m.sign(req, body)
+	var result struct {
+		SecretString string `json:"SecretString"`
+		SecretBinary []byte `json:"SecretBinary"` // base64 in JSON
+	}
+	if err := doSecretRequest(m.client, req, &result); err != nil {
+		return nil, fmt.Errorf("reading %s from AWS Secrets Manager: %w", name, err)
+	}
+	if result.SecretString != "" {
+		return []byte(result.SecretString), nil
+	}
+	return result.SecretBinary, nil
+}
+
+// sign adds an AWS Signature Version 4 to req, whose body is body.
+func (m *awsSecretManager) sign(req *http.Request, body []byte) {
+	now := m.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if m.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", m.sessionToken)
+	}
+
+	// The signed headers are listed in alphabetical order.
+	names := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if m.sessionToken != "" {
+		names = []string{"content-type", "host", "x-amz-date", "x-amz-security-token", "x-amz-target"}
+	}
+	var canonical strings.Builder
+	for _, name := range names {
+		value := req.Header.Get(name)
+		if name == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonical, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+	signed := strings.Join(names, ";")
+	payload := sha256.Sum256(body)
+	request := strings.Join([]string{req.Method, "/", "", canonical.String(), signed, hex.EncodeToString(payload[:])}, "\n")
+	requestHash := sha256.Sum256([]byte(request))
+
+	scope := day + "/" + m.region + "/secretsmanager/aws4_request"
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	key := []byte("AWS4" + m.secretKey)
+	for _, part := range []string{day, m.region, "secretsmanager", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", m.accessKey, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// doSecretRequest sends req and decodes its JSON response into v.
+func doSecretRequest(client *http.Client, req *http.Request, v any) error {
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
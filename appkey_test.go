@@ -0,0 +1,161 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testPrivateKey returns a new RSA key and its PEM encoding.
+func testPrivateKey(t *testing.T) (*rsa.PrivateKey, []byte) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// signedBy reports whether signer's JWTs verify with key.
+func signedBy(t *testing.T, signer *rotatingSigner, key *rsa.PrivateKey) bool {
+	t.Helper()
+	token, err := signer.Sign(jwt.RegisteredClaims{Issuer: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = jwt.Parse(token, func(*jwt.Token) (any, error) { return &key.PublicKey, nil })
+	return err == nil
+}
+
+func TestAppKeyFormats(t *testing.T) {
+	_, pemKey := testPrivateKey(t)
+	path := filepath.Join(t.TempDir(), "app.pem")
+	if err := os.WriteFile(path, pemKey, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	encoded := base64.StdEncoding.EncodeToString(pemKey)
+	tests := []struct {
+		name    string
+		value   string
+		rotates bool
+	}{
+		{"PEM", string(pemKey), false},
+		{"escaped newlines", strings.ReplaceAll(string(pemKey), "\n", `\n`), false},
+		{"base64", encoded, false},
+		{"wrapped base64", encoded[:64] + "\n" + encoded[64:], false},
+		{"file", path, true},
+	}
+	for _, tt := range tests {
+		key, err := newAppKey(t.Context(), &StartupConfig{AppPrivateKey: tt.value})
+		if err != nil {
+			t.Errorf("%s: newAppKey: %v", tt.name, err)
+			continue
+		}
+		got, err := key.load(t.Context())
+		if err != nil || strings.TrimSpace(string(got)) != strings.TrimSpace(string(pemKey)) {
+			t.Errorf("%s: load = %q, %v", tt.name, got, err)
+		}
+		if key.rotates() != tt.rotates {
+			t.Errorf("%s: rotates = %v", tt.name, key.rotates())
+		}
+	}
+	key, _ := newAppKey(t.Context(), &StartupConfig{AppPrivateKey: "not a key"})
+	if _, err := key.load(t.Context()); err == nil || !strings.Contains(err.Error(), "neither PEM nor base64") {
+		t.Errorf("an invalid key should be rejected, got %v", err)
+	}
+	if _, err := newAppKey(t.Context(), &StartupConfig{AppPrivateKey: awsSecretPrefix + "prd-bot/key"}); err == nil || !strings.Contains(err.Error(), "AWS_REGION") {
+		t.Errorf("an AWS secret should need a region, got %v", err)
+	}
+}
+
+func TestWatchAppKeyRotatesFromFile(t *testing.T) {
+	first, firstPEM := testPrivateKey(t)
+	second, secondPEM := testPrivateKey(t)
+	path := filepath.Join(t.TempDir(), "app.pem")
+	if err := os.WriteFile(path, firstPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	apps, err := newAppClientFactory(1, firstPEM, githubServer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !signedBy(t, apps.signer, first) {
+		t.Fatal("JWTs should be signed with the configured key")
+	}
+	go watchAppKey(t.Context(), &appKey{path: path}, apps, firstPEM, 10*time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if !signedBy(t, apps.signer, first) {
+		t.Fatal("an invalid key shouldn't replace the current one")
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(secondPEM)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); !signedBy(t, apps.signer, second); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the rotated key should sign the next JWTs")
+		}
+	}
+}
+
+func TestSecretManagers(t *testing.T) {
+	_, pemKey := testPrivateKey(t)
+	var gcpPath, awsAuth, awsTarget, awsSecret string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "" {
+			gcpPath = r.URL.Path
+			json.NewEncoder(w).Encode(map[string]any{"payload": map[string]any{"data": pemKey}})
+			return
+		}
+		awsAuth, awsTarget = r.Header.Get("Authorization"), r.Header.Get("X-Amz-Target")
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		awsSecret = body.SecretId
+		json.NewEncoder(w).Encode(map[string]any{"SecretString": base64.StdEncoding.EncodeToString(pemKey)})
+	}))
+	defer server.Close()
+
+	gcp := &appKey{secret: "projects/p/secrets/app-key/versions/latest", manager: &gcpSecretManager{client: server.Client(), endpoint: server.URL}}
+	if got, err := gcp.load(t.Context()); err != nil || string(got) != strings.TrimSpace(string(pemKey)) {
+		t.Errorf("GCP load = %v", err)
+	}
+	if gcpPath != "/v1/projects/p/secrets/app-key/versions/latest:access" {
+		t.Errorf("unexpected Secret Manager request %s", gcpPath)
+	}
+
+	const arn = "arn:aws:secretsmanager:eu-west-1:123456789012:secret:app-key"
+	manager, err := newAWSSecretManager(&StartupConfig{AWSRegion: "us-east-1", AWSAccessKeyID: "AKID", AWSSecretAccessKey: "secret"}, arn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manager.region != "eu-west-1" {
+		t.Errorf("the region should come from the ARN, got %s", manager.region)
+	}
+	manager.client, manager.endpoint = server.Client(), server.URL
+	manager.now = func() time.Time { return time.Date(2026, 10, 17, 8, 0, 0, 0, time.UTC) }
+	aws := &appKey{secret: arn, manager: manager}
+	if got, err := aws.load(t.Context()); err != nil || string(got) != strings.TrimSpace(string(pemKey)) {
+		t.Errorf("AWS load = %v", err)
+	}
+	if awsTarget != "secretsmanager.GetSecretValue" || awsSecret != arn {
+		t.Errorf("unexpected AWS request %s for %s", awsTarget, awsSecret)
+	}
+	if !strings.HasPrefix(awsAuth, "AWS4-HMAC-SHA256 Credential=AKID/20261017/eu-west-1/secretsmanager/aws4_request, SignedHeaders=content-type;host;x-amz-date;x-amz-target, Signature=") {
+		t.Errorf("unexpected signature %s", awsAuth)
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandArchitectureDoc = "need_architecture_doc"
+
+	// architectureDocPath is where the architecture document is committed.
+	architectureDocPath = "docs/architecture.md"
+
+	// maxArchitecturePackages bounds the packages described to the model,
+	// keeping those with the most files.
+	maxArchitecturePackages = 40
+	// maxPackageSamples is how many files of a package are read for its
+	// imports.
+	maxPackageSamples = 3
+	// maxPackageImports bounds the external imports listed per package.
+	maxPackageImports = 15
+)
+
+// manifestNames are the build and deployment files that reveal the
+// containers of a repository, compared by base name.
+var manifestNames = []string{
+	"go.mod", "package.json", "pyproject.toml", "requirements.txt", "setup.py", "Cargo.toml",
+	"pom.xml", "build.gradle", "build.gradle.kts", "Gemfile", "composer.json",
+	"Dockerfile", "docker-compose.yml", "docker-compose.yaml", "compose.yml", "compose.yaml", "Procfile",
+}
+
+var (
+	goImportBlockPattern = regexp.MustCompile(`(?s)\bimport\s*\((.*?)\)`)
+	goImportLinePattern  = regexp.MustCompile(`(?m)^import\s+(?:[\w.]+\s+)?"([^"]+)"`)
+	quotedPattern        = regexp.MustCompile(`"([^"]+)"`)
+	jsImportPattern      = regexp.MustCompile(`(?m)(?:^\s*import\s+(?:[^'"]*?\s+from\s+)?|\brequire\(\s*|^\s*export\s+[^'"]*?\s+from\s+)['"]([^'"]+)['"]`)
+	pyImportPattern      = regexp.MustCompile(`(?m)^\s*(?:from\s+([\w.]+)\s+import\b|import\s+([\w.]+))`)
+)
+
+// repoPackage is a directory of source files, as the architecture document
+// sees it.
+type repoPackage struct {
+	Dir       string
+	Files     int
+	Languages []string // file extensions, e.g. ".go"
+	Imports   []string // imports of code outside the repository
+	DependsOn []string // other packages of the repository it imports
+}
+
+// repoStructure is what the architecture document is generated from.
+type repoStructure struct {
+	Packages  []repoPackage
+	Manifests []string
+	Truncated bool // the repository has more files than the tree listed
+}
+
+// processArchitectureDoc analyzes the packages of the repository and their
+// imports and opens a pull request adding a C4-style architecture document,
+// with context, container and component diagrams, as docs/architecture.md.
+func (b *Bot) processArchitectureDoc(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, _ []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandArchitectureDoc, issueNum, repoOwner, repoName)
+
+	structure, err := analyzeRepoStructure(ctx, client, repo)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "document the architecture", "Could not read the repository structure", err)
+		return
+	}
+	if len(structure.Packages) == 0 {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't find any source code in this repository to document the architecture of.")
+		return
+	}
+	repoContext, err := b.resolveRepoContext(ctx, client, repo)
+	if err != nil {
+		log.Printf("Could not read the README of %s for the architecture document: %v", repo.GetFullName(), err)
+		repoContext = "(The README could not be read.)"
+	}
+	doc, err := generateArchitectureDoc(ctx, b.llm, repo, repoContext, structure)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "document the architecture", "Could not write the architecture document", err)
+		return
+	}
+
+	pr, err := openFilePullRequest(ctx, client, repo, filePullRequest{
+		Branch:  fmt.Sprintf("docs/architecture-%d", issueNum),
+		Path:    architectureDocPath,
+		Content: formatArchitectureDoc(doc, repo, issueNum, b.appName),
+		Message: fmt.Sprintf("docs: Document the architecture for #%d", issueNum),
+		Title:   fmt.Sprintf("Architecture document for %s", repo.GetName()),
+		Body: fmt.Sprintf("This PR adds `%s`, a C4-style description of the system context, containers and components of this repository, generated from its packages and their imports for #%d. It was automatically generated by @%s; check the diagrams against how the system really runs before merging.",
+			architectureDocPath, issueNum, b.appName),
+	})
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "document the architecture", "Could not open the architecture document pull request", err)
+		return
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've opened a Pull Request documenting the architecture in `%s`: %s", architectureDocPath, pr.GetHTMLURL()))
+}
+
+// analyzeRepoStructure groups the source files of the default branch by
+// directory, reads a few files of each for their imports and tells the
+// imports of other packages from those of external code.
+func analyzeRepoStructure(ctx context.Context, client *github.Client, repo *github.Repository) (*repoStructure, error) {
+	owner, name, ref := repo.GetOwner().GetLogin(), repo.GetName(), repo.GetDefaultBranch()
+	tree, _, err := client.Git.GetTree(ctx, owner, name, ref, true)
+	if err != nil {
+		return nil, fmt.Errorf("listing the files of %s: %w", repo.GetFullName(), err)
+	}
+	structure := &repoStructure{Truncated: tree.GetTruncated()}
+	ignore := compileIgnore(codeAreaIgnore)
+	files := make(map[string][]string)
+	for _, entry := range tree.Entries {
+		p := entry.GetPath()
+		if entry.GetType() != "blob" {
+			continue
+		}
+		if slices.Contains(manifestNames, path.Base(p)) {
+			structure.Manifests = append(structure.Manifests, p)
+			continue
+		}
+		if ignored(ignore, p) || path.Ext(p) == "" {
+			continue
+		}
+		files[path.Dir(p)] = append(files[path.Dir(p)], p)
+	}
+
+	for dir, paths := range files {
+		pkg := repoPackage{Dir: dir, Files: len(paths)}
+		for _, p := range paths {
+			if ext := path.Ext(p); !slices.Contains(pkg.Languages, ext) {
+				pkg.Languages = append(pkg.Languages, ext)
+			}
+		}
+		slices.Sort(pkg.Languages)
+		structure.Packages = append(structure.Packages, pkg)
+	}
+	// Keep the largest packages, then list them by directory.
+	slices.SortFunc(structure.Packages, func(a, b repoPackage) int {
+		return cmp.Or(cmp.Compare(b.Files, a.Files), strings.Compare(a.Dir, b.Dir))
+	})
+	if len(structure.Packages) > maxArchitecturePackages {
+		structure.Packages = structure.Packages[:maxArchitecturePackages]
+	}
+	slices.SortFunc(structure.Packages, func(a, b repoPackage) int { return strings.Compare(a.Dir, b.Dir) })
+
+	dirs := make([]string, len(structure.Packages))
+	for i, pkg := range structure.Packages {
+		dirs[i] = pkg.Dir
+	}
+	for i := range structure.Packages {
+		pkg := &structure.Packages[i]
+		paths := files[pkg.Dir]
+		slices.Sort(paths)
+		for _, p := range paths[:min(len(paths), maxPackageSamples)] {
+			file, _, _, err := client.Repositories.GetContents(ctx, owner, name, p, &github.RepositoryContentGetOptions{Ref: ref})
+			if err != nil || file == nil {
+				log.Printf("Could not read %s of %s for its imports: %v", p, repo.GetFullName(), err)
+				continue
+			}
+			content, err := file.GetContent()
+			if err != nil {
+				continue
+			}
+			for _, imp := range parseImports(p, content) {
+				if dep, ok := internalPackage(dirs, pkg.Dir, imp); ok {
+					if dep != pkg.Dir && !slices.Contains(pkg.DependsOn, dep) {
+						pkg.DependsOn = append(pkg.DependsOn, dep)
+					}
+				} else if !slices.Contains(pkg.Imports, imp) && len(pkg.Imports) < maxPackageImports {
+					pkg.Imports = append(pkg.Imports, imp)
+				}
+			}
+		}
+		slices.Sort(pkg.DependsOn)
+		slices.Sort(pkg.Imports)
+	}
+	slices.Sort(structure.Manifests)
+	return structure, nil
+}
+
+// parseImports returns what a Go, JavaScript, TypeScript or Python source
+// file imports, or nothing for other languages.
+func parseImports(p, content string) []string {
+	var imports []string
+	switch path.Ext(p) {
+	case ".go":
+		for _, block := range goImportBlockPattern.FindAllStringSubmatch(content, -1) {
+			for _, m := range quotedPattern.FindAllStringSubmatch(block[1], -1) {
+				imports = append(imports, m[1])
+			}
+		}
+		for _, m := range goImportLinePattern.FindAllStringSubmatch(content, -1) {
+			imports = append(imports, m[1])
+		}
+	case ".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx":
+		for _, m := range jsImportPattern.FindAllStringSubmatch(content, -1) {
+			imports = append(imports, m[1])
+		}
+	case ".py":
+		for _, m := range pyImportPattern.FindAllStringSubmatch(content, -1) {
+			imp := cmp.Or(m[1], m[2])
+			if module := strings.TrimLeft(imp, "."); module != imp {
+				// A relative import: one dot is the package itself, each
+				// further dot its parent.
+				imp = "./" + strings.Repeat("../", len(imp)-len(module)-1) + strings.ReplaceAll(module, ".", "/")
+			}
+			imports = append(imports, imp)
+		}
+	}
+	return imports
+}
+
+// internalPackage returns the package among dirs an import of the package
+// in dir refers to: relative imports are resolved against dir, and module
+// paths match the directories they end with.
+func internalPackage(dirs []string, dir, imp string) (string, bool) {
+	if strings.HasPrefix(imp, "./") || strings.HasPrefix(imp, "../") {
+		target := path.Join(dir, imp)
+		for _, p := range []string{target, path.Dir(target)} {
+			if slices.Contains(dirs, p) {
+				return p, true
+			}
+		}
+		return "", false
+	}
+	if !strings.Contains(imp, "/") && strings.Contains(imp, ".") {
+		// A dotted Python module, such as app.services.billing.
+		imp = strings.ReplaceAll(imp, ".", "/")
+	}
+	best := ""
+	for _, d := range dirs {
+		if d == "." {
+			continue
+		}
+		if (imp == d || strings.HasSuffix(imp, "/"+d) || strings.HasPrefix(imp, d+"/")) && len(d) > len(best) {
+			best = d
+		}
+	}
+	return best, best != ""
+}
+
+// describeStructure lists the packages, their dependencies and the
+// manifests of a repository for the architecture prompt.
+func describeStructure(s *repoStructure) string {
+	var b strings.Builder
+	b.WriteString("**Build and Deployment Files:**\n")
+	if len(s.Manifests) == 0 {
+		b.WriteString("(none)\n")
+	}
+	for _, m := range s.Manifests {
+		fmt.Fprintf(&b, "- %s\n", m)
+	}
+	b.WriteString("\n**Packages:**\n")
+	for _, pkg := range s.Packages {
+		fmt.Fprintf(&b, "- `%s` (%d files: %s)\n", pkg.Dir, pkg.Files, strings.Join(pkg.Languages, ", "))
+		if len(pkg.DependsOn) > 0 {
+			fmt.Fprintf(&b, "  - depends on: %s\n", strings.Join(pkg.DependsOn, ", "))
+		}
+		if len(pkg.Imports) > 0 {
+			fmt.Fprintf(&b, "  - imports: %s\n", strings.Join(pkg.Imports, ", "))
+		}
+	}
+	if s.Truncated {
+		b.WriteString("\n(The repository has more files than were listed.)\n")
+	}
+	return b.String()
+}
+
+// generateArchitectureDoc asks the model for the C4 sections of the
+// architecture document. Every section needs its Mermaid diagram.
+func generateArchitectureDoc(ctx context.Context, llm Generator, repo *github.Repository, repoContext string, structure *repoStructure) (string, error) {
+	prompt := fmt.Sprintf(
+		"Write a C4-model architecture document for the repository below, from its package structure and imports.\n\n"+
+			"Write these sections as GitHub-flavored Markdown with `##` headings, each with a short description and a ```mermaid code block holding a `flowchart` diagram:\n"+
+			"## System Context\n(The system as one box, the people who use it and the external systems it talks to, judged from the external imports)\n"+
+			"## Containers\n(The separately deployed or run units, such as services, workers, web apps and databases, judged from the build and deployment files)\n"+
+			"## Components\n(The main packages of each container and how they depend on each other, using the package dependencies listed)\n"+
+			"## Notes\n(Assumptions you made and what the structure alone couldn't tell)\n\n"+
+			"Only describe what the structure and README support, and name packages by their directory. Quote node labels in the diagrams, e.g. `api[\"api: HTTP handlers\"]`.\n\n"+
+			"**Repository:** %s\n\n**README:**\n%s\n\n%s",
+		repo.GetFullName(), repoContext, describeStructure(structure),
+	)
+	out, err := generateMarkdown(withSystemPrompt(ctx, CommandArchitectureDoc), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate architecture document: %w", err)
+	}
+	out = strings.TrimSpace(out)
+	if !strings.Contains(out, "```mermaid") {
+		return "", fmt.Errorf("%w: the architecture document has no Mermaid diagram", ErrModelInvalid)
+	}
+	return out, nil
+}
+
+// formatArchitectureDoc renders the architecture document committed for
+// issueNum.
+func formatArchitectureDoc(doc string, repo *github.Repository, issueNum int, appName string) string {
+	return fmt.Sprintf("# Architecture of %s\n\n_Generated by @%s from the packages and imports of `%s` for #%d. Update it as the architecture changes._\n\n%s\n",
+		repo.GetName(), appName, repo.GetDefaultBranch(), issueNum, doc)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const architectureReply = "## System Context\nWidgets serves reports over HTTP.\n\n```mermaid\nflowchart LR\n  user[\"User\"] --> widgets[\"Widgets\"]\n```\n\n## Containers\nOne Go service.\n\n## Components\n`internal/export` writes CSV.\n\n## Notes\nNone."
+
+func TestArchitectureDocOpensPullRequest(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "go.mod", "module github.com/acme/widgets")
+	env.github.addFile("acme", "widgets", "main.go", "package main\n\nimport (\n\t\"net/http\"\n\n\t\"github.com/acme/widgets/internal/export\"\n)\n")
+	env.github.addFile("acme", "widgets", "internal/export/csv.go", "package export\n\nimport \"encoding/csv\"\n")
+	env.github.addFile("acme", "widgets", "web/src/components/Button.tsx", "import React from 'react';\nimport { get } from '../api';\n")
+	env.github.addFile("acme", "widgets", "web/src/api.ts", "export const get = () => fetch('/reports');\n")
+	env.gemini.on("C4-model architecture document", architectureReply)
+
+	env.comment(t, "@prd-bot need_architecture_doc")
+
+	var prompt string
+	for _, p := range env.gemini.receivedPrompts() {
+		if strings.HasPrefix(p, "Write a C4-model architecture document") {
+			prompt = p
+		}
+	}
+	for _, want := range []string{
+		"- go.mod\n",
+		"- `.` (1 files: .go)\n  - depends on: internal/export\n  - imports: net/http\n",
+		"- `internal/export` (1 files: .go)\n  - imports: encoding/csv\n",
+		"- `web/src/components` (1 files: .tsx)\n  - depends on: web/src\n  - imports: react\n",
+	} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected %q in the prompt:\n%s", want, prompt)
+		}
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected an architecture pull request, got %d", len(pulls))
+	}
+	doc, ok := env.github.committed("acme", "widgets", pulls[0].GetHead().GetRef(), architectureDocPath)
+	if !ok {
+		t.Fatalf("%s was not committed", architectureDocPath)
+	}
+	if !strings.HasPrefix(doc, "# Architecture of widgets\n") || !strings.Contains(doc, "```mermaid\nflowchart LR") {
+		t.Errorf("unexpected document:\n%s", doc)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, pulls[0].GetHTMLURL()) {
+		t.Errorf("expected a link to the pull request:\n%s", body)
+	}
+}
+
+func TestArchitectureDocNeedsDiagrams(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "main.go", "package main\n")
+	env.gemini.on("C4-model architecture document", "## System Context\nA service.")
+
+	env.comment(t, "@prd-bot need_architecture_doc")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("a document without diagrams shouldn't be committed, got %d pull requests", len(pulls))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "`MODEL_INVALID_RESPONSE`") {
+		t.Errorf("expected a MODEL_INVALID_RESPONSE failure:\n%s", body)
+	}
+}
+
+func TestParseImports(t *testing.T) {
+	for _, tt := range []struct {
+		path, content string
+		want          []string
+	}{
+		{"app.py", "import os\nfrom app.services import billing\nfrom . import models\nfrom ..core import db\n", []string{"os", "app.services", "./", "./../core"}},
+		{"index.js", "const express = require('express');\nexport { a } from \"./a\";\n", []string{"express", "./a"}},
+		{"main.go", "import alias \"github.com/acme/x\"\n", []string{"github.com/acme/x"}},
+		{"main.rs", "use std::io;\n", nil},
+	} {
+		if got := parseImports(tt.path, tt.content); !slices.Equal(got, tt.want) {
+			t.Errorf("parseImports(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// bucketArchives records the archive pull request opened for each issue,
+// keyed by issueKey, so an issue is archived only once.
+const bucketArchives = "archives"
+
+// artifactTitles names the artifact kinds in archives, in the order they
+// appear. Kinds without a title follow in alphabetical order.
+var artifactTitles = []struct{ Kind, Title string }{
+	{ArtifactPRD, "Product Requirements Document"},
+	{ArtifactSubTasks, "Sub-tasks"},
+	{ArtifactI18nPlan, "Internationalization Plan"},
+	{ArtifactAnalyticsEvents, "Analytics Events"},
+	{ArtifactCapacityPlan, "Capacity & Performance Considerations"},
+	{ArtifactUISpec, "UI Specification"},
+	{ArtifactRollbackPlan, "Rollback Plan"},
+	{ArtifactComplianceCheck, "Compliance Checklist"},
+}
+
+// archivePath is where the archive of an issue is committed.
+func archivePath(issueNum int) string {
+	return fmt.Sprintf("docs/prd/issue-%d.md", issueNum)
+}
+
+// handleIssueClosed archives the issue's artifacts when the repository
+// enables `archive_on_close`.
+func (b *Bot) handleIssueClosed(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64) {
+	b.dispatch(ctx, func() {
+		ctx := context.Background()
+		if !b.repoConfig(ctx, client, repo).ArchiveOnCloseEnabled() {
+			return
+		}
+		b.archiveIssue(ctx, client, issue, repo, installationID)
+	})
+}
+
+// archiveIssue bundles the issue's artifacts into a single Markdown file and
+// opens a pull request adding it under docs/prd, preserving the product
+// history in the repository.
+func (b *Bot) archiveIssue(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	key := issueKey(repoOwner, repoName, issueNum)
+	var archived int
+	if ok, _ := b.store.Get(bucketArchives, key, &archived); ok {
+		log.Printf("Issue %s was already archived in pull request #%d.", key, archived)
+		return
+	}
+	artifacts, err := b.issueArtifacts(repoOwner, repoName, issueNum)
+	if err != nil {
+		log.Printf("Error loading artifacts of %s: %v", key, err)
+		return
+	}
+	if len(artifacts) == 0 {
+		return
+	}
+	if err := b.preflight(ctx, client, repo, installationID); err != nil {
+		log.Printf("Not archiving %s: %v", key, err)
+		return
+	}
+	log.Printf("Archiving %d artifacts of closed issue %s.", len(artifacts), key)
+
+	path := archivePath(issueNum)
+	pr, err := openFilePullRequest(ctx, client, repo, filePullRequest{
+		Branch:  fmt.Sprintf("docs/prd-issue-%d", issueNum),
+		Path:    path,
+		Content: formatArchive(issue, artifacts),
+		Message: fmt.Sprintf("docs: Archive product documents of #%d", issueNum),
+		Title:   fmt.Sprintf("Archive product documents of #%d", issueNum),
+		Body:    fmt.Sprintf("This PR archives the documents generated for #%d to `%s`. It was automatically generated by @%s.", issueNum, path, b.appName),
+	})
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "archive the issue's documents", "Could not open the archive pull request", err)
+		return
+	}
+	if err := b.store.Put(bucketArchives, key, pr.GetNumber()); err != nil {
+		log.Printf("Error recording the archive of %s: %v", key, err)
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've opened a Pull Request archiving this issue's documents to `%s`: %s", path, pr.GetHTMLURL()))
+}
+
+// issueArtifacts returns the artifacts stored for the issue in archive order.
+func (b *Bot) issueArtifacts(owner, repo string, issueNum int) ([]*Artifact, error) {
+	docs, err := b.store.List(bucketArtifacts)
+	if err != nil {
+		return nil, err
+	}
+	prefix := issueKey(owner, repo, issueNum) + "/"
+	var artifacts []*Artifact
+	for key, doc := range docs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var artifact Artifact
+		if err := json.Unmarshal(doc, &artifact); err != nil {
+			return nil, fmt.Errorf("decoding %s/%s: %w", bucketArtifacts, key, err)
+		}
+		artifacts = append(artifacts, &artifact)
+	}
+	slices.SortFunc(artifacts, func(a, b *Artifact) int {
+		if d := artifactRank(a.Kind) - artifactRank(b.Kind); d != 0 {
+			return d
+		}
+		return strings.Compare(a.Kind, b.Kind)
+	})
+	return artifacts, nil
+}
+
+func artifactRank(kind string) int {
+	for i, t := range artifactTitles {
+		if t.Kind == kind {
+			return i
+		}
+	}
+	return len(artifactTitles)
+}
+
+func artifactTitle(kind string) string {
+	if i := artifactRank(kind); i < len(artifactTitles) {
+		return artifactTitles[i].Title
+	}
+	return kind
+}
+
+// formatArchive renders the artifacts of issue as a single Markdown document.
+func formatArchive(issue *github.Issue, artifacts []*Artifact) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# #%d: %s\n\n", issue.GetNumber(), issue.GetTitle())
+	fmt.Fprintf(&b, "_Archived from %s on %s._\n", issue.GetHTMLURL(), time.Now().UTC().Format("2006-01-02"))
+	for _, artifact := range artifacts {
+		fmt.Fprintf(&b, "\n## %s\n\n", artifactTitle(artifact.Kind))
+		if artifact.CommentURL != "" {
+			fmt.Fprintf(&b, "_Source: %s (%s)_\n\n", artifact.CommentURL, artifact.CreatedAt.UTC().Format("2006-01-02"))
+		}
+		b.WriteString(strings.TrimSpace(artifact.Markdown) + "\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// closeIssue delivers an issues "closed" webhook for issue #42.
+func (env *testEnv) closeIssue(t *testing.T) {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issues_opened.json"), &event); err != nil {
+		t.Fatalf("decoding issues fixture: %v", err)
+	}
+	event["action"] = "closed"
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding issues payload: %v", err)
+	}
+	env.deliverPayload(t, "issues", payload)
+}
+
+func TestArchiveOnClose(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "archive_on_close: true")
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	env.bot.saveArtifact(ArtifactSubTasks, "acme", "widgets", issue, "### Generated Sub-tasks\n\n- [ ] Add a CSV encoder", nil)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, PRDIdentifier+"\n\n**Background:** CSV", nil)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(7)}, "another issue", nil)
+
+	env.closeIssue(t)
+	env.closeIssue(t)
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 archive pull request, got %d", len(pulls))
+	}
+	if head := pulls[0].GetHead().GetRef(); head != "docs/prd-issue-42" || pulls[0].GetBase().GetRef() != "main" {
+		t.Errorf("unexpected archive pull request %s -> %s", head, pulls[0].GetBase().GetRef())
+	}
+	archive, ok := env.github.committed("acme", "widgets", "docs/prd-issue-42", "docs/prd/issue-42.md")
+	if !ok {
+		t.Fatal("the archive was not committed")
+	}
+	prd, subTasks := strings.Index(archive, "## Product Requirements Document"), strings.Index(archive, "## Sub-tasks")
+	if prd < 0 || subTasks < prd || !strings.Contains(archive, "- [ ] Add a CSV encoder") || strings.Contains(archive, "another issue") {
+		t.Errorf("unexpected archive:\n%s", archive)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), pulls[0].GetHTMLURL()) {
+		t.Errorf("expected a comment linking the archive pull request, got %+v", comments)
+	}
+}
+
+func TestArchiveOnCloseIsOptIn(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(42)}, PRDIdentifier, nil)
+
+	env.closeIssue(t)
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("archiving is off by default, got %d pull requests", len(pulls))
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"regexp"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// artifactMetaPrefix opens the hidden metadata of an artifact comment.
+	artifactMetaPrefix = "<!-- agent-prd:artifact "
+	// artifactMetaSchema is the version of the artifactMeta format. Raise it
+	// when fields change meaning or are removed.
+	artifactMetaSchema = 1
+)
+
+// artifactMetaPattern matches the metadata comment and the blank line
+// stampArtifact puts before it.
+var artifactMetaPattern = regexp.MustCompile(`(?:\n\n)?<!-- agent-prd:artifact (\{.*?\}) -->`)
+
+// artifactMeta describes the artifact a comment holds, for tools that scan
+// comments: it sits at the end of the comment as an HTML comment, so GitHub
+// doesn't render it.
+type artifactMeta struct {
+	Type     string    `json:"type"`              // the Artifact kind
+	Schema   int       `json:"schema"`            // artifactMetaSchema
+	Version  string    `json:"version,omitempty"` // of PRDs, e.g. "v1.1"
+	Bot      string    `json:"bot"`
+	Command  string    `json:"command,omitempty"`
+	Model    string    `json:"model,omitempty"`
+	Language string    `json:"language,omitempty"` // the configured output language
+	Updated  time.Time `json:"updated_at"`
+}
+
+// stampArtifact returns body with the metadata of a kind artifact generated
+// with ctx, replacing any metadata body already had.
+func (b *Bot) stampArtifact(ctx context.Context, kind, body string) string {
+	body = stripArtifactMeta(body)
+	meta := artifactMeta{
+		Type:    kind,
+		Schema:  artifactMetaSchema,
+		Bot:     b.appName,
+		Command: errorTags(ctx)["command"],
+		Model:   contextModel(ctx),
+		Updated: time.Now().UTC().Truncate(time.Second),
+	}
+	if meta.Model == "" {
+		if g, ok := b.llm.(interface{ modelName() string }); ok {
+			meta.Model = g.modelName()
+		}
+	}
+	if set, _ := ctx.Value(promptSetKey{}).(*promptSet); set != nil {
+		meta.Language = set.language
+	}
+	if doc, ok := parsePRDDocument(body); ok && kind == ArtifactPRD {
+		meta.Version = doc.Version
+	}
+	// Marshal escapes < and >, so no value can close the HTML comment.
+	data, err := json.Marshal(meta)
+	if err != nil {
+		log.Printf("Error encoding the metadata of a %s artifact: %v", kind, err)
+		return body
+	}
+	return body + "\n\n" + artifactMetaPrefix + string(data) + " -->"
+}
+
+// stripArtifactMeta removes the artifact metadata from body.
+func stripArtifactMeta(body string) string {
+	return artifactMetaPattern.ReplaceAllString(body, "")
+}
+
+// parseArtifactMeta returns the artifact metadata of body, if it has any.
+func parseArtifactMeta(body string) (artifactMeta, bool) {
+	var meta artifactMeta
+	match := artifactMetaPattern.FindStringSubmatch(body)
+	if match == nil || json.Unmarshal([]byte(match[1]), &meta) != nil || meta.Type == "" {
+		return artifactMeta{}, false
+	}
+	return meta, true
+}
+
+// postArtifact posts body, a kind artifact, with its metadata.
+func (b *Bot) postArtifact(ctx context.Context, client *github.Client, owner, repo string, issueNum int, kind, body string) *github.IssueComment {
+	return b.postComment(ctx, client, owner, repo, issueNum, b.stampArtifact(ctx, kind, body))
+}
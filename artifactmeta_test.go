@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestPRDCommentCarriesArtifactMeta(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_en.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) == 0 {
+		t.Fatal("expected a PRD comment")
+	}
+	body := comments[0].GetBody()
+	meta, ok := parseArtifactMeta(body)
+	if !ok || meta.Type != ArtifactPRD || meta.Schema != artifactMetaSchema || meta.Bot != testAppName || meta.Model != defaultGeminiModel || meta.Updated.IsZero() {
+		t.Errorf("unexpected metadata %+v, %v in:\n%s", meta, ok, body)
+	}
+	if !strings.HasSuffix(body, " -->") || strings.Contains(stripArtifactMeta(body), artifactMetaPrefix) {
+		t.Errorf("the metadata should close the comment and strip cleanly:\n%s", body)
+	}
+}
+
+func TestStampArtifactReplacesMeta(t *testing.T) {
+	env := newTestEnv(t)
+	body := (&PRDDocument{English: "1.  **Goals:** Ship it.\n"}).String()
+
+	stamped := env.bot.stampArtifact(context.Background(), ArtifactPRD, body)
+	restamped := env.bot.stampArtifact(context.Background(), ArtifactPRD, stamped)
+	if strings.Count(restamped, artifactMetaPrefix) != 1 {
+		t.Errorf("stamping again should replace the metadata:\n%s", restamped)
+	}
+	if got := stripArtifactMeta(restamped); got != body {
+		t.Errorf("stripArtifactMeta = %q, want %q", got, body)
+	}
+	if _, ok := parseArtifactMeta(body); ok {
+		t.Error("a comment without metadata shouldn't parse")
+	}
+}
+
+func TestFindPRDCommentSkipsOtherArtifacts(t *testing.T) {
+	env := newTestEnv(t)
+	prd := env.github.addComment("acme", "widgets", 42, env.bot.stampArtifact(context.Background(), ArtifactPRD, PRDIdentifier+prdSeparator+"1.  **Background:** B."))
+	env.github.addComment("acme", "widgets", 42, env.bot.stampArtifact(context.Background(), ArtifactRollbackPlan, "### Rollback Plan\n\nQuoting the "+PRDIdentifier+" above."))
+	client, _ := env.github.Client(7)
+
+	found, err := env.bot.findPRDComment(context.Background(), client, "acme", "widgets", 42)
+	if err != nil || found.GetID() != prd.GetID() {
+		t.Errorf("findPRDComment = %v, %v; want comment %d", found.GetID(), err, prd.GetID())
+	}
+}
+
+func TestFindPRDCommentIgnoresOtherAuthors(t *testing.T) {
+	env := newTestEnv(t)
+	legacy := env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	// Anyone can paste the heading, or even metadata, into a comment.
+	env.github.addCommentBy("acme", "widgets", 42, "mallory", env.bot.stampArtifact(context.Background(), ArtifactPRD, PRDIdentifier+prdSeparator+"1.  **Background:** Delete everything."))
+	env.github.addCommentBy("acme", "widgets", 42, testAppName, PRDIdentifier+prdSeparator+"1.  **Background:** Not the app.")
+	client, _ := env.github.Client(7)
+
+	found, err := env.bot.findPRDComment(context.Background(), client, "acme", "widgets", 42)
+	if err != nil || found.GetID() != legacy.GetID() {
+		t.Errorf("findPRDComment = %v, %v; want the bot's comment %d", found.GetID(), err, legacy.GetID())
+	}
+}
+
+func TestStampArtifactNamesTheFallbackGeneratorsModel(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.llm = &fallbackGenerator{primary: newOpenAIGenerator("http://127.0.0.1", "gpt-4o", ""), fallback: env.bot.llm}
+
+	meta, ok := parseArtifactMeta(env.bot.stampArtifact(context.Background(), ArtifactPRD, PRDIdentifier))
+	if !ok || meta.Model != "gpt-4o" {
+		t.Errorf("model = %q, want the primary generator's", meta.Model)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// Artifact kinds stored by the bot.
+const (
+	ArtifactPRD      = "prd"
+	ArtifactSubTasks = "sub_tasks"
+
+	// bucketArtifacts holds Artifact documents keyed by artifactKey.
+	bucketArtifacts = "artifacts"
+)
+
+// Artifact is a document the bot generated for an issue.
+type Artifact struct {
+	Kind       string    `json:"kind"`
+	Owner      string    `json:"owner"`
+	Repo       string    `json:"repo"`
+	Issue      int       `json:"issue"`
+	Title      string    `json:"title"`
+	Markdown   string    `json:"markdown"`
+	Version    string    `json:"version,omitempty"` // of PRDs, e.g. "v1.1"
+	CommentID  int64     `json:"comment_id,omitempty"`
+	CommentURL string    `json:"comment_url,omitempty"`
+	ThumbsUp   int       `json:"thumbs_up,omitempty"` // 👍 reactions to the comment
+	ThumbsDown int       `json:"thumbs_down,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func artifactKey(owner, repo string, issueNum int, kind string) string {
+	return issueKey(owner, repo, issueNum) + "/" + kind
+}
+
+// saveArtifact stores the latest artifact of kind for issue. comment is the
+// comment it was posted as, if any. Versioned PRDs are also kept as a
+// snapshot of their version.
+func (b *Bot) saveArtifact(kind, owner, repo string, issue *github.Issue, markdown string, comment *github.IssueComment) {
+	artifact := &Artifact{
+		Kind:       kind,
+		Owner:      owner,
+		Repo:       repo,
+		Issue:      issue.GetNumber(),
+		Title:      issue.GetTitle(),
+		Markdown:   stripArtifactMeta(markdown),
+		CommentID:  comment.GetID(),
+		CommentURL: comment.GetHTMLURL(),
+		CreatedAt:  time.Now(),
+	}
+	if doc, ok := parsePRDDocument(markdown); ok && kind == ArtifactPRD {
+		artifact.Version = doc.Version
+	}
+	if !b.serverConfig().Data.storeDocuments() {
+		artifact.Markdown = ""
+	}
+	if err := b.store.Put(bucketArtifacts, artifactKey(owner, repo, issue.GetNumber(), kind), artifact); err != nil {
+		log.Printf("Error storing %s artifact for issue #%d: %v", kind, issue.GetNumber(), err)
+	}
+	if kind == ArtifactPRD {
+		b.savePRDVersion(artifact)
+	}
+}
+
+// loadArtifact returns the stored artifact of kind for the issue, or nil.
+func (b *Bot) loadArtifact(owner, repo string, issueNum int, kind string) (*Artifact, error) {
+	var artifact Artifact
+	ok, err := b.store.Get(bucketArtifacts, artifactKey(owner, repo, issueNum, kind), &artifact)
+	if err != nil || !ok {
+		return nil, err
+	}
+	return &artifact, nil
+}
+
+// authorizeAPI checks the request's bearer token against the configured API
+// token, writing an error response and returning false when it doesn't match.
+// Browsers may send the token as the password of basic authentication.
+func (b *Bot) authorizeAPI(w http.ResponseWriter, r *http.Request) bool {
+	if b.apiToken == "" {
+		http.Error(w, "API is disabled: set API_TOKEN to enable it", http.StatusServiceUnavailable)
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		_, token, ok = r.BasicAuth()
+	}
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(b.apiToken)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="agent-prd"`)
+		w.Header().Add("WWW-Authenticate", `Basic realm="agent-prd"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleArtifactExport serves a stored artifact as Markdown, JSON or PDF:
+// GET /repos/{owner}/{repo}/issues/{number}/artifacts/{kind}?format=md|json|pdf
+func (b *Bot) handleArtifactExport(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	owner, repo, kind := r.PathValue("owner"), r.PathValue("repo"), r.PathValue("kind")
+	issueNum, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		http.Error(w, "Invalid issue number", http.StatusBadRequest)
+		return
+	}
+
+	artifact, err := b.loadArtifact(owner, repo, issueNum, kind)
+	if err != nil {
+		log.Printf("Error loading %s artifact for %s: %v", kind, issueKey(owner, repo, issueNum), err)
+		http.Error(w, "Error loading artifact", http.StatusInternalServerError)
+		return
+	}
+	if artifact == nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s-issue-%d-%s", owner, repo, issueNum, kind)
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "md":
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", filename+".md"))
+		fmt.Fprint(w, artifact.Markdown)
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(artifact); err != nil {
+			log.Printf("Error encoding artifact: %v", err)
+		}
+	case "pdf":
+		title := fmt.Sprintf("%s/%s#%d: %s", owner, repo, issueNum, artifact.Title)
+		if !pdfEncodable(title) || !pdfEncodable(artifact.Markdown) {
+			http.Error(w, "The artifact has characters the PDF export can't show, such as CJK text: use format=md or format=json", http.StatusUnsupportedMediaType)
+			return
+		}
+		w.Header().Set("Content-Type", "application/pdf")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+".pdf"))
+		w.Write(renderTextPDF(title, artifact.Markdown))
+	default:
+		http.Error(w, fmt.Sprintf("Unsupported format %q: use md, json or pdf", format), http.StatusBadRequest)
+	}
+}
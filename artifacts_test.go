@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func exportRequest(t *testing.T, bot *Bot, url, token string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}/artifacts/{kind}", bot.handleArtifactExport)
+	req := httptest.NewRequest(http.MethodGet, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestArtifactExport(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", "**Background:** CSV (export) needed.")
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", "**Background:** CSV (export) needed.")
+	env.deliver(t, "issues", "issues_opened.json")
+
+	const url = "/repos/acme/widgets/issues/42/artifacts/prd"
+	if rec := exportRequest(t, env.bot, url, ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("unauthenticated request returned %d", rec.Code)
+	}
+	if rec := exportRequest(t, env.bot, url, "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token returned %d", rec.Code)
+	}
+
+	rec := exportRequest(t, env.bot, url+"?format=md", "s3cret")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Body.String(), PRDIdentifier) {
+		t.Errorf("markdown export = %d:\n%s", rec.Code, rec.Body.String())
+	}
+
+	rec = exportRequest(t, env.bot, url+"?format=json", "s3cret")
+	var artifact Artifact
+	if err := json.Unmarshal(rec.Body.Bytes(), &artifact); err != nil || artifact.Issue != 42 || artifact.Kind != ArtifactPRD || artifact.CommentID == 0 {
+		t.Errorf("json export = %+v (%v)", artifact, err)
+	}
+
+	rec = exportRequest(t, env.bot, url+"?format=pdf", "s3cret")
+	body := rec.Body.Bytes()
+	if !bytes.HasPrefix(body, []byte("%PDF-1.4")) || !bytes.Contains(body, []byte(`CSV \(export\) needed.`)) || !bytes.HasSuffix(body, []byte("%%EOF\n")) {
+		t.Errorf("unexpected PDF export:\n%s", body)
+	}
+
+	translated := &github.Issue{Number: github.Int(43), Title: github.String("匯出 CSV")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", translated, "**背景:** 需要匯出 CSV。", nil)
+	if rec := exportRequest(t, env.bot, "/repos/acme/widgets/issues/43/artifacts/prd?format=pdf", "s3cret"); rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("a PDF of CJK text returned %d, want 415", rec.Code)
+	}
+
+	if rec := exportRequest(t, env.bot, url+"?format=docx", "s3cret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("unsupported format returned %d", rec.Code)
+	}
+	if rec := exportRequest(t, env.bot, "/repos/acme/widgets/issues/7/artifacts/prd", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing artifact returned %d", rec.Code)
+	}
+}
+
+func TestWrapPDFText(t *testing.T) {
+	long := strings.Repeat("word ", 40)
+	for _, line := range wrapPDFText(long) {
+		if n := len([]rune(line)); n > pdfCharsPerLine {
+			t.Errorf("wrapped line has %d runes, limit is %d", n, pdfCharsPerLine)
+		}
+	}
+	if got := pdfString("é (x) 中"); got != `\351 \(x\) ?` {
+		t.Errorf("pdfString = %q", got)
+	}
+	if got := pdfString("“CSV” – fast"); got != `\223CSV\224 \226 fast` || !pdfEncodable("“CSV” – fast\r\n") || pdfEncodable("匯出") {
+		t.Errorf("pdfString = %q", got)
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// AssessmentIdentifier heads the model's self-assessment in pull request bodies.
+	AssessmentIdentifier = "### Assumptions & Risks"
+
+	// maxAssessedDiff caps the diff sent for self-assessment, in bytes.
+	maxAssessedDiff = 30000
+)
+
+// changeAssessment is the model's own assessment of a generated change, so
+// reviewers know what to verify.
+type changeAssessment struct {
+	Confidence  int      `json:"confidence"` // 0 to 100
+	Rationale   string   `json:"rationale"`
+	Assumptions []string `json:"assumptions"`
+	Risks       []string `json:"risks"`
+}
+
+// level names the confidence band.
+func (a *changeAssessment) level() string {
+	switch {
+	case a.Confidence >= 80:
+		return "High"
+	case a.Confidence >= 50:
+		return "Medium"
+	default:
+		return "Low"
+	}
+}
+
+// assessChange returns the "Assumptions & Risks" section for a pull request
+// implementing issue with diff, or "" when the model can't assess it.
+func (b *Bot) assessChange(ctx context.Context, issue *github.Issue, diff, plan string) string {
+	assessment, err := generateAssessment(ctx, b.llm, issue, diff, plan)
+	if err != nil {
+		log.Printf("Could not assess the change for issue #%d, opening the pull request without it: %v", issue.GetNumber(), err)
+		return ""
+	}
+	return formatAssessment(assessment)
+}
+
+func generateAssessment(ctx context.Context, llm Generator, issue *github.Issue, diff, plan string) (*changeAssessment, error) {
+	if len(diff) > maxAssessedDiff {
+		diff = diff[:maxAssessedDiff] + "\n[diff truncated]"
+	}
+	var approved string
+	if plan != "" {
+		approved = fmt.Sprintf("**Approved Implementation Plan:**\n%s\n\n", plan)
+	}
+	prompt := fmt.Sprintf(
+		"Self-assess the following change generated to implement a GitHub issue, for the engineers who will review it. "+
+			"Rate your confidence that it correctly and completely implements the issue from 0 to 100, explain the rating in one sentence, "+
+			"list the assumptions the change makes that the issue doesn't state, and list the risks reviewers should verify (edge cases, missing tests, behavior changes).\n\n"+
+			"Respond with JSON only, in this format:\n"+
+			`{"confidence": 70, "rationale": "One sentence.", "assumptions": ["..."], "risks": ["..."]}`+"\n\n"+
+			"**Issue Title:** %s\n\n**Issue Body:**\n%s\n\n%s**Diff:**\n```diff\n%s\n```",
+		issue.GetTitle(), issue.GetBody(), approved, diff,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptAssessment), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assess the change: %w", err)
+	}
+	var assessment changeAssessment
+	if err := parseModelJSON(resp, &assessment); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	if assessment.Confidence < 0 || assessment.Confidence > 100 {
+		return nil, fmt.Errorf("%w: confidence %d is out of range", ErrModelInvalid, assessment.Confidence)
+	}
+	return &assessment, nil
+}
+
+// formatAssessment renders an assessment as a pull request body section.
+func formatAssessment(a *changeAssessment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", AssessmentIdentifier)
+	if a.level() == "Low" {
+		b.WriteString("> [!WARNING]\n> The model has low confidence in this change. Review it carefully before merging.\n\n")
+	}
+	fmt.Fprintf(&b, "**Confidence:** %s (%d/100)", a.level(), a.Confidence)
+	if rationale := strings.TrimSpace(a.Rationale); rationale != "" {
+		b.WriteString(". " + rationale)
+	}
+	writeList := func(title string, items []string) {
+		var lines []string
+		for _, item := range items {
+			if item = strings.TrimSpace(item); item != "" {
+				lines = append(lines, "- "+item)
+			}
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(&b, "\n\n**%s**\n\n%s", title, strings.Join(lines, "\n"))
+		}
+	}
+	writeList("Assumptions", a.Assumptions)
+	writeList("Risks to verify", a.Risks)
+	b.WriteString("\n\n_Self-assessed by the model that wrote the change; it doesn't replace review._")
+	return b.String()
+}
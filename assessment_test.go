@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPullRequestListsAssumptionsAndRisks(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git diff --cached": configDiff}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(pulls))
+	}
+	body := pulls[0].GetBody()
+	for _, want := range []string{
+		AssessmentIdentifier + "\n\n**Confidence:** High (85/100). The change follows the issue closely.",
+		"**Assumptions**\n\n- Reports fit in memory.",
+		"**Risks to verify**\n\n- No tests cover empty reports.",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "[!WARNING]") {
+		t.Errorf("a confident change shouldn't carry a warning:\n%s", body)
+	}
+	prompts := env.gemini.receivedPrompts()
+	if prompt := prompts[len(prompts)-1]; !strings.Contains(prompt, "S3_ACCESS_KEY") {
+		t.Errorf("the assessment should be based on the diff:\n%s", prompt)
+	}
+}
+
+func TestLowConfidenceIsFlagged(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Self-assess", "```json\n{\"confidence\": 30, \"rationale\": \"The issue is vague.\", \"assumptions\": [\" \"], \"risks\": []}\n```")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	body := env.github.pullRequests()[0].GetBody()
+	if !strings.Contains(body, "> [!WARNING]\n> The model has low confidence") || !strings.Contains(body, "**Confidence:** Low (30/100). The issue is vague.") {
+		t.Errorf("a low confidence should be flagged:\n%s", body)
+	}
+	if strings.Contains(body, "**Assumptions**") || strings.Contains(body, "**Risks to verify**") {
+		t.Errorf("empty lists should be omitted:\n%s", body)
+	}
+}
+
+func TestInvalidAssessmentIsOmitted(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Self-assess", `{"confidence": 140}`)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 1 || strings.Contains(pulls[0].GetBody(), AssessmentIdentifier) {
+		t.Errorf("the pull request should be opened without the assessment, got %v", pulls)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// AutoImplementConfig starts implement_feature from issue triage instead of
+// a comment. Both triggers are off by default.
+type AutoImplementConfig struct {
+	// OnAssign starts when the bot's user is assigned to the issue.
+	OnAssign bool `yaml:"on_assign"`
+	// Label starts when this label, e.g. "agent:implement", is applied.
+	Label string `yaml:"label"`
+}
+
+// isBotUser reports whether user is the bot: its app's bot user or, in
+// personal access token mode, the token's user. A user merely named like
+// the app isn't.
+func (b *Bot) isBotUser(user *github.User) bool {
+	login := b.appName
+	if _, app := b.clients.(installationResolver); app {
+		login += "[bot]"
+	}
+	return b.appName != "" && strings.EqualFold(user.GetLogin(), login)
+}
+
+// handleAutoImplement runs implement_feature for an issue that was assigned
+// to the bot or labeled, when the repository enables that trigger. sender is
+// the user who assigned or labeled the issue.
+func (b *Bot) handleAutoImplement(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, sender *github.User, assignee *github.User, label *github.Label) {
+	b.dispatch(ctx, func() {
+		ctx := b.withInstallation(context.Background(), installationID)
+		cfg := b.repoConfig(ctx, client, repo).AutoImplement
+		if cfg == nil {
+			return
+		}
+		switch {
+		case assignee != nil && cfg.OnAssign && b.isBotUser(assignee):
+			log.Printf("Issue #%d in %s was assigned to the bot. Starting %s.", issue.GetNumber(), repo.GetFullName(), CommandImplementFeature)
+		case label != nil && cfg.Label != "" && strings.EqualFold(label.GetName(), cfg.Label):
+			log.Printf("Issue #%d in %s was labeled %q. Starting %s.", issue.GetNumber(), repo.GetFullName(), label.GetName(), CommandImplementFeature)
+		default:
+			return
+		}
+		if issue.GetState() != "open" {
+			return
+		}
+		b.runCommandHandler(client, b.commands[CommandImplementFeature], CommandImplementFeature, nil, issue, repo, installationID, sender)
+	})
+}
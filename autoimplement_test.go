@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// label delivers an issues "labeled" webhook for issue #42.
+func (env *testEnv) label(t *testing.T, name string) {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issues_opened.json"), &event); err != nil {
+		t.Fatalf("decoding issues fixture: %v", err)
+	}
+	event["action"] = "labeled"
+	event["label"] = map[string]any{"name": name}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding issues payload: %v", err)
+	}
+	env.deliverPayload(t, "issues", payload)
+}
+
+func TestAutoImplementOnLabelAndAssignment(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "onboarding: false\nauto_implement:\n  on_assign: true\n  label: agent:implement\n")
+
+	env.label(t, "bug")
+	env.assign(t, "bob")
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Fatalf("other labels and assignees shouldn't start an implementation, got %d pull requests", len(pulls))
+	}
+
+	env.label(t, "Agent:Implement")
+	if pulls := env.github.pullRequests(); len(pulls) != 1 {
+		t.Fatalf("the label should start implement_feature, got %d pull requests", len(pulls))
+	}
+	// The issue's pull request is still open, so the second run updates it.
+	env.assign(t, env.bot.appName+"[bot]")
+	if pulls := env.github.pullRequests(); len(pulls) != 1 || env.github.pullEdits != 1 {
+		t.Fatalf("assigning the bot should start implement_feature, got %d pull requests and %d edits", len(pulls), env.github.pullEdits)
+	}
+}
+
+func TestAutoImplementIsOptIn(t *testing.T) {
+	env := newTestEnv(t)
+	env.label(t, "agent:implement")
+	env.assign(t, env.bot.appName)
+	if commands := env.runner.executed(); len(commands) != 0 {
+		t.Errorf("auto_implement is off by default, but ran %v", commands)
+	}
+}
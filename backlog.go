@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// RankedBacklogTitle is the title of the pinned issue maintained by rank_backlog.
+	RankedBacklogTitle = "Ranked Backlog"
+
+	// bucketBacklog maps "owner/repo" to the rankedBacklogIssue of the repository.
+	bucketBacklog = "backlog"
+)
+
+// rankedBacklogIssue remembers which issue holds a repository's ranked backlog.
+type rankedBacklogIssue struct {
+	Number int `json:"number"`
+}
+
+// rankedEntry is one position in the ranked backlog.
+type rankedEntry struct {
+	Score   PriorityScore
+	TieNote string // model reasoning for the order within a group of equal scores
+}
+
+// processRankBacklog orders the repository's open, scored issues and writes
+// the result to the pinned Ranked Backlog issue. Maintainers only.
+func (b *Bot) processRankBacklog(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, _ []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandRankBacklog, issueNum, repoOwner, repoName)
+
+	if !b.requireMaintainer(ctx, client, repo, issueNum, CommandRankBacklog) {
+		return
+	}
+
+	framework := b.repoConfig(ctx, client, repo).PriorityFramework
+	if framework == "" {
+		framework = FrameworkRICE
+	}
+
+	scores, err := b.openIssueScores(ctx, client, repoOwner, repoName)
+	if err != nil {
+		log.Printf("Error collecting priority scores for %s/%s: %v", repoOwner, repoName, err)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't collect the open issues to rank. Please try again later.")
+		return
+	}
+	var comparable, other []PriorityScore
+	for _, s := range scores {
+		if s.Framework == framework {
+			comparable = append(comparable, s)
+		} else {
+			other = append(other, s)
+		}
+	}
+	if len(comparable) == 0 {
+		msg := fmt.Sprintf("No open issues have a %s priority score yet. Run `@%s %s` on the issues you want ranked first.", strings.ToUpper(framework), b.appName, CommandPriority)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+		return
+	}
+
+	ranked := rankScores(ctx, b.llm, comparable)
+	body := formatRankedBacklog(framework, ranked, other)
+	backlog, err := b.upsertBacklogIssue(ctx, client, repoOwner, repoName, body)
+	if err != nil {
+		log.Printf("Error updating ranked backlog for %s/%s: %v", repoOwner, repoName, err)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I ranked the backlog but couldn't write the Ranked Backlog issue.")
+		return
+	}
+	msg := fmt.Sprintf("I've ranked %d open issues in #%d (%s).", len(ranked), backlog.GetNumber(), backlog.GetHTMLURL())
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+}
+
+// openIssueScores returns the stored priority scores of the repository's open issues.
+func (b *Bot) openIssueScores(ctx context.Context, client *github.Client, owner, repo string) ([]PriorityScore, error) {
+	docs, err := b.store.List(bucketPriority)
+	if err != nil {
+		return nil, err
+	}
+	stored := make(map[int]PriorityScore)
+	prefix := owner + "/" + repo + "#"
+	for key, data := range docs {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		var s PriorityScore
+		if err := json.Unmarshal(data, &s); err != nil {
+			log.Printf("Skipping unreadable priority score %s: %v", key, err)
+			continue
+		}
+		stored[s.Issue] = s
+	}
+	if len(stored) == 0 {
+		return nil, nil
+	}
+
+	var scores []PriorityScore
+	opts := &github.IssueListByRepoOptions{State: "open", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		for _, issue := range issues {
+			if s, ok := stored[issue.GetNumber()]; ok && !issue.IsPullRequest() {
+				s.Title = issue.GetTitle()
+				scores = append(scores, s)
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+	return scores, nil
+}
+
+// rankScores sorts scores from highest to lowest and asks the model to order
+// groups of equal scores, keeping issue-number order when it can't.
+func rankScores(ctx context.Context, llm Generator, scores []PriorityScore) []rankedEntry {
+	sorted := slices.Clone(scores)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if roundScore(sorted[i].Score) != roundScore(sorted[j].Score) {
+			return sorted[i].Score > sorted[j].Score
+		}
+		return sorted[i].Issue < sorted[j].Issue
+	})
+
+	var ranked []rankedEntry
+	for start := 0; start < len(sorted); {
+		end := start + 1
+		for end < len(sorted) && roundScore(sorted[end].Score) == roundScore(sorted[start].Score) {
+			end++
+		}
+		group := sorted[start:end]
+		note := ""
+		if len(group) > 1 {
+			var err error
+			group, note, err = resolveTie(ctx, llm, group)
+			if err != nil {
+				log.Printf("Could not resolve tie between %d issues, keeping issue order: %v", len(group), err)
+			}
+		}
+		for _, s := range group {
+			ranked = append(ranked, rankedEntry{Score: s, TieNote: note})
+		}
+		start = end
+	}
+	return ranked
+}
+
+func roundScore(score float64) string {
+	return fmt.Sprintf("%.2f", score)
+}
+
+// resolveTie asks the model to order issues with equal scores using
+// qualitative reasoning. On failure the group is returned unchanged.
+func resolveTie(ctx context.Context, llm Generator, group []PriorityScore) ([]PriorityScore, string, error) {
+	var candidates strings.Builder
+	for _, s := range group {
+		fmt.Fprintf(&candidates, "- Issue #%d: %s\n  Summary: %s\n", s.Issue, s.Title, s.Summary)
+	}
+	prompt := fmt.Sprintf(
+		"The following backlog items received the same priority score. "+
+			"Order them from most to least important using qualitative reasoning such as strategic fit, dependencies and user pain.\n\n"+
+			"%s\n"+
+			"Respond with JSON only, using this shape:\n"+
+			"{\"order\": [<issue numbers, most important first>], \"reasoning\": \"<one or two sentences>\"}",
+		candidates.String(),
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, CommandRankBacklog), prompt)
+	if err != nil {
+		return group, "", err
+	}
+	var decision struct {
+		Order     []int  `json:"order"`
+		Reasoning string `json:"reasoning"`
+	}
+	if err := parseModelJSON(resp, &decision); err != nil {
+		return group, "", err
+	}
+	byIssue := make(map[int]PriorityScore, len(group))
+	for _, s := range group {
+		byIssue[s.Issue] = s
+	}
+	ordered := make([]PriorityScore, 0, len(group))
+	for _, n := range decision.Order {
+		s, ok := byIssue[n]
+		if !ok {
+			return group, "", fmt.Errorf("model ordered unknown or duplicate issue #%d", n)
+		}
+		ordered = append(ordered, s)
+		delete(byIssue, n)
+	}
+	if len(byIssue) > 0 {
+		return group, "", fmt.Errorf("model omitted %d issues", len(byIssue))
+	}
+	return ordered, decision.Reasoning, nil
+}
+
+func formatRankedBacklog(framework string, ranked []rankedEntry, other []PriorityScore) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This issue is maintained by the bot and ranks open issues by their %s priority score. Do not edit it by hand; run `rank_backlog` again to refresh it.\n\n", strings.ToUpper(framework))
+	b.WriteString("| Rank | Issue | Score | Scored |\n|---|---|---|---|\n")
+	var notes []string
+	for i, e := range ranked {
+		marker := ""
+		if e.TieNote != "" {
+			if len(notes) == 0 || notes[len(notes)-1] != e.TieNote {
+				notes = append(notes, e.TieNote)
+			}
+			marker = fmt.Sprintf(" [^tie%d]", len(notes))
+		}
+		fmt.Fprintf(&b, "| %d | #%d %s | %.2f%s | %s |\n", i+1, e.Score.Issue, strings.ReplaceAll(e.Score.Title, "|", "\\|"), e.Score.Score, marker, e.Score.UpdatedAt.Format(time.DateOnly))
+	}
+	for i, note := range notes {
+		fmt.Fprintf(&b, "\n[^tie%d]: Tie resolved by qualitative review: %s\n", i+1, note)
+	}
+	if len(other) > 0 {
+		issues := make([]string, len(other))
+		for i, s := range other {
+			issues[i] = fmt.Sprintf("#%d (%s)", s.Issue, strings.ToUpper(s.Framework))
+		}
+		fmt.Fprintf(&b, "\n_Not ranked because they were scored with a different framework: %s._\n", strings.Join(issues, ", "))
+	}
+	fmt.Fprintf(&b, "\n_Last updated %s._\n", time.Now().UTC().Format(time.RFC1123))
+	return b.String()
+}
+
+// upsertBacklogIssue updates the repository's Ranked Backlog issue, creating
+// and pinning it when it doesn't exist yet.
+func (b *Bot) upsertBacklogIssue(ctx context.Context, client *github.Client, owner, repo, body string) (*github.Issue, error) {
+	key := owner + "/" + repo
+	var existing rankedBacklogIssue
+	if ok, _ := b.store.Get(bucketBacklog, key, &existing); ok {
+		issue, _, err := client.Issues.Edit(ctx, owner, repo, existing.Number, &github.IssueRequest{
+			Body:  github.String(body),
+			State: github.String("open"),
+		})
+		if err == nil {
+			return issue, nil
+		}
+		log.Printf("Could not update ranked backlog issue #%d, creating a new one: %v", existing.Number, err)
+	}
+
+	issue, _, err := client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title: github.String(RankedBacklogTitle),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := b.store.Put(bucketBacklog, key, rankedBacklogIssue{Number: issue.GetNumber()}); err != nil {
+		log.Printf("Error storing ranked backlog issue for %s: %v", key, err)
+	}
+	if err := pinIssue(ctx, client, issue.GetNodeID()); err != nil {
+		log.Printf("Could not pin ranked backlog issue #%d: %v", issue.GetNumber(), err)
+	}
+	return issue, nil
+}
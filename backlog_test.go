@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRankBacklogRequiresMaintainer(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.comment(t, "@prd-bot rank_backlog")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "only repository maintainers and admins") {
+		t.Fatalf("expected a permission refusal, got %+v", comments)
+	}
+}
+
+func TestRankBacklogCreatesAndUpdatesPinnedIssue(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.setRole("alice", "maintain")
+	env.github.addIssue("acme", "widgets", 1, "Dark mode", "open")
+	env.github.addIssue("acme", "widgets", 2, "CSV export", "open")
+	env.github.addIssue("acme", "widgets", 3, "SSO", "open")
+	env.github.addIssue("acme", "widgets", 4, "Closed thing", "closed")
+	for _, s := range []PriorityScore{
+		{Owner: "acme", Repo: "widgets", Issue: 1, Framework: FrameworkRICE, Score: 10},
+		{Owner: "acme", Repo: "widgets", Issue: 2, Framework: FrameworkRICE, Score: 50},
+		{Owner: "acme", Repo: "widgets", Issue: 3, Framework: FrameworkRICE, Score: 50},
+		{Owner: "acme", Repo: "widgets", Issue: 4, Framework: FrameworkRICE, Score: 99},
+	} {
+		s.UpdatedAt = time.Now()
+		if err := env.bot.store.Put(bucketPriority, issueKey(s.Owner, s.Repo, s.Issue), s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env.gemini.on("received the same priority score", `{"order": [3, 2], "reasoning": "SSO unblocks enterprise deals."}`)
+
+	env.comment(t, "@prd-bot rank_backlog")
+
+	backlog := env.github.issue("acme", "widgets", 101)
+	if backlog == nil || backlog.GetTitle() != RankedBacklogTitle {
+		t.Fatalf("expected a Ranked Backlog issue, got %+v", backlog)
+	}
+	body := backlog.GetBody()
+	first, second, third := strings.Index(body, "| 1 | #3 SSO"), strings.Index(body, "| 2 | #2 CSV export"), strings.Index(body, "| 3 | #1 Dark mode")
+	if first < 0 || second < first || third < second {
+		t.Errorf("unexpected ranking:\n%s", body)
+	}
+	if strings.Contains(body, "Closed thing") || !strings.Contains(body, "SSO unblocks enterprise deals.") {
+		t.Errorf("unexpected backlog body:\n%s", body)
+	}
+	if queries := env.github.graphQLQueries(); len(queries) != 1 || !strings.Contains(queries[0], "pinIssue") {
+		t.Errorf("expected the backlog issue to be pinned, got %v", queries)
+	}
+
+	// A second run updates the same issue instead of creating another one.
+	env.comment(t, "@prd-bot rank_backlog")
+	if env.github.issue("acme", "widgets", 102) != nil {
+		t.Error("expected the existing backlog issue to be updated")
+	}
+}
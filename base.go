@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// baseFlag makes implement_feature branch off and target another branch
+// than the default one, e.g. `--base release/1.x` for a maintenance fix.
+const baseFlag = "--base"
+
+// parseBaseFlag removes `--base <branch>` from args. ok is false when the
+// flag has no branch.
+func parseBaseFlag(args []string) (base string, rest []string, ok bool) {
+	i := slices.Index(args, baseFlag)
+	if i < 0 {
+		return "", args, true
+	}
+	if i+1 >= len(args) {
+		return "", nil, false
+	}
+	rest = append(slices.Clone(args[:i]), args[i+2:]...)
+	return args[i+1], rest, true
+}
+
+// resolveBase returns the branch implement_feature should work on: the
+// requested one when it exists, otherwise the default branch along with a
+// note explaining the fallback.
+func resolveBase(ctx context.Context, client *github.Client, repo *github.Repository, requested string) (base, note string, err error) {
+	defaultBranch := repo.GetDefaultBranch()
+	if requested == "" || requested == defaultBranch {
+		return defaultBranch, "", nil
+	}
+	_, _, err = client.Git.GetRef(ctx, repo.GetOwner().GetLogin(), repo.GetName(), "heads/"+requested)
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusNotFound {
+		return defaultBranch, fmt.Sprintf("The branch `%s` doesn't exist in this repository, so I'm working on the default branch `%s` instead.", requested, defaultBranch), nil
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("%w: reading branch %s: %w", ErrCloneFailed, requested, err)
+	}
+	return requested, "", nil
+}
+
+// withBaseFlag returns the `--base` argument to repeat in replies suggested
+// to the user, or "" for the default branch.
+func withBaseFlag(repo *github.Repository, base string) string {
+	if base == "" || base == repo.GetDefaultBranch() {
+		return ""
+	}
+	return fmt.Sprintf(" %s %s", baseFlag, base)
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseBaseFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		base string
+		rest []string
+		ok   bool
+	}{
+		{nil, "", nil, true},
+		{[]string{"split"}, "", []string{"split"}, true},
+		{[]string{"--base", "release/1.x"}, "release/1.x", []string{}, true},
+		{[]string{"single", "--base", "release/1.x"}, "release/1.x", []string{"single"}, true},
+		{[]string{"--base"}, "", nil, false},
+	}
+	for _, tt := range tests {
+		base, rest, ok := parseBaseFlag(tt.args)
+		if base != tt.base || !slices.Equal(rest, tt.rest) || ok != tt.ok {
+			t.Errorf("parseBaseFlag(%q) = %q, %q, %v; want %q, %q, %v", tt.args, base, rest, ok, tt.base, tt.rest, tt.ok)
+		}
+	}
+}
+
+// implementPayload is the implement_feature fixture with the comment body.
+func implementPayload(t *testing.T, body string) []byte {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issue_comment_implement_feature.json"), &event); err != nil {
+		t.Fatalf("decoding comment fixture: %v", err)
+	}
+	event["comment"].(map[string]any)["body"] = body
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding comment payload: %v", err)
+	}
+	return payload
+}
+
+func TestImplementFeatureOnMaintenanceBranch(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.branches["acme/widgets@release/1.x"] = testHeadSHA
+
+	env.deliverPayload(t, "issue_comment", implementPayload(t, "@prd-bot implement_feature --base release/1.x"))
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || pulls[0].GetBase().GetRef() != "release/1.x" {
+		t.Fatalf("expected a pull request against release/1.x, got %v", pulls)
+	}
+	if !slices.Contains(env.runner.executed(), "git checkout release/1.x") {
+		t.Errorf("the clone should be switched to the base branch, ran:\n%s", strings.Join(env.runner.executed(), "\n"))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "against `release/1.x`") {
+		t.Errorf("the final comment should name the base branch:\n%s", last)
+	}
+	var record botPullRequest
+	if found, _ := env.bot.store.Get(bucketPulls, pullKey("acme", "widgets", pulls[0].GetNumber()), &record); !found || record.Base != "release/1.x" {
+		t.Errorf("the recorded pull request should keep its base, got %+v", record)
+	}
+}
+
+func TestImplementFeatureFallsBackToDefaultBranch(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.deliverPayload(t, "issue_comment", implementPayload(t, "@prd-bot implement_feature --base release/9.x"))
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || pulls[0].GetBase().GetRef() != "main" {
+		t.Fatalf("expected a pull request against main, got %v", pulls)
+	}
+	var notes []string
+	for _, c := range env.github.issueComments("acme", "widgets", 42) {
+		if strings.Contains(c.GetBody(), "`release/9.x` doesn't exist") {
+			notes = append(notes, c.GetBody())
+		}
+	}
+	if len(notes) != 1 || !strings.Contains(notes[0], "default branch `main`") {
+		t.Errorf("expected one fallback note, got %q", notes)
+	}
+	for _, c := range env.runner.executed() {
+		if strings.HasPrefix(c, "git checkout release") {
+			t.Errorf("unexpected %q", c)
+		}
+	}
+}
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandCheckBreaking lists the breaking changes of a pull request and
+	// labels it BreakingChangeLabel when there are any.
+	CommandCheckBreaking = "check_breaking"
+
+	// BreakingChangesIdentifier marks comments produced by check_breaking.
+	BreakingChangesIdentifier = "### Breaking Changes"
+
+	// BreakingChangeLabel labels pull requests with breaking changes.
+	BreakingChangeLabel = "breaking-change"
+
+	// maxBreakingGoFiles limits the Go files whose API is compared.
+	maxBreakingGoFiles = 50
+	// maxBreakingPatchBytes bounds the diff the model reviews.
+	maxBreakingPatchBytes = 40 * 1024
+)
+
+// apiChange is a change of the exported Go API of a package.
+type apiChange struct {
+	Package string // directory of the package
+	Name    string // identifier, e.g. "Parse" or "Writer.Flush"
+	Before  string // declaration at the base, "" when added
+	After   string // declaration at the head, "" when removed
+}
+
+func (c apiChange) String() string {
+	switch {
+	case c.After == "":
+		return fmt.Sprintf("`%s` in `%s`: removed (was `%s`)", c.Name, c.Package, c.Before)
+	case c.Before == "":
+		return fmt.Sprintf("`%s` in `%s`: `%s` added to an interface, which implementations outside the package don't have", c.Name, c.Package, c.After)
+	default:
+		return fmt.Sprintf("`%s` in `%s`: changed from `%s` to `%s`", c.Name, c.Package, c.Before, c.After)
+	}
+}
+
+// breakingChange is a breaking change the model found beyond the Go API.
+type breakingChange struct {
+	Change    string `json:"change"`
+	Impact    string `json:"impact"`
+	Migration string `json:"migration"`
+}
+
+// processCheckBreaking compares the exported Go API of the packages a pull
+// request changes with its base, asks the model for the breaking changes
+// the API doesn't show (behavior, configuration, HTTP and CLI interfaces,
+// schemas), and labels the pull request when it finds any.
+func (b *Bot) processCheckBreaking(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, _ []string) {
+	repoOwner, repoName, number := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	if !issue.IsPullRequest() {
+		b.postComment(ctx, client, repoOwner, repoName, number, fmt.Sprintf("`%s` works on pull requests. Comment it on the pull request to check.", CommandCheckBreaking))
+		return
+	}
+	log.Printf("Processing '%s' for pull request #%d in %s/%s", CommandCheckBreaking, number, repoOwner, repoName)
+	fail := func(reason string, err error) {
+		b.reportFailure(ctx, client, repoOwner, repoName, number, "check for breaking changes", reason, err)
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, repoOwner, repoName, number)
+	if err != nil {
+		fail("Could not read the pull request", err)
+		return
+	}
+	files, err := listPullRequestFiles(ctx, client, repoOwner, repoName, number)
+	if err != nil {
+		fail("Could not list the changed files", err)
+		return
+	}
+	changes, skipped := compareGoAPI(ctx, client, repoOwner, repoName, pr.GetBase().GetSHA(), pr.GetHead().GetSHA(), files)
+	others, err := findBreakingChanges(ctx, b.llm, pr, files, changes)
+	if err != nil {
+		fail("Could not review the change", err)
+		return
+	}
+
+	breaking := len(changes) > 0 || len(others) > 0
+	var labelErr error
+	if breaking {
+		_, _, labelErr = client.Issues.AddLabelsToIssue(ctx, repoOwner, repoName, number, []string{BreakingChangeLabel})
+	} else if slices.ContainsFunc(issue.Labels, func(l *github.Label) bool { return l.GetName() == BreakingChangeLabel }) {
+		resp, err := client.Issues.RemoveLabelForIssue(ctx, repoOwner, repoName, number, BreakingChangeLabel)
+		if resp == nil || resp.StatusCode != http.StatusNotFound {
+			labelErr = err
+		}
+	}
+	if labelErr != nil {
+		log.Printf("Error updating the %s label of #%d in %s/%s: %v", BreakingChangeLabel, number, repoOwner, repoName, labelErr)
+	}
+	b.postComment(ctx, client, repoOwner, repoName, number, formatBreakingChanges(changes, others, skipped, breaking, labelErr))
+}
+
+// listPullRequestFiles returns every file a pull request changes.
+func listPullRequestFiles(ctx context.Context, client *github.Client, owner, repo string, number int) ([]*github.CommitFile, error) {
+	opts := &github.ListOptions{PerPage: 100}
+	var files []*github.CommitFile
+	for {
+		page, resp, err := client.PullRequests.ListFiles(ctx, owner, repo, number, opts)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, page...)
+		if resp.NextPage == 0 {
+			return files, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// isPublicGoFile reports whether p is a Go source file whose package others
+// can import: not a test, and not under internal, testdata or vendor.
+func isPublicGoFile(p string) bool {
+	if !strings.HasSuffix(p, ".go") || strings.HasSuffix(p, "_test.go") {
+		return false
+	}
+	for _, dir := range strings.Split(path.Dir(p), "/") {
+		if dir == "internal" || dir == "testdata" || dir == "vendor" {
+			return false
+		}
+	}
+	return true
+}
+
+// compareGoAPI returns the changes of the exported API of the packages the
+// files belong to, between the base and head commits, and the files it
+// couldn't compare. Declarations moved between changed files of a package
+// aren't reported.
+func compareGoAPI(ctx context.Context, client *github.Client, owner, repo, base, head string, files []*github.CommitFile) ([]apiChange, []string) {
+	before := make(map[string]goAPI) // package directory -> API
+	after := make(map[string]goAPI)
+	var skipped []string
+	analyzed := 0
+	for _, f := range files {
+		name, previous := f.GetFilename(), f.GetPreviousFilename()
+		if previous == "" {
+			previous = name
+		}
+		if !isPublicGoFile(name) && !isPublicGoFile(previous) {
+			continue
+		}
+		if analyzed == maxBreakingGoFiles {
+			skipped = append(skipped, name)
+			continue
+		}
+		analyzed++
+		var old, updated goAPI
+		ok := true
+		if f.GetStatus() != "added" && isPublicGoFile(previous) {
+			old, ok = fileAPI(ctx, client, owner, repo, base, previous)
+		}
+		if ok && f.GetStatus() != "removed" && isPublicGoFile(name) {
+			updated, ok = fileAPI(ctx, client, owner, repo, head, name)
+		}
+		if !ok {
+			skipped = append(skipped, name)
+			continue
+		}
+		mergeAPI(before, path.Dir(previous), old)
+		mergeAPI(after, path.Dir(name), updated)
+	}
+
+	var changes []apiChange
+	for dir, old := range before {
+		updated := after[dir]
+		for name, decl := range old {
+			// The members of a removed type go with it.
+			if typeName, _, member := strings.Cut(name, "."); member {
+				if _, ok := updated[typeName]; !ok {
+					if _, declared := old[typeName]; declared {
+						continue
+					}
+				}
+			}
+			if now, ok := updated[name]; !ok {
+				changes = append(changes, apiChange{Package: dir, Name: name, Before: decl})
+			} else if now != decl {
+				changes = append(changes, apiChange{Package: dir, Name: name, Before: decl, After: now})
+			}
+		}
+	}
+	for dir, updated := range after {
+		for name, decl := range updated {
+			// Adding a method to an interface breaks its implementations.
+			typeName, _, member := strings.Cut(name, ".")
+			if _, ok := before[dir][name]; !ok && member && strings.HasSuffix(before[dir][typeName], " interface") && strings.HasSuffix(updated[typeName], " interface") {
+				changes = append(changes, apiChange{Package: dir, Name: name, After: decl})
+			}
+		}
+	}
+	slices.SortFunc(changes, func(a, b apiChange) int {
+		return cmp.Or(cmp.Compare(a.Package, b.Package), cmp.Compare(a.Name, b.Name))
+	})
+	return changes, skipped
+}
+
+// fileAPI returns the exported API of file p at ref, nil for a main
+// package. It reports whether the file could be read and parsed.
+func fileAPI(ctx context.Context, client *github.Client, owner, repo, ref, p string) (goAPI, bool) {
+	file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, p, &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil || file == nil {
+		log.Printf("Error fetching %s at %s from %s/%s: %v", p, ref, owner, repo, err)
+		return nil, false
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		log.Printf("Error decoding %s at %s from %s/%s: %v", p, ref, owner, repo, err)
+		return nil, false
+	}
+	api, err := parseGoAPI(p, content)
+	if err != nil {
+		log.Printf("Error parsing %s at %s from %s/%s: %v", p, ref, owner, repo, err)
+		return nil, false
+	}
+	return api, true
+}
+
+// mergeAPI adds api to the API of the package in dir.
+func mergeAPI(apis map[string]goAPI, dir string, api goAPI) {
+	if api == nil {
+		return
+	}
+	if apis[dir] == nil {
+		apis[dir] = make(goAPI)
+	}
+	for name, decl := range api {
+		apis[dir][name] = decl
+	}
+}
+
+// goAPI maps the exported identifiers of a package to their declarations,
+// without names of parameters, bodies, values or comments, e.g.
+// "Parse" -> "func Parse(string) (*Doc, error)". Fields and methods are
+// keyed "Type.Name"; structs and interfaces are declared by kind only, so
+// adding fields or methods doesn't change them.
+type goAPI map[string]string
+
+// parseGoAPI returns the exported API declared in a Go source file, or nil
+// for a main package.
+func parseGoAPI(filename, src string) (goAPI, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	if file.Name.Name == "main" {
+		return nil, nil
+	}
+	node := func(n ast.Node) string {
+		var buf bytes.Buffer
+		if err := printer.Fprint(&buf, fset, n); err != nil {
+			return fmt.Sprintf("%T", n)
+		}
+		return strings.Join(strings.Fields(buf.String()), " ")
+	}
+	api := make(goAPI)
+	for _, decl := range file.Decls {
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if !d.Name.IsExported() {
+				continue
+			}
+			if d.Recv == nil {
+				api[d.Name.Name] = "func " + d.Name.Name + typeParams(node, d.Type.TypeParams) + signature(node, d.Type)
+				continue
+			}
+			recv := d.Recv.List[0].Type
+			pointer := ""
+			if star, ok := recv.(*ast.StarExpr); ok {
+				recv, pointer = star.X, "*"
+			}
+			switch r := recv.(type) {
+			case *ast.IndexExpr:
+				recv = r.X
+			case *ast.IndexListExpr:
+				recv = r.X
+			}
+			if ident, ok := recv.(*ast.Ident); ok && ident.IsExported() {
+				api[ident.Name+"."+d.Name.Name] = fmt.Sprintf("func (%s%s) %s%s", pointer, ident.Name, d.Name.Name, signature(node, d.Type))
+			}
+		case *ast.GenDecl:
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					if s.Name.IsExported() {
+						addTypeAPI(api, node, s)
+					}
+				case *ast.ValueSpec:
+					for _, name := range s.Names {
+						if !name.IsExported() {
+							continue
+						}
+						decl := d.Tok.String() + " " + name.Name
+						if s.Type != nil {
+							decl += " " + node(s.Type)
+						}
+						api[name.Name] = decl
+					}
+				}
+			}
+		}
+	}
+	return api, nil
+}
+
+func addTypeAPI(api goAPI, node func(ast.Node) string, s *ast.TypeSpec) {
+	name := s.Name.Name
+	decl := "type " + name + typeParams(node, s.TypeParams)
+	if s.Assign.IsValid() {
+		api[name] = decl + " = " + node(s.Type)
+		return
+	}
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		api[name] = decl + " struct"
+		for _, field := range t.Fields.List {
+			for _, fieldName := range fieldNames(field) {
+				if token.IsExported(fieldName) {
+					api[name+"."+fieldName] = "field " + name + "." + fieldName + " " + node(field.Type)
+				}
+			}
+		}
+	case *ast.InterfaceType:
+		api[name] = decl + " interface"
+		for _, method := range t.Methods.List {
+			if fn, ok := method.Type.(*ast.FuncType); ok {
+				for _, methodName := range method.Names {
+					if methodName.IsExported() {
+						api[name+"."+methodName.Name] = "method " + name + "." + methodName.Name + signature(node, fn)
+					}
+				}
+				continue
+			}
+			// An embedded interface or a type constraint.
+			embedded := node(method.Type)
+			api[name+"."+embedded] = "embeds " + embedded
+		}
+	default:
+		api[name] = decl + " " + node(s.Type)
+	}
+}
+
+// fieldNames returns the names of a struct field; an embedded field is
+// named after its type.
+func fieldNames(field *ast.Field) []string {
+	if len(field.Names) > 0 {
+		names := make([]string, len(field.Names))
+		for i, n := range field.Names {
+			names[i] = n.Name
+		}
+		return names
+	}
+	t := field.Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch e := t.(type) {
+	case *ast.Ident:
+		return []string{e.Name}
+	case *ast.SelectorExpr:
+		return []string{e.Sel.Name}
+	case *ast.IndexExpr:
+		return fieldNames(&ast.Field{Type: e.X})
+	case *ast.IndexListExpr:
+		return fieldNames(&ast.Field{Type: e.X})
+	}
+	return nil
+}
+
+// signature formats the parameter and result types of fn, e.g.
+// "(string, ...int) (*Doc, error)".
+func signature(node func(ast.Node) string, fn *ast.FuncType) string {
+	s := "(" + strings.Join(fieldTypes(node, fn.Params), ", ") + ")"
+	switch results := fieldTypes(node, fn.Results); len(results) {
+	case 0:
+	case 1:
+		s += " " + results[0]
+	default:
+		s += " (" + strings.Join(results, ", ") + ")"
+	}
+	return s
+}
+
+func typeParams(node func(ast.Node) string, params *ast.FieldList) string {
+	if params == nil || len(params.List) == 0 {
+		return ""
+	}
+	return "[" + strings.Join(fieldTypes(node, params), ", ") + "]"
+}
+
+// fieldTypes lists the type of every name in fields, dropping the names.
+func fieldTypes(node func(ast.Node) string, fields *ast.FieldList) []string {
+	if fields == nil {
+		return nil
+	}
+	var types []string
+	for _, field := range fields.List {
+		t := node(field.Type)
+		for range max(1, len(field.Names)) {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// findBreakingChanges asks the model for the breaking changes of pr that
+// the exported Go API doesn't show.
+func findBreakingChanges(ctx context.Context, llm Generator, pr *github.PullRequest, files []*github.CommitFile, changes []apiChange) ([]breakingChange, error) {
+	var diff strings.Builder
+	for _, f := range files {
+		patch := fmt.Sprintf("--- %s (%s)\n%s\n", f.GetFilename(), f.GetStatus(), f.GetPatch())
+		if diff.Len()+len(patch) > maxBreakingPatchBytes {
+			diff.WriteString("(further changes omitted)\n")
+			break
+		}
+		diff.WriteString(patch)
+	}
+	api := "None found."
+	if len(changes) > 0 {
+		var lines []string
+		for _, c := range changes {
+			lines = append(lines, "- "+c.String())
+		}
+		api = strings.Join(lines, "\n")
+	}
+	prompt := fmt.Sprintf(
+		"Review this pull request for breaking changes: changes that force its users, clients or operators to change something when they upgrade. "+
+			"The changes of the exported Go API below were already found by comparing declarations, so don't repeat them. "+
+			"Look for the ones a declaration comparison can't see: changed behavior or defaults of existing functions, removed or renamed configuration keys, environment variables or CLI flags, changed HTTP or RPC endpoints and payloads, database schema or stored data format changes, and raised minimum versions. "+
+			"Only report changes the diff shows; internal refactoring isn't breaking.\n\n"+
+			"Respond with only a JSON object: {\"breaking_changes\": [{\"change\": \"what changed\", \"impact\": \"who is affected and how\", \"migration\": \"what they need to do\"}]}, with an empty list when there are none.\n\n"+
+			"**Pull Request:** %s\n\n%s\n\n"+
+			"**Exported Go API Changes:**\n%s\n\n"+
+			"**Diff:**\n```diff\n%s```",
+		pr.GetTitle(), pr.GetBody(), api, diff.String(),
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, CommandCheckBreaking), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to review the change: %w", err)
+	}
+	var result struct {
+		BreakingChanges []breakingChange `json:"breaking_changes"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(result.BreakingChanges, func(c breakingChange) bool { return strings.TrimSpace(c.Change) == "" }), nil
+}
+
+func formatBreakingChanges(changes []apiChange, others []breakingChange, skipped []string, breaking bool, labelErr error) string {
+	sections := []string{BreakingChangesIdentifier}
+	if !breaking {
+		sections = append(sections, "I found no breaking changes in this pull request.")
+	}
+	if len(changes) > 0 {
+		var b strings.Builder
+		b.WriteString("**Exported Go API** (compared with the base branch):")
+		for _, c := range changes {
+			fmt.Fprintf(&b, "\n- %s", c)
+		}
+		sections = append(sections, b.String())
+	}
+	if len(others) > 0 {
+		var b strings.Builder
+		b.WriteString("**Other breaking changes:**")
+		for _, c := range others {
+			fmt.Fprintf(&b, "\n- **%s** %s", strings.TrimSpace(c.Change), strings.TrimSpace(c.Impact))
+			if migration := strings.TrimSpace(c.Migration); migration != "" {
+				fmt.Fprintf(&b, " _Migration:_ %s", migration)
+			}
+		}
+		sections = append(sections, b.String())
+	}
+	switch {
+	case labelErr != nil:
+		sections = append(sections, fmt.Sprintf("_I couldn't update the `%s` label: %v_", BreakingChangeLabel, labelErr))
+	case breaking:
+		sections = append(sections, fmt.Sprintf("I labeled this pull request `%s`.", BreakingChangeLabel))
+	}
+	if len(skipped) > 0 {
+		sections = append(sections, fmt.Sprintf("_I couldn't compare the API of `%s`; check them by hand._", strings.Join(skipped, "`, `")))
+	}
+	return strings.Join(sections, "\n\n")
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const baseCSV = `package export
+
+import "io"
+
+// Writer writes CSV records.
+type Writer struct {
+	Comma rune
+	out   io.Writer
+}
+
+const MaxRows = 10000
+
+// Source provides the rows of a report.
+type Source interface {
+	Rows() [][]string
+}
+
+func NewWriter(w io.Writer) *Writer { return &Writer{out: w} }
+
+func (w *Writer) Write(record []string) error { return nil }
+
+func (w *Writer) Flush() error { return nil }
+
+func helper() {}
+`
+
+const headCSV = `package export
+
+import "io"
+
+// Writer writes CSV records.
+type Writer struct {
+	Comma  rune
+	Header bool
+	out    io.Writer
+}
+
+const MaxRows = 50000
+
+// Source provides the rows of a report.
+type Source interface {
+	Rows() [][]string
+	Close() error
+}
+
+func NewWriter(out io.Writer, header bool) *Writer { return &Writer{out: out, Header: header} }
+
+func (w *Writer) Write(row []string) error { return nil }
+
+func Encode(rows [][]string) string { return "" }
+`
+
+// seedPullFiles adds pull request number, changing files from their
+// content at base to their content at head. An empty content adds or
+// removes the file.
+func seedPullFiles(env *testEnv, number int, files map[string][2]string) {
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	pr := env.github.pulls[number-1]
+	pr.Base = &github.PullRequestBranch{SHA: github.String("base0")}
+	pr.Head = &github.PullRequestBranch{SHA: github.String(testHeadSHA)}
+	for name, content := range files {
+		status := "modified"
+		switch {
+		case content[0] == "":
+			status = "added"
+		case content[1] == "":
+			status = "removed"
+		}
+		env.github.refFiles["acme/widgets@base0/"+name] = content[0]
+		env.github.refFiles["acme/widgets@"+testHeadSHA+"/"+name] = content[1]
+		env.github.pullFiles[number] = append(env.github.pullFiles[number], &github.CommitFile{
+			Filename: github.String(name), Status: github.String(status), Patch: github.String("@@ -1 +1 @@\n-" + name),
+		})
+	}
+}
+
+func TestCheckBreakingLabelsPullRequest(t *testing.T) {
+	env := newTestEnv(t)
+	number := env.github.addPull("acme", "widgets", "Streamed CSV exports")
+	seedPullFiles(env, number, map[string][2]string{
+		"export/csv.go":      {baseCSV, headCSV},
+		"export/csv_test.go": {"package export\n\nfunc TestOld() {}", "package export"},
+		"internal/rows.go":   {"package rows\n\nfunc Rows() {}", "package rows"},
+		"cmd/report/main.go": {"package main\n\nfunc Run() {}", "package main"},
+	})
+	env.gemini.on("Review this pull request for breaking changes", "```json\n"+
+		`{"breaking_changes": [{"change": "MaxRows is now 50000.", "impact": "Exports can be five times larger.", "migration": "Pass a row limit to keep the old size."}]}`+"\n```")
+
+	env.commentOnPull(t, number, "@prd-bot check_breaking")
+
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 {
+		t.Fatalf("expected one comment, got %d", len(comments))
+	}
+	want := BreakingChangesIdentifier + "\n\n" +
+		"**Exported Go API** (compared with the base branch):\n" +
+		"- `NewWriter` in `export`: changed from `func NewWriter(io.Writer) *Writer` to `func NewWriter(io.Writer, bool) *Writer`\n" +
+		"- `Source.Close` in `export`: `method Source.Close() error` added to an interface, which implementations outside the package don't have\n" +
+		"- `Writer.Flush` in `export`: removed (was `func (*Writer) Flush() error`)\n\n" +
+		"**Other breaking changes:**\n" +
+		"- **MaxRows is now 50000.** Exports can be five times larger. _Migration:_ Pass a row limit to keep the old size.\n\n" +
+		"I labeled this pull request `breaking-change`."
+	if body := comments[0].GetBody(); body != want {
+		t.Errorf("unexpected comment:\n%s\nwant:\n%s", body, want)
+	}
+	if labels := env.github.issueLabels("acme", "widgets", number); !slices.Equal(labels, []string{BreakingChangeLabel}) {
+		t.Errorf("labels = %q", labels)
+	}
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "- `Writer.Flush` in `export`: removed") || !strings.Contains(prompt, "Streamed CSV exports") {
+		t.Errorf("the prompt should carry the API changes and the pull request:\n%s", prompt)
+	}
+}
+
+func TestCheckBreakingRemovesStaleLabel(t *testing.T) {
+	env := newTestEnv(t)
+	number := env.github.addPull("acme", "widgets", "Fix a typo")
+	seedPullFiles(env, number, map[string][2]string{"README.md": {"Wigdets", "Widgets"}})
+	env.github.labels["acme/widgets#1"] = []string{"docs", BreakingChangeLabel}
+	env.gemini.on("Review this pull request for breaking changes", `{"breaking_changes": []}`)
+	issue := &github.Issue{
+		Number:           github.Int(number),
+		Labels:           []*github.Label{{Name: github.String("docs")}, {Name: github.String(BreakingChangeLabel)}},
+		PullRequestLinks: &github.PullRequestLinks{URL: github.String("https://api.github.com/repos/acme/widgets/pulls/1")},
+	}
+	repo := &github.Repository{Name: github.String("widgets"), FullName: github.String("acme/widgets"), Owner: &github.User{Login: github.String("acme")}}
+	client, _ := env.github.Client(7)
+
+	env.bot.processCheckBreaking(context.Background(), client, issue, repo, 7, nil)
+
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || comments[0].GetBody() != BreakingChangesIdentifier+"\n\nI found no breaking changes in this pull request." {
+		t.Errorf("unexpected comments: %v", comments)
+	}
+	if labels := env.github.issueLabels("acme", "widgets", number); !slices.Equal(labels, []string{"docs"}) {
+		t.Errorf("the stale label should be removed: %q", labels)
+	}
+}
+
+func TestCheckBreakingOnIssue(t *testing.T) {
+	env := newTestEnv(t)
+	env.comment(t, "@prd-bot check_breaking")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "works on pull requests") {
+		t.Errorf("unexpected comments: %v", comments)
+	}
+	if len(env.gemini.receivedPrompts()) != 0 {
+		t.Error("nothing should be sent to the model")
+	}
+}
+
+func TestParseGoAPIGenerics(t *testing.T) {
+	api, err := parseGoAPI("set.go", `package set
+
+type Set[T comparable] struct{ items map[T]struct{} }
+
+func (s *Set[T]) Add(items ...T) {}
+
+func Of[T comparable](items ...T) *Set[T] { return nil }
+
+type Alias = Set[string]
+
+var Default, custom = 1, 2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := goAPI{
+		"Set":     "type Set[comparable] struct",
+		"Set.Add": "func (*Set) Add(...T)",
+		"Of":      "func Of[comparable](...T) *Set[T]",
+		"Alias":   "type Alias = Set[string]",
+		"Default": "var Default",
+	}
+	if len(api) != len(want) {
+		t.Errorf("api = %q, want %q", api, want)
+	}
+	for name, decl := range want {
+		if api[name] != decl {
+			t.Errorf("api[%s] = %q, want %q", name, api[name], decl)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandBudget shows the installation's model usage this month against
+	// its budget: `budget`, or `budget override` for repository admins to
+	// lift an exceeded budget until the end of the month.
+	CommandBudget = "budget"
+
+	// bucketSpend holds monthlySpend documents keyed by
+	// "<installation id>/<YYYY-MM>".
+	bucketSpend = "spend"
+)
+
+// budgetExemptCommands run even when the installation is over its budget:
+// they don't generate, and api_key is how an installation moves its
+// generations to its own key.
+var budgetExemptCommands = []string{CommandBudget, CommandAPIKey, CommandPurgeData, CommandFeedback, CommandRun, CommandApprove}
+
+// BudgetConfig caps the model usage each installation may bill to the
+// operator's key every calendar month (UTC). Usage on an installation's own
+// API key doesn't count. Zero limits are unlimited.
+type BudgetConfig struct {
+	MonthlyTokens int64   `yaml:"monthly_tokens"`
+	MonthlyCost   float64 `yaml:"monthly_cost"`
+	// InputPrice and OutputPrice are the model's prices in USD per million
+	// prompt and response tokens, used to estimate the cost.
+	InputPrice  float64 `yaml:"input_price"`
+	OutputPrice float64 `yaml:"output_price"`
+	// Installations replaces the limits above for some installations.
+	Installations map[int64]BudgetLimit `yaml:"installations"`
+}
+
+// BudgetLimit is the monthly budget of an installation.
+type BudgetLimit struct {
+	MonthlyTokens int64   `yaml:"monthly_tokens"`
+	MonthlyCost   float64 `yaml:"monthly_cost"`
+}
+
+func (c BudgetConfig) validate() error {
+	limits := map[string]BudgetLimit{"budget": {c.MonthlyTokens, c.MonthlyCost}}
+	for id, limit := range c.Installations {
+		limits[fmt.Sprintf("budget.installations.%d", id)] = limit
+	}
+	for name, limit := range limits {
+		if limit.MonthlyTokens < 0 || limit.MonthlyCost < 0 {
+			return fmt.Errorf("%s: limits must not be negative", name)
+		}
+		if limit.MonthlyCost > 0 && c.InputPrice <= 0 && c.OutputPrice <= 0 {
+			return fmt.Errorf("%s: monthly_cost requires budget.input_price or budget.output_price", name)
+		}
+	}
+	if c.InputPrice < 0 || c.OutputPrice < 0 {
+		return errors.New("budget: prices must not be negative")
+	}
+	return nil
+}
+
+// limit returns the budget of an installation.
+func (c BudgetConfig) limit(installationID int64) BudgetLimit {
+	if limit, ok := c.Installations[installationID]; ok {
+		return limit
+	}
+	return BudgetLimit{MonthlyTokens: c.MonthlyTokens, MonthlyCost: c.MonthlyCost}
+}
+
+// cost estimates the price of tokens in USD.
+func (c BudgetConfig) cost(inputTokens, outputTokens int64) float64 {
+	return (float64(inputTokens)*c.InputPrice + float64(outputTokens)*c.OutputPrice) / 1e6
+}
+
+// monthlySpend is the model usage of an installation in a month.
+type monthlySpend struct {
+	InputTokens  int64     `json:"input_tokens"`
+	OutputTokens int64     `json:"output_tokens"`
+	Override     bool      `json:"override,omitempty"` // the budget was lifted for the rest of the month
+	OverrideBy   string    `json:"override_by,omitempty"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (s monthlySpend) tokens() int64 { return s.InputTokens + s.OutputTokens }
+
+func spendKey(installationID int64, month time.Time) string {
+	return strconv.FormatInt(installationID, 10) + "/" + month.UTC().Format("2006-01")
+}
+
+// nextMonth returns the start of the month after t, when budgets reset.
+func nextMonth(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+type tokenMeterKey struct{}
+
+// withTokenMeter returns a context whose model requests report the tokens
+// they use to meter, and to the meters of ctx.
+func withTokenMeter(ctx context.Context, meter func(inputTokens, outputTokens int64)) context.Context {
+	if outer, ok := ctx.Value(tokenMeterKey{}).(func(int64, int64)); ok {
+		inner := meter
+		meter = func(inputTokens, outputTokens int64) {
+			inner(inputTokens, outputTokens)
+			outer(inputTokens, outputTokens)
+		}
+	}
+	return context.WithValue(ctx, tokenMeterKey{}, meter)
+}
+
+// meterTokens reports the tokens a model request made with ctx used.
+func meterTokens(ctx context.Context, inputTokens, outputTokens int64) {
+	if meter, ok := ctx.Value(tokenMeterKey{}).(func(int64, int64)); ok && inputTokens+outputTokens > 0 {
+		meter(inputTokens, outputTokens)
+	}
+}
+
+// updateSpend applies update to this month's spend of an installation.
+func (b *Bot) updateSpend(installationID int64, update func(*monthlySpend)) (monthlySpend, error) {
+	b.spendMu.Lock()
+	defer b.spendMu.Unlock()
+	key := spendKey(installationID, time.Now())
+	var spend monthlySpend
+	if _, err := b.store.Get(bucketSpend, key, &spend); err != nil {
+		return spend, err
+	}
+	update(&spend)
+	spend.UpdatedAt = time.Now().UTC()
+	return spend, b.store.Put(bucketSpend, key, &spend)
+}
+
+// recordSpend adds tokens billed to the operator's key to an installation's
+// spend this month.
+func (b *Bot) recordSpend(installationID int64, inputTokens, outputTokens int64) {
+	_, err := b.updateSpend(installationID, func(s *monthlySpend) {
+		s.InputTokens += inputTokens
+		s.OutputTokens += outputTokens
+	})
+	if err != nil {
+		log.Printf("Error recording the model usage of installation %d: %v", installationID, err)
+	}
+}
+
+// loadSpend returns this month's spend of an installation.
+func (b *Bot) loadSpend(installationID int64) (monthlySpend, error) {
+	var spend monthlySpend
+	_, err := b.store.Get(bucketSpend, spendKey(installationID, time.Now()), &spend)
+	return spend, err
+}
+
+// budgetStatus describes an installation's spend this month against its budget.
+type budgetStatus struct {
+	monthlySpend
+	Limit    BudgetLimit
+	Cost     float64
+	Exceeded bool // the budget is used up and not overridden
+}
+
+func (b *Bot) budgetStatus(installationID int64) (budgetStatus, error) {
+	spend, err := b.loadSpend(installationID)
+	if err != nil {
+		return budgetStatus{}, err
+	}
+	cfg := b.serverConfig().Budget
+	status := budgetStatus{monthlySpend: spend, Limit: cfg.limit(installationID), Cost: cfg.cost(spend.InputTokens, spend.OutputTokens)}
+	overTokens := status.Limit.MonthlyTokens > 0 && spend.tokens() >= status.Limit.MonthlyTokens
+	overCost := status.Limit.MonthlyCost > 0 && status.Cost >= status.Limit.MonthlyCost
+	status.Exceeded = (overTokens || overCost) && !spend.Override
+	return status, nil
+}
+
+// summary describes the usage and limits in a sentence.
+func (s budgetStatus) summary() string {
+	usage := fmt.Sprintf("%d tokens", s.tokens())
+	if s.Limit.MonthlyTokens > 0 {
+		usage += fmt.Sprintf(" of %d", s.Limit.MonthlyTokens)
+	}
+	if s.Cost > 0 || s.Limit.MonthlyCost > 0 {
+		usage += fmt.Sprintf(" (about $%.2f", s.Cost)
+		if s.Limit.MonthlyCost > 0 {
+			usage += fmt.Sprintf(" of $%.2f", s.Limit.MonthlyCost)
+		}
+		usage += ")"
+	}
+	return usage
+}
+
+// checkBudget reports whether an installation may run a generation billed
+// to the operator's key, failing open when its spend can't be read.
+func (b *Bot) checkBudget(ctx context.Context, installationID int64) (budgetStatus, bool) {
+	if modelAPIKey(ctx) != "" {
+		return budgetStatus{}, true
+	}
+	status, err := b.budgetStatus(installationID)
+	if err != nil {
+		log.Printf("Error checking the budget of installation %d: %v", installationID, err)
+		return status, true
+	}
+	return status, !status.Exceeded
+}
+
+// budgetExceededMessage explains why command was declined.
+func (b *Bot) budgetExceededMessage(command string, status budgetStatus) string {
+	return fmt.Sprintf("I can't run `%s`: this installation has used its monthly model budget, %s. The budget resets on %s.\n\n"+
+		"A repository admin can lift it for the rest of the month with `@%s %s override`, or the installation can use its own Google API key with `@%s %s set <key>`.",
+		command, status.summary(), nextMonth(time.Now()).Format(time.DateOnly), b.appName, CommandBudget, b.appName, CommandAPIKey)
+}
+
+// isAdmin reports whether login has the admin role on owner/repo.
+func isAdmin(ctx context.Context, client *github.Client, owner, repo, login string) (bool, error) {
+	level, _, err := client.Repositories.GetPermissionLevel(ctx, owner, repo, login)
+	if err != nil {
+		return false, fmt.Errorf("checking permission of %s on %s/%s: %w", login, owner, repo, err)
+	}
+	return level.GetPermission() == "admin", nil
+}
+
+func (b *Bot) processBudget(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	action := "status"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	switch action {
+	case "status":
+		status, err := b.budgetStatus(installationID)
+		if err != nil {
+			b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "check the budget", "Could not load this month's usage", err)
+			return
+		}
+		msg := fmt.Sprintf("This installation has used %s of the bot's model budget this month. Usage resets on %s.", status.summary(), nextMonth(time.Now()).Format(time.DateOnly))
+		switch {
+		case modelAPIKey(ctx) != "":
+			msg += " Generations currently use this installation's own API key, which doesn't count towards the budget."
+		case status.Override:
+			msg += fmt.Sprintf(" The budget was lifted for the rest of the month by `%s`.", status.OverrideBy)
+		case status.Exceeded:
+			msg += fmt.Sprintf(" The budget is used up: a repository admin can lift it with `@%s %s override`.", b.appName, CommandBudget)
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+	case "override":
+		sender := commandSender(ctx)
+		if sender == nil {
+			return
+		}
+		ok, err := isAdmin(ctx, client, repoOwner, repoName, sender.GetLogin())
+		if err != nil {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I couldn't verify your permissions to run `%s override`. Please try again later.", CommandBudget))
+			return
+		}
+		if !ok {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("@%s, only repository admins can run `%s override`.", sender.GetLogin(), CommandBudget))
+			return
+		}
+		if _, err := b.updateSpend(installationID, func(s *monthlySpend) { s.Override, s.OverrideBy = true, sender.GetLogin() }); err != nil {
+			b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "lift the budget", "Could not save the override", err)
+			return
+		}
+		log.Printf("Installation %d budget lifted by %s until %s.", installationID, sender.GetLogin(), nextMonth(time.Now()).Format(time.DateOnly))
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've lifted this installation's model budget until %s. Usage is still recorded.", nextMonth(time.Now()).Format(time.DateOnly)))
+	default:
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Unknown `%s` action `%s`: expected `status` or `override`.", CommandBudget, action))
+	}
+}
+
+// installationBudget is the API view of an installation's budget.
+type installationBudget struct {
+	InstallationID int64     `json:"installation_id"`
+	Month          string    `json:"month"`
+	InputTokens    int64     `json:"input_tokens"`
+	OutputTokens   int64     `json:"output_tokens"`
+	Cost           float64   `json:"cost"`
+	MonthlyTokens  int64     `json:"monthly_tokens"`
+	MonthlyCost    float64   `json:"monthly_cost"`
+	Override       bool      `json:"override"`
+	Exceeded       bool      `json:"exceeded"`
+	ResetsAt       time.Time `json:"resets_at"`
+}
+
+// handleInstallationBudget serves an installation's usage this month on
+// GET, and lifts (POST) or restores (DELETE) its budget until the end of
+// the month.
+func (b *Bot) handleInstallationBudget(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	installationID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil || installationID <= 0 {
+		http.Error(w, "invalid installation id", http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost || r.Method == http.MethodDelete {
+		override := r.Method == http.MethodPost
+		if _, err := b.updateSpend(installationID, func(s *monthlySpend) {
+			s.Override, s.OverrideBy = override, ""
+			if override {
+				s.OverrideBy = "api"
+			}
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Installation %d budget override set to %t through the API.", installationID, override)
+	}
+	status, err := b.budgetStatus(installationID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(installationBudget{
+		InstallationID: installationID,
+		Month:          time.Now().UTC().Format("2006-01"),
+		InputTokens:    status.InputTokens,
+		OutputTokens:   status.OutputTokens,
+		Cost:           status.Cost,
+		MonthlyTokens:  status.Limit.MonthlyTokens,
+		MonthlyCost:    status.Limit.MonthlyCost,
+		Override:       status.Override,
+		Exceeded:       status.Exceeded,
+		ResetsAt:       nextMonth(time.Now()),
+	}); err != nil {
+		log.Printf("Error encoding budget status: %v", err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestBudgetDeclinesGenerationUntilOverride(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+
+	env.comment(t, "@prd-bot need_sub_task")
+	spend, err := env.bot.loadSpend(7)
+	if err != nil || spend.InputTokens == 0 || spend.OutputTokens == 0 {
+		t.Fatalf("the generation should be metered, got %+v (%v)", spend, err)
+	}
+
+	env.bot.applyServerConfig(&ServerConfig{Budget: BudgetConfig{MonthlyTokens: spend.tokens()}})
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 1 {
+		t.Errorf("the generation should be declined over budget, got %d prompts", len(prompts))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	for _, want := range []string{"used its monthly model budget", fmt.Sprintf("%d tokens of %d", spend.tokens(), spend.tokens()), nextMonth(time.Now()).Format(time.DateOnly), "`@prd-bot budget override`"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the reply should contain %q:\n%s", want, body)
+		}
+	}
+
+	env.comment(t, "@prd-bot budget override")
+	if spend, _ := env.bot.loadSpend(7); spend.Override {
+		t.Fatal("only admins should lift the budget")
+	}
+	env.github.setRole("alice", "admin")
+	env.comment(t, "@prd-bot budget override")
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 2 {
+		t.Errorf("the override should allow generation, got %d prompts", len(prompts))
+	}
+	env.comment(t, "@prd-bot budget")
+	comments = env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "lifted for the rest of the month by `alice`") {
+		t.Errorf("unexpected status:\n%s", body)
+	}
+}
+
+func TestBudgetSkipsAutomaticPRD(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.applyServerConfig(&ServerConfig{Budget: BudgetConfig{MonthlyCost: 1, InputPrice: 1e6}})
+	env.bot.recordSpend(7, 1, 0)
+
+	env.deliverPayload(t, "issues", issuesPayload(t, "opened", nil))
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("no PRD should be generated over budget, got %d prompts", len(prompts))
+	}
+}
+
+// exceedBudget puts an installation over its monthly budget.
+func exceedBudget(env *testEnv, installationID int64) {
+	env.bot.applyServerConfig(&ServerConfig{Budget: BudgetConfig{MonthlyCost: 1, InputPrice: 1e6}})
+	env.bot.recordSpend(installationID, 1, 0)
+}
+
+func TestBudgetSkipsPlanAutoProceed(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "plan_preview:\n  enabled: true\n  auto_proceed_after: 2h\n")
+	issue := env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.mu.Lock()
+	issue.Body = github.String("Files: export/csv.go")
+	env.github.mu.Unlock()
+	env.gemini.on("Write a step-by-step implementation plan", testPlan)
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+	exceedBudget(env, 7)
+
+	env.bot.proceedDuePlans(context.Background(), time.Now().Add(3*time.Hour))
+	env.bot.jobs.Wait()
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("the plan shouldn't be implemented over budget, got %d pull requests", len(pulls))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "used its monthly model budget") {
+		t.Errorf("expected a budget reply, got:\n%s", body)
+	}
+}
+
+func TestBudgetKeepsWizardAnswer(t *testing.T) {
+	env := newTestEnv(t)
+	env.comment(t, "@prd-bot wizard")
+	for _, answer := range []string{"Analysts re-type reports", "Finance analysts, daily", "Must ship by Q3"} {
+		env.comment(t, answer)
+	}
+	exceedBudget(env, 7)
+
+	env.comment(t, "No more re-typing")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("no PRD should be generated over budget, got %d prompts", len(prompts))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "used its monthly model budget") || !strings.Contains(body, "reply with your last answer again") {
+		t.Errorf("expected a budget reply, got:\n%s", body)
+	}
+	if !env.bot.wizardActive("acme", "widgets", 42) {
+		t.Error("the wizard session should be kept so the last answer can be sent again")
+	}
+}
+
+func TestBudgetSkipsOnboarding(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.addIssue("acme", "widgets", 10, "Reporting epic", "open")
+	env.github.addComment("acme", "widgets", 10, PRDIdentifier+"\n\nReports PRD")
+	env.github.setParent("acme", "widgets", 42, 10)
+	exceedBudget(env, 7)
+
+	env.assign(t, "bob")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("no checklist should be generated over budget, got %d prompts", len(prompts))
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Errorf("expected no onboarding comment, got %d", len(comments))
+	}
+}
+
+func TestBudgetDeclinesReviewCommentQuestion(t *testing.T) {
+	env := newTestEnv(t)
+	seedBotPull(t, env)
+	exceedBudget(env, 1)
+	payload, err := json.Marshal(map[string]any{
+		"action":       "created",
+		"installation": map[string]any{"id": 1},
+		"repository":   map[string]any{"name": "widgets", "full_name": "acme/widgets", "owner": map[string]any{"login": "acme"}},
+		"pull_request": map[string]any{"number": 7},
+		"sender":       map[string]any{"login": "alice"},
+		"comment":      map[string]any{"id": 55, "body": "@prd-bot why this line?", "path": "export/csv.go"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.deliverPayload(t, "pull_request_review_comment", payload)
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("no answer should be generated over budget, got %d prompts", len(prompts))
+	}
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	if len(env.github.reviewComments) != 1 || !strings.Contains(env.github.reviewComments[0].GetBody(), "used its monthly model budget") {
+		t.Errorf("expected a budget reply in the thread, got %+v", env.github.reviewComments)
+	}
+}
+
+func TestBudgetSkipsRebaseRegeneration(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git rev-parse HEAD": "abc123\n"}
+	number := openBotPull(t, env)
+	env.runner.failOn = "git rebase origin/main"
+	exceedBudget(env, 7)
+
+	env.deliver(t, "push", "push_main.json")
+
+	if executed := strings.Join(env.runner.executed(), "\n"); strings.Contains(executed, "gemini ") || strings.Contains(executed, "git push") {
+		t.Errorf("the branch shouldn't be regenerated over budget, ran:\n%s", executed)
+	}
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "monthly model budget") {
+		t.Errorf("expected a manual resolution comment, got %+v", comments)
+	}
+}
+
+func TestBudgetIgnoresInstallationKey(t *testing.T) {
+	env := newSecretsEnv(t)
+	if err := env.bot.setInstallationAPIKey(7, "AIza-tenant-1234", "alice"); err != nil {
+		t.Fatal(err)
+	}
+	env.bot.applyServerConfig(&ServerConfig{Budget: BudgetConfig{MonthlyTokens: 1}})
+	env.bot.recordSpend(7, 5, 5)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 1 {
+		t.Errorf("generations on the installation's key shouldn't be capped, got %d prompts", len(prompts))
+	}
+	if spend, _ := env.bot.loadSpend(7); spend.tokens() != 10 {
+		t.Errorf("generations on the installation's key shouldn't be metered, got %+v", spend)
+	}
+}
+
+func TestInstallationBudgetAPI(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	env.bot.applyServerConfig(&ServerConfig{Budget: BudgetConfig{MonthlyTokens: 100, Installations: map[int64]BudgetLimit{9: {MonthlyTokens: 10}}}})
+	env.bot.recordSpend(9, 8, 4)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /installations/{id}/budget", env.bot.handleInstallationBudget)
+	mux.HandleFunc("POST /installations/{id}/budget", env.bot.handleInstallationBudget)
+	mux.HandleFunc("DELETE /installations/{id}/budget", env.bot.handleInstallationBudget)
+	call := func(method string) string {
+		req := httptest.NewRequest(method, "/installations/9/budget", nil)
+		req.Header.Set("Authorization", "Bearer s3cret")
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s returned %d %s", method, rec.Code, rec.Body.String())
+		}
+		return rec.Body.String()
+	}
+
+	if body := call(http.MethodGet); !strings.Contains(body, `"input_tokens":8`) || !strings.Contains(body, `"monthly_tokens":10`) || !strings.Contains(body, `"exceeded":true`) {
+		t.Errorf("GET returned %s", body)
+	}
+	if body := call(http.MethodPost); !strings.Contains(body, `"override":true`) || !strings.Contains(body, `"exceeded":false`) {
+		t.Errorf("POST returned %s", body)
+	}
+	if body := call(http.MethodDelete); !strings.Contains(body, `"exceeded":true`) {
+		t.Errorf("DELETE returned %s", body)
+	}
+}
+
+func TestBudgetConfigValidation(t *testing.T) {
+	for _, cfg := range []BudgetConfig{
+		{MonthlyTokens: -1},
+		{MonthlyCost: 5},
+		{InputPrice: -1},
+		{InputPrice: 1, Installations: map[int64]BudgetLimit{9: {MonthlyCost: -2}}},
+	} {
+		if err := cfg.validate(); err == nil {
+			t.Errorf("expected %+v to be invalid", cfg)
+		}
+	}
+	if err := (BudgetConfig{MonthlyCost: 5, InputPrice: 0.075, OutputPrice: 0.3}).validate(); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
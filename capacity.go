@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandCapacityPlan = "need_capacity_plan"
+
+	// CapacityPlanIdentifier marks comments produced by the
+	// need_capacity_plan command.
+	CapacityPlanIdentifier = "### Capacity & Performance Considerations"
+
+	ArtifactCapacityPlan = "capacity_plan"
+
+	// capacityAppendixHeading starts the appendix added to the PRD with
+	// `--appendix`.
+	capacityAppendixHeading = prdAppendixPrefix + " Capacity & Performance"
+)
+
+// processCapacityPlan posts the capacity and performance considerations of
+// the feature described by the PRD: expected load, storage growth and how it
+// scales. With `--appendix` the plan is also added to the PRD, replacing an
+// earlier appendix.
+func (b *Bot) processCapacityPlan(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandCapacityPlan, issueNum, repoOwner, repoName)
+
+	prdComment, err := b.findPRDComment(ctx, client, repoOwner, repoName, issueNum)
+	if err != nil || prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting capacity plan.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to plan capacity for. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	doc, ok := parsePRDDocument(prdComment.GetBody())
+	prd := prdComment.GetBody()
+	if ok {
+		prd = withoutCapacityAppendix(doc.English)
+	}
+
+	plan, err := generateCapacityPlan(ctx, b.llm, issue, prd)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "plan capacity", "Could not generate the capacity plan", err)
+		return
+	}
+
+	body := CapacityPlanIdentifier + "\n\n" + plan
+	if slices.Contains(args, "--appendix") {
+		if !ok {
+			body += "\n\n_I couldn't add this plan to the PRD because its comment isn't in the format I generate._"
+		} else {
+			doc.English = withoutCapacityAppendix(doc.English) + "\n\n" + capacityAppendixHeading + "\n\n" + plan + "\n"
+			doc.revise("Added the capacity plan appendix.")
+			prdBody := doc.String()
+			if edited, _, err := client.Issues.EditComment(ctx, repoOwner, repoName, prdComment.GetID(), &github.IssueComment{Body: github.String(b.stampArtifact(ctx, ArtifactPRD, prdBody))}); err != nil {
+				log.Printf("Error adding the capacity appendix to the PRD of issue #%d: %v", issueNum, err)
+				body += "\n\n_I couldn't add this plan to the PRD._"
+			} else {
+				b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, prdBody, edited)
+				body += "\n\n_Added to the PRD as an appendix._"
+			}
+		}
+	}
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactCapacityPlan, body)
+	b.saveArtifact(ArtifactCapacityPlan, repoOwner, repoName, issue, body, comment)
+}
+
+// withoutCapacityAppendix removes the capacity appendix from a PRD.
+func withoutCapacityAppendix(prd string) string {
+	return withoutPRDAppendix(prd, capacityAppendixHeading)
+}
+
+func generateCapacityPlan(ctx context.Context, llm Generator, issue *github.Issue, prd string) (string, error) {
+	prompt := fmt.Sprintf(
+		"Estimate the capacity and performance needs of the feature described in the following Product Requirements Document (PRD).\n\n"+
+			"Start with one line rating the infrastructure impact: `**Infrastructure Impact:** High`, `Medium` or `Low`, followed by a one-sentence reason. When the impact is Low, say so briefly under each heading instead of inventing load.\n\n"+
+			"Then format the output as GitHub-flavored Markdown under these headings:\n"+
+			"1.  **Expected Load:** (Requests per second at launch and at peak, read/write mix, batch or background jobs; state the assumptions behind each number)\n"+
+			"2.  **Storage Growth:** (New data per day and after one year, retention, indexes and backups)\n"+
+			"3.  **Scaling Strategy:** (Caching, horizontal scaling, partitioning, queues and rate limits)\n"+
+			"4.  **Bottlenecks & Risks:** (Hot spots, dependencies and failure modes under load)\n"+
+			"5.  **Load Testing & Monitoring:** (Load tests to run before launch, the metrics and alert thresholds to watch)\n\n"+
+			"Give ranges rather than single numbers when the PRD doesn't state the audience size.\n\n"+
+			"**Issue Title:** %s\n\n"+
+			"**Here is the PRD:**\n%s",
+		issue.GetTitle(), prd,
+	)
+	plan, err := generateMarkdown(withSystemPrompt(ctx, CommandCapacityPlan), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate capacity plan: %w", err)
+	}
+	return strings.TrimSpace(plan), nil
+}
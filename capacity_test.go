@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const capacityPRD = PRDIdentifier + prdSeparator + "1.  **Background:** Users export reports as CSV.\n\n5.  **Success Metrics:** 100 exports a day."
+
+func TestCapacityPlan(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Estimate the capacity and performance needs", "**Infrastructure Impact:** Medium — exports read every report row.\n\n1.  **Expected Load:** 1-5 QPS.")
+
+	env.comment(t, "@prd-bot need_capacity_plan")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "Users export reports as CSV.") || !strings.Contains(prompt, "Export reports as CSV") {
+		t.Errorf("prompt should include the issue and the PRD:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	if !strings.HasPrefix(body, CapacityPlanIdentifier) || !strings.Contains(body, "1-5 QPS") || strings.Contains(body, "appendix") {
+		t.Errorf("unexpected capacity plan comment:\n%s", body)
+	}
+	if comments[0].GetBody() != capacityPRD {
+		t.Errorf("the PRD should be left alone without --appendix:\n%s", comments[0].GetBody())
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactCapacityPlan); artifact == nil || artifact.Markdown != stripArtifactMeta(body) {
+		t.Errorf("capacity plan artifact = %+v", artifact)
+	}
+}
+
+func TestCapacityPlanAppendix(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Estimate the capacity and performance needs", "1.  **Expected Load:** 1-5 QPS.")
+
+	env.comment(t, "@prd-bot need_capacity_plan --appendix")
+	env.gemini.mu.Lock()
+	env.gemini.rules = nil
+	env.gemini.mu.Unlock()
+	env.gemini.on("Estimate the capacity and performance needs", "1.  **Expected Load:** 10 QPS.")
+	env.comment(t, "@prd-bot need_capacity_plan --appendix")
+
+	if prompt := env.gemini.receivedPrompts()[1]; strings.Contains(prompt, "1-5 QPS") {
+		t.Errorf("the earlier appendix shouldn't be part of the PRD sent to the model:\n%s", prompt)
+	}
+	prd := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	if strings.Count(prd, capacityAppendixHeading) != 1 || !strings.Contains(prd, "10 QPS") || strings.Contains(prd, "1-5 QPS") {
+		t.Errorf("expected one up-to-date appendix in the PRD:\n%s", prd)
+	}
+	doc, _ := parsePRDDocument(prd)
+	if metrics, _ := prdSectionContent(doc.English, 4, false); metrics != "100 exports a day." {
+		t.Errorf("the appendix shouldn't become part of the last section, got %q", metrics)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD); artifact == nil || artifact.Markdown != stripArtifactMeta(prd) {
+		t.Errorf("the PRD artifact should hold the appendix, got %+v", artifact)
+	}
+}
+
+func TestWithoutCapacityAppendixKeepsSchemaLink(t *testing.T) {
+	prd := "1.  **Background:** B.\n\n" + capacityAppendixHeading + "\n\nLoad.\n\n" + analyticsSchemaLinkPrefix + " [`schema.json`](url)\n"
+	want := "1.  **Background:** B.\n\n" + analyticsSchemaLinkPrefix + " [`schema.json`](url)"
+	if got := withoutCapacityAppendix(prd); got != want {
+		t.Errorf("withoutCapacityAppendix = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Faults CHAOS can inject.
+const (
+	faultGeminiTimeout = "gemini_timeout" // Gemini API requests and gemini CLI runs time out
+	faultGitHub502     = "github_502"     // GitHub API requests answer 502 Bad Gateway
+	faultGit           = "git"            // git commands fail
+)
+
+var chaosFaults = []string{faultGeminiTimeout, faultGitHub502, faultGit}
+
+var chaosFaultsTotal = newCounterVec("agent_prd_chaos_faults_total", "Faults injected by CHAOS by fault.", "fault")
+
+// faultInjector makes a share of the bot's calls to Gemini, GitHub and git
+// fail, so operators can watch the retries, the dead letter queue and the
+// failure comments work before going to production. A nil injector injects
+// nothing.
+type faultInjector struct {
+	rates map[string]float64 // the share of calls failed, by fault
+	rand  func() float64
+}
+
+// parseChaos parses CHAOS, a comma-separated list of fault=rate pairs where
+// rate is the share of calls failed from 0 to 1, e.g.
+// "gemini_timeout=0.5,github_502=0.1,git=1". A fault without a rate always
+// fires. It returns nil when spec is empty.
+func parseChaos(spec string) (*faultInjector, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	f := &faultInjector{rates: make(map[string]float64), rand: rand.Float64}
+	for _, entry := range strings.Split(spec, ",") {
+		name, raw, hasRate := strings.Cut(strings.TrimSpace(entry), "=")
+		name = strings.TrimSpace(name)
+		if !slices.Contains(chaosFaults, name) {
+			return nil, fmt.Errorf("unknown fault %q: expected %s", name, strings.Join(chaosFaults, ", "))
+		}
+		rate := 1.0
+		if hasRate {
+			var err error
+			if rate, err = strconv.ParseFloat(strings.TrimSpace(raw), 64); err != nil || rate < 0 || rate > 1 {
+				return nil, fmt.Errorf("%s: %q is not a rate between 0 and 1", name, raw)
+			}
+		}
+		f.rates[name] = rate
+	}
+	return f, nil
+}
+
+// String describes the faults injected, for the startup log.
+func (f *faultInjector) String() string {
+	var faults []string
+	for _, name := range chaosFaults {
+		if rate, ok := f.rates[name]; ok {
+			faults = append(faults, fmt.Sprintf("%s %g%%", name, rate*100))
+		}
+	}
+	return strings.Join(faults, ", ")
+}
+
+// inject reports whether this call should fail with fault, counting it.
+func (f *faultInjector) inject(fault string) bool {
+	if f == nil {
+		return false
+	}
+	rate, ok := f.rates[fault]
+	if !ok || rate == 0 || f.rand() >= rate {
+		return false
+	}
+	chaosFaultsTotal.Inc(fault)
+	log.Printf("CHAOS: injecting %s", fault)
+	return true
+}
+
+// githubTransport returns base answering the requests CHAOS fails with 502
+// Bad Gateway, the way GitHub does during an outage.
+func (f *faultInjector) githubTransport(base http.RoundTripper) http.RoundTripper {
+	if f == nil {
+		return base
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !f.inject(faultGitHub502) {
+			return base.RoundTrip(req)
+		}
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return &http.Response{
+			Status:     "502 Bad Gateway",
+			StatusCode: http.StatusBadGateway,
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"message":"Server Error (injected by CHAOS)"}`)),
+			Request:    req,
+		}, nil
+	})
+}
+
+// geminiTransport returns base timing out the requests CHAOS fails.
+func (f *faultInjector) geminiTransport(base http.RoundTripper) http.RoundTripper {
+	if f == nil {
+		return base
+	}
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if !f.inject(faultGeminiTimeout) {
+			return base.RoundTrip(req)
+		}
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, chaosTimeoutError{}
+	})
+}
+
+// runner returns run failing the git and gemini commands CHAOS fails.
+func (f *faultInjector) runner(run CommandRunner) CommandRunner {
+	if f == nil {
+		return run
+	}
+	return func(dir, name string, args ...string) (string, error) {
+		switch {
+		case name == "git" && f.inject(faultGit):
+			return "fatal: unable to access the remote: The requested URL returned error: 502 (injected by CHAOS)", fmt.Errorf("exit status 128")
+		case name == "gemini" && f.inject(faultGeminiTimeout):
+			return "Request timed out (injected by CHAOS)", fmt.Errorf("exit status 1")
+		}
+		return run(dir, name, args...)
+	}
+}
+
+// chaosTimeoutError is the network timeout of an injected Gemini timeout.
+type chaosTimeoutError struct{}
+
+func (chaosTimeoutError) Error() string   { return "i/o timeout (injected by CHAOS)" }
+func (chaosTimeoutError) Timeout() bool   { return true }
+func (chaosTimeoutError) Temporary() bool { return true }
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (fn roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return fn(req) }
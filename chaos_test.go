@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestParseChaos(t *testing.T) {
+	f, err := parseChaos(" gemini_timeout=0.25, github_502 ,git=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{faultGeminiTimeout: 0.25, faultGitHub502: 1, faultGit: 0}
+	for fault, rate := range want {
+		if f.rates[fault] != rate {
+			t.Errorf("rate of %s = %g, want %g", fault, f.rates[fault], rate)
+		}
+	}
+	if got := f.String(); got != "gemini_timeout 25%, github_502 100%, git 0%" {
+		t.Errorf("String() = %q", got)
+	}
+	if f, err := parseChaos(""); f != nil || err != nil {
+		t.Errorf("an empty CHAOS should inject nothing, got %v, %v", f, err)
+	}
+	for _, spec := range []string{"disk_full", "git=2", "git=often"} {
+		if _, err := parseChaos(spec); err == nil {
+			t.Errorf("parseChaos(%q) should fail", spec)
+		}
+	}
+}
+
+func TestFaultInjectorRates(t *testing.T) {
+	f, _ := parseChaos("git=0.5")
+	roll := 0.0
+	f.rand = func() float64 { return roll }
+	for _, tt := range []struct {
+		roll  float64
+		fault string
+		want  bool
+	}{
+		{0.49, faultGit, true},
+		{0.5, faultGit, false},
+		{0, faultGitHub502, false},
+	} {
+		roll = tt.roll
+		if got := f.inject(tt.fault); got != tt.want {
+			t.Errorf("inject(%s) with roll %g = %v, want %v", tt.fault, tt.roll, got, tt.want)
+		}
+	}
+	var none *faultInjector
+	if none.inject(faultGit) {
+		t.Error("a nil injector should inject nothing")
+	}
+}
+
+func TestChaosGitHub502(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeJSON(w, http.StatusOK, map[string]string{"login": "octo-bot"})
+	}))
+	defer srv.Close()
+	f, _ := parseChaos("github_502")
+	client := github.NewClient(&http.Client{Transport: f.githubTransport(http.DefaultTransport)})
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	_, resp, err := client.Users.Get(context.Background(), "")
+	var ghErr *github.ErrorResponse
+	if !errors.As(err, &ghErr) || resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected a 502 error response, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("the failed request should not reach GitHub")
+	}
+}
+
+func TestChaosGeminiTimeout(t *testing.T) {
+	f, _ := parseChaos("gemini_timeout")
+	client := &http.Client{Transport: f.geminiTransport(http.DefaultTransport)}
+	_, err := client.Get("http://127.0.0.1:1/v1beta/models")
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Errorf("expected a timeout, got %v", err)
+	}
+}
+
+func TestImplementFeatureUnderChaos(t *testing.T) {
+	env := newTestEnv(t)
+	f, _ := parseChaos("git")
+	env.bot.runner = f.runner(env.runner.run)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if executed := env.runner.executed(); slices.ContainsFunc(executed, func(c string) bool { return strings.HasPrefix(c, "git ") }) {
+		t.Errorf("failed git commands should not run, ran:\n%s", strings.Join(executed, "\n"))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if !slices.ContainsFunc(comments, func(c *github.IssueComment) bool { return strings.Contains(c.GetBody(), "`CLONE_FAILED`") }) {
+		t.Error("the injected git failure should be reported on the issue")
+	}
+	if len(env.github.pullRequests()) != 0 {
+		t.Error("no pull request should be opened")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// citationInstructions asks the model to ground its answer in the numbered
+// source files it was given.
+const citationInstructions = "**Citations:** Ground every technical claim in the source files above. " +
+	"After each claim, cite the lines you relied on as `[[cite:<path>#L<start>-L<end>]]` (or `[[cite:<path>#L<line>]]` for a single line), " +
+	"using the paths and line numbers shown. Do not cite files or lines that are not shown.\n"
+
+// citationPattern matches the citation markers requested by citationInstructions.
+var citationPattern = regexp.MustCompile(`\[\[cite:([^\]#]+)#L(\d+)(?:-L(\d+))?\]\]`)
+
+// citation is a verified reference to a line range of a repository file.
+type citation struct {
+	Path  string
+	Start int
+	End   int
+}
+
+func (c citation) anchor() string {
+	if c.Start == c.End {
+		return fmt.Sprintf("L%d", c.Start)
+	}
+	return fmt.Sprintf("L%d-L%d", c.Start, c.End)
+}
+
+// numberLines prefixes every line of content with its 1-based line number so
+// the model can cite exact ranges.
+func numberLines(content string) string {
+	lines := strings.Split(strings.TrimSuffix(content, "\n"), "\n")
+	var b strings.Builder
+	for i, line := range lines {
+		fmt.Fprintf(&b, "%d: %s\n", i+1, line)
+	}
+	return b.String()
+}
+
+// resolveCommitSHA returns the commit SHA ref currently points at, so files
+// and permalinks refer to the same immutable snapshot.
+func resolveCommitSHA(ctx context.Context, client *github.Client, owner, repo, ref string) (string, error) {
+	sha, _, err := client.Repositories.GetCommitSHA1(ctx, owner, repo, ref, "")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(sha), nil
+}
+
+// headCommit returns the commit SHA of the repository's default branch,
+// falling back to the branch name when it can't be resolved.
+func (b *Bot) headCommit(ctx context.Context, client *github.Client, repo *github.Repository) string {
+	ref := repo.GetDefaultBranch()
+	sha, err := resolveCommitSHA(ctx, client, repo.GetOwner().GetLogin(), repo.GetName(), ref)
+	if err != nil {
+		log.Printf("Could not resolve %s of %s, citing the branch instead: %v", ref, repo.GetFullName(), err)
+		return ref
+	}
+	return sha
+}
+
+// repoWebURL returns the browser URL of the repository.
+func (b *Bot) repoWebURL(repo *github.Repository) string {
+	if u := repo.GetHTMLURL(); u != "" {
+		return u
+	}
+	return fmt.Sprintf("https://%s/%s/%s", b.gitHost, repo.GetOwner().GetLogin(), repo.GetName())
+}
+
+// renderCitations replaces the model's citation markers with permalinks to
+// the cited lines at sha and appends a list of sources. Citations of files
+// that were not provided, or of lines outside them, are shown as plain text
+// and reported in a note.
+func renderCitations(text, repoURL, sha string, files []repoFile) string {
+	lineCounts := make(map[string]int, len(files))
+	for _, f := range files {
+		lineCounts[f.Path] = strings.Count(strings.TrimSuffix(f.Content, "\n"), "\n") + 1
+	}
+
+	var sources []citation
+	seen := make(map[citation]bool)
+	unverified := 0
+	rendered := citationPattern.ReplaceAllStringFunc(text, func(marker string) string {
+		m := citationPattern.FindStringSubmatch(marker)
+		c := citation{Path: strings.TrimSpace(m[1])}
+		c.Start, _ = strconv.Atoi(m[2])
+		c.End = c.Start
+		if m[3] != "" {
+			c.End, _ = strconv.Atoi(m[3])
+		}
+		if n, ok := lineCounts[c.Path]; !ok || c.Start < 1 || c.End < c.Start || c.End > n {
+			unverified++
+			return fmt.Sprintf("(`%s#%s`, unverified)", c.Path, c.anchor())
+		}
+		if !seen[c] {
+			seen[c] = true
+			sources = append(sources, c)
+		}
+		return fmt.Sprintf("([`%s#%s`](%s))", c.Path, c.anchor(), permalink(repoURL, sha, c))
+	})
+
+	var b strings.Builder
+	b.WriteString(rendered)
+	if len(sources) > 0 {
+		shortSHA := sha[:min(len(sha), 7)]
+		fmt.Fprintf(&b, "\n\n**Sources** (at `%s`):\n", shortSHA)
+		for _, c := range sources {
+			fmt.Fprintf(&b, "- [`%s#%s`](%s)\n", c.Path, c.anchor(), permalink(repoURL, sha, c))
+		}
+	} else {
+		b.WriteString("\n\n_The model did not cite any repository lines; verify this answer against the code._")
+	}
+	if unverified > 0 {
+		fmt.Fprintf(&b, "\n_%d citation(s) referred to files or lines that were not provided and are not linked._", unverified)
+	}
+	return b.String()
+}
+
+func permalink(repoURL, sha string, c citation) string {
+	return fmt.Sprintf("%s/blob/%s/%s#%s", repoURL, sha, c.Path, c.anchor())
+}
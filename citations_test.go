@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderCitations(t *testing.T) {
+	files := []repoFile{{Path: "pkg/auth/login.go", Content: "package auth\n\nfunc Login() {}\n"}}
+	text := "Login is defined here [[cite:pkg/auth/login.go#L3]] in package auth [[cite:pkg/auth/login.go#L1-L3]]. " +
+		"It calls the token store [[cite:pkg/auth/token.go#L5-L9]] and [[cite:pkg/auth/login.go#L2-L40]]."
+
+	got := renderCitations(text, "https://github.com/acme/widgets", testHeadSHA, files)
+
+	for _, want := range []string{
+		"([`pkg/auth/login.go#L3`](https://github.com/acme/widgets/blob/" + testHeadSHA + "/pkg/auth/login.go#L3))",
+		"([`pkg/auth/login.go#L1-L3`](https://github.com/acme/widgets/blob/" + testHeadSHA + "/pkg/auth/login.go#L1-L3))",
+		"(`pkg/auth/token.go#L5-L9`, unverified)",
+		"(`pkg/auth/login.go#L2-L40`, unverified)",
+		"**Sources** (at `3f2c1e0`):",
+		"_2 citation(s) referred to files or lines that were not provided",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("rendered text is missing %q:\n%s", want, got)
+		}
+	}
+	if strings.Contains(got, "[[cite:") {
+		t.Errorf("citation markers were left in the text:\n%s", got)
+	}
+
+	if got := renderCitations("No sources.", "https://github.com/acme/widgets", testHeadSHA, files); !strings.Contains(got, "did not cite any repository lines") {
+		t.Errorf("expected an ungrounded-answer note:\n%s", got)
+	}
+}
+
+func TestExplainCitesPermalinks(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "pkg/auth/login.go", "package auth\n\nfunc Login() {}\n")
+	env.gemini.on("Explain the following source files", "**Overview:** Login lives here [[cite:pkg/auth/login.go#L3]].")
+
+	env.comment(t, "@prd-bot explain --files pkg/auth/login.go")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "3: func Login() {}") || !strings.Contains(prompt, "[[cite:") {
+		t.Errorf("prompt should number source lines and request citations:\n%s", prompt)
+	}
+	body := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	if !strings.Contains(body, "https://github.com/acme/widgets/blob/"+testHeadSHA+"/pkg/auth/login.go#L3") {
+		t.Errorf("explanation is missing the permalink:\n%s", body)
+	}
+}
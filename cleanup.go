@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandCleanup hides the bot's outdated comments on an issue.
+	CommandCleanup = "cleanup"
+	// cleanupDeleteFlag makes cleanup delete the outdated comments instead.
+	cleanupDeleteFlag = "--delete"
+
+	// progressMarker marks progress comments, which are outdated once a
+	// newer one is posted on the issue.
+	progressMarker = "<!-- agent-prd:progress -->"
+)
+
+// supersededSections head the bot comments a newer comment with the same
+// heading replaces, such as a regenerated PRD or priority score.
+var supersededSections = []string{
+	PRDIdentifier,
+	SubTasksIdentifier,
+	PriorityIdentifier,
+	PlanIdentifier,
+	AnalyticsEventsIdentifier,
+	I18nPlanIdentifier,
+	CapacityPlanIdentifier,
+	UISpecIdentifier,
+	RollbackPlanIdentifier,
+	BreakingChangesIdentifier,
+	ComplianceCheckIdentifier,
+}
+
+// outdatedComment is a bot comment and the next newer one of its kind,
+// which superseded it.
+type outdatedComment struct {
+	comment *github.IssueComment
+	newer   *github.IssueComment
+}
+
+// commentKind returns what a bot comment is, so comments of the same kind
+// supersede each other, or "" when nothing supersedes it.
+func commentKind(body string) string {
+	if strings.Contains(body, progressMarker) {
+		return progressMarker
+	}
+	body = strings.TrimSpace(body)
+	for _, section := range supersededSections {
+		if strings.HasPrefix(body, section) {
+			return section
+		}
+	}
+	return ""
+}
+
+// outdatedComments returns the bot's comments, among comments listed
+// oldest first, that a newer bot comment of the same kind supersedes.
+func (b *Bot) outdatedComments(comments []*github.IssueComment) []outdatedComment {
+	next := make(map[string]*github.IssueComment)
+	var outdated []outdatedComment
+	for _, c := range slices.Backward(comments) {
+		if !b.isBotUser(c.GetUser()) {
+			continue
+		}
+		kind := commentKind(c.GetBody())
+		if kind == "" {
+			continue
+		}
+		if newer, ok := next[kind]; ok {
+			outdated = append(outdated, outdatedComment{comment: c, newer: newer})
+		}
+		next[kind] = c
+	}
+	slices.Reverse(outdated)
+	return outdated
+}
+
+// listIssueComments returns every comment of an issue, oldest first.
+func listIssueComments(ctx context.Context, client *github.Client, owner, repo string, issueNum int) ([]*github.IssueComment, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var all []*github.IssueComment
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, repo, issueNum, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing comments of issue #%d: %w", issueNum, err)
+		}
+		all = append(all, comments...)
+		if resp.NextPage == 0 {
+			return all, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// processCleanup hides the bot's outdated comments on the issue as
+// outdated, or deletes them with --delete, which only maintainers may do.
+func (b *Bot) processCleanup(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandCleanup, issueNum, repoOwner, repoName)
+	remove := slices.Contains(args, cleanupDeleteFlag)
+	if remove && !b.requireMaintainer(ctx, client, repo, issueNum, CommandCleanup+" "+cleanupDeleteFlag) {
+		return
+	}
+	comments, err := listIssueComments(ctx, client, repoOwner, repoName, issueNum)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "clean up my comments", "Could not list the comments of the issue", err)
+		return
+	}
+	outdated := b.outdatedComments(comments)
+	if len(outdated) == 0 {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "None of my comments on this issue are outdated.")
+		return
+	}
+	done, failed := 0, 0
+	for _, o := range outdated {
+		if remove {
+			_, err = client.Issues.DeleteComment(ctx, repoOwner, repoName, o.comment.GetID())
+		} else {
+			err = minimizeComment(ctx, client, o.comment.GetNodeID())
+		}
+		if err != nil {
+			log.Printf("Error cleaning up comment %d on issue #%d: %v", o.comment.GetID(), issueNum, err)
+			failed++
+			continue
+		}
+		done++
+	}
+	verb := "hid"
+	if remove {
+		verb = "deleted"
+	}
+	msg := fmt.Sprintf("I %s %d of my comments that newer ones replaced.", verb, done)
+	if failed > 0 {
+		msg += fmt.Sprintf(" I couldn't clean up %d others; check that I have **Issues** and **Pull requests** write permission.", failed)
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+}
+
+// minimizeSuperseded hides the comments a command's own comments superseded
+// on the issue. Comments superseded earlier are left as they are, so one a
+// user chose to show again stays visible.
+func (b *Bot) minimizeSuperseded(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, posted []int64) {
+	if len(posted) == 0 {
+		return
+	}
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	comments, err := listIssueComments(ctx, client, owner, name, issueNum)
+	if err != nil {
+		log.Printf("Error looking for outdated comments on issue #%d: %v", issueNum, err)
+		return
+	}
+	for _, o := range b.outdatedComments(comments) {
+		if !slices.Contains(posted, o.newer.GetID()) {
+			continue
+		}
+		if err := minimizeComment(ctx, client, o.comment.GetNodeID()); err != nil {
+			log.Printf("Error hiding outdated comment %d on issue #%d: %v", o.comment.GetID(), issueNum, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// addThread adds bot comments to issue #42: two PRDs, two progress
+// messages, a sub-task list and a reply, with a user's comment between them.
+func addThread(env *testEnv) (outdated []string) {
+	prd1 := env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** A.")
+	progress1 := env.github.addComment("acme", "widgets", 42, "Alright, I'm on it!\n"+progressMarker+"\n\n- [x] Cloned")
+	env.github.addCommentBy("acme", "widgets", 42, "alice", PRDIdentifier+" looks wrong, regenerating.")
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.github.addComment("acme", "widgets", 42, "Alright, I'm on it!\n"+progressMarker)
+	env.github.addComment("acme", "widgets", 42, SubTasksIdentifier+"\n\n- [ ] Export")
+	env.github.addComment("acme", "widgets", 42, "I couldn't find a PRD.")
+	return []string{prd1.GetNodeID(), progress1.GetNodeID()}
+}
+
+func TestCleanupHidesOutdatedComments(t *testing.T) {
+	env := newTestEnv(t)
+	want := addThread(env)
+
+	env.comment(t, "@prd-bot cleanup")
+
+	if got := env.github.minimizedComments(); !slices.Equal(got, want) {
+		t.Errorf("minimized %v, want the first PRD and progress comment %v", got, want)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "I hid 2 of my comments") {
+		t.Errorf("unexpected reply %q", last)
+	}
+}
+
+func TestCleanupDeleteNeedsMaintainer(t *testing.T) {
+	env := newTestEnv(t)
+	addThread(env)
+
+	env.comment(t, "@prd-bot cleanup --delete")
+
+	if deleted := env.github.deletedComments; len(deleted) != 0 {
+		t.Errorf("a non-maintainer shouldn't delete comments, deleted %v", deleted)
+	}
+
+	env.github.setRole("alice", "maintain")
+	env.comment(t, "@prd-bot cleanup --delete")
+
+	if deleted := env.github.deletedComments; len(deleted) != 2 {
+		t.Errorf("expected the 2 outdated comments to be deleted, got %v", deleted)
+	}
+	if got := env.github.minimizedComments(); len(got) != 0 {
+		t.Errorf("deleted comments shouldn't be minimized, got %v", got)
+	}
+}
+
+func TestNewCommentsHideWhatTheySupersede(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"4.  **Requirements:** Export reports as CSV.")
+	old := env.github.addComment("acme", "widgets", 42, SubTasksIdentifier+"\n\n- [ ] Old")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Export")
+
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+
+	if got := env.github.minimizedComments(); !slices.Equal(got, []string{old.GetNodeID()}) {
+		t.Errorf("minimized %v, want the superseded sub-tasks %s", got, old.GetNodeID())
+	}
+}
+
+func TestMinimizeOutdatedOff(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "minimize_outdated: false\n")
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"4.  **Requirements:** Export reports as CSV.")
+	env.github.addComment("acme", "widgets", 42, SubTasksIdentifier+"\n\n- [ ] Old")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Export")
+
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+
+	if got := env.github.minimizedComments(); len(got) != 0 {
+		t.Errorf("nothing should be minimized with minimize_outdated: false, got %v", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// CompletionIdentifier heads the summary posted on an issue once the bot's
+// pull requests for it are merged.
+const CompletionIdentifier = "### Completion Summary"
+
+// acceptanceCheck is an item of the reviewer checklist of a merged pull
+// request.
+type acceptanceCheck struct {
+	Text     string
+	Verified bool
+	Pull     int
+}
+
+// handlePullRequestMerged closes the loop on an issue once the bot's pull
+// requests implementing it are merged: it summarizes what was delivered
+// and, unless close_on_merge is off, closes the issue and its sub-tasks
+// when every acceptance check was verified.
+func (b *Bot) handlePullRequestMerged(ctx context.Context, event *github.PullRequestEvent) {
+	pr, repo := event.GetPullRequest(), event.GetRepo()
+	if event.GetAction() != "closed" || !pr.GetMerged() {
+		return
+	}
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	record := b.lookupPullRequest(owner, name, pr.GetNumber())
+	if record == nil || record.Issue == 0 {
+		return
+	}
+	client, err := b.clients.Client(event.GetInstallation().GetID())
+	if err != nil {
+		log.Printf("Error creating GitHub client for the merge of #%d in %s: %v", pr.GetNumber(), repo.GetFullName(), err)
+		return
+	}
+	b.dispatch(ctx, func() {
+		ctx := withErrorTags(context.Background(), "repo", repo.GetFullName(), "issue", strconv.Itoa(record.Issue))
+		b.closeLoop(ctx, client, repo, record.Issue, pr)
+	})
+}
+
+// closeLoop posts the completion summary of issueNum after merged, one of
+// its bot pull requests, was merged.
+func (b *Bot) closeLoop(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, merged *github.PullRequest) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	pulls, open, err := b.issuePullRequests(ctx, client, owner, name, issueNum, merged)
+	if err != nil {
+		log.Printf("Error looking up the pull requests of issue #%d in %s: %v", issueNum, repo.GetFullName(), err)
+		return
+	}
+	if len(open) > 0 {
+		b.postComment(ctx, client, owner, name, issueNum, fmt.Sprintf("Pull request #%d for this issue was merged. %s still open; I'll post the completion summary once everything is merged.", merged.GetNumber(), pullRefs(open, "is", "are")))
+		return
+	}
+	issue, _, err := client.Issues.Get(ctx, owner, name, issueNum)
+	if err != nil {
+		log.Printf("Error reading issue #%d in %s after a merge: %v", issueNum, repo.GetFullName(), err)
+		return
+	}
+
+	var checks []acceptanceCheck
+	for _, pr := range pulls {
+		for _, item := range parseChecklist(checklistSection(pr.GetBody(), ReviewChecklistIdentifier)) {
+			checks = append(checks, acceptanceCheck{Text: item.Text, Verified: item.Checked, Pull: pr.GetNumber()})
+		}
+	}
+	unverified := slices.ContainsFunc(checks, func(c acceptanceCheck) bool { return !c.Verified })
+	closeIssue := b.repoConfig(ctx, client, repo).CloseOnMergeEnabled() && !unverified && issue.GetState() == "open"
+
+	var done []string
+	if closeIssue {
+		done = b.completeSubTasks(ctx, client, owner, name, issueNum)
+	}
+	summary := b.formatCompletionSummary(owner, name, issueNum, pulls, checks, done)
+	switch {
+	case closeIssue:
+		summary += "\n\nEverything is delivered, so I'm closing this issue."
+	case unverified && issue.GetState() == "open":
+		summary += "\n\nSome acceptance checks weren't ticked in review, so I'm leaving this issue open. Close it once they are verified."
+	}
+	b.postComment(ctx, client, owner, name, issueNum, summary)
+	if closeIssue {
+		if _, _, err := client.Issues.Edit(ctx, owner, name, issueNum, &github.IssueRequest{State: github.String("closed"), StateReason: github.String("completed")}); err != nil {
+			log.Printf("Error closing issue #%d in %s: %v", issueNum, repo.GetFullName(), err)
+		}
+	}
+}
+
+// issuePullRequests returns the merged bot pull requests of the issue,
+// merged among them, and those still open. Closed unmerged ones were
+// superseded and are left out.
+func (b *Bot) issuePullRequests(ctx context.Context, client *github.Client, owner, repo string, issueNum int, merged *github.PullRequest) (pulls, open []*github.PullRequest, err error) {
+	for _, record := range b.relatedPullRequests(owner, repo, issueNum) {
+		if record.Number == merged.GetNumber() {
+			continue
+		}
+		pr, _, err := client.PullRequests.Get(ctx, owner, repo, record.Number)
+		if err != nil {
+			return nil, nil, err
+		}
+		switch {
+		case pr.GetMerged():
+			pulls = append(pulls, pr)
+		case pr.GetState() == "open":
+			open = append(open, pr)
+		}
+	}
+	return append(pulls, merged), open, nil
+}
+
+// checklistSection returns the section of markdown headed by identifier, up
+// to the next heading.
+func checklistSection(markdown, identifier string) string {
+	_, section, ok := strings.Cut(markdown, identifier)
+	if !ok {
+		return ""
+	}
+	if i := strings.Index(section, "\n#"); i >= 0 {
+		section = section[:i]
+	}
+	return section
+}
+
+// completeSubTasks ticks the sub-task checklist of the issue and closes its
+// open sub-issues, returning what it marked done.
+func (b *Bot) completeSubTasks(ctx context.Context, client *github.Client, owner, repo string, issueNum int) []string {
+	var done []string
+	if comment, err := b.findSubTasksComment(ctx, client, owner, repo, issueNum); err != nil {
+		log.Printf("Error finding the sub-tasks of issue #%d: %v", issueNum, err)
+	} else if comment != nil {
+		body, ticked := comment.GetBody(), 0
+		for _, item := range parseChecklist(body) {
+			if !item.Checked {
+				body = strings.Replace(body, item.Line, strings.Replace(item.Line, "[ ]", "[x]", 1), 1)
+				ticked++
+			}
+		}
+		if ticked > 0 {
+			if _, _, err := client.Issues.EditComment(ctx, owner, repo, comment.GetID(), &github.IssueComment{Body: github.String(body)}); err != nil {
+				log.Printf("Error ticking the sub-tasks of issue #%d: %v", issueNum, err)
+			} else {
+				done = append(done, fmt.Sprintf("%d open sub-task(s) of the checklist", ticked))
+			}
+		}
+	}
+	subIssues, err := listSubIssues(ctx, client, owner, repo, issueNum)
+	if err != nil {
+		log.Printf("Error listing the sub-issues of #%d: %v", issueNum, err)
+		return done
+	}
+	for _, sub := range subIssues {
+		if sub.GetState() != "open" {
+			continue
+		}
+		if _, _, err := client.Issues.Edit(ctx, owner, repo, sub.GetNumber(), &github.IssueRequest{State: github.String("closed"), StateReason: github.String("completed")}); err != nil {
+			log.Printf("Error closing sub-issue #%d: %v", sub.GetNumber(), err)
+			continue
+		}
+		done = append(done, fmt.Sprintf("#%d", sub.GetNumber()))
+	}
+	return done
+}
+
+// formatCompletionSummary lists what was delivered for the issue: the
+// merged pull requests, the status of their acceptance checks, the
+// artifacts the bot generated and the sub-tasks marked done.
+func (b *Bot) formatCompletionSummary(owner, repo string, issueNum int, pulls []*github.PullRequest, checks []acceptanceCheck, done []string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n\nThis issue was delivered by %s.\n", CompletionIdentifier, pullRefs(pulls, "", ""))
+	if len(checks) > 0 {
+		verified := 0
+		for _, c := range checks {
+			if c.Verified {
+				verified++
+			}
+		}
+		fmt.Fprintf(&sb, "\n**Acceptance checks:** %d of %d verified in review.\n", verified, len(checks))
+		for _, c := range checks {
+			if !c.Verified {
+				fmt.Fprintf(&sb, "- [ ] %s (#%d)\n", c.Text, c.Pull)
+			}
+		}
+	}
+	var artifacts []string
+	for _, p := range pulls {
+		artifacts = append(artifacts, fmt.Sprintf("- Pull request #%d: %s", p.GetNumber(), p.GetTitle()))
+	}
+	if prd, _ := b.loadArtifact(owner, repo, issueNum, ArtifactPRD); prd != nil && prd.CommentURL != "" {
+		label := "PRD"
+		if prd.Version != "" {
+			label += " " + prd.Version
+		}
+		artifacts = append(artifacts, fmt.Sprintf("- [%s](%s)", label, prd.CommentURL))
+	}
+	if subTasks, _ := b.loadArtifact(owner, repo, issueNum, ArtifactSubTasks); subTasks != nil && subTasks.CommentURL != "" {
+		artifacts = append(artifacts, fmt.Sprintf("- [Sub-tasks](%s)", subTasks.CommentURL))
+	}
+	sb.WriteString("\n**Delivered artifacts:**\n" + strings.Join(artifacts, "\n") + "\n")
+	if len(done) > 0 {
+		fmt.Fprintf(&sb, "\n**Marked done:** %s\n", strings.Join(done, ", "))
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// pullRefs lists pull requests as "#1, #2 and #3", followed by singular or
+// plural when set.
+func pullRefs(pulls []*github.PullRequest, singular, plural string) string {
+	refs := make([]string, len(pulls))
+	for i, p := range pulls {
+		refs[i] = fmt.Sprintf("#%d", p.GetNumber())
+	}
+	list := refs[len(refs)-1]
+	if len(refs) > 1 {
+		list = strings.Join(refs[:len(refs)-1], ", ") + " and " + list
+	}
+	verb := singular
+	if len(refs) > 1 {
+		verb = plural
+	}
+	return strings.TrimSpace(list + " " + verb)
+}
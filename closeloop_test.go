@@ -0,0 +1,124 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// mergedPayload is a pull_request webhook merging pull request number of
+// acme/widgets with the given body.
+func mergedPayload(t *testing.T, number int, body string) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"action":       "closed",
+		"number":       number,
+		"pull_request": &github.PullRequest{Number: github.Int(number), Title: github.String("Implement Feature: CSV export"), Body: github.String(body), State: github.String("closed"), Merged: github.Bool(true)},
+		"repository":   map[string]any{"name": "widgets", "full_name": "acme/widgets", "owner": map[string]any{"login": "acme"}},
+		"installation": map[string]any{"id": 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+const verifiedChecklist = ReviewChecklistIdentifier + "\n\nFrom the acceptance criteria of the PRD in #42.\n\n- [x] Verify reports download as CSV (`export/csv.go`)\n"
+
+func TestMergeClosesIssueAndSubTasks(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addIssue("acme", "widgets", 42, "CSV export", "open")
+	env.github.addIssue("acme", "widgets", 43, "Add the export button", "open")
+	env.github.setParent("acme", "widgets", 43, 42)
+	prd := env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"PRD")
+	prd.HTMLURL = github.String("https://github.com/acme/widgets/issues/42#issuecomment-1")
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(42)}, "PRD", prd)
+	subTasks := env.github.addComment("acme", "widgets", 42, formatSubTasks("- [ ] Add the export button #43\n- [x] Write the CSV encoder"))
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 5, Issue: 42})
+
+	env.deliverPayload(t, "pull_request", mergedPayload(t, 5, "Implements #42.\n\n"+verifiedChecklist))
+
+	if state := env.github.issue("acme", "widgets", 42).GetState(); state != "closed" {
+		t.Errorf("the issue should be closed, got %s", state)
+	}
+	if state := env.github.issue("acme", "widgets", 43).GetState(); state != "closed" {
+		t.Errorf("the sub-issue should be closed, got %s", state)
+	}
+	if body := subTasks.GetBody(); !strings.Contains(body, "- [x] Add the export button #43") {
+		t.Errorf("the sub-tasks should be ticked:\n%s", body)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	summary := comments[len(comments)-1].GetBody()
+	for _, want := range []string{
+		CompletionIdentifier + "\n\nThis issue was delivered by #5.",
+		"**Acceptance checks:** 1 of 1 verified in review.",
+		"- Pull request #5: Implement Feature: CSV export",
+		"- [PRD](https://github.com/acme/widgets/issues/42#issuecomment-1)",
+		"**Marked done:** 1 open sub-task(s) of the checklist, #43",
+		"I'm closing this issue.",
+	} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected %q in the summary:\n%s", want, summary)
+		}
+	}
+}
+
+func TestMergeWithUnverifiedChecksLeavesIssueOpen(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addIssue("acme", "widgets", 42, "CSV export", "open")
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 5, Issue: 42})
+
+	env.deliverPayload(t, "pull_request", mergedPayload(t, 5, verifiedChecklist+"- [ ] Verify missing reports return 404\n\n### Configuration Checklist\n\n- [ ] Set EXPORT_DIR\n"))
+
+	if state := env.github.issue("acme", "widgets", 42).GetState(); state != "open" {
+		t.Errorf("the issue should stay open, got %s", state)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	summary := comments[len(comments)-1].GetBody()
+	for _, want := range []string{"1 of 2 verified", "- [ ] Verify missing reports return 404 (#5)", "leaving this issue open"} {
+		if !strings.Contains(summary, want) {
+			t.Errorf("expected %q in the summary:\n%s", want, summary)
+		}
+	}
+	if strings.Contains(summary, "EXPORT_DIR") {
+		t.Errorf("only the reviewer checklist holds acceptance checks:\n%s", summary)
+	}
+}
+
+func TestMergeWaitsForOtherParts(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addIssue("acme", "widgets", 42, "CSV export", "open")
+	part1 := env.github.addPull("acme", "widgets", "Implement Feature (1/2)")
+	part2 := env.github.addPull("acme", "widgets", "Implement Feature (2/2)")
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: part1, Issue: 42})
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: part2, Issue: 42})
+
+	env.deliverPayload(t, "pull_request", mergedPayload(t, part1, ""))
+
+	if state := env.github.issue("acme", "widgets", 42).GetState(); state != "open" {
+		t.Errorf("the issue should stay open, got %s", state)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "#2 is still open") || strings.Contains(body, CompletionIdentifier) {
+		t.Errorf("unexpected comment:\n%s", body)
+	}
+}
+
+func TestCloseOnMergeOff(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "close_on_merge: false\n")
+	env.github.addIssue("acme", "widgets", 42, "CSV export", "open")
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 5, Issue: 42})
+
+	env.deliverPayload(t, "pull_request", mergedPayload(t, 5, verifiedChecklist))
+
+	if state := env.github.issue("acme", "widgets", 42).GetState(); state != "open" {
+		t.Errorf("the issue should stay open, got %s", state)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.HasPrefix(body, CompletionIdentifier) || strings.Contains(body, "closing") {
+		t.Errorf("expected only the summary:\n%s", body)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CodeAreasIdentifier heads the existing code summarized under generated
+	// sub-tasks.
+	CodeAreasIdentifier = "#### Existing code areas"
+
+	// defaultSubTaskContextFiles is how many existing files a sub-task
+	// breakdown builds on.
+	defaultSubTaskContextFiles = 8
+	// maxCodeAreaCandidates bounds the repository files offered to the model.
+	maxCodeAreaCandidates = 500
+	// maxOutlineLines bounds the declarations kept of one file.
+	maxOutlineLines = 20
+	// maxOutlineLineLength truncates long declarations.
+	maxOutlineLineLength = 120
+)
+
+// codeAreaIgnore lists, in gitignore syntax, the files that aren't code
+// areas: what long-context mode ignores, and documentation and configuration.
+var codeAreaIgnore = slices.Concat(defaultLongContextIgnore, []string{
+	".*", "*.md", "*.txt", "*.rst", "LICENSE*", "CODEOWNERS", "testdata/", "docs/",
+})
+
+// declarationPattern matches the top-level declarations of common languages.
+var declarationPattern = regexp.MustCompile(`^(export\s+)?(default\s+)?(public\s+|abstract\s+|async\s+)*(func|type|class|def|interface|enum|struct|trait|impl|fn|pub|module|function|const|var)\b`)
+
+// codeArea is an existing file a feature's sub-tasks will likely build on.
+type codeArea struct {
+	Path    string
+	Reason  string   // why it matters for the feature
+	Outline []string // its top-level declarations
+}
+
+// dir returns the directory of the area, "." at the root.
+func (a codeArea) dir() string {
+	return path.Dir(a.Path)
+}
+
+// retrieveCodeAreas picks the existing files of repo the PRD's feature will
+// most likely change and outlines them, at most limit. It returns none for
+// new repositories without code, and when the files can't be read: the
+// sub-tasks are then generated from the PRD alone.
+func (b *Bot) retrieveCodeAreas(ctx context.Context, client *github.Client, repo *github.Repository, prd string, limit int) []codeArea {
+	if limit <= 0 {
+		return nil
+	}
+	owner, name, ref := repo.GetOwner().GetLogin(), repo.GetName(), repo.GetDefaultBranch()
+	tree, _, err := client.Git.GetTree(ctx, owner, name, ref, true)
+	if err != nil {
+		log.Printf("Could not list the files of %s for the sub-task code areas: %v", repo.GetFullName(), err)
+		return nil
+	}
+	ignore := compileIgnore(codeAreaIgnore)
+	var paths []string
+	for _, entry := range tree.Entries {
+		if entry.GetType() == "blob" && !ignored(ignore, entry.GetPath()) && len(paths) < maxCodeAreaCandidates {
+			paths = append(paths, entry.GetPath())
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	areas, err := selectCodeAreas(ctx, b.llm, prd, paths, limit)
+	if err != nil {
+		log.Printf("Could not pick the code areas of the sub-tasks in %s: %v", repo.GetFullName(), err)
+		return nil
+	}
+	for i, area := range areas {
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, name, area.Path, &github.RepositoryContentGetOptions{Ref: ref})
+		if err != nil || file == nil {
+			log.Printf("Could not read %s of %s for the sub-task code areas: %v", area.Path, repo.GetFullName(), err)
+			continue
+		}
+		if content, err := file.GetContent(); err == nil {
+			areas[i].Outline = outlineSource(content)
+		}
+	}
+	return areas
+}
+
+// selectCodeAreas asks the model which of paths the PRD's feature will most
+// likely change or extend. Paths that aren't in the list are dropped.
+func selectCodeAreas(ctx context.Context, llm Generator, prd string, paths []string, limit int) ([]codeArea, error) {
+	prompt := fmt.Sprintf(
+		"Pick the existing repository files the feature described in the following Product Requirements Document (PRD) will most likely change or build on, at most %d, most important first. Leave the list empty when no existing file fits.\n\n"+
+			"Only choose paths from the list below. Respond with JSON only, in this format:\n"+
+			`{"files": [{"path": "internal/export/export.go", "reason": "holds the exporters the CSV format joins"}]}`+"\n\n"+
+			"**Repository Files:**\n%s\n\n"+
+			"**PRD:**\n%s",
+		limit, strings.Join(paths, "\n"), prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptTaskFiles), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick code areas: %w", err)
+	}
+	var result struct {
+		Files []struct {
+			Path   string `json:"path"`
+			Reason string `json:"reason"`
+		} `json:"files"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	var areas []codeArea
+	for _, f := range result.Files {
+		known := slices.Contains(paths, f.Path)
+		seen := slices.ContainsFunc(areas, func(a codeArea) bool { return a.Path == f.Path })
+		if known && !seen && len(areas) < limit {
+			areas = append(areas, codeArea{Path: f.Path, Reason: strings.TrimSpace(f.Reason)})
+		}
+	}
+	return areas, nil
+}
+
+// outlineSource returns the top-level declarations of a source file, the
+// first line of each, at most maxOutlineLines.
+func outlineSource(content string) []string {
+	var outline []string
+	for _, line := range strings.Split(content, "\n") {
+		if !declarationPattern.MatchString(line) {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimRight(strings.TrimSpace(line), "{:("))
+		if len(line) > maxOutlineLineLength {
+			line = line[:maxOutlineLineLength] + "…"
+		}
+		if outline = append(outline, line); len(outline) == maxOutlineLines {
+			break
+		}
+	}
+	return outline
+}
+
+// codeAreasPrompt describes the code areas to the sub-task prompt, or is
+// empty without any.
+func codeAreasPrompt(areas []codeArea) string {
+	if len(areas) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("**Existing Code:** The feature is built into an existing codebase. These are the files it most likely changes, with why and their top-level declarations. Name the concrete packages, files and functions each sub-task changes or extends, using these paths, or a new file next to them, rather than generic items such as \"develop the module\".\n\n")
+	for _, area := range areas {
+		fmt.Fprintf(&b, "--- %s ---\n", area.Path)
+		if area.Reason != "" {
+			fmt.Fprintf(&b, "Why: %s\n", area.Reason)
+		}
+		for _, line := range area.Outline {
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+	}
+	return b.String()
+}
+
+// formatCodeAreas renders the code areas under the sub-tasks, grouped by
+// directory in the order the model ranked them.
+func formatCodeAreas(areas []codeArea) string {
+	var dirs []string
+	byDir := make(map[string][]codeArea)
+	for _, area := range areas {
+		if _, ok := byDir[area.dir()]; !ok {
+			dirs = append(dirs, area.dir())
+		}
+		byDir[area.dir()] = append(byDir[area.dir()], area)
+	}
+	var b strings.Builder
+	b.WriteString(CodeAreasIdentifier + "\n\nThe sub-tasks build on this existing code:\n\n")
+	for _, dir := range dirs {
+		fmt.Fprintf(&b, "- `%s/`\n", dir)
+		for _, area := range byDir[dir] {
+			line := fmt.Sprintf("  - `%s`", path.Base(area.Path))
+			if area.Reason != "" {
+				line += ": " + area.Reason
+			}
+			b.WriteString(line + "\n")
+		}
+	}
+	return b.String()
+}
+
+// codeAreasSection returns the code areas of a sub-task comment, or "".
+func codeAreasSection(markdown string) string {
+	_, section, found := strings.Cut(markdown, CodeAreasIdentifier)
+	if !found {
+		return ""
+	}
+	section, _, _ = strings.Cut(section, OwnersIdentifier)
+	return strings.TrimRight(CodeAreasIdentifier+section, "\n")
+}
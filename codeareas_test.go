@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const exportSource = `package export
+
+import "io"
+
+// Exporter writes a report.
+type Exporter interface {
+	Export(w io.Writer, r Report) error
+}
+
+func NewJSONExporter() Exporter {
+	return jsonExporter{}
+}
+
+func (jsonExporter) Export(w io.Writer, r Report) error {
+	return nil
+}
+`
+
+func TestSubTasksReferenceExistingCode(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.github.addFile("acme", "widgets", "internal/export/export.go", exportSource)
+	env.github.addFile("acme", "widgets", "internal/api/handler.go", "package api\n\nfunc ExportHandler() {}\n")
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Requirements:** Export reports as CSV.")
+	env.gemini.on("Pick the existing repository files", `{"files": [{"path": "internal/export/export.go", "reason": "holds the exporters"}, {"path": "README.md"}, {"path": "internal/api/handler.go", "reason": "serves exports"}]}`)
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add a CSV exporter to `internal/export`.")
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	prompts := env.gemini.receivedPrompts()
+	if files := prompts[0]; !strings.Contains(files, "**Repository Files:**\ninternal/api/handler.go\ninternal/export/export.go\n\n") {
+		t.Errorf("only code should be offered as code areas:\n%s", files)
+	}
+	breakdown := prompts[1]
+	for _, want := range []string{"--- internal/export/export.go ---\nWhy: holds the exporters\ntype Exporter interface\nfunc NewJSONExporter() Exporter\nfunc (jsonExporter) Export(w io.Writer, r Report) error\n", "Name the concrete packages, files and functions"} {
+		if !strings.Contains(breakdown, want) {
+			t.Errorf("the breakdown prompt should contain %q:\n%s", want, breakdown)
+		}
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	want := CodeAreasIdentifier + "\n\nThe sub-tasks build on this existing code:\n\n- `internal/export/`\n  - `export.go`: holds the exporters\n- `internal/api/`\n  - `handler.go`: serves exports\n"
+	if !strings.HasPrefix(body, SubTasksIdentifier) || !strings.HasSuffix(stripArtifactMeta(body), want) {
+		t.Errorf("unexpected sub-task comment:\n%s", body)
+	}
+	if items := parseChecklist(body); len(items) != 1 {
+		t.Errorf("the code areas shouldn't be parsed as sub-tasks, got %+v", items)
+	}
+}
+
+func TestSubTasksWithoutExistingCode(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Requirements:** Export reports as CSV.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Develop the export module.")
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 1 || strings.Contains(prompts[0], "**Existing Code:**") {
+		t.Errorf("a repository without code should get the plain breakdown, got prompts:\n%s", strings.Join(prompts, "\n---\n"))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); strings.Contains(body, CodeAreasIdentifier) {
+		t.Errorf("unexpected code areas:\n%s", body)
+	}
+}
+
+func TestSubTaskContextOff(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "sub_task_context: 0\n")
+	env.github.addFile("acme", "widgets", "internal/export/export.go", exportSource)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Requirements:** Export reports as CSV.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Develop the export module.")
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	if prompts := env.gemini.receivedPrompts(); slices.ContainsFunc(prompts, func(p string) bool { return strings.Contains(p, "Pick the existing repository files") }) {
+		t.Error("no code areas should be retrieved with sub_task_context: 0")
+	}
+}
+
+func TestSubTasksRerunKeepsCodeAreas(t *testing.T) {
+	env := newTestEnv(t)
+	areas := CodeAreasIdentifier + "\n\nThe sub-tasks build on this existing code:\n\n- `internal/export/`\n  - `export.go`: holds the exporters"
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Requirements:** An export button on every report.")
+	env.github.addComment("acme", "widgets", 42, SubTasksIdentifier+"\n\n"+subTasksIntro+"\n\n- [ ] Add the CSV encoder.\n\n"+areas+"\n")
+	env.gemini.on("was revised after it was broken down", `{"tasks": [{"previous": 1}, {"text": "Add an export button."}]}`)
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	edited := env.github.issueComments("acme", "widgets", 42)[1].GetBody()
+	if !strings.Contains(edited, "- [ ] Add the CSV encoder.\n- [ ] Add an export button.\n\n"+areas) {
+		t.Errorf("the revised checklist should keep the code areas:\n%s", edited)
+	}
+}
+
+func TestOutlineSource(t *testing.T) {
+	source := "import os\n\nclass Exporter(Base):\n    def export(self):\n        pass\n\ndef export_csv(rows):\n    pass\n\nexport default function App() {\n"
+	want := []string{"class Exporter(Base)", "def export_csv(rows)", "export default function App()"}
+	if got := outlineSource(source); !slices.Equal(got, want) {
+		t.Errorf("outlineSource() = %q, want %q", got, want)
+	}
+}
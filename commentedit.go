@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// minCommentEditInterval is the minimum time between two edits of the
+	// same comment.
+	minCommentEditInterval = 2 * time.Second
+
+	// defaultAbuseBackoff is used when GitHub flags abuse without saying when
+	// to retry; it doubles on consecutive flags up to maxAbuseBackoff.
+	defaultAbuseBackoff = 30 * time.Second
+	maxAbuseBackoff     = 10 * time.Minute
+)
+
+// commentEditor coordinates frequent comment edits, such as progress
+// updates, so they stay clear of GitHub's secondary rate limits: edits of a
+// comment are coalesced so only the latest body is written, each comment is
+// edited at most once per minInterval, and all edits pause when GitHub
+// answers with an abuse-detection response.
+type commentEditor struct {
+	minInterval time.Duration
+
+	mu           sync.Mutex
+	pending      map[int64]*pendingEdit
+	lastEdit     map[int64]time.Time
+	inFlight     map[int64]bool
+	idle         map[int64]chan struct{} // closed once a comment has no queued or running edit
+	backoff      time.Duration
+	backoffUntil time.Time
+}
+
+type pendingEdit struct {
+	client *github.Client
+	owner  string
+	repo   string
+	body   string
+}
+
+func newCommentEditor(minInterval time.Duration) *commentEditor {
+	return &commentEditor{
+		minInterval: minInterval,
+		pending:     make(map[int64]*pendingEdit),
+		lastEdit:    make(map[int64]time.Time),
+		inFlight:    make(map[int64]bool),
+		idle:        make(map[int64]chan struct{}),
+	}
+}
+
+// Update schedules the comment to be edited to body. A body queued earlier
+// and not yet written is replaced.
+func (e *commentEditor) Update(client *github.Client, owner, repo string, commentID int64, body string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if p, ok := e.pending[commentID]; ok {
+		p.body = body
+		return
+	}
+	e.pending[commentID] = &pendingEdit{client: client, owner: owner, repo: repo, body: body}
+	if _, ok := e.idle[commentID]; !ok {
+		e.idle[commentID] = make(chan struct{})
+	}
+	e.scheduleLocked(commentID)
+}
+
+// Flush waits until every queued edit of the comment has been written or
+// abandoned, or ctx is done.
+func (e *commentEditor) Flush(ctx context.Context, commentID int64) error {
+	e.mu.Lock()
+	idle, ok := e.idle[commentID]
+	e.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *commentEditor) scheduleLocked(commentID int64) {
+	at := e.lastEdit[commentID].Add(e.minInterval)
+	if e.backoffUntil.After(at) {
+		at = e.backoffUntil
+	}
+	time.AfterFunc(time.Until(at), func() { e.run(commentID) })
+}
+
+func (e *commentEditor) run(commentID int64) {
+	e.mu.Lock()
+	p, ok := e.pending[commentID]
+	if !ok || e.inFlight[commentID] {
+		// The running edit reschedules pending bodies when it completes.
+		e.mu.Unlock()
+		return
+	}
+	if wait := time.Until(e.backoffUntil); wait > 0 {
+		// A backoff started after this edit was scheduled.
+		time.AfterFunc(wait, func() { e.run(commentID) })
+		e.mu.Unlock()
+		return
+	}
+	delete(e.pending, commentID)
+	e.inFlight[commentID] = true
+	e.lastEdit[commentID] = time.Now()
+	e.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), githubRequestTimeout)
+	_, _, err := p.client.Issues.EditComment(ctx, p.owner, p.repo, commentID, &github.IssueComment{Body: github.String(p.body)})
+	cancel()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.inFlight, commentID)
+	if wait, limited := abuseRetryAfter(err); limited {
+		if e.backoff == 0 {
+			e.backoff = defaultAbuseBackoff
+		} else {
+			e.backoff = min(2*e.backoff, maxAbuseBackoff)
+		}
+		if wait == 0 {
+			wait = e.backoff
+		}
+		e.backoffUntil = time.Now().Add(wait)
+		log.Printf("GitHub flagged comment edits as abusive, pausing edits for %s: %v", wait, err)
+		if _, newer := e.pending[commentID]; !newer {
+			e.pending[commentID] = p
+		}
+		e.scheduleLocked(commentID)
+		return
+	}
+	if err != nil {
+		log.Printf("Error editing comment %d: %v", commentID, err)
+	} else {
+		e.backoff = 0
+	}
+	if _, newer := e.pending[commentID]; newer {
+		e.scheduleLocked(commentID)
+		return
+	}
+	close(e.idle[commentID])
+	delete(e.idle, commentID)
+	// The edit time only spaces out the next edit, so forget it once that
+	// can't be soon.
+	time.AfterFunc(e.minInterval, func() { e.prune(commentID) })
+}
+
+// prune forgets the last edit of an idle comment once it is more than
+// minInterval old, and the abuse backoff once no comment is being edited and
+// the pause is over.
+func (e *commentEditor) prune(commentID int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if _, busy := e.idle[commentID]; !busy && time.Since(e.lastEdit[commentID]) >= e.minInterval {
+		delete(e.lastEdit, commentID)
+	}
+	if len(e.idle) == 0 && !time.Now().Before(e.backoffUntil) {
+		e.backoff, e.backoffUntil = 0, time.Time{}
+	}
+}
+
+// abuseRetryAfter reports whether err is a GitHub secondary rate limit or
+// abuse-detection response, and how long GitHub asked to wait (zero when it
+// didn't say).
+func abuseRetryAfter(err error) (time.Duration, bool) {
+	var abuse *github.AbuseRateLimitError
+	if errors.As(err, &abuse) {
+		return abuse.GetRetryAfter(), true
+	}
+	var rate *github.RateLimitError
+	if errors.As(err, &rate) {
+		return max(time.Until(rate.Rate.Reset.Time), 0), true
+	}
+	return 0, false
+}
+
+// progressComment is a comment that reports the steps of a long-running
+// command as they complete. Its methods are no-ops when posting it failed.
+type progressComment struct {
+	bot     *Bot
+	client  *github.Client
+	owner   string
+	repo    string
+	comment *github.IssueComment
+	header  string
+	steps   []string
+	live    bool // update the comment after each step rather than once at the end
+}
+
+// startProgress posts header as a new progress comment on the issue. The
+// comment is marked with progressMarker, so newer ones supersede it.
+func (b *Bot) startProgress(ctx context.Context, client *github.Client, repo *github.Repository, installationID int64, issueNum int, header string) *progressComment {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	return &progressComment{
+		bot:     b,
+		client:  client,
+		owner:   owner,
+		repo:    name,
+		comment: b.postComment(ctx, client, owner, name, issueNum, header+"\n"+progressMarker),
+		header:  header + "\n" + progressMarker,
+		live:    b.flagEnabled(FlagStreaming, installationID, repo.GetFullName()),
+	}
+}
+
+// step records a completed step and, when live, schedules the comment update.
+func (p *progressComment) step(format string, args ...any) {
+	p.steps = append(p.steps, "- [x] "+fmt.Sprintf(format, args...))
+	if p.live {
+		p.update()
+	}
+}
+
+func (p *progressComment) update() {
+	if p.comment == nil {
+		return
+	}
+	p.bot.edits.Update(p.client, p.owner, p.repo, p.comment.GetID(), p.header+"\n\n"+strings.Join(p.steps, "\n"))
+}
+
+// finish writes the steps if they weren't written live and waits for the
+// last update to be written.
+func (p *progressComment) finish(ctx context.Context) {
+	if p.comment == nil {
+		return
+	}
+	if !p.live && len(p.steps) > 0 {
+		p.update()
+	}
+	if err := p.bot.edits.Flush(ctx, p.comment.GetID()); err != nil {
+		log.Printf("Progress comment %d was not fully updated: %v", p.comment.GetID(), err)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommentEditorCoalescesEdits(t *testing.T) {
+	gh := newFakeGitHub(t)
+	client, _ := gh.Client(0)
+	comment := gh.addComment("acme", "widgets", 42, "start")
+	e := newCommentEditor(200 * time.Millisecond)
+
+	for i := 1; i <= 5; i++ {
+		e.Update(client, "acme", "widgets", comment.GetID(), fmt.Sprintf("step %d", i))
+	}
+	time.Sleep(50 * time.Millisecond)
+	e.Update(client, "acme", "widgets", comment.GetID(), "step 6")
+	if err := e.Flush(context.Background(), comment.GetID()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	gh.mu.Lock()
+	edits := gh.commentEdits
+	gh.mu.Unlock()
+	if edits > 2 {
+		t.Errorf("expected at most 2 edits, got %d", edits)
+	}
+	if got := gh.issueComments("acme", "widgets", 42)[0].GetBody(); got != "step 6" {
+		t.Errorf("body = %q, want the latest update", got)
+	}
+}
+
+func TestCommentEditorBacksOffOnSecondaryRateLimit(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.abuseResponses = 1
+	client, _ := gh.Client(0)
+	comment := gh.addComment("acme", "widgets", 42, "start")
+	e := newCommentEditor(0)
+
+	start := time.Now()
+	e.Update(client, "acme", "widgets", comment.GetID(), "done")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Flush(ctx, comment.GetID()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("edit was retried after %s, before the requested Retry-After", elapsed)
+	}
+	if got := gh.issueComments("acme", "widgets", 42)[0].GetBody(); got != "done" {
+		t.Errorf("body = %q, want the retried edit", got)
+	}
+}
+
+func TestCommentEditorForgetsIdleComments(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.abuseResponses = 1
+	client, _ := gh.Client(0)
+	comment := gh.addComment("acme", "widgets", 42, "start")
+	e := newCommentEditor(50 * time.Millisecond)
+
+	e.Update(client, "acme", "widgets", comment.GetID(), "done")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Flush(ctx, comment.GetID()); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.lastEdit) != 0 || e.backoff != 0 {
+		t.Errorf("idle comments should be forgotten, got last edits %v and backoff %s", e.lastEdit, e.backoff)
+	}
+}
+
+func TestImplementFeatureReportsProgress(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	progress := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	for _, want := range []string{"Alright, I'm on it!", "- [x] Cloned `acme/widgets`", "- [x] Edited `report.go`, `export/csv.go`", "- [x] Pushed branch `feature/issue-42-", "- [x] Opened pull request #"} {
+		if !strings.Contains(progress, want) {
+			t.Errorf("progress comment is missing %q:\n%s", want, progress)
+		}
+	}
+}
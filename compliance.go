@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandComplianceCheck = "need_compliance_check"
+
+	// ComplianceCheckIdentifier marks comments produced by the
+	// need_compliance_check command.
+	ComplianceCheckIdentifier = "### Compliance Checklist"
+
+	ArtifactComplianceCheck = "compliance_check"
+
+	// complianceAppendixHeading starts the appendix the checklist is added
+	// to the PRD as.
+	complianceAppendixHeading = prdAppendixPrefix + " Compliance"
+
+	// bucketCompliance records the checklist issue opened for each issue,
+	// keyed by issueKey, so running the command again updates it.
+	bucketCompliance = "compliance"
+)
+
+// defaultComplianceFrameworks are the regulations checked when a regulated
+// repository doesn't name any.
+var defaultComplianceFrameworks = []string{"GDPR"}
+
+// ComplianceConfig flags a repository as handling regulated data, which
+// enables need_compliance_check.
+type ComplianceConfig struct {
+	// Regulated enables need_compliance_check.
+	Regulated bool `yaml:"regulated"`
+	// Frameworks are the regulations the checklist covers, e.g. "GDPR",
+	// "HIPAA" or "SOC 2"; GDPR by default.
+	Frameworks []string `yaml:"frameworks"`
+	// DataResidency is where the repository's data must stay, e.g. "EU".
+	DataResidency string `yaml:"data_residency"`
+}
+
+func (c *ComplianceConfig) regulated() bool {
+	return c != nil && c.Regulated
+}
+
+func (c *ComplianceConfig) frameworks() []string {
+	if c == nil || len(c.Frameworks) == 0 {
+		return defaultComplianceFrameworks
+	}
+	return c.Frameworks
+}
+
+// complianceIssue is the checklist issue opened for an issue.
+type complianceIssue struct {
+	Number int `json:"number"`
+}
+
+// processComplianceCheck posts the compliance checklist of the feature
+// described by the PRD in repositories flagged as regulated: personal data
+// handling, data residency and audit logging. The checklist is added to the
+// PRD as an appendix and tracked in an issue of its own, both replaced when
+// the command runs again.
+func (b *Bot) processComplianceCheck(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, _ []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandComplianceCheck, issueNum, repoOwner, repoName)
+
+	cfg := b.repoConfig(ctx, client, repo).Compliance
+	if !cfg.regulated() {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("This repository isn't flagged as regulated, so I don't check compliance. Set `compliance.regulated: true` in `%s` to enable `%s`.", RepoConfigPath, CommandComplianceCheck))
+		return
+	}
+
+	prdComment, err := b.findPRDComment(ctx, client, repoOwner, repoName, issueNum)
+	if err != nil || prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting compliance check.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to check compliance for. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	doc, ok := parsePRDDocument(prdComment.GetBody())
+	prd := prdComment.GetBody()
+	if ok {
+		prd = withoutPRDAppendix(doc.English, complianceAppendixHeading)
+	}
+
+	checklist, err := generateComplianceChecklist(ctx, b.llm, issue, prd, cfg)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "check compliance", "Could not generate the compliance checklist", err)
+		return
+	}
+
+	body := ComplianceCheckIdentifier + "\n\n" + checklist
+	if !ok {
+		body += "\n\n_I couldn't add this checklist to the PRD because its comment isn't in the format I generate._"
+	} else {
+		doc.English = withoutPRDAppendix(doc.English, complianceAppendixHeading) + "\n\n" + complianceAppendixHeading + "\n\n" + checklist + "\n"
+		doc.revise("Added the compliance checklist appendix.")
+		prdBody := doc.String()
+		if edited, _, err := client.Issues.EditComment(ctx, repoOwner, repoName, prdComment.GetID(), &github.IssueComment{Body: github.String(b.stampArtifact(ctx, ArtifactPRD, prdBody))}); err != nil {
+			log.Printf("Error adding the compliance appendix to the PRD of issue #%d: %v", issueNum, err)
+			body += "\n\n_I couldn't add this checklist to the PRD._"
+		} else {
+			b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, prdBody, edited)
+			body += "\n\n_Added to the PRD as an appendix._"
+		}
+	}
+
+	if tracked, err := b.upsertComplianceIssue(ctx, client, repoOwner, repoName, issue, checklist); err != nil {
+		log.Printf("Error opening the compliance checklist issue of issue #%d: %v", issueNum, err)
+		body += "\n\n_I couldn't open an issue to track this checklist._"
+	} else {
+		body += fmt.Sprintf("\n\n_Tracked in #%d._", tracked.GetNumber())
+	}
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactComplianceCheck, body)
+	b.saveArtifact(ArtifactComplianceCheck, repoOwner, repoName, issue, body, comment)
+}
+
+// upsertComplianceIssue updates the checklist issue of issue, opening it
+// when it doesn't exist yet.
+func (b *Bot) upsertComplianceIssue(ctx context.Context, client *github.Client, owner, repo string, issue *github.Issue, checklist string) (*github.Issue, error) {
+	key := issueKey(owner, repo, issue.GetNumber())
+	body := fmt.Sprintf("Compliance checklist of #%d, generated by @%s from its PRD. Tick each item once it is handled, or note why it doesn't apply.\n\n%s", issue.GetNumber(), b.appName, checklist)
+	var existing complianceIssue
+	if ok, _ := b.store.Get(bucketCompliance, key, &existing); ok {
+		tracked, _, err := client.Issues.Edit(ctx, owner, repo, existing.Number, &github.IssueRequest{
+			Body:  github.String(body),
+			State: github.String("open"),
+		})
+		if err == nil {
+			return tracked, nil
+		}
+		log.Printf("Could not update compliance checklist issue #%d, creating a new one: %v", existing.Number, err)
+	}
+
+	tracked, _, err := client.Issues.Create(ctx, owner, repo, &github.IssueRequest{
+		Title: github.String(fmt.Sprintf("Compliance checklist: %s", issue.GetTitle())),
+		Body:  github.String(body),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := b.store.Put(bucketCompliance, key, complianceIssue{Number: tracked.GetNumber()}); err != nil {
+		log.Printf("Error storing compliance checklist issue for %s: %v", key, err)
+	}
+	return tracked, nil
+}
+
+func generateComplianceChecklist(ctx context.Context, llm Generator, issue *github.Issue, prd string, cfg *ComplianceConfig) (string, error) {
+	residency := "The repository doesn't state a data residency requirement; list the regions the feature stores or sends data to so one can be decided."
+	if cfg.DataResidency != "" {
+		residency = fmt.Sprintf("Data must stay in: %s. Flag every place the feature may store or send data elsewhere, including third-party services, backups and logs.", cfg.DataResidency)
+	}
+	prompt := fmt.Sprintf(
+		"Write the compliance checklist of the feature described in the following Product Requirements Document (PRD), for the engineers who build it and the reviewer who signs off on its release. The repository handles regulated data under: %s.\n\n"+
+			"Format the output as GitHub-flavored Markdown under these headings, each item an unchecked task list item (`- [ ] ...`) that is specific to this feature:\n"+
+			"1.  **Personal Data (PII):** (The personal data the feature collects, stores or shows, its lawful basis and minimization, consent, and data subject rights such as access, export and erasure)\n"+
+			"2.  **Data Residency:** (Where the data is stored and processed, including third parties)\n"+
+			"3.  **Audit Logging:** (The actions that must be logged, who did what and when, without logging the personal data itself, and how long logs are kept)\n"+
+			"4.  **Security & Retention:** (Access control, encryption in transit and at rest, retention periods and deletion)\n"+
+			"5.  **Open Questions:** (What the PRD leaves unclear that a compliance reviewer must decide)\n\n"+
+			"When the feature doesn't touch a heading's concerns, say so in one line instead of inventing items. Don't give legal advice; name the regulation behind each item.\n\n"+
+			"%s\n\n"+
+			"**Issue Title:** %s\n\n"+
+			"**Here is the PRD:**\n%s",
+		strings.Join(cfg.frameworks(), ", "), residency, issue.GetTitle(), prd,
+	)
+	checklist, err := generateMarkdown(withSystemPrompt(ctx, CommandComplianceCheck), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate compliance checklist: %w", err)
+	}
+	return strings.TrimSpace(checklist), nil
+}
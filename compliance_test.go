@@ -0,0 +1,92 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const complianceChecklist = "1.  **Personal Data (PII):**\n    - [ ] Exclude email addresses from exported reports (GDPR Art. 5(1)(c))."
+
+func TestComplianceCheck(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "compliance:\n  regulated: true\n  frameworks: [GDPR, HIPAA]\n  data_residency: EU\n")
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Write the compliance checklist", complianceChecklist)
+
+	env.comment(t, "@prd-bot need_compliance_check")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	for _, want := range []string{"regulated data under: GDPR, HIPAA.", "Data must stay in: EU.", "Users export reports as CSV."} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt should contain %q:\n%s", want, prompt)
+		}
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	if !strings.HasPrefix(body, ComplianceCheckIdentifier) || !strings.Contains(body, "Exclude email addresses") || !strings.Contains(body, "_Tracked in #101._") {
+		t.Errorf("unexpected compliance comment:\n%s", body)
+	}
+	if prd := comments[0].GetBody(); !strings.Contains(prd, complianceAppendixHeading+"\n\n"+complianceChecklist) {
+		t.Errorf("the checklist should be added to the PRD:\n%s", prd)
+	}
+	tracked := env.github.issue("acme", "widgets", 101)
+	if tracked == nil || tracked.GetTitle() != "Compliance checklist: Export reports as CSV" || !strings.Contains(tracked.GetBody(), "Compliance checklist of #42") {
+		t.Fatalf("unexpected checklist issue: %+v", tracked)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactComplianceCheck); artifact == nil || artifact.Markdown != stripArtifactMeta(body) {
+		t.Errorf("compliance artifact = %+v", artifact)
+	}
+}
+
+func TestComplianceCheckRerunUpdatesIssueAndAppendix(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "compliance:\n  regulated: true\n")
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Write the compliance checklist", "- [ ] Old item.")
+	env.comment(t, "@prd-bot need_compliance_check")
+
+	env.gemini.mu.Lock()
+	env.gemini.rules = nil
+	env.gemini.mu.Unlock()
+	env.gemini.on("Write the compliance checklist", "- [ ] New item.")
+	env.comment(t, "@prd-bot need_compliance_check")
+
+	if prompt := env.gemini.receivedPrompts()[1]; strings.Contains(prompt, "Old item") || !strings.Contains(prompt, "regulated data under: GDPR.") {
+		t.Errorf("the earlier appendix shouldn't be sent and GDPR is the default:\n%s", prompt)
+	}
+	prd := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	if strings.Count(prd, complianceAppendixHeading) != 1 || strings.Contains(prd, "Old item") || !strings.Contains(prd, "New item") {
+		t.Errorf("expected one up-to-date appendix in the PRD:\n%s", prd)
+	}
+	if env.github.issue("acme", "widgets", 102) != nil {
+		t.Error("running the command again should update the checklist issue, not open another")
+	}
+	if body := env.github.issue("acme", "widgets", 101).GetBody(); !strings.Contains(body, "New item") {
+		t.Errorf("the checklist issue should be updated:\n%s", body)
+	}
+}
+
+func TestComplianceCheckRequiresRegulatedRepo(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+
+	env.comment(t, "@prd-bot need_compliance_check")
+
+	if len(env.gemini.receivedPrompts()) != 0 {
+		t.Error("no checklist should be generated for an unregulated repository")
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "compliance.regulated: true") {
+		t.Errorf("the reply should explain how to enable the command:\n%s", body)
+	}
+}
+
+func TestWithoutPRDAppendixKeepsOtherAppendices(t *testing.T) {
+	prd := "1.  **Background:** B.\n\n" + capacityAppendixHeading + "\n\nLoad.\n\n" + complianceAppendixHeading + "\n\n- [ ] Item.\n"
+	if got, want := withoutPRDAppendix(prd, capacityAppendixHeading), "1.  **Background:** B.\n\n"+complianceAppendixHeading+"\n\n- [ ] Item."; got != want {
+		t.Errorf("withoutPRDAppendix(capacity) = %q, want %q", got, want)
+	}
+	if got, want := withoutPRDAppendix(prd, complianceAppendixHeading), "1.  **Background:** B.\n\n"+capacityAppendixHeading+"\n\nLoad."; got != want {
+		t.Errorf("withoutPRDAppendix(compliance) = %q, want %q", got, want)
+	}
+}
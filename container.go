@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// ContainerBuildIdentifier heads the result of the container build run
+	// before a pull request is opened.
+	ContainerBuildIdentifier = "### Container Build"
+
+	// Values of ContainerConfig.Build.
+	containerBuildOff          = "off"          // don't build containers (default)
+	containerBuildAuto         = "auto"         // the devcontainer if there is one, else the Dockerfile
+	containerBuildDockerfile   = "dockerfile"   // docker build the Dockerfile
+	containerBuildDevcontainer = "devcontainer" // run the devcontainer lifecycle
+
+	defaultDockerfile = "Dockerfile"
+)
+
+// devcontainerFiles are where the devcontainer CLI looks for the
+// configuration, in order.
+var devcontainerFiles = []string{".devcontainer/devcontainer.json", ".devcontainer.json"}
+
+// dockerNamePattern matches the characters a Docker image tag can't hold.
+var dockerNamePattern = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// ContainerConfig makes implement_feature validate a change by building the
+// repository's container, rather than guessing how the project builds.
+type ContainerConfig struct {
+	// Build is "auto", "dockerfile", "devcontainer" or "off" (default).
+	Build string `yaml:"build"`
+	// Dockerfile is the Dockerfile built, relative to the repository root;
+	// "Dockerfile" by default.
+	Dockerfile string `yaml:"dockerfile"`
+}
+
+func (c *ContainerConfig) mode() string {
+	if c == nil {
+		return containerBuildOff
+	}
+	switch c.Build {
+	case containerBuildAuto, containerBuildDockerfile, containerBuildDevcontainer:
+		return c.Build
+	}
+	return containerBuildOff
+}
+
+func (c *ContainerConfig) dockerfile() string {
+	if c == nil || c.Dockerfile == "" {
+		return defaultDockerfile
+	}
+	return filepath.ToSlash(filepath.Clean(c.Dockerfile))
+}
+
+// containerBuild is the outcome of building a change's container.
+type containerBuild struct {
+	Kind   string // containerBuildDockerfile or containerBuildDevcontainer
+	Source string // the Dockerfile or devcontainer configuration built
+	Passed bool
+	Output string // kept on failure
+}
+
+// runContainerBuild builds the container the repository configured for the
+// change in ws. It reports nothing when no build is configured or the
+// repository has nothing to build.
+func (b *Bot) runContainerBuild(ctx context.Context, client *github.Client, repo *github.Repository, ws workspace, issueNum int, progress *progressComment) checkResult {
+	cfg := b.repoConfig(ctx, client, repo).Container
+	kind, source := detectContainer(ws.dir(), cfg)
+	if kind == "" {
+		if cfg.mode() != containerBuildOff {
+			log.Printf("Not building a container for %s: no %s found", repo.GetFullName(), cfg.mode())
+		}
+		return checkResult{}
+	}
+	var build *containerBuild
+	if kind == containerBuildDevcontainer {
+		build = b.buildDevcontainer(ws.dir(), source)
+	} else {
+		build = b.buildDockerfile(ws.dir(), source, containerTag(repo, issueNum))
+	}
+	if build.Passed {
+		progress.step("Built the container from `%s`: passed", source)
+		return checkResult{Section: build.format()}
+	}
+	progress.step("Built the container from `%s`: failed", source)
+	return checkResult{Section: build.format(), Failures: fmt.Sprintf("Building `%s`:\n%s", source, tailOutput(build.Output))}
+}
+
+// detectContainer returns what cfg builds in the checkout at dir: the kind
+// of build and its source file, or "" when there is nothing to build.
+func detectContainer(dir string, cfg *ContainerConfig) (kind, source string) {
+	exists := func(name string) bool {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+		return err == nil && !info.IsDir()
+	}
+	mode := cfg.mode()
+	if mode == containerBuildAuto || mode == containerBuildDevcontainer {
+		for _, name := range devcontainerFiles {
+			if exists(name) {
+				return containerBuildDevcontainer, name
+			}
+		}
+	}
+	if mode == containerBuildAuto || mode == containerBuildDockerfile {
+		if name := cfg.dockerfile(); !strings.HasPrefix(name, "..") && exists(name) {
+			return containerBuildDockerfile, name
+		}
+	}
+	return "", ""
+}
+
+// containerTag is the tag of the image built for an issue of repo.
+func containerTag(repo *github.Repository, issueNum int) string {
+	name := dockerNamePattern.ReplaceAllString(strings.ToLower(repo.GetOwner().GetLogin()+"-"+repo.GetName()), "-")
+	return fmt.Sprintf("agent-prd-validate:%s-%d", strings.Trim(name, "-._"), issueNum)
+}
+
+// buildDockerfile builds the Dockerfile in dir and removes the image again.
+func (b *Bot) buildDockerfile(dir, dockerfile, tag string) *containerBuild {
+	build := &containerBuild{Kind: containerBuildDockerfile, Source: dockerfile}
+	out, err := b.runner(dir, "docker", "build", "--file", dockerfile, "--tag", tag, ".")
+	build.Passed = err == nil
+	if !build.Passed {
+		build.Output = out
+		return build
+	}
+	if out, err := b.runner(dir, "docker", "image", "rm", "--force", tag); err != nil {
+		log.Printf("Could not remove the validation image %s: %v: %s", tag, err, out)
+	}
+	return build
+}
+
+// buildDevcontainer creates the devcontainer of the checkout at dir, which
+// runs its lifecycle commands, and removes the container again.
+func (b *Bot) buildDevcontainer(dir, config string) *containerBuild {
+	build := &containerBuild{Kind: containerBuildDevcontainer, Source: config}
+	out, err := b.runner(dir, "devcontainer", "up", "--workspace-folder", ".", "--config", config, "--remove-existing-container")
+	// devcontainer up ends its output with a JSON result, also on failure.
+	var result struct {
+		Outcome     string `json:"outcome"`
+		ContainerID string `json:"containerId"`
+	}
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	json.Unmarshal([]byte(lines[len(lines)-1]), &result)
+	build.Passed = err == nil && result.Outcome != "error"
+	if !build.Passed {
+		build.Output = out
+	}
+	if result.ContainerID != "" {
+		if out, err := b.runner(dir, "docker", "rm", "--force", result.ContainerID); err != nil {
+			log.Printf("Could not remove the devcontainer %s: %v: %s", result.ContainerID, err, out)
+		}
+	}
+	return build
+}
+
+// format renders the build as a pull request body section.
+func (c *containerBuild) format() string {
+	what := fmt.Sprintf("`%s`", c.Source)
+	if c.Kind == containerBuildDevcontainer {
+		what = fmt.Sprintf("the devcontainer of `%s`, running its lifecycle commands,", c.Source)
+	}
+	if c.Passed {
+		return fmt.Sprintf("%s\n\n**Passed:** I built %s with this change.", ContainerBuildIdentifier, what)
+	}
+	return fmt.Sprintf("%s\n\n> [!WARNING]\n> Building %s failed with this change. Fix the build before merging.\n\n<details>\n<summary>Build output</summary>\n\n```\n%s\n```\n</details>",
+		ContainerBuildIdentifier, what, tailOutput(c.Output))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// containerRunner makes the fake clone hold files, given by path.
+func containerRunner(t *testing.T, env *testEnv, files ...string) {
+	t.Helper()
+	env.runner.effects = map[string]func(string){
+		"git clone": func(dir string) {
+			for _, name := range files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte("{}"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		},
+	}
+}
+
+func TestImplementFeatureBuildsDockerfile(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "container:\n  build: auto\n  dockerfile: build/Dockerfile\n")
+	containerRunner(t, env, "build/Dockerfile")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	executed := env.runner.executed()
+	for _, want := range []string{
+		"docker build --file build/Dockerfile --tag agent-prd-validate:acme-widgets-42 .",
+		"docker image rm --force agent-prd-validate:acme-widgets-42",
+	} {
+		if !slices.Contains(executed, want) {
+			t.Errorf("expected %q, ran:\n%s", want, strings.Join(executed, "\n"))
+		}
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || !strings.Contains(pulls[0].GetBody(), ContainerBuildIdentifier+"\n\n**Passed:** I built `build/Dockerfile` with this change.") {
+		t.Fatalf("the pull request should report the build: %v", pulls)
+	}
+}
+
+func TestImplementFeatureRunsDevcontainer(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "container:\n  build: auto\n")
+	containerRunner(t, env, "Dockerfile", ".devcontainer/devcontainer.json")
+	env.runner.failOn = "devcontainer up"
+	env.runner.outputs = map[string]string{
+		"devcontainer up": "Running the postCreateCommand from devcontainer.json...\nnpm ERR! missing script: bootstrap\n" +
+			`{"outcome":"error","message":"postCreateCommand failed","containerId":"c0ffee"}`,
+	}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	executed := env.runner.executed()
+	if !slices.Contains(executed, "docker rm --force c0ffee") || slices.ContainsFunc(executed, func(c string) bool { return strings.HasPrefix(c, "docker build") }) {
+		t.Errorf("the devcontainer should be preferred and removed, ran:\n%s", strings.Join(executed, "\n"))
+	}
+	body := env.github.pullRequests()[0].GetBody()
+	if !strings.Contains(body, "> [!WARNING]\n> Building the devcontainer of `.devcontainer/devcontainer.json`") || !strings.Contains(body, "missing script: bootstrap") {
+		t.Errorf("the pull request should report the failed build:\n%s", body)
+	}
+}
+
+func TestImplementFeatureWithoutContainer(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "container:\n  build: dockerfile\n")
+	containerRunner(t, env, ".devcontainer.json")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if executed := env.runner.executed(); slices.ContainsFunc(executed, func(c string) bool { return strings.HasPrefix(c, "docker") || strings.HasPrefix(c, "devcontainer") }) {
+		t.Errorf("nothing should be built without a Dockerfile, ran:\n%s", strings.Join(executed, "\n"))
+	}
+	if body := env.github.pullRequests()[0].GetBody(); strings.Contains(body, ContainerBuildIdentifier) {
+		t.Errorf("unexpected container build section:\n%s", body)
+	}
+}
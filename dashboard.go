@@ -0,0 +1,222 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// bucketUsage holds repoUsage documents keyed by "owner/repo".
+const bucketUsage = "usage"
+
+// repoUsage counts what the bot did for a repository, for the dashboard.
+type repoUsage struct {
+	Repo         string         `json:"repo"`
+	Commands     map[string]int `json:"commands,omitempty"`
+	PRDs         int            `json:"prds"`
+	PRDSeconds   float64        `json:"prd_seconds"` // total time spent generating PRDs
+	PullsOpened  int            `json:"pulls_opened"`
+	PullsMerged  int            `json:"pulls_merged"`
+	LastActivity time.Time      `json:"last_activity"`
+}
+
+// recordUsage applies update to the usage document of owner/repo.
+func (b *Bot) recordUsage(owner, repo string, update func(*repoUsage)) {
+	b.usageMu.Lock()
+	defer b.usageMu.Unlock()
+	key := owner + "/" + repo
+	usage := repoUsage{Repo: key}
+	if _, err := b.store.Get(bucketUsage, key, &usage); err != nil {
+		log.Printf("Error reading usage of %s: %v", key, err)
+		return
+	}
+	update(&usage)
+	usage.LastActivity = time.Now().UTC()
+	if err := b.store.Put(bucketUsage, key, &usage); err != nil {
+		log.Printf("Error recording usage of %s: %v", key, err)
+	}
+}
+
+func (b *Bot) recordCommand(owner, repo, command string) {
+	b.recordUsage(owner, repo, func(u *repoUsage) {
+		if u.Commands == nil {
+			u.Commands = make(map[string]int)
+		}
+		u.Commands[command]++
+	})
+}
+
+// recordPRD counts a generated PRD that took elapsed to generate.
+func (b *Bot) recordPRD(owner, repo string, elapsed time.Duration) {
+	b.recordUsage(owner, repo, func(u *repoUsage) {
+		u.PRDs++
+		u.PRDSeconds += elapsed.Seconds()
+	})
+}
+
+// handlePullRequestClosed counts the merge of the bot's pull requests.
+func (b *Bot) handlePullRequestClosed(event *github.PullRequestEvent) {
+	pr, repo := event.GetPullRequest(), event.GetRepo()
+	if event.GetAction() != "closed" || !pr.GetMerged() {
+		return
+	}
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	if b.lookupPullRequest(owner, name, pr.GetNumber()) == nil {
+		return
+	}
+	b.recordUsage(owner, name, func(u *repoUsage) { u.PullsMerged++ })
+}
+
+// dashboardRow is a repository's line on the dashboard.
+type dashboardRow struct {
+	repoUsage
+	feedbackSummary
+	AverageLatency string
+	TopCommands    string
+}
+
+// dashboardData is what the dashboard template renders.
+type dashboardData struct {
+	Owner    string
+	Total    dashboardRow
+	Repos    []dashboardRow
+	Failures []metricValue
+	Feedback []feedbackEntry // the latest written feedback
+}
+
+func newDashboardRow(u repoUsage) dashboardRow {
+	row := dashboardRow{repoUsage: u, AverageLatency: "-"}
+	if u.PRDs > 0 {
+		row.AverageLatency = (time.Duration(u.PRDSeconds / float64(u.PRDs) * float64(time.Second))).Round(100 * time.Millisecond).String()
+	}
+	commands := make([]string, 0, len(u.Commands))
+	for command := range u.Commands {
+		commands = append(commands, command)
+	}
+	slices.SortFunc(commands, func(a, b string) int {
+		return cmp.Or(cmp.Compare(u.Commands[b], u.Commands[a]), cmp.Compare(a, b))
+	})
+	var top []string
+	for _, command := range commands[:min(len(commands), 3)] {
+		top = append(top, command+" ("+strconv.Itoa(u.Commands[command])+")")
+	}
+	row.TopCommands = strings.Join(top, ", ")
+	return row
+}
+
+// handleDashboard renders usage per repository as an HTML page, optionally
+// limited to one organization: GET /dashboard?owner=acme
+func (b *Bot) handleDashboard(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	docs, err := b.store.List(bucketUsage)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := dashboardData{Owner: r.URL.Query().Get("owner"), Failures: failuresTotal.snapshot()}
+	total := repoUsage{Repo: "Total", Commands: make(map[string]int)}
+	var totalFeedback feedbackSummary
+	feedback := b.summarizeFeedback()
+	for key, doc := range docs {
+		var usage repoUsage
+		if err := json.Unmarshal(doc, &usage); err != nil {
+			log.Printf("Skipping unreadable usage of %s: %v", key, err)
+			continue
+		}
+		if owner, _, _ := strings.Cut(usage.Repo, "/"); data.Owner != "" && !strings.EqualFold(owner, data.Owner) {
+			continue
+		}
+		row := newDashboardRow(usage)
+		if s := feedback[usage.Repo]; s != nil {
+			row.feedbackSummary = *s
+			totalFeedback.ThumbsUp += s.ThumbsUp
+			totalFeedback.ThumbsDown += s.ThumbsDown
+			totalFeedback.Written += s.Written
+		}
+		data.Repos = append(data.Repos, row)
+		for command, n := range usage.Commands {
+			total.Commands[command] += n
+		}
+		total.PRDs += usage.PRDs
+		total.PRDSeconds += usage.PRDSeconds
+		total.PullsOpened += usage.PullsOpened
+		total.PullsMerged += usage.PullsMerged
+		if usage.LastActivity.After(total.LastActivity) {
+			total.LastActivity = usage.LastActivity
+		}
+	}
+	slices.SortFunc(data.Repos, func(a, b dashboardRow) int { return cmp.Compare(a.Repo, b.Repo) })
+	data.Total = newDashboardRow(total)
+	data.Total.feedbackSummary = totalFeedback
+	data.Feedback = b.repoFeedback(data.Owner, "")
+	data.Feedback = data.Feedback[:min(len(data.Feedback), maxDashboardFeedback)]
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		log.Printf("Error rendering the dashboard: %v", err)
+	}
+}
+
+var dashboardTemplate = template.Must(template.New("dashboard").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PRD bot dashboard{{with .Owner}} – {{.}}{{end}}</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2rem; color: #1f2328; }
+table { border-collapse: collapse; margin-bottom: 2rem; }
+th, td { border: 1px solid #d0d7de; padding: .4rem .8rem; text-align: left; }
+th { background: #f6f8fa; }
+td.n { text-align: right; }
+tfoot td { font-weight: bold; }
+</style>
+</head>
+<body>
+<h1>PRD bot dashboard{{with .Owner}} – {{.}}{{end}}</h1>
+<h2>Repositories</h2>
+{{if .Repos}}
+<table>
+<thead><tr><th>Repository</th><th>PRDs</th><th>Avg. PRD latency</th><th>PRs opened</th><th>PRs merged</th><th>Top commands</th><th>👍</th><th>👎</th><th>Feedback</th><th>Last activity</th></tr></thead>
+<tbody>
+{{range .Repos}}<tr><td>{{.Repo}}</td><td class="n">{{.PRDs}}</td><td class="n">{{.AverageLatency}}</td><td class="n">{{.PullsOpened}}</td><td class="n">{{.PullsMerged}}</td><td>{{.TopCommands}}</td><td class="n">{{.ThumbsUp}}</td><td class="n">{{.ThumbsDown}}</td><td class="n">{{.Written}}</td><td>{{.LastActivity.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</tbody>
+{{with .Total}}<tfoot><tr><td>{{.Repo}}</td><td class="n">{{.PRDs}}</td><td class="n">{{.AverageLatency}}</td><td class="n">{{.PullsOpened}}</td><td class="n">{{.PullsMerged}}</td><td>{{.TopCommands}}</td><td class="n">{{.ThumbsUp}}</td><td class="n">{{.ThumbsDown}}</td><td class="n">{{.Written}}</td><td>{{.LastActivity.Format "2006-01-02 15:04"}}</td></tr></tfoot>{{end}}
+</table>
+{{else}}
+<p>No activity recorded yet.</p>
+{{end}}
+<h2>Recent feedback</h2>
+{{if .Feedback}}
+<table>
+<thead><tr><th>Issue</th><th>Document</th><th>Author</th><th>Feedback</th><th>Date</th></tr></thead>
+<tbody>
+{{range .Feedback}}<tr><td>{{.Owner}}/{{.Repo}}#{{.Issue}}</td><td>{{.Kind}}</td><td>{{.Author}}</td><td>{{.Text}}</td><td>{{.CreatedAt.Format "2006-01-02"}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>No feedback yet.</p>
+{{end}}
+<h2>Failures since the last restart</h2>
+{{if .Failures}}
+<table>
+<thead><tr><th>Error code</th><th>Count</th></tr></thead>
+<tbody>
+{{range .Failures}}<tr><td>{{.Label}}</td><td class="n">{{.Value}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}
+<p>No failures.</p>
+{{end}}
+</body>
+</html>
+`))
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// pullRequestClosedPayload is a pull_request webhook closing pull request
+// number of acme/widgets.
+func pullRequestClosedPayload(t *testing.T, number int, merged bool) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"action":       "closed",
+		"number":       number,
+		"pull_request": &github.PullRequest{Number: github.Int(number), Merged: github.Bool(merged)},
+		"repository":   map[string]any{"name": "widgets", "full_name": "acme/widgets", "owner": map[string]any{"login": "acme"}},
+		"installation": map[string]any{"id": 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestDashboard(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_en.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Break down the following Product Requirements Document", string(loadFixture(t, "gemini/sub_tasks.md")))
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+	env.comment(t, "@prd-bot need_sub_task")
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+	env.comment(t, "@prd-bot need_prd") // the PRD exists: counted as a command only
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 5, Issue: 42})
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 6, Issue: 42})
+	env.deliverPayload(t, "pull_request", pullRequestClosedPayload(t, 5, true))
+	env.deliverPayload(t, "pull_request", pullRequestClosedPayload(t, 6, false))
+	env.deliverPayload(t, "pull_request", pullRequestClosedPayload(t, 7, true)) // not the bot's
+	env.bot.recordCommand("other", "repo", CommandExplain)
+
+	var usage repoUsage
+	if ok, _ := env.bot.store.Get(bucketUsage, "acme/widgets", &usage); !ok || usage.PRDs != 1 || usage.PullsOpened != 2 || usage.PullsMerged != 1 || usage.Commands[CommandGenerateSubTask] != 2 || usage.Commands[CommandGeneratePRD] != 1 {
+		t.Fatalf("unexpected usage %+v", usage)
+	}
+
+	get := func(url string, auth func(*http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		auth(req)
+		rec := httptest.NewRecorder()
+		env.bot.handleDashboard(rec, req)
+		return rec
+	}
+	if rec := get("/dashboard", func(*http.Request) {}); rec.Code != http.StatusUnauthorized || len(rec.Header().Values("WWW-Authenticate")) != 2 {
+		t.Errorf("anonymous request returned %d %v", rec.Code, rec.Header())
+	}
+	rec := get("/dashboard?owner=acme", func(r *http.Request) { r.SetBasicAuth("admin", "s3cret") })
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("dashboard returned %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"PRD bot dashboard – acme",
+		`<td>acme/widgets</td><td class="n">1</td>`,
+		`<td class="n">2</td><td class="n">1</td><td>need_sub_task (2), need_prd (1)</td>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "other/repo") {
+		t.Errorf("other organizations should be filtered out:\n%s", body)
+	}
+}
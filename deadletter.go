@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// bucketWebhooks holds webhooks from receipt until they are handled, so
+	// the ones in flight during a crash are handled again on restart.
+	bucketWebhooks = "webhooks"
+	// bucketDeadLetters holds webhooks that failed maxDeliveryAttempts times
+	// or crashed a handler, until an operator re-drives or discards them.
+	bucketDeadLetters = "deadletters"
+
+	// maxDeliveryAttempts is how many times a webhook is attempted before it
+	// is dead-lettered.
+	maxDeliveryAttempts = 3
+)
+
+var deadLettersTotal = newCounterVec("agent_prd_dead_letters_total", "Webhooks moved to the dead letter queue by event type.", "event")
+
+// eventScope tracks the handlers dispatched while handling one webhook.
+type eventScope struct {
+	wg       sync.WaitGroup
+	delivery string // the webhook's delivery ID
+	event    string // the webhook's event type
+
+	mu     sync.Mutex
+	panics []string
+}
+
+func (s *eventScope) recordPanic(r any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.panics = append(s.panics, fmt.Sprint(r))
+}
+
+// errorContext returns a context whose reported errors name the webhook of
+// the scope, if any.
+func (s *eventScope) errorContext() context.Context {
+	if s == nil {
+		return context.Background()
+	}
+	return withErrorTags(context.Background(), "delivery", s.delivery, "event", s.event)
+}
+
+// err describes the panics of the scope's handlers, or is nil.
+func (s *eventScope) err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.panics) == 0 {
+		return nil
+	}
+	return fmt.Errorf("a handler panicked: %s", strings.Join(s.panics, "; "))
+}
+
+type eventScopeKey struct{}
+
+// withEventScope returns a context whose dispatched handlers belong to scope.
+func withEventScope(ctx context.Context, scope *eventScope) context.Context {
+	return context.WithValue(ctx, eventScopeKey{}, scope)
+}
+
+// eventScopeFrom returns the scope of the webhook ctx was made for, or nil
+// for background work.
+func eventScopeFrom(ctx context.Context) *eventScope {
+	scope, _ := ctx.Value(eventScopeKey{}).(*eventScope)
+	return scope
+}
+
+// handleScoped handles job, attributing the handlers it dispatches to the
+// returned scope. A panic handling the payload is recovered and returned as
+// an error, so one bad payload can't stop the others.
+func (b *Bot) handleScoped(job *queuedJob) (scope *eventScope, err error) {
+	scope = &eventScope{delivery: job.ID, event: job.Event}
+	ctx := withEventScope(context.Background(), scope)
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			log.Printf("Recovered from a panic handling webhook %s (%s): %v\n%s", job.ID, job.Event, r, stack)
+			b.capturePanic(scope.errorContext(), r, stack)
+			err = fmt.Errorf("handling the event panicked: %v", r)
+		}
+	}()
+	return scope, b.handleEvent(ctx, job.Event, job.Payload)
+}
+
+// processDelivery handles a persisted webhook and, once its handlers are
+// done, removes it from the webhooks bucket or dead-letters it when one of
+// them panicked. It returns the error of handleEvent.
+func (b *Bot) processDelivery(job *queuedJob) error {
+	scope, err := b.handleScoped(job)
+	if errors.Is(err, errInvalidEvent) {
+		// Retrying can't fix the payload.
+		b.settleDelivery(job, "")
+		return err
+	}
+	b.jobs.Add(1)
+	go func() {
+		defer b.jobs.Done()
+		scope.wg.Wait()
+		cause := err
+		if cause == nil {
+			cause = scope.err()
+		}
+		reason := ""
+		if cause != nil {
+			reason = cause.Error()
+		}
+		b.settleDelivery(job, reason)
+	}()
+	return err
+}
+
+// settleDelivery removes a webhook from the webhooks bucket, moving it to
+// the dead letter queue when reason says why it failed.
+func (b *Bot) settleDelivery(job *queuedJob, reason string) {
+	if reason != "" {
+		job.Error, job.Failed = reason, time.Now()
+		log.Printf("Dead-lettering webhook %s (%s): %s", job.ID, job.Event, reason)
+		if err := b.store.Put(bucketDeadLetters, job.ID, job); err != nil {
+			// Keep it in the webhooks bucket rather than lose it.
+			log.Printf("Error dead-lettering webhook %s: %v", job.ID, err)
+			return
+		}
+		deadLettersTotal.Inc(job.Event)
+		b.captureError(withErrorTags(context.Background(), "delivery", job.ID, "event", job.Event), &errorEvent{
+			Level:     "error",
+			Message:   fmt.Sprintf("Dead-lettered webhook %s (%s): %s", job.ID, job.Event, reason),
+			Exception: &errorExceptions{Values: []errorException{{Type: "dead_letter", Value: reason}}},
+			Extra:     map[string]any{"attempts": job.Attempts},
+		})
+	}
+	if err := b.store.Delete(bucketWebhooks, job.ID); err != nil {
+		log.Printf("Error removing handled webhook %s: %v", job.ID, err)
+	}
+}
+
+// recoverDeliveries handles the webhooks that were in flight when the
+// process stopped. A webhook that was attempted maxDeliveryAttempts times
+// probably crashes the bot and is dead-lettered instead.
+func (b *Bot) recoverDeliveries() {
+	docs, err := b.store.List(bucketWebhooks)
+	if err != nil {
+		log.Printf("Error listing unfinished webhooks: %v", err)
+		return
+	}
+	var jobs []*queuedJob
+	for key, doc := range docs {
+		var job queuedJob
+		if err := json.Unmarshal(doc, &job); err != nil {
+			log.Printf("Skipping unreadable webhook %s: %v", key, err)
+			continue
+		}
+		jobs = append(jobs, &job)
+	}
+	slices.SortFunc(jobs, func(a, b *queuedJob) int { return a.Queued.Compare(b.Queued) })
+	for _, job := range jobs {
+		log.Printf("Recovering unfinished webhook %s (%s, %d attempts).", job.ID, job.Event, job.Attempts)
+		if b.queue != nil {
+			if job.Lane == "" {
+				job.Lane = b.jobLane(job.Event, job.Payload)
+			}
+			b.queue.enqueue(job)
+			continue
+		}
+		if job.Attempts >= maxDeliveryAttempts {
+			b.settleDelivery(job, fmt.Sprintf("the bot stopped while handling it on each of %d attempts", job.Attempts))
+			continue
+		}
+		job.Attempts++
+		if err := b.store.Put(bucketWebhooks, job.ID, job); err != nil {
+			log.Printf("Error persisting webhook %s: %v", job.ID, err)
+			continue
+		}
+		if err := b.processDelivery(job); err != nil {
+			log.Printf("Error handling recovered webhook %s: %v", job.ID, err)
+		}
+	}
+}
+
+// deadLetterSummary describes a dead letter without its payload.
+type deadLetterSummary struct {
+	ID       string    `json:"id"`
+	Event    string    `json:"event"`
+	Attempts int       `json:"attempts"`
+	Received time.Time `json:"received"`
+	Failed   time.Time `json:"failed"`
+	Error    string    `json:"error"`
+}
+
+// handleDeadLetters lists the dead letter queue, oldest failure first:
+// GET /deadletters
+func (b *Bot) handleDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	docs, err := b.store.List(bucketDeadLetters)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	summaries := []deadLetterSummary{}
+	for key, doc := range docs {
+		var job queuedJob
+		if err := json.Unmarshal(doc, &job); err != nil {
+			log.Printf("Skipping unreadable dead letter %s: %v", key, err)
+			continue
+		}
+		summaries = append(summaries, deadLetterSummary{ID: job.ID, Event: job.Event, Attempts: job.Attempts, Received: job.Queued, Failed: job.Failed, Error: job.Error})
+	}
+	slices.SortFunc(summaries, func(a, b deadLetterSummary) int { return a.Failed.Compare(b.Failed) })
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(summaries); err != nil {
+		log.Printf("Error encoding dead letters: %v", err)
+	}
+}
+
+// handleDeadLetter serves, discards or re-drives one dead letter:
+// GET or DELETE /deadletters/{id}, POST /deadletters/{id}/redrive
+func (b *Bot) handleDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	var job queuedJob
+	found, err := b.store.Get(bucketDeadLetters, id, &job)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !found {
+		http.Error(w, "dead letter not found", http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&job); err != nil {
+			log.Printf("Error encoding dead letter %s: %v", id, err)
+		}
+	case http.MethodDelete:
+		if err := b.store.Delete(bucketDeadLetters, id); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Discarded dead letter %s (%s).", id, job.Event)
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodPost:
+		if err := b.redrive(&job); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// redrive moves a dead letter back to the webhooks bucket and handles it
+// again with a fresh set of attempts.
+func (b *Bot) redrive(job *queuedJob) error {
+	job.Attempts, job.Error, job.Failed = 0, "", time.Time{}
+	if b.queue == nil {
+		job.Attempts = 1
+	}
+	if err := b.store.Put(bucketWebhooks, job.ID, job); err != nil {
+		return fmt.Errorf("persisting webhook %s: %w", job.ID, err)
+	}
+	if err := b.store.Delete(bucketDeadLetters, job.ID); err != nil {
+		return fmt.Errorf("removing dead letter %s: %w", job.ID, err)
+	}
+	log.Printf("Re-driving dead letter %s (%s).", job.ID, job.Event)
+	if b.queue != nil {
+		b.queue.enqueue(job)
+		return nil
+	}
+	if err := b.processDelivery(job); err != nil {
+		log.Printf("Error handling re-driven webhook %s: %v", job.ID, err)
+	}
+	return nil
+}
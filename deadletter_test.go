@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// explodeCommand registers a command whose handler panics until defused.
+func explodeCommand(env *testEnv) (defuse func(), ran *int) {
+	armed, count := true, 0
+	env.bot.commands["explode"] = func(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, _ []string) {
+		count++
+		if armed {
+			panic("boom")
+		}
+	}
+	return func() { armed = false }, &count
+}
+
+func bucketSize(t *testing.T, store Store, bucket string) int {
+	t.Helper()
+	docs, err := store.List(bucket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(docs)
+}
+
+func TestHandledWebhooksLeaveTheInbox(t *testing.T) {
+	env := newTestEnv(t)
+	env.comment(t, "@prd-bot need_sub_task")
+
+	if n := bucketSize(t, env.bot.store, bucketWebhooks); n != 0 {
+		t.Errorf("expected the handled webhook to be removed, %d left", n)
+	}
+	if n := bucketSize(t, env.bot.store, bucketDeadLetters); n != 0 {
+		t.Errorf("expected no dead letters, got %d", n)
+	}
+}
+
+func TestPanickingHandlerIsDeadLettered(t *testing.T) {
+	env := newTestEnv(t)
+	explodeCommand(env)
+	before := deadLettersTotal.Value("issue_comment")
+
+	rec := env.comment(t, "@prd-bot explode")
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("the webhook should be accepted, got %d", rec.Code)
+	}
+	docs, err := env.bot.store.List(bucketDeadLetters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(docs))
+	}
+	for _, doc := range docs {
+		var job queuedJob
+		if err := json.Unmarshal(doc, &job); err != nil {
+			t.Fatal(err)
+		}
+		if job.Event != "issue_comment" || !strings.Contains(job.Error, "boom") || job.Failed.IsZero() || len(job.Payload) == 0 {
+			t.Errorf("unexpected dead letter %+v", job)
+		}
+	}
+	if n := bucketSize(t, env.bot.store, bucketWebhooks); n != 0 {
+		t.Errorf("the dead letter should leave the inbox, %d left", n)
+	}
+	if got := deadLettersTotal.Value("issue_comment") - before; got != 1 {
+		t.Errorf("dead letter counter increased by %v, want 1", got)
+	}
+}
+
+func TestRecoverDeliveries(t *testing.T) {
+	env := newTestEnv(t)
+	defuse, ran := explodeCommand(env)
+	defuse()
+	payload := commentPayload(t, "@prd-bot explode")
+	unfinished := newQueuedJob("unfinished", "issue_comment", payload)
+	unfinished.Attempts = 1
+	poison := newQueuedJob("poison", "issue_comment", payload)
+	poison.Attempts = maxDeliveryAttempts
+	for _, job := range []*queuedJob{unfinished, poison} {
+		if err := env.bot.store.Put(bucketWebhooks, job.ID, job); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	env.bot.recoverDeliveries()
+	env.bot.jobs.Wait()
+
+	if *ran != 1 {
+		t.Errorf("only the unfinished webhook should be handled again, the handler ran %d times", *ran)
+	}
+	if n := bucketSize(t, env.bot.store, bucketWebhooks); n != 0 {
+		t.Errorf("expected every webhook to leave the inbox, %d left", n)
+	}
+	var dead queuedJob
+	if found, _ := env.bot.store.Get(bucketDeadLetters, "poison", &dead); !found || !strings.Contains(dead.Error, "stopped") {
+		t.Errorf("the webhook attempted %d times should be dead-lettered, got %+v", maxDeliveryAttempts, dead)
+	}
+}
+
+func TestDeadLetterAPI(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	defuse, ran := explodeCommand(env)
+	env.comment(t, "@prd-bot explode")
+	env.comment(t, "@prd-bot explode")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /deadletters", env.bot.handleDeadLetters)
+	mux.HandleFunc("GET /deadletters/{id}", env.bot.handleDeadLetter)
+	mux.HandleFunc("DELETE /deadletters/{id}", env.bot.handleDeadLetter)
+	mux.HandleFunc("POST /deadletters/{id}/redrive", env.bot.handleDeadLetter)
+	call := func(method, url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		env.bot.jobs.Wait()
+		return rec
+	}
+
+	if rec := call(http.MethodGet, "/deadletters", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token returned %d", rec.Code)
+	}
+	rec := call(http.MethodGet, "/deadletters", "s3cret")
+	var summaries []deadLetterSummary
+	if err := json.Unmarshal(rec.Body.Bytes(), &summaries); err != nil || len(summaries) != 2 {
+		t.Fatalf("expected 2 dead letters, got %s (%v)", rec.Body, err)
+	}
+	if strings.Contains(rec.Body.String(), "payload") || !strings.Contains(summaries[0].Error, "boom") {
+		t.Errorf("the list should summarize the dead letters without payloads: %s", rec.Body)
+	}
+	first, second := summaries[0].ID, summaries[1].ID
+	if rec := call(http.MethodGet, "/deadletters/"+first, "s3cret"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "explode") {
+		t.Errorf("expected the dead letter with its payload, got %d %s", rec.Code, rec.Body)
+	}
+
+	defuse()
+	if rec := call(http.MethodPost, "/deadletters/"+first+"/redrive", "s3cret"); rec.Code != http.StatusAccepted {
+		t.Fatalf("redrive returned %d %s", rec.Code, rec.Body)
+	}
+	if *ran != 3 {
+		t.Errorf("the re-driven webhook should run the handler again, ran %d times", *ran)
+	}
+	if rec := call(http.MethodDelete, "/deadletters/"+second, "s3cret"); rec.Code != http.StatusNoContent {
+		t.Errorf("discard returned %d", rec.Code)
+	}
+	if rec := call(http.MethodPost, "/deadletters/"+second+"/redrive", "s3cret"); rec.Code != http.StatusNotFound {
+		t.Errorf("re-driving a discarded dead letter returned %d", rec.Code)
+	}
+	if n := bucketSize(t, env.bot.store, bucketDeadLetters) + bucketSize(t, env.bot.store, bucketWebhooks); n != 0 {
+		t.Errorf("expected empty buckets, %d documents left", n)
+	}
+}
+
+func TestJobQueueDeadLettersAfterMaxAttempts(t *testing.T) {
+	var settled []string
+	q := newJobQueue(func(job *queuedJob, reason string) { settled = append(settled, job.ID+": "+reason) })
+	now := time.Now()
+	q.now = func() time.Time { return now }
+
+	q.enqueue(newQueuedJob("failing", "issues", []byte(`{}`)))
+	for attempt := 1; attempt <= maxDeliveryAttempts; attempt++ {
+		job := q.lease(context.Background(), 0, nil)
+		if job == nil || job.Attempts != attempt {
+			t.Fatalf("attempt %d: unexpected lease %+v", attempt, job)
+		}
+		q.finish(job.ID, true, "client error")
+	}
+	q.enqueue(newQueuedJob("stuck", "issues", []byte(`{}`)))
+	for attempt := 1; attempt <= maxDeliveryAttempts; attempt++ {
+		if job := q.lease(context.Background(), 0, nil); job == nil || job.ID != "stuck" {
+			t.Fatalf("attempt %d: unexpected lease %+v", attempt, job)
+		}
+		now = now.Add(jobLeaseTimeout)
+	}
+	if job := q.lease(context.Background(), 0, nil); job != nil {
+		t.Fatalf("the stuck job should be given up, got %+v", job)
+	}
+	q.enqueue(newQueuedJob("fine", "issues", []byte(`{}`)))
+	q.finish(q.lease(context.Background(), 0, nil).ID, false, "")
+
+	want := []string{
+		"failing: failed on each of 3 attempts, last with: client error",
+		"stuck: the lease expired on each of 3 attempts",
+		"fine: ",
+	}
+	if strings.Join(settled, "\n") != strings.Join(want, "\n") {
+		t.Errorf("settled jobs:\n%s\nwant:\n%s", strings.Join(settled, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestBackgroundPanicsStayOutOfWebhookScopes(t *testing.T) {
+	env := newTestEnv(t)
+	scope := &eventScope{delivery: "d1", event: "issue_comment"}
+	ctx := withEventScope(context.Background(), scope)
+
+	env.bot.dispatch(context.Background(), func() { panic("background boom") })
+	env.bot.dispatch(ctx, func() {})
+	env.bot.jobs.Wait()
+	if err := scope.err(); err != nil {
+		t.Errorf("a background panic shouldn't fail the webhook: %v", err)
+	}
+
+	env.bot.dispatch(ctx, func() { panic("boom") })
+	scope.wg.Wait()
+	if err := scope.err(); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("the webhook's own panic should fail it, got %v", err)
+	}
+}
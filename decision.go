@@ -0,0 +1,193 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandRecordDecision = "record_decision"
+
+	// adrDir is where architecture decision records are committed.
+	adrDir = "docs/adr"
+
+	// noDecisionMarker is the model's answer when the discussion hasn't
+	// settled on an approach yet.
+	noDecisionMarker = "NO_DECISION"
+
+	// maxDiscussedComment and maxDiscussion cap the issue discussion sent
+	// to the model, in bytes; the oldest comments are dropped first.
+	maxDiscussedComment = 3000
+	maxDiscussion       = 30000
+)
+
+// adrNumberPattern matches the number prefix of ADR file names such as
+// 0007-use-postgres.md.
+var adrNumberPattern = regexp.MustCompile(`^(\d{4})-.*\.md$`)
+
+// processRecordDecision condenses the issue discussion into an Architecture
+// Decision Record and opens a pull request adding it as the next numbered
+// file under docs/adr. `@bot record_decision <title>` names the decision.
+func (b *Bot) processRecordDecision(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandRecordDecision, issueNum, repoOwner, repoName)
+
+	discussion, err := b.issueDiscussion(ctx, client, repoOwner, repoName, issue)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "record the decision", "Could not read the issue discussion", err)
+		return
+	}
+	title, record, err := generateDecisionRecord(ctx, b.llm, issue, discussion, strings.Join(args, " "))
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "record the decision", "Could not write the decision record", err)
+		return
+	}
+	if record == "" {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I couldn't find a decision in this discussion yet. Once the approach is agreed on, run `@%s %s` again.", b.appName, CommandRecordDecision))
+		return
+	}
+
+	number, err := nextADRNumber(ctx, client, repo)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "record the decision", fmt.Sprintf("Could not list `%s`", adrDir), err)
+		return
+	}
+	slug := cmp.Or(slugify(title), fmt.Sprintf("issue-%d", issueNum))
+	path := fmt.Sprintf("%s/%04d-%s.md", adrDir, number, slug)
+	pr, err := openFilePullRequest(ctx, client, repo, filePullRequest{
+		Branch:  fmt.Sprintf("docs/adr-%04d-%s", number, slug),
+		Path:    path,
+		Content: formatDecisionRecord(number, title, record, issue, time.Now()),
+		Message: fmt.Sprintf("docs: Record decision %04d from #%d", number, issueNum),
+		Title:   fmt.Sprintf("ADR %04d: %s", number, title),
+		Body:    fmt.Sprintf("This PR records the decision reached in #%d as `%s`. It was automatically generated by @%s; check that it reflects what was agreed before merging.", issueNum, path, b.appName),
+	})
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "record the decision", "Could not open the decision record pull request", err)
+		return
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've opened a Pull Request recording this decision as ADR %04d in `%s`: %s", number, path, pr.GetHTMLURL()))
+}
+
+// issueDiscussion returns the issue body and its comments as a transcript,
+// leaving out commands to the bot and the oldest comments past
+// maxDiscussion.
+func (b *Bot) issueDiscussion(ctx context.Context, client *github.Client, owner, repo string, issue *github.Issue) (string, error) {
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	var entries []string
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, repo, issue.GetNumber(), opts)
+		if err != nil {
+			return "", fmt.Errorf("listing comments of issue #%d: %w", issue.GetNumber(), err)
+		}
+		for _, c := range comments {
+			if _, _, ok := b.parseComment(c.GetBody()); ok {
+				continue
+			}
+			body := strings.TrimSpace(c.GetBody())
+			if len(body) > maxDiscussedComment {
+				body = body[:maxDiscussedComment] + "\n[comment truncated]"
+			}
+			entries = append(entries, fmt.Sprintf("**@%s** (%s):\n%s", c.GetUser().GetLogin(), c.GetCreatedAt().Format("2006-01-02"), body))
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	transcript := strings.Join(entries, "\n\n---\n\n")
+	for len(transcript) > maxDiscussion && len(entries) > 1 {
+		entries = entries[1:]
+		transcript = "[earlier comments omitted]\n\n" + strings.Join(entries, "\n\n---\n\n")
+	}
+	if len(transcript) > maxDiscussion {
+		transcript = transcript[len(transcript)-maxDiscussion:]
+	}
+	return fmt.Sprintf("**Issue by @%s:**\n%s\n\n**Comments:**\n\n%s", issue.GetUser().GetLogin(), issue.GetBody(), transcript), nil
+}
+
+// generateDecisionRecord asks the model for the ADR sections and a title,
+// or the given title when there is one. The record is empty when the
+// discussion hasn't reached a decision.
+func generateDecisionRecord(ctx context.Context, llm Generator, issue *github.Issue, discussion, title string) (string, string, error) {
+	titleRule := "Start with one line `Title: <the decision as a short imperative phrase, e.g. Use PostgreSQL for report storage>`."
+	if title != "" {
+		titleRule = fmt.Sprintf("Start with the line `Title: %s`.", title)
+	}
+	prompt := fmt.Sprintf(
+		"Condense the following GitHub issue discussion into an Architecture Decision Record (ADR).\n\n"+
+			"%s Then write these sections as GitHub-flavored Markdown with `##` headings:\n"+
+			"## Context\n(The problem and the forces at play, in a few sentences)\n"+
+			"## Decision\n(The chosen approach, stated actively: \"We will ...\")\n"+
+			"## Considered Options\n(Each alternative discussed and why it was not chosen)\n"+
+			"## Consequences\n(What becomes easier or harder, follow-up work and risks)\n\n"+
+			"Only record what the participants agreed on; attribute open concerns instead of resolving them. "+
+			"If the discussion hasn't settled on an approach, answer only `%s`.\n\n"+
+			"**Issue Title:** %s\n\n%s",
+		titleRule, noDecisionMarker, issue.GetTitle(), discussion,
+	)
+	out, err := generateMarkdown(withSystemPrompt(ctx, CommandRecordDecision), llm, prompt)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate decision record: %w", err)
+	}
+	out = strings.TrimSpace(out)
+	if out == noDecisionMarker {
+		return "", "", nil
+	}
+	first, rest, _ := strings.Cut(out, "\n")
+	if t, ok := strings.CutPrefix(strings.TrimSpace(first), "Title:"); ok {
+		out = strings.TrimSpace(rest)
+		if title == "" {
+			title = strings.Trim(strings.TrimSpace(t), "`*")
+		}
+	}
+	if title == "" {
+		title = issue.GetTitle()
+	}
+	if out == "" {
+		return "", "", fmt.Errorf("%w: empty decision record", ErrModelInvalid)
+	}
+	return title, out, nil
+}
+
+// nextADRNumber returns the number following the highest numbered record
+// under docs/adr, or 1 when there is none.
+func nextADRNumber(ctx context.Context, client *github.Client, repo *github.Repository) (int, error) {
+	_, entries, resp, err := client.Repositories.GetContents(ctx, repo.GetOwner().GetLogin(), repo.GetName(), adrDir, &github.RepositoryContentGetOptions{Ref: repo.GetDefaultBranch()})
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return 1, nil
+		}
+		return 0, err
+	}
+	highest := 0
+	for _, entry := range entries {
+		if m := adrNumberPattern.FindStringSubmatch(entry.GetName()); m != nil {
+			if n, _ := strconv.Atoi(m[1]); n > highest {
+				highest = n
+			}
+		}
+	}
+	return highest + 1, nil
+}
+
+// formatDecisionRecord renders ADR number in the common Nygard layout.
+func formatDecisionRecord(number int, title, record string, issue *github.Issue, now time.Time) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %d. %s\n\n", number, title)
+	fmt.Fprintf(&b, "Date: %s\n\n", now.UTC().Format("2006-01-02"))
+	b.WriteString("## Status\n\nAccepted\n\n")
+	b.WriteString(record + "\n\n")
+	fmt.Fprintf(&b, "## References\n\n- Discussed in #%d: %s\n", issue.GetNumber(), issue.GetTitle())
+	return b.String()
+}
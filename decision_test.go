@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const decisionReply = "Title: Stream CSV exports from the read replica\n\n## Context\nLarge reports time out.\n\n## Decision\nWe will stream rows from the read replica.\n\n## Considered Options\n- Background jobs: too slow to ship.\n\n## Consequences\nExports no longer load the primary."
+
+func TestRecordDecisionOpensNumberedADR(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "docs/adr/0001-record-architecture-decisions.md", "# 1. Record architecture decisions")
+	env.github.addFile("acme", "widgets", "docs/adr/0007-use-postgres.md", "# 7. Use PostgreSQL")
+	env.github.addFile("acme", "widgets", "docs/adr/README.md", "Decisions")
+	env.github.addCommentBy("acme", "widgets", 42, "alice", "Should we use background jobs or stream from the replica?")
+	env.github.addCommentBy("acme", "widgets", 42, "bob", "Streaming from the replica, agreed.")
+	env.github.addCommentBy("acme", "widgets", 42, "carol", "@prd-bot need_prd")
+	env.gemini.on("Architecture Decision Record", decisionReply)
+
+	env.comment(t, "@prd-bot record_decision")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "**@bob**") || !strings.Contains(prompt, "Streaming from the replica, agreed.") || strings.Contains(prompt, "need_prd") {
+		t.Errorf("the prompt should hold the discussion without bot commands:\n%s", prompt)
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected an ADR pull request, got %d", len(pulls))
+	}
+	const path = "docs/adr/0008-stream-csv-exports-from-the-read-replica.md"
+	branch := pulls[0].GetHead().GetRef()
+	adr, ok := env.github.committed("acme", "widgets", branch, path)
+	if !ok {
+		t.Fatalf("%s was not committed on %s", path, branch)
+	}
+	for _, want := range []string{"# 8. Stream CSV exports from the read replica\n", "## Status\n\nAccepted", "We will stream rows", "- Discussed in #42"} {
+		if !strings.Contains(adr, want) {
+			t.Errorf("the ADR should contain %q:\n%s", want, adr)
+		}
+	}
+	if strings.Contains(adr, "Title:") {
+		t.Errorf("the title line should become the heading:\n%s", adr)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "ADR 0008") || !strings.Contains(body, pulls[0].GetHTMLURL()) {
+		t.Errorf("expected a link to the pull request:\n%s", body)
+	}
+}
+
+func TestRecordDecisionFirstADRWithTitle(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Architecture Decision Record", "Title: Use CSV\n\n## Decision\nWe will export CSV.")
+
+	env.comment(t, "@prd-bot record_decision Export CSV only")
+
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "`Title: Export CSV only`") {
+		t.Errorf("the given title should be requested:\n%s", prompt)
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected an ADR pull request, got %d", len(pulls))
+	}
+	if _, ok := env.github.committed("acme", "widgets", pulls[0].GetHead().GetRef(), "docs/adr/0001-export-csv-only.md"); !ok {
+		t.Errorf("expected the first ADR at docs/adr/0001-export-csv-only.md")
+	}
+}
+
+func TestRecordDecisionWithoutDecision(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Architecture Decision Record", noDecisionMarker)
+
+	env.comment(t, "@prd-bot record_decision")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("no ADR should be opened without a decision, got %d", len(pulls))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "couldn't find a decision") {
+		t.Errorf("unexpected reply:\n%s", body)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// Values of execution.backend.
+	executionBot     = "bot"     // clone, edit and push on the bot's host (default)
+	executionActions = "actions" // hand the job to a workflow of the repository
+
+	// Values of execution.checkout.
+	checkoutFull  = "full"  // the whole repository, with the commit backend (default)
+	checkoutFiles = "files" // only the files to change, through the Git Data API
+
+	// Values of execution.editor.
+	editorCLI   = "cli"   // the Gemini CLI edits the files to change (default)
+	editorAgent = "agent" // the model explores the checkout with tools and edits it
+
+	// defaultDispatchEvent is the repository_dispatch event type the
+	// workflow listens for when execution.event_type isn't set.
+	defaultDispatchEvent = "agent-prd-implement"
+)
+
+// ExecutionConfig selects where implement_feature runs. With the actions
+// backend the bot only dispatches the job; a workflow of the repository
+// clones, edits, tests and opens the pull request on its own runners.
+// Checkout "files" suits repositories too large to clone: the bot then
+// fetches and commits only the files to change, through the Git Data API.
+// Editor "agent" lets the model list, read and search the checkout and run
+// its tests before and while it edits, instead of the Gemini CLI. OnBusy
+// "reject" declines an implementation of an issue another one is working on,
+// instead of waiting for it.
+type ExecutionConfig struct {
+	Backend   string `yaml:"backend"`
+	EventType string `yaml:"event_type"`
+	Checkout  string `yaml:"checkout"`
+	Editor    string `yaml:"editor"`
+	OnBusy    string `yaml:"on_busy"`
+}
+
+func (c *ExecutionConfig) dispatched() bool {
+	return c != nil && strings.EqualFold(c.Backend, executionActions)
+}
+
+// filesOnly reports whether implement_feature fetches only the files it
+// changes instead of the whole repository.
+func (c *ExecutionConfig) filesOnly() bool {
+	return c != nil && strings.EqualFold(c.Checkout, checkoutFiles)
+}
+
+// rejectsWhenBusy reports whether an implementation started while another
+// one works on the same issue is declined rather than queued.
+func (c *ExecutionConfig) rejectsWhenBusy() bool {
+	return c != nil && strings.EqualFold(c.OnBusy, busyReject)
+}
+
+// agentEditor reports whether the model edits the checkout through tools.
+func (c *ExecutionConfig) agentEditor() bool {
+	return c != nil && strings.EqualFold(c.Editor, editorAgent)
+}
+
+func (c *ExecutionConfig) eventType() string {
+	if c == nil || c.EventType == "" {
+		return defaultDispatchEvent
+	}
+	return c.EventType
+}
+
+// dispatchJob is the client_payload of the repository_dispatch event. GitHub
+// allows at most 10 top-level properties.
+type dispatchJob struct {
+	Issue         int      `json:"issue"`
+	Base          string   `json:"base"`
+	Branch        string   `json:"branch"`
+	CommitMessage string   `json:"commit_message"`
+	Files         []string `json:"files"`
+	Plan          string   `json:"plan,omitempty"`
+}
+
+// dispatchImplementation sends the implement_feature job for issue to the
+// repository's workflow instead of running it here.
+func (b *Bot) dispatchImplementation(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, base string, files []string, plan string, cfg *ExecutionConfig) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+
+	naming := b.repoConfig(ctx, client, repo).Naming
+	branchName := naming.branchName(issue, time.Now())
+	if _, _, err := client.Git.GetRef(ctx, repoOwner, repoName, "heads/"+branchName); err == nil {
+		branchName = fmt.Sprintf("%s-%d", branchName, time.Now().Unix())
+	}
+	job := dispatchJob{
+		Issue:         issueNum,
+		Base:          base,
+		Branch:        branchName,
+		CommitMessage: naming.commitMessage(issue, "", time.Now()),
+		Files:         files,
+		Plan:          plan,
+	}
+	data, err := json.Marshal(job)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "implement the feature", "Could not encode the job", err)
+		return
+	}
+	payload := json.RawMessage(data)
+	event := cfg.eventType()
+	if _, _, err := client.Repositories.Dispatch(ctx, repoOwner, repoName, github.DispatchRequestOptions{EventType: event, ClientPayload: &payload}); err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "implement the feature", "Could not dispatch the job to GitHub Actions", githubError(ErrDispatchFailed, err))
+		return
+	}
+	log.Printf("Dispatched '%s' for issue #%d in %s/%s as a %q event", CommandImplementFeature, issueNum, repoOwner, repoName, event)
+	message := fmt.Sprintf("I've handed issue #%d to the repository's GitHub Actions workflow (`repository_dispatch` event `%s`), which will push `%s` and open the Pull Request from your own runners. Follow it in [Actions](%s/actions).",
+		issueNum, event, branchName, b.repoWebURL(repo))
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, message)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestImplementFeatureDispatchesToActions(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  backend: actions\n")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if executed := env.runner.executed(); len(executed) != 0 {
+		t.Errorf("nothing should run on the bot's host, ran %q", executed)
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("the workflow opens the pull request, not the bot; got %d", len(pulls))
+	}
+	if len(env.github.dispatches) != 1 {
+		t.Fatalf("expected one repository_dispatch event, got %d", len(env.github.dispatches))
+	}
+	dispatch := env.github.dispatches[0]
+	if dispatch.EventType != defaultDispatchEvent {
+		t.Errorf("event type = %q, want %q", dispatch.EventType, defaultDispatchEvent)
+	}
+	var job dispatchJob
+	if err := json.Unmarshal(*dispatch.ClientPayload, &job); err != nil {
+		t.Fatalf("decoding client payload: %v", err)
+	}
+	if job.Issue != 42 || job.Base != "main" || !strings.HasPrefix(job.Branch, "feature/issue-42-") || !slices.Equal(job.Files, []string{"report.go", "export/csv.go"}) || job.CommitMessage == "" {
+		t.Errorf("unexpected job %+v", job)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "`repository_dispatch` event `agent-prd-implement`") || !strings.Contains(body, "/acme/widgets/actions") {
+		t.Errorf("expected a link to the workflow runs:\n%s", body)
+	}
+}
+
+func TestImplementFeatureDispatchRejected(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  backend: actions\n  event_type: build-feature\n")
+	env.github.dispatchStatus = http.StatusForbidden
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "NO_WRITE_ACCESS") {
+		t.Errorf("a forbidden dispatch should be reported as missing permission:\n%s", body)
+	}
+}
+
+func TestExecutionConfig(t *testing.T) {
+	var unset *ExecutionConfig
+	if unset.dispatched() || unset.eventType() != defaultDispatchEvent {
+		t.Error("jobs should run on the bot's host by default")
+	}
+	cfg := &ExecutionConfig{Backend: "Actions", EventType: "build-feature"}
+	if !cfg.dispatched() || cfg.eventType() != "build-feature" {
+		t.Errorf("unexpected defaults of %+v", cfg)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ConfigChecklistIdentifier heads the pull request section listing the
+// configuration a generated change needs at deploy time.
+const ConfigChecklistIdentifier = "### Configuration Required"
+
+// configReferencePatterns match reads of environment variables and CI
+// secrets in the languages the bot commonly edits. The non-empty group holds
+// the variable name.
+var configReferencePatterns = []*regexp.Regexp{
+	regexp.MustCompile(`os\.(?:Getenv|LookupEnv|getenv)\(\s*["']([A-Za-z_][A-Za-z0-9_]*)["']`),             // Go, Python
+	regexp.MustCompile(`os\.environ(?:\.get)?[\[(]\s*["']([A-Za-z_][A-Za-z0-9_]*)["']`),                    // Python
+	regexp.MustCompile(`process\.env(?:\.([A-Za-z_][A-Za-z0-9_]*)|\[\s*["']([A-Za-z_][A-Za-z0-9_]*)["'])`), // Node.js
+	regexp.MustCompile(`ENV(?:\.fetch\(|\[)\s*["']([A-Za-z_][A-Za-z0-9_]*)["']`),                           // Ruby
+	regexp.MustCompile(`System\.getenv\(\s*"([A-Za-z_][A-Za-z0-9_]*)"`),                                    // Java
+}
+
+// secretPattern matches GitHub Actions secrets, e.g. `${{ secrets.API_KEY }}`.
+var secretPattern = regexp.MustCompile(`\$\{\{\s*secrets\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+// secretNameHints mark environment variables which likely hold credentials.
+var secretNameHints = []string{"KEY", "TOKEN", "SECRET", "PASSWORD", "PASSWD", "CREDENTIAL", "PRIVATE", "DSN"}
+
+// configReference is a piece of configuration read by new code.
+type configReference struct {
+	Name   string
+	Secret bool     // a CI secret or an environment variable that likely holds a credential
+	CI     bool     // a GitHub Actions secret rather than an environment variable
+	Files  []string // files that read it
+}
+
+// scanConfigReferences returns the environment variables and CI secrets read
+// by lines added in diff, a unified diff, that weren't already read by the
+// lines it removes. References are returned in order of first appearance.
+func scanConfigReferences(diff string) []configReference {
+	var refs []configReference
+	removed := make(map[string]bool)
+	file := ""
+	for _, line := range strings.Split(diff, "\n") {
+		switch {
+		case strings.HasPrefix(line, "+++ "):
+			file = strings.TrimPrefix(strings.TrimPrefix(line, "+++ "), "b/")
+		case strings.HasPrefix(line, "--- "):
+		case strings.HasPrefix(line, "-"):
+			for _, name := range configNames(line) {
+				removed[name.key()] = true
+			}
+		case strings.HasPrefix(line, "+"):
+			for _, name := range configNames(line) {
+				i := slices.IndexFunc(refs, func(r configReference) bool { return r.Name == name.name && r.CI == name.ci })
+				if i < 0 {
+					refs = append(refs, configReference{Name: name.name, CI: name.ci, Secret: name.ci || looksSecret(name.name)})
+					i = len(refs) - 1
+				}
+				if file != "" && !slices.Contains(refs[i].Files, file) {
+					refs[i].Files = append(refs[i].Files, file)
+				}
+			}
+		}
+	}
+	return slices.DeleteFunc(refs, func(r configReference) bool {
+		return removed[configName{r.Name, r.CI}.key()]
+	})
+}
+
+type configName struct {
+	name string
+	ci   bool
+}
+
+func (n configName) key() string {
+	if n.ci {
+		return "secrets." + n.name
+	}
+	return n.name
+}
+
+func configNames(line string) []configName {
+	var names []configName
+	for _, re := range configReferencePatterns {
+		for _, m := range re.FindAllStringSubmatch(line, -1) {
+			for _, group := range m[1:] {
+				if group != "" {
+					names = append(names, configName{name: group})
+				}
+			}
+		}
+	}
+	for _, m := range secretPattern.FindAllStringSubmatch(line, -1) {
+		names = append(names, configName{name: m[1], ci: true})
+	}
+	return names
+}
+
+func looksSecret(name string) bool {
+	upper := strings.ToUpper(name)
+	return slices.ContainsFunc(secretNameHints, func(hint string) bool { return strings.Contains(upper, hint) })
+}
+
+// formatConfigChecklist renders refs as a pull request checklist, or returns
+// an empty string when there are none.
+func formatConfigChecklist(refs []configReference) string {
+	if len(refs) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(ConfigChecklistIdentifier + "\n\n")
+	b.WriteString("This change reads configuration that must be set wherever it is deployed:\n\n")
+	for _, ref := range refs {
+		kind := "environment variable"
+		switch {
+		case ref.CI:
+			kind = "GitHub Actions secret"
+		case ref.Secret:
+			kind = "environment variable, likely a secret"
+		}
+		fmt.Fprintf(&b, "- [ ] `%s` (%s)", ref.Name, kind)
+		if len(ref.Files) > 0 {
+			fmt.Fprintf(&b, " — used in `%s`", strings.Join(ref.Files, "`, `"))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const configDiff = `diff --git a/export/csv.go b/export/csv.go
+--- a/export/csv.go
++++ b/export/csv.go
+@@ -3 +3,2 @@
+-	dir := os.Getenv("EXPORT_DIR")
++	dir := os.Getenv("EXPORT_DIR")
++	key := os.Getenv("S3_ACCESS_KEY")
+diff --git a/web/upload.js b/web/upload.js
+--- a/web/upload.js
++++ b/web/upload.js
+@@ -1 +1 @@
++const bucket = process.env.EXPORT_BUCKET || process.env["S3_ACCESS_KEY"];
+diff --git a/.github/workflows/deploy.yml b/.github/workflows/deploy.yml
+--- a/.github/workflows/deploy.yml
++++ b/.github/workflows/deploy.yml
+@@ -9 +9 @@
++          token: ${{ secrets.DEPLOY_TOKEN }}
+`
+
+func TestScanConfigReferences(t *testing.T) {
+	refs := scanConfigReferences(configDiff)
+
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.Name)
+	}
+	if want := "S3_ACCESS_KEY EXPORT_BUCKET DEPLOY_TOKEN"; strings.Join(got, " ") != want {
+		t.Fatalf("references = %q, want %q (EXPORT_DIR was already read)", got, want)
+	}
+	if key := refs[0]; !key.Secret || key.CI || strings.Join(key.Files, ",") != "export/csv.go,web/upload.js" {
+		t.Errorf("S3_ACCESS_KEY = %+v", key)
+	}
+	if bucket := refs[1]; bucket.Secret {
+		t.Errorf("EXPORT_BUCKET should not look like a secret: %+v", bucket)
+	}
+	if deploy := refs[2]; !deploy.CI || !deploy.Secret {
+		t.Errorf("DEPLOY_TOKEN = %+v", deploy)
+	}
+
+	checklist := formatConfigChecklist(refs)
+	for _, want := range []string{
+		ConfigChecklistIdentifier,
+		"- [ ] `S3_ACCESS_KEY` (environment variable, likely a secret) — used in `export/csv.go`, `web/upload.js`",
+		"- [ ] `DEPLOY_TOKEN` (GitHub Actions secret)",
+	} {
+		if !strings.Contains(checklist, want) {
+			t.Errorf("checklist is missing %q:\n%s", want, checklist)
+		}
+	}
+	if formatConfigChecklist(nil) != "" {
+		t.Error("no checklist expected without references")
+	}
+}
+
+func TestImplementFeatureListsRequiredConfiguration(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git diff --cached": configDiff}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(pulls))
+	}
+	if body := pulls[0].GetBody(); !strings.Contains(body, ConfigChecklistIdentifier) || !strings.Contains(body, "`DEPLOY_TOKEN`") {
+		t.Errorf("pull request body should list the required configuration:\n%s", body)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// EpicProgressIdentifier starts the comment rolling up the progress of a
+	// parent issue's sub-issues.
+	EpicProgressIdentifier = "### Epic Progress"
+
+	// The progress section of a parent issue's body sits between these markers.
+	epicBodyStart = "<!-- agent-prd:epic-progress -->"
+	epicBodyEnd   = "<!-- /agent-prd:epic-progress -->"
+
+	defaultBlockerLabel = "blocked"
+	maxEpicSubIssues    = 100
+	epicProgressBarSize = 20
+)
+
+// EpicProgressConfig keeps a progress comment on parent issues the bot wrote
+// a PRD or sub-tasks for, refreshed whenever one of their sub-issues is
+// added, removed, closed, reopened or (un)labeled as blocked.
+type EpicProgressConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// IssueBody also keeps the progress in a section of the parent issue's
+	// body.
+	IssueBody bool `yaml:"issue_body"`
+	// BlockerLabels mark open sub-issues as blockers; "blocked" by default.
+	BlockerLabels []string `yaml:"blocker_labels"`
+}
+
+func (c *EpicProgressConfig) enabled() bool {
+	return c != nil && c.Enabled
+}
+
+func (c *EpicProgressConfig) blockerLabels() []string {
+	if c == nil || len(c.BlockerLabels) == 0 {
+		return []string{defaultBlockerLabel}
+	}
+	return c.BlockerLabels
+}
+
+// isBlocker reports whether label marks blocked sub-issues.
+func (c *EpicProgressConfig) isBlocker(label string) bool {
+	return slices.ContainsFunc(c.blockerLabels(), func(l string) bool { return strings.EqualFold(l, label) })
+}
+
+// epicItem is a sub-issue of an epic and what holds it up.
+type epicItem struct {
+	Issue   *github.Issue
+	Pulls   []*github.PullRequest // the bot's open pull requests for it
+	Blocker string                // the blocker label of an open sub-issue, if any
+}
+
+// handleEpicSubIssue refreshes the progress of the parent of issue, a
+// sub-issue that was closed, reopened or labeled. label is the label added
+// or removed, if any; other labels don't change the progress.
+func (b *Bot) handleEpicSubIssue(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, label *github.Label) {
+	b.dispatch(ctx, func() {
+		ctx := context.Background()
+		cfg := b.repoConfig(ctx, client, repo).EpicProgress
+		if !cfg.enabled() || (label != nil && !cfg.isBlocker(label.GetName())) {
+			return
+		}
+		parent, err := parentIssue(ctx, client, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber())
+		if err != nil {
+			log.Printf("Error looking up the parent of issue #%d: %v", issue.GetNumber(), err)
+			return
+		}
+		if parent != nil {
+			b.refreshEpic(ctx, client, repo, parent.GetNumber(), cfg)
+		}
+	})
+}
+
+// refreshEpic updates the progress comment, and the body section when
+// configured, of the parent issue parentNum.
+func (b *Bot) refreshEpic(ctx context.Context, client *github.Client, repo *github.Repository, parentNum int, cfg *EpicProgressConfig) {
+	repoOwner, repoName := repo.GetOwner().GetLogin(), repo.GetName()
+	// Only epics the bot planned: sub-issues of other issues are left alone.
+	prd, _ := b.loadArtifact(repoOwner, repoName, parentNum, ArtifactPRD)
+	subTasks, _ := b.loadArtifact(repoOwner, repoName, parentNum, ArtifactSubTasks)
+	if prd == nil && subTasks == nil {
+		return
+	}
+	b.epicMu.Lock()
+	defer b.epicMu.Unlock()
+
+	subIssues, err := listSubIssues(ctx, client, repoOwner, repoName, parentNum)
+	if err != nil {
+		log.Printf("Error listing the sub-issues of #%d in %s: %v", parentNum, repo.GetFullName(), err)
+		return
+	}
+	items := b.epicItems(ctx, client, repoOwner, repoName, subIssues, cfg)
+	progress := formatEpicProgress(items)
+
+	comment, err := findEpicProgressComment(ctx, client, repoOwner, repoName, parentNum)
+	if err != nil {
+		log.Printf("Error looking for the progress comment of #%d: %v", parentNum, err)
+		return
+	}
+	body := EpicProgressIdentifier + "\n\n" + progress + "\n\n_Updated automatically when sub-issues change._"
+	switch {
+	case comment == nil && len(items) > 0:
+		b.postComment(ctx, client, repoOwner, repoName, parentNum, body)
+	case comment != nil && comment.GetBody() != body:
+		if _, _, err := client.Issues.EditComment(ctx, repoOwner, repoName, comment.GetID(), &github.IssueComment{Body: github.String(body)}); err != nil {
+			log.Printf("Error updating the progress comment of #%d: %v", parentNum, err)
+		}
+	}
+
+	if cfg.IssueBody {
+		parent, _, err := client.Issues.Get(ctx, repoOwner, repoName, parentNum)
+		if err != nil {
+			log.Printf("Error loading issue #%d to update its progress: %v", parentNum, err)
+			return
+		}
+		if updated := withEpicSection(parent.GetBody(), progress); updated != parent.GetBody() {
+			if _, _, err := client.Issues.Edit(ctx, repoOwner, repoName, parentNum, &github.IssueRequest{Body: github.String(updated)}); err != nil {
+				log.Printf("Error updating the progress section of #%d: %v", parentNum, err)
+			}
+		}
+	}
+}
+
+// epicItems looks up the open pull requests and blockers of subIssues.
+func (b *Bot) epicItems(ctx context.Context, client *github.Client, owner, repo string, subIssues []*github.Issue, cfg *EpicProgressConfig) []epicItem {
+	numbers := make([]int, len(subIssues))
+	for i, issue := range subIssues {
+		numbers[i] = issue.GetNumber()
+	}
+	recorded := b.relatedPullRequests(owner, repo, numbers...)
+	items := make([]epicItem, len(subIssues))
+	for i, issue := range subIssues {
+		items[i].Issue = issue
+		if issue.GetState() == "closed" {
+			continue
+		}
+		for _, label := range issue.Labels {
+			if cfg.isBlocker(label.GetName()) {
+				items[i].Blocker = label.GetName()
+				break
+			}
+		}
+		for _, record := range recorded {
+			if record.Issue != issue.GetNumber() {
+				continue
+			}
+			pr, _, err := client.PullRequests.Get(ctx, owner, repo, record.Number)
+			if err != nil {
+				log.Printf("Error loading pull request #%d of sub-issue #%d: %v", record.Number, issue.GetNumber(), err)
+				continue
+			}
+			if pr.GetState() == "open" {
+				items[i].Pulls = append(items[i].Pulls, pr)
+			}
+		}
+	}
+	return items
+}
+
+// formatEpicProgress renders the completion, the sub-issues and the
+// blockers of an epic.
+func formatEpicProgress(items []epicItem) string {
+	if len(items) == 0 {
+		return "This issue has no sub-issues."
+	}
+	closed := 0
+	var blockers []string
+	for _, item := range items {
+		if item.Issue.GetState() == "closed" {
+			closed++
+		}
+		if item.Blocker != "" {
+			blockers = append(blockers, fmt.Sprintf("#%d %s (`%s`)", item.Issue.GetNumber(), item.Issue.GetTitle(), item.Blocker))
+		}
+	}
+	percent := closed * 100 / len(items)
+	filled := closed * epicProgressBarSize / len(items)
+
+	var s strings.Builder
+	fmt.Fprintf(&s, "**%d of %d sub-issues done (%d%%)**\n\n", closed, len(items), percent)
+	fmt.Fprintf(&s, "`[%s%s]`\n\n", strings.Repeat("#", filled), strings.Repeat("-", epicProgressBarSize-filled))
+	s.WriteString("| Sub-issue | Status | Assignees | Open pull requests |\n|---|---|---|---|\n")
+	for _, item := range items {
+		status := "Open"
+		switch {
+		case item.Issue.GetState() == "closed":
+			status = "Done"
+		case item.Blocker != "":
+			status = "Blocked"
+		case len(item.Pulls) > 0:
+			status = "In review"
+		}
+		var assignees, pulls []string
+		for _, user := range item.Issue.Assignees {
+			assignees = append(assignees, "@"+user.GetLogin())
+		}
+		for _, pr := range item.Pulls {
+			pulls = append(pulls, fmt.Sprintf("#%d", pr.GetNumber()))
+		}
+		title := strings.ReplaceAll(item.Issue.GetTitle(), "|", `\|`)
+		fmt.Fprintf(&s, "| #%d %s | %s | %s | %s |\n", item.Issue.GetNumber(), title, status, strings.Join(assignees, ", "), strings.Join(pulls, ", "))
+	}
+	if len(blockers) > 0 {
+		s.WriteString("\n**Blockers:**\n")
+		for _, blocker := range blockers {
+			s.WriteString("- " + blocker + "\n")
+		}
+	}
+	return strings.TrimRight(s.String(), "\n")
+}
+
+// withEpicSection returns body with its progress section replaced by
+// progress, or progress appended as a new section.
+func withEpicSection(body, progress string) string {
+	section := epicBodyStart + "\n" + EpicProgressIdentifier + "\n\n" + progress + "\n" + epicBodyEnd
+	if start := strings.Index(body, epicBodyStart); start >= 0 {
+		if end := strings.Index(body[start:], epicBodyEnd); end >= 0 {
+			return body[:start] + section + body[start+end+len(epicBodyEnd):]
+		}
+	}
+	if strings.TrimSpace(body) == "" {
+		return section
+	}
+	return strings.TrimRight(body, "\n") + "\n\n" + section
+}
+
+// listSubIssues returns the sub-issues of an issue, in their order.
+func listSubIssues(ctx context.Context, client *github.Client, owner, repo string, number int) ([]*github.Issue, error) {
+	req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/issues/%d/sub_issues?per_page=%d", owner, repo, number, maxEpicSubIssues), nil)
+	if err != nil {
+		return nil, err
+	}
+	var issues []*github.Issue
+	if _, err := client.Do(ctx, req, &issues); err != nil {
+		return nil, err
+	}
+	return issues, nil
+}
+
+func findEpicProgressComment(ctx context.Context, client *github.Client, repoOwner, repoName string, issueNum int) (*github.IssueComment, error) {
+	comments, _, err := client.Issues.ListComments(ctx, repoOwner, repoName, issueNum, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching comments for issue #%d: %w", issueNum, err)
+	}
+	for i := len(comments) - 1; i >= 0; i-- {
+		if strings.HasPrefix(comments[i].GetBody(), EpicProgressIdentifier) {
+			return comments[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// setUpEpic makes #42 a parent the bot broke down into three sub-issues:
+// #101 done, #102 with an open pull request and #103 blocked.
+func setUpEpic(t *testing.T, env *testEnv, config string) {
+	t.Helper()
+	env.github.addFile("acme", "widgets", RepoConfigPath, config)
+	parent := env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	parent.Body = github.String("Analysts need CSV exports.")
+	env.bot.saveArtifact(ArtifactSubTasks, "acme", "widgets", parent, SubTasksIdentifier+"\n\n- [ ] Add the CSV encoder", nil)
+
+	env.github.addIssue("acme", "widgets", 101, "Add the CSV encoder", "closed")
+	inReview := env.github.addIssue("acme", "widgets", 102, "Add the export button", "open")
+	inReview.Assignees = []*github.User{{Login: github.String("dave")}}
+	blocked := env.github.addIssue("acme", "widgets", 103, "Document | the export", "open")
+	blocked.Labels = []*github.Label{{Name: github.String("Blocked")}}
+	for _, number := range []int{101, 102, 103} {
+		env.github.setParent("acme", "widgets", number, 42)
+	}
+	pr := env.github.addPull("acme", "widgets", "Add the export button")
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: pr, Issue: 102})
+}
+
+// issueClosedPayload is an issues webhook closing number.
+func issueClosedPayload(t *testing.T, number int) []byte {
+	t.Helper()
+	payload, err := json.Marshal(map[string]any{
+		"action":       "closed",
+		"issue":        &github.Issue{Number: github.Int(number), State: github.String("closed")},
+		"repository":   map[string]any{"name": "widgets", "full_name": "acme/widgets", "default_branch": "main", "owner": map[string]any{"login": "acme"}},
+		"installation": map[string]any{"id": 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestEpicProgressComment(t *testing.T) {
+	env := newTestEnv(t)
+	setUpEpic(t, env, "epic_progress:\n  enabled: true\n  issue_body: true\n")
+
+	env.deliverPayload(t, "sub_issues", subIssuePayload(t, 103, "Document | the export"))
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected a progress comment, got %d comments", len(comments))
+	}
+	body := comments[0].GetBody()
+	for _, want := range []string{
+		EpicProgressIdentifier + "\n\n**1 of 3 sub-issues done (33%)**",
+		"`[######--------------]`",
+		"| #101 Add the CSV encoder | Done |  |  |",
+		"| #102 Add the export button | In review | @dave | #1 |",
+		`| #103 Document \| the export | Blocked |  |  |`,
+		"**Blockers:**\n- #103 Document | the export (`Blocked`)",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the progress comment should contain %q:\n%s", want, body)
+		}
+	}
+	issueBody := env.github.issue("acme", "widgets", 42).GetBody()
+	if !strings.HasPrefix(issueBody, "Analysts need CSV exports.\n\n"+epicBodyStart+"\n"+EpicProgressIdentifier) || !strings.HasSuffix(issueBody, epicBodyEnd) {
+		t.Errorf("the issue body should end with the progress:\n%s", issueBody)
+	}
+
+	env.github.issue("acme", "widgets", 103).State = github.String("closed")
+	env.deliverPayload(t, "issues", issueClosedPayload(t, 103))
+
+	comments = env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "**2 of 3 sub-issues done (66%)**") || strings.Contains(comments[0].GetBody(), "Blockers") {
+		t.Errorf("closing a sub-issue should update the progress comment:\n%v", comments)
+	}
+	if issueBody := env.github.issue("acme", "widgets", 42).GetBody(); strings.Count(issueBody, epicBodyStart) != 1 || !strings.Contains(issueBody, "2 of 3") {
+		t.Errorf("the progress section should be replaced:\n%s", issueBody)
+	}
+}
+
+func TestEpicProgressOnlyForBotEpics(t *testing.T) {
+	env := newTestEnv(t)
+	setUpEpic(t, env, "epic_progress:\n  enabled: true\n")
+	env.bot.store.Delete(bucketArtifacts, artifactKey("acme", "widgets", 42, ArtifactSubTasks))
+
+	env.deliverPayload(t, "sub_issues", subIssuePayload(t, 103, "Document | the export"))
+	env.deliverPayload(t, "issues", issueClosedPayload(t, 101))
+
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Errorf("issues the bot didn't plan should be left alone: %v", comments)
+	}
+}
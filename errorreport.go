@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// errorReportTimeout bounds the delivery of one error report.
+const errorReportTimeout = 10 * time.Second
+
+// errorReporter sends handler panics and failed jobs to Sentry, or as the
+// same JSON events to any other endpoint.
+type errorReporter struct {
+	endpoint   string
+	sentryKey  string // the public key of a Sentry DSN; empty for other endpoints
+	serverName string
+	client     *http.Client
+}
+
+// newErrorReporter returns the reporter of ERROR_REPORTING_DSN: a Sentry DSN
+// such as https://<key>@o1.ingest.sentry.io/<project>, or the URL of any
+// other endpoint accepting JSON events.
+func newErrorReporter(dsn string) (*errorReporter, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("%q is not an HTTP(S) URL", u.Redacted())
+	}
+	r := &errorReporter{endpoint: dsn, client: &http.Client{Transport: sharedTransport, Timeout: errorReportTimeout}}
+	r.serverName, _ = os.Hostname()
+	if key := u.User.Username(); key != "" {
+		dir, project := path.Split(strings.TrimSuffix(u.Path, "/"))
+		if project == "" {
+			return nil, errors.New("the Sentry DSN has no project ID")
+		}
+		r.sentryKey = key
+		r.endpoint = (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: dir + "api/" + project + "/store/"}).String()
+	}
+	return r, nil
+}
+
+// errorEvent is a reported error, in Sentry's event format.
+type errorEvent struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Platform   string            `json:"platform"`
+	Level      string            `json:"level"`
+	Logger     string            `json:"logger"`
+	ServerName string            `json:"server_name,omitempty"`
+	Message    string            `json:"message"`
+	Exception  *errorExceptions  `json:"exception,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Extra      map[string]any    `json:"extra,omitempty"`
+}
+
+type errorExceptions struct {
+	Values []errorException `json:"values"`
+}
+
+// errorException is the error or panic of an event. Type groups events,
+// such as the error code of failed jobs.
+type errorException struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+func (r *errorReporter) send(ctx context.Context, event *errorEvent) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if r.sentryKey != "" {
+		req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=%s/1.0, sentry_key=%s", errorLogger, r.sentryKey))
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending error report: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sending error report: %s", resp.Status)
+	}
+	return nil
+}
+
+// errorLogger names the bot in error reports.
+const errorLogger = "agent-prd"
+
+type errorTagsKey struct{}
+
+// withErrorTags returns a context whose reported errors carry the tags of
+// ctx and kv, alternating keys and values. Empty values are left out.
+func withErrorTags(ctx context.Context, kv ...string) context.Context {
+	tags := maps.Clone(errorTags(ctx))
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	for i := 0; i+1 < len(kv); i += 2 {
+		if kv[i+1] != "" {
+			tags[kv[i]] = kv[i+1]
+		}
+	}
+	return context.WithValue(ctx, errorTagsKey{}, tags)
+}
+
+// errorTags returns the tags errors reported with ctx carry, such as the
+// repository, issue and command of a job.
+func errorTags(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(errorTagsKey{}).(map[string]string)
+	return tags
+}
+
+// captureError reports event with the tags of ctx, when ERROR_REPORTING_DSN
+// is set. Delivery failures are only logged.
+func (b *Bot) captureError(ctx context.Context, event *errorEvent) {
+	if b.reporter == nil {
+		return
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	event.EventID = hex.EncodeToString(id)
+	event.Timestamp = time.Now().UTC()
+	event.Platform, event.Logger, event.ServerName = "go", errorLogger, b.reporter.serverName
+	tags := maps.Clone(errorTags(ctx))
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	maps.Copy(tags, event.Tags)
+	event.Tags = tags
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), errorReportTimeout)
+	defer cancel()
+	if err := b.reporter.send(ctx, event); err != nil {
+		log.Printf("Could not report the error %q: %v", event.Message, err)
+	}
+}
+
+// capturePanic reports a recovered panic and the stack it was raised on.
+func (b *Bot) capturePanic(ctx context.Context, r any, stack []byte) {
+	b.captureError(ctx, &errorEvent{
+		Level:     "fatal",
+		Message:   fmt.Sprintf("panic: %v", r),
+		Exception: &errorExceptions{Values: []errorException{{Type: "panic", Value: fmt.Sprint(r)}}},
+		Extra:     map[string]any{"stack": string(stack)},
+	})
+}
+
+// reportedPanic carries on a panic that was already reported with the
+// context of the job that raised it.
+type reportedPanic struct {
+	value any
+}
+
+func (p reportedPanic) String() string { return fmt.Sprint(p.value) }
+
+// recoverHTTP recovers panics of next, reporting them and answering 500
+// rather than dropping the connection.
+func (b *Bot) recoverHTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+			stack := debug.Stack()
+			log.Printf("Recovered from a panic serving %s %s: %v\n%s", r.Method, r.URL.Path, v, stack)
+			b.capturePanic(withErrorTags(r.Context(), "request", r.Method+" "+r.URL.Path), v, stack)
+			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// errorCollector is an error reporting endpoint recording the events it
+// receives.
+type errorCollector struct {
+	*httptest.Server
+
+	mu     sync.Mutex
+	events []errorEvent
+	auth   []string
+	paths  []string
+}
+
+func newErrorCollector(t *testing.T) *errorCollector {
+	c := &errorCollector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event errorEvent
+		if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+			t.Errorf("invalid error report: %v", err)
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.events = append(c.events, event)
+		c.auth = append(c.auth, r.Header.Get("X-Sentry-Auth"))
+		c.paths = append(c.paths, r.URL.Path)
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *errorCollector) received() []errorEvent {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]errorEvent(nil), c.events...)
+}
+
+func TestPanicsAreReportedToSentry(t *testing.T) {
+	env := newTestEnv(t)
+	collector := newErrorCollector(t)
+	var err error
+	env.bot.reporter, err = newErrorReporter(strings.Replace(collector.URL, "http://", "http://public@", 1) + "/42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	explodeCommand(env)
+
+	env.comment(t, "@prd-bot explode")
+
+	events := collector.received()
+	if len(events) != 2 {
+		t.Fatalf("expected the panic and the dead letter to be reported, got %+v", events)
+	}
+	panicked := events[0]
+	if panicked.Level != "fatal" || panicked.Message != "panic: boom" || panicked.EventID == "" || !strings.Contains(panicked.Extra["stack"].(string), "explodeCommand") {
+		t.Errorf("unexpected panic report %+v", panicked)
+	}
+	for key, want := range map[string]string{"command": "explode", "repo": "acme/widgets", "issue": "42"} {
+		if got := panicked.Tags[key]; got != want {
+			t.Errorf("panic tag %s = %q, want %q", key, got, want)
+		}
+	}
+	if dead := events[1]; dead.Exception == nil || dead.Exception.Values[0].Type != "dead_letter" || dead.Tags["event"] != "issue_comment" || dead.Tags["delivery"] == "" {
+		t.Errorf("unexpected dead letter report %+v", dead)
+	}
+	if collector.paths[0] != "/api/42/store/" || !strings.Contains(collector.auth[0], "sentry_key=public") {
+		t.Errorf("expected a Sentry store request, got %s with %q", collector.paths[0], collector.auth[0])
+	}
+}
+
+func TestFailedJobsAreReported(t *testing.T) {
+	env := newTestEnv(t)
+	collector := newErrorCollector(t)
+	env.bot.reporter, _ = newErrorReporter(collector.URL + "/errors")
+	env.runner.failOn = "git push"
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	events := collector.received()
+	if len(events) != 1 {
+		t.Fatalf("expected 1 report, got %+v", events)
+	}
+	event := events[0]
+	if event.Exception == nil || event.Exception.Values[0].Type != "PUSH_FAILED" {
+		t.Errorf("the report should be grouped by error code, got %+v", event.Exception)
+	}
+	for key, want := range map[string]string{"command": "implement_feature", "repo": "acme/widgets", "issue": "42", "code": "PUSH_FAILED"} {
+		if got := event.Tags[key]; got != want {
+			t.Errorf("tag %s = %q, want %q", key, got, want)
+		}
+	}
+	if event.Tags["step"] == "" {
+		t.Errorf("the report should name the failed step, got %+v", event.Tags)
+	}
+	if collector.paths[0] != "/errors" || collector.auth[0] != "" {
+		t.Errorf("a generic endpoint should receive the event as is, got %s with %q", collector.paths[0], collector.auth[0])
+	}
+}
+
+func TestRecoverHTTP(t *testing.T) {
+	env := newTestEnv(t)
+	collector := newErrorCollector(t)
+	env.bot.reporter, _ = newErrorReporter(collector.URL)
+	handler := env.bot.recoverHTTP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m map[string]int
+		m["crash"]++
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, got %d", rec.Code)
+	}
+	if events := collector.received(); len(events) != 1 || events[0].Tags["request"] != "GET /dashboard" {
+		t.Errorf("expected the panic to be reported, got %+v", events)
+	}
+}
+
+func TestNewErrorReporter(t *testing.T) {
+	for _, tt := range []struct {
+		dsn, endpoint, key string
+	}{
+		{"https://abc@o1.ingest.sentry.io/123", "https://o1.ingest.sentry.io/api/123/store/", "abc"},
+		{"https://abc@sentry.example.com/sentry/7/", "https://sentry.example.com/sentry/api/7/store/", "abc"},
+		{"https://errors.example.com/ingest", "https://errors.example.com/ingest", ""},
+	} {
+		r, err := newErrorReporter(tt.dsn)
+		if err != nil {
+			t.Errorf("newErrorReporter(%q): %v", tt.dsn, err)
+			continue
+		}
+		if r.endpoint != tt.endpoint || r.sentryKey != tt.key {
+			t.Errorf("newErrorReporter(%q) = %s with key %q, want %s with key %q", tt.dsn, r.endpoint, r.sentryKey, tt.endpoint, tt.key)
+		}
+	}
+	for _, dsn := range []string{"sentry.io/1", "ftp://host/1", "https://abc@o1.ingest.sentry.io/"} {
+		if _, err := newErrorReporter(dsn); err == nil {
+			t.Errorf("newErrorReporter(%q) should fail", dsn)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-github/v58/github"
+)
+
+// Failure categories. Helpers wrap their errors with one of these so handlers
+// can report a stable error code and a remediation hint to users.
+var (
+	ErrNoFilesSpecified   = errors.New("no files specified")
+	ErrReadmeUnavailable  = errors.New("README unavailable")
+	ErrNoPRD              = errors.New("no PRD found")
+	ErrCloneFailed        = errors.New("clone failed")
+	ErrGitFailed          = errors.New("git command failed")
+	ErrEditFailed         = errors.New("code edit failed")
+	ErrNoChanges          = errors.New("no changes to publish")
+	ErrPushFailed         = errors.New("push failed")
+	ErrBranchChanged      = errors.New("branch changed")
+	ErrSigningFailed      = errors.New("commit signing failed")
+	ErrNoWriteAccess      = errors.New("no write access")
+	ErrMissingPermissions = errors.New("installation permissions missing")
+	ErrIssueLocked        = errors.New("issue locked")
+	ErrBranchProtected    = errors.New("branch protected")
+	ErrRepoArchived       = errors.New("repository archived")
+	ErrRepoEmpty          = errors.New("repository empty")
+	ErrReadOnlyFork       = errors.New("fork without write access")
+	ErrPullRequestFailed  = errors.New("pull request creation failed")
+	ErrDispatchFailed     = errors.New("workflow dispatch failed")
+	ErrModelBlocked       = errors.New("model response blocked")
+	ErrModelUnavailable   = errors.New("model unavailable")
+	ErrModelInvalid       = errors.New("invalid model response")
+	ErrInputTooLarge      = errors.New("model input too large")
+	ErrDiskQuota          = errors.New("workspace disk quota exceeded")
+	ErrWorkspaceTooLarge  = errors.New("workspace too large")
+)
+
+// failureInfo is the user-facing description of a failure category.
+type failureInfo struct {
+	Code string
+	Hint string
+}
+
+// failureCatalog lists categories from most to least specific; the first
+// match wins, so ErrNoWriteAccess is reported even when wrapped together
+// with ErrPushFailed.
+var failureCatalog = []struct {
+	err  error
+	info failureInfo
+}{
+	{ErrBranchProtected, failureInfo{"BRANCH_PROTECTED", "A branch protection rule or repository ruleset blocks the app from creating or pushing its branch. Add the app to the rule's bypass list, or change `naming.branch` in the repository configuration so its branches don't match the rule."}},
+	{ErrNoWriteAccess, failureInfo{"NO_WRITE_ACCESS", "Make sure the app has **Contents** and **Pull requests** write permission on this repository and that branch protection allows it to push."}},
+	{ErrMissingPermissions, failureInfo{"MISSING_PERMISSIONS", "Ask an owner of the repository's account to grant the app **Contents: Read and write** and **Pull requests: Read and write** in the app's settings, then approve the updated permissions of the installation (**Settings** > **GitHub Apps** > **Configure**)."}},
+	{ErrIssueLocked, failureInfo{"ISSUE_LOCKED", "The conversation on this issue is locked. Unlock it (**Unlock conversation** in the issue's sidebar), then run the command again."}},
+	{ErrRepoArchived, failureInfo{"REPO_ARCHIVED", "The repository is archived and read-only. Unarchive it in the repository settings first."}},
+	{ErrRepoEmpty, failureInfo{"REPO_EMPTY", "The repository has no commits yet. Push an initial commit to the default branch first."}},
+	{ErrReadOnlyFork, failureInfo{"REPO_FORK_READ_ONLY", "The repository is a fork the app cannot push to. Run the command on the upstream repository, or install the app on the fork with **Contents** write permission."}},
+	{ErrModelBlocked, failureInfo{"MODEL_BLOCKED", "The AI model's safety filters blocked the request. Rephrase the issue to remove sensitive content and try again."}},
+	{ErrModelUnavailable, failureInfo{"MODEL_UNAVAILABLE", "The AI model could not be reached. Try again in a few minutes; if it keeps failing, ask the operator to check the API key and quota."}},
+	{ErrModelInvalid, failureInfo{"MODEL_INVALID_RESPONSE", "The AI model returned an answer in an unexpected format. Running the command again usually helps."}},
+	{ErrInputTooLarge, failureInfo{"INPUT_TOO_LARGE", "The issue and the context it pulls in are too large to send to the AI model. Shorten the issue or reference fewer files, then try again."}},
+	{ErrNoFilesSpecified, failureInfo{"NO_FILES", "List the files to change in the issue body using the format `Files: file1.go, path/to/file2.go`."}},
+	{ErrReadmeUnavailable, failureInfo{"README_UNAVAILABLE", "The repository's README could not be read. Check that the app has **Contents** read permission on this repository, then try again."}},
+	{ErrNoPRD, failureInfo{"NO_PRD", "Generate a PRD first."}},
+	{ErrDiskQuota, failureInfo{"DISK_QUOTA_EXCEEDED", "The bot is out of disk space for working copies because other jobs are running. Try again in a few minutes; if it keeps failing, ask the operator to raise `WORKSPACE_QUOTA`."}},
+	{ErrWorkspaceTooLarge, failureInfo{"WORKSPACE_TOO_LARGE", "The repository is larger than the bot allows for a single job. Ask the operator to raise `WORKSPACE_JOB_LIMIT`."}},
+	{ErrCloneFailed, failureInfo{"CLONE_FAILED", "Check that the app is installed on this repository and that the repository is not empty."}},
+	{ErrNoChanges, failureInfo{"NO_CHANGES", "The change left every file as it is on the base branch, so there is nothing to open a pull request for. Check whether the change is already merged, or describe it more specifically in the issue."}},
+	{ErrEditFailed, failureInfo{"EDIT_FAILED", "Check that the files listed in the issue exist and that the issue describes the change clearly."}},
+	{ErrBranchChanged, failureInfo{"BRANCH_CHANGED", "Someone pushed to the pull request's branch since the bot last updated it, so the bot left the branch alone instead of replacing those commits. Apply the change on top of them by hand, or close the pull request to have the bot open a new one."}},
+	{ErrPushFailed, failureInfo{"PUSH_FAILED", "Check for branch protection rules or pre-receive hooks that reject pushes from the app."}},
+	{ErrPullRequestFailed, failureInfo{"PR_FAILED", "Check that the base branch exists and that the app has **Pull requests** write permission on this repository."}},
+	{ErrDispatchFailed, failureInfo{"DISPATCH_FAILED", "GitHub rejected the `repository_dispatch` event. Check that the app has **Contents** write permission, or set `execution.backend: bot` in the repository configuration to run jobs on the bot's host."}},
+	{ErrSigningFailed, failureInfo{"SIGNING_FAILED", "The commit could not be signed. Ask the operator to check `COMMIT_SIGNING_KEY` and `COMMIT_SIGNING_FORMAT`; the key must not have a passphrase."}},
+	{ErrGitFailed, failureInfo{"GIT_FAILED", "This is usually transient. Try again; if it persists, ask the operator to check the bot logs."}},
+}
+
+var internalFailure = failureInfo{"INTERNAL", "This is unexpected. Ask the operator to check the bot logs."}
+
+// classifyError maps err to its failure category.
+func classifyError(err error) failureInfo {
+	for _, entry := range failureCatalog {
+		if errors.Is(err, entry.err) {
+			return entry.info
+		}
+	}
+	return internalFailure
+}
+
+// modelError wraps an error from the AI model with its failure category.
+func modelError(err error) error {
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return fmt.Errorf("%w: %w", ErrModelBlocked, err)
+	}
+	return fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+}
+
+// gitError wraps a failed git command with kind, or with ErrNoWriteAccess
+// when the output shows the remote rejected our credentials and with
+// ErrSigningFailed when the commit could not be signed.
+func gitError(kind error, output string, err error) error {
+	lower := strings.ToLower(output)
+	if strings.Contains(lower, "failed to sign") || strings.Contains(lower, "gpg failed") {
+		return fmt.Errorf("%w: %w: %w", ErrSigningFailed, kind, err)
+	}
+	// GH006 and GH013 are GitHub's rejections by branch protection and rulesets.
+	for _, marker := range []string{"gh006", "gh013", "protected branch", "rule violations"} {
+		if strings.Contains(lower, marker) {
+			return fmt.Errorf("%w: %w: %w", ErrBranchProtected, kind, err)
+		}
+	}
+	// Match git's and GitHub's own wording, so a 403 or "permission denied"
+	// elsewhere in the output, e.g. in a file name or a hook's message,
+	// isn't taken for a rejection.
+	for _, marker := range []string{"error: 403", "403 forbidden", "remote: permission to", "write access to repository not granted"} {
+		if strings.Contains(lower, marker) {
+			return fmt.Errorf("%w: %w: %w", ErrNoWriteAccess, kind, err)
+		}
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// githubError wraps a GitHub API error with kind, or with ErrNoWriteAccess
+// for permission errors and ErrBranchProtected for ruleset violations.
+func githubError(kind error, err error) error {
+	var ghErr *github.ErrorResponse
+	if errors.As(err, &ghErr) && ghErr.Response != nil {
+		if ghErr.Response.StatusCode == http.StatusUnprocessableEntity && strings.Contains(strings.ToLower(ghErr.Message), "rule") {
+			return fmt.Errorf("%w: %w: %w", ErrBranchProtected, kind, err)
+		}
+		if ghErr.Response.StatusCode == http.StatusForbidden {
+			return fmt.Errorf("%w: %w: %w", ErrNoWriteAccess, kind, err)
+		}
+	}
+	return fmt.Errorf("%w: %w", kind, err)
+}
+
+// reportFailure logs err, counts it by error code and tells the user what
+// went wrong and how to fix it.
+func (b *Bot) reportFailure(ctx context.Context, client *github.Client, owner, repo string, issueNum int, action, reason string, err error) {
+	info := classifyError(err)
+	log.Printf("Operation failed for issue #%d: %s [%s]: %v", issueNum, reason, info.Code, err)
+	failuresTotal.Inc(info.Code)
+	noteFailure(ctx, info.Code)
+	b.captureError(withErrorTags(ctx, "repo", owner+"/"+repo, "issue", strconv.Itoa(issueNum), "step", action, "code", info.Code), &errorEvent{
+		Level:     "error",
+		Message:   fmt.Sprintf("Failed to %s: %s", action, reason),
+		Exception: &errorExceptions{Values: []errorException{{Type: info.Code, Value: err.Error()}}},
+	})
+	msg := fmt.Sprintf("I failed to %s for issue #%d.\n\n**Error code:** `%s`\n**Reason:** %s.\n**How to fix:** %s", action, issueNum, info.Code, reason, info.Hint)
+	b.postComment(ctx, client, owner, repo, issueNum, msg)
+	b.publishEvent(EventJobFailed, owner, repo, issueNum, "", map[string]any{"code": info.Code, "action": action, "reason": reason})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/generative-ai-go/genai"
+	"github.com/google/go-github/v58/github"
+)
+
+func TestClassifyError(t *testing.T) {
+	cause := errors.New("exit status 128")
+	tests := []struct {
+		err  error
+		code string
+	}{
+		{gitError(ErrCloneFailed, "fatal: repository not found", cause), "CLONE_FAILED"},
+		{gitError(ErrPushFailed, "remote: Permission to acme/widgets.git denied", cause), "NO_WRITE_ACCESS"},
+		{gitError(ErrPushFailed, "fatal: unable to access 'https://github.com/acme/widgets.git/': The requested URL returned error: 403", cause), "NO_WRITE_ACCESS"},
+		{gitError(ErrGitFailed, "error: open(\"docs/403.html\"): Permission denied", cause), "GIT_FAILED"},
+		{gitError(ErrPushFailed, "remote: error: GH006: Protected branch update failed for refs/heads/main.", cause), "BRANCH_PROTECTED"},
+		{githubError(ErrPullRequestFailed, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}, Message: "Repository rule violations found"}), "BRANCH_PROTECTED"},
+		{gitError(ErrGitFailed, "error: gpg failed to sign the data\nfatal: failed to write commit object", cause), "SIGNING_FAILED"},
+		{githubError(ErrPullRequestFailed, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusForbidden}}), "NO_WRITE_ACCESS"},
+		{githubError(ErrPullRequestFailed, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusUnprocessableEntity}}), "PR_FAILED"},
+		{modelError(&genai.BlockedError{}), "MODEL_BLOCKED"},
+		{modelError(cause), "MODEL_UNAVAILABLE"},
+		{cause, "INTERNAL"},
+	}
+	for _, tt := range tests {
+		if got := classifyError(tt.err).Code; got != tt.code {
+			t.Errorf("classifyError(%v) = %s, want %s", tt.err, got, tt.code)
+		}
+	}
+}
+
+func TestFailuresAreCountedAndExported(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.failOn = "git push"
+	before := failuresTotal.Value("PUSH_FAILED")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if got := failuresTotal.Value("PUSH_FAILED"); got != before+1 {
+		t.Errorf("PUSH_FAILED count = %v, want %v", got, before+1)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	last := comments[len(comments)-1].GetBody()
+	if !strings.Contains(last, "**Error code:** `PUSH_FAILED`") || !strings.Contains(last, "**How to fix:**") {
+		t.Errorf("unexpected failure comment:\n%s", last)
+	}
+
+	rec := httptest.NewRecorder()
+	handleMetrics(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(rec.Body.String(), `agent_prd_failures_total{code="PUSH_FAILED"}`) {
+		t.Errorf("metrics output missing failure counter:\n%s", rec.Body.String())
+	}
+}
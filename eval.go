@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// promptJudge names the system prompt of the evaluation judge.
+const promptJudge = "eval_judge"
+
+// evalCommands are the commands an experiment can evaluate.
+var evalCommands = []string{CommandGeneratePRD, CommandGenerateSubTask}
+
+// defaultEvalCriteria are the rubrics outputs are judged by, by command.
+var defaultEvalCriteria = map[string][]evalCriterion{
+	CommandGeneratePRD: {
+		{Name: "grounded", Description: "Every requirement follows from the issue; nothing is invented."},
+		{Name: "specific", Description: "Requirements and acceptance criteria are concrete and testable."},
+		{Name: "complete", Description: "Every section of the PRD is filled in with content specific to the issue."},
+		{Name: "clear", Description: "The PRD is concise, well organized and free of filler."},
+	},
+	CommandGenerateSubTask: {
+		{Name: "actionable", Description: "Each sub-task is a single piece of work a developer can pick up."},
+		{Name: "coverage", Description: "Together, the sub-tasks cover every requirement of the PRD."},
+		{Name: "granular", Description: "Sub-tasks are neither too broad nor split needlessly."},
+		{Name: "concrete", Description: "Sub-tasks name what they build rather than generic steps."},
+	},
+}
+
+// evalExperiment is a saved prompt experiment: the variants of a command's
+// prompts and models to compare, and the rubric to compare them by.
+type evalExperiment struct {
+	// Command is the command whose output is evaluated, need_prd by default.
+	Command string `yaml:"command"`
+	// JudgeModel scores the outputs; the configured model by default.
+	JudgeModel string `yaml:"judge_model"`
+	// Criteria replace the built-in rubric of the command.
+	Criteria []evalCriterion `yaml:"criteria"`
+	// Variants are compared against the first one.
+	Variants []evalVariant `yaml:"variants"`
+}
+
+// evalCriterion is a rubric item the judge scores from 1 to 5.
+type evalCriterion struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+}
+
+// evalVariant is a prompt template version and model to evaluate.
+type evalVariant struct {
+	Name string `yaml:"name"`
+	// Model overrides the configured model.
+	Model string `yaml:"model"`
+	// SystemPrompts override system prompts by name, like the system_prompts
+	// of the repository configuration.
+	SystemPrompts map[string]string `yaml:"system_prompts"`
+}
+
+// evalCase is an issue of the evaluation corpus.
+type evalCase struct {
+	ID     string `json:"id"` // e.g. "acme/widgets#42"
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	README string `json:"readme,omitempty"`
+	// PRD is broken down when need_sub_task is evaluated.
+	PRD string `json:"prd,omitempty"`
+}
+
+// evalResult is the output of a variant for a case and its scores.
+type evalResult struct {
+	Variant string            `json:"variant"`
+	Case    string            `json:"case"`
+	Output  string            `json:"output,omitempty"`
+	Scores  map[string]int    `json:"scores,omitempty"`
+	Reasons map[string]string `json:"reasons,omitempty"`
+	Error   string            `json:"error,omitempty"`
+}
+
+// mean returns the average of the scores, or 0 without any.
+func (r evalResult) mean() float64 {
+	if len(r.Scores) == 0 {
+		return 0
+	}
+	var sum int
+	for _, score := range r.Scores {
+		sum += score
+	}
+	return float64(sum) / float64(len(r.Scores))
+}
+
+// loadEvalExperiment reads and checks the experiment file at path.
+func loadEvalExperiment(path string) (*evalExperiment, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var exp evalExperiment
+	if err := yaml.Unmarshal(data, &exp); err != nil {
+		return nil, fmt.Errorf("invalid experiment %s: %w", path, err)
+	}
+	if exp.Command == "" {
+		exp.Command = CommandGeneratePRD
+	}
+	if !slices.Contains(evalCommands, exp.Command) {
+		return nil, fmt.Errorf("experiment %s: command %q can't be evaluated: expected %s", path, exp.Command, strings.Join(evalCommands, " or "))
+	}
+	if len(exp.Criteria) == 0 {
+		exp.Criteria = defaultEvalCriteria[exp.Command]
+	}
+	if len(exp.Variants) == 0 {
+		return nil, fmt.Errorf("experiment %s has no variants", path)
+	}
+	seen := make(map[string]bool)
+	for i, v := range exp.Variants {
+		if v.Name == "" {
+			return nil, fmt.Errorf("experiment %s: variant %d has no name", path, i+1)
+		}
+		if seen[v.Name] {
+			return nil, fmt.Errorf("experiment %s: variant %q is defined twice", path, v.Name)
+		}
+		seen[v.Name] = true
+	}
+	return &exp, nil
+}
+
+// loadEvalCorpus reads the corpus at path, one JSON case per line.
+func loadEvalCorpus(path string) ([]evalCase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var cases []evalCase
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		var c evalCase
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		if c.ID == "" {
+			c.ID = fmt.Sprintf("line %d", line)
+		}
+		cases = append(cases, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(cases) == 0 {
+		return nil, fmt.Errorf("corpus %s is empty", path)
+	}
+	return cases, nil
+}
+
+// runEvaluation generates the output of every variant for every case and has
+// the judge score it. A failed generation or judgment is recorded in its
+// result rather than ending the run.
+func runEvaluation(ctx context.Context, llm Generator, exp *evalExperiment, corpus []evalCase) []evalResult {
+	var results []evalResult
+	for _, v := range exp.Variants {
+		for _, c := range corpus {
+			log.Printf("Evaluating %s on %s", v.Name, c.ID)
+			result := evalResult{Variant: v.Name, Case: c.ID}
+			output, err := evalGenerate(ctx, llm, exp.Command, v, c)
+			if err == nil {
+				result.Output = output
+				result.Scores, result.Reasons, err = judgeEvalOutput(ctx, llm, exp, c, output)
+			}
+			if err != nil {
+				log.Printf("Evaluating %s on %s failed: %v", v.Name, c.ID, err)
+				result.Error = err.Error()
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// evalGenerate runs the command of the experiment on c as variant v.
+func evalGenerate(ctx context.Context, llm Generator, command string, v evalVariant, c evalCase) (string, error) {
+	ctx = context.WithValue(ctx, promptSetKey{}, &promptSet{repo: strings.SplitN(c.ID, "#", 2)[0], overrides: v.SystemPrompts})
+	if v.Model != "" {
+		ctx = withModel(ctx, v.Model)
+	}
+	if command == CommandGenerateSubTask {
+		if c.PRD == "" {
+			return "", errors.New("the case has no prd to break down")
+		}
+		return generateSubTasks(ctx, llm, c.PRD, nil)
+	}
+	return generateEnglishPRD(ctx, llm, c.Title, c.Body, c.README, nil, nil)
+}
+
+// judgeEvalOutput scores output on each criterion of the experiment. The
+// judge isn't told which variant wrote it.
+func judgeEvalOutput(ctx context.Context, llm Generator, exp *evalExperiment, c evalCase, output string) (map[string]int, map[string]string, error) {
+	var rubric strings.Builder
+	for _, criterion := range exp.Criteria {
+		fmt.Fprintf(&rubric, "- %s: %s\n", criterion.Name, criterion.Description)
+	}
+	input := fmt.Sprintf("**GitHub Issue Title:**\n%s\n\n**GitHub Issue Body:**\n%s", c.Title, c.Body)
+	kind := "Product Requirements Document (PRD) written for the following GitHub issue"
+	if exp.Command == CommandGenerateSubTask {
+		input = "**PRD:**\n" + c.PRD
+		kind = "sub-task breakdown of the following Product Requirements Document (PRD)"
+	}
+	prompt := fmt.Sprintf(
+		"Score the %s on each criterion below, from 1 (poor) to 5 (excellent), with a one-sentence reason. Judge only what is written; a confident tone isn't evidence of quality.\n\n"+
+			"**Criteria:**\n%s\n"+
+			"Respond with JSON only, in this format:\n"+
+			`{"scores": [{"criterion": "%s", "score": 4, "reason": "..."}]}`+"\n\n"+
+			"%s\n\n"+
+			"**Output to Score:**\n%s",
+		kind, rubric.String(), exp.Criteria[0].Name, input, output,
+	)
+	ctx = withSystemPrompt(ctx, promptJudge)
+	if exp.JudgeModel != "" {
+		ctx = withModel(ctx, exp.JudgeModel)
+	}
+	resp, err := llm.GenerateText(ctx, prompt)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to judge the output: %w", err)
+	}
+	var judgment struct {
+		Scores []struct {
+			Criterion string `json:"criterion"`
+			Score     int    `json:"score"`
+			Reason    string `json:"reason"`
+		} `json:"scores"`
+	}
+	if err := parseModelJSON(resp, &judgment); err != nil {
+		return nil, nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	scores, reasons := make(map[string]int), make(map[string]string)
+	for _, s := range judgment.Scores {
+		known := slices.ContainsFunc(exp.Criteria, func(c evalCriterion) bool { return c.Name == s.Criterion })
+		if known && s.Score >= 1 && s.Score <= 5 {
+			scores[s.Criterion], reasons[s.Criterion] = s.Score, strings.TrimSpace(s.Reason)
+		}
+	}
+	for _, criterion := range exp.Criteria {
+		if _, ok := scores[criterion.Name]; !ok {
+			return nil, nil, fmt.Errorf("%w: the judge didn't score %s", ErrModelInvalid, criterion.Name)
+		}
+	}
+	return scores, reasons, nil
+}
+
+// formatEvalReport renders the results as Markdown: the mean scores of each
+// variant, compared with the first, and the overall score of each case.
+func formatEvalReport(exp *evalExperiment, corpus []evalCase, results []evalResult) string {
+	byVariant := make(map[string][]evalResult)
+	for _, r := range results {
+		byVariant[r.Variant] = append(byVariant[r.Variant], r)
+	}
+	overall := func(rs []evalResult) (float64, int) {
+		var sum float64
+		var n int
+		for _, r := range rs {
+			if r.Error == "" {
+				sum, n = sum+r.mean(), n+1
+			}
+		}
+		if n == 0 {
+			return 0, 0
+		}
+		return sum / float64(n), n
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Evaluation of %s\n\n%d cases, judged on a scale of 1 to 5.\n\n", exp.Command, len(corpus))
+	b.WriteString("| Variant | Model |")
+	for _, c := range exp.Criteria {
+		fmt.Fprintf(&b, " %s |", c.Name)
+	}
+	b.WriteString(" Overall | vs. " + exp.Variants[0].Name + " | Failed |\n|---|---|")
+	b.WriteString(strings.Repeat("---|", len(exp.Criteria)+3) + "\n")
+	baseline, _ := overall(byVariant[exp.Variants[0].Name])
+	for _, v := range exp.Variants {
+		rs := byVariant[v.Name]
+		model := v.Model
+		if model == "" {
+			model = "default"
+		}
+		fmt.Fprintf(&b, "| %s | %s |", v.Name, model)
+		for _, c := range exp.Criteria {
+			var sum, n int
+			for _, r := range rs {
+				if score, ok := r.Scores[c.Name]; ok {
+					sum, n = sum+score, n+1
+				}
+			}
+			if n == 0 {
+				b.WriteString(" - |")
+			} else {
+				fmt.Fprintf(&b, " %.2f |", float64(sum)/float64(n))
+			}
+		}
+		mean, n := overall(rs)
+		switch {
+		case n == 0:
+			b.WriteString(" - | - |")
+		case v.Name == exp.Variants[0].Name:
+			fmt.Fprintf(&b, " %.2f | - |", mean)
+		default:
+			fmt.Fprintf(&b, " %.2f | %+.2f |", mean, mean-baseline)
+		}
+		fmt.Fprintf(&b, " %d |\n", len(rs)-n)
+	}
+
+	b.WriteString("\n## By case\n\n| Case |")
+	for _, v := range exp.Variants {
+		fmt.Fprintf(&b, " %s |", v.Name)
+	}
+	b.WriteString("\n|---|" + strings.Repeat("---|", len(exp.Variants)) + "\n")
+	for _, c := range corpus {
+		fmt.Fprintf(&b, "| %s |", c.ID)
+		for _, v := range exp.Variants {
+			i := slices.IndexFunc(results, func(r evalResult) bool { return r.Variant == v.Name && r.Case == c.ID })
+			if i < 0 || results[i].Error != "" {
+				b.WriteString(" failed |")
+			} else {
+				fmt.Fprintf(&b, " %.2f |", results[i].mean())
+			}
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// runEval implements `agent-prd eval`: it evaluates the variants of an
+// experiment on a corpus and writes the report to out. The model settings
+// are read from the environment like the server's.
+func runEval(ctx context.Context, args []string, getenv func(string) string, out io.Writer) error {
+	fs := flag.NewFlagSet("agent-prd eval", flag.ContinueOnError)
+	experimentPath := fs.String("experiment", "", "YAML file of the variants to compare")
+	corpusPath := fs.String("corpus", "", "JSON Lines file of the issues to evaluate on")
+	resultsPath := fs.String("results", "", "file to write every output and score to as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *experimentPath == "" || *corpusPath == "" {
+		return errors.New("usage: agent-prd eval -experiment experiment.yml -corpus corpus.jsonl [-results results.json]")
+	}
+	exp, err := loadEvalExperiment(*experimentPath)
+	if err != nil {
+		return err
+	}
+	corpus, err := loadEvalCorpus(*corpusPath)
+	if err != nil {
+		return err
+	}
+
+	cfg := defaultStartupConfig()
+	cfg.GoogleAPIKey, cfg.OpenAIBaseURL, cfg.OpenAIModel, cfg.OpenAIAPIKey = getenv("GOOGLE_API_KEY"), getenv("OPENAI_BASE_URL"), getenv("OPENAI_MODEL"), getenv("OPENAI_API_KEY")
+	if model := getenv("GEMINI_MODEL"); model != "" {
+		cfg.GeminiModel = model
+	}
+	if cfg.GoogleAPIKey == "" && (cfg.OpenAIBaseURL == "" || cfg.OpenAIModel == "") {
+		return errors.New("a model is required: set GOOGLE_API_KEY, or OPENAI_BASE_URL and OPENAI_MODEL")
+	}
+
+	results := runEvaluation(ctx, newGenerator(cfg), exp, corpus)
+	if *resultsPath != "" {
+		data, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*resultsPath, data, 0o644); err != nil {
+			return fmt.Errorf("writing results: %w", err)
+		}
+	}
+	_, err = io.WriteString(out, formatEvalReport(exp, corpus, results))
+	return err
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const evalExperimentYAML = `judge_model: gemini-judge
+variants:
+  - name: baseline
+  - name: terse
+    model: gemini-small
+    system_prompts:
+      need_prd: "{{default}} Keep every section short."
+`
+
+func writeEvalFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunEvaluation(t *testing.T) {
+	gemini := newFakeGemini(t)
+	gemini.on("Create a Product Requirements Document", "1.  **Requirements:** Export reports as CSV.")
+	gemini.on("Score the Product Requirements Document", `{"scores": [{"criterion": "grounded", "score": 5, "reason": "Follows the issue."}, {"criterion": "specific", "score": 4}, {"criterion": "complete", "score": 3}, {"criterion": "clear", "score": 4}]}`)
+	exp, err := loadEvalExperiment(writeEvalFile(t, "experiment.yml", evalExperimentYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	corpus, err := loadEvalCorpus(writeEvalFile(t, "corpus.jsonl", `{"id": "acme/widgets#42", "title": "Export reports as CSV", "body": "Analysts need CSV."}`+"\n\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := runEvaluation(context.Background(), gemini.generator(), exp, corpus)
+
+	if len(results) != 2 || results[0].Variant != "baseline" || results[1].Variant != "terse" {
+		t.Fatalf("expected a result of each variant, got %+v", results)
+	}
+	for _, r := range results {
+		if r.Error != "" || r.mean() != 4 || r.Reasons["grounded"] != "Follows the issue." {
+			t.Errorf("unexpected result %+v", r)
+		}
+	}
+	if got, want := strings.Join(gemini.receivedModels(), ","), defaultGeminiModel+",gemini-judge,gemini-small,gemini-judge"; got != want {
+		t.Errorf("models = %s, want %s", got, want)
+	}
+	systems := gemini.receivedSystemPrompts()
+	if systems[0] != systemPrompts[CommandGeneratePRD] || systems[2] != systemPrompts[CommandGeneratePRD]+" Keep every section short." {
+		t.Errorf("each variant should use its system prompts, got %q", systems)
+	}
+	if systems[1] != systemPrompts[promptJudge] || systems[3] != systemPrompts[promptJudge] {
+		t.Errorf("the judge should use its own system prompt, got %q", systems)
+	}
+	if judge := gemini.receivedPrompts()[3]; strings.Contains(judge, "terse") || !strings.Contains(judge, "- grounded: Every requirement follows from the issue") {
+		t.Errorf("unexpected judge prompt:\n%s", judge)
+	}
+}
+
+func TestRunEvaluationRecordsFailures(t *testing.T) {
+	gemini := newFakeGemini(t)
+	gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+	gemini.on("Score the sub-task breakdown", `{"scores": [{"criterion": "actionable", "score": 4}]}`)
+	exp := &evalExperiment{Command: CommandGenerateSubTask, Criteria: defaultEvalCriteria[CommandGenerateSubTask], Variants: []evalVariant{{Name: "baseline"}}}
+	corpus := []evalCase{{ID: "no-prd", Title: "Export"}, {ID: "partial", PRD: "1.  **Requirements:** Export reports as CSV."}}
+
+	results := runEvaluation(context.Background(), gemini.generator(), exp, corpus)
+
+	if !strings.Contains(results[0].Error, "no prd") {
+		t.Errorf("a case without a PRD should fail, got %+v", results[0])
+	}
+	if !strings.Contains(results[1].Error, "didn't score coverage") || results[1].Output == "" {
+		t.Errorf("an incomplete judgment should fail and keep the output, got %+v", results[1])
+	}
+}
+
+func TestFormatEvalReport(t *testing.T) {
+	exp := &evalExperiment{
+		Command:  CommandGeneratePRD,
+		Criteria: []evalCriterion{{Name: "grounded"}, {Name: "clear"}},
+		Variants: []evalVariant{{Name: "baseline"}, {Name: "concise", Model: "gemini-small"}},
+	}
+	corpus := []evalCase{{ID: "acme/widgets#1"}, {ID: "acme/widgets#2"}}
+	results := []evalResult{
+		{Variant: "baseline", Case: "acme/widgets#1", Scores: map[string]int{"grounded": 4, "clear": 2}},
+		{Variant: "baseline", Case: "acme/widgets#2", Scores: map[string]int{"grounded": 4, "clear": 4}},
+		{Variant: "concise", Case: "acme/widgets#1", Scores: map[string]int{"grounded": 5, "clear": 4}},
+		{Variant: "concise", Case: "acme/widgets#2", Error: "model unavailable"},
+	}
+
+	report := formatEvalReport(exp, corpus, results)
+
+	for _, want := range []string{
+		"| Variant | Model | grounded | clear | Overall | vs. baseline | Failed |\n|---|---|---|---|---|---|---|\n",
+		"| baseline | default | 4.00 | 3.00 | 3.50 | - | 0 |\n",
+		"| concise | gemini-small | 5.00 | 4.00 | 4.50 | +1.00 | 1 |\n",
+		"| acme/widgets#1 | 3.00 | 4.50 |\n| acme/widgets#2 | 4.00 | failed |\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report should contain %q:\n%s", want, report)
+		}
+	}
+}
+
+func TestLoadEvalExperimentErrors(t *testing.T) {
+	for _, content := range []string{
+		"command: explain\nvariants: [{name: a}]\n",
+		"variants: []\n",
+		"variants: [{model: gemini-small}]\n",
+		"variants: [{name: a}, {name: a}]\n",
+	} {
+		if _, err := loadEvalExperiment(writeEvalFile(t, "experiment.yml", content)); err == nil {
+			t.Errorf("loadEvalExperiment(%q) should fail", content)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Lifecycle events the bot publishes to the configured outbound webhooks.
+const (
+	EventPRDCreated = "prd.created"
+	EventPROpened   = "pr.opened"
+	EventJobFailed  = "job.failed"
+)
+
+// lifecycleEvents lists the events a webhook may subscribe to.
+var lifecycleEvents = []string{EventPRDCreated, EventPROpened, EventJobFailed}
+
+var (
+	// webhookAttempts is how many times an event is sent to a webhook
+	// that fails with a network error or a 5xx status.
+	webhookAttempts = 3
+	// webhookRetryDelay is the wait before the first retry; it doubles
+	// for each further retry.
+	webhookRetryDelay = 2 * time.Second
+)
+
+// WebhookConfig is an outbound webhook receiving the bot's lifecycle events.
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Events lists the events sent to the webhook; empty sends them all.
+	Events []string `yaml:"events"`
+	// Repos limits the webhook to events of these repositories, given as
+	// "owner/repo" or "owner"; empty sends the events of every repository.
+	Repos []string `yaml:"repos"`
+	// Secret signs each delivery with HMAC-SHA256 in the
+	// X-PRD-Bot-Signature-256 header, like GitHub signs its webhooks.
+	Secret string `yaml:"secret"`
+}
+
+func (c WebhookConfig) validate() error {
+	u, err := url.Parse(c.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %q must be an absolute http or https URL", c.URL)
+	}
+	for _, event := range c.Events {
+		if !slices.Contains(lifecycleEvents, event) {
+			return fmt.Errorf("unknown event %q, expected one of %s", event, strings.Join(lifecycleEvents, ", "))
+		}
+	}
+	return nil
+}
+
+// accepts reports whether event should be sent to the webhook.
+func (c WebhookConfig) accepts(event *botEvent) bool {
+	if len(c.Events) > 0 && !slices.Contains(c.Events, event.Type) {
+		return false
+	}
+	if len(c.Repos) == 0 {
+		return true
+	}
+	owner, _, _ := strings.Cut(event.Repository, "/")
+	return slices.ContainsFunc(c.Repos, func(r string) bool {
+		return strings.EqualFold(r, event.Repository) || strings.EqualFold(r, owner)
+	})
+}
+
+// botEvent is the JSON body of a lifecycle event delivery.
+type botEvent struct {
+	ID         string         `json:"id"`
+	Type       string         `json:"type"`
+	Repository string         `json:"repository"` // "owner/repo"
+	Issue      int            `json:"issue,omitempty"`
+	URL        string         `json:"url,omitempty"` // of the comment or pull request the event is about
+	CreatedAt  time.Time      `json:"created_at"`
+	Data       map[string]any `json:"data,omitempty"`
+}
+
+// eventPublisher delivers lifecycle events to outbound webhooks.
+type eventPublisher struct {
+	client *http.Client
+}
+
+func newEventPublisher() *eventPublisher {
+	return &eventPublisher{client: &http.Client{Transport: sharedTransport, Timeout: githubRequestTimeout}}
+}
+
+// publishEvent sends an event of type kind to every configured webhook that
+// subscribes to it, in the background. Delivery failures are logged and
+// never fail the command that raised the event.
+func (b *Bot) publishEvent(kind, owner, repo string, issue int, url string, data map[string]any) {
+	var hooks []WebhookConfig
+	event := &botEvent{Type: kind, Repository: owner + "/" + repo, Issue: issue, URL: url, CreatedAt: time.Now().UTC(), Data: data}
+	for _, hook := range b.serverConfig().Webhooks {
+		if hook.accepts(event) {
+			hooks = append(hooks, hook)
+		}
+	}
+	if len(hooks) == 0 {
+		return
+	}
+	var random [8]byte
+	rand.Read(random[:])
+	event.ID = hex.EncodeToString(random[:])
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding the %s event of %s: %v", kind, event.Repository, err)
+		return
+	}
+	for _, hook := range hooks {
+		b.jobs.Add(1)
+		go func() {
+			defer b.jobs.Done()
+			if err := b.events.deliver(context.Background(), hook, event, payload); err != nil {
+				log.Printf("Error sending the %s event %s of %s to %s: %v", kind, event.ID, event.Repository, hook.URL, err)
+			}
+		}()
+	}
+}
+
+// deliver posts payload to hook, retrying network errors and server errors.
+func (p *eventPublisher) deliver(ctx context.Context, hook WebhookConfig, event *botEvent, payload []byte) error {
+	delay := webhookRetryDelay
+	var err error
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var retry bool
+		if retry, err = p.post(ctx, hook, event, payload); err == nil || !retry {
+			return err
+		}
+	}
+	return err
+}
+
+// post sends payload once and reports whether a failure is worth retrying.
+func (p *eventPublisher) post(ctx context.Context, hook WebhookConfig, event *botEvent, payload []byte) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, hook.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-PRD-Bot-Event", event.Type)
+	req.Header.Set("X-PRD-Bot-Delivery", event.ID)
+	if hook.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(hook.Secret))
+		mac.Write(payload)
+		req.Header.Set("X-PRD-Bot-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500, errors.New(resp.Status)
+	}
+	return false, nil
+}
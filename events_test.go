@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// eventSink is an outbound webhook recording the events it receives.
+type eventSink struct {
+	mu       sync.Mutex
+	events   []botEvent
+	headers  []http.Header
+	failures int // requests to fail with 503 before accepting events
+	server   *httptest.Server
+}
+
+func newEventSink(t *testing.T) *eventSink {
+	sink := &eventSink{}
+	sink.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		sink.mu.Lock()
+		defer sink.mu.Unlock()
+		if sink.failures > 0 {
+			sink.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var event botEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("decoding event: %v", err)
+		}
+		r.Header.Set("X-Body", string(body))
+		sink.events = append(sink.events, event)
+		sink.headers = append(sink.headers, r.Header)
+	}))
+	t.Cleanup(sink.server.Close)
+	return sink
+}
+
+func (s *eventSink) received() []botEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.events
+}
+
+func TestLifecycleEventsAreSentToWebhooks(t *testing.T) {
+	env := newTestEnv(t)
+	all, failures := newEventSink(t), newEventSink(t)
+	env.bot.applyServerConfig(&ServerConfig{Webhooks: []WebhookConfig{
+		{URL: all.server.URL, Secret: "s3cret"},
+		{URL: failures.server.URL, Events: []string{EventJobFailed}, Repos: []string{"acme"}},
+		{URL: failures.server.URL, Repos: []string{"acme/gadgets"}},
+	}})
+
+	env.comment(t, "@prd-bot implement_feature")
+	env.runner.failOn = "git clone"
+	env.comment(t, "@prd-bot implement_feature")
+
+	events := all.received()
+	if len(events) != 2 || events[0].Type != EventPROpened || events[1].Type != EventJobFailed {
+		t.Fatalf("expected pr.opened and job.failed, got %+v", events)
+	}
+	if events[0].Repository != "acme/widgets" || events[0].Issue != 42 || events[0].Data["number"] != float64(1) || events[0].ID == "" {
+		t.Errorf("unexpected pr.opened event: %+v", events[0])
+	}
+	header := all.headers[0]
+	mac := hmac.New(sha256.New, []byte("s3cret"))
+	mac.Write([]byte(header.Get("X-Body")))
+	if header.Get("X-PRD-Bot-Signature-256") != "sha256="+hex.EncodeToString(mac.Sum(nil)) || header.Get("X-PRD-Bot-Event") != EventPROpened {
+		t.Errorf("the delivery should be signed and typed: %v", header)
+	}
+	if got := failures.received(); len(got) != 1 || got[0].Type != EventJobFailed || got[0].Data["code"] == "" {
+		t.Errorf("the filtered webhook should only get job.failed: %+v", got)
+	}
+}
+
+func TestWebhookDeliveryRetriesServerErrors(t *testing.T) {
+	env := newTestEnv(t)
+	defer func(old time.Duration) { webhookRetryDelay = old }(webhookRetryDelay)
+	webhookRetryDelay = 0
+	sink := newEventSink(t)
+	sink.failures = 2
+	env.bot.applyServerConfig(&ServerConfig{Webhooks: []WebhookConfig{{URL: sink.server.URL}}})
+
+	env.bot.publishEvent(EventPRDCreated, "acme", "widgets", 42, "https://github.com/acme/widgets/issues/42#issuecomment-1", map[string]any{"title": "CSV export"})
+	env.bot.jobs.Wait()
+
+	if events := sink.received(); len(events) != 1 || events[0].URL != "https://github.com/acme/widgets/issues/42#issuecomment-1" {
+		t.Errorf("the event should be delivered on the third attempt: %+v", events)
+	}
+}
+
+func TestServerConfigRejectsInvalidWebhooks(t *testing.T) {
+	for name, config := range map[string]string{
+		"relative url":  "webhooks:\n  - url: /hooks\n",
+		"unknown event": "webhooks:\n  - url: https://hooks.example.com\n    events: [pr.merged]\n",
+	} {
+		path := filepath.Join(t.TempDir(), "server.yaml")
+		os.WriteFile(path, []byte(config), 0o600)
+		if _, err := loadServerConfig(path); err == nil || !strings.Contains(err.Error(), "webhooks[0]") {
+			t.Errorf("%s: expected a webhooks[0] error, got %v", name, err)
+		}
+	}
+}
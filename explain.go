@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// ExplainIdentifier marks comments produced by the explain command.
+	ExplainIdentifier = "### Code Explanation"
+
+	maxExplainFiles = 20
+	maxExplainBytes = 200 * 1024
+)
+
+// processExplain fetches the files matched by `--files` (or listed in the
+// issue's `Files:` line) at the default branch's current commit and posts an
+// architecture explanation of them with permalinks to the cited lines.
+func (b *Bot) processExplain(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandExplain, issueNum, repoOwner, repoName)
+
+	patterns := parseFilesFlag(args)
+	if len(patterns) == 0 {
+		patterns = parseFilePathsFromIssue(issue.GetBody())
+	}
+	if len(patterns) == 0 {
+		usage := fmt.Sprintf("Please tell me which files to explain, e.g. `@%s %s --files pkg/auth/*.go`, or list them in the issue body using `Files: file1.go, path/to/file2.go`.", b.appName, CommandExplain)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, usage)
+		return
+	}
+
+	ref := b.headCommit(ctx, client, repo)
+	paths, err := matchRepoFiles(ctx, client, repoOwner, repoName, ref, patterns)
+	if err != nil {
+		log.Printf("Error listing files of %s/%s: %v", repoOwner, repoName, err)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't list the repository files to explain.")
+		return
+	}
+	if len(paths) == 0 {
+		msg := fmt.Sprintf("No files in `%s` match `%s`.", repo.GetDefaultBranch(), strings.Join(patterns, "`, `"))
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+		return
+	}
+
+	files, skipped := fetchFiles(ctx, client, repoOwner, repoName, ref, paths)
+	if len(files) == 0 {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't read any of the requested files.")
+		return
+	}
+
+	explanation, err := generateExplanation(ctx, b.llm, issue.GetTitle(), files)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "explain the code", "Could not generate the explanation", err)
+		return
+	}
+
+	var comment strings.Builder
+	explanation = renderCitations(explanation, b.repoWebURL(repo), ref, files)
+	fmt.Fprintf(&comment, "%s\n\n**Files:** `%s`\n\n%s", ExplainIdentifier, strings.Join(fileNames(files), "`, `"), explanation)
+	if len(skipped) > 0 {
+		fmt.Fprintf(&comment, "\n\n_Skipped (limit of %d files / %d KB reached or unreadable): `%s`_", maxExplainFiles, maxExplainBytes/1024, strings.Join(skipped, "`, `"))
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, comment.String())
+}
+
+// parseFilesFlag returns the patterns following `--files`, accepting both
+// space and comma separated lists.
+func parseFilesFlag(args []string) []string {
+	var patterns []string
+	collecting := false
+	for _, arg := range args {
+		if arg == "--files" {
+			collecting = true
+			continue
+		}
+		if value, ok := strings.CutPrefix(arg, "--files="); ok {
+			collecting = true
+			arg = value
+		} else if strings.HasPrefix(arg, "--") {
+			collecting = false
+			continue
+		}
+		if !collecting {
+			continue
+		}
+		for _, p := range strings.Split(arg, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				patterns = append(patterns, p)
+			}
+		}
+	}
+	return patterns
+}
+
+// matchRepoFiles lists the blobs of ref and returns those matching any of the
+// glob patterns, in tree order.
+func matchRepoFiles(ctx context.Context, client *github.Client, owner, repo, ref string, patterns []string) ([]string, error) {
+	tree, _, err := client.Git.GetTree(ctx, owner, repo, ref, true)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, entry := range tree.Entries {
+		if entry.GetType() != "blob" {
+			continue
+		}
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, entry.GetPath()); ok {
+				matches = append(matches, entry.GetPath())
+				break
+			}
+		}
+	}
+	return matches, nil
+}
+
+// repoFile is a file fetched from a repository.
+type repoFile struct {
+	Path    string
+	Content string
+}
+
+// fetchFiles downloads paths at ref until the file-count or byte budget is
+// exhausted, returning the fetched files and the paths that were skipped.
+func fetchFiles(ctx context.Context, client *github.Client, owner, repo, ref string, paths []string) ([]repoFile, []string) {
+	var files []repoFile
+	var skipped []string
+	total := 0
+	for _, p := range paths {
+		if len(files) >= maxExplainFiles || total >= maxExplainBytes {
+			skipped = append(skipped, p)
+			continue
+		}
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, p, &github.RepositoryContentGetOptions{Ref: ref})
+		if err != nil || file == nil {
+			log.Printf("Error fetching %s from %s/%s: %v", p, owner, repo, err)
+			skipped = append(skipped, p)
+			continue
+		}
+		content, err := file.GetContent()
+		if err != nil || total+len(content) > maxExplainBytes {
+			skipped = append(skipped, p)
+			continue
+		}
+		total += len(content)
+		files = append(files, repoFile{Path: p, Content: content})
+	}
+	return files, skipped
+}
+
+func fileNames(files []repoFile) []string {
+	names := make([]string, len(files))
+	for i, f := range files {
+		names[i] = f.Path
+	}
+	return names
+}
+
+func generateExplanation(ctx context.Context, llm Generator, issueTitle string, files []repoFile) (string, error) {
+	var sources strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&sources, "--- %s ---\n%s\n", f.Path, numberLines(f.Content))
+	}
+	prompt := fmt.Sprintf(
+		"Explain the following source files to product managers and new contributors who will work on the feature \"%s\".\n\n"+
+			"Structure the explanation as GitHub-flavored Markdown with these sections:\n"+
+			"1.  **Overview:** (What this code is responsible for, in plain language)\n"+
+			"2.  **Key Components:** (The main types, functions and files, and what each does)\n"+
+			"3.  **How It Fits Together:** (Control and data flow between the components and the rest of the system)\n"+
+			"4.  **Things to Watch Out For:** (Invariants, side effects and areas likely affected by the feature)\n\n"+
+			"**Source Files** (each line is prefixed with its line number):\n%s\n"+
+			"%s",
+		issueTitle, sources.String(), citationInstructions,
+	)
+	explanation, err := generateMarkdown(withSystemPrompt(ctx, CommandExplain), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate explanation: %w", err)
+	}
+	return explanation, nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseFilesFlag(t *testing.T) {
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{[]string{"--files", "pkg/auth/*.go"}, []string{"pkg/auth/*.go"}},
+		{[]string{"--files", "a.go,", "b.go", "--other", "x"}, []string{"a.go", "b.go"}},
+		{[]string{"--files=a.go,b.go"}, []string{"a.go", "b.go"}},
+		{[]string{"pkg/auth/*.go"}, nil},
+	}
+	for _, tt := range tests {
+		if got := parseFilesFlag(tt.args); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseFilesFlag(%q) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestExplainMatchesGlobAndPostsExplanation(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "pkg/auth/login.go", "package auth // login")
+	env.github.addFile("acme", "widgets", "pkg/auth/token.go", "package auth // token")
+	env.github.addFile("acme", "widgets", "pkg/auth/sub/deep.go", "package sub")
+	env.gemini.on("Explain the following source files", "**Overview:** Handles authentication.")
+
+	env.comment(t, "@prd-bot explain --files pkg/auth/*.go")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected 1 comment, got %d", len(comments))
+	}
+	body := comments[0].GetBody()
+	if !strings.HasPrefix(body, ExplainIdentifier) || !strings.Contains(body, "`pkg/auth/login.go`, `pkg/auth/token.go`") {
+		t.Errorf("unexpected explanation comment:\n%s", body)
+	}
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "// login") || strings.Contains(prompt, "package sub") {
+		t.Errorf("prompt should include only the matched files:\n%s", prompt)
+	}
+}
@@ -0,0 +1,1538 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+	"google.golang.org/api/option"
+)
+
+const (
+	testAppName       = "prd-bot"
+	testWebhookSecret = "test-secret"
+	testHeadSHA       = "3f2c1e0d9b8a7c6d5e4f30211203f4e5d6c7b8a9" // commit every ref resolves to
+)
+
+// fakeGitHub is an in-memory stand-in for the GitHub REST API.
+type fakeGitHub struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	nextID   int64
+	files    map[string]string                 // "owner/repo/path" -> content
+	refFiles map[string]string                 // "owner/repo@ref/path" -> content at a commit, overriding files
+	labels   map[string][]string               // "owner/repo#n" -> labels added
+	comments map[string][]*github.IssueComment // "owner/repo#n" -> comments
+	pulls    []*github.PullRequest
+	issues   map[string]*github.Issue // "owner/repo#n" -> issue
+	roles    map[string]string        // login -> "admin", "maintain", "write" or "read"
+	graphql  []string                 // received GraphQL queries
+	gqlVars  []map[string]any         // variables of the received GraphQL queries
+	parents  map[string]int           // "owner/repo#n" -> parent issue number
+	branches map[string]string        // "owner/repo@branch" -> commit SHA of created branches
+	commits  map[string]string        // "owner/repo@branch/path" -> content committed through the contents API
+	reviews  map[int]int              // pull request number -> number of reviews
+	history  map[string][]string      // "owner/repo/path" -> logins of the recent commits to it
+
+	searchResults []*github.Issue // issues and pull requests returned by every issue search
+	searches      []string        // received issue search queries
+
+	rules      map[string][]*github.RepositoryRule // branch name prefix -> ruleset rules applying to it
+	protection map[string]*github.Protection       // branch -> classic branch protection
+
+	pullFiles      map[int][]*github.CommitFile // pull request number -> changed files
+	reviewComments []*github.PullRequestComment // review comments created by the bot
+
+	blobs      map[string]string // blob SHA -> content created through the Git Data API
+	trees      []*github.Tree    // trees created through the Git Data API, with their base tree as SHA
+	gitCommits []*github.Commit  // commits created through the Git Data API
+	blobReads  []string          // paths of the blobs downloaded through the Git Data API
+
+	dispatches     []github.DispatchRequestOptions // repository_dispatch events sent
+	dispatchStatus int                             // status of dispatch requests; 0 accepts them
+
+	archived bool // repositories are archived
+	fork     bool // repositories are forks the bot can't push to
+	empty    bool // repositories have no commits
+
+	permissions map[string]string // installation permissions; nil grants write on everything
+	installs    map[string]int64  // installation of each "owner/repo"; 7 when missing
+
+	createdIssues   int
+	deletedComments []int64 // IDs of deleted comments
+	commentEdits    int     // comment edit requests received, including rejected ones
+	pullEdits       int     // pull request edits received
+	abuseResponses  int     // number of upcoming comment edits to reject with a secondary rate limit
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	t.Helper()
+	f := &fakeGitHub{
+		t:        t,
+		nextID:   1000,
+		files:    make(map[string]string),
+		refFiles: make(map[string]string),
+		labels:   make(map[string][]string),
+		comments: make(map[string][]*github.IssueComment),
+		issues:   make(map[string]*github.Issue),
+		roles:    make(map[string]string),
+		parents:  make(map[string]int),
+		branches: make(map[string]string),
+		commits:  make(map[string]string),
+		reviews:  make(map[int]int),
+		history:  make(map[string][]string),
+
+		rules:      make(map[string][]*github.RepositoryRule),
+		protection: make(map[string]*github.Protection),
+
+		pullFiles: make(map[int][]*github.CommitFile),
+		blobs:     make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/{owner}/{repo}", f.getRepo)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/contents/{path...}", f.getContents)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/comments", f.listRepoComments)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/issues/comments/{id}", f.editComment)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/issues/comments/{id}", f.deleteComment)
+	// Comments are read at issues/comments/{id}, which a
+	// issues/{number}/comments pattern would conflict with.
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}/{sub}", func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.PathValue("number") == "comments":
+			f.getComment(w, r, r.PathValue("sub"))
+		case r.PathValue("sub") == "comments":
+			f.listComments(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/{number}/comments", f.createComment)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/pulls", f.createPull)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/rules/branches/{branch...}", f.getBranchRules)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/branches/{branch}/protection", f.getBranchProtection)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls", f.listPulls)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls/{number}", f.getPull)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/pulls/{number}", f.editPull)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls/{number}/reviews", func(w http.ResponseWriter, r *http.Request) {
+		number, _ := strconv.Atoi(r.PathValue("number"))
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		reviews := []*github.PullRequestReview{}
+		for i := 0; i < f.reviews[number]; i++ {
+			reviews = append(reviews, &github.PullRequestReview{ID: github.Int64(int64(i + 1)), State: github.String("COMMENTED")})
+		}
+		writeJSON(w, http.StatusOK, reviews)
+	})
+	mux.HandleFunc("GET /repos/{owner}/{repo}/pulls/{number}/files", func(w http.ResponseWriter, r *http.Request) {
+		number, _ := strconv.Atoi(r.PathValue("number"))
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		files := f.pullFiles[number]
+		if files == nil {
+			files = []*github.CommitFile{}
+		}
+		writeJSON(w, http.StatusOK, files)
+	})
+	mux.HandleFunc("POST /repos/{owner}/{repo}/pulls/{number}/comments", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Body      string `json:"body"`
+			InReplyTo int64  `json:"in_reply_to"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.nextID++
+		comment := &github.PullRequestComment{ID: github.Int64(f.nextID), Body: github.String(req.Body), InReplyTo: github.Int64(req.InReplyTo)}
+		f.reviewComments = append(f.reviewComments, comment)
+		writeJSON(w, http.StatusCreated, comment)
+	})
+	mux.HandleFunc("GET /repos/{owner}/{repo}/git/trees/{sha}", f.getTree)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/git/ref/{ref...}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		empty := f.empty
+		branch, isBranch := strings.CutPrefix(r.PathValue("ref"), "heads/")
+		_, created := f.branches[r.PathValue("owner")+"/"+r.PathValue("repo")+"@"+branch]
+		f.mu.Unlock()
+		if empty {
+			writeJSON(w, http.StatusConflict, map[string]string{"message": "Git Repository is empty."})
+			return
+		}
+		// Only the default branch and branches created by the bot exist.
+		if isBranch && branch != "main" && !created {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, &github.Reference{Ref: github.String("refs/" + r.PathValue("ref")), Object: &github.GitObject{SHA: github.String(testHeadSHA)}})
+	})
+	mux.HandleFunc("GET /repos/{owner}/{repo}/git/commits/{sha}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, &github.Commit{SHA: github.String(r.PathValue("sha")), Tree: &github.Tree{SHA: github.String("tree-" + r.PathValue("sha"))}})
+	})
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/blobs", f.createBlob)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/git/blobs/{sha}", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		p, _ := strings.CutPrefix(r.PathValue("sha"), "blob:")
+		content, ok := f.files[r.PathValue("owner")+"/"+r.PathValue("repo")+"/"+p]
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+			return
+		}
+		f.blobReads = append(f.blobReads, p)
+		fmt.Fprint(w, content)
+	})
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/trees", f.createTree)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/commits", f.createCommit)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/tarball/{ref}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, fmt.Sprintf("%s/archives/%s/%s/%s.tar.gz", f.server.URL, r.PathValue("owner"), r.PathValue("repo"), r.PathValue("ref")), http.StatusFound)
+	})
+	mux.HandleFunc("GET /archives/{owner}/{repo}/{archive}", f.getArchive)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/git/refs", f.createRef)
+	mux.HandleFunc("PUT /repos/{owner}/{repo}/contents/{path...}", f.putContents)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/commits/{ref}", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testHeadSHA)
+	})
+	mux.HandleFunc("GET /repos/{owner}/{repo}/collaborators/{user}/permission", f.getPermission)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues", f.listIssues)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues", f.createIssue)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}", f.getIssue)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/issues/{number}", f.editIssue)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}/parent", f.getParent)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}/sub_issues", f.listSubIssues)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/{number}/labels", f.addLabels)
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/issues/{number}/labels/{name}", f.removeLabel)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/{number}/assignees", f.addAssignees)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/commits", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		commits := []*github.RepositoryCommit{}
+		for _, login := range f.history[r.PathValue("owner")+"/"+r.PathValue("repo")+"/"+r.URL.Query().Get("path")] {
+			commits = append(commits, &github.RepositoryCommit{Author: &github.User{Login: github.String(login), Type: github.String("User")}})
+		}
+		writeJSON(w, http.StatusOK, commits)
+	})
+	mux.HandleFunc("GET /search/issues", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.searches = append(f.searches, r.URL.Query().Get("q"))
+		writeJSON(w, http.StatusOK, map[string]any{"total_count": len(f.searchResults), "items": f.searchResults})
+	})
+	mux.HandleFunc("POST /repos/{owner}/{repo}/dispatches", func(w http.ResponseWriter, r *http.Request) {
+		var req github.DispatchRequestOptions
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.dispatchStatus != 0 {
+			writeJSON(w, f.dispatchStatus, map[string]string{"message": "Resource not accessible by integration"})
+			return
+		}
+		f.dispatches = append(f.dispatches, req)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /graphql", f.handleGraphQL)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("fake GitHub: unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+	})
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// Client implements ClientFactory.
+func (f *fakeGitHub) Client(int64) (*github.Client, error) {
+	client := github.NewClient(f.server.Client())
+	baseURL, err := url.Parse(f.server.URL + "/")
+	if err != nil {
+		return nil, err
+	}
+	client.BaseURL = baseURL
+	return client, nil
+}
+
+// Token implements ClientFactory.
+func (f *fakeGitHub) Token(context.Context, int64) (string, error) {
+	return "test-token", nil
+}
+
+// Permissions implements permissionReporter.
+func (f *fakeGitHub) Permissions(context.Context, int64) (map[string]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.permissions == nil {
+		return map[string]string{"contents": "write", "pull_requests": "write", "issues": "write"}, nil
+	}
+	return f.permissions, nil
+}
+
+// RepoInstallation implements installationResolver.
+func (f *fakeGitHub) RepoInstallation(_ context.Context, owner, repo string) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id, ok := f.installs[owner+"/"+repo]
+	switch {
+	case !ok:
+		return 7, nil
+	case id == 0:
+		return 0, &github.ErrorResponse{Response: &http.Response{StatusCode: http.StatusNotFound}, Message: "Not Found"}
+	}
+	return id, nil
+}
+
+func (f *fakeGitHub) addFile(owner, repo, path, content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.files[owner+"/"+repo+"/"+path] = content
+}
+
+func (f *fakeGitHub) addComment(owner, repo string, number int, body string) *github.IssueComment {
+	return f.addCommentBy(owner, repo, number, testAppName+"[bot]", body)
+}
+
+// addCommentBy adds a comment written by login; logins ending in "[bot]" are bot accounts.
+func (f *fakeGitHub) addCommentBy(owner, repo string, number int, login, body string) *github.IssueComment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	userType := "User"
+	if strings.HasSuffix(login, "[bot]") {
+		userType = "Bot"
+	}
+	return f.storeCommentLocked(owner, repo, number, &github.IssueComment{
+		Body: github.String(body),
+		User: &github.User{Login: github.String(login), Type: github.String(userType)},
+	})
+}
+
+func (f *fakeGitHub) storeCommentLocked(owner, repo string, number int, comment *github.IssueComment) *github.IssueComment {
+	f.nextID++
+	comment.ID = github.Int64(f.nextID)
+	comment.NodeID = github.String(fmt.Sprintf("IC_%d", f.nextID))
+	comment.CreatedAt = &github.Timestamp{Time: time.Now()}
+	comment.IssueURL = github.String(fmt.Sprintf("%s/repos/%s/%s/issues/%d", f.server.URL, owner, repo, number))
+	key := fmt.Sprintf("%s/%s#%d", owner, repo, number)
+	f.comments[key] = append(f.comments[key], comment)
+	return comment
+}
+
+func (f *fakeGitHub) addIssue(owner, repo string, number int, title, state string) *github.Issue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.addIssueLocked(owner, repo, number, title, state)
+}
+
+func (f *fakeGitHub) addIssueLocked(owner, repo string, number int, title, state string) *github.Issue {
+	f.nextID++
+	issue := &github.Issue{
+		ID:      github.Int64(f.nextID),
+		NodeID:  github.String(fmt.Sprintf("I_%d", f.nextID)),
+		Number:  github.Int(number),
+		Title:   github.String(title),
+		State:   github.String(state),
+		HTMLURL: github.String(fmt.Sprintf("https://github.com/%s/%s/issues/%d", owner, repo, number)),
+	}
+	f.issues[fmt.Sprintf("%s/%s#%d", owner, repo, number)] = issue
+	return issue
+}
+
+func (f *fakeGitHub) issue(owner, repo string, number int) *github.Issue {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.issues[fmt.Sprintf("%s/%s#%d", owner, repo, number)]
+}
+
+// setParent makes issue number a sub-issue of parent.
+func (f *fakeGitHub) setParent(owner, repo string, number, parent int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.parents[fmt.Sprintf("%s/%s#%d", owner, repo, number)] = parent
+}
+
+func (f *fakeGitHub) setRole(login, role string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.roles[login] = role
+}
+
+// minimizedComments returns the node IDs of the comments minimized through
+// GraphQL, in order.
+func (f *fakeGitHub) minimizedComments() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var ids []string
+	for i, q := range f.graphql {
+		if strings.Contains(q, "minimizeComment") {
+			ids = append(ids, fmt.Sprint(f.gqlVars[i]["subjectId"]))
+		}
+	}
+	return ids
+}
+
+func (f *fakeGitHub) graphQLQueries() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.graphql...)
+}
+
+func (f *fakeGitHub) issueComments(owner, repo string, number int) []*github.IssueComment {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*github.IssueComment(nil), f.comments[fmt.Sprintf("%s/%s#%d", owner, repo, number)]...)
+}
+
+// addPull adds an open pull request and returns its number.
+func (f *fakeGitHub) addPull(owner, repo, title string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	number := len(f.pulls) + 1
+	f.pulls = append(f.pulls, &github.PullRequest{
+		Number:  github.Int(number),
+		Title:   github.String(title),
+		State:   github.String("open"),
+		HTMLURL: github.String(fmt.Sprintf("https://github.com/%s/%s/pull/%d", owner, repo, number)),
+	})
+	return number
+}
+
+func (f *fakeGitHub) pullRequests() []*github.PullRequest {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]*github.PullRequest(nil), f.pulls...)
+}
+
+func (f *fakeGitHub) getContents(w http.ResponseWriter, r *http.Request) {
+	prefix := r.PathValue("owner") + "/" + r.PathValue("repo") + "/"
+	f.mu.Lock()
+	content, ok := f.refFiles[r.PathValue("owner")+"/"+r.PathValue("repo")+"@"+r.URL.Query().Get("ref")+"/"+r.PathValue("path")]
+	if !ok {
+		content, ok = f.files[prefix+r.PathValue("path")]
+	}
+	// Other paths list the files directly under them as a directory.
+	dir := prefix
+	if r.PathValue("path") != "" {
+		dir += r.PathValue("path") + "/"
+	}
+	var entries []*github.RepositoryContent
+	for key := range f.files {
+		if name, found := strings.CutPrefix(key, dir); found && !strings.Contains(name, "/") {
+			entries = append(entries, &github.RepositoryContent{Type: github.String("file"), Name: github.String(name), Path: github.String(strings.TrimPrefix(key, prefix))})
+		}
+	}
+	f.mu.Unlock()
+	if !ok && len(entries) > 0 {
+		writeJSON(w, http.StatusOK, entries)
+		return
+	}
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, &github.RepositoryContent{
+		Type:     github.String("file"),
+		Path:     github.String(r.PathValue("path")),
+		Encoding: github.String(""),
+		Content:  github.String(content),
+		Size:     github.Int(len(content)),
+	})
+}
+
+func (f *fakeGitHub) createRef(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ref string `json:"ref"`
+		SHA string `json:"sha"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	branch := strings.TrimPrefix(req.Ref, "refs/heads/")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.branches[r.PathValue("owner")+"/"+r.PathValue("repo")+"@"+branch] = req.SHA
+	writeJSON(w, http.StatusCreated, &github.Reference{Ref: github.String(req.Ref), Object: &github.GitObject{SHA: github.String(req.SHA)}})
+}
+
+func (f *fakeGitHub) createBlob(w http.ResponseWriter, r *http.Request) {
+	var blob github.Blob
+	if err := json.NewDecoder(r.Body).Decode(&blob); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	content := []byte(blob.GetContent())
+	if blob.GetEncoding() == "base64" {
+		var err error
+		if content, err = base64.StdEncoding.DecodeString(blob.GetContent()); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+			return
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	sha := fmt.Sprintf("blob-%d", f.nextID)
+	f.blobs[sha] = string(content)
+	writeJSON(w, http.StatusCreated, &github.Blob{SHA: github.String(sha)})
+}
+
+func (f *fakeGitHub) createTree(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		BaseTree string              `json:"base_tree"`
+		Tree     []*github.TreeEntry `json:"tree"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	f.trees = append(f.trees, &github.Tree{SHA: github.String(req.BaseTree), Entries: req.Tree})
+	writeJSON(w, http.StatusCreated, &github.Tree{SHA: github.String(fmt.Sprintf("tree-%d", f.nextID)), Entries: req.Tree})
+}
+
+func (f *fakeGitHub) createCommit(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Message string   `json:"message"`
+		Tree    string   `json:"tree"`
+		Parents []string `json:"parents"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.nextID++
+	commit := &github.Commit{SHA: github.String(fmt.Sprintf("commit-%d", f.nextID)), Message: github.String(req.Message), Tree: &github.Tree{SHA: github.String(req.Tree)}}
+	for _, p := range req.Parents {
+		commit.Parents = append(commit.Parents, &github.Commit{SHA: github.String(p)})
+	}
+	f.gitCommits = append(f.gitCommits, commit)
+	writeJSON(w, http.StatusCreated, commit)
+}
+
+// getArchive serves the files of a repository as a gzipped tarball, with
+// the top-level directory GitHub adds.
+func (f *fakeGitHub) getArchive(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := r.PathValue("owner") + "/" + r.PathValue("repo") + "/"
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	archive := tar.NewWriter(gz)
+	top := fmt.Sprintf("%s-%s-%s/", r.PathValue("owner"), r.PathValue("repo"), testHeadSHA[:7])
+	archive.WriteHeader(&tar.Header{Name: top, Typeflag: tar.TypeDir, Mode: 0o755})
+	for key, content := range f.files {
+		p, ok := strings.CutPrefix(key, prefix)
+		if !ok {
+			continue
+		}
+		mode := int64(0o644)
+		if strings.HasSuffix(p, ".sh") {
+			mode = 0o755
+		}
+		archive.WriteHeader(&tar.Header{Name: top + p, Typeflag: tar.TypeReg, Mode: mode, Size: int64(len(content))})
+		archive.Write([]byte(content))
+	}
+	archive.Close()
+	gz.Close()
+	w.Header().Set("Content-Type", "application/x-gzip")
+	w.Write(buf.Bytes())
+}
+
+func (f *fakeGitHub) putContents(w http.ResponseWriter, r *http.Request) {
+	var opts struct {
+		Message string `json:"message"`
+		Content []byte `json:"content"`
+		Branch  string `json:"branch"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commits[r.PathValue("owner")+"/"+r.PathValue("repo")+"@"+opts.Branch+"/"+r.PathValue("path")] = string(opts.Content)
+	writeJSON(w, http.StatusCreated, &github.RepositoryContentResponse{Content: &github.RepositoryContent{Path: github.String(r.PathValue("path"))}})
+}
+
+// committed returns the content committed to path on branch, if any.
+func (f *fakeGitHub) committed(owner, repo, branch, path string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	content, ok := f.commits[owner+"/"+repo+"@"+branch+"/"+path]
+	return content, ok
+}
+
+func (f *fakeGitHub) getTree(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := r.PathValue("owner") + "/" + r.PathValue("repo") + "/"
+	var paths []string
+	for key := range f.files {
+		if p, ok := strings.CutPrefix(key, prefix); ok {
+			paths = append(paths, p)
+		}
+	}
+	sort.Strings(paths)
+	// github.TreeEntry doesn't marshal its size, which only trees read have.
+	entries := []map[string]any{}
+	if r.URL.Query().Get("recursive") == "" {
+		// Without recursion, list the entries of one directory: the root, or
+		// the one named by a "tree:<dir>" SHA. Blobs are "blob:<path>".
+		dir, _ := strings.CutPrefix(r.PathValue("sha"), "tree:")
+		if !strings.HasPrefix(r.PathValue("sha"), "tree:") {
+			dir = ""
+		}
+		seen := make(map[string]bool)
+		for _, p := range paths {
+			rest, ok := strings.CutPrefix(p, dir+"/")
+			if dir == "" {
+				rest, ok = p, true
+			}
+			if !ok {
+				continue
+			}
+			if name, _, isDir := strings.Cut(rest, "/"); isDir {
+				if !seen[name] {
+					seen[name] = true
+					entries = append(entries, map[string]any{"path": name, "type": "tree", "mode": "040000", "sha": "tree:" + path.Join(dir, name)})
+				}
+				continue
+			}
+			mode := "100644"
+			if strings.HasSuffix(p, ".sh") {
+				mode = "100755"
+			}
+			entries = append(entries, map[string]any{"path": rest, "type": "blob", "mode": mode, "sha": "blob:" + p})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"sha": r.PathValue("sha"), "tree": entries})
+		return
+	}
+	for _, p := range paths {
+		entries = append(entries, map[string]any{"path": p, "type": "blob", "mode": "100644", "size": len(f.files[prefix+p])})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"sha": r.PathValue("sha"), "tree": entries})
+}
+
+func (f *fakeGitHub) getBranchRules(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	rules := []*github.RepositoryRule{}
+	for prefix, list := range f.rules {
+		if strings.HasPrefix(r.PathValue("branch"), prefix) {
+			rules = append(rules, list...)
+		}
+	}
+	writeJSON(w, http.StatusOK, rules)
+}
+
+func (f *fakeGitHub) getBranchProtection(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	protection, ok := f.protection[r.PathValue("branch")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Branch not protected"})
+		return
+	}
+	writeJSON(w, http.StatusOK, protection)
+}
+
+func (f *fakeGitHub) getPermission(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	role := f.roles[r.PathValue("user")]
+	f.mu.Unlock()
+	permission := role
+	switch role {
+	case "":
+		permission, role = "read", "read"
+	case "maintain":
+		permission = "write"
+	}
+	writeJSON(w, http.StatusOK, &github.RepositoryPermissionLevel{
+		Permission: github.String(permission),
+		User: &github.User{
+			Login:       github.String(r.PathValue("user")),
+			RoleName:    github.String(role),
+			Permissions: map[string]bool{role: true},
+		},
+	})
+}
+
+func (f *fakeGitHub) listIssues(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := r.PathValue("owner") + "/" + r.PathValue("repo") + "#"
+	state := r.URL.Query().Get("state")
+	var issues []*github.Issue
+	for key, issue := range f.issues {
+		if strings.HasPrefix(key, prefix) && (state == "" || state == "all" || state == issue.GetState()) {
+			issues = append(issues, issue)
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].GetNumber() > issues[j].GetNumber() })
+	writeJSON(w, http.StatusOK, issues)
+}
+
+func (f *fakeGitHub) getIssue(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issue, ok := f.issues[r.PathValue("owner")+"/"+r.PathValue("repo")+"#"+r.PathValue("number")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (f *fakeGitHub) getParent(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	parent, ok := f.parents[owner+"/"+repo+"#"+r.PathValue("number")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, f.issues[fmt.Sprintf("%s/%s#%d", owner, repo, parent)])
+}
+
+func (f *fakeGitHub) listSubIssues(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	owner, repo := r.PathValue("owner"), r.PathValue("repo")
+	parent, _ := strconv.Atoi(r.PathValue("number"))
+	subIssues := []*github.Issue{}
+	for key, p := range f.parents {
+		if p == parent && strings.HasPrefix(key, owner+"/"+repo+"#") {
+			subIssues = append(subIssues, f.issues[key])
+		}
+	}
+	slices.SortFunc(subIssues, func(a, b *github.Issue) int { return a.GetNumber() - b.GetNumber() })
+	writeJSON(w, http.StatusOK, subIssues)
+}
+
+func (f *fakeGitHub) createIssue(w http.ResponseWriter, r *http.Request) {
+	var req github.IssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.createdIssues++
+	// Created issues are numbered from 101 to keep clear of the numbers used by fixtures.
+	issue := f.addIssueLocked(r.PathValue("owner"), r.PathValue("repo"), 100+f.createdIssues, req.GetTitle(), "open")
+	issue.Body = req.Body
+	if req.Labels != nil {
+		for _, name := range *req.Labels {
+			issue.Labels = append(issue.Labels, &github.Label{Name: github.String(name)})
+		}
+	}
+	if req.Assignees != nil {
+		for _, login := range *req.Assignees {
+			issue.Assignees = append(issue.Assignees, &github.User{Login: github.String(login)})
+		}
+	}
+	writeJSON(w, http.StatusCreated, issue)
+}
+
+func (f *fakeGitHub) editIssue(w http.ResponseWriter, r *http.Request) {
+	var req github.IssueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issue, ok := f.issues[r.PathValue("owner")+"/"+r.PathValue("repo")+"#"+r.PathValue("number")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	if req.Title != nil {
+		issue.Title = req.Title
+	}
+	if req.Body != nil {
+		issue.Body = req.Body
+	}
+	if req.State != nil {
+		issue.State = req.State
+	}
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (f *fakeGitHub) addLabels(w http.ResponseWriter, r *http.Request) {
+	var names []string
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.PathValue("owner") + "/" + r.PathValue("repo") + "#" + r.PathValue("number")
+	var labels []*github.Label
+	for _, name := range names {
+		if !slices.Contains(f.labels[key], name) {
+			f.labels[key] = append(f.labels[key], name)
+		}
+	}
+	for _, name := range f.labels[key] {
+		labels = append(labels, &github.Label{Name: github.String(name)})
+	}
+	writeJSON(w, http.StatusOK, labels)
+}
+
+func (f *fakeGitHub) removeLabel(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.PathValue("owner") + "/" + r.PathValue("repo") + "#" + r.PathValue("number")
+	if !slices.Contains(f.labels[key], r.PathValue("name")) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Label does not exist"})
+		return
+	}
+	f.labels[key] = slices.DeleteFunc(f.labels[key], func(name string) bool { return name == r.PathValue("name") })
+	writeJSON(w, http.StatusOK, []*github.Label{})
+}
+
+// issueLabels returns the labels added to issue number.
+func (f *fakeGitHub) issueLabels(owner, repo string, number int) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.labels[fmt.Sprintf("%s/%s#%d", owner, repo, number)]...)
+}
+
+func (f *fakeGitHub) addAssignees(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Assignees []string `json:"assignees"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	issue, ok := f.issues[r.PathValue("owner")+"/"+r.PathValue("repo")+"#"+r.PathValue("number")]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	for _, login := range req.Assignees {
+		issue.Assignees = append(issue.Assignees, &github.User{Login: github.String(login)})
+	}
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (f *fakeGitHub) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string         `json:"query"`
+		Variables map[string]any `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	f.graphql = append(f.graphql, req.Query)
+	f.gqlVars = append(f.gqlVars, req.Variables)
+	f.mu.Unlock()
+	// Every repository has discussions, whose comments are only recorded.
+	switch {
+	case strings.Contains(req.Query, "discussion(number:"):
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"repository": map[string]any{"discussion": map[string]any{"id": fmt.Sprintf("D_%v", req.Variables["number"])}}}})
+	case strings.Contains(req.Query, "addDiscussionComment"):
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{"addDiscussionComment": map[string]any{"comment": map[string]any{"url": "https://github.com/discussions/comment"}}}})
+	default:
+		writeJSON(w, http.StatusOK, map[string]any{"data": map[string]any{}})
+	}
+}
+
+// discussionComments returns the bodies of the discussion comments added
+// through GraphQL, in order.
+func (f *fakeGitHub) discussionComments() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var bodies []string
+	for i, q := range f.graphql {
+		if strings.Contains(q, "addDiscussionComment") {
+			bodies = append(bodies, fmt.Sprint(f.gqlVars[i]["body"]))
+		}
+	}
+	return bodies
+}
+
+func (f *fakeGitHub) listComments(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := r.PathValue("owner") + "/" + r.PathValue("repo") + "#" + r.PathValue("number")
+	writeJSON(w, http.StatusOK, f.comments[key])
+}
+
+// listRepoComments lists the comments of every issue in the repository,
+// oldest first, honouring the since parameter.
+func (f *fakeGitHub) listRepoComments(w http.ResponseWriter, r *http.Request) {
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, _ = time.Parse(time.RFC3339, v)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	prefix := r.PathValue("owner") + "/" + r.PathValue("repo") + "#"
+	var comments []*github.IssueComment
+	for key, list := range f.comments {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, c := range list {
+			if !c.GetCreatedAt().Time.Before(since) {
+				comments = append(comments, c)
+			}
+		}
+	}
+	sort.Slice(comments, func(i, j int) bool { return comments[i].GetID() < comments[j].GetID() })
+	if r.URL.Query().Get("direction") == "desc" {
+		slices.Reverse(comments)
+	}
+	writeJSON(w, http.StatusOK, comments)
+}
+
+func (f *fakeGitHub) deleteComment(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deletedComments = append(f.deletedComments, id)
+	for key, list := range f.comments {
+		f.comments[key] = slices.DeleteFunc(list, func(c *github.IssueComment) bool { return c.GetID() == id })
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *fakeGitHub) getComment(w http.ResponseWriter, r *http.Request, id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, list := range f.comments {
+		for _, c := range list {
+			if strconv.FormatInt(c.GetID(), 10) == id {
+				writeJSON(w, http.StatusOK, c)
+				return
+			}
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// react sets the 👍 and 👎 reactions to a comment.
+func (f *fakeGitHub) react(comment *github.IssueComment, up, down int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	comment.Reactions = &github.Reactions{PlusOne: github.Int(up), MinusOne: github.Int(down), TotalCount: github.Int(up + down)}
+}
+
+func (f *fakeGitHub) editComment(w http.ResponseWriter, r *http.Request) {
+	var req github.IssueComment
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commentEdits++
+	if f.abuseResponses > 0 {
+		f.abuseResponses--
+		w.Header().Set("Retry-After", "1")
+		writeJSON(w, http.StatusForbidden, map[string]string{
+			"message":           "You have exceeded a secondary rate limit.",
+			"documentation_url": "https://docs.github.com/rest/overview/rate-limits-for-the-rest-api#about-secondary-rate-limits",
+		})
+		return
+	}
+	prefix := r.PathValue("owner") + "/" + r.PathValue("repo") + "#"
+	for key, list := range f.comments {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		for _, c := range list {
+			if strconv.FormatInt(c.GetID(), 10) == r.PathValue("id") {
+				c.Body = req.Body
+				writeJSON(w, http.StatusOK, c)
+				return
+			}
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func (f *fakeGitHub) getRepo(w http.ResponseWriter, r *http.Request) {
+	owner, name := r.PathValue("owner"), r.PathValue("repo")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	repo := &github.Repository{
+		Name:          github.String(name),
+		FullName:      github.String(owner + "/" + name),
+		DefaultBranch: github.String("main"),
+		Owner:         &github.User{Login: github.String(owner)},
+		Archived:      github.Bool(f.archived),
+		Fork:          github.Bool(f.fork),
+	}
+	if f.fork {
+		repo.Permissions = map[string]bool{"pull": true, "push": false}
+	}
+	writeJSON(w, http.StatusOK, repo)
+}
+
+func (f *fakeGitHub) createComment(w http.ResponseWriter, r *http.Request) {
+	var comment github.IssueComment
+	if err := json.NewDecoder(r.Body).Decode(&comment); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	comment.User = &github.User{Login: github.String(testAppName + "[bot]"), Type: github.String("Bot")}
+	writeJSON(w, http.StatusCreated, f.storeCommentLocked(r.PathValue("owner"), r.PathValue("repo"), number, &comment))
+}
+
+func (f *fakeGitHub) createPull(w http.ResponseWriter, r *http.Request) {
+	var req github.NewPullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pr := range f.pulls {
+		if pr.GetState() == "open" && pr.GetHead().GetRef() == req.GetHead() && pr.GetBase().GetRef() == req.GetBase() {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]any{
+				"message": "Validation Failed",
+				"errors":  []map[string]string{{"resource": "PullRequest", "code": "custom", "message": "A pull request already exists for " + r.PathValue("owner") + ":" + req.GetHead() + "."}},
+			})
+			return
+		}
+	}
+	f.nextID++
+	number := len(f.pulls) + 1
+	pr := &github.PullRequest{
+		ID:      github.Int64(f.nextID),
+		Number:  github.Int(number),
+		Title:   req.Title,
+		Body:    req.Body,
+		State:   github.String("open"),
+		User:    &github.User{Login: github.String(testAppName + "[bot]"), Type: github.String("Bot")},
+		Head:    &github.PullRequestBranch{Ref: req.Head},
+		Base:    &github.PullRequestBranch{Ref: req.Base},
+		HTMLURL: github.String(fmt.Sprintf("https://github.com/%s/%s/pull/%d", r.PathValue("owner"), r.PathValue("repo"), number)),
+	}
+	f.pulls = append(f.pulls, pr)
+	writeJSON(w, http.StatusCreated, pr)
+}
+
+func (f *fakeGitHub) setMergeable(number int, mergeable bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pr := range f.pulls {
+		if pr.GetNumber() == number {
+			pr.Mergeable = github.Bool(mergeable)
+			pr.MergeableState = github.String("clean")
+			if !mergeable {
+				pr.MergeableState = github.String("dirty")
+			}
+		}
+	}
+}
+
+func (f *fakeGitHub) listPulls(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, base, head := r.URL.Query().Get("state"), r.URL.Query().Get("base"), r.URL.Query().Get("head")
+	pulls := []*github.PullRequest{}
+	for _, pr := range f.pulls {
+		if (state == "" || state == "all" || pr.GetState() == state) && (base == "" || pr.GetBase().GetRef() == base) &&
+			(head == "" || head == r.PathValue("owner")+":"+pr.GetHead().GetRef()) {
+			// Like GitHub, the list endpoint does not report mergeability.
+			listed := *pr
+			listed.Mergeable, listed.MergeableState = nil, nil
+			pulls = append(pulls, &listed)
+		}
+	}
+	writeJSON(w, http.StatusOK, pulls)
+}
+
+func (f *fakeGitHub) editPull(w http.ResponseWriter, r *http.Request) {
+	var req github.PullRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"message": err.Error()})
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pr := range f.pulls {
+		if strconv.Itoa(pr.GetNumber()) == r.PathValue("number") {
+			if req.Title != nil {
+				pr.Title = req.Title
+			}
+			if req.Body != nil {
+				pr.Body = req.Body
+			}
+			f.pullEdits++
+			writeJSON(w, http.StatusOK, pr)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func (f *fakeGitHub) getPull(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pr := range f.pulls {
+		if strconv.Itoa(pr.GetNumber()) == r.PathValue("number") {
+			writeJSON(w, http.StatusOK, pr)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+// fakeGemini is a stand-in for the Gemini REST endpoint that answers from fixtures.
+type fakeGemini struct {
+	t      *testing.T
+	server *httptest.Server
+
+	mu       sync.Mutex
+	rules    []geminiRule
+	defaults []geminiRule // consulted after rules
+	prompts  []string
+	systems  []string // system instruction of each request, in order
+	models   []string // model of each request, in order
+	keys     []string // x-goog-api-key header of each request, in order
+	images   []string // MIME types of the inline images of all requests, in order
+
+	toolScripts []geminiToolScript
+	toolResults []string // "name: result" of every function response received, in order
+
+	embeddings []geminiEmbedding // consulted in order; unmatched texts embed as zero vectors
+}
+
+// geminiEmbedding embeds any text containing match as vector.
+type geminiEmbedding struct {
+	match  string
+	vector []float32
+}
+
+// geminiRule answers any prompt containing match with reply.
+type geminiRule struct {
+	match string
+	reply string
+}
+
+// geminiToolScript answers prompts containing match with one function call
+// per model turn, until calls run out.
+type geminiToolScript struct {
+	match string
+	calls []geminiCall
+}
+
+// geminiCall is a function call of the fake model.
+type geminiCall struct {
+	name string
+	args map[string]any
+}
+
+func newFakeGemini(t *testing.T) *fakeGemini {
+	t.Helper()
+	g := &fakeGemini{t: t}
+	g.server = httptest.NewServer(http.HandlerFunc(g.generateContent))
+	t.Cleanup(g.server.Close)
+	return g
+}
+
+// on registers a reply for prompts containing match. Earlier rules win.
+func (g *fakeGemini) on(match, reply string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rules = append(g.rules, geminiRule{match: match, reply: reply})
+}
+
+// byDefault registers a reply for prompts containing match that no rule
+// registered with on matches.
+func (g *fakeGemini) byDefault(match, reply string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.defaults = append(g.defaults, geminiRule{match: match, reply: reply})
+}
+
+// callTools makes the model call tools, one call a turn, on prompts
+// containing match. The rules registered with on answer once the calls run
+// out.
+func (g *fakeGemini) callTools(match string, calls ...geminiCall) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.toolScripts = append(g.toolScripts, geminiToolScript{match: match, calls: calls})
+}
+
+func (g *fakeGemini) receivedToolResults() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.toolResults...)
+}
+
+// embedOn registers the embedding of texts containing match.
+func (g *fakeGemini) embedOn(match string, vector ...float32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.embeddings = append(g.embeddings, geminiEmbedding{match: match, vector: vector})
+}
+
+func (g *fakeGemini) receivedModels() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.models...)
+}
+
+func (g *fakeGemini) receivedKeys() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.keys...)
+}
+
+func (g *fakeGemini) receivedSystemPrompts() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.systems...)
+}
+
+func (g *fakeGemini) receivedImages() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.images...)
+}
+
+func (g *fakeGemini) receivedPrompts() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.prompts...)
+}
+
+// generator returns a Generator that talks to the fake endpoint through the real genai SDK.
+func (g *fakeGemini) generator() Generator {
+	return &geminiGenerator{
+		model: defaultGeminiModel,
+		opts: []option.ClientOption{
+			option.WithAPIKey("test-key"),
+			option.WithEndpoint(g.server.URL),
+			option.WithHTTPClient(g.server.Client()),
+		},
+	}
+}
+
+func (g *fakeGemini) generateContent(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, ":batchEmbedContents") {
+		g.batchEmbedContents(w, r)
+		return
+	}
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, ":generateContent") {
+		g.t.Errorf("fake Gemini: unexpected request %s %s", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+	type content struct {
+		Role  string `json:"role"`
+		Parts []struct {
+			Text       string `json:"text"`
+			InlineData *struct {
+				MIMEType string `json:"mimeType"`
+			} `json:"inlineData"`
+			FunctionResponse *struct {
+				Name     string `json:"name"`
+				Response struct {
+					Result string `json:"result"`
+				} `json:"response"`
+			} `json:"functionResponse"`
+		} `json:"parts"`
+	}
+	var req struct {
+		Contents          []content `json:"contents"`
+		SystemInstruction *content  `json:"systemInstruction"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "message": err.Error()}})
+		return
+	}
+	var prompt strings.Builder
+	var images, toolResults []string
+	turns := 0 // model turns so far, in chats
+	for i, content := range req.Contents {
+		if content.Role == "model" {
+			turns++
+		}
+		for _, part := range content.Parts {
+			prompt.WriteString(part.Text)
+			if part.InlineData != nil {
+				images = append(images, part.InlineData.MIMEType)
+			}
+			// Earlier responses were recorded with their request.
+			if response := part.FunctionResponse; response != nil && i == len(req.Contents)-1 {
+				toolResults = append(toolResults, response.Name+": "+response.Response.Result)
+			}
+		}
+	}
+
+	var system strings.Builder
+	if req.SystemInstruction != nil {
+		for _, part := range req.SystemInstruction.Parts {
+			system.WriteString(part.Text)
+		}
+	}
+
+	g.mu.Lock()
+	g.prompts = append(g.prompts, prompt.String())
+	g.systems = append(g.systems, system.String())
+	g.models = append(g.models, strings.TrimSuffix(path.Base(r.URL.Path), ":generateContent"))
+	g.keys = append(g.keys, r.Header.Get("x-goog-api-key"))
+	g.images = append(g.images, images...)
+	g.toolResults = append(g.toolResults, toolResults...)
+	for _, script := range g.toolScripts {
+		if strings.Contains(prompt.String(), script.match) && turns < len(script.calls) {
+			call := script.calls[turns]
+			g.mu.Unlock()
+			writeJSON(w, http.StatusOK, map[string]any{
+				"candidates": []any{map[string]any{
+					"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"functionCall": map[string]any{"name": call.name, "args": call.args}}}},
+					"finishReason": "STOP",
+				}},
+			})
+			return
+		}
+	}
+	reply, found := "", false
+	for _, rule := range slices.Concat(g.rules, g.defaults) {
+		if strings.Contains(prompt.String(), rule.match) {
+			reply, found = rule.reply, true
+			break
+		}
+	}
+	g.mu.Unlock()
+
+	if !found {
+		g.t.Errorf("fake Gemini: no fixture for prompt:\n%s", prompt.String())
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": map[string]any{"code": 500, "message": "no fixture"}})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"candidates": []any{map[string]any{
+			"content":      map[string]any{"role": "model", "parts": []any{map[string]any{"text": reply}}},
+			"finishReason": "STOP",
+		}},
+		// Roughly four characters a token, like Gemini's tokenizer.
+		"usageMetadata": map[string]any{
+			"promptTokenCount":     len(prompt.String()) / 4,
+			"candidatesTokenCount": len(reply) / 4,
+			"totalTokenCount":      (len(prompt.String()) + len(reply)) / 4,
+		},
+	})
+}
+
+func (g *fakeGemini) batchEmbedContents(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Requests []struct {
+			Content struct {
+				Parts []struct {
+					Text string `json:"text"`
+				} `json:"parts"`
+			} `json:"content"`
+		} `json:"requests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": map[string]any{"code": 400, "message": err.Error()}})
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var embeddings []any
+	for _, request := range req.Requests {
+		var text strings.Builder
+		for _, part := range request.Content.Parts {
+			text.WriteString(part.Text)
+		}
+		vector := []float32{0, 0, 0}
+		for _, e := range g.embeddings {
+			if strings.Contains(text.String(), e.match) {
+				vector = e.vector
+				break
+			}
+		}
+		embeddings = append(embeddings, map[string]any{"values": vector})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"embeddings": embeddings})
+}
+
+// fakeRunner records external commands instead of executing them.
+type fakeRunner struct {
+	mu       sync.Mutex
+	commands []string
+	failOn   string                             // fail any command line containing this substring, with its outputs entry if any
+	outputs  map[string]string                  // output of command lines containing the key
+	effects  map[string]func(dir string)        // run for command lines containing the key, in the command's directory
+	replies  map[string]func(dir string) string // output of command lines containing the key, given the command's directory
+}
+
+func (r *fakeRunner) run(dir, name string, args ...string) (string, error) {
+	line := strings.Join(append([]string{name}, args...), " ")
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, line)
+	if r.failOn != "" && strings.Contains(line, r.failOn) {
+		for match, out := range r.outputs {
+			if strings.Contains(line, match) {
+				return out, fmt.Errorf("exit status 1")
+			}
+		}
+		return "simulated failure", fmt.Errorf("exit status 1")
+	}
+	for match, effect := range r.effects {
+		if strings.Contains(line, match) {
+			effect(dir)
+		}
+	}
+	for match, reply := range r.replies {
+		if strings.Contains(line, match) {
+			return reply(dir), nil
+		}
+	}
+	for match, out := range r.outputs {
+		if strings.Contains(line, match) {
+			return out, nil
+		}
+	}
+	return "", nil
+}
+
+func (r *fakeRunner) executed() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.commands...)
+}
+
+// testEnv wires a Bot to fake GitHub, Gemini and command execution.
+type testEnv struct {
+	bot    *Bot
+	github *fakeGitHub
+	gemini *fakeGemini
+	runner *fakeRunner
+}
+
+// testAssessment is the default self-assessment of generated changes.
+const testAssessment = `{"confidence": 85, "rationale": "The change follows the issue closely.", "assumptions": ["Reports fit in memory."], "risks": ["No tests cover empty reports."]}`
+
+func newTestEnv(t *testing.T) *testEnv {
+	t.Helper()
+	env := &testEnv{
+		github: newFakeGitHub(t),
+		gemini: newFakeGemini(t),
+		runner: &fakeRunner{},
+	}
+	// Every implement_feature run asks the model to assess its change,
+	env.gemini.byDefault("Self-assess", testAssessment)
+	// and to write a reviewer checklist when the issue has a PRD.
+	env.gemini.byDefault("Write the reviewer checklist", `{"items": []}`)
+	// need_sub_task picks the existing code areas of repositories with code.
+	env.gemini.byDefault("Pick the existing repository files", `{"files": []}`)
+	env.bot = NewBot(testAppName, testWebhookSecret, env.github, env.gemini.generator())
+	env.bot.runner = env.runner.run
+	env.bot.edits.minInterval = 0
+	return env
+}
+
+// deliver sends a signed webhook built from a fixture and waits for dispatched handlers to finish.
+func (env *testEnv) deliver(t *testing.T, event, fixture string) *httptest.ResponseRecorder {
+	t.Helper()
+	return env.deliverPayload(t, event, loadFixture(t, filepath.Join("webhooks", fixture)))
+}
+
+// comment delivers an issue_comment webhook for issue #42 with the given comment body.
+func (env *testEnv) comment(t *testing.T, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	return env.deliverPayload(t, "issue_comment", commentPayload(t, body))
+}
+
+// commentPayload is an issue_comment webhook for issue #42 with the given comment body.
+func commentPayload(t *testing.T, body string) []byte {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issue_comment_need_sub_task.json"), &event); err != nil {
+		t.Fatalf("decoding comment fixture: %v", err)
+	}
+	event["comment"].(map[string]any)["body"] = body
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding comment payload: %v", err)
+	}
+	return payload
+}
+
+// deliverPayload signs and sends payload as a webhook of type event and waits for dispatched handlers to finish.
+func (env *testEnv) deliverPayload(t *testing.T, event string, payload []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	mac := hmac.New(sha256.New, []byte(testWebhookSecret))
+	mac.Write(payload)
+
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader(string(payload)))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+	req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	rec := httptest.NewRecorder()
+	env.bot.handleWebhook(rec, req)
+	env.bot.jobs.Wait()
+	return rec
+}
+
+func loadFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatalf("reading fixture %s: %v", name, err)
+	}
+	return data
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// Values of fast_path.mode.
+const (
+	fastPathMiniPRD  = "mini_prd"  // post a one-paragraph PRD (default)
+	fastPathSubTasks = "sub_tasks" // skip the PRD and post sub-tasks
+)
+
+const (
+	// fullPRDFlag makes need_prd write a full PRD for a small issue,
+	// replacing its mini PRD.
+	fullPRDFlag = "--full"
+
+	// miniPRDPrefix starts the English part of a mini PRD.
+	miniPRDPrefix = "**Mini PRD:** "
+
+	promptMiniPRD = "mini_prd"
+
+	defaultFastPathBodyLength = 200
+)
+
+// defaultFastPathKeywords mark small issues in their title when a
+// repository doesn't list its own.
+var defaultFastPathKeywords = []string{"typo", "spelling", "bump", "update dependency", "upgrade dependency", "broken link"}
+
+// FastPathConfig spares small issues, such as typo fixes and dependency
+// bumps, a full PRD.
+type FastPathConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Mode is "mini_prd" (default) or "sub_tasks".
+	Mode string `yaml:"mode"`
+	// MaxBodyLength makes issues whose description is at most that many
+	// characters small; 200 by default, 0 turns the check off.
+	MaxBodyLength *int `yaml:"max_body_length"`
+	// Keywords make issues with one of them in the title small.
+	Keywords []string `yaml:"keywords"`
+}
+
+func (c *FastPathConfig) enabled() bool {
+	return c != nil && c.Enabled
+}
+
+func (c *FastPathConfig) mode() string {
+	if c == nil || c.Mode == "" {
+		return fastPathMiniPRD
+	}
+	return c.Mode
+}
+
+func (c *FastPathConfig) maxBodyLength() int {
+	if c == nil || c.MaxBodyLength == nil {
+		return defaultFastPathBodyLength
+	}
+	return max(*c.MaxBodyLength, 0)
+}
+
+func (c *FastPathConfig) keywords() []string {
+	if c == nil || len(c.Keywords) == 0 {
+		return defaultFastPathKeywords
+	}
+	return c.Keywords
+}
+
+// smallIssue reports whether issue takes the fast path, and why.
+func (c *FastPathConfig) smallIssue(issue *github.Issue) (string, bool) {
+	if !c.enabled() {
+		return "", false
+	}
+	title := strings.ToLower(issue.GetTitle())
+	for _, keyword := range c.keywords() {
+		pattern := `\b` + regexp.QuoteMeta(strings.ToLower(strings.TrimSpace(keyword))) + `\b`
+		if keyword != "" && regexp.MustCompile(pattern).MatchString(title) {
+			return fmt.Sprintf("the title mentions %q", keyword), true
+		}
+	}
+	if limit := c.maxBodyLength(); limit > 0 && utf8.RuneCountInString(strings.TrimSpace(issue.GetBody())) <= limit {
+		return fmt.Sprintf("the description is at most %d characters long", limit), true
+	}
+	return "", false
+}
+
+// isMiniPRD reports whether the PRD comment body is a mini PRD.
+func isMiniPRD(body string) bool {
+	doc, ok := parsePRDDocument(body)
+	return ok && strings.HasPrefix(doc.English, miniPRDPrefix)
+}
+
+// processFastPath answers a small issue with a mini PRD or, in sub_tasks
+// mode, with sub-tasks straight away.
+func (b *Bot) processFastPath(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, cfg *FastPathConfig, reason string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Issue #%d in %s/%s is small (%s), taking the fast path.", issueNum, repoOwner, repoName, reason)
+
+	if cfg.mode() == fastPathSubTasks {
+		if previous, err := b.findSubTasksComment(ctx, client, repoOwner, repoName, issueNum); err != nil || previous != nil {
+			log.Printf("Issue #%d already has sub-tasks or they couldn't be listed (%v). Skipping the fast path.", issueNum, err)
+			return
+		}
+		note := fmt.Sprintf("_This issue looks small (%s), so I skipped the PRD. Run `@%s %s %s` if it needs one._", reason, b.appName, CommandGeneratePRD, fullPRDFlag)
+		source := fmt.Sprintf("**Issue:** %s\n\n%s", issue.GetTitle(), issue.GetBody())
+		b.writeSubTasks(ctx, client, issue, repo, source, note)
+		return
+	}
+
+	start := time.Now()
+	summary, err := generateMiniPRD(ctx, b.llm, issue)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "generate a PRD", "Could not generate the mini PRD", err)
+		return
+	}
+	b.recordPRD(repoOwner, repoName, time.Since(start))
+	note := fmt.Sprintf("_This issue looks small (%s), so I kept its PRD to a paragraph. Run `@%s %s %s` for a full one._", reason, b.appName, CommandGeneratePRD, fullPRDFlag)
+	prdContent := (&PRDDocument{English: miniPRDPrefix + summary + "\n\n" + note}).String()
+	prdContent = b.versionNewPRD(repoOwner, repoName, issueNum, prdContent)
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactPRD, prdContent)
+	b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, prdContent, comment)
+	b.publishEvent(EventPRDCreated, repoOwner, repoName, issueNum, comment.GetHTMLURL(), map[string]any{"title": issue.GetTitle()})
+}
+
+// generateMiniPRD writes the one-paragraph English PRD of a small issue.
+func generateMiniPRD(ctx context.Context, llm Generator, issue *github.Issue) (string, error) {
+	prompt := fmt.Sprintf(
+		"Write a mini Product Requirements Document (PRD) for the following small GitHub issue: a single English paragraph of at most four sentences stating what changes, why, and how to tell it is done. Don't use headings or lists.\n\n"+
+			"**GitHub Issue Title:**\n%s\n\n"+
+			"**GitHub Issue Body:**\n%s",
+		issue.GetTitle(), issue.GetBody(),
+	)
+	summary, err := generateMarkdown(withSystemPrompt(ctx, promptMiniPRD), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate the mini PRD: %w", err)
+	}
+	return strings.Join(strings.Fields(summary), " "), nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestSmallIssueGetsMiniPRD(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "fast_path:\n  enabled: true\n")
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Write a mini Product Requirements Document", "Add a CSV export to reports,\nso analysts can open them in spreadsheets.")
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_en.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected the mini PRD only, got %d comments", len(comments))
+	}
+	doc, ok := parsePRDDocument(comments[0].GetBody())
+	if !ok || !strings.HasPrefix(doc.English, miniPRDPrefix+"Add a CSV export to reports, so analysts can open them in spreadsheets.") || !strings.Contains(doc.English, "the description is at most 200 characters long") || doc.Translated != "" {
+		t.Errorf("unexpected mini PRD:\n%s", comments[0].GetBody())
+	}
+	for _, prompt := range env.gemini.receivedPrompts() {
+		if strings.Contains(prompt, "Create a Product Requirements Document") {
+			t.Error("a small issue shouldn't get a full PRD")
+		}
+	}
+
+	env.comment(t, "@prd-bot need_prd --full")
+
+	client, _ := env.github.Client(7)
+	prd, err := env.bot.findPRDComment(t.Context(), client, "acme", "widgets", 42)
+	if err != nil || prd == nil || isMiniPRD(prd.GetBody()) || !strings.Contains(prd.GetBody(), "**Goals:**") {
+		t.Errorf("--full should replace the mini PRD, got %v:\n%s", err, prd.GetBody())
+	}
+}
+
+func TestSmallIssueSkipsToSubTasks(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "fast_path:\n  enabled: true\n  mode: sub_tasks\n  max_body_length: 0\n  keywords: [csv]\n")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV exporter.")
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected the sub-tasks only, got %d comments", len(comments))
+	}
+	body := comments[0].GetBody()
+	if !strings.HasPrefix(body, SubTasksIdentifier) || !strings.Contains(body, "- [ ] Add the CSV exporter.") || !strings.Contains(body, `the title mentions "csv"`) {
+		t.Errorf("unexpected sub-tasks:\n%s", body)
+	}
+	if prompts := env.gemini.receivedPrompts(); len(prompts) == 0 || !strings.Contains(prompts[0], "**Issue:** Export reports as CSV") {
+		t.Errorf("the sub-tasks should come from the issue, got prompts %q", prompts)
+	}
+}
+
+func TestFastPathSmallIssue(t *testing.T) {
+	zero := 0
+	long := strings.Repeat("x", 300)
+	tests := []struct {
+		name   string
+		cfg    *FastPathConfig
+		title  string
+		body   string
+		reason string
+	}{
+		{"off", nil, "Fix typo", "", ""},
+		{"keyword", &FastPathConfig{Enabled: true}, "Bump golang.org/x/net from 0.1 to 0.2", long, `the title mentions "bump"`},
+		{"whole words", &FastPathConfig{Enabled: true}, "Improve typography", long, ""},
+		{"short body", &FastPathConfig{Enabled: true}, "Add SSO", "Please.", "the description is at most 200 characters long"},
+		{"length off", &FastPathConfig{Enabled: true, MaxBodyLength: &zero}, "Add SSO", "", ""},
+	}
+	for _, tt := range tests {
+		issue := &github.Issue{Title: github.String(tt.title), Body: github.String(tt.body)}
+		if reason, small := tt.cfg.smallIssue(issue); reason != tt.reason || small != (tt.reason != "") {
+			t.Errorf("%s: smallIssue = %q, %v; want %q", tt.name, reason, small, tt.reason)
+		}
+	}
+}
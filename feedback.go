@@ -0,0 +1,276 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandFeedback = "feedback"
+
+	// bucketFeedback holds feedbackEntry documents keyed by
+	// "owner/repo#number@<time in nanoseconds>".
+	bucketFeedback = "feedback"
+
+	// reactionSyncInterval is how often the reactions to artifact comments
+	// are read, as GitHub sends no webhook for reactions.
+	reactionSyncInterval = time.Hour
+	// maxFeedbackLength truncates written feedback.
+	maxFeedbackLength = 500
+	// maxPromptFeedback bounds the feedback added to one system prompt.
+	maxPromptFeedback = 5
+	// maxDashboardFeedback bounds the written feedback the dashboard lists.
+	maxDashboardFeedback = 20
+)
+
+// feedbackPrompts are the prompts that write each kind of artifact, which
+// learn from the feedback on it.
+var feedbackPrompts = map[string]string{
+	ArtifactPRD:      CommandGeneratePRD,
+	ArtifactSubTasks: CommandGenerateSubTask,
+}
+
+// feedbackEntry is written feedback on the bot's artifacts for an issue.
+type feedbackEntry struct {
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Issue     int       `json:"issue"`
+	Artifact  string    `json:"artifact,omitempty"` // kind of the artifact it is about, if any
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// processFeedback records `@bot feedback [prd|sub_tasks] <text>` about the
+// named artifact of the issue, or the latest one.
+func (b *Bot) processFeedback(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandFeedback, issueNum, repoOwner, repoName)
+
+	var kind string
+	if len(args) > 0 && feedbackPrompts[args[0]] != "" {
+		kind, args = args[0], args[1:]
+	}
+	text := strings.TrimSpace(strings.Join(args, " "))
+	if text == "" {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Tell me what to improve with `@%s %s <your feedback>`, optionally naming the document first, e.g. `@%s %s %s The scope is too broad.`", b.appName, CommandFeedback, b.appName, CommandFeedback, ArtifactPRD))
+		return
+	}
+	if len(text) > maxFeedbackLength {
+		text = text[:maxFeedbackLength] + "…"
+	}
+	var artifact *Artifact
+	if kind != "" {
+		artifact, _ = b.loadArtifact(repoOwner, repoName, issueNum, kind)
+	} else {
+		artifact = b.latestArtifact(repoOwner, repoName, issueNum)
+	}
+	entry := &feedbackEntry{
+		Owner:     repoOwner,
+		Repo:      repoName,
+		Issue:     issueNum,
+		Artifact:  kind,
+		Author:    commandSender(ctx).GetLogin(),
+		Text:      text,
+		CreatedAt: time.Now().UTC(),
+	}
+	if artifact != nil {
+		entry.Artifact = artifact.Kind
+	}
+	key := fmt.Sprintf("%s@%d", issueKey(repoOwner, repoName, issueNum), entry.CreatedAt.UnixNano())
+	if err := b.store.Put(bucketFeedback, key, entry); err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "record your feedback", "Could not store the feedback", err)
+		return
+	}
+
+	about := "on my work for this issue"
+	if entry.Artifact != "" {
+		about = "on the " + artifactName(entry.Artifact)
+	}
+	msg := fmt.Sprintf("Thanks, I've recorded your feedback %s.", about)
+	if cfg := b.repoConfig(ctx, client, repo); cfg.LearnFromFeedbackEnabled() && feedbackPrompts[entry.Artifact] != "" {
+		msg += fmt.Sprintf(" I'll take it into account when writing future %ss for this repository.", artifactName(entry.Artifact))
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+}
+
+// artifactName names an artifact kind in comments.
+func artifactName(kind string) string {
+	if kind == ArtifactSubTasks {
+		return "sub-task breakdown"
+	}
+	return strings.ToUpper(kind)
+}
+
+// latestArtifact returns the most recently generated artifact of the
+// issue that feedback can be about, or nil.
+func (b *Bot) latestArtifact(owner, repo string, issueNum int) *Artifact {
+	var latest *Artifact
+	for kind := range feedbackPrompts {
+		artifact, err := b.loadArtifact(owner, repo, issueNum, kind)
+		if err != nil || artifact == nil {
+			continue
+		}
+		if latest == nil || artifact.CreatedAt.After(latest.CreatedAt) {
+			latest = artifact
+		}
+	}
+	return latest
+}
+
+// repoFeedback returns the written feedback on owner/repo, newest first.
+func (b *Bot) repoFeedback(owner, repo string) []feedbackEntry {
+	docs, err := b.store.List(bucketFeedback)
+	if err != nil {
+		log.Printf("Error listing feedback: %v", err)
+		return nil
+	}
+	var entries []feedbackEntry
+	for _, doc := range docs {
+		var entry feedbackEntry
+		if json.Unmarshal(doc, &entry) != nil {
+			continue
+		}
+		if (owner == "" || strings.EqualFold(entry.Owner, owner)) && (repo == "" || strings.EqualFold(entry.Repo, repo)) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b feedbackEntry) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return entries
+}
+
+type promptFeedbackKey struct{}
+
+// withFeedback returns a context whose system prompts include the latest
+// written feedback of the repository on the artifacts they write, when the
+// repository's learn_from_feedback is on.
+func (b *Bot) withFeedback(ctx context.Context, cfg *RepoConfig, repo *github.Repository) context.Context {
+	if !cfg.LearnFromFeedbackEnabled() {
+		return ctx
+	}
+	notes := make(map[string][]string)
+	for _, entry := range b.repoFeedback(repo.GetOwner().GetLogin(), repo.GetName()) {
+		if name := feedbackPrompts[entry.Artifact]; name != "" && len(notes[name]) < maxPromptFeedback {
+			notes[name] = append(notes[name], strings.Join(strings.Fields(entry.Text), " "))
+		}
+	}
+	return context.WithValue(ctx, promptFeedbackKey{}, notes)
+}
+
+// feedbackInstruction is the part of the system prompt name carrying the
+// repository's feedback, or "" without any.
+func feedbackInstruction(ctx context.Context, name string) string {
+	notes, _ := ctx.Value(promptFeedbackKey{}).(map[string][]string)
+	if len(notes[name]) == 0 {
+		return ""
+	}
+	return "\n\nThis repository's team gave the following feedback on documents you wrote for it before. Take it into account where it applies:\n- " + strings.Join(notes[name], "\n- ")
+}
+
+// reactionLoop reads the reactions to artifact comments every interval
+// until ctx is cancelled.
+func (b *Bot) reactionLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, interval)
+			b.syncReactions(roundCtx)
+			cancel()
+		}
+	}
+}
+
+// syncReactions stores the 👍 and 👎 reactions to the comment of each
+// artifact.
+func (b *Bot) syncReactions(ctx context.Context) {
+	docs, err := b.store.List(bucketArtifacts)
+	if err != nil {
+		log.Printf("Error listing artifacts for their reactions: %v", err)
+		return
+	}
+	clients := make(map[string]*github.Client)
+	for key, doc := range docs {
+		var artifact Artifact
+		if json.Unmarshal(doc, &artifact) != nil || artifact.CommentID == 0 {
+			continue
+		}
+		fullName := artifact.Owner + "/" + artifact.Repo
+		client, seen := clients[fullName]
+		if !seen {
+			client = b.installationClient(artifact.Owner, artifact.Repo)
+			clients[fullName] = client
+		}
+		if client == nil {
+			continue
+		}
+		comment, _, err := client.Issues.GetComment(ctx, artifact.Owner, artifact.Repo, artifact.CommentID)
+		if err != nil {
+			log.Printf("Error reading the reactions to the %s of %s: %v", artifact.Kind, key, err)
+			continue
+		}
+		up, down := comment.GetReactions().GetPlusOne(), comment.GetReactions().GetMinusOne()
+		if up == artifact.ThumbsUp && down == artifact.ThumbsDown {
+			continue
+		}
+		// The artifact may have been regenerated meanwhile.
+		var current Artifact
+		if ok, _ := b.store.Get(bucketArtifacts, key, &current); !ok || current.CommentID != artifact.CommentID {
+			continue
+		}
+		current.ThumbsUp, current.ThumbsDown = up, down
+		if err := b.store.Put(bucketArtifacts, key, &current); err != nil {
+			log.Printf("Error storing the reactions to %s: %v", key, err)
+		}
+	}
+}
+
+// feedbackSummary is the feedback of a repository on the dashboard.
+type feedbackSummary struct {
+	ThumbsUp, ThumbsDown, Written int
+}
+
+// summarizeFeedback totals the reactions and written feedback per
+// repository, keyed by "owner/repo".
+func (b *Bot) summarizeFeedback() map[string]*feedbackSummary {
+	summaries := make(map[string]*feedbackSummary)
+	summary := func(owner, repo string) *feedbackSummary {
+		key := owner + "/" + repo
+		if summaries[key] == nil {
+			summaries[key] = &feedbackSummary{}
+		}
+		return summaries[key]
+	}
+	artifacts, err := b.store.List(bucketArtifacts)
+	if err != nil {
+		log.Printf("Error listing artifacts for the feedback summary: %v", err)
+	}
+	for _, doc := range artifacts {
+		var artifact Artifact
+		if json.Unmarshal(doc, &artifact) == nil && artifact.ThumbsUp+artifact.ThumbsDown > 0 {
+			s := summary(artifact.Owner, artifact.Repo)
+			s.ThumbsUp += artifact.ThumbsUp
+			s.ThumbsDown += artifact.ThumbsDown
+		}
+	}
+	for _, entry := range b.repoFeedback("", "") {
+		summary(entry.Owner, entry.Repo).Written++
+	}
+	return summaries
+}
+
+// feedbackKind describes the artifact of feedback on the dashboard.
+func (e feedbackEntry) Kind() string {
+	return cmp.Or(e.Artifact, "-")
+}
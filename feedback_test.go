@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestFeedbackIsLearnedByLaterPRDs(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "learn_from_feedback: true\n")
+	stubPRD(t, env)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(42)}, "# PRD", nil)
+
+	env.comment(t, "@prd-bot feedback Always   list the permissions a feature needs.")
+
+	entries := env.bot.repoFeedback("acme", "widgets")
+	if len(entries) != 1 || entries[0].Artifact != ArtifactPRD || entries[0].Author != "alice" || entries[0].Text != "Always list the permissions a feature needs." {
+		t.Fatalf("unexpected feedback %+v", entries)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "feedback on the PRD") || !strings.Contains(body, "future PRDs") {
+		t.Errorf("unexpected reply:\n%s", body)
+	}
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	var learned bool
+	prompts, systems := env.gemini.receivedPrompts(), env.gemini.receivedSystemPrompts()
+	for i, p := range prompts {
+		if strings.HasPrefix(p, "Create a Product Requirements Document") {
+			learned = strings.Contains(systems[i], "feedback on documents you wrote for it before") && strings.Contains(systems[i], "- Always list the permissions a feature needs.")
+		}
+	}
+	if !learned {
+		t.Errorf("the PRD system prompt should include the feedback:\n%s", strings.Join(systems, "\n---\n"))
+	}
+}
+
+func TestFeedbackIsNotLearnedByDefault(t *testing.T) {
+	env := newTestEnv(t)
+	stubPRD(t, env)
+
+	env.comment(t, "@prd-bot feedback prd Too long.")
+	env.deliver(t, "issues", "issues_opened.json")
+
+	if entries := env.bot.repoFeedback("acme", "widgets"); len(entries) != 1 || entries[0].Artifact != ArtifactPRD {
+		t.Fatalf("unexpected feedback %+v", entries)
+	}
+	for _, system := range env.gemini.receivedSystemPrompts() {
+		if strings.Contains(system, "Too long.") {
+			t.Errorf("feedback shouldn't reach prompts unless learn_from_feedback is on:\n%s", system)
+		}
+	}
+}
+
+func TestReactionsAreShownOnDashboard(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	comment := env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"PRD")
+	env.github.react(comment, 3, 1)
+	env.bot.rememberInstallation(&github.Repository{FullName: github.String("acme/widgets")}, 7)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(42)}, "# PRD", comment)
+	env.bot.recordCommand("acme", "widgets", CommandGeneratePRD)
+	env.comment(t, "@prd-bot feedback The <b>goals</b> were spot on.")
+
+	env.bot.syncReactions(t.Context())
+
+	artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD)
+	if artifact.ThumbsUp != 3 || artifact.ThumbsDown != 1 {
+		t.Fatalf("expected 3 👍 and 1 👎, got %+v", artifact)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/dashboard", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	env.bot.handleDashboard(rec, req)
+	page := rec.Body.String()
+	for _, want := range []string{
+		`<td class="n">3</td><td class="n">1</td><td class="n">1</td>`,
+		"<td>acme/widgets#42</td><td>prd</td><td>alice</td><td>The &lt;b&gt;goals&lt;/b&gt; were spot on.</td>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("expected %q on the dashboard:\n%s", want, page)
+		}
+	}
+}
+
+// stubPRD answers the prompts of a PRD for the issues_opened.json fixture.
+func stubPRD(t *testing.T, env *testEnv) {
+	t.Helper()
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_en.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// defaultFixIterations is how many times by default the model gets the
+// failures of the checks of its change to fix them.
+const defaultFixIterations = 2
+
+// FixLoopConfig bounds how implement_feature fixes its change when the checks
+// validating it (go_checks, tests and container) fail: the failures go back
+// to the model for another edit until the checks pass or the budget is
+// spent.
+type FixLoopConfig struct {
+	// MaxIterations bounds the fix edits; 2 by default. 0 reports failures
+	// without fixing them.
+	MaxIterations *int `yaml:"max_iterations"`
+	// MaxTokens bounds the model tokens the fix edits use together; 0 means
+	// no limit. The Gemini CLI doesn't report its tokens, so only
+	// MaxIterations bounds its edits.
+	MaxTokens int64 `yaml:"max_tokens"`
+}
+
+func (c *FixLoopConfig) maxIterations() int {
+	if c == nil || c.MaxIterations == nil || *c.MaxIterations < 0 {
+		return defaultFixIterations
+	}
+	return *c.MaxIterations
+}
+
+func (c *FixLoopConfig) maxTokens() int64 {
+	if c == nil || c.MaxTokens < 0 {
+		return 0
+	}
+	return c.MaxTokens
+}
+
+// checkResult is the outcome of a check validating a change.
+type checkResult struct {
+	Section  string // the pull request section reporting it; "" when the check didn't run
+	Failures string // the errors for the model to fix; "" when it passed
+}
+
+// validateChange runs the checks the repository configured on the change in
+// ws and, while some fail, has fix edit the change given their failures,
+// within the fix loop's budget. fix is called with a context metering the
+// tokens of its model requests. It returns the pull request sections
+// reporting the last round of checks, or "" when none ran.
+func (b *Bot) validateChange(ctx context.Context, client *github.Client, repo *github.Repository, ws workspace, issueNum int, fix func(ctx context.Context, failures string) error, progress *progressComment) string {
+	cfg := b.repoConfig(ctx, client, repo).FixLoop
+	var tokens int64
+	fixCtx := withTokenMeter(ctx, func(inputTokens, outputTokens int64) {
+		tokens += inputTokens + outputTokens
+	})
+	for iteration := 0; ; iteration++ {
+		var sections, failures []string
+		for _, result := range []checkResult{
+			b.runGoChecks(ctx, client, repo, ws, progress),
+			b.runChangeTests(ctx, client, repo, ws, progress),
+			b.runContainerBuild(ctx, client, repo, ws, issueNum, progress),
+		} {
+			if result.Section != "" {
+				sections = append(sections, result.Section)
+			}
+			if result.Failures != "" {
+				failures = append(failures, result.Failures)
+			}
+		}
+		report := strings.Join(sections, "\n\n")
+		if iteration == 0 && (len(failures) == 0 || cfg.maxIterations() == 0) {
+			return report
+		}
+		if len(failures) == 0 {
+			return report + "\n\n" + fixLoopNote("The checks passed", iteration, tokens, "")
+		}
+
+		var stop string
+		switch {
+		case iteration == cfg.maxIterations():
+			stop = fmt.Sprintf("the limit of %d fix iteration(s) was reached", cfg.maxIterations())
+		case cfg.maxTokens() > 0 && tokens >= cfg.maxTokens():
+			stop = fmt.Sprintf("the token budget of %d was spent", cfg.maxTokens())
+		}
+		if stop == "" {
+			progress.step("Fix iteration %d of %d: sent %d failed check(s) back to the model", iteration+1, cfg.maxIterations(), len(failures))
+			err := fix(fixCtx, strings.Join(failures, "\n\n"))
+			if err == nil {
+				continue
+			}
+			log.Printf("Could not fix the change for issue #%d in %s: %v", issueNum, repo.GetFullName(), err)
+			stop = "the model's fix edit failed"
+		}
+		progress.step("Stopped fixing the change: %s", stop)
+		return report + "\n\n" + fixLoopNote("The checks still fail", iteration, tokens, stop)
+	}
+}
+
+// fixLoopNote reports the fix iterations made on a change, which used
+// tokens, and why they stopped before the checks passed.
+func fixLoopNote(outcome string, iterations int, tokens int64, stop string) string {
+	note := fmt.Sprintf("_%s after %d fix iteration(s)", outcome, iterations)
+	if tokens > 0 {
+		note += fmt.Sprintf(", which used %d model tokens", tokens)
+	}
+	if stop != "" {
+		note += "; I stopped because " + stop
+	}
+	return note + "._"
+}
+
+// fixRequest asks an edit prompt to fix failures, the output of the checks
+// the previous edit failed, or is "" without failures.
+func fixRequest(failures string) string {
+	if failures == "" {
+		return ""
+	}
+	return fmt.Sprintf("\n\nYou already edited the files for this issue, but the change fails these checks. Fix the errors, keeping the rest of your change:\n```\n%s\n```", failures)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFixLoopStopsAtTokenBudget(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  editor: agent\ntests:\n  run: affected\nfix_loop:\n  max_iterations: 3\n  max_tokens: 10\n")
+	goModuleRunner(t, env)
+	env.runner.failOn = "go test -count=1"
+	env.runner.outputs["go test -count=1"] = "--- FAIL: TestExport (0.00s)\n    csv_test.go:9: want 4 columns, got 3\nFAIL\tex.com/m/export"
+	env.gemini.callTools("in the repository you can reach through your tools",
+		geminiCall{"write_file", map[string]any{"path": "export/csv.go", "content": "package export\n"}},
+	)
+	env.gemini.on("in the repository you can reach through your tools", "Added the CSV exporter.")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	var edits []string
+	for _, prompt := range env.gemini.receivedPrompts() {
+		if strings.Contains(prompt, "in the repository you can reach through your tools") {
+			edits = append(edits, prompt)
+		}
+	}
+	// Each edit sends its prompt again with the result of the write.
+	edits = slices.Compact(edits)
+	if len(edits) != 2 || !strings.Contains(edits[1], "the change fails these checks") || !strings.Contains(edits[1], "$ go test\n--- FAIL: TestExport (0.00s)\n    csv_test.go:9: want 4 columns, got 3") {
+		t.Fatalf("the test failure should go back to the model once, got %d edits:\n%s", len(edits), strings.Join(edits, "\n---\n"))
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected a pull request, got %d", len(pulls))
+	}
+	body := pulls[0].GetBody()
+	if !strings.Contains(body, "> The tests of 4 packages failed on this change.") || !strings.Contains(body, "_The checks still fail after 1 fix iteration(s), which used ") || !strings.Contains(body, "model tokens; I stopped because the token budget of 10 was spent._") {
+		t.Errorf("the pull request should report the fix loop:\n%s", body)
+	}
+	progress := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	for _, want := range []string{
+		"- [x] Ran the tests of 4 packages: failed",
+		"- [x] Fix iteration 1 of 3: sent 1 failed check(s) back to the model",
+		"- [x] Stopped fixing the change: the token budget of 10 was spent",
+	} {
+		if !strings.Contains(progress, want) {
+			t.Errorf("progress comment is missing %q:\n%s", want, progress)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Feature flags gate capabilities that are rolled out gradually. Each flag
+// has a default; a rule, from the FEATURE_FLAGS environment variable or
+// stored through the flags API, replaces the default with the set of
+// installations and repositories the flag is enabled for.
+const (
+	FlagAutoPRD   = "auto_prd"
+	FlagStreaming = "streaming"
+
+	// bucketFlags holds the flag rules set through the API, keyed by flag
+	// name. They take precedence over FEATURE_FLAGS.
+	bucketFlags = "feature_flags"
+)
+
+type featureFlag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Default     bool   `json:"default"`
+}
+
+var featureFlags = []featureFlag{
+	{FlagAutoPRD, "Generate a PRD when an issue is opened.", true},
+	{FlagStreaming, "Update progress comments while long-running commands work, instead of once at the end.", true},
+}
+
+func lookupFeatureFlag(name string) (featureFlag, bool) {
+	i := slices.IndexFunc(featureFlags, func(f featureFlag) bool { return f.Name == name })
+	if i < 0 {
+		return featureFlag{}, false
+	}
+	return featureFlags[i], true
+}
+
+// FlagRule lists where a flag is enabled. An empty rule disables the flag
+// everywhere.
+type FlagRule struct {
+	All           bool     `json:"all,omitempty"`
+	Repos         []string `json:"repos,omitempty"` // "owner/repo", or "owner/*" for every repository of owner
+	Installations []int64  `json:"installations,omitempty"`
+	// Percentage enables the flag for that share of repositories, picked by
+	// a stable hash of the repository name so a repository stays in or out
+	// as the share grows.
+	Percentage int `json:"percentage,omitempty"`
+}
+
+// matches reports whether the rule enables its flag for the repository.
+func (r *FlagRule) matches(installationID int64, repoFullName string) bool {
+	if r.All || (installationID != 0 && slices.Contains(r.Installations, installationID)) {
+		return true
+	}
+	for _, pattern := range r.Repos {
+		if ok, _ := path.Match(strings.ToLower(pattern), strings.ToLower(repoFullName)); ok {
+			return true
+		}
+	}
+	return r.Percentage > 0 && rolloutBucket(repoFullName) < r.Percentage
+}
+
+// rolloutBucket maps a repository to a stable bucket in [0, 100).
+func rolloutBucket(repoFullName string) int {
+	h := fnv.New32a()
+	h.Write([]byte(strings.ToLower(repoFullName)))
+	return int(h.Sum32() % 100)
+}
+
+// parseFeatureFlags parses FEATURE_FLAGS: rules separated by ";", each a
+// flag name, "=" and comma-separated targets: "all" or "on", "off",
+// "owner/repo", "owner/*", an installation ID, or a percentage such as
+// "25%". For example "streaming=off;auto_prd=acme/*,12345".
+func parseFeatureFlags(value string) (map[string]FlagRule, error) {
+	rules := make(map[string]FlagRule)
+	for _, entry := range strings.Split(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, targets, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok {
+			return nil, fmt.Errorf("invalid feature flag %q: want name=targets", entry)
+		}
+		if _, known := lookupFeatureFlag(name); !known {
+			return nil, fmt.Errorf("unknown feature flag %q", name)
+		}
+		var rule FlagRule
+		for _, target := range strings.Split(targets, ",") {
+			target = strings.TrimSpace(target)
+			switch {
+			case target == "" || target == "off":
+			case target == "all" || target == "on":
+				rule.All = true
+			case strings.HasSuffix(target, "%"):
+				pct, err := strconv.Atoi(strings.TrimSuffix(target, "%"))
+				if err != nil || pct < 0 || pct > 100 {
+					return nil, fmt.Errorf("invalid rollout percentage %q for feature flag %s", target, name)
+				}
+				rule.Percentage = pct
+			case strings.Contains(target, "/"):
+				rule.Repos = append(rule.Repos, target)
+			default:
+				id, err := strconv.ParseInt(target, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("invalid target %q for feature flag %s: want a repository, installation ID or percentage", target, name)
+				}
+				rule.Installations = append(rule.Installations, id)
+			}
+		}
+		rules[name] = rule
+	}
+	return rules, nil
+}
+
+// flagRule returns the rule in effect for the flag and where it comes from,
+// or nil when the flag's default applies.
+func (b *Bot) flagRule(name string) (*FlagRule, string) {
+	var rule FlagRule
+	ok, err := b.store.Get(bucketFlags, name, &rule)
+	if err != nil {
+		log.Printf("Error loading feature flag %s, using FEATURE_FLAGS: %v", name, err)
+	}
+	if ok && err == nil {
+		return &rule, "store"
+	}
+	if rule, ok := b.flagRules[name]; ok {
+		return &rule, "env"
+	}
+	return nil, "default"
+}
+
+// flagEnabled reports whether the flag is enabled for the repository, as
+// installed by installationID (zero when unknown).
+func (b *Bot) flagEnabled(name string, installationID int64, repoFullName string) bool {
+	flag, ok := lookupFeatureFlag(name)
+	if !ok {
+		log.Printf("Checked unknown feature flag %q", name)
+		return false
+	}
+	rule, _ := b.flagRule(name)
+	if rule == nil {
+		return flag.Default
+	}
+	return rule.matches(installationID, repoFullName)
+}
+
+// flagStatus is a flag as reported by the flags API.
+type flagStatus struct {
+	featureFlag
+	Source string    `json:"source"` // "default", "env" or "store"
+	Rule   *FlagRule `json:"rule,omitempty"`
+}
+
+// handleFlags lists the feature flags and their rules: GET /flags
+func (b *Bot) handleFlags(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	statuses := make([]flagStatus, 0, len(featureFlags))
+	for _, flag := range featureFlags {
+		rule, source := b.flagRule(flag.Name)
+		statuses = append(statuses, flagStatus{featureFlag: flag, Source: source, Rule: rule})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(statuses); err != nil {
+		log.Printf("Error encoding feature flags: %v", err)
+	}
+}
+
+// handleFlagUpdate stores a flag rule, overriding FEATURE_FLAGS, or deletes
+// it to fall back to FEATURE_FLAGS and the default:
+// PUT /flags/{name} with a FlagRule body, DELETE /flags/{name}
+func (b *Bot) handleFlagUpdate(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+	if _, ok := lookupFeatureFlag(name); !ok {
+		http.Error(w, "Unknown feature flag", http.StatusNotFound)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		if err := b.store.Delete(bucketFlags, name); err != nil {
+			log.Printf("Error deleting feature flag %s: %v", name, err)
+			http.Error(w, "Error deleting feature flag", http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Feature flag %s reset.", name)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var rule FlagRule
+	if err := json.NewDecoder(r.Body).Decode(&rule); err != nil {
+		http.Error(w, "Invalid feature flag rule: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if rule.Percentage < 0 || rule.Percentage > 100 {
+		http.Error(w, "Invalid feature flag rule: percentage must be between 0 and 100", http.StatusBadRequest)
+		return
+	}
+	if err := b.store.Put(bucketFlags, name, &rule); err != nil {
+		log.Printf("Error saving feature flag %s: %v", name, err)
+		http.Error(w, "Error saving feature flag", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Feature flag %s updated: %+v", name, rule)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func flagsRequest(t *testing.T, bot *Bot, method, url, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /flags", bot.handleFlags)
+	mux.HandleFunc("PUT /flags/{name}", bot.handleFlagUpdate)
+	mux.HandleFunc("DELETE /flags/{name}", bot.handleFlagUpdate)
+	req := httptest.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestParseFeatureFlags(t *testing.T) {
+	rules, err := parseFeatureFlags("streaming=off; auto_prd=acme/*,other/tools,12345,10%")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if streaming := rules[FlagStreaming]; streaming.matches(7, "acme/widgets") {
+		t.Errorf("streaming=off should match nothing: %+v", streaming)
+	}
+	auto := rules[FlagAutoPRD]
+	for _, tt := range []struct {
+		installation int64
+		repo         string
+		want         bool
+	}{
+		{7, "acme/widgets", true},
+		{7, "Other/Tools", true},
+		{12345, "someone/else", true},
+		{7, "someone/else", rolloutBucket("someone/else") < 10},
+	} {
+		if got := auto.matches(tt.installation, tt.repo); got != tt.want {
+			t.Errorf("matches(%d, %q) = %v, want %v", tt.installation, tt.repo, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"unknown=all", "auto_prd", "auto_prd=120%", "auto_prd=acme"} {
+		if _, err := parseFeatureFlags(bad); err == nil {
+			t.Errorf("parseFeatureFlags(%q) should fail", bad)
+		}
+	}
+}
+
+func TestAutoPRDFlagSkipsIssueOpened(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	env.bot.flagRules = map[string]FlagRule{FlagAutoPRD: {Repos: []string{"acme/other"}}}
+
+	env.deliver(t, "issues", "issues_opened.json")
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Fatalf("auto_prd is off for acme/widgets, but the model was called %d times", len(prompts))
+	}
+
+	// A stored rule overrides FEATURE_FLAGS.
+	if rec := flagsRequest(t, env.bot, http.MethodPut, "/flags/auto_prd", `{"installations":[7]}`); rec.Code != http.StatusNoContent {
+		t.Fatalf("PUT /flags/auto_prd = %d: %s", rec.Code, rec.Body.String())
+	}
+	if !env.bot.flagEnabled(FlagAutoPRD, 7, "acme/widgets") {
+		t.Error("the stored rule should enable auto_prd for installation 7")
+	}
+	if rec := flagsRequest(t, env.bot, http.MethodGet, "/flags", ""); !strings.Contains(rec.Body.String(), `"source":"store"`) {
+		t.Errorf("GET /flags = %s", rec.Body.String())
+	}
+	flagsRequest(t, env.bot, http.MethodDelete, "/flags/auto_prd", "")
+	if env.bot.flagEnabled(FlagAutoPRD, 7, "acme/widgets") {
+		t.Error("deleting the stored rule should restore the FEATURE_FLAGS rule")
+	}
+	if rec := flagsRequest(t, env.bot, http.MethodPut, "/flags/nope", `{}`); rec.Code != http.StatusNotFound {
+		t.Errorf("unknown flag returned %d", rec.Code)
+	}
+}
+
+func TestStreamingFlagOffWritesProgressOnce(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.flagRules = map[string]FlagRule{FlagStreaming: {}}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	env.github.mu.Lock()
+	edits := env.github.commentEdits
+	env.github.mu.Unlock()
+	if edits != 1 {
+		t.Errorf("expected a single progress edit with streaming off, got %d", edits)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[0].GetBody(); !strings.Contains(body, "- [x] Opened pull request #1") {
+		t.Errorf("progress comment should list every step:\n%s", body)
+	}
+}
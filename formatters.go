@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// Values of FormatConfig.Run.
+	formatRunOff  = "off"  // don't run formatters (default)
+	formatRunAuto = "auto" // run the formatters the repository configures
+)
+
+// golangciFiles are the golangci-lint configurations checked for goimports.
+var golangciFiles = []string{".golangci.yml", ".golangci.yaml", ".golangci.toml", ".golangci.json"}
+
+// prettierFiles are the Prettier configuration files, besides the
+// "prettier" key of package.json.
+var prettierFiles = []string{
+	".prettierrc", ".prettierrc.json", ".prettierrc.yml", ".prettierrc.yaml", ".prettierrc.json5",
+	".prettierrc.js", ".prettierrc.cjs", ".prettierrc.mjs", ".prettierrc.toml",
+	"prettier.config.js", "prettier.config.cjs", "prettier.config.mjs",
+}
+
+// prettierExtensions are the file extensions Prettier formats.
+var prettierExtensions = []string{
+	".js", ".jsx", ".mjs", ".cjs", ".ts", ".tsx", ".css", ".scss", ".less",
+	".json", ".md", ".yaml", ".yml", ".html", ".vue", ".graphql",
+}
+
+// FormatConfig makes implement_feature run the repository's formatters on
+// the files it edited before committing, so its pull requests pass the
+// formatting checks of CI.
+type FormatConfig struct {
+	// Run is "auto" to run the formatters detected from the repository's
+	// configuration, or "off" (default).
+	Run string `yaml:"run"`
+	// Commands are more formatters, run from the repository root with the
+	// edited files appended, e.g. "black" or "rustfmt --edition 2021".
+	Commands []string `yaml:"commands"`
+}
+
+func (c *FormatConfig) mode() string {
+	if c == nil || c.Run != formatRunAuto {
+		return formatRunOff
+	}
+	return c.Run
+}
+
+// formatter is a command that rewrites the files given to it in place.
+type formatter struct {
+	Name       string   // shown in the progress comment
+	Command    []string // the command line, before the files
+	Extensions []string // the extensions of the files it formats; nil for any
+}
+
+// files returns those of files the formatter handles.
+func (f formatter) files(files []string) []string {
+	if f.Extensions == nil {
+		return files
+	}
+	var matched []string
+	for _, name := range files {
+		if slices.Contains(f.Extensions, strings.ToLower(path.Ext(name))) {
+			matched = append(matched, name)
+		}
+	}
+	return matched
+}
+
+// runFormatters runs the formatters of the repository on those of files the
+// edit left in ws. A failing formatter is reported but leaves the change as
+// it is.
+func (b *Bot) runFormatters(ctx context.Context, client *github.Client, repo *github.Repository, ws workspace, files []string, progress *progressComment) {
+	cfg := b.repoConfig(ctx, client, repo).Format
+	if cfg.mode() == formatRunOff {
+		return
+	}
+	files = existingFiles(ws.dir(), files)
+	for _, f := range detectFormatters(ws.dir(), cfg) {
+		matched := f.files(files)
+		if len(matched) == 0 {
+			continue
+		}
+		args := append(slices.Clone(f.Command[1:]), matched...)
+		if out, err := b.runner(ws.dir(), f.Command[0], args...); err != nil {
+			log.Printf("Formatter %s failed in %s: %v: %s", f.Name, repo.GetFullName(), err, out)
+			progress.step("Formatted with %s: failed", f.Name)
+			continue
+		}
+		progress.step("Formatted `%s` with %s", strings.Join(matched, "`, `"), f.Name)
+	}
+}
+
+// detectFormatters returns the formatters cfg runs in the checkout at dir:
+// gofmt, or goimports when golangci-lint enables it, for Go modules, Prettier
+// when it is configured, and the configured commands.
+func detectFormatters(dir string, cfg *FormatConfig) []formatter {
+	var formatters []formatter
+	if fileExists(dir, "go.mod") {
+		gofmt := formatter{Name: "gofmt", Command: []string{"gofmt", "-w"}, Extensions: []string{".go"}}
+		for _, name := range golangciFiles {
+			if data, err := os.ReadFile(filepath.Join(dir, name)); err == nil && strings.Contains(string(data), "goimports") {
+				gofmt = formatter{Name: "goimports", Command: []string{"goimports", "-w"}, Extensions: []string{".go"}}
+				break
+			}
+		}
+		formatters = append(formatters, gofmt)
+	}
+	if usesPrettier(dir) {
+		formatters = append(formatters, formatter{Name: "Prettier", Command: []string{"npx", "--no-install", "prettier", "--write"}, Extensions: prettierExtensions})
+	}
+	for _, command := range cfg.Commands {
+		if fields := strings.Fields(command); len(fields) > 0 {
+			formatters = append(formatters, formatter{Name: fields[0], Command: fields})
+		}
+	}
+	return formatters
+}
+
+// usesPrettier reports whether the checkout at dir configures Prettier.
+func usesPrettier(dir string) bool {
+	if slices.ContainsFunc(prettierFiles, func(name string) bool { return fileExists(dir, name) }) {
+		return true
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return false
+	}
+	var pkg struct {
+		Prettier        json.RawMessage   `json:"prettier"`
+		DevDependencies map[string]string `json:"devDependencies"`
+	}
+	if json.Unmarshal(data, &pkg) != nil {
+		return false
+	}
+	_, dependency := pkg.DevDependencies["prettier"]
+	return pkg.Prettier != nil || dependency
+}
+
+// existingFiles returns those of files, relative to dir, that are regular
+// files inside it.
+func existingFiles(dir string, files []string) []string {
+	var existing []string
+	for _, name := range files {
+		name = filepath.ToSlash(filepath.Clean(name))
+		if !strings.HasPrefix(name, "..") && !path.IsAbs(name) && fileExists(dir, name) {
+			existing = append(existing, name)
+		}
+	}
+	return existing
+}
+
+// fileExists reports whether name, relative to dir, is a regular file.
+func fileExists(dir, name string) bool {
+	info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name)))
+	return err == nil && !info.IsDir()
+}
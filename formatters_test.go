@@ -0,0 +1,126 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// formatRunner makes the fake clone hold files, given by path and content.
+func formatRunner(t *testing.T, env *testEnv, files map[string]string) {
+	t.Helper()
+	env.runner.effects = map[string]func(string){
+		"git clone": func(dir string) {
+			for name, content := range files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		},
+	}
+}
+
+func TestImplementFeatureRunsFormatters(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "format:\n  run: auto\n  commands:\n    - black --quiet\n")
+	formatRunner(t, env, map[string]string{
+		"go.mod":        "module example.com/widgets\n",
+		".golangci.yml": "linters:\n  enable:\n    - goimports\n",
+		"report.go":     "package widgets\n",
+		"export/csv.go": "package export\n",
+	})
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	executed := env.runner.executed()
+	for _, want := range []string{
+		"goimports -w report.go export/csv.go",
+		"black --quiet report.go export/csv.go",
+	} {
+		if !slices.Contains(executed, want) {
+			t.Errorf("expected %q, ran:\n%s", want, strings.Join(executed, "\n"))
+		}
+	}
+	format := slices.Index(executed, "goimports -w report.go export/csv.go")
+	stage := slices.Index(executed, "git add .")
+	if format < 0 || stage < format {
+		t.Errorf("the files should be formatted before they are staged, ran:\n%s", strings.Join(executed, "\n"))
+	}
+	if len(env.github.pullRequests()) != 1 {
+		t.Fatal("the pull request should be opened")
+	}
+}
+
+func TestImplementFeatureKeepsChangeWhenFormatterFails(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "format:\n  run: auto\n")
+	formatRunner(t, env, map[string]string{"go.mod": "module example.com/widgets\n", "report.go": "package widgets\n"})
+	env.runner.failOn = "gofmt"
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if !slices.Contains(env.runner.executed(), "gofmt -w report.go") {
+		t.Errorf("gofmt should run on the edited file that exists, ran:\n%s", strings.Join(env.runner.executed(), "\n"))
+	}
+	if len(env.github.pullRequests()) != 1 {
+		t.Fatal("a failing formatter should not stop the pull request")
+	}
+}
+
+func TestImplementFeatureWithoutFormatters(t *testing.T) {
+	env := newTestEnv(t)
+	formatRunner(t, env, map[string]string{"go.mod": "module example.com/widgets\n", "report.go": "package widgets\n"})
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if slices.ContainsFunc(env.runner.executed(), func(c string) bool { return strings.HasPrefix(c, "gofmt") }) {
+		t.Errorf("formatters should be off by default, ran:\n%s", strings.Join(env.runner.executed(), "\n"))
+	}
+}
+
+func TestDetectFormatters(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{"go module", map[string]string{"go.mod": ""}, []string{"gofmt"}},
+		{"goimports", map[string]string{"go.mod": "", ".golangci.yaml": "linters-settings:\n  goimports:\n    local-prefixes: example.com\n"}, []string{"goimports"}},
+		{"prettierrc", map[string]string{".prettierrc": "{}"}, []string{"Prettier"}},
+		{"package.json key", map[string]string{"package.json": `{"prettier": {"semi": false}}`}, []string{"Prettier"}},
+		{"package.json dependency", map[string]string{"package.json": `{"devDependencies": {"prettier": "^3.0.0"}}`}, []string{"Prettier"}},
+		{"package.json without prettier", map[string]string{"package.json": `{"devDependencies": {"eslint": "^9.0.0"}}`}, nil},
+		{"nothing", nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var got []string
+			for _, f := range detectFormatters(dir, &FormatConfig{Run: formatRunAuto}) {
+				got = append(got, f.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("detectFormatters() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatterFiles(t *testing.T) {
+	prettier := formatter{Name: "Prettier", Extensions: prettierExtensions}
+	got := prettier.files([]string{"main.go", "web/App.TSX", "README.md"})
+	if want := []string{"web/App.TSX", "README.md"}; !slices.Equal(got, want) {
+		t.Errorf("files() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// maxArchiveBytes bounds the size of the repository archive downloaded by
+// the API commit backend.
+const maxArchiveBytes = 1 << 30
+
+var archiveClient = &http.Client{Transport: sharedTransport, Timeout: 10 * time.Minute}
+
+// apiWorkspace is a copy of the base branch downloaded as an archive and
+// published through the Git Data API, so neither git nor a token-bearing
+// clone URL is needed. The pristine copy in base is compared with the
+// edited one in work.
+type apiWorkspace struct {
+	ctx         context.Context
+	client      *github.Client
+	owner, repo string
+	headSHA     string // the base branch's commit
+	treeSHA     string // its tree
+	root        string
+	modes       map[string]string // path -> git file mode of the downloaded files
+	edits       []fileEdit        // computed by changes
+	computed    bool
+}
+
+// fileEdit is a file changed in the workspace.
+type fileEdit struct {
+	Path    string
+	Mode    string
+	Deleted bool
+	Content []byte
+}
+
+// openAPIWorkspace downloads the base branch of repo into dir: the whole
+// archive, or only files when given.
+func openAPIWorkspace(ctx context.Context, client *github.Client, repo *github.Repository, base, dir string, files []string) (*apiWorkspace, error) {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	ref, _, err := client.Git.GetRef(ctx, owner, name, "heads/"+base)
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading branch %s: %w", ErrCloneFailed, base, err)
+	}
+	head, _, err := client.Git.GetCommit(ctx, owner, name, ref.GetObject().GetSHA())
+	if err != nil {
+		return nil, fmt.Errorf("%w: reading commit %s: %w", ErrCloneFailed, ref.GetObject().GetSHA(), err)
+	}
+	w := &apiWorkspace{
+		ctx: ctx, client: client, owner: owner, repo: name,
+		headSHA: head.GetSHA(), treeSHA: head.GetTree().GetSHA(),
+		root: dir, modes: make(map[string]string),
+	}
+	download := w.download
+	if files != nil {
+		download = func() error { return w.downloadFiles(files) }
+	}
+	if err := download(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrCloneFailed, err)
+	}
+	return w, nil
+}
+
+func (w *apiWorkspace) dir() string          { return filepath.Join(w.root, "work") }
+func (w *apiWorkspace) baseDir() string      { return filepath.Join(w.root, "base") }
+func (w *apiWorkspace) close()               { os.RemoveAll(w.root) }
+func (w *apiWorkspace) mode(p string) string { return cmp.Or(w.modes[p], "100644") }
+
+// download extracts the tarball of the head commit into both base and work.
+func (w *apiWorkspace) download() error {
+	link, _, err := w.client.Repositories.GetArchiveLink(w.ctx, w.owner, w.repo, github.Tarball, &github.RepositoryContentGetOptions{Ref: w.headSHA}, 0)
+	if err != nil {
+		return fmt.Errorf("locating the archive: %w", err)
+	}
+	req, err := http.NewRequestWithContext(w.ctx, http.MethodGet, link.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := archiveClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("downloading the archive: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("downloading the archive: %s", resp.Status)
+	}
+	gz, err := gzip.NewReader(io.LimitReader(resp.Body, maxArchiveBytes))
+	if err != nil {
+		return fmt.Errorf("reading the archive: %w", err)
+	}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading the archive: %w", err)
+		}
+		// Entries are under a "<owner>-<repo>-<sha>/" directory.
+		_, rel, ok := strings.Cut(header.Name, "/")
+		rel = path.Clean(rel)
+		if !ok || rel == "." || !filepath.IsLocal(rel) || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(archive)
+		if err != nil {
+			return fmt.Errorf("reading %s from the archive: %w", rel, err)
+		}
+		mode := "100644"
+		if header.Mode&0o111 != 0 {
+			mode = "100755"
+		}
+		if err := w.writeFile(rel, mode, content); err != nil {
+			return err
+		}
+	}
+}
+
+// downloadFiles fetches only files from the head commit, through the Git
+// Data API, for repositories too large to download whole. The tree is read
+// one directory at a time along their paths. Files that don't exist yet are
+// skipped: the edit creates them.
+func (w *apiWorkspace) downloadFiles(files []string) error {
+	trees := make(map[string][]*github.TreeEntry) // directory -> entries
+	var list func(dir string) ([]*github.TreeEntry, error)
+	list = func(dir string) ([]*github.TreeEntry, error) {
+		if entries, ok := trees[dir]; ok {
+			return entries, nil
+		}
+		sha := w.treeSHA
+		if dir != "." {
+			parent, err := list(path.Dir(dir))
+			if err != nil {
+				return nil, err
+			}
+			i := slices.IndexFunc(parent, func(e *github.TreeEntry) bool { return e.GetPath() == path.Base(dir) && e.GetType() == "tree" })
+			if i < 0 {
+				trees[dir] = nil
+				return nil, nil
+			}
+			sha = parent[i].GetSHA()
+		}
+		tree, _, err := w.client.Git.GetTree(w.ctx, w.owner, w.repo, sha, false)
+		if err != nil {
+			return nil, fmt.Errorf("listing %s: %w", dir, err)
+		}
+		trees[dir] = tree.Entries
+		return tree.Entries, nil
+	}
+	for _, file := range files {
+		rel := path.Clean(file)
+		if !filepath.IsLocal(rel) {
+			continue
+		}
+		entries, err := list(path.Dir(rel))
+		if err != nil {
+			return err
+		}
+		i := slices.IndexFunc(entries, func(e *github.TreeEntry) bool { return e.GetPath() == path.Base(rel) })
+		// Symbolic links and submodules aren't downloaded, like in archives.
+		if i < 0 || entries[i].GetType() != "blob" || entries[i].GetMode() == "120000" {
+			continue
+		}
+		content, _, err := w.client.Git.GetBlobRaw(w.ctx, w.owner, w.repo, entries[i].GetSHA())
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", rel, err)
+		}
+		if err := w.writeFile(rel, entries[i].GetMode(), content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes a downloaded file with its git mode into both base and
+// work.
+func (w *apiWorkspace) writeFile(rel, mode string, content []byte) error {
+	perm := os.FileMode(0o644)
+	if mode == "100755" {
+		perm = 0o755
+	}
+	w.modes[rel] = mode
+	for _, root := range []string{w.baseDir(), w.dir()} {
+		target := filepath.Join(root, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(target, content, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *apiWorkspace) branchExists(branch string) bool {
+	_, _, err := w.client.Git.GetRef(w.ctx, w.owner, w.repo, "heads/"+branch)
+	return err == nil
+}
+
+// regularFiles lists the regular files under root as slash-separated paths.
+func regularFiles(root string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if d.Type().IsRegular() {
+			rel, err := filepath.Rel(root, p)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = true
+		}
+		return nil
+	})
+	return files, err
+}
+
+func (w *apiWorkspace) changes() (string, []fileStat, error) {
+	if !w.computed {
+		if err := w.computeEdits(); err != nil {
+			return "", nil, err
+		}
+	}
+	var diff strings.Builder
+	var stats []fileStat
+	for _, e := range w.edits {
+		before, _ := os.ReadFile(filepath.Join(w.baseDir(), filepath.FromSlash(e.Path)))
+		added, deleted := lineChanges(before, e.Content)
+		if isBinary(before) || isBinary(e.Content) {
+			added, deleted = nil, nil
+		}
+		stats = append(stats, fileStat{Path: e.Path, Added: len(added), Deleted: len(deleted)})
+		fmt.Fprintf(&diff, "--- a/%s\n+++ b/%s\n", e.Path, e.Path)
+		for _, line := range deleted {
+			diff.WriteString("-" + line + "\n")
+		}
+		for _, line := range added {
+			diff.WriteString("+" + line + "\n")
+		}
+	}
+	return diff.String(), stats, nil
+}
+
+// computeEdits compares the work copy with the pristine one.
+func (w *apiWorkspace) computeEdits() error {
+	before, err := regularFiles(w.baseDir())
+	if err != nil {
+		return err
+	}
+	after, err := regularFiles(w.dir())
+	if err != nil {
+		return err
+	}
+	var paths []string
+	for p := range after {
+		paths = append(paths, p)
+	}
+	for p := range before {
+		if !after[p] {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	for _, p := range paths {
+		if !after[p] {
+			w.edits = append(w.edits, fileEdit{Path: p, Mode: w.mode(p), Deleted: true})
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(w.dir(), filepath.FromSlash(p)))
+		if err != nil {
+			return err
+		}
+		if before[p] {
+			original, err := os.ReadFile(filepath.Join(w.baseDir(), filepath.FromSlash(p)))
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(original, content) {
+				continue
+			}
+		}
+		w.edits = append(w.edits, fileEdit{Path: p, Mode: w.mode(p), Content: content})
+	}
+	w.computed = true
+	return nil
+}
+
+// lineChanges returns the lines of after missing from before and the lines
+// of before missing from after, counting repeated lines.
+func lineChanges(before, after []byte) (added, deleted []string) {
+	split := func(b []byte) []string {
+		if len(b) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+	remaining := make(map[string]int)
+	for _, line := range split(before) {
+		remaining[line]++
+	}
+	for _, line := range split(after) {
+		if remaining[line] > 0 {
+			remaining[line]--
+			continue
+		}
+		added = append(added, line)
+	}
+	for _, line := range split(before) {
+		if remaining[line] > 0 {
+			remaining[line]--
+			deleted = append(deleted, line)
+		}
+	}
+	return added, deleted
+}
+
+func isBinary(content []byte) bool {
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+func (w *apiWorkspace) publish(branch, message, lease string) (string, error) {
+	return w.commitFiles(branch, message, nil, lease)
+}
+
+func (w *apiWorkspace) publishFiles(branch, message string, files []string) error {
+	_, err := w.commitFiles(branch, message, files, "")
+	return err
+}
+
+// commitFiles creates a commit on top of the base branch holding the edits
+// of files, or every edit when files is nil, and points branch at it. It
+// creates branch without a lease, and otherwise moves it only from the
+// lease. It returns the commit's SHA.
+func (w *apiWorkspace) commitFiles(branch, message string, files []string, lease string) (string, error) {
+	if !w.computed {
+		if err := w.computeEdits(); err != nil {
+			return "", err
+		}
+	}
+	var entries []*github.TreeEntry
+	for _, e := range w.edits {
+		if files != nil && !slices.Contains(files, e.Path) {
+			continue
+		}
+		entry := &github.TreeEntry{Path: github.String(e.Path), Mode: github.String(e.Mode), Type: github.String("blob")}
+		if !e.Deleted {
+			blob, _, err := w.client.Git.CreateBlob(w.ctx, w.owner, w.repo, &github.Blob{
+				Content:  github.String(base64.StdEncoding.EncodeToString(e.Content)),
+				Encoding: github.String("base64"),
+			})
+			if err != nil {
+				return "", githubError(ErrPushFailed, fmt.Errorf("uploading %s: %w", e.Path, err))
+			}
+			entry.SHA = blob.SHA
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) == 0 {
+		return "", fmt.Errorf("%w: there are no changes to commit", ErrNoChanges)
+	}
+	tree, _, err := w.client.Git.CreateTree(w.ctx, w.owner, w.repo, w.treeSHA, entries)
+	if err != nil {
+		return "", githubError(ErrPushFailed, fmt.Errorf("creating the tree: %w", err))
+	}
+	// Without an author, GitHub attributes and signs the commit as the app.
+	commit, _, err := w.client.Git.CreateCommit(w.ctx, w.owner, w.repo, &github.Commit{
+		Message: github.String(message),
+		Tree:    &github.Tree{SHA: tree.SHA},
+		Parents: []*github.Commit{{SHA: github.String(w.headSHA)}},
+	}, nil)
+	if err != nil {
+		return "", githubError(ErrPushFailed, fmt.Errorf("creating the commit: %w", err))
+	}
+	ref := &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: commit.SHA},
+	}
+	if lease != "" {
+		// The new commit replaces the branch's, so the update is forced;
+		// checking the lease first keeps commits pushed since.
+		current, _, err := w.client.Git.GetRef(w.ctx, w.owner, w.repo, "heads/"+branch)
+		if err != nil {
+			return "", githubError(ErrPushFailed, fmt.Errorf("reading branch %s: %w", branch, err))
+		}
+		if current.GetObject().GetSHA() != lease {
+			return "", fmt.Errorf("%w: %s is at %s, not %s", ErrBranchChanged, branch, current.GetObject().GetSHA(), lease)
+		}
+		if _, _, err := w.client.Git.UpdateRef(w.ctx, w.owner, w.repo, ref, true); err != nil {
+			return "", githubError(ErrPushFailed, fmt.Errorf("updating branch %s: %w", branch, err))
+		}
+		return commit.GetSHA(), nil
+	}
+	if _, _, err := w.client.Git.CreateRef(w.ctx, w.owner, w.repo, ref); err != nil {
+		return "", githubError(ErrPushFailed, fmt.Errorf("creating branch %s: %w", branch, err))
+	}
+	return commit.GetSHA(), nil
+}
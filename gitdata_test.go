@@ -0,0 +1,197 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLineChanges(t *testing.T) {
+	added, deleted := lineChanges([]byte("a\nb\nb\nc\n"), []byte("a\nb\nd\nc\nc\n"))
+	if !slices.Equal(added, []string{"d", "c"}) || !slices.Equal(deleted, []string{"b"}) {
+		t.Errorf("lineChanges = +%q -%q", added, deleted)
+	}
+	if added, deleted := lineChanges(nil, []byte("x\n")); len(added) != 1 || len(deleted) != 0 {
+		t.Errorf("new file: +%q -%q", added, deleted)
+	}
+}
+
+// editWith makes the fake Gemini CLI write files in its working directory,
+// deleting the ones mapped to "".
+func editWith(env *testEnv, files map[string]string) {
+	env.runner.effects = map[string]func(string){"gemini": func(dir string) {
+		for name, content := range files {
+			target := filepath.Join(dir, filepath.FromSlash(name))
+			if content == "" {
+				os.Remove(target)
+				continue
+			}
+			os.MkdirAll(filepath.Dir(target), 0o755)
+			os.WriteFile(target, []byte(content), 0o644)
+		}
+	}}
+}
+
+func TestImplementFeatureCommitsThroughAPI(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.commitBackend = commitBackendAPI
+	env.github.addFile("acme", "widgets", "report.go", "package report\n\nfunc Report() {}\n")
+	env.github.addFile("acme", "widgets", "legacy.go", "package report\n")
+	env.github.addFile("acme", "widgets", "scripts/build.sh", "#!/bin/sh\ngo build\n")
+	editWith(env, map[string]string{
+		"report.go":        "package report\n\nfunc Report() { exportCSV() }\n",
+		"export/csv.go":    "package report\n\nvar token = os.Getenv(\"EXPORT_TOKEN\")\n",
+		"legacy.go":        "",
+		"scripts/build.sh": "#!/bin/sh\ngo build ./...\n",
+	})
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	for _, c := range env.runner.executed() {
+		if strings.HasPrefix(c, "git ") {
+			t.Errorf("the API backend should not run git, ran %q", c)
+		}
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(pulls))
+	}
+	branch := pulls[0].GetHead().GetRef()
+	if !strings.HasPrefix(branch, "feature/issue-42-") {
+		t.Errorf("unexpected branch %q", branch)
+	}
+	if !strings.Contains(pulls[0].GetBody(), "EXPORT_TOKEN") {
+		t.Errorf("the configuration checklist should come from the API diff:\n%s", pulls[0].GetBody())
+	}
+
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	if len(env.github.gitCommits) != 1 || len(env.github.trees) != 1 {
+		t.Fatalf("expected 1 commit and 1 tree, got %d and %d", len(env.github.gitCommits), len(env.github.trees))
+	}
+	commit := env.github.gitCommits[0]
+	if !strings.HasPrefix(commit.GetMessage(), "feat: Implement feature for #42") || commit.Parents[0].GetSHA() != testHeadSHA {
+		t.Errorf("unexpected commit %q on %v", commit.GetMessage(), commit.Parents)
+	}
+	if env.github.branches["acme/widgets@"+branch] != commit.GetSHA() {
+		t.Errorf("branch %s should point at the new commit, got %v", branch, env.github.branches)
+	}
+	tree := env.github.trees[0]
+	if tree.GetSHA() != "tree-"+testHeadSHA {
+		t.Errorf("the tree should be based on the head's tree, got %q", tree.GetSHA())
+	}
+	entries := make(map[string]string)
+	for _, e := range tree.Entries {
+		content := "<deleted>"
+		if e.SHA != nil {
+			content = env.github.blobs[e.GetSHA()]
+		}
+		entries[e.GetPath()+" "+e.GetMode()] = content
+	}
+	want := map[string]string{
+		"export/csv.go 100644":    "package report\n\nvar token = os.Getenv(\"EXPORT_TOKEN\")\n",
+		"legacy.go 100644":        "<deleted>",
+		"report.go 100644":        "package report\n\nfunc Report() { exportCSV() }\n",
+		"scripts/build.sh 100755": "#!/bin/sh\ngo build ./...\n",
+	}
+	if len(entries) != len(want) {
+		t.Errorf("tree entries = %v, want %v", entries, want)
+	}
+	for k, v := range want {
+		if entries[k] != v {
+			t.Errorf("tree entry %s = %q, want %q", k, entries[k], v)
+		}
+	}
+}
+
+func TestImplementFeatureSplitsThroughAPI(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.commitBackend = commitBackendAPI
+	env.github.addFile("acme", "widgets", RepoConfigPath, "pr_size:\n  max_files: 1\n  on_exceed: split\n")
+	editWith(env, map[string]string{"report.go": "package report\n", "export/csv.go": "package export\n"})
+	env.gemini.on("Split the following change", `{"groups": [
+		{"title": "Add the encoder", "summary": "Encodes reports.", "files": ["export/csv.go"]},
+		{"title": "Use it", "summary": "Exports reports.", "files": ["report.go"]}
+	]}`)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 2 {
+		t.Fatalf("expected 2 pull requests, got %d", len(pulls))
+	}
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	for i, want := range []string{"export/csv.go", "report.go"} {
+		if entries := env.github.trees[i].Entries; len(entries) != 1 || entries[0].GetPath() != want {
+			t.Errorf("part %d should only hold %s, got %v", i+1, want, entries)
+		}
+		if !strings.Contains(env.github.gitCommits[i].GetMessage(), "Part ") {
+			t.Errorf("unexpected part commit message %q", env.github.gitCommits[i].GetMessage())
+		}
+	}
+}
+
+func TestImplementFeatureAPIBackendAvoidsExistingBranches(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.commitBackend = commitBackendAPI
+	env.github.addFile("acme", "widgets", RepoConfigPath, "naming:\n  branch: \"issue-{{issue}}\"\n")
+	env.github.branches["acme/widgets@issue-42"] = testHeadSHA
+	editWith(env, map[string]string{"report.go": "package report\n"})
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || !strings.HasPrefix(pulls[0].GetHead().GetRef(), "issue-42-") {
+		t.Fatalf("expected a pull request from a suffixed branch, got %v", pulls)
+	}
+}
+
+func TestImplementFeatureFetchesOnlyTargetFiles(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  checkout: files\n")
+	env.github.addFile("acme", "widgets", "report.go", "package report\n\nfunc Report() {}\n")
+	env.github.addFile("acme", "widgets", "export/json.go", "package export\n")
+	env.github.addFile("acme", "widgets", "go.mod", "module example.com/widgets\n")
+	editWith(env, map[string]string{
+		"report.go":     "package report\n\nfunc Report() { exportCSV() }\n",
+		"export/csv.go": "package export\n",
+	})
+	edit := env.runner.effects["gemini"]
+	var seen []string
+	env.runner.effects["gemini"] = func(dir string) {
+		files, _ := regularFiles(dir)
+		for f := range files {
+			seen = append(seen, f)
+		}
+		edit(dir)
+	}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if !slices.Equal(seen, []string{"report.go"}) {
+		t.Errorf("the edit should only see the target files that exist, got %v", seen)
+	}
+	for _, c := range env.runner.executed() {
+		if strings.HasPrefix(c, "git ") {
+			t.Errorf("a files-only checkout should not run git, ran %q", c)
+		}
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || !strings.Contains(pulls[0].GetBody(), "Only the files to change were fetched") {
+		t.Fatalf("expected a pull request noting the partial checkout, got %+v", pulls)
+	}
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	if !slices.Equal(env.github.blobReads, []string{"report.go"}) {
+		t.Errorf("only report.go should be downloaded, got %v", env.github.blobReads)
+	}
+	var paths []string
+	for _, e := range env.github.trees[0].Entries {
+		paths = append(paths, e.GetPath())
+	}
+	if !slices.Equal(paths, []string{"export/csv.go", "report.go"}) {
+		t.Errorf("the commit should hold the edited files, got %v", paths)
+	}
+}
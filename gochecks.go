@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// GoChecksIdentifier heads the result of the Go checks run before a pull
+	// request is opened.
+	GoChecksIdentifier = "### Go Checks"
+)
+
+// GoChecksConfig makes implement_feature build and vet Go modules after the
+// edit. Failures go back to the model within the fix loop's budget.
+type GoChecksConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Staticcheck also runs staticcheck on the changed packages.
+	Staticcheck bool `yaml:"staticcheck"`
+}
+
+func (c *GoChecksConfig) enabled() bool { return c != nil && c.Enabled }
+
+// goCheck is a check run on the changed packages.
+type goCheck struct {
+	Name   string // shown in the progress comment and pull request
+	Passed bool
+	Output string // kept on failure
+}
+
+// goChecksRun is the outcome of the Go checks of a change.
+type goChecksRun struct {
+	Packages []string // the changed packages, as relative paths
+	Checks   []goCheck
+}
+
+func (r *goChecksRun) passed() bool {
+	return !slices.ContainsFunc(r.Checks, func(c goCheck) bool { return !c.Passed })
+}
+
+// failures returns the output of the failed checks, for the model to fix.
+func (r *goChecksRun) failures() string {
+	var out []string
+	for _, c := range r.Checks {
+		if !c.Passed {
+			out = append(out, fmt.Sprintf("$ %s\n%s", c.Name, strings.TrimSpace(c.Output)))
+		}
+	}
+	failures := strings.Join(out, "\n\n")
+	// Compilers report the first errors first, so the head is kept.
+	if len(failures) > maxTestOutput {
+		failures = failures[:maxTestOutput] + "\n[output truncated]"
+	}
+	return failures
+}
+
+// runGoChecks builds and vets the Go change in ws. It reports nothing when
+// the checks aren't configured or no Go package changed.
+func (b *Bot) runGoChecks(ctx context.Context, client *github.Client, repo *github.Repository, ws workspace, progress *progressComment) checkResult {
+	cfg := b.repoConfig(ctx, client, repo).GoChecks
+	if !cfg.enabled() || !fileExists(ws.dir(), "go.mod") {
+		return checkResult{}
+	}
+	_, stats, err := ws.changes()
+	if err != nil {
+		log.Printf("Could not list the changes to check in %s: %v", repo.GetFullName(), err)
+		return checkResult{}
+	}
+	run := &goChecksRun{Packages: changedGoPackages(ws.dir(), stats)}
+	if len(run.Packages) == 0 {
+		return checkResult{}
+	}
+	run.Checks = b.checkGoPackages(ws.dir(), run.Packages, cfg)
+	if run.passed() {
+		progress.step("Checked %s: passed", run.scope())
+		return checkResult{Section: run.format()}
+	}
+	progress.step("Checked %s: failed", run.scope())
+	return checkResult{Section: run.format(), Failures: run.failures()}
+}
+
+// changedGoPackages returns the directories, relative to dir and prefixed
+// with "./", of the packages whose Go files stats changed and that still
+// exist.
+func changedGoPackages(dir string, stats []fileStat) []string {
+	var pkgs []string
+	for _, s := range stats {
+		if path.Ext(s.Path) != ".go" {
+			continue
+		}
+		pkg := "./" + path.Dir(s.Path)
+		if pkg == "./." {
+			pkg = "."
+		}
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(pkg))); err != nil || !info.IsDir() || slices.Contains(pkgs, pkg) {
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	slices.Sort(pkgs)
+	return pkgs
+}
+
+// checkGoPackages builds the module in dir, and vets the packages, with
+// staticcheck too when cfg asks for it.
+func (b *Bot) checkGoPackages(dir string, pkgs []string, cfg *GoChecksConfig) []goCheck {
+	commands := [][]string{{"go", "build", "./..."}, append([]string{"go", "vet"}, pkgs...)}
+	if cfg.Staticcheck {
+		commands = append(commands, append([]string{"staticcheck"}, pkgs...))
+	}
+	checks := make([]goCheck, len(commands))
+	for i, command := range commands {
+		out, err := b.runner(dir, command[0], command[1:]...)
+		checks[i] = goCheck{Name: strings.Join(command[:2], " "), Passed: err == nil}
+		if command[0] == "staticcheck" {
+			checks[i].Name = "staticcheck"
+		}
+		if err != nil {
+			checks[i].Output = out
+		}
+	}
+	return checks
+}
+
+// scope describes the checked packages for the progress comment.
+func (r *goChecksRun) scope() string {
+	return fmt.Sprintf("`%s`", strings.Join(r.Packages, "`, `"))
+}
+
+// names lists the checks run, e.g. "`go build`, `go vet` and `staticcheck`".
+func (r *goChecksRun) names() string {
+	names := make([]string, len(r.Checks))
+	for i, c := range r.Checks {
+		names[i] = "`" + c.Name + "`"
+	}
+	if len(names) < 2 {
+		return strings.Join(names, "")
+	}
+	return strings.Join(names[:len(names)-1], ", ") + " and " + names[len(names)-1]
+}
+
+// format renders the checks as a pull request body section.
+func (r *goChecksRun) format() string {
+	if r.passed() {
+		return fmt.Sprintf("%s\n\n**Passed:** %s succeed on %s.", GoChecksIdentifier, r.names(), r.scope())
+	}
+	var failed []string
+	for _, c := range r.Checks {
+		if !c.Passed {
+			failed = append(failed, "`"+c.Name+"`")
+		}
+	}
+	return fmt.Sprintf("%s\n\n> [!WARNING]\n> %s failed on %s. Fix the errors before merging.\n\n<details>\n<summary>Check output</summary>\n\n```\n%s\n```\n</details>",
+		GoChecksIdentifier, strings.Join(failed, ", "), r.scope(), r.failures())
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGoChecksFeedErrorsBackToTheModel(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "go_checks:\n  enabled: true\n")
+	containerRunner(t, env, "go.mod", "export/csv.go", "report.go")
+	edits := 0
+	env.runner.effects["gemini"] = func(string) {
+		// The second edit fixes the error of the first.
+		if edits++; edits == 2 {
+			env.runner.failOn = ""
+		}
+	}
+	// The fix prompt quotes "$ go vet", which mustn't fail the edit.
+	env.runner.failOn = "go vet ."
+	env.runner.outputs = map[string]string{
+		"--numstat": "4\t0\texport/csv.go\n1\t0\treport.go\n1\t0\tREADME.md\n",
+		"go vet .":  "export/csv.go:12:2: fmt.Sprintf format %d has arg name of wrong type string",
+	}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	executed := env.runner.executed()
+	var geminiRuns []string
+	for _, line := range executed {
+		if strings.HasPrefix(line, "gemini ") {
+			geminiRuns = append(geminiRuns, line)
+		}
+	}
+	if len(geminiRuns) != 2 || !strings.Contains(geminiRuns[1], "the change fails these checks") || !strings.Contains(geminiRuns[1], "$ go vet\nexport/csv.go:12:2: fmt.Sprintf format %d") {
+		t.Fatalf("the vet error should go back to the model, ran:\n%s", strings.Join(geminiRuns, "\n"))
+	}
+	if !slices.Contains(executed, "go build ./...") || !slices.Contains(executed, "go vet . ./export") || slices.ContainsFunc(executed, func(c string) bool { return strings.HasPrefix(c, "staticcheck") }) {
+		t.Errorf("expected build and vet of the changed packages, ran:\n%s", strings.Join(executed, "\n"))
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || !strings.Contains(pulls[0].GetBody(), GoChecksIdentifier+"\n\n**Passed:** `go build` and `go vet` succeed on `.`, `./export`.\n\n_The checks passed after 1 fix iteration(s)._") {
+		t.Fatalf("the pull request should report the checks: %v", pulls)
+	}
+}
+
+func TestGoChecksReportFailuresWithoutFixes(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "go_checks:\n  enabled: true\n  staticcheck: true\nfix_loop:\n  max_iterations: 0\n")
+	containerRunner(t, env, "go.mod", "export/csv.go")
+	env.runner.failOn = "staticcheck"
+	env.runner.outputs = map[string]string{
+		"--numstat":   "4\t0\texport/csv.go\n",
+		"staticcheck": "export/csv.go:3:2: should use strings.Builder (SA6004)",
+	}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if executed := env.runner.executed(); !slices.Contains(executed, "staticcheck ./export") {
+		t.Errorf("staticcheck should check the changed package, ran:\n%s", strings.Join(executed, "\n"))
+	}
+	body := env.github.pullRequests()[0].GetBody()
+	if !strings.Contains(body, "> [!WARNING]\n> `staticcheck` failed on `./export`. Fix the errors before merging.") || !strings.Contains(body, "$ staticcheck\nexport/csv.go:3:2: should use strings.Builder") {
+		t.Errorf("the pull request should report the failed check:\n%s", body)
+	}
+}
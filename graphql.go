@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// graphQL runs a GitHub GraphQL query with the client's credentials and
+// decodes the "data" member of the response into out.
+func graphQL(ctx context.Context, client *github.Client, query string, variables map[string]any, out any) error {
+	req, err := client.NewRequest("POST", "graphql", map[string]any{"query": query, "variables": variables})
+	if err != nil {
+		return err
+	}
+	var resp struct {
+		Data   any `json:"data"`
+		Errors []struct {
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	resp.Data = out
+	if _, err := client.Do(ctx, req, &resp); err != nil {
+		return err
+	}
+	if len(resp.Errors) > 0 {
+		messages := make([]string, len(resp.Errors))
+		for i, e := range resp.Errors {
+			messages[i] = e.Message
+		}
+		return fmt.Errorf("graphql: %s", strings.Join(messages, "; "))
+	}
+	return nil
+}
+
+// minimizeComment hides the comment with the given GraphQL node ID as
+// outdated. Anyone can still show it again.
+func minimizeComment(ctx context.Context, client *github.Client, commentNodeID string) error {
+	const mutation = `mutation($subjectId: ID!) { minimizeComment(input: {subjectId: $subjectId, classifier: OUTDATED}) { minimizedComment { isMinimized } } }`
+	return graphQL(ctx, client, mutation, map[string]any{"subjectId": commentNodeID}, nil)
+}
+
+// pinIssue pins the issue with the given GraphQL node ID to its repository.
+func pinIssue(ctx context.Context, client *github.Client, issueNodeID string) error {
+	const mutation = `mutation($issueId: ID!) { pinIssue(input: {issueId: $issueId}) { issue { id } } }`
+	return graphQL(ctx, client, mutation, map[string]any{"issueId": issueNodeID}, nil)
+}
+
+// addDiscussionComment comments body on discussion number of owner/repo and
+// returns the comment's URL.
+func addDiscussionComment(ctx context.Context, client *github.Client, owner, repo string, number int, body string) (string, error) {
+	const query = `query($owner: String!, $name: String!, $number: Int!) { repository(owner: $owner, name: $name) { discussion(number: $number) { id } } }`
+	var found struct {
+		Repository struct {
+			Discussion struct {
+				ID string `json:"id"`
+			} `json:"discussion"`
+		} `json:"repository"`
+	}
+	if err := graphQL(ctx, client, query, map[string]any{"owner": owner, "name": repo, "number": number}, &found); err != nil {
+		return "", err
+	}
+	if found.Repository.Discussion.ID == "" {
+		return "", fmt.Errorf("discussion #%d of %s/%s not found", number, owner, repo)
+	}
+	const mutation = `mutation($discussionId: ID!, $body: String!) { addDiscussionComment(input: {discussionId: $discussionId, body: $body}) { comment { url } } }`
+	var added struct {
+		AddDiscussionComment struct {
+			Comment struct {
+				URL string `json:"url"`
+			} `json:"comment"`
+		} `json:"addDiscussionComment"`
+	}
+	if err := graphQL(ctx, client, mutation, map[string]any{"discussionId": found.Repository.Discussion.ID, "body": body}, &added); err != nil {
+		return "", err
+	}
+	return added.AddDiscussionComment.Comment.URL, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// I18nPlanIdentifier marks comments produced by the need_i18n_plan command.
+	I18nPlanIdentifier = "### Internationalization Plan"
+
+	ArtifactI18nPlan = "i18n_plan"
+)
+
+// processI18nPlan posts an internationalization impact checklist for the
+// feature, based on its PRD and, when files are given with `--files` or the
+// issue's `Files:` line, on the code the feature touches.
+func (b *Bot) processI18nPlan(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandI18nPlan, issueNum, repoOwner, repoName)
+
+	version, args := prdVersionArg(args)
+	prdComment, ok := b.findPRDVersion(ctx, client, repoOwner, repoName, issueNum, version)
+	if !ok {
+		return
+	}
+	if prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting i18n plan.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to plan internationalization for. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+
+	patterns := parseFilesFlag(args)
+	if len(patterns) == 0 {
+		patterns = parseFilePathsFromIssue(issue.GetBody())
+	}
+	var files []repoFile
+	var skipped []string
+	ref := ""
+	if len(patterns) > 0 {
+		ref = b.headCommit(ctx, client, repo)
+		paths, err := matchRepoFiles(ctx, client, repoOwner, repoName, ref, patterns)
+		if err != nil {
+			log.Printf("Error listing files of %s/%s, planning from the PRD only: %v", repoOwner, repoName, err)
+		} else {
+			files, skipped = fetchFiles(ctx, client, repoOwner, repoName, ref, paths)
+		}
+	}
+
+	plan, err := generateI18nPlan(ctx, b.llm, prdComment.GetBody(), files)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "plan internationalization", "Could not generate the internationalization plan", err)
+		return
+	}
+
+	var body strings.Builder
+	body.WriteString(I18nPlanIdentifier + "\n\n")
+	if len(files) > 0 {
+		plan = renderCitations(plan, b.repoWebURL(repo), ref, files)
+		fmt.Fprintf(&body, "**Code reviewed:** `%s`\n\n", strings.Join(fileNames(files), "`, `"))
+	} else {
+		body.WriteString("_Based on the PRD only. List the affected files with `--files` or a `Files:` line in the issue to include the code._\n\n")
+	}
+	body.WriteString(plan)
+	if len(skipped) > 0 {
+		fmt.Fprintf(&body, "\n\n_Skipped (limit of %d files / %d KB reached or unreadable): `%s`_", maxExplainFiles, maxExplainBytes/1024, strings.Join(skipped, "`, `"))
+	}
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactI18nPlan, body.String())
+	b.saveArtifact(ArtifactI18nPlan, repoOwner, repoName, issue, body.String(), comment)
+}
+
+func generateI18nPlan(ctx context.Context, llm Generator, prd string, files []repoFile) (string, error) {
+	var code strings.Builder
+	if len(files) > 0 {
+		code.WriteString("**Source Files** (each line is prefixed with its line number):\n")
+		for _, f := range files {
+			fmt.Fprintf(&code, "--- %s ---\n%s\n", f.Path, numberLines(f.Content))
+		}
+		code.WriteString(citationInstructions)
+	}
+	prompt := fmt.Sprintf(
+		"Analyze the impact of the feature described in the following Product Requirements Document (PRD) on localization.\n\n"+
+			"Format the output as GitHub-flavored Markdown checklists (`- [ ] ...`) under these headings:\n"+
+			"1.  **Strings to Externalize:** (User-facing text, messages and labels that must move to translation resources)\n"+
+			"2.  **Locale-Sensitive Formats:** (Dates, times, time zones, numbers, currencies, units, pluralization, sorting and collation)\n"+
+			"3.  **Right-to-Left (RTL) Considerations:** (Layout mirroring, bidirectional text, icons and alignment)\n"+
+			"4.  **Other Considerations:** (Encoding, fonts, text expansion, input methods and translation workflow)\n\n"+
+			"Write \"None identified.\" under a heading when nothing applies.\n\n"+
+			"**Here is the PRD:**\n%s\n\n%s",
+		prd, code.String(),
+	)
+	plan, err := generateMarkdown(withSystemPrompt(ctx, CommandI18nPlan), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate i18n plan: %w", err)
+	}
+	return plan, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestI18nPlanUsesPRDAndIssueFiles(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+"\n\nUsers export reports as CSV.")
+	env.github.addFile("acme", "widgets", "report.go", "package widgets\n\nconst header = \"Date,Amount\"\n")
+	env.gemini.on("Analyze the impact of the feature", "**Strings to Externalize:**\n- [ ] CSV header [[cite:report.go#L3]]")
+
+	env.comment(t, "@prd-bot need_i18n_plan")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "Users export reports as CSV.") || !strings.Contains(prompt, "3: const header") {
+		t.Errorf("prompt should include the PRD and the numbered issue files:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	if !strings.HasPrefix(body, I18nPlanIdentifier) || !strings.Contains(body, "**Code reviewed:** `report.go`") || !strings.Contains(body, "/blob/"+testHeadSHA+"/report.go#L3") {
+		t.Errorf("unexpected i18n plan comment:\n%s", body)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactI18nPlan); artifact == nil || artifact.Markdown != stripArtifactMeta(body) {
+		t.Errorf("i18n plan artifact = %+v", artifact)
+	}
+}
+
+func TestI18nPlanRequiresPRD(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.comment(t, "@prd-bot need_i18n_plan")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "need_prd") {
+		t.Errorf("expected a request to generate the PRD first, got %+v", comments)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// rejectedCommandsTotal counts the commands dropped because their webhook
+// looked spoofed or came from a bot that isn't allowed to run commands.
+var rejectedCommandsTotal = newCounterVec("agent_prd_rejected_commands_total", "Commands rejected because of their sender or installation, by reason.", "reason")
+
+// installationResolver is implemented by client factories that can look up
+// which installation of the app covers a repository.
+type installationResolver interface {
+	RepoInstallation(ctx context.Context, owner, repo string) (int64, error)
+}
+
+// verifiedInstallations caches the installations confirmed to cover a
+// repository, so only the first command in a while costs an API call.
+type verifiedInstallations struct {
+	mu      sync.Mutex
+	expires map[string]time.Time // "owner/repo@installation" -> expiry
+}
+
+func (v *verifiedInstallations) known(key string, now time.Time) bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return now.Before(v.expires[key])
+}
+
+func (v *verifiedInstallations) add(key string, now time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.expires == nil {
+		v.expires = make(map[string]time.Time)
+	}
+	for k, expiry := range v.expires {
+		if !now.Before(expiry) {
+			delete(v.expires, k)
+		}
+	}
+	v.expires[key] = now.Add(installationClientTTL)
+}
+
+// installationMatches reports whether installationID, as claimed by a
+// comment webhook or configured in POLL_REPOS, is the app's installation on
+// repo. A payload naming another installation is spoofed or was replayed
+// across installations, and acting on it would run the command with the
+// wrong account's access. Lookup failures other than "not installed" are
+// logged and let through, so a GitHub outage doesn't stop every command.
+func (b *Bot) installationMatches(ctx context.Context, repo *github.Repository, installationID int64) bool {
+	resolver, ok := b.clients.(installationResolver)
+	if !ok {
+		return true // personal access tokens have no installations
+	}
+	key := fmt.Sprintf("%s@%d", repo.GetFullName(), installationID)
+	now := time.Now()
+	if b.installs.known(key, now) {
+		return true
+	}
+	actual, err := resolver.RepoInstallation(ctx, repo.GetOwner().GetLogin(), repo.GetName())
+	var errResp *github.ErrorResponse
+	switch {
+	case errors.As(err, &errResp) && errResp.Response != nil && errResp.Response.StatusCode == http.StatusNotFound:
+		log.Printf("The app isn't installed on %s, but a comment webhook claims installation %d.", repo.GetFullName(), installationID)
+		return false
+	case err != nil:
+		log.Printf("Could not look up the installation of %s, trusting the webhook's %d: %v", repo.GetFullName(), installationID, err)
+		return true
+	case actual != installationID:
+		log.Printf("A comment webhook claims installation %d for %s, which belongs to installation %d.", installationID, repo.GetFullName(), actual)
+		return false
+	}
+	b.installs.add(key, now)
+	return true
+}
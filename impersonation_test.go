@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// commentFromBot delivers a comment webhook with body from the bot account
+// login.
+func commentFromBot(t *testing.T, env *testEnv, login, body string) {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(commentPayload(t, body), &event); err != nil {
+		t.Fatalf("decoding comment payload: %v", err)
+	}
+	event["sender"] = map[string]any{"login": login, "type": "Bot"}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding comment payload: %v", err)
+	}
+	env.deliverPayload(t, "issue_comment", payload)
+}
+
+func TestCommandFromBotIsIgnored(t *testing.T) {
+	env := newTestEnv(t)
+	before := rejectedCommandsTotal.Value("bot")
+
+	commentFromBot(t, env, "other-app[bot]", "@prd-bot need_prd")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("a bot's command reached the model %d times", len(prompts))
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Errorf("the bot shouldn't answer other bots: %v", comments)
+	}
+	if got := rejectedCommandsTotal.Value("bot") - before; got != 1 {
+		t.Errorf("rejected commands counted %v times, want 1", got)
+	}
+}
+
+func TestCommandFromBotIsIgnoredBeforeServerLimits(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.settings.Store(&ServerConfig{DisabledCommands: []string{CommandGeneratePRD}, RateLimit: RateLimitConfig{CommandsPerHour: 1}})
+
+	commentFromBot(t, env, "other-app[bot]", "@prd-bot need_prd")
+	commentFromBot(t, env, "other-app[bot]", "@prd-bot explain")
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Fatalf("the bot shouldn't answer other bots, even to refuse: %v", comments)
+	}
+	// The bot's commands didn't use the repository's rate limit.
+	env.comment(t, "@prd-bot explain")
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 || strings.Contains(comments[0].GetBody(), "limit of 1 commands per hour") {
+		t.Errorf("a user's command should run: %v", comments)
+	}
+}
+
+func TestCommandFromAllowedBotRuns(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "allowed_bots: [\"Renovate[bot]\"]\n")
+
+	commentFromBot(t, env, "renovate[bot]", "@prd-bot feedback The scope is too broad.")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "recorded your feedback") {
+		t.Errorf("an allowed bot's command should run: %v", comments)
+	}
+}
+
+func TestCommandWithForeignInstallationIsRejected(t *testing.T) {
+	for name, installation := range map[string]int64{"other installation": 99, "not installed": 0} {
+		t.Run(name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.github.installs = map[string]int64{"acme/widgets": installation}
+			before := rejectedCommandsTotal.Value("installation")
+
+			env.comment(t, "@prd-bot need_prd")
+
+			if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+				t.Errorf("a spoofed command reached the model %d times", len(prompts))
+			}
+			if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+				t.Errorf("the bot shouldn't act on a spoofed command: %v", comments)
+			}
+			if got := rejectedCommandsTotal.Value("installation") - before; got != 1 {
+				t.Errorf("rejected commands counted %v times, want 1", got)
+			}
+			var known int64
+			if ok, _ := env.bot.store.Get(bucketInstallations, "acme/widgets", &known); ok {
+				t.Errorf("the spoofed installation shouldn't be remembered, got %d", known)
+			}
+		})
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// maxWebhookBytes is the largest webhook payload accepted, GitHub's own
+	// cap on the payloads it sends.
+	maxWebhookBytes = 25 << 20
+
+	// maxReadmeBytes is the largest README included in prompts as it is.
+	maxReadmeBytes = 16 << 10
+
+	// maxReadmeSummaryBytes is the largest README file summarized for
+	// prompts; larger ones are left out.
+	maxReadmeSummaryBytes = 256 << 10
+
+	// readmeChunkBytes is the size of the parts a README is summarized in.
+	readmeChunkBytes = 64 << 10
+
+	// maxPromptBytes is the largest prompt sent to a model.
+	maxPromptBytes = 1 << 20
+
+	promptReadmeSummary = "readme_summary"
+)
+
+// checkWebhookRequest rejects webhook deliveries that aren't JSON and limits
+// the size of their body. It reports whether the request may be read.
+func checkWebhookRequest(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		log.Printf("Rejecting webhook with content type %q.", r.Header.Get("Content-Type"))
+		http.Error(w, "Webhooks must be sent as application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxWebhookBytes)
+	return true
+}
+
+// isPayloadTooLarge reports whether err comes from reading a webhook body
+// larger than maxWebhookBytes.
+func isPayloadTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
+}
+
+// guardPrompt protects model calls from pathological inputs: it rejects
+// prompts larger than maxPromptBytes, and replaces invalid UTF-8 and drops
+// NUL bytes, which the model APIs refuse.
+func guardPrompt(prompt string) (string, error) {
+	if len(prompt) > maxPromptBytes {
+		return "", fmt.Errorf("%w: the prompt is %d KB, the limit is %d KB", ErrInputTooLarge, len(prompt)/1024, maxPromptBytes/1024)
+	}
+	prompt = strings.ToValidUTF8(prompt, "\uFFFD")
+	return strings.ReplaceAll(prompt, "\x00", ""), nil
+}
+
+// condenseReadme fits the README context into prompts: up to maxReadmeBytes
+// it is used as it is, and larger ones are summarized part by part.
+func (b *Bot) condenseReadme(ctx context.Context, repo *github.Repository, readme string) string {
+	if len(readme) <= maxReadmeBytes {
+		return readme
+	}
+	summary, err := summarizeReadme(ctx, b.llm, readme)
+	if err != nil {
+		log.Printf("Error summarizing the %d KB README of %s, using its beginning: %v", len(readme)/1024, repo.GetFullName(), err)
+		return truncateUTF8(readme, maxReadmeBytes) + "\n\n(The rest of the README was left out.)"
+	}
+	return summary
+}
+
+// summarizeReadme summarizes readme in parts of readmeChunkBytes, one model
+// request each, so no request carries the whole file.
+func summarizeReadme(ctx context.Context, llm Generator, readme string) (string, error) {
+	chunks := splitChunks(readme, readmeChunkBytes)
+	summaries := make([]string, len(chunks))
+	for i, chunk := range chunks {
+		prompt := fmt.Sprintf(
+			"Summarize part %d of %d of a repository README for a product manager writing requirements for the project. "+
+				"Keep its purpose, features, architecture, setup and constraints; leave out badges, long examples and license text. Use at most 300 words.\n\n"+
+				"**README part:**\n%s",
+			i+1, len(chunks), chunk,
+		)
+		summary, err := llm.GenerateText(withSystemPrompt(ctx, promptReadmeSummary), prompt)
+		if err != nil {
+			return "", fmt.Errorf("failed to summarize part %d of the README: %w", i+1, err)
+		}
+		summaries[i] = strings.TrimSpace(summary)
+	}
+	return fmt.Sprintf("(Summary of a %d KB README.)\n\n%s", len(readme)/1024, strings.Join(summaries, "\n\n")), nil
+}
+
+// splitChunks splits text into parts of at most size bytes, at line breaks
+// where it can.
+func splitChunks(text string, size int) []string {
+	var chunks []string
+	for len(text) > size {
+		cut := strings.LastIndexByte(text[:size], '\n') + 1
+		if cut == 0 {
+			cut = len(truncateUTF8(text, size))
+		}
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+	if strings.TrimSpace(text) != "" {
+		chunks = append(chunks, text)
+	}
+	return chunks
+}
+
+// truncateUTF8 returns at most the first n bytes of s, without splitting a
+// character.
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWebhookRejectsOtherContentTypes(t *testing.T) {
+	env := newTestEnv(t)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", strings.NewReader("payload=%7B%7D"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-GitHub-Event", "issues")
+	rec := httptest.NewRecorder()
+	env.bot.handleWebhook(rec, req)
+	if rec.Code != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for a form-encoded webhook, got %d", rec.Code)
+	}
+}
+
+func TestWebhookRejectsOversizedPayloads(t *testing.T) {
+	env := newTestEnv(t)
+	req := httptest.NewRequest(http.MethodPost, "/webhook", bytes.NewReader(make([]byte, maxWebhookBytes+1)))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("X-GitHub-Event", "issues")
+	req.Header.Set("X-Hub-Signature-256", "sha256=00")
+	rec := httptest.NewRecorder()
+	env.bot.handleWebhook(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for an oversized webhook, got %d", rec.Code)
+	}
+}
+
+func TestLargeReadmeIsSummarizedInChunks(t *testing.T) {
+	env := newTestEnv(t)
+	section := "## Usage\n" + strings.Repeat("Widgets renders reports for analysts.\n", 1000)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets\n"+strings.Repeat(section, 3))
+	env.gemini.on("Summarize part", "Widgets renders reports.")
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.comment(t, "@prd-bot need_prd")
+
+	var summaries int
+	var prd string
+	for _, prompt := range env.gemini.receivedPrompts() {
+		switch {
+		case strings.HasPrefix(prompt, "Summarize part"):
+			summaries++
+			if len(prompt) > readmeChunkBytes+1024 {
+				t.Errorf("a summary request carries %d bytes", len(prompt))
+			}
+		case strings.HasPrefix(prompt, "Create a Product Requirements Document"):
+			prd = prompt
+		}
+	}
+	if summaries != 2 {
+		t.Errorf("expected the README to be summarized in 2 parts, got %d", summaries)
+	}
+	if !strings.Contains(prd, "(Summary of a 111 KB README.)\n\nWidgets renders reports.\n\nWidgets renders reports.") || strings.Contains(prd, "## Usage") {
+		t.Errorf("the PRD prompt should carry the summary instead of the README:\n%.500s", prd)
+	}
+}
+
+func TestHugeReadmeIsLeftOut(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", strings.Repeat("x", maxReadmeSummaryBytes+1))
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.comment(t, "@prd-bot need_prd")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "(README.md is 256 KB, too large to include.)") || strings.Contains(prompt, "xxxx") {
+		t.Errorf("the PRD prompt should leave the README out:\n%.500s", prompt)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 || !strings.HasPrefix(comments[0].GetBody(), PRDIdentifier) {
+		t.Error("the PRD should still be generated")
+	}
+}
+
+func TestGuardPrompt(t *testing.T) {
+	if _, err := guardPrompt(strings.Repeat("a", maxPromptBytes+1)); !errors.Is(err, ErrInputTooLarge) {
+		t.Errorf("expected ErrInputTooLarge, got %v", err)
+	}
+	if got, err := guardPrompt("a\x00b\xffc"); err != nil || got != "ab�c" {
+		t.Errorf("guardPrompt = %q, %v", got, err)
+	}
+}
+
+func TestSplitChunks(t *testing.T) {
+	chunks := splitChunks("aaaa\nbbbb\ncccccccccc\n", 6)
+	want := []string{"aaaa\n", "bbbb\n", "cccccc", "cccc\n"}
+	if strings.Join(chunks, "|") != strings.Join(want, "|") {
+		t.Errorf("splitChunks = %q, want %q", chunks, want)
+	}
+}
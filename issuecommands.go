@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// issueBodyDirective starts the lines at the bottom of an issue body that
+// list the commands to run when the issue is opened, so issue templates can
+// pre-wire a pipeline, e.g. "/agent: need_prd, need_sub_task".
+const issueBodyDirective = "/agent:"
+
+// bodyForbiddenCommands can't run from an issue body: anyone who can read
+// the issue would see the API key.
+var bodyForbiddenCommands = []string{CommandAPIKey}
+
+// bodyCommand is a command listed in an issue body.
+type bodyCommand struct {
+	Name string
+	Args []string
+}
+
+// parseBodyCommands returns the commands of the directive lines at the
+// bottom of body, in order, and body without those lines.
+func parseBodyCommands(body string) ([]bodyCommand, string) {
+	lines := strings.Split(strings.TrimRight(body, " \t\r\n"), "\n")
+	end := len(lines)
+	for end > 0 {
+		line := strings.TrimSpace(lines[end-1])
+		if line != "" && !hasDirectivePrefix(line) {
+			break
+		}
+		end--
+	}
+	var commands []bodyCommand
+	for _, line := range lines[end:] {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		for _, item := range strings.Split(line[len(issueBodyDirective):], ",") {
+			if fields := strings.Fields(item); len(fields) > 0 {
+				commands = append(commands, bodyCommand{Name: fields[0], Args: fields[1:]})
+			}
+		}
+	}
+	return commands, strings.TrimRight(strings.Join(lines[:end], "\n"), " \t\r\n")
+}
+
+func hasDirectivePrefix(line string) bool {
+	return len(line) >= len(issueBodyDirective) && strings.EqualFold(line[:len(issueBodyDirective)], issueBodyDirective)
+}
+
+// runBodyCommands runs the commands listed in a new issue's body one after
+// the other, on behalf of the issue's author, so each can use what the
+// previous one produced. Commands that don't exist or can't run from an
+// issue body are reported in one comment.
+func (b *Bot) runBodyCommands(ctx context.Context, client *github.Client, commands []bodyCommand, issue *github.Issue, repo *github.Repository, installationID int64) {
+	var skipped []string
+	for _, command := range commands {
+		handler, exists := b.commands[command.Name]
+		if !exists || slices.Contains(bodyForbiddenCommands, command.Name) {
+			skipped = append(skipped, "`"+command.Name+"`")
+			continue
+		}
+		log.Printf("Running '%s' from the body of issue #%d in %s.", command.Name, issue.GetNumber(), repo.GetFullName())
+		b.runCommandHandler(client, handler, command.Name, command.Args, issue, repo, installationID, issue.GetUser())
+	}
+	if len(skipped) > 0 {
+		msg := fmt.Sprintf("I skipped %s in the `%s` line of this issue: they aren't commands I can run from an issue body.", strings.Join(skipped, ", "), issueBodyDirective)
+		b.postComment(ctx, client, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), msg)
+	}
+}
+
+// withoutBodyCommands returns a copy of issue with body, the issue's body
+// without its command lines, so they don't end up in prompts.
+func withoutBodyCommands(issue *github.Issue, body string) *github.Issue {
+	stripped := *issue
+	stripped.Body = github.String(body)
+	return &stripped
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseBodyCommands(t *testing.T) {
+	body := "Export reports as CSV.\n\n/agent: need_prd\n/AGENT: need_sub_task, translate_prd ja \n\n"
+	commands, rest := parseBodyCommands(body)
+	want := []bodyCommand{{Name: CommandGeneratePRD, Args: []string{}}, {Name: CommandGenerateSubTask, Args: []string{}}, {Name: CommandTranslatePRD, Args: []string{"ja"}}}
+	if !slices.EqualFunc(commands, want, func(a, b bodyCommand) bool { return a.Name == b.Name && slices.Equal(a.Args, b.Args) }) {
+		t.Errorf("parseBodyCommands = %+v, want %+v", commands, want)
+	}
+	if rest != "Export reports as CSV." {
+		t.Errorf("unexpected body %q", rest)
+	}
+
+	// Only the lines at the bottom count.
+	if commands, rest := parseBodyCommands("/agent: need_prd\n\nDetails."); len(commands) != 0 || rest != "/agent: need_prd\n\nDetails." {
+		t.Errorf("a directive above the text should be ignored, got %+v and %q", commands, rest)
+	}
+}
+
+func TestIssueBodyCommandsRunOnOpen(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets\nA reporting tool.")
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliverPayload(t, "issues", issueWithBody(t, "Export reports as CSV.\n\n/agent: need_prd, need_sub_task, api_key set AIza-1234, nonsense"))
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 3 {
+		t.Fatalf("expected the PRD, the sub-tasks and a note, got %d comments", len(comments))
+	}
+	if !strings.Contains(comments[0].GetBody(), PRDIdentifier) || !strings.Contains(comments[1].GetBody(), "- [ ] Add the CSV encoder.") {
+		t.Errorf("the commands should run in order:\n%s\n---\n%s", comments[0].GetBody(), comments[1].GetBody())
+	}
+	if body := comments[2].GetBody(); !strings.Contains(body, "`api_key`, `nonsense`") {
+		t.Errorf("unexpected note:\n%s", body)
+	}
+	for _, prompt := range env.gemini.receivedPrompts() {
+		if strings.Contains(prompt, issueBodyDirective) {
+			t.Errorf("the command line shouldn't reach the model:\n%s", prompt)
+		}
+	}
+}
+
+func TestIssueBodyCommandsReplaceAutomaticPRD(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+
+	env.deliverPayload(t, "issues", issueWithBody(t, "Export reports as CSV.\n/agent: need_sub_task"))
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("an issue that already has a PRD shouldn't run its commands again, got %d prompts", len(prompts))
+	}
+}
+
+// issueWithBody is the issues "opened" payload for #42 with body.
+func issueWithBody(t *testing.T, body string) []byte {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(issuesPayload(t, "opened", nil), &event); err != nil {
+		t.Fatal(err)
+	}
+	event["issue"].(map[string]any)["body"] = body
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
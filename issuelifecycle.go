@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// issueTransfer is the part of an issues "transferred" webhook naming where
+// the issue went; go-github doesn't decode these changes.
+type issueTransfer struct {
+	Changes struct {
+		NewIssue      *github.Issue      `json:"new_issue"`
+		NewRepository *github.Repository `json:"new_repository"`
+	} `json:"changes"`
+}
+
+// handleIssueTransferred moves what the bot stored about a transferred issue
+// to its number in the new repository. The comments, PRD included, move with
+// the issue, so the new repository's "opened" event doesn't generate another.
+func (b *Bot) handleIssueTransferred(ctx context.Context, payload []byte, issue *github.Issue, repo *github.Repository) {
+	var e issueTransfer
+	if err := json.Unmarshal(payload, &e); err != nil || e.Changes.NewIssue == nil || e.Changes.NewRepository == nil {
+		log.Printf("Ignoring the transfer of issue #%d in %s without its destination.", issue.GetNumber(), repo.GetFullName())
+		return
+	}
+	to, toRepo := e.Changes.NewIssue.GetNumber(), e.Changes.NewRepository
+	b.dispatch(ctx, func() {
+		moved, err := b.migrateIssueData(repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), toRepo.GetOwner().GetLogin(), toRepo.GetName(), to)
+		if err != nil {
+			log.Printf("Error moving the data of issue #%d in %s to #%d in %s: %v", issue.GetNumber(), repo.GetFullName(), to, toRepo.GetFullName(), err)
+			return
+		}
+		log.Printf("Moved %d documents of issue #%d in %s to #%d in %s.", moved, issue.GetNumber(), repo.GetFullName(), to, toRepo.GetFullName())
+	})
+}
+
+// migrateIssueData rekeys the documents stored about an issue to another
+// issue, updating the owner, repo and issue fields of documents that have
+// them. Documents about the issue's pull requests stay, as pull requests
+// aren't transferred.
+func (b *Bot) migrateIssueData(owner, repo string, issueNum int, toOwner, toRepo string, toNum int) (int, error) {
+	from, to := issueKey(owner, repo, issueNum), issueKey(toOwner, toRepo, toNum)
+	moved := 0
+	for _, bucket := range dataBuckets {
+		docs, err := b.store.List(bucket)
+		if err != nil {
+			return moved, err
+		}
+		for key, doc := range docs {
+			rest, ok := strings.CutPrefix(key, from)
+			if !ok || (rest != "" && !strings.ContainsAny(rest[:1], "/@")) {
+				continue
+			}
+			if err := b.store.Put(bucket, to+rest, retargetDocument(doc, issueNum, toOwner, toRepo, toNum)); err != nil {
+				return moved, fmt.Errorf("writing %s/%s: %w", bucket, to+rest, err)
+			}
+			if err := b.store.Delete(bucket, key); err != nil {
+				return moved, fmt.Errorf("deleting %s/%s: %w", bucket, key, err)
+			}
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// retargetDocument points a stored JSON object about issue issueNum at the
+// new issue. Other documents are returned unchanged.
+func retargetDocument(doc []byte, issueNum int, toOwner, toRepo string, toNum int) json.RawMessage {
+	var fields map[string]any
+	if err := json.Unmarshal(doc, &fields); err != nil {
+		return doc
+	}
+	if n, ok := fields["issue"].(float64); !ok || int(n) != issueNum {
+		return doc
+	}
+	fields["issue"] = toNum
+	if _, ok := fields["owner"]; ok {
+		fields["owner"] = toOwner
+	}
+	if _, ok := fields["repo"]; ok {
+		fields["repo"] = toRepo
+	}
+	updated, err := json.Marshal(fields)
+	if err != nil {
+		return doc
+	}
+	return updated
+}
+
+// handleIssueConverted deletes what the bot stored about an issue converted
+// to a discussion, which commands can no longer reach.
+func (b *Bot) handleIssueConverted(ctx context.Context, issue *github.Issue, repo *github.Repository) {
+	b.dispatch(ctx, func() {
+		purged, err := b.purgeData(repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber())
+		if err != nil {
+			log.Printf("Error deleting the data of issue #%d in %s after its conversion to a discussion: %v", issue.GetNumber(), repo.GetFullName(), err)
+			return
+		}
+		log.Printf("Deleted %d documents of issue #%d in %s, converted to a discussion.", purged, issue.GetNumber(), repo.GetFullName())
+	})
+}
+
+// hasPRD reports whether the issue already has a PRD comment, e.g. because
+// it was transferred from another repository with its comments.
+func (b *Bot) hasPRD(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int) bool {
+	comment, err := b.findPRDComment(ctx, client, repo.GetOwner().GetLogin(), repo.GetName(), issueNum)
+	if err != nil {
+		log.Printf("Error looking for an existing PRD on issue #%d: %v", issueNum, err)
+		return false
+	}
+	return comment != nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// issuesPayload is the issues fixture for #42 with action and extra fields.
+func issuesPayload(t *testing.T, action string, extra map[string]any) []byte {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issues_opened.json"), &event); err != nil {
+		t.Fatalf("decoding issues fixture: %v", err)
+	}
+	event["action"] = action
+	for k, v := range extra {
+		event[k] = v
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding issues payload: %v", err)
+	}
+	return payload
+}
+
+func TestTransferredIssueKeepsItsDocuments(t *testing.T) {
+	env := newTestEnv(t)
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, PRDIdentifier+"\n\nCSV", nil)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(420)}, "another issue", nil)
+	if err := env.bot.store.Put(bucketOnboarding, issueKey("acme", "widgets", 42)+"@alice", true); err != nil {
+		t.Fatal(err)
+	}
+
+	env.deliverPayload(t, "issues", issuesPayload(t, "transferred", map[string]any{"changes": map[string]any{
+		"new_issue":      map[string]any{"number": 7},
+		"new_repository": map[string]any{"name": "reports", "full_name": "acme/reports", "owner": map[string]any{"login": "acme"}},
+	}}))
+
+	moved, _ := env.bot.loadArtifact("acme", "reports", 7, ArtifactPRD)
+	if moved == nil || moved.Owner != "acme" || moved.Repo != "reports" || moved.Issue != 7 || !strings.Contains(moved.Markdown, "CSV") {
+		t.Errorf("the PRD artifact should follow the issue, got %+v", moved)
+	}
+	if old, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD); old != nil {
+		t.Errorf("the artifact should leave the old issue, got %+v", old)
+	}
+	if other, _ := env.bot.loadArtifact("acme", "widgets", 420, ArtifactPRD); other == nil {
+		t.Error("issue #420 shouldn't be affected by the transfer of #42")
+	}
+	var onboarded bool
+	if ok, _ := env.bot.store.Get(bucketOnboarding, issueKey("acme", "reports", 7)+"@alice", &onboarded); !ok || !onboarded {
+		t.Error("documents without issue fields should be rekeyed too")
+	}
+}
+
+func TestOpenedIssueWithPRDIsSkipped(t *testing.T) {
+	env := newTestEnv(t)
+	// A transferred issue arrives in its new repository with its comments.
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+"\n\nCSV")
+
+	env.deliverPayload(t, "issues", issuesPayload(t, "opened", nil))
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("an issue with a PRD shouldn't get another, got %d prompts", len(prompts))
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 {
+		t.Errorf("expected no new comment, got %d comments", len(comments))
+	}
+}
+
+func TestReopenedIssueDoesNotRegeneratePRD(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.deliverPayload(t, "issues", issuesPayload(t, "reopened", nil))
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("reopening shouldn't generate a PRD, got %d prompts", len(prompts))
+	}
+}
+
+func TestConvertedIssueDataIsDeleted(t *testing.T) {
+	env := newTestEnv(t)
+	issue := &github.Issue{Number: github.Int(42)}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, PRDIdentifier, nil)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(7)}, PRDIdentifier, nil)
+
+	env.deliverPayload(t, "issues", issuesPayload(t, "converted_to_discussion", nil))
+
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD); artifact != nil {
+		t.Errorf("the converted issue's artifacts should be deleted, got %+v", artifact)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 7, ArtifactPRD); artifact == nil {
+		t.Error("other issues should keep their artifacts")
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// Values of execution.on_busy.
+const (
+	busyQueue  = "queue"  // wait for the running implementation, then start (default)
+	busyReject = "reject" // decline, linking to the running implementation
+)
+
+const (
+	// issueLockPoll is how often a waiting implementation checks whether the
+	// one ahead of it finished.
+	issueLockPoll = 5 * time.Second
+	// maxIssueLockWait bounds the wait, well within the lease of the queued
+	// job that waits.
+	maxIssueLockWait = 10 * time.Minute
+)
+
+// issueLock is held by the implementation working on an issue, so two runs
+// don't race for its branch and pull request.
+type issueLock struct {
+	Token   string    `json:"token"`
+	Link    string    `json:"link,omitempty"` // the holder's progress comment
+	Expires time.Time `json:"expires"`
+}
+
+// issueLocker hands out issue locks. A lock expires after jobLeaseTimeout,
+// when the job of a worker that died would be handed out again.
+type issueLocker interface {
+	// acquire takes key for lock, or renews it when lock.Token holds it
+	// already. Otherwise it returns the current holder.
+	acquire(key string, lock issueLock) (holder issueLock, ok bool, err error)
+	// release gives up key when token holds it.
+	release(key, token string) error
+}
+
+// localLocker keeps issue locks in memory: those of a single process, or, on
+// a frontend, those of all its workers.
+type localLocker struct {
+	mu    sync.Mutex
+	locks map[string]issueLock
+}
+
+func (l *localLocker) acquire(key string, lock issueLock) (issueLock, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	if held, ok := l.locks[key]; ok && held.Token != lock.Token && now.Before(held.Expires) {
+		return held, false, nil
+	}
+	if l.locks == nil {
+		l.locks = make(map[string]issueLock)
+	}
+	lock.Expires = now.Add(jobLeaseTimeout)
+	l.locks[key] = lock
+	return lock, true, nil
+}
+
+func (l *localLocker) release(key, token string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.locks[key].Token == token {
+		delete(l.locks, key)
+	}
+	return nil
+}
+
+// remoteLocker is the issueLocker of a worker: the frontend's, over HTTP.
+type remoteLocker struct {
+	frontend *frontendClient
+}
+
+// lockResult is the frontend's answer to an acquire request.
+type lockResult struct {
+	Acquired bool      `json:"acquired"`
+	Holder   issueLock `json:"holder"`
+}
+
+func lockPath(key string) string {
+	return "/internal/locks/" + url.PathEscape(key)
+}
+
+func (l *remoteLocker) acquire(key string, lock issueLock) (issueLock, bool, error) {
+	resp, err := l.frontend.do(context.Background(), http.MethodPost, lockPath(key), lock)
+	if err != nil {
+		return issueLock{}, false, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return issueLock{}, false, expect(resp, http.StatusOK)
+	}
+	defer resp.Body.Close()
+	var result lockResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return issueLock{}, false, fmt.Errorf("decoding the lock of %s: %w", key, err)
+	}
+	return result.Holder, result.Acquired, nil
+}
+
+func (l *remoteLocker) release(key, token string) error {
+	resp, err := l.frontend.do(context.Background(), http.MethodDelete, lockPath(key)+"?token="+url.QueryEscape(token), nil)
+	if err != nil {
+		return err
+	}
+	return expect(resp, http.StatusNoContent)
+}
+
+func (b *Bot) handleLock(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeWorker(w, r) {
+		return
+	}
+	key := r.PathValue("key")
+	if r.Method == http.MethodDelete {
+		if err := b.locker.release(key, r.URL.Query().Get("token")); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	var lock issueLock
+	if err := json.NewDecoder(r.Body).Decode(&lock); err != nil || lock.Token == "" {
+		http.Error(w, "A lock needs a token", http.StatusBadRequest)
+		return
+	}
+	holder, ok, err := b.locker.acquire(key, lock)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encodeWorkerResponse(w, lockResult{Acquired: ok, Holder: holder})
+}
+
+// heldIssue is an issue lock taken by an implementation.
+type heldIssue struct {
+	bot   *Bot
+	key   string
+	token string
+}
+
+// lockIssue takes the lock of the issue for an implementation. When another
+// implementation holds it, the run waits for it to finish or, with
+// execution.on_busy: reject, declines with a link to it. It returns nil when
+// the run must not go ahead; a lock service that can't be reached doesn't
+// stop it.
+func (b *Bot) lockIssue(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, execution *ExecutionConfig) *heldIssue {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	held := &heldIssue{bot: b, key: issueKey(owner, name, issueNum), token: randomString()}
+	holder, ok, err := b.locker.acquire(held.key, issueLock{Token: held.token})
+	if err != nil {
+		log.Printf("Could not lock issue #%d in %s, going ahead without the lock: %v", issueNum, repo.GetFullName(), err)
+		return held
+	}
+	if ok {
+		return held
+	}
+	running := "Another implementation of this issue is already in progress"
+	if holder.Link != "" {
+		running += ": " + holder.Link
+	}
+	if execution.rejectsWhenBusy() {
+		log.Printf("Issue #%d in %s is being implemented already, declining.", issueNum, repo.GetFullName())
+		b.postComment(ctx, client, owner, name, issueNum, running+"\n\nI won't start a second one at the same time. Try again once it finishes.")
+		return nil
+	}
+	log.Printf("Issue #%d in %s is being implemented already, waiting for it.", issueNum, repo.GetFullName())
+	b.postComment(ctx, client, owner, name, issueNum, running+"\n\nI'll start on this request once it finishes.")
+	deadline := time.Now().Add(maxIssueLockWait)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(b.lockPoll):
+		}
+		if _, ok, err := b.locker.acquire(held.key, issueLock{Token: held.token}); ok || err != nil {
+			return held
+		}
+	}
+	b.postComment(ctx, client, owner, name, issueNum, fmt.Sprintf("The other implementation of this issue is still in progress after %d minutes, so I gave up waiting. Try again once it finishes.", int(maxIssueLockWait.Minutes())))
+	return nil
+}
+
+// link points waiting implementations at the holder's progress comment.
+func (h *heldIssue) link(comment *github.IssueComment) {
+	if comment == nil {
+		return
+	}
+	if _, _, err := h.bot.locker.acquire(h.key, issueLock{Token: h.token, Link: comment.GetHTMLURL()}); err != nil {
+		log.Printf("Could not renew the lock of %s: %v", h.key, err)
+	}
+}
+
+func (h *heldIssue) release() {
+	if err := h.bot.locker.release(h.key, h.token); err != nil {
+		log.Printf("Could not release the lock of %s: %v", h.key, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const runningLink = "https://github.com/acme/widgets/issues/42#issuecomment-7"
+
+func TestImplementFeatureWaitsForRunningImplementation(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.lockPoll = 10 * time.Millisecond
+	key := issueKey("acme", "widgets", 42)
+	if _, ok, _ := env.bot.locker.acquire(key, issueLock{Token: "running", Link: runningLink}); !ok {
+		t.Fatal("the first lock should be free")
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		env.bot.locker.release(key, "running")
+	}()
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) == 0 || comments[0].GetBody() != "Another implementation of this issue is already in progress: "+runningLink+"\n\nI'll start on this request once it finishes." {
+		t.Fatalf("the run should say it waits for the other one: %v", comments)
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 1 {
+		t.Errorf("the waiting run should open its pull request, got %d", len(pulls))
+	}
+	if _, ok, _ := env.bot.locker.acquire(key, issueLock{Token: "next"}); !ok {
+		t.Error("the run should release the lock when it finishes")
+	}
+}
+
+func TestImplementFeatureRejectedWhileBusy(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "execution:\n  on_busy: reject\n")
+	env.bot.locker.acquire(issueKey("acme", "widgets", 42), issueLock{Token: "running", Link: runningLink})
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), runningLink) || !strings.Contains(comments[0].GetBody(), "I won't start a second one") {
+		t.Errorf("the run should decline with a link to the running one: %v", comments)
+	}
+	if executed := env.runner.executed(); len(executed) != 0 {
+		t.Errorf("nothing should run, ran %q", executed)
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("no pull request should open, got %d", len(pulls))
+	}
+}
+
+func TestWorkersShareIssueLocks(t *testing.T) {
+	frontend := newTestEnv(t).bot
+	frontend.workerToken = "w0rker"
+	mux := http.NewServeMux()
+	frontend.registerQueueHandlers(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	first := &remoteLocker{frontend: newFrontendClient(server.URL, "w0rker")}
+	second := &remoteLocker{frontend: newFrontendClient(server.URL, "w0rker")}
+	key := issueKey("acme", "widgets", 42)
+
+	if _, ok, err := first.acquire(key, issueLock{Token: "a", Link: runningLink}); !ok || err != nil {
+		t.Fatalf("acquire = %v, %v", ok, err)
+	}
+	holder, ok, err := second.acquire(key, issueLock{Token: "b"})
+	if ok || err != nil || holder.Link != runningLink {
+		t.Fatalf("the second worker should see the holder, got %+v, %v, %v", holder, ok, err)
+	}
+	if err := second.release(key, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := second.acquire(key, issueLock{Token: "b"}); ok {
+		t.Fatal("only the holder should release the lock")
+	}
+	if err := first.release(key, "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := second.acquire(key, issueLock{Token: "b"}); !ok || err != nil {
+		t.Errorf("the released lock should be free, got %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// bucketJobHistory holds jobRecord documents keyed by
+// "owner/repo#number@<start in nanoseconds>".
+const bucketJobHistory = "job_history"
+
+// jobRecord is a command the bot ran, for the artifact viewer.
+type jobRecord struct {
+	Command   string    `json:"command"`
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Issue     int       `json:"issue"`
+	Sender    string    `json:"sender,omitempty"`
+	CreatedAt time.Time `json:"created_at"` // when the command started
+	Seconds   float64   `json:"seconds"`
+	Failure   string    `json:"failure,omitempty"` // error code of the first failure reported
+}
+
+// jobOutcomeKey is the context key of the *jobOutcome of a command.
+type jobOutcomeKey struct{}
+
+// jobOutcome collects the failures a command reports and the comments it
+// posts.
+type jobOutcome struct {
+	mu      sync.Mutex
+	failure string
+	posted  []int64 // IDs of the comments posted
+}
+
+func withJobOutcome(ctx context.Context, outcome *jobOutcome) context.Context {
+	return context.WithValue(ctx, jobOutcomeKey{}, outcome)
+}
+
+// noteFailure records code as the command's failure, unless it already
+// reported one.
+func noteFailure(ctx context.Context, code string) {
+	if outcome, ok := ctx.Value(jobOutcomeKey{}).(*jobOutcome); ok {
+		outcome.mu.Lock()
+		defer outcome.mu.Unlock()
+		if outcome.failure == "" {
+			outcome.failure = code
+		}
+	}
+}
+
+// notePosted records a comment the command posted.
+func notePosted(ctx context.Context, comment *github.IssueComment) {
+	if outcome, ok := ctx.Value(jobOutcomeKey{}).(*jobOutcome); ok {
+		outcome.mu.Lock()
+		defer outcome.mu.Unlock()
+		outcome.posted = append(outcome.posted, comment.GetID())
+	}
+}
+
+// postedComments returns the IDs of the comments the command posted.
+// failed reports whether the command reported a failure.
+func (o *jobOutcome) failed() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.failure != ""
+}
+
+func (o *jobOutcome) postedComments() []int64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.posted)
+}
+
+// recordJob stores the history entry of a finished command. purge_data
+// isn't recorded, so deleting a repository's data leaves nothing about it.
+func (b *Bot) recordJob(record *jobRecord, outcome *jobOutcome) {
+	if record.Command == CommandPurgeData {
+		return
+	}
+	record.Seconds = time.Since(record.CreatedAt).Seconds()
+	outcome.mu.Lock()
+	record.Failure = outcome.failure
+	outcome.mu.Unlock()
+	key := fmt.Sprintf("%s@%d", issueKey(record.Owner, record.Repo, record.Issue), record.CreatedAt.UnixNano())
+	if err := b.store.Put(bucketJobHistory, key, record); err != nil {
+		log.Printf("Error recording the '%s' job of %s: %v", record.Command, key, err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+const (
+	// Lanes of the job queue.
+	laneQuick = "quick" // answered with a comment or two, e.g. need_prd
+	laneHeavy = "heavy" // clones, pushes or opens pull requests
+)
+
+// jobLanes lists the lanes from the highest priority, so a backlog of
+// implementations doesn't hold up lightweight requests.
+var jobLanes = []string{laneQuick, laneHeavy}
+
+// jobLane returns the lane of a webhook: heavy when it runs one of the
+// writeCommands or may start one, quick otherwise.
+func (b *Bot) jobLane(event string, payload []byte) string {
+	var e struct {
+		Action  string `json:"action"`
+		Comment struct {
+			Body string `json:"body"`
+		} `json:"comment"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return laneQuick
+	}
+	switch event {
+	case "issue_comment":
+		// Pipelines may run write commands.
+		if command, _, ok := b.parseComment(e.Comment.Body); ok && (slices.Contains(writeCommands, command) || slices.Contains(pipelineCommands, command)) {
+			return laneHeavy
+		}
+	case "issues":
+		// Assignments and labels may start implement_feature, and closing
+		// may archive the issue through a pull request.
+		if e.Action == "assigned" || e.Action == "labeled" || e.Action == "closed" {
+			return laneHeavy
+		}
+	case "push":
+		// Pushes rebase the bot's pull requests.
+		return laneHeavy
+	}
+	return laneQuick
+}
+
+// parseLanes parses a comma-separated list of lanes such as WORKER_LANES.
+func parseLanes(s string) ([]string, error) {
+	var lanes []string
+	for _, lane := range strings.Split(s, ",") {
+		lane = strings.TrimSpace(lane)
+		if lane == "" {
+			continue
+		}
+		if !slices.Contains(jobLanes, lane) {
+			return nil, fmt.Errorf("unknown lane %q: expected %s", lane, strings.Join(jobLanes, " or "))
+		}
+		lanes = append(lanes, lane)
+	}
+	return lanes, nil
+}
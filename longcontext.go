@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// defaultLongContextModel is the model long-context prompts use unless
+	// the repository configures another.
+	defaultLongContextModel = "gemini-1.5-pro"
+	// defaultLongContextBytes is the default budget of a packed repository.
+	defaultLongContextBytes = 512 << 10
+	// maxLongContextBytes keeps room in the prompt for the issue and the
+	// instructions around a packed repository.
+	maxLongContextBytes = maxPromptBytes - 128<<10
+	// maxLongContextFiles bounds the files fetched to pack a repository.
+	maxLongContextFiles = 1000
+)
+
+// defaultLongContextIgnore lists, in gitignore syntax, files never packed:
+// dependencies, lock files, build output and binary formats.
+var defaultLongContextIgnore = []string{
+	"vendor/", "node_modules/", "third_party/", "dist/", ".git/",
+	"go.sum", "package-lock.json", "yarn.lock", "pnpm-lock.yaml", "Cargo.lock", "poetry.lock", "*.min.js", "*.min.css", "*.map",
+	"*.png", "*.jpg", "*.jpeg", "*.gif", "*.ico", "*.webp", "*.pdf", "*.zip", "*.gz", "*.tar", "*.jar",
+	"*.exe", "*.dll", "*.so", "*.dylib", "*.woff", "*.woff2", "*.ttf", "*.eot", "*.mp4", "*.mp3",
+}
+
+// LongContextConfig makes PRDs and implementation plans read the whole
+// repository in a single long-context prompt instead of its README, and
+// implement_feature edit with the long-context model, when the repository
+// fits the budget.
+type LongContextConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Model is the long-context model used for these prompts.
+	Model string `yaml:"model"`
+	// MaxBytes is the budget of the packed repository; larger repositories
+	// fall back to the README.
+	MaxBytes int `yaml:"max_bytes"`
+	// Ignore lists more files to leave out, in gitignore syntax.
+	Ignore []string `yaml:"ignore"`
+}
+
+func (c *LongContextConfig) enabled() bool {
+	return c != nil && c.Enabled
+}
+
+func (c *LongContextConfig) model() string {
+	if c == nil || c.Model == "" {
+		return defaultLongContextModel
+	}
+	return c.Model
+}
+
+func (c *LongContextConfig) budget() int {
+	if c == nil || c.MaxBytes <= 0 {
+		return defaultLongContextBytes
+	}
+	return min(c.MaxBytes, maxLongContextBytes)
+}
+
+type modelKey struct{}
+
+// withModel returns a context whose model requests use model instead of the
+// configured one.
+func withModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// contextModel returns the model set by withModel, or "".
+func contextModel(ctx context.Context) string {
+	model, _ := ctx.Value(modelKey{}).(string)
+	return model
+}
+
+// specContext returns the repository context of PRD prompts: the whole
+// repository with a context using the long-context model when long-context
+// mode is on and the repository fits, its README otherwise.
+func (b *Bot) specContext(ctx context.Context, client *github.Client, repo *github.Repository, cfg *LongContextConfig) (context.Context, string, error) {
+	if packed, ok := b.packRepository(ctx, client, repo, cfg); ok {
+		return withModel(ctx, cfg.model()), packed, nil
+	}
+	readme, err := b.resolveRepoContext(ctx, client, repo)
+	return ctx, readme, err
+}
+
+// editModel returns the model implement_feature edits repo with: the
+// long-context model when the repository fits long-context mode, or "" for
+// the Gemini CLI's default.
+func editModel(ctx context.Context, client *github.Client, repo *github.Repository, cfg *LongContextConfig) string {
+	if _, ok := longContextFiles(ctx, client, repo, cfg); ok {
+		return cfg.model()
+	}
+	return ""
+}
+
+// packRepository returns every text file of repo's default branch, each
+// under its path, when long-context mode is on and the files fit its
+// budget. It reports false, to fall back to retrieval from the README, when
+// the mode is off, the repository is too large or it can't be read.
+func (b *Bot) packRepository(ctx context.Context, client *github.Client, repo *github.Repository, cfg *LongContextConfig) (string, bool) {
+	paths, ok := longContextFiles(ctx, client, repo, cfg)
+	if !ok {
+		return "", false
+	}
+	owner, name, ref := repo.GetOwner().GetLogin(), repo.GetName(), repo.GetDefaultBranch()
+	var packed strings.Builder
+	fmt.Fprintf(&packed, "(Long-context mode: below are the text files of the repository at `%s`, each under its path.)\n\n", ref)
+	for _, p := range paths {
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, name, p, &github.RepositoryContentGetOptions{Ref: ref})
+		if err != nil || file == nil {
+			log.Printf("Could not read %s of %s for long-context mode, using its README: %v", p, repo.GetFullName(), err)
+			return "", false
+		}
+		content, err := file.GetContent()
+		if err != nil || !utf8.ValidString(content) || strings.ContainsRune(content, 0) {
+			continue // binary
+		}
+		fmt.Fprintf(&packed, "--- %s ---\n%s\n", p, strings.TrimRight(content, "\n"))
+	}
+	log.Printf("Packed %d files of %s for long-context mode.", len(paths), repo.GetFullName())
+	return packed.String(), true
+}
+
+// longContextFiles lists the files of repo's default branch that
+// long-context mode packs, leaving out ignored ones. It reports false when
+// the mode is off or the files don't fit its budget.
+func longContextFiles(ctx context.Context, client *github.Client, repo *github.Repository, cfg *LongContextConfig) ([]string, bool) {
+	if !cfg.enabled() {
+		return nil, false
+	}
+	tree, _, err := client.Git.GetTree(ctx, repo.GetOwner().GetLogin(), repo.GetName(), repo.GetDefaultBranch(), true)
+	if err != nil {
+		log.Printf("Could not list the files of %s for long-context mode: %v", repo.GetFullName(), err)
+		return nil, false
+	}
+	if tree.GetTruncated() {
+		log.Printf("%s has too many files for long-context mode, using retrieval.", repo.GetFullName())
+		return nil, false
+	}
+	ignore := compileIgnore(slices.Concat(defaultLongContextIgnore, cfg.Ignore))
+	var paths []string
+	total := 0
+	for _, entry := range tree.Entries {
+		if entry.GetType() != "blob" || ignored(ignore, entry.GetPath()) {
+			continue
+		}
+		paths = append(paths, entry.GetPath())
+		total += entry.GetSize()
+	}
+	if total > cfg.budget() || len(paths) > maxLongContextFiles {
+		log.Printf("%s has %d KB in %d files, over the long-context budget of %d KB, using retrieval.", repo.GetFullName(), total/1024, len(paths), cfg.budget()/1024)
+		return nil, false
+	}
+	return paths, true
+}
+
+// compileIgnore compiles gitignore-style patterns.
+func compileIgnore(patterns []string) []*regexp.Regexp {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		if pattern = strings.TrimSpace(pattern); pattern != "" && !strings.HasPrefix(pattern, "#") {
+			compiled = append(compiled, codeownersPattern(pattern))
+		}
+	}
+	return compiled
+}
+
+// ignored reports whether p matches any of the patterns.
+func ignored(patterns []*regexp.Regexp, p string) bool {
+	p = path.Clean(p)
+	return slices.ContainsFunc(patterns, func(re *regexp.Regexp) bool { return re.MatchString(p) })
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// seedLongContextPRD adds a small repository and the model replies of a PRD.
+func seedLongContextPRD(t *testing.T, env *testEnv, config string) {
+	env.github.addFile("acme", "widgets", RepoConfigPath, config)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.github.addFile("acme", "widgets", "export/csv.go", "package export\n\nconst MaxRows = 10000\n")
+	env.github.addFile("acme", "widgets", "go.sum", "example.com/x v1.0.0 h1:abc=")
+	env.github.addFile("acme", "widgets", "vendor/example.com/x/x.go", "package x")
+	env.github.addFile("acme", "widgets", "fixtures/big.json", "{}")
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+}
+
+func TestPRDInLongContextMode(t *testing.T) {
+	env := newTestEnv(t)
+	seedLongContextPRD(t, env, "long_context:\n  enabled: true\n  ignore: [fixtures/]\n")
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	prompts, models := env.gemini.receivedPrompts(), env.gemini.receivedModels()
+	i := slices.IndexFunc(prompts, func(p string) bool { return strings.Contains(p, "Create a Product Requirements Document") })
+	if i < 0 {
+		t.Fatal("no PRD prompt was sent")
+	}
+	if !strings.Contains(prompts[i], "--- export/csv.go ---\npackage export\n\nconst MaxRows = 10000\n") || !strings.Contains(prompts[i], "--- README.md ---\n# Widgets") {
+		t.Errorf("the PRD prompt should hold the whole repository:\n%s", prompts[i])
+	}
+	for _, skipped := range []string{"go.sum", "vendor/", "fixtures/big.json"} {
+		if strings.Contains(prompts[i], "--- "+skipped) {
+			t.Errorf("%s should be ignored", skipped)
+		}
+	}
+	if models[i] != defaultLongContextModel {
+		t.Errorf("the PRD should use the long-context model, got %s", models[i])
+	}
+}
+
+func TestLongContextFallsBackToRetrieval(t *testing.T) {
+	env := newTestEnv(t)
+	seedLongContextPRD(t, env, "long_context:\n  enabled: true\n  max_bytes: 64\n")
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	prompts, models := env.gemini.receivedPrompts(), env.gemini.receivedModels()
+	i := slices.IndexFunc(prompts, func(p string) bool { return strings.Contains(p, "Create a Product Requirements Document") })
+	if i < 0 || strings.Contains(prompts[i], "Long-context mode") || !strings.Contains(prompts[i], "# Widgets") || models[i] == defaultLongContextModel {
+		t.Errorf("a repository over the budget should fall back to its README")
+	}
+}
+
+func TestImplementFeatureUsesLongContextModel(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "long_context:\n  enabled: true\n  model: gemini-2.0-pro\n")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if !slices.ContainsFunc(env.runner.executed(), func(c string) bool {
+		return strings.HasPrefix(c, "gemini ") && strings.Contains(c, " -y -a --model gemini-2.0-pro")
+	}) {
+		t.Errorf("the Gemini CLI should edit with the long-context model, ran:\n%s", strings.Join(env.runner.executed(), "\n"))
+	}
+}
@@ -2,17 +2,21 @@ package main
 
 import (
 	"context"
-	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/generative-ai-go/genai"
 	"github.com/google/go-github/v58/github"
 	"google.golang.org/api/option"
@@ -24,78 +28,460 @@ const (
 	CommandGeneratePRD      = "need_prd"
 	CommandGenerateSubTask  = "need_sub_task"
 	CommandImplementFeature = "implement_feature"
+	CommandExplain          = "explain"
+	CommandPriority         = "need_priority"
+	CommandRankBacklog      = "rank_backlog"
+	CommandI18nPlan         = "need_i18n_plan"
+	CommandRegenSection     = "regen_section"
+	CommandWizard           = "wizard"
+	CommandAnalyticsEvents  = "need_analytics_events"
 	PRDIdentifier           = "### PRD (Product Requirements Document)"
-)
-
-var (
-	githubAppID         = os.Getenv("GITHUB_APP_ID")
-	githubAppPrivateKey = os.Getenv("GITHUB_APP_PRIVATE_KEY")
-	githubAppName       = strings.TrimSpace(os.Getenv("GITHUB_APP_NAME"))
-	googleAPIKey        = os.Getenv("GOOGLE_API_KEY")
-	githubWebhookSecret = os.Getenv("GITHUB_WEBHOOK_SECRET")
+	defaultGeminiModel      = "gemini-1.5-flash"
+	defaultGitHost          = "github.com"
 )
 
 // --- Bot Structure and Command Handling ---
 
-// Bot holds the application's configuration and command registry.
+// Bot holds the application's configuration, injected service clients and command registry.
 type Bot struct {
-	appName  string
-	commands map[string]commandHandler
+	appName       string
+	webhookSecret []byte
+	commands      map[string]commandHandler
+
+	clients ClientFactory // creates GitHub clients and installation tokens
+	config  *ConfigLoader // resolves organization and repository settings
+	store   Store         // persists generated artifacts and bot state
+	llm     Generator     // generates text with the AI model
+	runner  CommandRunner // executes external commands such as git and the Gemini CLI
+	gitHost string        // host used to build clone URLs
+
+	apiToken   string                // bearer token for the HTTP API; the API is disabled when empty
+	flagRules  map[string]FlagRule   // feature flag rules from FEATURE_FLAGS
+	allowlist  *Allowlist            // repositories the bot acts on from ALLOWLIST; nil allows all
+	slack      *slackNotifier        // sends Slack reminders; nil when SLACK_WEBHOOK_URL is unset
+	signer     *commitSigner         // signs commits; nil when COMMIT_SIGNING_KEY is unset
+	secrets    *secretBox            // encrypts stored secrets; nil when STORE_ENCRYPTION_KEY is unset
+	viewerAuth *oidcAuth             // signs in to the artifact viewer; nil when OIDC_ISSUER is unset
+	events     *eventPublisher       // delivers lifecycle events to the webhooks of the server config
+	reporter   *errorReporter        // reports panics and failed jobs; nil when ERROR_REPORTING_DSN is unset
+	installs   verifiedInstallations // installations confirmed to cover the repositories of comment webhooks
+
+	commitBackend string          // how implement_feature commits: commitBackendGit or commitBackendAPI
+	workdirs      *workdirManager // allocates the working directories of jobs
+
+	queue       *jobQueue // queues webhooks for workers in frontend mode; nil otherwise
+	workerToken string    // authenticates workers to the frontend's internal API
+
+	settings atomic.Pointer[ServerConfig] // server-wide settings, replaced on reload
+	limiter  *rateLimiter                 // enforces the configured command rate limit
+	edits    *commentEditor               // throttles frequent comment edits
+	locker   issueLocker                  // serializes the implementations of an issue
+	lockPoll time.Duration                // how often a waiting implementation retries the lock
+
+	wizardMu sync.Mutex // serializes updates of wizard sessions
+	usageMu  sync.Mutex // serializes updates of usage documents
+	spendMu  sync.Mutex // serializes updates of monthly spend documents
+	epicMu   sync.Mutex // serializes updates of epic progress
+
+	jobs sync.WaitGroup // tracks asynchronously dispatched handlers
+
+}
+
+// commandHandler defines the function signature for a bot command. args holds
+// the words following the command in the triggering comment.
+type commandHandler func(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args []string)
+
+// ClientFactory creates authenticated GitHub clients for an installation.
+type ClientFactory interface {
+	Client(installationID int64) (*github.Client, error)
+	Token(ctx context.Context, installationID int64) (string, error)
+}
+
+// Generator produces a text completion for a prompt.
+type Generator interface {
+	GenerateText(ctx context.Context, prompt string) (string, error)
 }
 
-// commandHandler defines the function signature for a bot command.
-type commandHandler func(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64)
+// CommandRunner executes an external command in dir and returns its combined output.
+type CommandRunner func(dir, name string, args ...string) (string, error)
 
-// NewBot creates and initializes a new Bot instance.
-func NewBot(appName string) *Bot {
+// NewBot creates and initializes a new Bot instance wired to the given services.
+func NewBot(appName string, webhookSecret string, clients ClientFactory, llm Generator) *Bot {
 	bot := &Bot{
-		appName:  appName,
-		commands: make(map[string]commandHandler),
+		appName:       appName,
+		webhookSecret: []byte(webhookSecret),
+		commands:      make(map[string]commandHandler),
+		clients:       clients,
+		config:        NewConfigLoader(defaultConfigCacheTTL),
+		store:         newMemoryStore(),
+		llm:           llm,
+		runner:        runCommand,
+		gitHost:       defaultGitHost,
+		workdirs:      &workdirManager{},
+		limiter:       newRateLimiter(),
+		edits:         newCommentEditor(minCommentEditInterval),
+		locker:        &localLocker{},
+		lockPoll:      issueLockPoll,
+		events:        newEventPublisher(),
 	}
+	bot.settings.Store(&ServerConfig{})
 	bot.registerCommands()
 	return bot
 }
 
+// dispatch runs fn in a new goroutine tracked by the bot's job group and,
+// when ctx belongs to a webhook being handled, by the webhook's scope. A
+// panic in fn is recovered, reported and recorded in the scope.
+func (b *Bot) dispatch(ctx context.Context, fn func()) {
+	scope := eventScopeFrom(ctx)
+	b.jobs.Add(1)
+	if scope != nil {
+		scope.wg.Add(1)
+	}
+	go func() {
+		defer b.jobs.Done()
+		if scope != nil {
+			defer scope.wg.Done()
+		}
+		defer func() {
+			if r := recover(); r != nil {
+				if reported, ok := r.(reportedPanic); ok {
+					r = reported.value
+				} else {
+					stack := debug.Stack()
+					log.Printf("Recovered from a panic in a handler: %v\n%s", r, stack)
+					b.capturePanic(scope.errorContext(), r, stack)
+				}
+				if scope != nil {
+					scope.recordPanic(r)
+				}
+			}
+		}()
+		fn()
+	}()
+}
+
 // registerCommands maps command strings to their handler functions.
 func (b *Bot) registerCommands() {
 	b.commands[CommandGeneratePRD] = b.processIssuePRD
 	b.commands[CommandGenerateSubTask] = b.processIssueSubTasks
 	b.commands[CommandImplementFeature] = b.processImplementFeature
+	b.commands[CommandExplain] = b.processExplain
+	b.commands[CommandPriority] = b.processPriority
+	b.commands[CommandRankBacklog] = b.processRankBacklog
+	b.commands[CommandI18nPlan] = b.processI18nPlan
+	b.commands[CommandRegenSection] = b.processRegenSection
+	b.commands[CommandWizard] = b.processWizard
+	b.commands[CommandAnalyticsEvents] = b.processAnalyticsEvents
+	b.commands[CommandProceed] = b.processProceed
+	b.commands[CommandPurgeData] = b.processPurgeData
+	b.commands[CommandAsk] = b.processAsk
+	b.commands[CommandCapacityPlan] = b.processCapacityPlan
+	b.commands[CommandAPIKey] = b.processAPIKey
+	b.commands[CommandUISpec] = b.processUISpec
+	b.commands[CommandTranslatePRD] = b.processTranslatePRD
+	b.commands[CommandRecordDecision] = b.processRecordDecision
+	b.commands[CommandBudget] = b.processBudget
+	b.commands[CommandRollbackPlan] = b.processRollbackPlan
+	b.commands[CommandCheckBreaking] = b.processCheckBreaking
+	b.commands[CommandComplianceCheck] = b.processComplianceCheck
+	b.commands[CommandCleanup] = b.processCleanup
+	b.commands[CommandArchitectureDoc] = b.processArchitectureDoc
+	b.commands[CommandFeedback] = b.processFeedback
+	b.commands[CommandSDKExamples] = b.processSDKExamples
+	b.commands[CommandRun] = b.processRun
+	b.commands[CommandApprove] = b.processApprove
 }
 
 // --- Main Application ---
 
 func main() {
-	if githubAppID == "" || githubAppPrivateKey == "" || githubAppName == "" || googleAPIKey == "" || githubWebhookSecret == "" {
-		log.Fatal("Missing required environment variables: GITHUB_APP_ID, GITHUB_APP_PRIVATE_KEY, GITHUB_APP_NAME, GOOGLE_API_KEY, GITHUB_WEBHOOK_SECRET")
+	if len(os.Args) > 1 && os.Args[1] == "eval" {
+		if err := runEval(context.Background(), os.Args[2:], os.Getenv, os.Stdout); err != nil {
+			log.Fatalf("Evaluation failed: %v", err)
+		}
+		return
+	}
+
+	cfg, err := loadStartupConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v", err)
+	}
+
+	store, err := OpenStore(cfg.StorePath)
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	var secrets *secretBox
+	if cfg.StoreEncryptionKey != "" {
+		if secrets, err = newSecretBox(cfg.StoreEncryptionKey); err != nil {
+			log.Fatalf("Invalid STORE_ENCRYPTION_KEY: %v", err)
+		}
+	}
+	// Without GitHub credentials, use the App created through /setup, or
+	// serve /setup until one is.
+	if cfg.AppID == 0 && cfg.GitHubToken == "" {
+		app, appSecrets, err := loadSetupApp(store, secrets)
+		if err != nil {
+			log.Fatalf("Failed to load the GitHub App created through /setup: %v", err)
+		}
+		if app == nil {
+			if err := runAppSetup(cfg.Port, cfg.PublicURL, cfg.githubServer(), store, secrets); err != nil {
+				log.Fatalf("Failed to serve /setup: %v", err)
+			}
+			if app, appSecrets, err = loadSetupApp(store, secrets); err != nil || app == nil {
+				log.Fatalf("Failed to load the GitHub App created through /setup: %v", err)
+			}
+		}
+		cfg.applySetupApp(app, appSecrets)
+	}
+	log.Printf("Effective configuration:\n%s", cfg.redacted())
+
+	// validate has checked CHAOS.
+	faults, _ := parseChaos(cfg.Chaos)
+	if faults != nil {
+		log.Printf("WARNING: CHAOS is set, injecting faults: %s. Don't use it in production.", faults)
+		githubTransport = faults.githubTransport(githubTransport)
+		geminiTransport = faults.geminiTransport(geminiTransport)
+	}
+
+	var clients ClientFactory
+	appName := cfg.AppName
+	if cfg.AppID != 0 {
+		key, err := newAppKey(context.Background(), cfg)
+		if err != nil {
+			log.Fatalf("Invalid GITHUB_APP_PRIVATE_KEY: %v", err)
+		}
+		privateKey, err := key.load(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to load the GitHub App private key from %s: %v", key, err)
+		}
+		apps, err := newAppClientFactory(cfg.AppID, privateKey, cfg.githubServer())
+		if err != nil {
+			log.Fatalf("Failed to set up GitHub App authentication: %v", err)
+		}
+		// Keys in files and secrets managers are picked up when rotated.
+		if key.rotates() {
+			go watchAppKey(context.Background(), key, apps, privateKey, cfg.AppKeyRefresh)
+		}
+		clients = apps
+	} else {
+		log.Printf("GitHub App credentials are not set. Running in personal access token mode.")
+		pat := newPATClientFactory(cfg.GitHubToken, cfg.githubServer())
+		if appName == "" {
+			login, err := pat.login(context.Background())
+			if err != nil {
+				log.Fatalf("Failed to look up the GITHUB_TOKEN user: %v", err)
+			}
+			appName = login
+		}
+		clients = pat
 	}
 
-	bot := NewBot(githubAppName)
+	llm := newGenerator(cfg)
+	bot := NewBot(appName, cfg.WebhookSecret, clients, llm)
+	bot.store = store
+	bot.secrets = secrets
+	bot.gitHost = cfg.githubServer().host()
+	if secrets != nil {
+		encrypted := &encryptedStore{Store: store, box: secrets}
+		sealed, err := encrypted.sealExisting()
+		if err != nil {
+			log.Fatalf("Failed to encrypt the store: %v", err)
+		}
+		if sealed > 0 {
+			log.Printf("Encrypted %d documents stored in clear text.", sealed)
+		}
+		bot.store = encrypted
+	}
+	bot.apiToken = cfg.APIToken
+
+	// MODE splits the bot into a webhook frontend, which queues events and
+	// owns the store, and workers, which lease and run them.
+	mode := cfg.Mode
+	bot.workerToken = cfg.WorkerToken
+	if mode == modeFrontend {
+		bot.queue = newJobQueue(bot.settleDelivery)
+		bot.registerQueueHandlers(http.DefaultServeMux)
+	}
+	if mode == modeWorker {
+		frontend := newFrontendClient(cfg.FrontendURL, bot.workerToken)
+		if frontend.lanes, err = parseLanes(cfg.WorkerLanes); err != nil {
+			log.Fatalf("Invalid WORKER_LANES: %v", err)
+		}
+		bot.store = &remoteStore{frontend: frontend}
+		bot.locker = &remoteLocker{frontend: frontend}
+		lanes := frontend.lanes
+		if len(lanes) == 0 {
+			lanes = jobLanes
+		}
+		log.Printf("Running as a worker of %s (lanes: %s).", cfg.FrontendURL, strings.Join(lanes, ", "))
+		go bot.runWorker(context.Background(), frontend)
+	}
+	if bot.flagRules, err = parseFeatureFlags(cfg.FeatureFlags); err != nil {
+		log.Fatalf("Invalid FEATURE_FLAGS: %v", err)
+	}
+	if bot.allowlist, err = parseAllowlist(cfg.Allowlist); err != nil {
+		log.Fatalf("Invalid ALLOWLIST: %v", err)
+	}
+	if cfg.ServerConfigPath != "" {
+		if err := bot.reloadServerConfig(cfg.ServerConfigPath); err != nil {
+			log.Fatalf("Failed to load server config: %v", err)
+		}
+		go bot.watchServerConfig(context.Background(), cfg.ServerConfigPath)
+	}
 	http.HandleFunc("/webhook", bot.handleWebhook)
+	http.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}/artifacts/{kind}", bot.handleArtifactExport)
+	http.HandleFunc("GET /flags", bot.handleFlags)
+	http.HandleFunc("PUT /flags/{name}", bot.handleFlagUpdate)
+	http.HandleFunc("DELETE /flags/{name}", bot.handleFlagUpdate)
+	http.HandleFunc("GET /allowlist", bot.handleAllowlist)
+	http.HandleFunc("PUT /allowlist", bot.handleAllowlist)
+	http.HandleFunc("DELETE /allowlist", bot.handleAllowlist)
+	http.HandleFunc("DELETE /repos/{owner}/{repo}/data", bot.handleDataPurge)
+	http.HandleFunc("GET /deadletters", bot.handleDeadLetters)
+	http.HandleFunc("GET /deadletters/{id}", bot.handleDeadLetter)
+	http.HandleFunc("DELETE /deadletters/{id}", bot.handleDeadLetter)
+	http.HandleFunc("POST /deadletters/{id}/redrive", bot.handleDeadLetter)
+	http.HandleFunc("GET /installations/{id}/apikey", bot.handleInstallationAPIKey)
+	http.HandleFunc("PUT /installations/{id}/apikey", bot.handleInstallationAPIKey)
+	http.HandleFunc("DELETE /installations/{id}/apikey", bot.handleInstallationAPIKey)
+	http.HandleFunc("GET /installations/{id}/budget", bot.handleInstallationBudget)
+	http.HandleFunc("POST /installations/{id}/budget", bot.handleInstallationBudget)
+	http.HandleFunc("DELETE /installations/{id}/budget", bot.handleInstallationBudget)
+	http.HandleFunc("GET /dashboard", bot.handleDashboard)
+	bot.registerViewerHandlers(http.DefaultServeMux)
+	http.HandleFunc("/metrics", handleMetrics)
+
+	if cfg.CommitSigningKey != "" {
+		if bot.signer, err = newCommitSigner(cfg.CommitSigningFormat, cfg.CommitSigningKey, cfg.CommitAuthorName, cfg.CommitAuthorEmail, bot.runner); err != nil {
+			log.Fatalf("Invalid COMMIT_SIGNING_KEY: %v", err)
+		}
+		log.Printf("Signing commits with an %s key.", bot.signer.format)
+	}
+	bot.runner = faults.runner(bot.runner)
+	bot.commitBackend = cfg.CommitBackend
+	if bot.commitBackend == commitBackendAPI && bot.signer != nil {
+		log.Printf("COMMIT_SIGNING_KEY is only used by rebases: commits made through the API are signed by GitHub.")
+	}
+	// validate has checked the sizes.
+	bot.workdirs.root = cfg.WorkspaceRoot
+	bot.workdirs.quota, _ = parseByteSize(cfg.WorkspaceQuota)
+	bot.workdirs.jobLimit, _ = parseByteSize(cfg.WorkspaceJobLimit)
+	if removed, err := bot.workdirs.removeLeaked(); err != nil {
+		log.Printf("Error removing leaked working directories from %s: %v", bot.workdirs.root, err)
+	} else if removed > 0 {
+		log.Printf("Removed %d working directories leaked by a previous run from %s.", removed, bot.workdirs.root)
+	}
+	if cfg.OIDCIssuer != "" {
+		if bot.viewerAuth, err = newOIDCAuth(cfg.OIDCIssuer, cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.ViewerURL, cfg.ViewerEmailDomains, cfg.ViewerSessionSecret); err != nil {
+			log.Fatalf("Failed to set up the viewer sign-in: %v", err)
+		}
+		log.Printf("Serving the artifact viewer at %s/viewer, signing in with %s.", strings.TrimRight(cfg.ViewerURL, "/"), cfg.OIDCIssuer)
+	}
+	if cfg.SlackWebhookURL != "" {
+		bot.slack = newSlackNotifier(cfg.SlackWebhookURL)
+	}
+	if cfg.ErrorReportingDSN != "" {
+		// validate has checked the DSN.
+		bot.reporter, _ = newErrorReporter(cfg.ErrorReportingDSN)
+	}
+	if mode != modeWorker {
+		go bot.reminderLoop(context.Background(), cfg.ReminderInterval)
+		go bot.planLoop(context.Background(), planCheckInterval)
+		go bot.retentionLoop(context.Background(), retentionCheckInterval)
+		go bot.reactionLoop(context.Background(), reactionSyncInterval)
+		go bot.signalsLoop(context.Background(), signalsCheckInterval)
+		go bot.recoverDeliveries()
+	}
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	if cfg.PollRepos != "" {
+		targets, err := parsePollTargets(cfg.PollRepos)
+		if err != nil {
+			log.Fatalf("Invalid POLL_REPOS: %v", err)
+		}
+		log.Printf("Polling %d repositories every %s.", len(targets), cfg.PollInterval)
+		go bot.pollLoop(context.Background(), targets, cfg.PollInterval)
 	}
-	log.Printf("Server listening on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+
+	log.Printf("Server listening on port %s", cfg.Port)
+	log.Fatal(http.ListenAndServe(":"+cfg.Port, bot.recoverHTTP(http.DefaultServeMux)))
 }
 
 // --- Webhook and Authentication ---
 
 func (b *Bot) handleWebhook(w http.ResponseWriter, r *http.Request) {
-	payload, err := github.ValidatePayload(r, []byte(githubWebhookSecret))
+	if !checkWebhookRequest(w, r) {
+		return
+	}
+	payload, err := github.ValidatePayload(r, b.webhookSecret)
+	if isPayloadTooLarge(err) {
+		log.Printf("Rejecting webhook larger than %d MB.", maxWebhookBytes>>20)
+		http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
 	if err != nil {
 		log.Printf("Error validating payload: %v", err)
 		http.Error(w, "Invalid payload", http.StatusUnauthorized)
 		return
 	}
 
-	event, err := github.ParseWebHook(github.WebHookType(r), payload)
-	if err != nil {
-		log.Printf("Error parsing webhook: %v", err)
+	eventType := github.WebHookType(r)
+	if !b.webhookAllowed(payload) {
+		// Acknowledged so GitHub doesn't retry, but neither stored nor handled.
+		log.Printf("Ignoring %s event from a repository or installation that isn't allowlisted.", eventType)
+		ignoredWebhooksTotal.Inc(eventType)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	job := newQueuedJob(r.Header.Get("X-GitHub-Delivery"), eventType, payload)
+	job.Lane = b.jobLane(eventType, payload)
+	if b.queue == nil {
+		job.Attempts = 1
+	}
+	// The payload is persisted before it is handled, so it survives a crash.
+	if err := b.store.Put(bucketWebhooks, job.ID, job); err != nil {
+		log.Printf("Error persisting webhook %s: %v", job.ID, err)
+		http.Error(w, "Failed to persist webhook", http.StatusServiceUnavailable)
+		return
+	}
+	if b.queue != nil {
+		// Frontend mode: workers lease the event from the queue.
+		b.queue.enqueue(job)
+		log.Printf("Queued %s event as job %s.", eventType, job.ID)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err := b.processDelivery(job); errors.Is(err, errInvalidEvent) {
 		http.Error(w, "Error parsing webhook", http.StatusBadRequest)
 		return
+	} else if err != nil {
+		http.Error(w, "Failed to create client", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// errInvalidEvent reports a webhook payload that can't be parsed.
+var errInvalidEvent = errors.New("invalid webhook event")
+
+// handleEvent handles a verified webhook of type eventType, dispatching any
+// work it triggers. It is shared by the webhook endpoint and queue workers.
+func (b *Bot) handleEvent(ctx context.Context, eventType string, payload []byte) error {
+	// Queued and replayed events are checked again: the allowlist may have
+	// changed since, or differ on the worker.
+	if !b.webhookAllowed(payload) {
+		log.Printf("Ignoring %s event from a repository or installation that isn't allowlisted.", eventType)
+		ignoredWebhooksTotal.Inc(eventType)
+		return nil
+	}
+	if eventType == "sub_issues" {
+		return b.handleSubIssues(ctx, payload)
+	}
+	event, err := github.ParseWebHook(eventType, payload)
+	if err != nil {
+		log.Printf("Error parsing webhook: %v", err)
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
 	}
 
 	log.Printf("Successfully parsed webhook event of type: %T", event)
@@ -104,6 +490,8 @@ func (b *Bot) handleWebhook(w http.ResponseWriter, r *http.Request) {
 	var repo *github.Repository
 	var action string
 	var commentBody string
+	var commentID int64
+	var sender *github.User
 
 	switch e := event.(type) {
 	case *github.IssuesEvent:
@@ -111,244 +499,646 @@ func (b *Bot) handleWebhook(w http.ResponseWriter, r *http.Request) {
 		issue = e.GetIssue()
 		repo = e.GetRepo()
 		action = e.GetAction()
+		b.rememberInstallation(repo, installationID)
 		if action == "opened" {
-			log.Printf("New issue opened #%d. Triggering PRD generation.", issue.GetNumber())
-			client, err := createGitHubClient(installationID)
+			client, err := b.clients.Client(installationID)
 			if err != nil {
 				log.Printf("Error creating GitHub client for new issue: %v", err)
-				return
+				return nil
 			}
-			go b.processIssuePRD(context.Background(), client, issue, repo, installationID)
+			b.handleIssueOpened(ctx, client, issue, repo, installationID)
 		}
-		return // Return after handling
+		if action == "assigned" {
+			client, err := b.clients.Client(installationID)
+			if err != nil {
+				log.Printf("Error creating GitHub client for assigned issue: %v", err)
+				return nil
+			}
+			b.handleAutoImplement(ctx, client, issue, repo, installationID, e.GetSender(), e.GetAssignee(), nil)
+			b.handleIssueAssigned(ctx, client, issue, repo, installationID, e.GetAssignee())
+		}
+		if action == "labeled" {
+			client, err := b.clients.Client(installationID)
+			if err != nil {
+				log.Printf("Error creating GitHub client for labeled issue: %v", err)
+				return nil
+			}
+			b.handleAutoImplement(ctx, client, issue, repo, installationID, e.GetSender(), nil, e.GetLabel())
+		}
+		if action == "closed" {
+			client, err := b.clients.Client(installationID)
+			if err != nil {
+				log.Printf("Error creating GitHub client for closed issue: %v", err)
+				return nil
+			}
+			b.handleIssueClosed(ctx, client, issue, repo, installationID)
+		}
+		switch action {
+		case "closed", "reopened", "labeled", "unlabeled":
+			if client, err := b.clients.Client(installationID); err == nil {
+				b.handleEpicSubIssue(ctx, client, issue, repo, e.GetLabel())
+			}
+		}
+		switch action {
+		case "reopened":
+			// The issue keeps its PRD; only "opened" generates one.
+			log.Printf("Issue #%d in %s was reopened; keeping its documents.", issue.GetNumber(), repo.GetFullName())
+		case "transferred":
+			b.handleIssueTransferred(ctx, payload, issue, repo)
+		case "converted_to_discussion":
+			b.handleIssueConverted(ctx, issue, repo)
+		}
+		return nil
 	case *github.IssueCommentEvent:
 		installationID = e.GetInstallation().GetID()
 		issue = e.GetIssue()
 		repo = e.GetRepo()
 		action = e.GetAction()
 		commentBody = e.GetComment().GetBody()
+		commentID = e.GetComment().GetID()
+		sender = e.GetSender()
+	case *github.PullRequestReviewCommentEvent:
+		b.handleReviewCommentQuestion(ctx, e)
+		return nil
+	case *github.PushEvent:
+		b.handlePush(ctx, e)
+		return nil
+	case *github.PullRequestEvent:
+		b.handlePullRequestClosed(e)
+		b.handlePullRequestMerged(ctx, e)
+		return nil
 	default:
 		log.Printf("Ignoring event of type %T", event)
-		w.WriteHeader(http.StatusOK)
-		return
+		return nil
 	}
 
 	if action != "created" {
 		log.Printf("Ignoring non-created issue comment event.")
-		w.WriteHeader(http.StatusOK)
-		return
+		return nil
 	}
+	return b.handleComment(ctx, issue, repo, installationID, sender, commentID, commentBody)
+}
 
-	command, mentioned := b.parseComment(commentBody)
+// handleComment runs the command a new comment on issue mentions the bot
+// with, or records it as an answer to the issue's wizard. It is shared by
+// comment webhooks and polling, so both go through the same checks.
+func (b *Bot) handleComment(ctx context.Context, issue *github.Issue, repo *github.Repository, installationID int64, sender *github.User, commentID int64, commentBody string) error {
+	command, args, mentioned := b.parseComment(commentBody)
 	if !mentioned {
+		if client, err := b.clients.Client(installationID); err == nil && b.handleWizardAnswer(ctx, client, issue, repo, installationID, sender, commentBody) {
+			log.Printf("Recorded wizard answer on issue #%d.", issue.GetNumber())
+			return nil
+		}
 		log.Printf("Bot was not mentioned correctly in comment.")
-		w.WriteHeader(http.StatusOK)
-		return
+		return nil
 	}
 
 	handler, exists := b.commands[command]
+	if !exists && b.isQuestionForPullRequest(issue, repo) {
+		command, args, handler, exists = CommandAsk, append([]string{command}, args...), b.commands[CommandAsk], true
+	}
 	if !exists {
 		log.Printf("Bot was mentioned, but command '%s' is not recognized.", command)
-		w.WriteHeader(http.StatusOK)
-		return
+		return nil
 	}
 
-	log.Printf("Recognized command '%s' on issue #%d. Dispatching handler.", issue.GetNumber(), command)
-	client, err := createGitHubClient(installationID)
+	if !b.installationMatches(context.Background(), repo, installationID) {
+		rejectedCommandsTotal.Inc("installation")
+		log.Printf("Rejecting '%s' on issue #%d in %s: the webhook's installation %d doesn't cover the repository.", command, issue.GetNumber(), repo.GetFullName(), installationID)
+		return nil
+	}
+	b.rememberInstallation(repo, installationID)
+
+	log.Printf("Recognized command '%s' on issue #%d. Dispatching handler.", command, issue.GetNumber())
+	client, err := b.clients.Client(installationID)
 	if err != nil {
 		log.Printf("Error creating GitHub client for comment: %v", err)
-		http.Error(w, "Failed to create client", http.StatusInternalServerError)
-		return
+		return fmt.Errorf("creating GitHub client: %w", err)
+	}
+	if command == CommandAPIKey && len(args) > 1 {
+		// The comment may carry an API key: remove it before running the command.
+		b.deleteSecretComment(client, repo, commentID)
 	}
 
-	go handler(context.Background(), client, issue, repo, installationID)
-	w.WriteHeader(http.StatusOK)
+	b.dispatchCommand(ctx, client, handler, command, args, issue, repo, installationID, sender)
+	return nil
 }
 
-func createGitHubClient(installationID int64) (*github.Client, error) {
-	appID, err := strconv.ParseInt(githubAppID, 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf("invalid GITHUB_APP_ID: %w", err)
+// handleIssueOpened runs the commands listed at the bottom of a newly opened
+// issue's body or, when it lists none, generates a PRD unless the repository
+// turned automatic generation off.
+func (b *Bot) handleIssueOpened(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64) {
+	log.Printf("New issue opened #%d. Triggering PRD generation.", issue.GetNumber())
+	b.dispatch(ctx, func() {
+		ctx := b.withInstallation(context.Background(), installationID)
+		if commands, _ := parseBodyCommands(issue.GetBody()); len(commands) > 0 {
+			if b.hasPRD(ctx, client, repo, issue.GetNumber()) {
+				log.Printf("Issue #%d in %s already has a PRD. Skipping the commands of its body.", issue.GetNumber(), repo.GetFullName())
+				return
+			}
+			b.runBodyCommands(ctx, client, commands, issue, repo, installationID)
+			return
+		}
+		if !b.flagEnabled(FlagAutoPRD, installationID, repo.GetFullName()) {
+			log.Printf("The %s feature flag is off for %s. Skipping issue #%d.", FlagAutoPRD, repo.GetFullName(), issue.GetNumber())
+			return
+		}
+		cfg := b.repoConfig(ctx, client, repo)
+		if !cfg.AutoPRDEnabled() {
+			log.Printf("Automatic PRD generation is disabled for %s. Skipping issue #%d.", repo.GetFullName(), issue.GetNumber())
+			return
+		}
+		if b.hasPRD(ctx, client, repo, issue.GetNumber()) {
+			log.Printf("Issue #%d in %s already has a PRD. Skipping it.", issue.GetNumber(), repo.GetFullName())
+			return
+		}
+		if _, ok := b.checkBudget(ctx, installationID); !ok {
+			log.Printf("Installation %d is over its monthly budget. Skipping the PRD of issue #%d in %s.", installationID, issue.GetNumber(), repo.GetFullName())
+			return
+		}
+		b.processIssuePRD(b.withFeedback(withPrompts(ctx, cfg, repo), cfg, repo), client, issue, repo, installationID, nil)
+	})
+}
+
+// dispatchCommand runs handler for a command requested by sender, unless the
+// repository configuration disables the command.
+func (b *Bot) dispatchCommand(ctx context.Context, client *github.Client, handler commandHandler, command string, args []string, issue *github.Issue, repo *github.Repository, installationID int64, sender *github.User) {
+	b.dispatch(ctx, func() {
+		b.runCommandHandler(client, handler, command, args, issue, repo, installationID, sender)
+	})
+}
+
+// runCommandHandler is dispatchCommand for callers already running in a
+// dispatched goroutine. It reports whether the command ran without
+// reporting a failure.
+func (b *Bot) runCommandHandler(client *github.Client, handler commandHandler, command string, args []string, issue *github.Issue, repo *github.Repository, installationID int64, sender *github.User) bool {
+	owner, name, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	ctx := withErrorTags(context.Background(), "command", command, "repo", repo.GetFullName(), "issue", strconv.Itoa(issueNum))
+	ctx = b.withInstallation(withSender(ctx, sender), installationID)
+	// Panics are reported here, where the job they failed is known.
+	defer func() {
+		if r := recover(); r != nil {
+			stack := debug.Stack()
+			log.Printf("Recovered from a panic in '%s' on issue #%d in %s: %v\n%s", command, issueNum, repo.GetFullName(), r, stack)
+			b.capturePanic(ctx, r, stack)
+			panic(reportedPanic{r})
+		}
+	}()
+	if !b.allowed(installationID, repo.GetFullName()) {
+		// Polled comments, pipelines and auto-proceeding plans don't pass
+		// the webhook's check.
+		rejectedCommandsTotal.Inc("allowlist")
+		log.Printf("Ignoring '%s' on issue #%d in %s, which isn't allowlisted.", command, issueNum, repo.GetFullName())
+		return false
 	}
-	privateKeyBytes, err := base64.StdEncoding.DecodeString(githubAppPrivateKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode base64 private key: %w", err)
+	if commands, body := parseBodyCommands(issue.GetBody()); len(commands) > 0 {
+		issue = withoutBodyCommands(issue, body)
 	}
-	itr, err := ghinstallation.New(http.DefaultTransport, appID, installationID, privateKeyBytes)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create installation transport: %w", err)
+	// Bots are dropped before anything replies to them or counts against
+	// the rate limit.
+	cfg := b.repoConfig(ctx, client, repo)
+	if !cfg.SenderAllowed(sender) {
+		// Answering would let bots drive the bot, or loop with it.
+		rejectedCommandsTotal.Inc("bot")
+		log.Printf("Ignoring '%s' on issue #%d in %s from the bot %s, which allowed_bots doesn't list.", command, issueNum, repo.GetFullName(), sender.GetLogin())
+		return false
 	}
-	return github.NewClient(&http.Client{Transport: itr}), nil
-}
-
-func getInstallationToken(ctx context.Context, installationID int64) (string, error) {
-	appID, err := strconv.ParseInt(githubAppID, 10, 64)
-	if err != nil {
-		return "", fmt.Errorf("invalid GITHUB_APP_ID: %w", err)
+	settings := b.serverConfig()
+	if slices.Contains(settings.DisabledCommands, command) {
+		log.Printf("Command '%s' is disabled on this server.", command)
+		b.postComment(ctx, client, owner, name, issueNum, fmt.Sprintf("The `%s` command is currently disabled on this bot.", command))
+		return false
 	}
-	privateKeyBytes, err := base64.StdEncoding.DecodeString(githubAppPrivateKey)
-	if err != nil {
-		return "", fmt.Errorf("failed to decode base64 private key: %w", err)
+	if !b.limiter.allow(repo.GetFullName(), settings.RateLimit.CommandsPerHour) {
+		log.Printf("Rate limit reached for %s, rejecting '%s'.", repo.GetFullName(), command)
+		msg := fmt.Sprintf("This repository has reached its limit of %d commands per hour. Please try `%s` again later.", settings.RateLimit.CommandsPerHour, command)
+		b.postComment(ctx, client, owner, name, issueNum, msg)
+		return false
 	}
-	itr, err := ghinstallation.New(http.DefaultTransport, appID, installationID, privateKeyBytes)
-	if err != nil {
-		return "", fmt.Errorf("failed to create installation transport: %w", err)
+	if !cfg.CommandEnabled(command) {
+		log.Printf("Command '%s' is disabled for %s.", command, repo.GetFullName())
+		msg := fmt.Sprintf("The `%s` command is disabled for this repository by its `%s` configuration.", command, RepoConfigPath)
+		b.postComment(ctx, client, owner, name, issueNum, msg)
+		return false
 	}
-	token, err := itr.Token(ctx)
-	if err != nil {
-		return "", fmt.Errorf("failed to get installation token: %w", err)
+	if issue.GetLocked() {
+		log.Printf("Issue #%d in %s is locked, declining '%s'.", issueNum, repo.GetFullName(), command)
+		b.reportFailure(ctx, client, owner, name, issueNum, fmt.Sprintf("run `%s`", command), "This issue's conversation is locked", fmt.Errorf("%w: #%d", ErrIssueLocked, issueNum))
+		return false
 	}
-	return token, nil
+	if !slices.Contains(budgetExemptCommands, command) {
+		if status, ok := b.checkBudget(ctx, installationID); !ok {
+			log.Printf("Installation %d is over its monthly budget, declining '%s'.", installationID, command)
+			b.postComment(ctx, client, owner, name, issueNum, b.budgetExceededMessage(command, status))
+			return false
+		}
+	}
+	ctx = b.withFeedback(withPrompts(ctx, cfg, repo), cfg, repo)
+	if slices.Contains(writeCommands, command) {
+		if err := b.preflight(ctx, client, repo, installationID); err != nil {
+			log.Printf("Pre-flight checks failed for '%s' in %s: %v", command, repo.GetFullName(), err)
+			b.reportFailure(ctx, client, owner, name, issueNum, fmt.Sprintf("run `%s`", command), preflightReason(err), err)
+			return false
+		}
+	}
+	b.recordCommand(owner, name, command)
+	record := &jobRecord{Command: command, Owner: owner, Repo: name, Issue: issueNum, Sender: sender.GetLogin(), CreatedAt: time.Now().UTC()}
+	outcome := &jobOutcome{}
+	handler(withJobOutcome(ctx, outcome), client, issue, repo, installationID, args)
+	b.recordJob(record, outcome)
+	if cfg.MinimizeOutdatedEnabled() {
+		b.minimizeSuperseded(ctx, client, repo, issueNum, outcome.postedComments())
+	}
+	return !outcome.failed()
 }
 
 // --- Command Implementations ---
 
-func (b *Bot) processIssuePRD(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64) {
+func (b *Bot) processIssuePRD(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
 	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
 	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandGeneratePRD, issueNum, repoOwner, repoName)
 
-	if prd, _ := findPRDComment(ctx, client, repoOwner, repoName, issueNum); prd != nil {
+	// --full replaces a mini PRD with a full one.
+	full := slices.Contains(args, fullPRDFlag)
+	if prd, _ := b.findPRDComment(ctx, client, repoOwner, repoName, issueNum); prd != nil && !(full && isMiniPRD(prd.GetBody())) {
 		log.Printf("PRD already exists for issue #%d. Skipping generation.", issueNum)
 		return
 	}
 
-	readme, _, _, err := client.Repositories.GetContents(ctx, repoOwner, repoName, "README.md", nil)
-	if err != nil {
-		log.Printf("Error getting README for %s/%s: %v", repoOwner, repoName, err)
+	fail := func(reason string, err error) {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "generate a PRD", reason, err)
+	}
+
+	cfg := b.repoConfig(ctx, client, repo)
+	if reason, small := cfg.FastPath.smallIssue(issue); small && !full {
+		b.processFastPath(ctx, client, issue, repo, cfg.FastPath, reason)
 		return
 	}
-	readmeContent, err := readme.GetContent()
+	ctx, readmeContent, err := b.specContext(ctx, client, repo, cfg.LongContext)
 	if err != nil {
-		log.Printf("Error decoding README content for %s/%s: %v", repoOwner, repoName, err)
+		fail("Could not read the repository README", err)
 		return
 	}
 
-	prdContent, err := generatePRD(issue.GetTitle(), issue.GetBody(), readmeContent)
+	start := time.Now()
+	related := b.findRelatedWork(ctx, client, repo, issue)
+	prior := b.findPriorPRDs(ctx, repo, issue, cfg.PriorPRDCount())
+	prdContent, err := generatePRD(ctx, b.llm, issue.GetTitle(), issue.GetBody(), readmeContent, cfg.Language, related, prior)
 	if err != nil {
-		log.Printf("Error generating PRD for issue #%d: %v", issueNum, err)
+		fail("Could not generate the PRD", err)
 		return
 	}
-
-	b.postComment(ctx, client, repoOwner, repoName, issueNum, prdContent)
+	b.recordPRD(repoOwner, repoName, time.Since(start))
+	prdContent = b.addExecutiveSummary(ctx, prdContent, cfg.PRDLayout)
+	prdContent = b.addReviewersFooter(ctx, client, repo, issue, prdContent, cfg.Stakeholders)
+	prdContent = b.versionNewPRD(repoOwner, repoName, issueNum, prdContent)
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactPRD, prdContent)
+	b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, prdContent, comment)
+	b.publishEvent(EventPRDCreated, repoOwner, repoName, issueNum, comment.GetHTMLURL(), map[string]any{"title": issue.GetTitle()})
 }
 
-func (b *Bot) processIssueSubTasks(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64) {
+// processIssueSubTasks breaks the PRD down into a checklist. When the issue
+// already has one, it is updated for the current PRD instead, unless args
+// ask for a fresh checklist.
+func (b *Bot) processIssueSubTasks(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
 	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
 	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandGenerateSubTask, issueNum, repoOwner, repoName)
 
-	prdComment, err := findPRDComment(ctx, client, repoOwner, repoName, issueNum)
-	if err != nil || prdComment == nil {
+	version, args := prdVersionArg(args)
+	prdComment, ok := b.findPRDVersion(ctx, client, repoOwner, repoName, issueNum, version)
+	if !ok {
+		return
+	}
+	if prdComment == nil {
 		log.Printf("No PRD comment found for issue #%d. Aborting sub-task generation.", issueNum)
 		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to generate sub-tasks from. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
 		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
 		return
 	}
 
-	subTasks, err := generateSubTasks(prdComment.GetBody())
+	if !slices.Contains(args, subTasksFreshFlag) {
+		previous, err := b.findSubTasksComment(ctx, client, repoOwner, repoName, issueNum)
+		if err != nil {
+			log.Printf("Error looking for the previous sub-tasks of issue #%d: %v", issueNum, err)
+		} else if previous != nil && len(parseChecklist(previous.GetBody())) > 0 {
+			b.updateSubTasks(ctx, client, issue, repo, prdComment.GetBody(), previous)
+			return
+		}
+	}
+
+	b.writeSubTasks(ctx, client, issue, repo, prdComment.GetBody(), "")
+}
+
+// writeSubTasks posts the sub-tasks of source, the PRD or, for small issues,
+// the issue itself, followed by note when there is one.
+func (b *Bot) writeSubTasks(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, source, note string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	areas := b.retrieveCodeAreas(ctx, client, repo, source, b.repoConfig(ctx, client, repo).SubTaskContextFiles())
+	subTasks, err := generateSubTasks(ctx, b.llm, source, areas)
 	if err != nil {
-		log.Printf("Error generating sub-tasks for issue #%d: %v", issueNum, err)
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "generate sub-tasks", "Could not generate the sub-tasks", err)
 		return
 	}
+	if len(areas) > 0 {
+		subTasks += "\n\n" + formatCodeAreas(areas)
+	}
 
-	b.postComment(ctx, client, repoOwner, repoName, issueNum, subTasks)
+	if cfg := b.repoConfig(ctx, client, repo).SubTaskOwners; cfg.enabled() {
+		subTasks = b.addTaskOwners(ctx, client, repo, issueNum, source, subTasks, cfg)
+	}
+	if note != "" {
+		subTasks += "\n\n" + note
+	}
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactSubTasks, subTasks)
+	b.saveArtifact(ArtifactSubTasks, repoOwner, repoName, issue, subTasks, comment)
+}
+
+func (b *Bot) processImplementFeature(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args []string) {
+	b.implementFeature(ctx, client, issue, repo, installationID, args, "")
 }
 
-func (b *Bot) processImplementFeature(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64) {
+// implementFeature edits the files named in the issue and opens a pull
+// request. plan is the approved implementation plan; when it is empty and
+// the repository wants plans reviewed first, only the plan is posted.
+func (b *Bot) implementFeature(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args []string, plan string) {
 	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
 	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandImplementFeature, issueNum, repoOwner, repoName)
 
 	// Helper function for posting failure comments
 	fail := func(reason string, err error) {
-		log.Printf("Operation failed for issue #%d: %s: %v", issueNum, reason, err)
-		errMsg := fmt.Sprintf("I failed to implement the feature for issue #%d. **Reason:** %s.", issueNum, reason)
-		b.postComment(ctx, client, repoOwner, repoName, issueNum, errMsg)
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "implement the feature", reason, err)
 	}
 
 	filesToModify := parseFilePathsFromIssue(issue.GetBody())
 	if len(filesToModify) == 0 {
-		fail("No files to modify. Please specify the files in the issue body using the format `Files: file1.go, path/to/file2.go`", nil)
+		fail("No files to modify", ErrNoFilesSpecified)
 		return
 	}
 
-	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Alright, I'm on it! I will try to implement the feature for issue #%d. Give me a few minutes...", issueNum))
-
-	tempDir, err := os.MkdirTemp("", fmt.Sprintf("repo-%d-*", issueNum))
-	if err != nil {
-		fail("Could not create temporary directory", err)
+	requested, rest, ok := parseBaseFlag(args)
+	if !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Please name the branch to work on, e.g. `@%s %s %s release/1.x`.", b.appName, CommandImplementFeature, baseFlag))
 		return
 	}
-	defer os.RemoveAll(tempDir)
-	log.Printf("Created temporary directory: %s", tempDir)
-
-	token, err := getInstallationToken(ctx, installationID)
+	base, fallback, err := resolveBase(ctx, client, repo, requested)
 	if err != nil {
-		fail("Could not get installation token", err)
+		fail(fmt.Sprintf("Could not read the branch `%s`", requested), err)
 		return
 	}
+	if fallback != "" {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fallback)
+		// A plan approved later shouldn't check the missing branch again.
+		args = rest
+	}
 
-	cloneURL := fmt.Sprintf("https://x-access-token:%s@github.com/%s/%s.git", token, repoOwner, repoName)
-	if _, err := runCommand(tempDir, "git", "clone", cloneURL, "."); err != nil {
-		fail("Could not clone repository", err)
+	// Replies to a split plan follow a change the user already reviewed.
+	splitReply := len(rest) > 0 && (rest[0] == splitModeSplit || rest[0] == splitModeSingle)
+	if preview := b.repoConfig(ctx, client, repo).PlanPreview; plan == "" && preview.enabled() && !splitReply {
+		b.postImplementationPlan(ctx, client, issue, repo, installationID, args, filesToModify, preview)
+		return
+	}
+	execution := b.repoConfig(ctx, client, repo).Execution
+	if execution.dispatched() {
+		b.dispatchImplementation(ctx, client, issue, repo, base, filesToModify, plan, execution)
 		return
 	}
 
-	branchName := fmt.Sprintf("feature/issue-%d-%d", issueNum, time.Now().Unix())
-	if _, err := runCommand(tempDir, "git", "checkout", "-b", branchName); err != nil {
-		fail("Could not create new branch", err)
+	// Concurrent runs on the issue would race for its branch and pull request.
+	lock := b.lockIssue(ctx, client, repo, issueNum, execution)
+	if lock == nil {
 		return
 	}
+	defer lock.release()
 
-	prompt := fmt.Sprintf("As a senior Go developer, please modify the code to implement the feature described in the following GitHub issue.\n\n**Issue Title:** %s\n\n**Issue Body:**\n%s\n\nYour response should only be the modified code, without any additional explanation.", issue.GetTitle(), issue.GetBody())
-	geminiArgs := []string{prompt, "-y", "-a"}
-	geminiArgs = append(geminiArgs, filesToModify...)
+	progress := b.startProgress(ctx, client, repo, installationID, issueNum, fmt.Sprintf("Alright, I'm on it! I will try to implement the feature for issue #%d. Give me a few minutes...", issueNum))
+	defer progress.finish(ctx)
+	lock.link(progress.comment)
+
+	// Repositories too large to clone only have the files to change fetched.
+	var only []string
+	if execution.filesOnly() {
+		only = filesToModify
+	}
+	ws, err := b.openWorkspace(ctx, client, repo, base, installationID, issueNum, only)
+	if err != nil {
+		fail("Could not clone repository", err)
+		return
+	}
+	defer ws.close()
+	if only != nil {
+		progress.step("Fetched `%s` from `%s/%s` at `%s`", strings.Join(only, "`, `"), repoOwner, repoName, base)
+	} else {
+		progress.step("Cloned `%s/%s` at `%s`", repoOwner, repoName, base)
+	}
 
-	if _, err := runCommand(tempDir, "gemini", geminiArgs...); err != nil {
-		fail("Gemini CLI failed to modify the files", err)
+	naming := b.repoConfig(ctx, client, repo).Naming
+	branchName, lease := b.implementationBranch(ctx, client, repo, ws, issue, base, naming)
+	// Explain protection rules up front rather than failing on the push.
+	rules := b.loadBranchRules(ctx, client, repo, base, branchName)
+	if rules.CreationBlocked {
+		fail(fmt.Sprintf("The branch `%s` can't be created in this repository", branchName), rules.creationError(branchName))
 		return
 	}
 
-	if _, err := runCommand(tempDir, "git", "config", "user.name", b.appName); err != nil {
-		fail("Could not set git user name", err)
+	model := editModel(ctx, client, repo, b.repoConfig(ctx, client, repo).LongContext)
+	generator, agent := b.llm.(ToolGenerator)
+	agent = agent && execution.agentEditor()
+	// edit has the model change the checkout; failures are the errors of
+	// the checks its previous edit failed, for it to fix.
+	edit := func(ctx context.Context, failures string) error {
+		if agent {
+			if model != "" {
+				ctx = withModel(ctx, model)
+			}
+			return b.runAgentEdit(ctx, generator, ws.dir(), issue, filesToModify, plan, failures, b.repoConfig(ctx, client, repo).Tests)
+		}
+		return b.runGeminiEdit(ws.dir(), issue, filesToModify, plan, failures, model)
+	}
+	if err := edit(ctx, ""); err != nil {
+		if agent {
+			fail("The model failed to modify the files", err)
+		} else {
+			fail("Gemini CLI failed to modify the files", err)
+		}
 		return
 	}
-	if _, err := runCommand(tempDir, "git", "config", "user.email", fmt.Sprintf("%s@users.noreply.github.com", b.appName)); err != nil {
-		fail("Could not set git user email", err)
+	if err := b.workdirs.check(ws.dir()); err != nil {
+		fail("The working copy grew too large", err)
 		return
 	}
+	progress.step("Edited `%s`", strings.Join(filesToModify, "`, `"))
+	b.runFormatters(ctx, client, repo, ws, filesToModify, progress)
+	tests := fmt.Sprintf("_Only the files to change were fetched (`execution.checkout: %s`), so the edit didn't see the rest of the repository, and no formatters, checks, tests or container builds ran._", checkoutFiles)
+	if only == nil {
+		tests = b.validateChange(ctx, client, repo, ws, issueNum, func(ctx context.Context, failures string) error {
+			if err := edit(ctx, failures); err != nil {
+				return err
+			}
+			if err := b.workdirs.check(ws.dir()); err != nil {
+				return err
+			}
+			b.runFormatters(ctx, client, repo, ws, filesToModify, progress)
+			return nil
+		}, progress)
+	}
 
-	if _, err := runCommand(tempDir, "git", "add", "."); err != nil {
+	diff, stats, err := ws.changes()
+	if err != nil {
 		fail("Could not add files to git", err)
 		return
 	}
 
-	commitMsg := fmt.Sprintf("feat: Implement feature for #%d\n\nThis commit was automatically generated by the Gemini bot based on the issue.", issueNum)
-	if _, err := runCommand(tempDir, "git", "commit", "-m", commitMsg); err != nil {
-		fail("Could not commit changes", err)
+	// New configuration the change reads goes into the PR so deployers set it.
+	configRefs := scanConfigReferences(diff)
+	configChecklist := formatConfigChecklist(configRefs)
+	// The model's self-assessment tells reviewers what to verify.
+	assessment := b.assessChange(ctx, issue, diff, plan)
+	// Checks derived from the PRD tell them how.
+	checklist := b.reviewChecklist(ctx, client, repo, issue, diff, stats)
+
+	// Changes over the repository's size budget are split into smaller pull
+	// requests, after confirmation unless the repository opts out of it.
+	budget := b.repoConfig(ctx, client, repo).PRSize
+	mode := budget.mode()
+	if splitReply {
+		mode = rest[0]
+	}
+	var groups []splitGroup
+	if mode != splitModeSingle && len(stats) > 0 && budget.exceeded(len(stats), totalLines(stats)) {
+		groups = planSplit(ctx, b.llm, issue, stats, budget)
+		progress.step("Planned a split into %d pull requests", len(groups))
+		if mode == splitModeConfirm {
+			b.postSplitPlan(ctx, client, repo, issueNum, base, groups, stats, budget)
+			return
+		}
+	}
+
+	if len(groups) > 0 {
+		pulls, err := b.openSplitPullRequests(ctx, client, repo, issue, ws, base, branchName, naming, groups, configRefs, strings.Join(slices.DeleteFunc([]string{assessment, checklist, tests}, func(s string) bool { return s == "" }), "\n\n"), progress)
+		if err != nil {
+			fail(fmt.Sprintf("Could not open part %d of %d of the split pull requests", len(pulls)+1, len(groups)), err)
+			return
+		}
+		var links []string
+		for _, pr := range pulls {
+			links = append(links, "- "+pr.GetHTMLURL())
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've split the change for issue #%d into %d Pull Requests, to be merged in order:\n\n%s%s", issueNum, len(pulls), strings.Join(links, "\n"), rules.mergeNote()))
 		return
 	}
 
-	if _, err := runCommand(tempDir, "git", "push", "origin", branchName); err != nil {
+	headSHA, err := ws.publish(branchName, naming.commitMessage(issue, "", time.Now()), lease)
+	if err != nil {
 		fail("Could not push changes to remote", err)
 		return
 	}
+	progress.step("Pushed branch `%s`", branchName)
 
 	prTitle := fmt.Sprintf("Implement Feature: %s", issue.GetTitle())
 	prBody := fmt.Sprintf("This PR implements the feature requested in #%d. It was automatically generated by @%s.", issueNum, b.appName)
+	if plan != "" {
+		prBody += fmt.Sprintf("\n\n<details>\n<summary>Approved implementation plan</summary>\n\n%s\n</details>", strings.TrimSpace(plan))
+	}
+	if assessment != "" {
+		prBody += "\n\n" + assessment
+	}
+	if checklist != "" {
+		prBody += "\n\n" + checklist
+	}
+	if tests != "" {
+		prBody += "\n\n" + tests
+	}
+	if configChecklist != "" {
+		prBody += "\n\n" + configChecklist
+	}
+	if requirements := rules.formatMergeRequirements(); requirements != "" {
+		prBody += "\n\n" + requirements
+	}
 	newPR := &github.NewPullRequest{
 		Title: &prTitle,
 		Head:  &branchName,
-		Base:  repo.DefaultBranch,
+		Base:  &base,
 		Body:  &prBody,
 	}
 
-	pr, _, err := client.PullRequests.Create(ctx, repoOwner, repoName, newPR)
+	pr, updated, err := openOrUpdatePullRequest(ctx, client, repoOwner, repoName, newPR)
 	if err != nil {
 		fail("Could not create Pull Request", err)
 		return
 	}
+	if updated {
+		progress.step("Updated pull request #%d", pr.GetNumber())
+	} else {
+		progress.step("Opened pull request #%d", pr.GetNumber())
+		b.publishEvent(EventPROpened, repoOwner, repoName, issueNum, pr.GetHTMLURL(), map[string]any{"number": pr.GetNumber(), "branch": branchName, "base": base})
+	}
+
+	b.recordPullRequest(&botPullRequest{
+		Owner:   repoOwner,
+		Repo:    repoName,
+		Number:  pr.GetNumber(),
+		Issue:   issueNum,
+		Branch:  branchName,
+		Base:    base,
+		Files:   filesToModify,
+		Plan:    plan,
+		HeadSHA: headSHA,
+	})
 
 	finalComment := fmt.Sprintf("I've created a Pull Request for issue #%d. You can review it here: %s", issueNum, pr.GetHTMLURL())
-	b.postComment(ctx, client, repoOwner, repoName, issueNum, finalComment)
+	switch {
+	case updated:
+		finalComment = fmt.Sprintf("I've updated the open Pull Request for issue #%d with the new changes. You can review it here: %s", issueNum, pr.GetHTMLURL())
+	case base != repo.GetDefaultBranch():
+		finalComment = fmt.Sprintf("I've created a Pull Request against `%s` for issue #%d. You can review it here: %s", base, issueNum, pr.GetHTMLURL())
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, finalComment+rules.mergeNote())
+}
+
+// runGeminiEdit asks the Gemini CLI to implement issue by editing files in
+// the checkout at dir, following plan when one was approved and fixing
+// failures, the errors of its previous edit, when given. A non-empty model
+// replaces the CLI's default model.
+func (b *Bot) runGeminiEdit(dir string, issue *github.Issue, files []string, plan, failures, model string) error {
+	var approved string
+	if plan != "" {
+		approved = fmt.Sprintf("\n\nFollow this approved implementation plan:\n%s", plan)
+	}
+	approved += fixRequest(failures)
+	prompt := fmt.Sprintf("As a senior Go developer, please modify the code to implement the feature described in the following GitHub issue.\n\n**Issue Title:** %s\n\n**Issue Body:**\n%s%s\n\nYour response should only be the modified code, without any additional explanation.", issue.GetTitle(), issue.GetBody(), approved)
+	geminiArgs := []string{prompt, "-y", "-a"}
+	if model != "" {
+		geminiArgs = append(geminiArgs, "--model", model)
+	}
+	geminiArgs = append(geminiArgs, files...)
+
+	if out, err := b.runner(dir, "gemini", geminiArgs...); err != nil {
+		return fmt.Errorf("%w: %w: %s", ErrEditFailed, err, out)
+	}
+	return nil
+}
+
+// configureGitIdentity makes commits in dir authored by the bot, and signed
+// when a signing key is configured.
+func (b *Bot) configureGitIdentity(dir string) error {
+	settings := [][2]string{{"user.name", b.appName}, {"user.email", fmt.Sprintf("%s@users.noreply.github.com", b.appName)}}
+	if b.signer != nil {
+		if b.signer.name != "" {
+			settings[0][1] = b.signer.name
+		}
+		if b.signer.email != "" {
+			settings[1][1] = b.signer.email
+		}
+		settings = append(settings, b.signer.gitConfig...)
+	}
+	for _, s := range settings {
+		if out, err := b.runner(dir, "git", "config", s[0], s[1]); err != nil {
+			return gitError(ErrGitFailed, out, err)
+		}
+	}
+	return nil
 }
 
 // --- Helper Functions ---
@@ -385,35 +1175,49 @@ func parseFilePathsFromIssue(body string) []string {
 	return files
 }
 
-func (b *Bot) parseComment(body string) (command string, mentioned bool) {
+func (b *Bot) parseComment(body string) (command string, args []string, mentioned bool) {
 	botMention := "@" + b.appName
 	trimmedBody := strings.TrimSpace(body)
 	fields := strings.Fields(trimmedBody)
 
 	if len(fields) < 2 || fields[0] != botMention {
-		return "", false
+		return "", nil, false
 	}
 
-	return fields[1], true
+	return fields[1], fields[2:], true
 }
 
-func (b *Bot) postComment(ctx context.Context, client *github.Client, owner, repo string, issueNum int, body string) {
+// postComment posts body on the issue and returns the created comment, or nil
+// when posting failed (the error is logged).
+func (b *Bot) postComment(ctx context.Context, client *github.Client, owner, repo string, issueNum int, body string) *github.IssueComment {
 	comment := &github.IssueComment{Body: &body}
 	log.Printf("Attempting to post comment to issue #%d", issueNum)
-	_, _, err := client.Issues.CreateComment(ctx, owner, repo, issueNum, comment)
+	created, _, err := client.Issues.CreateComment(ctx, owner, repo, issueNum, comment)
 	if err != nil {
 		log.Printf("Error creating comment on issue #%d: %v", issueNum, err)
-	} else {
-		log.Printf("Successfully created comment on issue #%d", issueNum)
+		return nil
 	}
+	log.Printf("Successfully created comment on issue #%d", issueNum)
+	notePosted(ctx, created)
+	return created
 }
 
-func findPRDComment(ctx context.Context, client *github.Client, repoOwner, repoName string, issueNumber int) (*github.IssueComment, error) {
+// findPRDComment returns the latest PRD the bot posted on the issue, or nil.
+// Comments by anyone else are never PRDs, whatever they contain.
+func (b *Bot) findPRDComment(ctx context.Context, client *github.Client, repoOwner, repoName string, issueNumber int) (*github.IssueComment, error) {
 	comments, _, err := client.Issues.ListComments(ctx, repoOwner, repoName, issueNumber, nil)
 	if err != nil {
 		return nil, fmt.Errorf("error fetching comments for issue #%d: %w", issueNumber, err)
 	}
 	for i := len(comments) - 1; i >= 0; i-- {
+		if !b.isBotUser(comments[i].GetUser()) {
+			continue
+		}
+		// Metadata tells PRDs from comments that only quote one; PRDs posted
+		// before it existed are recognised by their heading.
+		if meta, ok := parseArtifactMeta(comments[i].GetBody()); ok && meta.Type != ArtifactPRD {
+			continue
+		}
 		if strings.Contains(comments[i].GetBody(), PRDIdentifier) {
 			log.Printf("Found PRD comment #%d for issue #%d", comments[i].GetID(), issueNumber)
 			return comments[i], nil
@@ -422,81 +1226,267 @@ func findPRDComment(ctx context.Context, client *github.Client, repoOwner, repoN
 	return nil, nil // No PRD found
 }
 
-// --- AI Generation Functions (Unchanged) ---
+// --- AI Generation Functions ---
 
-func generateSubTasks(prdContent string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(googleAPIKey))
+// geminiGenerator generates text with a Gemini model through the genai SDK.
+type geminiGenerator struct {
+	model string
+	opts  []option.ClientOption
+
+	mu       sync.RWMutex
+	override string // model set at runtime by the server config
+
+	clientMu sync.Mutex
+	client   *genai.Client            // created on first use and reused
+	tenants  map[string]*genai.Client // clients using installations' own API keys, by key
+}
+
+// SetModel switches the model used by subsequent requests; an empty name
+// restores the configured model.
+func (g *geminiGenerator) SetModel(model string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.override = model
+}
+
+func (g *geminiGenerator) modelName() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.override != "" {
+		return g.override
+	}
+	return g.model
+}
+
+// genaiClient returns the client shared by all requests with the same API
+// key, so its connections are reused. It outlives any single request's
+// context. An empty key selects the configured key.
+func (g *geminiGenerator) genaiClient(key string) (*genai.Client, error) {
+	g.clientMu.Lock()
+	defer g.clientMu.Unlock()
+	if key == "" {
+		if g.client == nil {
+			client, err := genai.NewClient(context.Background(), g.opts...)
+			if err != nil {
+				return nil, err
+			}
+			g.client = client
+		}
+		return g.client, nil
+	}
+	if client, ok := g.tenants[key]; ok {
+		return client, nil
+	}
+	client, err := genai.NewClient(context.Background(), g.clientOptions(key)...)
+	if err != nil {
+		return nil, err
+	}
+	if g.tenants == nil {
+		g.tenants = make(map[string]*genai.Client)
+	}
+	g.tenants[key] = client
+	return client, nil
+}
+
+// clientOptions returns the options of clients using key. An empty key
+// selects the configured key.
+func (g *geminiGenerator) clientOptions(key string) []option.ClientOption {
+	if key == "" {
+		return g.opts
+	}
+	return append(slices.Clone(g.opts), option.WithHTTPClient(geminiHTTPClient(key)))
+}
+
+// GenerateText sends prompt to the configured Gemini model, with the system
+// prompt of ctx as its system instruction, and returns the concatenated text
+// parts.
+func (g *geminiGenerator) GenerateText(ctx context.Context, prompt string) (string, error) {
+	prompt, err := guardPrompt(prompt)
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
-	model := client.GenerativeModel("gemini-1.5-flash")
+	return g.generate(ctx, genai.Text(prompt))
+}
+
+// GenerateWithImages implements ImageGenerator: the images follow the prompt
+// as inline data.
+func (g *geminiGenerator) GenerateWithImages(ctx context.Context, prompt string, images []modelImage) (string, error) {
+	prompt, err := guardPrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	parts := []genai.Part{genai.Text(prompt)}
+	for _, image := range images {
+		parts = append(parts, genai.Blob{MIMEType: image.MIMEType, Data: image.Data})
+	}
+	return g.generate(ctx, parts...)
+}
+
+func (g *geminiGenerator) generate(ctx context.Context, parts ...genai.Part) (string, error) {
+	client, err := g.genaiClient(modelAPIKey(ctx))
+	if err != nil {
+		return "", modelError(err)
+	}
+	name := g.modelName()
+	if model := contextModel(ctx); model != "" {
+		name = model
+	}
+	model := client.GenerativeModel(name)
+	if system := systemPrompt(ctx); system != "" {
+		model.SystemInstruction = &genai.Content{Parts: []genai.Part{genai.Text(system)}}
+	}
+	resp, err := model.GenerateContent(ctx, parts...)
+	if err != nil {
+		return "", modelError(err)
+	}
+	if usage := resp.UsageMetadata; usage != nil {
+		meterTokens(ctx, int64(usage.PromptTokenCount), int64(usage.CandidatesTokenCount))
+	}
+	return extractText(resp), nil
+}
+
+// EmbedTexts implements Embedder with the Gemini embedding model.
+func (g *geminiGenerator) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	client, err := g.genaiClient(modelAPIKey(ctx))
+	if err != nil {
+		return nil, modelError(err)
+	}
+	model := client.EmbeddingModel(defaultEmbeddingModel)
+	batch := model.NewBatch()
+	for _, text := range texts {
+		batch.AddContent(genai.Text(text))
+	}
+	resp, err := model.BatchEmbedContents(ctx, batch)
+	if err != nil {
+		return nil, modelError(err)
+	}
+	vectors := make([][]float32, len(resp.Embeddings))
+	for i, embedding := range resp.Embeddings {
+		vectors[i] = embedding.Values
+	}
+	return vectors, nil
+}
+
+// newGenerator returns the Generator of the configured models: Gemini, with
+// the OpenAI-compatible endpoint as fallback when both are configured.
+func newGenerator(cfg *StartupConfig) Generator {
+	newOpenAI := func() *openAIGenerator {
+		g := newOpenAIGenerator(cfg.OpenAIBaseURL, cfg.OpenAIModel, cfg.OpenAIAPIKey)
+		g.client.Timeout = cfg.OpenAITimeout
+		return g
+	}
+	switch {
+	case cfg.GoogleAPIKey != "" && cfg.OpenAIBaseURL != "":
+		log.Printf("Using Gemini with %s (%s) as fallback.", cfg.OpenAIBaseURL, cfg.OpenAIModel)
+		return &fallbackGenerator{
+			primary:  &geminiGenerator{model: cfg.GeminiModel, opts: []option.ClientOption{option.WithHTTPClient(geminiHTTPClient(cfg.GoogleAPIKey))}},
+			fallback: newOpenAI(),
+		}
+	case cfg.GoogleAPIKey != "":
+		return &geminiGenerator{model: cfg.GeminiModel, opts: []option.ClientOption{option.WithHTTPClient(geminiHTTPClient(cfg.GoogleAPIKey))}}
+	default:
+		log.Printf("GOOGLE_API_KEY is not set. Using the OpenAI-compatible endpoint %s (%s).", cfg.OpenAIBaseURL, cfg.OpenAIModel)
+		return newOpenAI()
+	}
+}
+
+// generateSubTasks breaks the PRD down into a checklist. With code areas,
+// the sub-tasks name the existing packages and files they change.
+func generateSubTasks(ctx context.Context, llm Generator, prdContent string, areas []codeArea) (string, error) {
+	example := "- [ ] Set up the initial project structure and CI/CD pipeline.\n" +
+		"- [ ] Develop the user authentication module.\n\n"
+	existing := codeAreasPrompt(areas)
+	if existing != "" {
+		example = "- [ ] Add a `WriteCSV` exporter next to the existing ones in `internal/export/` (`internal/export/csv.go`).\n" +
+			"- [ ] Register the CSV format in `ExportHandler` (`internal/api/export.go`).\n\n"
+		existing += "\n"
+	}
 	prompt := fmt.Sprintf(
-		"As an expert project manager, break down the following Product Requirements Document (PRD) into a series of actionable sub-tasks for the development team. Each sub-task should be a single, distinct piece of work.\n\n"+
+		"Break down the following Product Requirements Document (PRD) into a series of actionable sub-tasks for the development team. Each sub-task should be a single, distinct piece of work.\n\n"+
 			"Format the output as a GitHub-flavored Markdown checklist. Each item should clearly state the main function to be completed.\n\n"+
-			"**Example:**\n"+
-			"- [ ] Set up the initial project structure and CI/CD pipeline.\n"+
-			"- [ ] Develop the user authentication module.\n\n"+
+			"**Example:**\n%s"+
+			"%s"+
 			"**Here is the PRD:**\n%s",
-		prdContent,
+		example, existing, prdContent,
 	)
-	resp, err := model.GenerateContent(ctx, genai.Text(prompt))
+	subTasks, err := generateMarkdown(withSystemPrompt(ctx, CommandGenerateSubTask), llm, prompt)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate sub-tasks: %w", err)
 	}
-	return fmt.Sprintf("### Generated Sub-tasks\n\nBased on the PRD, here are the suggested sub-tasks:\n\n%s", extractText(resp)), nil
+	return formatSubTasks(subTasks), nil
 }
 
-func generatePRD(title, body, readme string) (string, error) {
-	ctx := context.Background()
-	client, err := genai.NewClient(ctx, option.WithAPIKey(googleAPIKey))
+// generatePRD writes an English PRD and a translation into language, or into
+// the detected language of the issue body when language is empty. The PRD
+// builds on related work and lists it, and stays consistent with the prior
+// PRDs of the repository.
+func generatePRD(ctx context.Context, llm Generator, title, body, readme, language string, related []relatedItem, prior []priorPRD) (string, error) {
+	englishPRD, err := generateEnglishPRD(ctx, llm, title, body, readme, related, prior)
 	if err != nil {
 		return "", err
 	}
-	defer client.Close()
-	model := client.GenerativeModel("gemini-1.5-flash")
 
-	// Generate English PRD
+	// Detect language and translate
+	detectedLanguage := languageName(language)
+	if detectedLanguage == "" {
+		languageDetectionPrompt := fmt.Sprintf("Detect the primary language of the following text. Respond with the language name only (e.g., 'Traditional Chinese', 'Japanese').\n\nText:\n%s", body)
+		respLang, err := llm.GenerateText(withSystemPrompt(ctx, promptDetectLanguage), languageDetectionPrompt)
+		detectedLanguage = "the original language of the issue"
+		if err == nil {
+			detectedLanguage = respLang
+		}
+	}
+
+	translatedPRD, err := translatePRD(ctx, llm, englishPRD, detectedLanguage)
+	if err != nil {
+		log.Printf("Failed to generate translated PRD, falling back to English only: %v", err)
+		return (&PRDDocument{English: englishPRD, Related: formatRelatedWork(related)}).String(), nil
+	}
+
+	doc := &PRDDocument{English: englishPRD, Language: strings.TrimSpace(detectedLanguage), Translated: translatedPRD, Related: formatRelatedWork(related)}
+	return doc.String(), nil
+}
+
+// generateEnglishPRD writes the English PRD of an issue.
+func generateEnglishPRD(ctx context.Context, llm Generator, title, body, readme string, related []relatedItem, prior []priorPRD) (string, error) {
+	var relatedSection string
+	if prompt := relatedWorkPrompt(related); prompt != "" {
+		relatedSection = prompt + "\n\n"
+	}
+	if prompt := priorPRDsPrompt(prior); prompt != "" {
+		relatedSection += prompt + "\n\n"
+	}
 	promptEn := fmt.Sprintf(
-		"As a professional Product Manager, create a Product Requirements Document (PRD) based on the following GitHub issue and repository README. The PRD should be in English.\n\n"+
+		"Create a Product Requirements Document (PRD) based on the following GitHub issue and repository README. The PRD should be in English.\n\n"+
 			"**GitHub Issue Title:**\n%s\n\n"+
 			"**GitHub Issue Body:**\n%s\n\n"+
 			"**Repository README:**\n%s\n\n"+
-			"**PRD Structure:**\n"+
-			"1.  **Background:** (Briefly describe the context and problem)\n"+
-			"2.  **Goals:** (What are the primary objectives?)\n"+
-			"3.  **User Stories:** (As a [user type], I want [an action] so that [a benefit])\n"+
-			"4.  **Requirements:** (Detailed functional and non-functional requirements)\n"+
-			"5.  **Success Metrics:** (How will we measure success?)\n",
-		title, body, readme,
+			"%s"+
+			"**PRD Structure:**\n%s",
+		title, body, readme, relatedSection, prdStructure(),
 	)
-	respEn, err := model.GenerateContent(ctx, genai.Text(promptEn))
+	englishPRD, err := generateMarkdown(withSystemPrompt(ctx, CommandGeneratePRD), llm, promptEn)
 	if err != nil {
 		return "", fmt.Errorf("failed to generate English PRD: %w", err)
 	}
-	englishPRD := extractText(respEn)
+	return englishPRD, nil
+}
 
-	// Detect language and translate
-	languageDetectionPrompt := fmt.Sprintf("Detect the primary language of the following text. Respond with the language name only (e.g., 'Traditional Chinese', 'Japanese').\n\nText:\n%s", body)
-	respLang, err := model.GenerateContent(ctx, genai.Text(languageDetectionPrompt))
-	detectedLanguage := "the original language of the issue"
-	if err == nil {
-		detectedLanguage = extractText(respLang)
+// parseModelJSON decodes a JSON model response into v, tolerating a
+// surrounding Markdown code fence.
+func parseModelJSON(text string, v any) error {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		// Drop the opening fence line (which may carry a language tag) and the closing fence.
+		if _, rest, found := strings.Cut(text, "\n"); found {
+			text = rest
+		}
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
 	}
-
-	promptTranslate := fmt.Sprintf("Translate the following English PRD into %s. Maintain the original formatting and structure.\n\n**English PRD:**\n%s", detectedLanguage, englishPRD)
-	respTranslated, err := model.GenerateContent(ctx, genai.Text(promptTranslate))
-	if err != nil {
-		log.Printf("Failed to generate translated PRD, falling back to English only: %v", err)
-		return fmt.Sprintf("%s\n\n---\n\n%s", PRDIdentifier, englishPRD), nil
+	if err := json.Unmarshal([]byte(text), v); err != nil {
+		return fmt.Errorf("model returned invalid JSON: %w", err)
 	}
-	translatedPRD := extractText(respTranslated)
-
-	return fmt.Sprintf(
-		"%s\n\n---\n\n%s\n\n---\n\n### PRD (%s)\n\n%s",
-		PRDIdentifier, englishPRD, strings.TrimSpace(detectedLanguage), translatedPRD,
-	), nil
+	return nil
 }
 
 func extractText(resp *genai.GenerateContentResponse) string {
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestPATClientFactoryAuthenticatesWithToken(t *testing.T) {
+	var gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		writeJSON(w, http.StatusOK, map[string]string{"login": "octo-bot"})
+	}))
+	defer srv.Close()
+
+	pat := newPATClientFactory("ghp_example", githubServer{})
+	client, err := pat.Client(0)
+	if err != nil {
+		t.Fatalf("Client: %v", err)
+	}
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+	user, _, err := client.Users.Get(context.Background(), "")
+	if err != nil {
+		t.Fatalf("Users.Get: %v", err)
+	}
+	if gotAuth != "Bearer ghp_example" || user.GetLogin() != "octo-bot" {
+		t.Errorf("Authorization = %q, login = %q", gotAuth, user.GetLogin())
+	}
+	if token, _ := pat.Token(context.Background(), 0); token != "ghp_example" {
+		t.Errorf("Token = %q, want the PAT", token)
+	}
+}
+
+func TestGitHubEnterpriseServer(t *testing.T) {
+	server := githubServer{apiURL: "https://ghe.example.com/api/v3/"}
+	if server.host() != "ghe.example.com" || server.webURL() != "https://ghe.example.com" {
+		t.Errorf("host = %q, web URL = %q", server.host(), server.webURL())
+	}
+	pat := newPATClientFactory("ghp_example", server)
+	if client, _ := pat.Client(0); client.BaseURL.String() != "https://ghe.example.com/api/v3/" || client.UploadURL.String() != "https://ghe.example.com/api/uploads/" {
+		t.Errorf("PAT client uses %s and %s", client.BaseURL, client.UploadURL)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apps, err := newAppClientFactory(1, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), server)
+	if err != nil {
+		t.Fatalf("newAppClientFactory: %v", err)
+	}
+	if apps.apps.BaseURL != "https://ghe.example.com/api/v3" {
+		t.Errorf("app JWTs are sent to %s", apps.apps.BaseURL)
+	}
+	if client, _ := apps.Client(7); client.BaseURL.String() != "https://ghe.example.com/api/v3/" {
+		t.Errorf("installation client uses %s", client.BaseURL)
+	}
+	if defaults := (githubServer{}); defaults.host() != "github.com" || defaults.webURL() != "https://github.com" {
+		t.Errorf("github.com host = %q, web URL = %q", defaults.host(), defaults.webURL())
+	}
+}
+
+func TestAppClientFactoryReusesInstallationClients(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	apps, err := newAppClientFactory(1, pemKey, githubServer{})
+	if err != nil {
+		t.Fatalf("newAppClientFactory: %v", err)
+	}
+	now := time.Now()
+	apps.now = func() time.Time { return now }
+
+	first, _ := apps.Client(7)
+	if again, _ := apps.Client(7); again != first {
+		t.Error("the installation client should be reused")
+	}
+	if other, _ := apps.Client(8); other == first {
+		t.Error("installations should not share a client")
+	}
+	now = now.Add(installationClientTTL)
+	if expired, _ := apps.Client(7); expired == first {
+		t.Error("an expired installation client should be replaced")
+	}
+	if len(apps.clients) != 1 {
+		t.Errorf("expired clients should be evicted, %d cached", len(apps.clients))
+	}
+}
+
+func TestParseComment(t *testing.T) {
+	bot := NewBot("prd-bot", "", nil, nil)
+	tests := []struct {
+		body      string
+		command   string
+		args      int
+		mentioned bool
+	}{
+		{"@prd-bot need_prd", "need_prd", 0, true},
+		{"  @prd-bot explain --files a.go  ", "explain", 2, true},
+		{"@prd-bot", "", 0, false},
+		{"hey @prd-bot need_prd", "", 0, false},
+	}
+	for _, tt := range tests {
+		command, args, mentioned := bot.parseComment(tt.body)
+		if command != tt.command || len(args) != tt.args || mentioned != tt.mentioned {
+			t.Errorf("parseComment(%q) = %q, %q, %v", tt.body, command, args, mentioned)
+		}
+	}
+}
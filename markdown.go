@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+var (
+	// fencePattern matches a line opening or closing a fenced code block.
+	fencePattern = regexp.MustCompile("^\\s*(`{3,}|~{3,})")
+	// checklistPattern matches a well-formed task list item.
+	checklistPattern = regexp.MustCompile(`^\s*[-*+] \[[ xX]\] \S`)
+	// looseChecklistPattern matches lines meant as task list items, such as
+	// "- []", "-[ ] task", "[x] task" or "- [ ]task".
+	looseChecklistPattern = regexp.MustCompile(`^(\s*)(?:[-*+]\s*)?\[\s*([xX]?)\s*\]\s*(.*)$`)
+	// headingPattern matches an ATX heading, with or without the space
+	// after the hashes. "#42" is an issue reference, not a heading.
+	headingPattern = regexp.MustCompile(`^ {0,3}(#{1,6})(?:\s+|([^#\s\d]))(.*)$`)
+)
+
+// markdownLine describes a line of generated Markdown outside code blocks.
+type markdownLine struct {
+	text    string
+	inFence bool
+}
+
+// scanMarkdown splits text into lines, marking those inside fenced code
+// blocks, and returns the opening fence and line number of a block that is
+// never closed.
+func scanMarkdown(text string) (lines []markdownLine, openFence string, openLine int) {
+	for i, line := range strings.Split(text, "\n") {
+		m := fencePattern.FindStringSubmatch(line)
+		switch {
+		case openFence == "" && m != nil:
+			openFence, openLine = m[1], i+1
+			lines = append(lines, markdownLine{line, true})
+		case openFence != "":
+			if m != nil && m[1][0] == openFence[0] && len(m[1]) >= len(openFence) && strings.TrimSpace(line) == m[1] {
+				openFence = ""
+			}
+			lines = append(lines, markdownLine{line, true})
+		default:
+			lines = append(lines, markdownLine{line, false})
+		}
+	}
+	return lines, openFence, openLine
+}
+
+// lintMarkdown returns the problems that make generated Markdown render
+// badly on GitHub: unclosed code fences, malformed task list items and
+// headings that lack their space or skip levels.
+func lintMarkdown(text string) []string {
+	lines, openFence, openLine := scanMarkdown(text)
+	var problems []string
+	level := 0
+	for i, line := range lines {
+		if line.inFence {
+			continue
+		}
+		if looseChecklistPattern.MatchString(line.text) && !checklistPattern.MatchString(line.text) && !isMarkdownLink(line.text) {
+			problems = append(problems, fmt.Sprintf("line %d: malformed checklist item %q, expected \"- [ ] task\"", i+1, strings.TrimSpace(line.text)))
+		}
+		m := headingPattern.FindStringSubmatch(line.text)
+		if m == nil {
+			continue
+		}
+		if m[2] != "" {
+			problems = append(problems, fmt.Sprintf("line %d: heading %q needs a space after the #", i+1, strings.TrimSpace(line.text)))
+		}
+		if next := len(m[1]); level > 0 && next > level+1 {
+			problems = append(problems, fmt.Sprintf("line %d: heading skips from level %d to %d", i+1, level, next))
+		}
+		level = len(m[1])
+	}
+	if openFence != "" {
+		problems = append(problems, fmt.Sprintf("line %d: code fence %s is never closed", openLine, openFence))
+	}
+	return problems
+}
+
+// repairMarkdown fixes the problems lintMarkdown reports: it closes an
+// unclosed code fence at the end, rewrites task list items as "- [ ] task",
+// adds the missing space to headings and lowers headings that skip levels.
+func repairMarkdown(text string) string {
+	lines, openFence, _ := scanMarkdown(text)
+	out := make([]string, len(lines))
+	level, shift := 0, 0
+	for i, line := range lines {
+		out[i] = line.text
+		if line.inFence {
+			continue
+		}
+		if m := looseChecklistPattern.FindStringSubmatch(line.text); m != nil && !checklistPattern.MatchString(line.text) && !isMarkdownLink(line.text) && m[3] != "" {
+			mark := " "
+			if m[2] != "" {
+				mark = "x"
+			}
+			out[i] = fmt.Sprintf("%s- [%s] %s", m[1], mark, m[3])
+			continue
+		}
+		m := headingPattern.FindStringSubmatch(line.text)
+		if m == nil {
+			continue
+		}
+		next := len(m[1])
+		switch {
+		case level == 0 || next <= level:
+			shift = 0
+		case next-shift > level+1:
+			shift = next - level - 1
+		}
+		next -= shift
+		level = next
+		out[i] = strings.Repeat("#", next) + " " + m[2] + m[3]
+	}
+	repaired := strings.Join(out, "\n")
+	if openFence != "" {
+		repaired = strings.TrimRight(repaired, "\n") + "\n" + openFence
+	}
+	return repaired
+}
+
+// isMarkdownLink reports whether line starts with a link or footnote such
+// as "[docs](url)" or "[^1]: note", which look like checklist brackets.
+func isMarkdownLink(line string) bool {
+	rest := strings.TrimLeft(strings.TrimSpace(line), "-*+ ")
+	return strings.HasPrefix(rest, "[^") || strings.Contains(rest, "](") || strings.Contains(rest, "]:") || strings.Contains(rest, "][")
+}
+
+// generateMarkdown asks llm for a Markdown response to prompt. A malformed
+// response is sent back once with its problems; whatever is still wrong
+// after that is repaired in place, so comments always render.
+func generateMarkdown(ctx context.Context, llm Generator, prompt string) (string, error) {
+	text, err := llm.GenerateText(ctx, prompt)
+	if err != nil {
+		return "", err
+	}
+	problems := lintMarkdown(text)
+	if len(problems) == 0 {
+		return text, nil
+	}
+	log.Printf("The model returned malformed Markdown, asking for a correction: %s", strings.Join(problems, "; "))
+	retry := fmt.Sprintf("%s\n\n**Your Previous Response:**\n%s\n\n**Markdown Problems:**\n- %s\n\n"+
+		"Your previous response has malformed Markdown. Respond again with the same content, fixing only these problems.",
+		prompt, text, strings.Join(problems, "\n- "))
+	if corrected, err := llm.GenerateText(ctx, retry); err != nil {
+		log.Printf("Could not get corrected Markdown, repairing the response: %v", err)
+	} else if strings.TrimSpace(corrected) != "" {
+		text = corrected
+	}
+	return repairMarkdown(text), nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLintAndRepairMarkdown(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		problems int
+		repaired string
+	}{
+		{
+			name:     "well formed",
+			text:     "## Goals\n\n- [ ] Export CSV\n- [x] Pick a library\n\n### Notes\n\n```go\n#include\n- [] not a task\n```\nSee #42 and [docs](https://example.com).",
+			problems: 0,
+		},
+		{
+			name:     "unclosed fence",
+			text:     "## Example\n\n```bash\ncurl https://example.com\n",
+			problems: 1,
+			repaired: "## Example\n\n```bash\ncurl https://example.com\n```",
+		},
+		{
+			name:     "nested fences",
+			text:     "````markdown\n```go\nfunc main() {}\n```\n````\n~~~\ncode",
+			problems: 1,
+			repaired: "````markdown\n```go\nfunc main() {}\n```\n````\n~~~\ncode\n~~~",
+		},
+		{
+			name:     "checklist items",
+			text:     "-[ ] Parse input\n- []Validate rows\n  * [X] Write output\n[ ] Document it\n- []",
+			problems: 4,
+			repaired: "- [ ] Parse input\n- [ ] Validate rows\n  * [X] Write output\n- [ ] Document it\n- []",
+		},
+		{
+			name:     "headings",
+			text:     "# PRD\n##Goals\n#### Metrics\n#### Rollout\n##### Phases\n## Risks",
+			problems: 2,
+			repaired: "# PRD\n## Goals\n### Metrics\n### Rollout\n#### Phases\n## Risks",
+		},
+	}
+	for _, test := range tests {
+		problems := lintMarkdown(test.text)
+		if len(problems) != test.problems {
+			t.Errorf("%s: got problems %q, want %d", test.name, problems, test.problems)
+		}
+		want := test.repaired
+		if test.problems == 0 {
+			want = test.text
+		}
+		if got := repairMarkdown(test.text); got != want {
+			t.Errorf("%s: repaired to\n%s\nwant\n%s", test.name, got, want)
+		}
+	}
+}
+
+func TestGenerateMarkdownReprompts(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Markdown Problems", "## Tasks\n\n- [ ] Export CSV\n\n```go\nfunc Export() {}\n")
+	env.gemini.on("Break down", "## Tasks\n\n-[] Export CSV\n\n```go\nfunc Export() {}\n")
+
+	text, err := generateMarkdown(context.Background(), env.gemini.generator(), "Break down the PRD.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "## Tasks\n\n- [ ] Export CSV\n\n```go\nfunc Export() {}\n```"; text != want {
+		t.Errorf("got\n%s\nwant\n%s", text, want)
+	}
+	prompts := env.gemini.receivedPrompts()
+	if len(prompts) != 2 || !strings.Contains(prompts[1], `malformed checklist item "-[] Export CSV"`) || !strings.Contains(prompts[1], "code fence ``` is never closed") {
+		t.Errorf("the correction prompt should list the problems: %q", prompts)
+	}
+
+	text, _ = generateMarkdown(context.Background(), env.gemini.generator(), "Write the Markdown Problems section.")
+	if problems := lintMarkdown(text); len(env.gemini.receivedPrompts()) != 4 || problems != nil {
+		t.Errorf("a response still malformed after the correction should be repaired: %q", problems)
+	}
+}
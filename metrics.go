@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// counterVec is a monotonically increasing counter partitioned by one label,
+// exported in the Prometheus text format.
+type counterVec struct {
+	name  string
+	help  string
+	label string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// metricsRegistry holds every exported metric in registration order.
+var metricsRegistry []*counterVec
+
+func newCounterVec(name, help, label string) *counterVec {
+	c := &counterVec{name: name, help: help, label: label, values: make(map[string]float64)}
+	metricsRegistry = append(metricsRegistry, c)
+	return c
+}
+
+// Inc adds one to the counter for labelValue.
+func (c *counterVec) Inc(labelValue string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[labelValue]++
+}
+
+// Value returns the current count for labelValue.
+func (c *counterVec) Value(labelValue string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[labelValue]
+}
+
+// metricValue is the count of one label value.
+type metricValue struct {
+	Label string
+	Value float64
+}
+
+// snapshot returns the counts by label value, sorted by label.
+func (c *counterVec) snapshot() []metricValue {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values := make([]metricValue, 0, len(c.values))
+	for k, v := range c.values {
+		values = append(values, metricValue{k, v})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Label < values[j].Label })
+	return values
+}
+
+func (c *counterVec) write(w *strings.Builder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	keys := make([]string, 0, len(c.values))
+	for k := range c.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s{%s=%q} %g\n", c.name, c.label, k, c.values[k])
+	}
+}
+
+var failuresTotal = newCounterVec("agent_prd_failures_total", "Failed bot operations by error code.", "code")
+
+// handleMetrics serves all registered metrics in the Prometheus text format.
+func handleMetrics(w http.ResponseWriter, _ *http.Request) {
+	var b strings.Builder
+	for _, c := range metricsRegistry {
+		c.write(&b)
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
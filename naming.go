@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	defaultBranchTemplate = "feature/issue-{{issue}}-{{timestamp}}"
+	defaultCommitTemplate = "feat: Implement feature for #{{issue}}"
+
+	// commitFooter ends every commit message of the bot.
+	commitFooter = "This commit was automatically generated by the Gemini bot based on the issue."
+
+	maxSlugLength = 40
+)
+
+// NamingConfig sets the branch names and commit messages of implement_feature,
+// so they fit the repository's conventions and commit-lint hooks. Templates
+// may use {{issue}}, {{title}}, {{slug}} (the title in kebab-case), {{type}}
+// (the conventional-commit type inferred from the issue) and {{timestamp}}.
+type NamingConfig struct {
+	// Branch is the branch name template, "feature/issue-{{issue}}-{{timestamp}}" by default.
+	Branch string `yaml:"branch"`
+	// Commit is the commit subject template, "feat: Implement feature for #{{issue}}" by default.
+	Commit string `yaml:"commit"`
+	// Types maps issue labels to conventional-commit types, on top of the
+	// built-in ones, e.g. {"regression": "fix"}.
+	Types map[string]string `yaml:"types"`
+}
+
+// commitTypes maps issue labels and title prefixes to conventional-commit types.
+var commitTypes = map[string]string{
+	"bug": "fix", "fix": "fix", "bugfix": "fix", "hotfix": "fix",
+	"documentation": "docs", "docs": "docs",
+	"refactor": "refactor", "refactoring": "refactor",
+	"performance": "perf", "perf": "perf",
+	"test": "test", "tests": "test", "testing": "test",
+	"chore": "chore", "maintenance": "chore", "dependencies": "chore",
+	"ci": "ci", "build": "build", "style": "style",
+	"feature": "feat", "feat": "feat", "enhancement": "feat",
+}
+
+var (
+	templateVariable = regexp.MustCompile(`\{\{\s*(\w+)\s*\}\}`)
+	slugSeparators   = regexp.MustCompile(`[^a-z0-9]+`)
+	titlePrefix      = regexp.MustCompile(`^\s*\[?(\w+)[\]:(]`)
+	// invalidRefChars are characters git doesn't allow in branch names.
+	invalidRefChars = regexp.MustCompile(`[\s~^:?*\[\\]+|\.\.|@\{`)
+)
+
+// commitType infers the conventional-commit type of issue from its labels,
+// then from a title prefix such as "fix:" or "[docs]", defaulting to feat.
+func (c *NamingConfig) commitType(issue *github.Issue) string {
+	lookup := func(name string) (string, bool) {
+		name = strings.ToLower(name)
+		if c != nil {
+			for label, t := range c.Types {
+				if strings.EqualFold(label, name) {
+					return t, true
+				}
+			}
+		}
+		t, ok := commitTypes[name]
+		return t, ok
+	}
+	for _, label := range issue.Labels {
+		name := label.GetName()
+		// Labels are often namespaced, as in "type: bug" or "kind/bug".
+		if i := strings.LastIndexAny(name, ":/"); i >= 0 {
+			name = strings.TrimSpace(name[i+1:])
+		}
+		if t, ok := lookup(name); ok {
+			return t
+		}
+	}
+	if m := titlePrefix.FindStringSubmatch(issue.GetTitle()); m != nil {
+		if t, ok := lookup(m[1]); ok {
+			return t
+		}
+	}
+	return "feat"
+}
+
+// slugify turns a title into a short kebab-case slug.
+func slugify(title string) string {
+	slug := strings.Trim(slugSeparators.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	if len(slug) > maxSlugLength {
+		// Cut at a word boundary when there is one.
+		cut := slug[:maxSlugLength]
+		if slug[maxSlugLength] != '-' {
+			if i := strings.LastIndex(cut, "-"); i > 0 {
+				cut = cut[:i]
+			}
+		}
+		slug = strings.TrimRight(cut, "-")
+	}
+	return slug
+}
+
+// renderNameTemplate expands the variables of tmpl for issue.
+func (c *NamingConfig) renderNameTemplate(tmpl string, issue *github.Issue, now time.Time) (string, error) {
+	vars := map[string]string{
+		"issue":     strconv.Itoa(issue.GetNumber()),
+		"title":     issue.GetTitle(),
+		"slug":      slugify(issue.GetTitle()),
+		"type":      c.commitType(issue),
+		"timestamp": strconv.FormatInt(now.Unix(), 10),
+	}
+	return expandTemplate(tmpl, vars)
+}
+
+// expandTemplate replaces the {{name}} variables of tmpl with their values
+// in vars. Unknown variables are an error.
+func expandTemplate(tmpl string, vars map[string]string) (string, error) {
+	var unknown []string
+	out := templateVariable.ReplaceAllStringFunc(tmpl, func(m string) string {
+		name := templateVariable.FindStringSubmatch(m)[1]
+		v, ok := vars[name]
+		if !ok {
+			unknown = append(unknown, name)
+		}
+		return v
+	})
+	if len(unknown) > 0 {
+		return "", fmt.Errorf("unknown template variables %s in %q", strings.Join(unknown, ", "), tmpl)
+	}
+	return out, nil
+}
+
+// branchName returns the branch for implementing issue. An invalid template
+// falls back to the default one.
+func (c *NamingConfig) branchName(issue *github.Issue, now time.Time) string {
+	tmpl := defaultBranchTemplate
+	if c != nil && c.Branch != "" {
+		tmpl = c.Branch
+	}
+	name, err := c.renderNameTemplate(tmpl, issue, now)
+	if err == nil {
+		name = strings.Trim(invalidRefChars.ReplaceAllString(name, "-"), "/.-")
+		if name = strings.TrimSuffix(name, ".lock"); name == "" {
+			err = fmt.Errorf("the branch name is empty")
+		}
+	}
+	if err != nil {
+		log.Printf("Ignoring the naming.branch template %q: %v", tmpl, err)
+		name, _ = c.renderNameTemplate(defaultBranchTemplate, issue, now)
+	}
+	return name
+}
+
+// commitMessage returns the commit message for implementing issue: the
+// rendered subject, an optional detail paragraph and the bot footer. An
+// invalid template falls back to the default one.
+func (c *NamingConfig) commitMessage(issue *github.Issue, detail string, now time.Time) string {
+	tmpl := defaultCommitTemplate
+	if c != nil && c.Commit != "" {
+		tmpl = c.Commit
+	}
+	subject, err := c.renderNameTemplate(tmpl, issue, now)
+	if err != nil {
+		log.Printf("Ignoring the naming.commit template: %v", err)
+		subject, _ = c.renderNameTemplate(defaultCommitTemplate, issue, now)
+	}
+	message := subject
+	if detail != "" {
+		message += "\n\n" + detail
+	}
+	return message + "\n\n" + commitFooter
+}
+
+// namingConfig returns the naming configuration of owner/repo, falling back
+// to the defaults when it cannot be read.
+func (b *Bot) namingConfig(ctx context.Context, client *github.Client, owner, repo string) *NamingConfig {
+	cfg, err := b.config.Load(ctx, client, owner, repo)
+	if err != nil {
+		log.Printf("Error loading config, using defaults where needed: %v", err)
+	}
+	return cfg.Naming
+}
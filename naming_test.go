@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func namingIssue(title string, labels ...string) *github.Issue {
+	issue := &github.Issue{Number: github.Int(42), Title: github.String(title)}
+	for _, l := range labels {
+		issue.Labels = append(issue.Labels, &github.Label{Name: github.String(l)})
+	}
+	return issue
+}
+
+func TestCommitType(t *testing.T) {
+	custom := &NamingConfig{Types: map[string]string{"Regression": "fix"}}
+	for _, tc := range []struct {
+		cfg   *NamingConfig
+		issue *github.Issue
+		want  string
+	}{
+		{nil, namingIssue("Export reports as CSV"), "feat"},
+		{nil, namingIssue("Crash on export", "bug"), "fix"},
+		{nil, namingIssue("Crash on export", "priority: high", "type: Documentation"), "docs"},
+		{nil, namingIssue("[refactor] Split the exporter"), "refactor"},
+		{nil, namingIssue("perf: faster exports"), "perf"},
+		{nil, namingIssue("Exports are slow again", "regression"), "feat"},
+		{custom, namingIssue("Exports are slow again", "regression"), "fix"},
+	} {
+		if got := tc.cfg.commitType(tc.issue); got != tc.want {
+			t.Errorf("commitType(%q, %v) = %q, want %q", tc.issue.GetTitle(), tc.issue.Labels, got, tc.want)
+		}
+	}
+}
+
+func TestNamingTemplates(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	issue := namingIssue("Fix: CSV export crashes on émojis & long titles that go on and on", "bug")
+
+	var defaults *NamingConfig
+	if got := defaults.branchName(issue, now); got != "feature/issue-42-1700000000" {
+		t.Errorf("default branch = %q", got)
+	}
+	if got := defaults.commitMessage(issue, "", now); got != "feat: Implement feature for #42\n\n"+commitFooter {
+		t.Errorf("default commit message = %q", got)
+	}
+
+	cfg := &NamingConfig{Branch: "{{type}}/{{issue}}-{{slug}}", Commit: "{{type}}(export): {{title}} (#{{ issue }})"}
+	if got := cfg.branchName(issue, now); got != "fix/42-fix-csv-export-crashes-on-mojis-long" {
+		t.Errorf("branch = %q", got)
+	}
+	if got := cfg.commitMessage(issue, "Part 1/2: Encoder", now); got != "fix(export): Fix: CSV export crashes on émojis & long titles that go on and on (#42)\n\nPart 1/2: Encoder\n\n"+commitFooter {
+		t.Errorf("commit message = %q", got)
+	}
+
+	bad := &NamingConfig{Branch: "{{owner}}/{{issue}}", Commit: "{{kind}}: done"}
+	if got := bad.branchName(issue, now); got != "feature/issue-42-1700000000" {
+		t.Errorf("branch with an unknown variable = %q, want the default", got)
+	}
+	if got := bad.commitMessage(issue, "", now); !strings.HasPrefix(got, "feat: Implement feature for #42") {
+		t.Errorf("commit message with an unknown variable = %q, want the default", got)
+	}
+	if got := (&NamingConfig{Branch: "wip: {{title}}.."}).branchName(namingIssue("a b"), now); got != "wip-a-b" {
+		t.Errorf("sanitized branch = %q", got)
+	}
+}
+
+func TestImplementFeatureUsesNamingTemplates(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "naming:\n  branch: \"{{type}}/{{issue}}-{{slug}}\"\n  commit: \"{{type}}: {{title}} (#{{issue}})\"\n")
+	env.runner.outputs = map[string]string{"ls-remote --heads origin feat/42-export-reports-as-csv": "abc123\trefs/heads/feat/42-export-reports-as-csv\n"}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	executed := strings.Join(env.runner.executed(), "\n")
+	for _, want := range []string{
+		"git checkout -b feat/42-export-reports-as-csv-",
+		"git commit -m feat: Export reports as CSV (#42)",
+		"git push origin feat/42-export-reports-as-csv-",
+	} {
+		if !strings.Contains(executed, want) {
+			t.Errorf("expected command containing %q, got:\n%s", want, executed)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	viewerSessionCookie = "agent_prd_session"
+	viewerLoginCookie   = "agent_prd_login"
+
+	// viewerSessionTTL is how long a viewer sign-in lasts.
+	viewerSessionTTL = 8 * time.Hour
+
+	// viewerLoginTTL is how long a sign-in may take at the provider.
+	viewerLoginTTL = 10 * time.Minute
+
+	// jwksRefreshInterval limits how often unknown key IDs refetch the
+	// provider's keys.
+	jwksRefreshInterval = time.Minute
+)
+
+// oidcAuth signs people in to the artifact viewer with an OpenID Connect
+// provider, such as Okta, Microsoft Entra ID or Google Workspace, using the
+// authorization code flow. Sessions are kept in signed cookies.
+type oidcAuth struct {
+	issuer       string
+	clientID     string
+	clientSecret string
+	redirectURL  string   // the viewer's callback URL registered with the provider
+	domains      []string // email domains allowed in; empty allows every account of the provider
+	client       *http.Client
+	sessionKey   []byte // signs session and login cookies
+
+	mu          sync.Mutex
+	metadata    *oidcMetadata
+	keys        map[string]*rsa.PublicKey // signing keys by key ID
+	keysFetched time.Time
+}
+
+// oidcMetadata is the part of the provider's discovery document the viewer
+// uses.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// newOIDCAuth configures sign-in with the provider at issuer. viewerURL is
+// the public URL of the bot, domains a comma-separated list of the email
+// domains allowed in, and sessionSecret the secret the cookie signing key
+// is derived from, so every replica accepts the same sessions.
+func newOIDCAuth(issuer, clientID, clientSecret, viewerURL, domains, sessionSecret string) (*oidcAuth, error) {
+	if sessionSecret == "" {
+		return nil, errors.New("a session secret is required")
+	}
+	mac := hmac.New(sha256.New, []byte(sessionSecret))
+	mac.Write([]byte("agent-prd viewer session"))
+	key := mac.Sum(nil)
+	a := &oidcAuth{
+		issuer:       strings.TrimRight(issuer, "/"),
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		redirectURL:  strings.TrimRight(viewerURL, "/") + "/viewer/callback",
+		client:       &http.Client{Timeout: 30 * time.Second},
+		sessionKey:   key,
+	}
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@")); domain != "" {
+			a.domains = append(a.domains, domain)
+		}
+	}
+	return a, nil
+}
+
+// discover returns the provider's metadata, fetching it once.
+func (a *oidcAuth) discover(ctx context.Context) (*oidcMetadata, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.metadata != nil {
+		return a.metadata, nil
+	}
+	var metadata oidcMetadata
+	if err := a.getJSON(ctx, a.issuer+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, fmt.Errorf("discovering %s: %w", a.issuer, err)
+	}
+	if strings.TrimRight(metadata.Issuer, "/") != a.issuer {
+		return nil, fmt.Errorf("discovery document of %s names issuer %q", a.issuer, metadata.Issuer)
+	}
+	a.metadata = &metadata
+	return a.metadata, nil
+}
+
+func (a *oidcAuth) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %s", url, resp.Status)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
+
+// authCodeURL is where the browser signs in.
+func (a *oidcAuth) authCodeURL(ctx context.Context, state, nonce string) (string, error) {
+	metadata, err := a.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type": {"code"},
+		"client_id":     {a.clientID},
+		"redirect_uri":  {a.redirectURL},
+		"scope":         {"openid email profile"},
+		"state":         {state},
+		"nonce":         {nonce},
+	}
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return metadata.AuthorizationEndpoint + sep + query.Encode(), nil
+}
+
+// exchange redeems an authorization code for the ID token.
+func (a *oidcAuth) exchange(ctx context.Context, code string) (string, error) {
+	metadata, err := a.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {a.redirectURL}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&token); err != nil {
+		return "", fmt.Errorf("token endpoint returned %s: %w", resp.Status, err)
+	}
+	if resp.StatusCode != http.StatusOK || token.IDToken == "" {
+		return "", fmt.Errorf("token endpoint returned %s: %s", resp.Status, cmp.Or(token.Error, "no ID token"))
+	}
+	return token.IDToken, nil
+}
+
+// idTokenClaims are the ID token claims the viewer checks.
+type idTokenClaims struct {
+	Issuer        string   `json:"iss"`
+	Audience      audience `json:"aud"`
+	Expiry        int64    `json:"exp"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified *bool    `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// audience is the aud claim, a single client ID or a list of them.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if json.Unmarshal(data, &single) == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(data, (*[]string)(a))
+}
+
+// verifyIDToken checks the signature and claims of an RS256 ID token issued
+// for this client with nonce.
+func (a *oidcAuth) verifyIDToken(ctx context.Context, raw, nonce string) (*idTokenClaims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("ID token header: %w", err)
+	}
+	if header.Alg != "RS256" {
+		return nil, fmt.Errorf("unsupported ID token algorithm %q", header.Alg)
+	}
+	key, err := a.signingKey(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("ID token signature: %w", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("invalid ID token signature")
+	}
+
+	var claims idTokenClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("ID token claims: %w", err)
+	}
+	switch {
+	case strings.TrimRight(claims.Issuer, "/") != a.issuer:
+		return nil, fmt.Errorf("ID token issued by %q", claims.Issuer)
+	case !slices.Contains(claims.Audience, a.clientID):
+		return nil, errors.New("ID token issued for another client")
+	case time.Now().Unix() >= claims.Expiry:
+		return nil, errors.New("ID token expired")
+	case !subtleEqual(claims.Nonce, nonce):
+		return nil, errors.New("ID token nonce mismatch")
+	}
+	return &claims, nil
+}
+
+func subtleEqual(a, b string) bool {
+	return hmac.Equal([]byte(a), []byte(b))
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// signingKey returns the provider key with ID kid, refetching the keys when
+// the provider has rotated them.
+func (a *oidcAuth) signingKey(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	metadata, err := a.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(a.keysFetched) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown ID token key %q", kid)
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := a.getJSON(ctx, metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("fetching the provider's keys: %w", err)
+	}
+	a.keys = make(map[string]*rsa.PublicKey)
+	a.keysFetched = time.Now()
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil {
+			log.Printf("Skipping malformed key %q of %s.", k.Kid, a.issuer)
+			continue
+		}
+		a.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := a.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown ID token key %q", kid)
+}
+
+// allowed reports whether the account of claims may use the viewer.
+func (a *oidcAuth) allowed(claims *idTokenClaims) bool {
+	if len(a.domains) == 0 {
+		return true
+	}
+	if claims.EmailVerified != nil && !*claims.EmailVerified {
+		return false
+	}
+	_, domain, ok := strings.Cut(strings.ToLower(claims.Email), "@")
+	return ok && slices.Contains(a.domains, domain)
+}
+
+// viewerSession is who is signed in to the viewer.
+type viewerSession struct {
+	Email   string    `json:"email"`
+	Name    string    `json:"name,omitempty"`
+	Expires time.Time `json:"expires"`
+}
+
+// loginState is the pending sign-in of a browser.
+type loginState struct {
+	State    string    `json:"state"`
+	Nonce    string    `json:"nonce"`
+	ReturnTo string    `json:"return_to"`
+	Expires  time.Time `json:"expires"`
+}
+
+// seal signs v into the value of the cookie name. The signature covers the
+// name, so a value can't be replayed as another cookie.
+func (a *oidcAuth) seal(name string, v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + a.sign(name, payload), nil
+}
+
+// open verifies a value of the cookie name made by seal and decodes it into v.
+func (a *oidcAuth) open(name, value string, v any) bool {
+	payload, signature, ok := strings.Cut(value, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(a.sign(name, payload))) {
+		return false
+	}
+	return decodeSegment(payload, v) == nil
+}
+
+func (a *oidcAuth) sign(name, payload string) string {
+	mac := hmac.New(sha256.New, a.sessionKey)
+	mac.Write([]byte(name + "\x00" + payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (a *oidcAuth) cookie(name, value string, ttl time.Duration) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/viewer",
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(a.redirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+// session returns who is signed in with r, or nil.
+func (a *oidcAuth) session(r *http.Request) *viewerSession {
+	c, err := r.Cookie(viewerSessionCookie)
+	if err != nil {
+		return nil
+	}
+	var session viewerSession
+	if !a.open(viewerSessionCookie, c.Value, &session) || session.Email == "" || time.Now().After(session.Expires) {
+		return nil
+	}
+	return &session
+}
+
+func randomString() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// requireViewer returns the signed-in viewer of r, or redirects the browser
+// to sign in and returns nil.
+func (b *Bot) requireViewer(w http.ResponseWriter, r *http.Request) *viewerSession {
+	if b.viewerAuth == nil {
+		http.Error(w, "The viewer is disabled: set OIDC_ISSUER to enable it", http.StatusServiceUnavailable)
+		return nil
+	}
+	if session := b.viewerAuth.session(r); session != nil {
+		return session
+	}
+	http.Redirect(w, r, "/viewer/login?"+url.Values{"return": {r.URL.RequestURI()}}.Encode(), http.StatusFound)
+	return nil
+}
+
+// handleViewerLogin sends the browser to the provider to sign in:
+// GET /viewer/login?return=/viewer/jobs
+func (b *Bot) handleViewerLogin(w http.ResponseWriter, r *http.Request) {
+	if b.viewerAuth == nil {
+		http.Error(w, "The viewer is disabled: set OIDC_ISSUER to enable it", http.StatusServiceUnavailable)
+		return
+	}
+	returnTo := r.URL.Query().Get("return")
+	// Only return to the viewer itself, never to another site.
+	if !strings.HasPrefix(returnTo, "/viewer") || strings.HasPrefix(returnTo, "//") {
+		returnTo = "/viewer"
+	}
+	login := loginState{State: randomString(), Nonce: randomString(), ReturnTo: returnTo, Expires: time.Now().Add(viewerLoginTTL)}
+	target, err := b.viewerAuth.authCodeURL(r.Context(), login.State, login.Nonce)
+	if err != nil {
+		log.Printf("Error starting a viewer sign-in: %v", err)
+		http.Error(w, "The identity provider is unavailable", http.StatusBadGateway)
+		return
+	}
+	value, err := b.viewerAuth.seal(viewerLoginCookie, login)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, b.viewerAuth.cookie(viewerLoginCookie, value, viewerLoginTTL))
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// handleViewerCallback finishes a sign-in: GET /viewer/callback?code=...&state=...
+func (b *Bot) handleViewerCallback(w http.ResponseWriter, r *http.Request) {
+	auth := b.viewerAuth
+	if auth == nil {
+		http.Error(w, "The viewer is disabled: set OIDC_ISSUER to enable it", http.StatusServiceUnavailable)
+		return
+	}
+	var login loginState
+	c, err := r.Cookie(viewerLoginCookie)
+	if err != nil || !auth.open(viewerLoginCookie, c.Value, &login) || time.Now().After(login.Expires) || !subtleEqual(login.State, r.URL.Query().Get("state")) {
+		http.Error(w, "The sign-in expired or didn't start here. Please try again.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, auth.cookie(viewerLoginCookie, "", -time.Second))
+	if msg := r.URL.Query().Get("error"); msg != "" {
+		http.Error(w, "Sign-in failed: "+msg, http.StatusUnauthorized)
+		return
+	}
+	idToken, err := auth.exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		log.Printf("Error redeeming a viewer sign-in code: %v", err)
+		http.Error(w, "Sign-in failed", http.StatusBadGateway)
+		return
+	}
+	claims, err := auth.verifyIDToken(r.Context(), idToken, login.Nonce)
+	if err != nil {
+		log.Printf("Rejecting a viewer ID token: %v", err)
+		http.Error(w, "Sign-in failed", http.StatusUnauthorized)
+		return
+	}
+	if claims.Email == "" {
+		log.Printf("Viewer sign-in denied: the ID token has no email.")
+		http.Error(w, "Your account has no email address", http.StatusForbidden)
+		return
+	}
+	if !auth.allowed(claims) {
+		log.Printf("Viewer sign-in of %q denied: its email domain isn't allowed.", claims.Email)
+		http.Error(w, "Your account isn't allowed to use this viewer", http.StatusForbidden)
+		return
+	}
+	value, err := auth.seal(viewerSessionCookie, viewerSession{Email: claims.Email, Name: claims.Name, Expires: time.Now().Add(viewerSessionTTL)})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("%s signed in to the viewer.", claims.Email)
+	http.SetCookie(w, auth.cookie(viewerSessionCookie, value, viewerSessionTTL))
+	http.Redirect(w, r, login.ReturnTo, http.StatusFound)
+}
+
+// handleViewerLogout ends the viewer session: POST /viewer/logout
+func (b *Bot) handleViewerLogout(w http.ResponseWriter, r *http.Request) {
+	if b.viewerAuth != nil {
+		http.SetCookie(w, b.viewerAuth.cookie(viewerSessionCookie, "", -time.Second))
+	}
+	w.Write([]byte("Signed out."))
+}
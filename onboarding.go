@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// OnboardingIdentifier marks onboarding comments posted for sub-issue assignees.
+	OnboardingIdentifier = "### Getting Started"
+
+	// bucketOnboarding records which assignees were already onboarded, keyed
+	// by issueKey followed by "@login".
+	bucketOnboarding = "onboarding"
+
+	maxOnboardingTreeFiles = 500
+	maxOnboardingFiles     = 8
+)
+
+// testCommands maps root-level build files to the command that runs the
+// project's tests.
+var testCommands = []struct {
+	file    string
+	command string
+}{
+	{"go.mod", "go test ./..."},
+	{"package.json", "npm test"},
+	{"Cargo.toml", "cargo test"},
+	{"pyproject.toml", "pytest"},
+	{"setup.py", "pytest"},
+	{"pom.xml", "mvn test"},
+	{"build.gradle", "./gradlew test"},
+	{"build.gradle.kts", "./gradlew test"},
+	{"Makefile", "make test"},
+}
+
+// onboardingGuide is the model's part of an onboarding checklist.
+type onboardingGuide struct {
+	Files []struct {
+		Path   string `json:"path"`
+		Reason string `json:"reason"`
+	} `json:"files"`
+	FirstSteps []string `json:"first_steps"`
+}
+
+// handleIssueAssigned posts an onboarding checklist when a sub-issue gets an
+// assignee for the first time.
+func (b *Bot) handleIssueAssigned(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, assignee *github.User) {
+	if assignee == nil || assignee.GetType() == "Bot" {
+		return
+	}
+	b.dispatch(ctx, func() {
+		ctx := b.withInstallation(context.Background(), installationID)
+		repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+		cfg := b.repoConfig(ctx, client, repo)
+		if !cfg.OnboardingEnabled() {
+			return
+		}
+		ctx = withPrompts(ctx, cfg, repo)
+		key := issueKey(repoOwner, repoName, issueNum) + "@" + assignee.GetLogin()
+		var seen bool
+		if ok, _ := b.store.Get(bucketOnboarding, key, &seen); ok {
+			log.Printf("%s was already onboarded on issue #%d.", assignee.GetLogin(), issueNum)
+			return
+		}
+		parent, err := parentIssue(ctx, client, repoOwner, repoName, issueNum)
+		if err != nil {
+			log.Printf("Error looking up the parent of issue #%d: %v", issueNum, err)
+			return
+		}
+		if parent == nil {
+			log.Printf("Issue #%d is not a sub-issue. Skipping onboarding.", issueNum)
+			return
+		}
+		// Nobody asked for the checklist, so there is no one to tell.
+		if _, ok := b.checkBudget(ctx, installationID); !ok {
+			log.Printf("Installation %d is over its monthly budget. Skipping onboarding on issue #%d in %s/%s.", installationID, issueNum, repoOwner, repoName)
+			return
+		}
+		if b.postOnboarding(ctx, client, repo, issue, parent, assignee.GetLogin()) {
+			if err := b.store.Put(bucketOnboarding, key, true); err != nil {
+				log.Printf("Error recording onboarding for %s: %v", key, err)
+			}
+		}
+	})
+}
+
+// parentIssue returns the parent of a sub-issue, or nil when the issue has none.
+func parentIssue(ctx context.Context, client *github.Client, owner, repo string, number int) (*github.Issue, error) {
+	req, err := client.NewRequest(http.MethodGet, fmt.Sprintf("repos/%s/%s/issues/%d/parent", owner, repo, number), nil)
+	if err != nil {
+		return nil, err
+	}
+	var parent github.Issue
+	if _, err := client.Do(ctx, req, &parent); err != nil {
+		var ghErr *github.ErrorResponse
+		if errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &parent, nil
+}
+
+// postOnboarding builds the onboarding checklist for login from the parent
+// PRD, the repository layout and related pull requests, and posts it. It
+// reports whether the comment was posted.
+func (b *Bot) postOnboarding(ctx context.Context, client *github.Client, repo *github.Repository, issue, parent *github.Issue, login string) bool {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Onboarding %s on sub-issue #%d of #%d in %s/%s", login, issueNum, parent.GetNumber(), repoOwner, repoName)
+
+	paths, err := listRepoFiles(ctx, client, repo, maxOnboardingTreeFiles)
+	if err != nil {
+		log.Printf("Error listing files of %s/%s for onboarding: %v", repoOwner, repoName, err)
+		return false
+	}
+
+	prd := ""
+	if comment, _ := b.findPRDComment(ctx, client, repoOwner, repoName, parent.GetNumber()); comment != nil {
+		prd = comment.GetBody()
+	}
+	guide, err := generateOnboarding(ctx, b.llm, issue, prd, paths)
+	if err != nil {
+		log.Printf("Error generating onboarding for issue #%d: %v", issueNum, err)
+		return false
+	}
+	pulls := b.relatedPullRequests(repoOwner, repoName, issueNum, parent.GetNumber())
+	body := formatOnboarding(login, parent, prd != "", guide, paths, pulls, detectTestCommands(paths))
+	return b.postComment(ctx, client, repoOwner, repoName, issueNum, body) != nil
+}
+
+// listRepoFiles returns up to limit file paths of the default branch.
+func listRepoFiles(ctx context.Context, client *github.Client, repo *github.Repository, limit int) ([]string, error) {
+	tree, _, err := client.Git.GetTree(ctx, repo.GetOwner().GetLogin(), repo.GetName(), repo.GetDefaultBranch(), true)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range tree.Entries {
+		if entry.GetType() == "blob" && len(paths) < limit {
+			paths = append(paths, entry.GetPath())
+		}
+	}
+	return paths, nil
+}
+
+// generateOnboarding asks the model which files the assignee should read
+// first and how to get started.
+func generateOnboarding(ctx context.Context, llm Generator, issue *github.Issue, prd string, paths []string) (*onboardingGuide, error) {
+	if prd == "" {
+		prd = "(no PRD available)"
+	}
+	prompt := fmt.Sprintf(
+		"Pick the repository files a new contributor should read first to work on the following sub-task, and suggest concrete first steps.\n\n"+
+			"**Sub-task Title:**\n%s\n\n"+
+			"**Sub-task Body:**\n%s\n\n"+
+			"**Parent PRD:**\n%s\n\n"+
+			"**Repository Files:**\n%s\n\n"+
+			"Only choose paths from the list above, at most %d. Respond with JSON only, using this shape:\n"+
+			"{\"files\": [{\"path\": \"<path>\", \"reason\": \"<why it matters for this task>\"}], \"first_steps\": [\"<short actionable step>\"]}",
+		issue.GetTitle(), issue.GetBody(), prd, strings.Join(paths, "\n"), maxOnboardingFiles,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptOnboarding), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate onboarding guide: %w", err)
+	}
+	var guide onboardingGuide
+	if err := parseModelJSON(resp, &guide); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	return &guide, nil
+}
+
+// relatedPullRequests returns the bot pull requests opened for any of issues.
+func (b *Bot) relatedPullRequests(owner, repo string, issues ...int) []botPullRequest {
+	docs, err := b.store.List(bucketPulls)
+	if err != nil {
+		log.Printf("Error listing pull request records: %v", err)
+		return nil
+	}
+	var pulls []botPullRequest
+	for _, data := range docs {
+		var pr botPullRequest
+		if err := json.Unmarshal(data, &pr); err != nil {
+			continue
+		}
+		if pr.Owner == owner && pr.Repo == repo && slices.Contains(issues, pr.Issue) {
+			pulls = append(pulls, pr)
+		}
+	}
+	slices.SortFunc(pulls, func(a, b botPullRequest) int { return a.Number - b.Number })
+	return pulls
+}
+
+// detectTestCommands returns the test commands implied by the build files at
+// the repository root.
+func detectTestCommands(paths []string) []string {
+	var commands []string
+	for _, tc := range testCommands {
+		if slices.Contains(paths, tc.file) && !slices.Contains(commands, tc.command) {
+			commands = append(commands, tc.command)
+		}
+	}
+	return commands
+}
+
+func formatOnboarding(login string, parent *github.Issue, hasPRD bool, guide *onboardingGuide, paths []string, pulls []botPullRequest, tests []string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nWelcome @%s! This is a sub-task of #%d (%s). Here is a checklist to help you get started:\n\n", OnboardingIdentifier, login, parent.GetNumber(), parent.GetTitle())
+	if hasPRD {
+		fmt.Fprintf(&b, "- [ ] Read the PRD in #%d\n", parent.GetNumber())
+	}
+	var files []string
+	for _, f := range guide.Files {
+		if slices.Contains(paths, f.Path) && len(files) < maxOnboardingFiles {
+			files = append(files, fmt.Sprintf("  - [ ] `%s`: %s", f.Path, f.Reason))
+		}
+	}
+	if len(files) > 0 {
+		fmt.Fprintf(&b, "- [ ] Read the relevant files:\n%s\n", strings.Join(files, "\n"))
+	}
+	if len(pulls) > 0 {
+		refs := make([]string, len(pulls))
+		for i, pr := range pulls {
+			refs[i] = fmt.Sprintf("#%d", pr.Number)
+		}
+		fmt.Fprintf(&b, "- [ ] Look at the related pull requests: %s\n", strings.Join(refs, ", "))
+	}
+	if len(tests) > 0 {
+		fmt.Fprintf(&b, "- [ ] Make sure the tests pass locally: `%s`\n", strings.Join(tests, "`, `"))
+	}
+	for _, step := range guide.FirstSteps {
+		fmt.Fprintf(&b, "- [ ] %s\n", step)
+	}
+	return b.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// assign delivers an issues "assigned" webhook for issue #42 and the given assignee.
+func (env *testEnv) assign(t *testing.T, login string) {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issues_opened.json"), &event); err != nil {
+		t.Fatalf("decoding issues fixture: %v", err)
+	}
+	event["action"] = "assigned"
+	event["assignee"] = map[string]any{"login": login, "type": "User"}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding issues payload: %v", err)
+	}
+	env.deliverPayload(t, "issues", payload)
+}
+
+func TestOnboardingForSubIssueAssignee(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "go.mod", "module widgets")
+	env.github.addFile("acme", "widgets", "report.go", "package widgets")
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.addIssue("acme", "widgets", 10, "Reporting epic", "open")
+	env.github.addComment("acme", "widgets", 10, PRDIdentifier+"\n\nReports PRD")
+	env.github.setParent("acme", "widgets", 42, 10)
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 7, Issue: 10})
+	env.gemini.on("a new contributor should read first", `{"files": [{"path": "report.go", "reason": "builds the report rows"}, {"path": "missing.go", "reason": "made up"}], "first_steps": ["Add a CSV writer"]}`)
+
+	env.assign(t, "bob")
+	env.assign(t, "bob")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected one onboarding comment, got %d", len(comments))
+	}
+	body := comments[0].GetBody()
+	for _, want := range []string{OnboardingIdentifier, "@bob", "Read the PRD in #10", "`report.go`: builds the report rows", "#7", "`go test ./...`", "- [ ] Add a CSV writer"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("onboarding comment is missing %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "missing.go") {
+		t.Errorf("onboarding comment lists a file that does not exist:\n%s", body)
+	}
+	if prompts := env.gemini.receivedPrompts(); !strings.Contains(prompts[0], "Reports PRD") {
+		t.Errorf("parent PRD was not part of the prompt:\n%s", prompts[0])
+	}
+}
+
+func TestOnboardingSkipsTopLevelIssues(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+
+	env.assign(t, "bob")
+
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Errorf("expected no comment on an issue without parent, got %d", len(comments))
+	}
+}
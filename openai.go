@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// openAIRequestTimeout bounds a single completion request; local models can
+// be slow, so it is generous.
+const openAIRequestTimeout = 5 * time.Minute
+
+// openAIGenerator generates text through any OpenAI-compatible chat
+// completions endpoint, such as Ollama (`http://localhost:11434/v1`), vLLM or
+// LocalAI, so installs can run entirely on on-prem models.
+type openAIGenerator struct {
+	baseURL string // e.g. "http://localhost:11434/v1"
+	model   string
+	apiKey  string // optional; sent as a bearer token
+	client  *http.Client
+
+	mu       sync.RWMutex
+	override string // model set at runtime by the server config
+}
+
+func newOpenAIGenerator(baseURL, model, apiKey string) *openAIGenerator {
+	return &openAIGenerator{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		model:   model,
+		apiKey:  apiKey,
+		client:  &http.Client{Transport: sharedTransport, Timeout: openAIRequestTimeout},
+	}
+}
+
+// SetModel switches the model used by subsequent requests; an empty name
+// restores the configured model.
+func (g *openAIGenerator) SetModel(model string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.override = model
+}
+
+func (g *openAIGenerator) modelName() string {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	if g.override != "" {
+		return g.override
+	}
+	return g.model
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatCompletionResponse struct {
+	Choices []struct {
+		Message      chatMessage `json:"message"`
+		FinishReason string      `json:"finish_reason"`
+	} `json:"choices"`
+	Usage struct {
+		PromptTokens     int64 `json:"prompt_tokens"`
+		CompletionTokens int64 `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// GenerateText implements Generator.
+func (g *openAIGenerator) GenerateText(ctx context.Context, prompt string) (string, error) {
+	prompt, err := guardPrompt(prompt)
+	if err != nil {
+		return "", err
+	}
+	messages := []chatMessage{{Role: "user", Content: prompt}}
+	if system := systemPrompt(ctx); system != "" {
+		messages = append([]chatMessage{{Role: "system", Content: system}}, messages...)
+	}
+	payload, err := json.Marshal(map[string]any{
+		"model":    g.modelName(),
+		"messages": messages,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.baseURL+"/chat/completions", bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if g.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.apiKey)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrModelUnavailable, err)
+	}
+
+	var completion chatCompletionResponse
+	decodeErr := json.Unmarshal(body, &completion)
+	if resp.StatusCode != http.StatusOK {
+		msg := strings.TrimSpace(string(body))
+		if decodeErr == nil && completion.Error != nil {
+			msg = completion.Error.Message
+		}
+		return "", fmt.Errorf("%w: %s returned %s: %s", ErrModelUnavailable, g.baseURL, resp.Status, msg)
+	}
+	if decodeErr != nil {
+		return "", fmt.Errorf("%w: %w", ErrModelInvalid, decodeErr)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("%w: no choices in completion", ErrModelInvalid)
+	}
+	meterTokens(ctx, completion.Usage.PromptTokens, completion.Usage.CompletionTokens)
+	choice := completion.Choices[0]
+	if choice.FinishReason == "content_filter" {
+		return "", fmt.Errorf("%w: completion stopped by the content filter", ErrModelBlocked)
+	}
+	return choice.Message.Content, nil
+}
+
+// fallbackGenerator uses primary and retries with fallback when primary is
+// unreachable, e.g. a local model standing in during a Gemini outage.
+type fallbackGenerator struct {
+	primary  Generator
+	fallback Generator
+}
+
+// GenerateText implements Generator.
+func (g *fallbackGenerator) GenerateText(ctx context.Context, prompt string) (string, error) {
+	text, err := g.primary.GenerateText(ctx, prompt)
+	if err == nil || !errors.Is(err, ErrModelUnavailable) {
+		return text, err
+	}
+	log.Printf("Primary model unavailable, using the fallback model: %v", err)
+	return g.fallback.GenerateText(ctx, prompt)
+}
+
+// EmbedTexts implements Embedder with the primary generator only: vectors of
+// different models can't be compared.
+func (g *fallbackGenerator) EmbedTexts(ctx context.Context, texts []string) ([][]float32, error) {
+	embedder, ok := g.primary.(Embedder)
+	if !ok {
+		return nil, errors.New("the primary model doesn't support embeddings")
+	}
+	return embedder.EmbedTexts(ctx, texts)
+}
+
+// GenerateWithImages implements ImageGenerator with the primary generator,
+// falling back to a text-only request when it doesn't accept images or is
+// unavailable.
+func (g *fallbackGenerator) GenerateWithImages(ctx context.Context, prompt string, images []modelImage) (string, error) {
+	generator, ok := g.primary.(ImageGenerator)
+	if !ok {
+		return g.GenerateText(ctx, prompt)
+	}
+	text, err := generator.GenerateWithImages(ctx, prompt, images)
+	if err == nil || !errors.Is(err, ErrModelUnavailable) {
+		return text, err
+	}
+	log.Printf("Primary model unavailable, using the fallback model without images: %v", err)
+	return g.fallback.GenerateText(ctx, prompt)
+}
+
+// GenerateWithTools implements ToolGenerator with the primary generator
+// only: a fallback model without tools can't carry on its calls.
+func (g *fallbackGenerator) GenerateWithTools(ctx context.Context, prompt string, tools []modelTool, maxCalls int) (string, error) {
+	generator, ok := g.primary.(ToolGenerator)
+	if !ok {
+		return "", errors.New("the primary model doesn't support tools")
+	}
+	return generator.GenerateWithTools(ctx, prompt, tools, maxCalls)
+}
+
+// SetModel forwards runtime model changes to the primary generator.
+func (g *fallbackGenerator) SetModel(model string) {
+	if s, ok := g.primary.(interface{ SetModel(string) }); ok {
+		s.SetModel(model)
+	}
+}
+
+// modelName reports the primary generator's model, which answers unless it
+// is unavailable.
+func (g *fallbackGenerator) modelName() string {
+	if n, ok := g.primary.(interface{ modelName() string }); ok {
+		return n.modelName()
+	}
+	return ""
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// fakeChatServer answers chat completion requests with reply, recording the
+// last request it received.
+func fakeChatServer(t *testing.T, status int, reply map[string]any) (*httptest.Server, *map[string]any) {
+	t.Helper()
+	var last map[string]any
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer local-key" {
+			t.Errorf("Authorization = %q", got)
+		}
+		_ = json.NewDecoder(r.Body).Decode(&last)
+		writeJSON(w, status, reply)
+	}))
+	t.Cleanup(server.Close)
+	return server, &last
+}
+
+func chatReply(content, finishReason string) map[string]any {
+	return map[string]any{"choices": []any{map[string]any{
+		"message":       map[string]any{"role": "assistant", "content": content},
+		"finish_reason": finishReason,
+	}}}
+}
+
+func TestOpenAIGenerator(t *testing.T) {
+	server, last := fakeChatServer(t, http.StatusOK, chatReply("Hello from llama", "stop"))
+	g := newOpenAIGenerator(server.URL+"/v1/", "llama3", "local-key")
+
+	text, err := g.GenerateText(context.Background(), "Say hello")
+	if err != nil || text != "Hello from llama" {
+		t.Fatalf("GenerateText = %q, %v", text, err)
+	}
+	messages := (*last)["messages"].([]any)
+	if (*last)["model"] != "llama3" || messages[0].(map[string]any)["content"] != "Say hello" {
+		t.Errorf("unexpected request body: %v", *last)
+	}
+
+	g.GenerateText(withSystemPrompt(context.Background(), promptTranslate), "Translate hello")
+	messages = (*last)["messages"].([]any)
+	if len(messages) != 2 || messages[0].(map[string]any)["role"] != "system" || messages[0].(map[string]any)["content"] != systemPrompts[promptTranslate] {
+		t.Errorf("the system prompt should come first: %v", messages)
+	}
+
+	g.SetModel("qwen2")
+	g.GenerateText(context.Background(), "Say hello")
+	if (*last)["model"] != "qwen2" {
+		t.Errorf("model = %v after SetModel", (*last)["model"])
+	}
+}
+
+func TestOpenAIGeneratorErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		reply  map[string]any
+		want   error
+	}{
+		{"server error", http.StatusServiceUnavailable, map[string]any{"error": map[string]any{"message": "model is loading"}}, ErrModelUnavailable},
+		{"content filter", http.StatusOK, chatReply("", "content_filter"), ErrModelBlocked},
+		{"no choices", http.StatusOK, map[string]any{"choices": []any{}}, ErrModelInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, _ := fakeChatServer(t, tt.status, tt.reply)
+			_, err := newOpenAIGenerator(server.URL+"/v1", "llama3", "local-key").GenerateText(context.Background(), "prompt")
+			if !errors.Is(err, tt.want) {
+				t.Errorf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFallbackGeneratorUsesLocalModelWhenPrimaryIsDown(t *testing.T) {
+	server, _ := fakeChatServer(t, http.StatusOK, chatReply("local answer", "stop"))
+	down := newOpenAIGenerator("http://127.0.0.1:1", "unreachable", "local-key")
+	g := &fallbackGenerator{primary: down, fallback: newOpenAIGenerator(server.URL+"/v1", "llama3", "local-key")}
+
+	if text, err := g.GenerateText(context.Background(), "prompt"); err != nil || text != "local answer" {
+		t.Errorf("GenerateText = %q, %v", text, err)
+	}
+}
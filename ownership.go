@@ -0,0 +1,326 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// OwnersIdentifier heads the owners suggested under generated sub-tasks.
+	OwnersIdentifier = "#### Suggested owners"
+
+	// bucketTaskOwners holds the owners suggested for the sub-tasks of an
+	// issue, keyed by issueKey, so sub-issues created from them can be
+	// assigned.
+	bucketTaskOwners = "taskowners"
+
+	defaultMaxTaskOwners  = 2
+	maxOwnershipTreeFiles = 500
+	maxTaskFiles          = 3
+	// ownershipHistory is how far back commits count toward ownership.
+	ownershipHistory = 180 * 24 * time.Hour
+	// codeownerWeight is how many commits owning a file in CODEOWNERS is worth.
+	codeownerWeight = 3
+)
+
+// SubTaskOwnersConfig makes need_sub_task suggest who should take each
+// sub-task, from CODEOWNERS and the recent commits to the files it implies.
+type SubTaskOwnersConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AutoAssign assigns a sub-issue created from a sub-task to the owners
+	// suggested for it, unless somebody is assigned already.
+	AutoAssign bool `yaml:"auto_assign"`
+	// Max bounds the owners suggested per sub-task; 2 by default.
+	Max int `yaml:"max"`
+}
+
+func (c *SubTaskOwnersConfig) enabled() bool    { return c != nil && c.Enabled }
+func (c *SubTaskOwnersConfig) autoAssign() bool { return c.enabled() && c.AutoAssign }
+
+func (c *SubTaskOwnersConfig) max() int {
+	if c == nil || c.Max <= 0 {
+		return defaultMaxTaskOwners
+	}
+	return c.Max
+}
+
+// taskOwners are the users suggested for one sub-task.
+type taskOwners struct {
+	Task   string      `json:"task"`
+	Owners []taskOwner `json:"owners"`
+}
+
+// taskOwner is a suggested user and why.
+type taskOwner struct {
+	Login  string `json:"login"`
+	Reason string `json:"reason"`
+}
+
+var subTaskPattern = regexp.MustCompile(`(?m)^\s*[-*] \[[ xX]\] (.+)$`)
+
+// parseSubTasks returns the checklist items of a sub-task comment.
+func parseSubTasks(markdown string) []string {
+	var tasks []string
+	for _, m := range subTaskPattern.FindAllStringSubmatch(markdown, -1) {
+		tasks = append(tasks, strings.TrimSpace(m[1]))
+	}
+	return tasks
+}
+
+// normalizeTask reduces a sub-task or issue title to what survives turning
+// a checklist item into an issue.
+func normalizeTask(s string) string {
+	s = strings.ToLower(strings.NewReplacer("*", "", "_", "", "`", "").Replace(s))
+	return strings.TrimRight(strings.Join(strings.Fields(s), " "), ".")
+}
+
+// suggestTaskOwners picks the owners of each sub-task: the users owning the
+// files the model maps it to in CODEOWNERS, and those who recently committed
+// to them. Teams can't be assigned and are left out, as are bots.
+func (b *Bot) suggestTaskOwners(ctx context.Context, client *github.Client, repo *github.Repository, prd string, tasks []string, limit int) ([]taskOwners, error) {
+	repoOwner, repoName := repo.GetOwner().GetLogin(), repo.GetName()
+	paths, err := listRepoFiles(ctx, client, repo, maxOwnershipTreeFiles)
+	if err != nil {
+		return nil, fmt.Errorf("listing files: %w", err)
+	}
+	files, err := generateTaskFiles(ctx, b.llm, prd, tasks, paths)
+	if err != nil {
+		return nil, err
+	}
+	rules := fetchCodeowners(ctx, client, repoOwner, repoName)
+	since := time.Now().Add(-ownershipHistory)
+	history := make(map[string]map[string]int) // path -> login -> commits
+	isUser := func(login string) bool {
+		return login != "" && !strings.Contains(login, "/") && !strings.HasSuffix(login, "[bot]") && !strings.EqualFold(login, b.appName)
+	}
+
+	var suggestions []taskOwners
+	for i, task := range tasks {
+		type candidate struct {
+			login         string
+			score         int
+			owned, edited []string
+		}
+		candidates := make(map[string]*candidate)
+		get := func(login string) *candidate {
+			key := strings.ToLower(login)
+			if candidates[key] == nil {
+				candidates[key] = &candidate{login: login}
+			}
+			return candidates[key]
+		}
+		for _, path := range files[i] {
+			// The catch-all owners are everyone's fallback, not owners.
+			if rule := codeownersFor(rules, path); rule != nil && rule.Pattern != "*" {
+				for _, owner := range rule.Owners {
+					if login, ok := strings.CutPrefix(owner, "@"); ok && isUser(login) {
+						c := get(login)
+						c.score += codeownerWeight
+						c.owned = append(c.owned, path)
+					}
+				}
+			}
+			if history[path] == nil {
+				history[path] = recentCommitters(ctx, client, repoOwner, repoName, path, since)
+			}
+			for login, commits := range history[path] {
+				if isUser(login) {
+					c := get(login)
+					c.score += commits
+					c.edited = append(c.edited, path)
+				}
+			}
+		}
+		ranked := make([]*candidate, 0, len(candidates))
+		for _, c := range candidates {
+			ranked = append(ranked, c)
+		}
+		slices.SortFunc(ranked, func(a, b *candidate) int { return cmp.Or(b.score-a.score, strings.Compare(a.login, b.login)) })
+		suggestion := taskOwners{Task: task}
+		for _, c := range ranked[:min(limit, len(ranked))] {
+			var reasons []string
+			if len(c.owned) > 0 {
+				reasons = append(reasons, fmt.Sprintf("owns `%s`", strings.Join(c.owned, "`, `")))
+			}
+			if len(c.edited) > 0 {
+				reasons = append(reasons, fmt.Sprintf("recently changed `%s`", strings.Join(c.edited, "`, `")))
+			}
+			suggestion.Owners = append(suggestion.Owners, taskOwner{Login: c.login, Reason: strings.Join(reasons, ", ")})
+		}
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, nil
+}
+
+// recentCommitters counts the commits each user made to path since since.
+func recentCommitters(ctx context.Context, client *github.Client, owner, repo, path string, since time.Time) map[string]int {
+	commits, _, err := client.Repositories.ListCommits(ctx, owner, repo, &github.CommitsListOptions{Path: path, Since: since, ListOptions: github.ListOptions{PerPage: 100}})
+	if err != nil {
+		log.Printf("Error listing the commits to %s in %s/%s: %v", path, owner, repo, err)
+		return nil
+	}
+	counts := make(map[string]int)
+	for _, c := range commits {
+		if author := c.GetAuthor(); author.GetType() != "Bot" && author.GetLogin() != "" {
+			counts[author.GetLogin()]++
+		}
+	}
+	return counts
+}
+
+// generateTaskFiles asks the model which files each sub-task will most
+// likely change. Paths that aren't in the repository are dropped.
+func generateTaskFiles(ctx context.Context, llm Generator, prd string, tasks, paths []string) (map[int][]string, error) {
+	var list strings.Builder
+	for i, task := range tasks {
+		fmt.Fprintf(&list, "%d. %s\n", i+1, task)
+	}
+	prompt := fmt.Sprintf(
+		"For each sub-task of the following Product Requirements Document (PRD), pick the repository files it will most likely change, at most %d per sub-task. Leave the list empty when no existing file fits.\n\n"+
+			"Only choose paths from the list below. Respond with JSON only, in this format:\n"+
+			`{"tasks": [{"task": 1, "files": ["internal/export/csv.go"]}]}`+"\n\n"+
+			"**Sub-tasks:**\n%s\n"+
+			"**Repository Files:**\n%s\n\n"+
+			"**PRD:**\n%s",
+		maxTaskFiles, list.String(), strings.Join(paths, "\n"), prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptTaskFiles), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to map sub-tasks to files: %w", err)
+	}
+	var result struct {
+		Tasks []struct {
+			Task  int      `json:"task"`
+			Files []string `json:"files"`
+		} `json:"tasks"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	files := make(map[int][]string)
+	for _, t := range result.Tasks {
+		for _, f := range t.Files {
+			if t.Task >= 1 && t.Task <= len(tasks) && slices.Contains(paths, f) && !slices.Contains(files[t.Task-1], f) && len(files[t.Task-1]) < maxTaskFiles {
+				files[t.Task-1] = append(files[t.Task-1], f)
+			}
+		}
+	}
+	return files, nil
+}
+
+// formatTaskOwners renders the suggested owners. Logins are written as code
+// so GitHub doesn't notify them.
+func formatTaskOwners(suggestions []taskOwners, autoAssign bool) string {
+	var b strings.Builder
+	b.WriteString(OwnersIdentifier + "\n\nBased on `CODEOWNERS` and recent commits to the files each sub-task will likely change:\n\n")
+	for _, s := range suggestions {
+		if len(s.Owners) == 0 {
+			continue
+		}
+		var owners []string
+		for _, o := range s.Owners {
+			owners = append(owners, fmt.Sprintf("`%s` (%s)", o.Login, o.Reason))
+		}
+		fmt.Fprintf(&b, "- %s: %s\n", s.Task, strings.Join(owners, "; "))
+	}
+	if autoAssign {
+		b.WriteString("\nSub-issues created from these sub-tasks are assigned to their suggested owners.\n")
+	}
+	return b.String()
+}
+
+// addTaskOwners appends the owners suggested for the sub-tasks to their
+// comment and remembers them for auto-assignment. The comment is returned
+// unchanged when no owner could be found.
+func (b *Bot) addTaskOwners(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, prd, subTasks string, cfg *SubTaskOwnersConfig) string {
+	tasks := parseSubTasks(subTasks)
+	if len(tasks) == 0 {
+		return subTasks
+	}
+	suggestions, err := b.suggestTaskOwners(ctx, client, repo, prd, tasks, cfg.max())
+	if err != nil {
+		log.Printf("Could not suggest owners for the sub-tasks of issue #%d: %v", issueNum, err)
+		return subTasks
+	}
+	if !slices.ContainsFunc(suggestions, func(s taskOwners) bool { return len(s.Owners) > 0 }) {
+		return subTasks
+	}
+	if err := b.store.Put(bucketTaskOwners, issueKey(repo.GetOwner().GetLogin(), repo.GetName(), issueNum), suggestions); err != nil {
+		log.Printf("Error saving the sub-task owners of issue #%d: %v", issueNum, err)
+	}
+	return strings.TrimRight(subTasks, "\n") + "\n\n" + formatTaskOwners(suggestions, cfg.autoAssign())
+}
+
+// subIssuesEvent is the part of a sub_issues webhook the bot uses. The
+// GitHub client library doesn't know this event yet.
+type subIssuesEvent struct {
+	Action       string               `json:"action"`
+	SubIssue     *github.Issue        `json:"sub_issue"`
+	ParentIssue  *github.Issue        `json:"parent_issue"`
+	Repo         *github.Repository   `json:"repository"`
+	Installation *github.Installation `json:"installation"`
+}
+
+// handleSubIssues refreshes the progress of the parent issue, and assigns a
+// new sub-issue to the owners suggested for the sub-task it was created
+// from, when the repository enables auto_assign.
+func (b *Bot) handleSubIssues(ctx context.Context, payload []byte) error {
+	var e subIssuesEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidEvent, err)
+	}
+	if (e.Action != "sub_issue_added" && e.Action != "sub_issue_removed") || e.SubIssue == nil || e.ParentIssue == nil || e.Repo == nil {
+		return nil
+	}
+	installationID := e.Installation.GetID()
+	b.rememberInstallation(e.Repo, installationID)
+	client, err := b.clients.Client(installationID)
+	if err != nil {
+		log.Printf("Error creating GitHub client for the sub-issue: %v", err)
+		return nil
+	}
+	b.dispatch(ctx, func() {
+		ctx := context.Background()
+		if cfg := b.repoConfig(ctx, client, e.Repo).EpicProgress; cfg.enabled() {
+			b.refreshEpic(ctx, client, e.Repo, e.ParentIssue.GetNumber(), cfg)
+		}
+	})
+	if e.Action != "sub_issue_added" || len(e.SubIssue.Assignees) > 0 {
+		return nil
+	}
+	b.dispatch(ctx, func() {
+		ctx := context.Background()
+		repoOwner, repoName := e.Repo.GetOwner().GetLogin(), e.Repo.GetName()
+		if !b.repoConfig(ctx, client, e.Repo).SubTaskOwners.autoAssign() {
+			return
+		}
+		var suggestions []taskOwners
+		if ok, err := b.store.Get(bucketTaskOwners, issueKey(repoOwner, repoName, e.ParentIssue.GetNumber()), &suggestions); err != nil || !ok {
+			return
+		}
+		title := normalizeTask(e.SubIssue.GetTitle())
+		i := slices.IndexFunc(suggestions, func(s taskOwners) bool { return normalizeTask(s.Task) == title })
+		if i < 0 || len(suggestions[i].Owners) == 0 {
+			log.Printf("Sub-issue #%d doesn't match a sub-task with suggested owners.", e.SubIssue.GetNumber())
+			return
+		}
+		var logins []string
+		for _, o := range suggestions[i].Owners {
+			logins = append(logins, o.Login)
+		}
+		log.Printf("Assigning sub-issue #%d in %s/%s to %s.", e.SubIssue.GetNumber(), repoOwner, repoName, strings.Join(logins, ", "))
+		if _, _, err := client.Issues.AddAssignees(ctx, repoOwner, repoName, e.SubIssue.GetNumber(), logins); err != nil {
+			log.Printf("Error assigning sub-issue #%d: %v", e.SubIssue.GetNumber(), err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const ownershipPRD = PRDIdentifier + prdSeparator + "1.  **Background:** Users export reports as CSV."
+
+func setUpOwnership(env *testEnv, config string) {
+	env.github.addFile("acme", "widgets", RepoConfigPath, config)
+	env.github.addFile("acme", "widgets", ".github/CODEOWNERS", "* @acme/everyone\n/internal/export/ @alice @acme/exporters\n")
+	env.github.addFile("acme", "widgets", "internal/export/csv.go", "package export\n")
+	env.github.addFile("acme", "widgets", "web/app.js", "render()\n")
+	env.github.history["acme/widgets/web/app.js"] = []string{"bob", "bob", "carol", "dependabot[bot]"}
+	env.github.history["acme/widgets/internal/export/csv.go"] = []string{"carol"}
+	env.github.addComment("acme", "widgets", 42, ownershipPRD)
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the **CSV** encoder.\n- [ ] Add the export button.\n- [ ] Write the announcement.")
+	env.gemini.on("For each sub-task", `{"tasks": [
+		{"task": 1, "files": ["internal/export/csv.go", "missing.go"]},
+		{"task": 2, "files": ["web/app.js"]},
+		{"task": 3, "files": []}
+	]}`)
+}
+
+func TestSubTaskOwnersSuggested(t *testing.T) {
+	env := newTestEnv(t)
+	setUpOwnership(env, "sub_task_owners:\n  enabled: true\n")
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	for _, want := range []string{
+		OwnersIdentifier,
+		"- Add the **CSV** encoder.: `alice` (owns `internal/export/csv.go`); `carol` (recently changed `internal/export/csv.go`)",
+		"- Add the export button.: `bob` (recently changed `web/app.js`); `carol` (recently changed `web/app.js`)",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	_, owners, _ := strings.Cut(body, OwnersIdentifier)
+	for _, unwanted := range []string{"everyone", "exporters", "dependabot", "announcement", "are assigned"} {
+		if strings.Contains(owners, unwanted) {
+			t.Errorf("unexpected %q in:\n%s", unwanted, owners)
+		}
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactSubTasks); artifact == nil || artifact.Markdown != stripArtifactMeta(body) {
+		t.Errorf("the sub-task artifact should include the owners, got %+v", artifact)
+	}
+}
+
+func TestSubTaskOwnersOffByDefault(t *testing.T) {
+	env := newTestEnv(t)
+	setUpOwnership(env, "auto_prd: true\n")
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); strings.Contains(body, OwnersIdentifier) {
+		t.Errorf("owners shouldn't be suggested unless enabled:\n%s", body)
+	}
+}
+
+// subIssuePayload is a sub_issues webhook adding sub-issue number to #42.
+func subIssuePayload(t *testing.T, number int, title string, assignees ...string) []byte {
+	t.Helper()
+	sub := &github.Issue{Number: github.Int(number), Title: github.String(title)}
+	for _, login := range assignees {
+		sub.Assignees = append(sub.Assignees, &github.User{Login: github.String(login)})
+	}
+	payload, err := json.Marshal(map[string]any{
+		"action":       "sub_issue_added",
+		"sub_issue":    sub,
+		"parent_issue": &github.Issue{Number: github.Int(42)},
+		"repository":   map[string]any{"name": "widgets", "full_name": "acme/widgets", "default_branch": "main", "owner": map[string]any{"login": "acme"}},
+		"installation": map[string]any{"id": 7},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload
+}
+
+func TestSubIssuesAssignedToSuggestedOwners(t *testing.T) {
+	env := newTestEnv(t)
+	setUpOwnership(env, "sub_task_owners:\n  enabled: true\n  auto_assign: true\n  max: 1\n")
+	env.comment(t, "@prd-bot need_sub_task")
+	if comments := env.github.issueComments("acme", "widgets", 42); !strings.Contains(comments[len(comments)-1].GetBody(), "are assigned to their suggested owners") {
+		t.Errorf("the comment should announce auto-assignment:\n%s", comments[len(comments)-1].GetBody())
+	}
+	created := env.github.addIssue("acme", "widgets", 101, "Add the CSV encoder", "open")
+	taken := env.github.addIssue("acme", "widgets", 102, "Add the export button", "open")
+	unrelated := env.github.addIssue("acme", "widgets", 103, "Fix the logo", "open")
+
+	env.deliverPayload(t, "sub_issues", subIssuePayload(t, 101, "Add the CSV encoder"))
+	env.deliverPayload(t, "sub_issues", subIssuePayload(t, 102, "Add the export button", "dave"))
+	env.deliverPayload(t, "sub_issues", subIssuePayload(t, 103, "Fix the logo"))
+
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	if len(created.Assignees) != 1 || created.Assignees[0].GetLogin() != "alice" {
+		t.Errorf("the sub-issue should be assigned to alice, got %v", created.Assignees)
+	}
+	if len(taken.Assignees) != 0 || len(unrelated.Assignees) != 0 {
+		t.Errorf("assigned or unrelated sub-issues should be left alone, got %v and %v", taken.Assignees, unrelated.Assignees)
+	}
+}
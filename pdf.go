@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page layout for renderTextPDF: US Letter, 10pt Courier (6pt per glyph).
+const (
+	pdfPageWidth    = 612
+	pdfPageHeight   = 792
+	pdfMargin       = 50
+	pdfFontSize     = 10
+	pdfLineHeight   = 12
+	pdfCharsPerLine = (pdfPageWidth - 2*pdfMargin) / 6
+	pdfLinesPerPage = (pdfPageHeight - 2*pdfMargin) / pdfLineHeight
+)
+
+// renderTextPDF lays out text as a plain monospaced PDF document. It only
+// uses the standard Courier font, so characters outside WinAnsi (for example
+// CJK text in translated PRDs) are replaced with '?'; callers check
+// pdfEncodable first and offer the Markdown or JSON export instead.
+func renderTextPDF(title, text string) []byte {
+	lines := append(wrapPDFText(title), "")
+	for _, line := range strings.Split(text, "\n") {
+		lines = append(lines, wrapPDFText(strings.TrimSuffix(line, "\r"))...)
+	}
+	var pages [][]string
+	for len(lines) > 0 {
+		n := min(len(lines), pdfLinesPerPage)
+		pages = append(pages, lines[:n])
+		lines = lines[n:]
+	}
+
+	var buf bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n")
+	// Objects 1-3 are the catalog, page tree and font; each page then takes
+	// a page object followed by its content stream.
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+2*i))
+		var content strings.Builder
+		fmt.Fprintf(&content, "BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin)
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", pdfString(line))
+		}
+		content.WriteString("ET")
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return buf.Bytes()
+}
+
+// wrapPDFText splits line into chunks of at most pdfCharsPerLine runes,
+// breaking at spaces where possible.
+func wrapPDFText(line string) []string {
+	runes := []rune(strings.ReplaceAll(line, "\t", "    "))
+	if len(runes) == 0 {
+		return []string{""}
+	}
+	var wrapped []string
+	for len(runes) > pdfCharsPerLine {
+		cut := pdfCharsPerLine
+		for i := pdfCharsPerLine; i > pdfCharsPerLine/2; i-- {
+			if runes[i] == ' ' {
+				cut = i
+				break
+			}
+		}
+		wrapped = append(wrapped, string(runes[:cut]))
+		runes = []rune(strings.TrimLeft(string(runes[cut:]), " "))
+	}
+	return append(wrapped, string(runes))
+}
+
+// winAnsiPunctuation maps the characters WinAnsiEncoding places between
+// 0x80 and 0x9f, such as the typographic quotes and dashes models like to
+// write, to their codes.
+var winAnsiPunctuation = map[rune]byte{
+	'€': 0x80, '‚': 0x82, 'ƒ': 0x83, '„': 0x84, '…': 0x85, '†': 0x86, '‡': 0x87, 'ˆ': 0x88,
+	'‰': 0x89, 'Š': 0x8a, '‹': 0x8b, 'Œ': 0x8c, 'Ž': 0x8e, '‘': 0x91, '’': 0x92, '“': 0x93,
+	'”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '˜': 0x98, '™': 0x99, 'š': 0x9a, '›': 0x9b,
+	'œ': 0x9c, 'ž': 0x9e, 'Ÿ': 0x9f,
+}
+
+// winAnsiCode returns the WinAnsi code of r, and whether it has one.
+func winAnsiCode(r rune) (byte, bool) {
+	if (r >= 0x20 && r < 0x7f) || (r >= 0xa0 && r <= 0xff) {
+		return byte(r), true
+	}
+	code, ok := winAnsiPunctuation[r]
+	return code, ok
+}
+
+// pdfEncodable reports whether renderTextPDF can show every character of s.
+func pdfEncodable(s string) bool {
+	for _, r := range s {
+		if _, ok := winAnsiCode(r); !ok && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// pdfString encodes s as the body of a PDF literal string in WinAnsi.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		code, ok := winAnsiCode(r)
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case ok:
+			fmt.Fprintf(&b, "\\%03o", code)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandRun runs a pipeline of the repository configuration:
+	// `run <name>`, `run` to list them and `run --cancel` to stop the one
+	// waiting for approval.
+	CommandRun = "run"
+	// CommandApprove continues the pipeline waiting for approval on the
+	// issue.
+	CommandApprove = "approve"
+
+	// PipelineIdentifier marks the progress comments of pipelines.
+	PipelineIdentifier = "### Pipeline"
+
+	// bucketPipelines holds the pipelineRun waiting for approval, keyed by
+	// issueKey.
+	bucketPipelines = "pipelines"
+
+	pipelineCancelFlag = "--cancel"
+)
+
+// pipelineCommands drive pipelines and can't be their steps.
+var pipelineCommands = []string{CommandRun, CommandApprove}
+
+// PipelineConfig is a named sequence of commands, declared under
+// `pipelines` in the repository configuration, that `run <name>` runs
+// one after the other on an issue or pull request.
+type PipelineConfig struct {
+	Description string         `yaml:"description"`
+	Steps       []PipelineStep `yaml:"steps"`
+}
+
+// PipelineStep is a step of a pipeline: a command to run, or an approval
+// to wait for.
+type PipelineStep struct {
+	// Run is the command and its arguments, e.g. "need_capacity_plan --appendix".
+	Run string `yaml:"run" json:"run,omitempty"`
+	// If skips the step unless every comma-separated condition holds:
+	// "label:<name>", "!label:<name>", "has:<artifact>" (e.g. has:prd),
+	// "!has:<artifact>", "pull_request" or "issue".
+	If string `yaml:"if" json:"if,omitempty"`
+	// Approval pauses the pipeline with this message until someone runs
+	// `approve`.
+	Approval string `yaml:"approval" json:"approval,omitempty"`
+	// Approvers lists who may approve; repository maintainers when empty.
+	Approvers []string `yaml:"approvers" json:"approvers,omitempty"`
+}
+
+// describe names the step in comments.
+func (s PipelineStep) describe() string {
+	if s.Approval != "" {
+		return "approval"
+	}
+	return "`" + s.Run + "`"
+}
+
+// pipelineRun is a pipeline paused at an approval step.
+type pipelineRun struct {
+	Owner          string         `json:"owner"`
+	Repo           string         `json:"repo"`
+	Issue          int            `json:"issue"`
+	InstallationID int64          `json:"installation_id"`
+	Pipeline       string         `json:"pipeline"`
+	Steps          []PipelineStep `json:"steps"` // as configured when the run started
+	Next           int            `json:"next"`  // index of the approval step it waits at
+	StartedBy      string         `json:"started_by"`
+	CreatedAt      time.Time      `json:"created_at"`
+}
+
+// validatePipeline reports the steps of pipeline that can't run.
+func (b *Bot) validatePipeline(pipeline *PipelineConfig) []string {
+	if pipeline == nil || len(pipeline.Steps) == 0 {
+		return []string{"it has no steps"}
+	}
+	var problems []string
+	for i, step := range pipeline.Steps {
+		n := i + 1
+		command := strings.Fields(step.Run)
+		switch {
+		case (len(command) == 0) == (step.Approval == ""):
+			problems = append(problems, fmt.Sprintf("step %d needs either `run` or `approval`", n))
+		case len(command) > 0 && b.commands[command[0]] == nil:
+			problems = append(problems, fmt.Sprintf("step %d runs `%s`, which isn't a command", n, command[0]))
+		case len(command) > 0 && (slices.Contains(pipelineCommands, command[0]) || slices.Contains(bodyForbiddenCommands, command[0])):
+			problems = append(problems, fmt.Sprintf("step %d runs `%s`, which can't run in a pipeline", n, command[0]))
+		}
+		if _, err := parseStepConditions(step.If); err != nil {
+			problems = append(problems, fmt.Sprintf("step %d: %v", n, err))
+		}
+	}
+	return problems
+}
+
+// stepCondition is a condition of a step's `if`.
+type stepCondition struct {
+	negate bool
+	kind   string // "label", "has", "pull_request" or "issue"
+	value  string
+}
+
+func parseStepConditions(s string) ([]stepCondition, error) {
+	var conditions []stepCondition
+	for _, term := range strings.Split(s, ",") {
+		term = strings.TrimSpace(term)
+		if term == "" {
+			continue
+		}
+		var c stepCondition
+		term, c.negate = strings.CutPrefix(term, "!")
+		c.kind, c.value, _ = strings.Cut(term, ":")
+		switch {
+		case (c.kind == "label" || c.kind == "has") && c.value != "":
+		case (c.kind == "pull_request" || c.kind == "issue") && c.value == "":
+		default:
+			return nil, fmt.Errorf("unknown condition %q: use label:<name>, has:<artifact>, pull_request or issue, optionally negated with !", term)
+		}
+		conditions = append(conditions, c)
+	}
+	return conditions, nil
+}
+
+// stepApplies evaluates the conditions of step against the issue as it is
+// now, since earlier steps may have labeled it or generated its artifacts.
+func (b *Bot) stepApplies(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, step PipelineStep) (bool, error) {
+	conditions, err := parseStepConditions(step.If)
+	if err != nil || len(conditions) == 0 {
+		return err == nil, err
+	}
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	issue, _, err := client.Issues.Get(ctx, owner, name, issueNum)
+	if err != nil {
+		return false, fmt.Errorf("reading #%d: %w", issueNum, err)
+	}
+	for _, c := range conditions {
+		var holds bool
+		switch c.kind {
+		case "label":
+			holds = slices.ContainsFunc(issue.Labels, func(l *github.Label) bool { return strings.EqualFold(l.GetName(), c.value) })
+		case "has":
+			if c.value == ArtifactPRD {
+				holds = b.hasPRD(ctx, client, repo, issueNum)
+			} else {
+				artifact, _ := b.loadArtifact(owner, name, issueNum, c.value)
+				holds = artifact != nil
+			}
+		case "pull_request":
+			holds = issue.IsPullRequest()
+		case "issue":
+			holds = !issue.IsPullRequest()
+		}
+		if holds == c.negate {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// processRun starts the pipeline named by args, lists the configured ones
+// without args, and cancels the one waiting for approval with --cancel.
+func (b *Bot) processRun(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandRun, issueNum, repoOwner, repoName)
+	key := issueKey(repoOwner, repoName, issueNum)
+	pipelines := b.repoConfig(ctx, client, repo).Pipelines
+
+	if len(args) == 0 {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, b.formatPipelineList(pipelines))
+		return
+	}
+	var waiting pipelineRun
+	isWaiting, err := b.store.Get(bucketPipelines, key, &waiting)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "run the pipeline", "Could not read the pipeline state of this issue", err)
+		return
+	}
+	if args[0] == pipelineCancelFlag {
+		if !isWaiting {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, "No pipeline is waiting for approval on this issue.")
+			return
+		}
+		if err := b.store.Delete(bucketPipelines, key); err != nil {
+			b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "cancel the pipeline", "Could not update the pipeline state of this issue", err)
+			return
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("%s `%s`\n\nCancelled before step %d of %d.", PipelineIdentifier, waiting.Pipeline, waiting.Next+1, len(waiting.Steps)))
+		return
+	}
+	if isWaiting {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("Pipeline `%s` is already waiting for approval on this issue. Reply `@%s %s` to continue it or `@%s %s %s` to cancel it first.", waiting.Pipeline, b.appName, CommandApprove, b.appName, CommandRun, pipelineCancelFlag))
+		return
+	}
+
+	name := args[0]
+	pipeline, ok := pipelines[name]
+	if !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("There is no pipeline named `%s` in `%s`.\n\n%s", name, RepoConfigPath, b.formatPipelineList(pipelines)))
+		return
+	}
+	if problems := b.validatePipeline(pipeline); len(problems) > 0 {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I can't run pipeline `%s` of `%s`:\n- %s", name, RepoConfigPath, strings.Join(problems, "\n- ")))
+		return
+	}
+	run := &pipelineRun{
+		Owner:          repoOwner,
+		Repo:           repoName,
+		Issue:          issueNum,
+		InstallationID: installationID,
+		Pipeline:       name,
+		Steps:          slices.Clone(pipeline.Steps),
+		StartedBy:      commandSender(ctx).GetLogin(),
+		CreatedAt:      time.Now().UTC(),
+	}
+	b.runPipeline(ctx, client, issue, repo, installationID, run, 0)
+}
+
+// processApprove continues the pipeline waiting for approval on the issue,
+// when the sender may approve its current step.
+func (b *Bot) processApprove(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, _ []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandApprove, issueNum, repoOwner, repoName)
+	key := issueKey(repoOwner, repoName, issueNum)
+
+	var run pipelineRun
+	if ok, err := b.store.Get(bucketPipelines, key, &run); err != nil || !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("No pipeline is waiting for approval on this issue. Run `@%s %s` to list the pipelines.", b.appName, CommandRun))
+		return
+	}
+	step := run.Steps[run.Next]
+	if len(step.Approvers) > 0 {
+		sender := commandSender(ctx).GetLogin()
+		if !slices.ContainsFunc(step.Approvers, func(login string) bool { return strings.EqualFold(login, sender) }) {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("@%s, only %s can approve this step of pipeline `%s`.", sender, mentionList(step.Approvers), run.Pipeline))
+			return
+		}
+	} else if !b.requireMaintainer(ctx, client, repo, issueNum, CommandApprove) {
+		return
+	}
+	// Removing the run first makes a concurrent approval find nothing.
+	if err := b.store.Delete(bucketPipelines, key); err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "continue the pipeline", "Could not update the pipeline state of this issue", err)
+		return
+	}
+	b.runPipeline(ctx, client, issue, repo, installationID, &run, run.Next+1)
+}
+
+// runPipeline runs the steps of run from start, through the same checks as
+// commented commands, until one fails, an approval step pauses the
+// pipeline or it completes. It reports the outcome in one comment.
+func (b *Bot) runPipeline(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, run *pipelineRun, start int) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	report := func(lines []string, status string) {
+		body := fmt.Sprintf("%s `%s`\n\n", PipelineIdentifier, run.Pipeline)
+		if len(lines) > 0 {
+			body += strings.Join(lines, "\n") + "\n\n"
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, body+status)
+	}
+	var lines []string
+	for i := start; i < len(run.Steps); i++ {
+		step := run.Steps[i]
+		n := fmt.Sprintf("%d/%d", i+1, len(run.Steps))
+		applies, err := b.stepApplies(ctx, client, repo, issueNum, step)
+		if err != nil {
+			lines = append(lines, fmt.Sprintf("- [ ] %s %s: could not check its condition", n, step.describe()))
+			report(lines, "The pipeline stopped.")
+			log.Printf("Error checking step %s of pipeline %s on %s: %v", n, run.Pipeline, issueKey(repoOwner, repoName, issueNum), err)
+			return
+		}
+		if !applies {
+			lines = append(lines, fmt.Sprintf("- %s %s: skipped (`%s`)", n, step.describe(), step.If))
+			continue
+		}
+		if step.Approval != "" {
+			run.Next = i
+			if err := b.store.Put(bucketPipelines, issueKey(repoOwner, repoName, issueNum), run); err != nil {
+				b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "pause the pipeline", "Could not save the pipeline state", err)
+				return
+			}
+			approvers := "a repository maintainer"
+			if len(step.Approvers) > 0 {
+				approvers = mentionList(step.Approvers)
+			}
+			lines = append(lines, fmt.Sprintf("- [ ] %s approval: %s", n, step.Approval))
+			report(lines, fmt.Sprintf("Waiting for %s to reply `@%s %s`, or `@%s %s %s` to stop here.", approvers, b.appName, CommandApprove, b.appName, CommandRun, pipelineCancelFlag))
+			return
+		}
+		fields := strings.Fields(step.Run)
+		log.Printf("Running step %s '%s' of pipeline %s on issue #%d in %s.", n, step.Run, run.Pipeline, issueNum, repo.GetFullName())
+		if !b.runCommandHandler(client, b.commands[fields[0]], fields[0], fields[1:], issue, repo, installationID, commandSender(ctx)) {
+			lines = append(lines, fmt.Sprintf("- [ ] %s %s: failed", n, step.describe()))
+			report(lines, "The pipeline stopped. Fix the problem above, then run the remaining steps yourself or start the pipeline again.")
+			return
+		}
+		lines = append(lines, fmt.Sprintf("- [x] %s %s", n, step.describe()))
+	}
+	report(lines, "The pipeline completed.")
+}
+
+// formatPipelineList lists the pipelines of the repository configuration.
+func (b *Bot) formatPipelineList(pipelines map[string]*PipelineConfig) string {
+	if len(pipelines) == 0 {
+		return fmt.Sprintf("This repository has no pipelines. Declare them under `pipelines` in `%s`.", RepoConfigPath)
+	}
+	names := make([]string, 0, len(pipelines))
+	for name := range pipelines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Pipelines of this repository, run with `@%s %s <name>`:\n", b.appName, CommandRun)
+	for _, name := range names {
+		p := pipelines[name]
+		var steps []string
+		for _, step := range p.Steps {
+			steps = append(steps, step.describe())
+		}
+		fmt.Fprintf(&sb, "- `%s`", name)
+		if p.Description != "" {
+			sb.WriteString(": " + p.Description)
+		}
+		if len(steps) > 0 {
+			sb.WriteString(" (" + strings.Join(steps, " → ") + ")")
+		}
+		sb.WriteString("\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// mentionList mentions logins as "@a, @b".
+func mentionList(logins []string) string {
+	mentions := make([]string, len(logins))
+	for i, login := range logins {
+		mentions[i] = "@" + strings.TrimPrefix(login, "@")
+	}
+	return strings.Join(mentions, ", ")
+}
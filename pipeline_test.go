@@ -0,0 +1,167 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const releasePrepConfig = `pipelines:
+  release-prep:
+    description: Get a feature ready to ship
+    steps:
+      - run: feedback First step ran.
+      - run: feedback Frontend only.
+        if: label:frontend
+      - approval: Check the feedback before going on.
+      - run: feedback After approval.
+`
+
+func TestPipelineRunsStepsAndWaitsForApproval(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, releasePrepConfig)
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+
+	env.comment(t, "@prd-bot run release-prep")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 2 || !strings.Contains(comments[0].GetBody(), "recorded your feedback") {
+		t.Fatalf("the first step should run before the pipeline pauses: %v", comments)
+	}
+	want := PipelineIdentifier + " `release-prep`\n\n" +
+		"- [x] 1/4 `feedback First step ran.`\n" +
+		"- 2/4 `feedback Frontend only.`: skipped (`label:frontend`)\n" +
+		"- [ ] 3/4 approval: Check the feedback before going on.\n\n" +
+		"Waiting for a repository maintainer to reply `@prd-bot approve`, or `@prd-bot run --cancel` to stop here."
+	if body := comments[1].GetBody(); body != want {
+		t.Errorf("unexpected pipeline comment:\n%s\nwant:\n%s", body, want)
+	}
+
+	env.comment(t, "@prd-bot approve")
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 3 || !strings.Contains(comments[2].GetBody(), "only repository maintainers and admins can run `approve`") {
+		t.Fatalf("only maintainers should approve: %v", comments)
+	}
+
+	env.github.setRole("alice", "maintain")
+	env.comment(t, "@prd-bot approve")
+
+	comments = env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 5 {
+		t.Fatalf("expected the last step and the pipeline summary, got %v", comments)
+	}
+	want = PipelineIdentifier + " `release-prep`\n\n- [x] 4/4 `feedback After approval.`\n\nThe pipeline completed."
+	if body := comments[4].GetBody(); body != want {
+		t.Errorf("unexpected pipeline comment:\n%s\nwant:\n%s", body, want)
+	}
+	if feedback := env.bot.repoFeedback("acme", "widgets"); len(feedback) != 2 || feedback[0].Text != "After approval." {
+		t.Errorf("the run steps should record their feedback: %+v", feedback)
+	}
+	if ok, _ := env.bot.store.Get(bucketPipelines, issueKey("acme", "widgets", 42), &pipelineRun{}); ok {
+		t.Error("the completed pipeline should no longer wait")
+	}
+}
+
+func TestPipelineStepConditionsSeeLabels(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, releasePrepConfig)
+	issue := env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.mu.Lock()
+	issue.Labels = append(issue.Labels, &github.Label{Name: github.String("Frontend")})
+	env.github.mu.Unlock()
+
+	env.comment(t, "@prd-bot run release-prep")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 3 || !strings.Contains(comments[2].GetBody(), "- [x] 2/4 `feedback Frontend only.`") {
+		t.Errorf("the labeled issue should run the frontend step: %v", comments)
+	}
+}
+
+func TestPipelineStopsAtFailedStep(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "disabled_commands: [budget]\n"+`pipelines:
+  ship:
+    steps:
+      - run: feedback Ready.
+      - run: budget
+      - run: feedback Never recorded.
+`)
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+
+	env.comment(t, "@prd-bot run ship")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 3 {
+		t.Fatalf("expected the first step, the declined one and the summary, got %v", comments)
+	}
+	body := comments[2].GetBody()
+	if !strings.Contains(body, "- [x] 1/3 `feedback Ready.`\n- [ ] 2/3 `budget`: failed\n\nThe pipeline stopped.") {
+		t.Errorf("unexpected pipeline comment:\n%s", body)
+	}
+	if feedback := env.bot.repoFeedback("acme", "widgets"); len(feedback) != 1 {
+		t.Errorf("the steps after the failed one shouldn't run: %+v", feedback)
+	}
+}
+
+func TestPipelineValidation(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, `pipelines:
+  broken:
+    steps:
+      - run: deploy_everything
+      - run: run broken
+      - run: feedback Both.
+        approval: Or an approval?
+      - approval: Go?
+        if: milestone:v2
+`)
+
+	env.comment(t, "@prd-bot run broken")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected one comment, got %v", comments)
+	}
+	for _, want := range []string{
+		"I can't run pipeline `broken`",
+		"step 1 runs `deploy_everything`, which isn't a command",
+		"step 2 runs `run`, which can't run in a pipeline",
+		"step 3 needs either `run` or `approval`",
+		`step 4: unknown condition "milestone:v2"`,
+	} {
+		if !strings.Contains(comments[0].GetBody(), want) {
+			t.Errorf("the comment should contain %q:\n%s", want, comments[0].GetBody())
+		}
+	}
+}
+
+func TestRunListsAndCancelsPipelines(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, releasePrepConfig)
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+
+	env.comment(t, "@prd-bot run")
+	comments := env.github.issueComments("acme", "widgets", 42)
+	want := "Pipelines of this repository, run with `@prd-bot run <name>`:\n" +
+		"- `release-prep`: Get a feature ready to ship (`feedback First step ran.` → `feedback Frontend only.` → approval → `feedback After approval.`)"
+	if len(comments) != 1 || comments[0].GetBody() != want {
+		t.Fatalf("unexpected list: %v", comments)
+	}
+
+	env.comment(t, "@prd-bot run release-prep")
+	env.comment(t, "@prd-bot run release-prep")
+	comments = env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "already waiting for approval") {
+		t.Errorf("a second run should be refused while one waits: %s", last)
+	}
+
+	env.comment(t, "@prd-bot run --cancel")
+	comments = env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); last != PipelineIdentifier+" `release-prep`\n\nCancelled before step 3 of 4." {
+		t.Errorf("unexpected cancel comment: %s", last)
+	}
+	if ok, _ := env.bot.store.Get(bucketPipelines, issueKey("acme", "widgets", 42), &pipelineRun{}); ok {
+		t.Error("the cancelled pipeline should no longer wait")
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandProceed approves the implementation plan posted by implement_feature.
+	CommandProceed = "proceed"
+
+	// PlanIdentifier marks comments holding an implementation plan.
+	PlanIdentifier = "### Implementation Plan"
+
+	// bucketPlans holds the pendingPlan awaiting approval, keyed by issueKey.
+	bucketPlans = "plans"
+
+	planCheckInterval = time.Minute
+)
+
+// PlanPreviewConfig makes implement_feature post an implementation plan and
+// wait for approval before editing any file.
+type PlanPreviewConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// AutoProceedAfter is a duration such as "2h" after which the plan is
+	// implemented without approval. Empty waits for `proceed` forever.
+	AutoProceedAfter string `yaml:"auto_proceed_after"`
+}
+
+func (c *PlanPreviewConfig) enabled() bool { return c != nil && c.Enabled }
+
+// autoProceedAfter returns the auto-proceed delay, or zero when there is none.
+func (c *PlanPreviewConfig) autoProceedAfter() time.Duration {
+	if c == nil || c.AutoProceedAfter == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(c.AutoProceedAfter)
+	if err != nil || d < 0 {
+		log.Printf("Ignoring invalid plan_preview.auto_proceed_after %q", c.AutoProceedAfter)
+		return 0
+	}
+	return d
+}
+
+// pendingPlan is an implementation plan waiting for `proceed`.
+type pendingPlan struct {
+	Owner          string    `json:"owner"`
+	Repo           string    `json:"repo"`
+	Issue          int       `json:"issue"`
+	InstallationID int64     `json:"installation_id"`
+	Plan           string    `json:"plan"`
+	Args           []string  `json:"args,omitempty"` // implement_feature arguments
+	CreatedAt      time.Time `json:"created_at"`
+	ProceedAt      time.Time `json:"proceed_at,omitempty"` // zero without auto-proceed
+}
+
+// postImplementationPlan generates the plan for issue, posts it and stores it
+// until it is approved.
+func (b *Bot) postImplementationPlan(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, args, files []string, cfg *PlanPreviewConfig) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	longContext := b.repoConfig(ctx, client, repo).LongContext
+	source, ok := b.packRepository(ctx, client, repo, longContext)
+	if ok {
+		ctx = withModel(ctx, longContext.model())
+	}
+	plan, err := generateImplementationPlan(ctx, b.llm, issue, files, source)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "plan the implementation", "Could not generate the implementation plan", err)
+		return
+	}
+
+	now := time.Now()
+	pending := &pendingPlan{Owner: repoOwner, Repo: repoName, Issue: issueNum, InstallationID: installationID, Plan: plan, Args: args, CreatedAt: now}
+	next := fmt.Sprintf("Reply `@%s %s` to implement it as planned, or edit the issue and run `@%s %s` again for a new plan.", b.appName, CommandProceed, b.appName, CommandImplementFeature)
+	if delay := cfg.autoProceedAfter(); delay > 0 {
+		pending.ProceedAt = now.Add(delay)
+		next += fmt.Sprintf(" Otherwise I'll proceed automatically in %s.", delay)
+	}
+	if err := b.store.Put(bucketPlans, issueKey(repoOwner, repoName, issueNum), pending); err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "plan the implementation", "Could not save the implementation plan", err)
+		return
+	}
+	body := fmt.Sprintf("%s\n\nBefore changing any code, here is how I plan to implement #%d:\n\n%s\n\n%s", PlanIdentifier, issueNum, strings.TrimSpace(plan), next)
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, body)
+}
+
+// generateImplementationPlan plans the implementation of issue, changing
+// files. source is the packed repository in long-context mode, or "".
+func generateImplementationPlan(ctx context.Context, llm Generator, issue *github.Issue, files []string, source string) (string, error) {
+	prompt := fmt.Sprintf(
+		"Write a step-by-step implementation plan for the feature described in the following GitHub issue, before any code is written. For each step, name the files to change, the functions or types to add or modify, and the tests to add. Keep it concise, as a numbered Markdown list, and finish with the main risks.\n\n"+
+			"**Issue Title:** %s\n\n**Issue Body:**\n%s\n\n**Files to modify:** %s",
+		issue.GetTitle(), issue.GetBody(), strings.Join(files, ", "),
+	)
+	if source != "" {
+		prompt += "\n\n**Repository Source:**\n" + source
+	}
+	plan, err := generateMarkdown(withSystemPrompt(ctx, promptPlan), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate the implementation plan: %w", err)
+	}
+	return plan, nil
+}
+
+// processProceed implements the pending plan of the issue.
+func (b *Bot) processProceed(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, _ []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	plan, ok := b.takePlan(repoOwner, repoName, issueNum)
+	if !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("There is no implementation plan waiting for approval. Run `@%s %s` to get one.", b.appName, CommandImplementFeature))
+		return
+	}
+	log.Printf("Proceeding with the implementation plan of issue #%d in %s/%s", issueNum, repoOwner, repoName)
+	b.implementFeature(ctx, client, issue, repo, installationID, plan.Args, plan.Plan)
+}
+
+// takePlan removes and returns the pending plan of the issue, so it is
+// implemented only once.
+func (b *Bot) takePlan(owner, repo string, issueNum int) (*pendingPlan, bool) {
+	key := issueKey(owner, repo, issueNum)
+	var plan pendingPlan
+	if ok, err := b.store.Get(bucketPlans, key, &plan); err != nil || !ok {
+		return nil, false
+	}
+	if err := b.store.Delete(bucketPlans, key); err != nil {
+		log.Printf("Error removing the implementation plan of %s: %v", key, err)
+		return nil, false
+	}
+	return &plan, true
+}
+
+// planLoop implements plans whose auto-proceed delay passed, every interval
+// until ctx is cancelled.
+func (b *Bot) planLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.proceedDuePlans(ctx, time.Now())
+		}
+	}
+}
+
+// proceedDuePlans starts implementing every plan due to auto-proceed at now.
+func (b *Bot) proceedDuePlans(ctx context.Context, now time.Time) {
+	docs, err := b.store.List(bucketPlans)
+	if err != nil {
+		log.Printf("Error listing implementation plans: %v", err)
+		return
+	}
+	for _, doc := range docs {
+		var due pendingPlan
+		if json.Unmarshal(doc, &due) != nil || due.ProceedAt.IsZero() || now.Before(due.ProceedAt) {
+			continue
+		}
+		if !b.allowed(due.InstallationID, due.Owner+"/"+due.Repo) {
+			continue
+		}
+		plan, ok := b.takePlan(due.Owner, due.Repo, due.Issue)
+		if !ok {
+			continue
+		}
+		b.dispatch(ctx, func() {
+			client, err := b.clients.Client(plan.InstallationID)
+			if err != nil {
+				log.Printf("Error creating GitHub client to auto-proceed on %s/%s#%d: %v", plan.Owner, plan.Repo, plan.Issue, err)
+				return
+			}
+			repo, _, err := client.Repositories.Get(ctx, plan.Owner, plan.Repo)
+			if err != nil {
+				log.Printf("Error loading %s/%s to auto-proceed: %v", plan.Owner, plan.Repo, err)
+				return
+			}
+			issue, _, err := client.Issues.Get(ctx, plan.Owner, plan.Repo, plan.Issue)
+			if err != nil || issue.GetState() != "open" {
+				log.Printf("Not auto-proceeding on %s/%s#%d: the issue is closed or unavailable (%v)", plan.Owner, plan.Repo, plan.Issue, err)
+				return
+			}
+			ctx := b.withInstallation(ctx, plan.InstallationID)
+			if status, ok := b.checkBudget(ctx, plan.InstallationID); !ok {
+				log.Printf("Installation %d is over its monthly budget. Not auto-proceeding on %s/%s#%d.", plan.InstallationID, plan.Owner, plan.Repo, plan.Issue)
+				b.postComment(ctx, client, plan.Owner, plan.Repo, plan.Issue, b.budgetExceededMessage(CommandImplementFeature, status))
+				return
+			}
+			if err := b.preflight(ctx, client, repo, plan.InstallationID); err != nil {
+				b.reportFailure(ctx, client, plan.Owner, plan.Repo, plan.Issue, fmt.Sprintf("run `%s`", CommandImplementFeature), preflightReason(err), err)
+				return
+			}
+			log.Printf("Auto-proceeding with the implementation plan of %s/%s#%d.", plan.Owner, plan.Repo, plan.Issue)
+			b.implementFeature(ctx, client, issue, repo, plan.InstallationID, plan.Args, plan.Plan)
+		})
+	}
+}
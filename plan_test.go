@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const testPlan = "1. Add `encodeCSV` to `export/csv.go` with a table test."
+
+func TestImplementFeatureWaitsForPlanApproval(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "plan_preview:\n  enabled: true\n")
+	env.gemini.on("Write a step-by-step implementation plan", testPlan)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if commands := env.runner.executed(); len(commands) != 0 {
+		t.Fatalf("nothing should run before the plan is approved, got %v", commands)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), PlanIdentifier) || !strings.Contains(comments[0].GetBody(), "`@prd-bot proceed`") {
+		t.Fatalf("expected the implementation plan, got %+v", comments)
+	}
+
+	env.comment(t, "@prd-bot proceed")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || !strings.Contains(pulls[0].GetBody(), testPlan) {
+		t.Fatalf("expected a pull request following the plan, got %+v", pulls)
+	}
+	var edit string
+	for _, c := range env.runner.executed() {
+		if strings.HasPrefix(c, "gemini ") {
+			edit = c
+		}
+	}
+	if !strings.Contains(edit, "Follow this approved implementation plan:\n"+testPlan) {
+		t.Errorf("the edit should follow the plan: %q", edit)
+	}
+
+	env.comment(t, "@prd-bot proceed")
+	if pulls := env.github.pullRequests(); len(pulls) != 1 {
+		t.Errorf("a plan is implemented once, got %d pull requests", len(pulls))
+	}
+}
+
+func TestPlanAutoProceeds(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "plan_preview:\n  enabled: true\n  auto_proceed_after: 2h\n")
+	issue := env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.mu.Lock()
+	issue.Body = github.String("Files: export/csv.go")
+	env.github.mu.Unlock()
+	env.gemini.on("Write a step-by-step implementation plan", testPlan)
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	env.bot.proceedDuePlans(context.Background(), time.Now().Add(time.Hour))
+	env.bot.jobs.Wait()
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Fatalf("the plan isn't due yet, got %d pull requests", len(pulls))
+	}
+	env.bot.proceedDuePlans(context.Background(), time.Now().Add(3*time.Hour))
+	env.bot.jobs.Wait()
+	if pulls := env.github.pullRequests(); len(pulls) != 1 {
+		t.Errorf("expected the plan to be implemented automatically, got %d pull requests", len(pulls))
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// bucketPoll maps "owner/repo" to the pollCursor of a polled repository.
+	bucketPoll = "poll"
+
+	defaultPollInterval = time.Minute
+)
+
+// pollTarget is a repository scanned by the polling fallback.
+type pollTarget struct {
+	Owner          string
+	Repo           string
+	InstallationID int64 // ignored in personal access token mode
+}
+
+// pollCursor records the newest issue and comment the poller has seen in a
+// repository, so restarts neither miss nor repeat events.
+type pollCursor struct {
+	LastIssue     int       `json:"last_issue"`
+	LastCommentID int64     `json:"last_comment_id"`
+	LastCommentAt time.Time `json:"last_comment_at"`
+}
+
+// parsePollTargets parses a comma separated list of `owner/repo` entries,
+// each optionally followed by `:<installation ID>` for GitHub App mode.
+func parsePollTargets(spec string) ([]pollTarget, error) {
+	var targets []pollTarget
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		var target pollTarget
+		fullName, installation, hasInstallation := strings.Cut(entry, ":")
+		owner, repo, ok := strings.Cut(fullName, "/")
+		if !ok || owner == "" || repo == "" || strings.Contains(repo, "/") {
+			return nil, fmt.Errorf("invalid repository %q, expected owner/repo", entry)
+		}
+		target.Owner, target.Repo = owner, repo
+		if hasInstallation {
+			id, err := strconv.ParseInt(installation, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid installation ID in %q: %w", entry, err)
+			}
+			target.InstallationID = id
+		}
+		targets = append(targets, target)
+	}
+	return targets, nil
+}
+
+// pollLoop scans targets every interval until ctx is cancelled. Each round is
+// time-boxed to the interval so a slow GitHub instance can't pile rounds up.
+func (b *Bot) pollLoop(ctx context.Context, targets []pollTarget, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		roundCtx, cancel := context.WithTimeout(ctx, interval)
+		for _, target := range targets {
+			if err := b.pollRepository(roundCtx, target); err != nil {
+				log.Printf("Error polling %s/%s: %v", target.Owner, target.Repo, err)
+			}
+		}
+		cancel()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pollRepository handles the issues opened and the comments created in the
+// repository since the stored cursor, exactly like the matching webhooks. The
+// first poll of a repository only records the cursor.
+func (b *Bot) pollRepository(ctx context.Context, target pollTarget) error {
+	if !b.allowed(target.InstallationID, target.Owner+"/"+target.Repo) {
+		log.Printf("Not polling %s/%s, which isn't allowlisted.", target.Owner, target.Repo)
+		return nil
+	}
+	client, err := b.clients.Client(target.InstallationID)
+	if err != nil {
+		return err
+	}
+	repo, _, err := client.Repositories.Get(ctx, target.Owner, target.Repo)
+	if err != nil {
+		return err
+	}
+	b.rememberInstallation(repo, target.InstallationID)
+
+	key := target.Owner + "/" + target.Repo
+	var cursor pollCursor
+	found, err := b.store.Get(bucketPoll, key, &cursor)
+	if err != nil {
+		return err
+	}
+	if !found {
+		if cursor, err = currentPollCursor(ctx, client, target.Owner, target.Repo); err != nil {
+			return err
+		}
+		log.Printf("Started polling %s from issue #%d and comment %d.", key, cursor.LastIssue, cursor.LastCommentID)
+		return b.store.Put(bucketPoll, key, cursor)
+	}
+
+	// Save whatever was handled even when the round runs out of time.
+	defer func() {
+		if err := b.store.Put(bucketPoll, key, cursor); err != nil {
+			log.Printf("Error storing poll cursor for %s: %v", key, err)
+		}
+	}()
+	if err := b.pollIssues(ctx, client, repo, target.InstallationID, &cursor); err != nil {
+		return err
+	}
+	return b.pollComments(ctx, client, repo, target.InstallationID, &cursor)
+}
+
+// currentPollCursor points at the newest issue and comment of the repository.
+func currentPollCursor(ctx context.Context, client *github.Client, owner, repo string) (pollCursor, error) {
+	var cursor pollCursor
+	issues, _, err := client.Issues.ListByRepo(ctx, owner, repo, &github.IssueListByRepoOptions{
+		State: "all", Sort: "created", Direction: "desc", ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return cursor, err
+	}
+	if len(issues) > 0 {
+		cursor.LastIssue = issues[0].GetNumber()
+	}
+	comments, _, err := client.Issues.ListComments(ctx, owner, repo, 0, &github.IssueListCommentsOptions{
+		Sort: github.String("created"), Direction: github.String("desc"), ListOptions: github.ListOptions{PerPage: 1},
+	})
+	if err != nil {
+		return cursor, err
+	}
+	cursor.LastCommentAt = time.Now()
+	if len(comments) > 0 {
+		cursor.LastCommentID = comments[0].GetID()
+		cursor.LastCommentAt = comments[0].GetCreatedAt().Time
+	}
+	return cursor, nil
+}
+
+// pollIssues triggers PRD generation for issues numbered above the cursor.
+func (b *Bot) pollIssues(ctx context.Context, client *github.Client, repo *github.Repository, installationID int64, cursor *pollCursor) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	var fresh []*github.Issue
+	opts := &github.IssueListByRepoOptions{State: "all", Sort: "created", Direction: "desc", ListOptions: github.ListOptions{PerPage: 50}}
+	for {
+		issues, resp, err := client.Issues.ListByRepo(ctx, owner, name, opts)
+		if err != nil {
+			return err
+		}
+		done := resp.NextPage == 0
+		for _, issue := range issues {
+			if issue.GetNumber() <= cursor.LastIssue {
+				done = true
+				break
+			}
+			fresh = append(fresh, issue)
+		}
+		if done {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for i := len(fresh) - 1; i >= 0; i-- {
+		issue := fresh[i]
+		cursor.LastIssue = issue.GetNumber()
+		if issue.IsPullRequest() || issue.GetState() != "open" {
+			continue
+		}
+		b.handleIssueOpened(ctx, client, issue, repo, installationID)
+	}
+	return nil
+}
+
+// pollComments dispatches the bot commands in comments created after the cursor.
+func (b *Bot) pollComments(ctx context.Context, client *github.Client, repo *github.Repository, installationID int64, cursor *pollCursor) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	since := cursor.LastCommentAt
+	opts := &github.IssueListCommentsOptions{
+		Sort: github.String("created"), Direction: github.String("asc"), Since: &since,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		comments, resp, err := client.Issues.ListComments(ctx, owner, name, 0, opts)
+		if err != nil {
+			return err
+		}
+		for _, comment := range comments {
+			if comment.GetID() <= cursor.LastCommentID {
+				continue
+			}
+			cursor.LastCommentID = comment.GetID()
+			cursor.LastCommentAt = comment.GetCreatedAt().Time
+
+			_, _, mentioned := b.parseComment(comment.GetBody())
+			number, err := strconv.Atoi(path.Base(comment.GetIssueURL()))
+			if err != nil {
+				log.Printf("Skipping comment %d with unexpected issue URL %q", comment.GetID(), comment.GetIssueURL())
+				continue
+			}
+			// Only comments for the bot are worth reading their issue.
+			if !mentioned && !b.wizardActive(owner, name, number) {
+				continue
+			}
+			issue, _, err := client.Issues.Get(ctx, owner, name, number)
+			if err != nil {
+				return err
+			}
+			log.Printf("Polled comment %d on issue #%d.", comment.GetID(), number)
+			if err := b.handleComment(ctx, issue, repo, installationID, comment.GetUser(), comment.GetID(), comment.GetBody()); err != nil {
+				return err
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
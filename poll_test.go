@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParsePollTargets(t *testing.T) {
+	targets, err := parsePollTargets("acme/widgets, acme/gadgets:7")
+	if err != nil {
+		t.Fatalf("parsePollTargets: %v", err)
+	}
+	want := []pollTarget{{Owner: "acme", Repo: "widgets"}, {Owner: "acme", Repo: "gadgets", InstallationID: 7}}
+	if len(targets) != len(want) || targets[0] != want[0] || targets[1] != want[1] {
+		t.Errorf("targets = %+v, want %+v", targets, want)
+	}
+	for _, spec := range []string{"widgets", "acme/", "acme/widgets:abc", "a/b/c"} {
+		if _, err := parsePollTargets(spec); err == nil {
+			t.Errorf("parsePollTargets(%q) succeeded, want error", spec)
+		}
+	}
+}
+
+func TestPollRepository(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", "PRD")
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", "**Background:** polling")
+	target := pollTarget{Owner: "acme", Repo: "widgets", InstallationID: 7}
+	ctx := context.Background()
+
+	// Issues and comments that predate the first poll are never processed.
+	env.github.addIssue("acme", "widgets", 41, "Old issue", "open")
+	env.github.addCommentBy("acme", "widgets", 41, "alice", "@prd-bot explain")
+	if err := env.bot.pollRepository(ctx, target); err != nil {
+		t.Fatalf("first poll: %v", err)
+	}
+	env.bot.jobs.Wait()
+	if got := len(env.github.issueComments("acme", "widgets", 41)); got != 1 {
+		t.Fatalf("first poll processed old events: %d comments on #41", got)
+	}
+
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.addCommentBy("acme", "widgets", 41, "alice", "@prd-bot explain")
+	env.github.addCommentBy("acme", "widgets", 41, "alice", "thanks!")
+	for i := 0; i < 2; i++ {
+		if err := env.bot.pollRepository(ctx, target); err != nil {
+			t.Fatalf("poll %d: %v", i+2, err)
+		}
+		env.bot.jobs.Wait()
+	}
+
+	prd := env.github.issueComments("acme", "widgets", 42)
+	if len(prd) != 1 || !strings.HasPrefix(prd[0].GetBody(), PRDIdentifier) {
+		t.Fatalf("expected one PRD comment on the new issue, got %+v", prd)
+	}
+	old := env.github.issueComments("acme", "widgets", 41)
+	if len(old) != 4 || !strings.Contains(old[3].GetBody(), "which files to explain") {
+		t.Fatalf("expected a single explain reply on #41, got %d comments", len(old))
+	}
+
+	var cursor pollCursor
+	if ok, _ := env.bot.store.Get(bucketPoll, "acme/widgets", &cursor); !ok || cursor.LastIssue != 42 || cursor.LastCommentID != max(old[3].GetID(), prd[0].GetID()) {
+		t.Errorf("cursor = %+v", cursor)
+	}
+}
+
+func TestPollChecksTheInstallation(t *testing.T) {
+	env := newTestEnv(t)
+	// The app is installed on acme/widgets as installation 7, not 8.
+	target := pollTarget{Owner: "acme", Repo: "widgets", InstallationID: 8}
+	ctx := context.Background()
+	env.github.addIssue("acme", "widgets", 41, "Old issue", "open")
+	if err := env.bot.pollRepository(ctx, target); err != nil {
+		t.Fatalf("first poll: %v", err)
+	}
+	env.github.addCommentBy("acme", "widgets", 41, "alice", "@prd-bot explain")
+	before := rejectedCommandsTotal.Value("installation")
+
+	if err := env.bot.pollRepository(ctx, target); err != nil {
+		t.Fatalf("poll: %v", err)
+	}
+	env.bot.jobs.Wait()
+
+	if got := len(env.github.issueComments("acme", "widgets", 41)); got != 1 {
+		t.Errorf("the command should be rejected, got %d comments on #41", got)
+	}
+	if got := rejectedCommandsTotal.Value("installation") - before; got != 1 {
+		t.Errorf("rejected commands counted %v times, want 1", got)
+	}
+}
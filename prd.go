@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PRDSection is one section of the PRD structure the model is asked to follow.
+type PRDSection struct {
+	Key         string // name used in commands, e.g. "user_stories"
+	Title       string
+	Description string
+}
+
+// prdSections is the structured PRD model, in document order.
+var prdSections = []PRDSection{
+	{"background", "Background", "Briefly describe the context and problem"},
+	{"goals", "Goals", "What are the primary objectives?"},
+	{"user_stories", "User Stories", "As a [user type], I want [an action] so that [a benefit]"},
+	{"requirements", "Requirements", "Detailed functional and non-functional requirements"},
+	{"success_metrics", "Success Metrics", "How will we measure success?"},
+}
+
+// prdStructure renders prdSections as the numbered outline used in prompts.
+func prdStructure() string {
+	var b strings.Builder
+	for i, s := range prdSections {
+		fmt.Fprintf(&b, "%d.  **%s:** (%s)\n", i+1, s.Title, s.Description)
+	}
+	return b.String()
+}
+
+// findPRDSection returns the index in prdSections of the section named name,
+// accepting keys ("user_stories"), dashed keys and titles in any case.
+func findPRDSection(name string) (int, bool) {
+	normalized := strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToLower(strings.TrimSpace(name)))
+	for i, s := range prdSections {
+		if s.Key == normalized {
+			return i, true
+		}
+	}
+	return -1, false
+}
+
+func prdSectionKeys() []string {
+	keys := make([]string, len(prdSections))
+	for i, s := range prdSections {
+		keys[i] = s.Key
+	}
+	return keys
+}
+
+// PRDDocument is a PRD comment split into its optional version header,
+// optional executive summary, English PRD, optional translation, optional
+// related work and optional reviewers footer.
+type PRDDocument struct {
+	Version    string      // e.g. "v1.1"; empty for PRDs posted before versioning
+	Changelog  []prdChange // newest first
+	Summary    string      // the summary bullets; empty when there is none
+	English    string
+	Language   string // empty when there is no translation
+	Translated string
+	Related    string // starts with RelatedWorkIdentifier; empty when there is none
+	Footer     string // starts with ReviewersIdentifier; empty when there is none
+	// Collapsed folds the English PRD and the translation into <details>
+	// sections under the summary.
+	Collapsed bool
+}
+
+const (
+	prdSeparator         = "\n\n---\n\n"
+	prdTranslationPrefix = "### PRD ("
+	// prdAppendixPrefix starts appendices added to the PRD by other commands.
+	prdAppendixPrefix = "#### Appendix:"
+	// prdSummaryHeading starts the executive summary of a PRD comment.
+	prdSummaryHeading = "### Executive Summary"
+
+	prdDetailsOpen  = "<details>\n<summary>"
+	prdDetailsClose = "\n\n</details>"
+)
+
+// String renders the document as a PRD comment.
+func (d *PRDDocument) String() string {
+	s := PRDIdentifier + prdSeparator
+	if d.Version != "" {
+		s = PRDIdentifier + "\n\n" + d.versionHeader() + prdSeparator
+	}
+	if d.Summary != "" {
+		s += prdSummaryHeading + "\n\n" + d.Summary + prdSeparator
+	}
+	s += d.fold("Show the full PRD", d.English)
+	if d.Language != "" {
+		s = fmt.Sprintf("%s%s%s%s)\n\n%s", s, prdSeparator, prdTranslationPrefix, d.Language, d.fold("Show the translation", d.Translated))
+	}
+	if d.Related != "" {
+		s += prdSeparator + d.Related
+	}
+	if d.Footer != "" {
+		s += prdSeparator + d.Footer
+	}
+	return s
+}
+
+func (d *PRDDocument) fold(label, text string) string {
+	if !d.Collapsed {
+		return text
+	}
+	return prdDetailsOpen + label + "</summary>\n\n" + strings.TrimRight(text, "\n") + prdDetailsClose
+}
+
+// unfold returns text without the <details> section String wraps it in,
+// and whether it was wrapped.
+func unfold(text string) (string, bool) {
+	inner, ok := strings.CutPrefix(text, prdDetailsOpen)
+	if !ok {
+		return text, false
+	}
+	_, inner, ok = strings.Cut(inner, "</summary>\n\n")
+	if !ok {
+		return text, false
+	}
+	inner, ok = strings.CutSuffix(inner, prdDetailsClose)
+	if !ok {
+		return text, false
+	}
+	return inner, true
+}
+
+// parsePRDDocument splits a PRD comment produced by generatePRD. It reports
+// false when the comment doesn't have that layout.
+func parsePRDDocument(body string) (*PRDDocument, bool) {
+	body = stripArtifactMeta(strings.ReplaceAll(body, "\r\n", "\n"))
+	doc := &PRDDocument{}
+	rest, ok := strings.CutPrefix(body, PRDIdentifier+"\n\n"+prdVersionLabel)
+	if ok {
+		var header string
+		if header, rest, ok = strings.Cut(rest, prdSeparator); !ok {
+			return nil, false
+		}
+		doc.parseVersionHeader(header)
+	} else if rest, ok = strings.CutPrefix(body, PRDIdentifier+prdSeparator); !ok {
+		return nil, false
+	}
+	if summary, ok := strings.CutPrefix(rest, prdSummaryHeading+"\n\n"); ok {
+		if doc.Summary, rest, ok = strings.Cut(summary, prdSeparator); !ok {
+			return nil, false
+		}
+	}
+	if i := strings.LastIndex(rest, prdSeparator+ReviewersIdentifier); i >= 0 {
+		rest, doc.Footer = rest[:i], rest[i+len(prdSeparator):]
+	}
+	if i := strings.LastIndex(rest, prdSeparator+RelatedWorkIdentifier); i >= 0 {
+		rest, doc.Related = rest[:i], rest[i+len(prdSeparator):]
+	}
+	english, translation, found := strings.Cut(rest, prdSeparator+prdTranslationPrefix)
+	doc.English, doc.Collapsed = unfold(english)
+	if found {
+		language, translated, ok := strings.Cut(translation, ")\n\n")
+		if !ok {
+			return nil, false
+		}
+		doc.Language = language
+		doc.Translated, _ = unfold(translated)
+	}
+	return doc, true
+}
+
+var (
+	// boldHeading matches "1.  **Goals:** text", "**Goals**: text" and "## 2. **Goals**".
+	boldHeading = regexp.MustCompile(`^\s*(?:#{1,6}\s+)?(?:(\d+)\.\s+)?\*\*([^*]+?)\s*[:：]?\s*\*\*(?:\s*[:：])?`)
+	// markdownHeading matches "## Goals" and "### 2. Goals".
+	markdownHeading = regexp.MustCompile(`^\s*#{1,6}\s+(?:(\d+)\.\s+)?(.+?)\s*[:：]?\s*$`)
+)
+
+// prdBlock is a run of PRD lines. section is the index in prdSections of the
+// section it holds, or -1 for text before the first section.
+type prdBlock struct {
+	section int
+	heading string   // the heading prefix of the first line
+	lines   []string // the block's lines, the first one starting with heading
+}
+
+// body returns everything after the heading, up to the next section.
+func (b prdBlock) body() string {
+	return strings.Join(b.lines, "\n")[len(b.heading):]
+}
+
+// parsePRDBlocks splits text into sections. English PRDs are matched by
+// section title; translated PRDs, whose titles are translated, are matched
+// by the section numbers of the outline when byNumber is set. Sections must
+// appear in order, so numbered lists inside a section are not mistaken for
+// headings.
+func parsePRDBlocks(text string, byNumber bool) []prdBlock {
+	blocks := []prdBlock{{section: -1}}
+	last := -1
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, prdAppendixPrefix) {
+			// Appendices follow the sections and belong to none of them.
+			blocks = append(blocks, prdBlock{section: -1, lines: []string{line}})
+			last = len(prdSections)
+			continue
+		}
+		section, heading := -1, ""
+		for _, re := range []*regexp.Regexp{boldHeading, markdownHeading} {
+			m := re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			if idx := sectionByTitle(m[2]); idx >= 0 {
+				section = idx
+			} else if n, err := strconv.Atoi(m[1]); byNumber && err == nil {
+				section = n - 1
+			}
+			heading = m[0]
+			break
+		}
+		if section > last && section < len(prdSections) {
+			blocks = append(blocks, prdBlock{section: section, heading: heading, lines: []string{line}})
+			last = section
+			continue
+		}
+		cur := &blocks[len(blocks)-1]
+		cur.lines = append(cur.lines, line)
+	}
+	return blocks
+}
+
+// withoutPRDAppendix removes the appendix headed heading from a PRD. The
+// appendix runs up to the next appendix; the analytics schema link after it
+// is kept.
+func withoutPRDAppendix(prd, heading string) string {
+	var kept []string
+	inside := false
+	for _, line := range strings.Split(prd, "\n") {
+		switch {
+		case line == heading:
+			inside = true
+			continue
+		case strings.HasPrefix(line, prdAppendixPrefix), strings.HasPrefix(line, analyticsSchemaLinkPrefix):
+			if inside && len(kept) > 0 && kept[len(kept)-1] != "" {
+				kept = append(kept, "")
+			}
+			inside = false
+		}
+		if !inside {
+			kept = append(kept, line)
+		}
+	}
+	return strings.TrimRight(strings.Join(kept, "\n"), "\n")
+}
+
+func sectionByTitle(title string) int {
+	for i, s := range prdSections {
+		if strings.EqualFold(strings.TrimSpace(title), s.Title) {
+			return i
+		}
+	}
+	return -1
+}
+
+func renderPRDBlocks(blocks []prdBlock) string {
+	var lines []string
+	for _, block := range blocks {
+		lines = append(lines, block.lines...)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// replacePRDSection replaces the body of section in text with content,
+// keeping its heading and the blank lines that follow it. It reports false
+// when the section can't be found.
+func replacePRDSection(text string, section int, content string, byNumber bool) (string, bool) {
+	blocks := parsePRDBlocks(text, byNumber)
+	for i, block := range blocks {
+		if block.section != section {
+			continue
+		}
+		content = strings.TrimSpace(content)
+		body := block.body()
+		sep := " "
+		if strings.HasPrefix(strings.TrimSpace(block.heading), "#") || strings.HasPrefix(strings.TrimLeft(body, " "), "\n") || strings.Contains(content, "\n") {
+			sep = "\n"
+		}
+		trailing := body[len(strings.TrimRight(body, " \n")):]
+		blocks[i].lines = strings.Split(block.heading+sep+content+trailing, "\n")
+		return renderPRDBlocks(blocks), true
+	}
+	return text, false
+}
+
+// prdSectionContent returns the body of section in text.
+func prdSectionContent(text string, section int, byNumber bool) (string, bool) {
+	for _, block := range parsePRDBlocks(text, byNumber) {
+		if block.section == section {
+			return strings.TrimSpace(block.body()), true
+		}
+	}
+	return "", false
+}
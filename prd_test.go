@@ -0,0 +1,102 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func readFixture(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile("testdata/gemini/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestParsePRDDocumentRoundTrip(t *testing.T) {
+	doc := &PRDDocument{English: "1.  **Goals:** Ship it.", Language: "Japanese", Translated: "1.  **目標:** 出荷する。"}
+	parsed, ok := parsePRDDocument(doc.String())
+	if !ok || !reflect.DeepEqual(parsed, doc) {
+		t.Errorf("parsePRDDocument(%q) = %+v, %v", doc.String(), parsed, ok)
+	}
+	if _, ok := parsePRDDocument("Some other comment"); ok {
+		t.Error("a comment without the PRD identifier should not parse")
+	}
+}
+
+func TestReplacePRDSection(t *testing.T) {
+	english := readFixture(t, "prd_en.md")
+	goals, _ := findPRDSection("goals")
+
+	got, ok := replacePRDSection(english, goals, "Support CSV and TSV exports.", false)
+	if !ok {
+		t.Fatal("goals section not found")
+	}
+	if want := strings.Replace(english, "Allow any report to be exported as a CSV file.", "Support CSV and TSV exports.", 1); got != want {
+		t.Errorf("replacePRDSection changed more than the goals:\n%s", got)
+	}
+
+	translated := readFixture(t, "prd_translated.md")
+	got, ok = replacePRDSection(translated, goals, "支援 CSV 與 TSV 匯出。", true)
+	if !ok || !strings.Contains(got, "2.  **目標:** 支援 CSV 與 TSV 匯出。") || !strings.Contains(got, "1.  **背景:** 使用者需要") {
+		t.Errorf("unexpected translated PRD (found %v):\n%s", ok, got)
+	}
+
+	headed := "## Background\nOld context.\n\n## Goals\n1. First\n2. Second\n\n## User Stories\nStories."
+	got, _ = replacePRDSection(headed, goals, "1. New goal", false)
+	if want := "## Background\nOld context.\n\n## Goals\n1. New goal\n\n## User Stories\nStories."; got != want {
+		t.Errorf("replacePRDSection with Markdown headings = %q, want %q", got, want)
+	}
+}
+
+func TestRegenSectionPatchesOnlyThatSection(t *testing.T) {
+	env := newTestEnv(t)
+	english, translated := readFixture(t, "prd_en.md"), readFixture(t, "prd_translated.md")
+	prd := env.github.addComment("acme", "widgets", 42, (&PRDDocument{English: english, Language: "Traditional Chinese", Translated: translated}).String())
+	env.gemini.on("Rewrite only the **Goals** section", "Support CSV and TSV exports.")
+	env.gemini.on("Translate the following section", "支援 CSV 與 TSV 匯出。")
+
+	env.comment(t, "@prd-bot regen_section goals include TSV")
+
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "include TSV") {
+		t.Errorf("prompt should include the guidance:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 2 || comments[0].GetID() != prd.GetID() {
+		t.Fatalf("expected the PRD and a confirmation, got %d comments", len(comments))
+	}
+	doc, ok := parsePRDDocument(comments[0].GetBody())
+	if !ok {
+		t.Fatalf("patched PRD no longer parses:\n%s", comments[0].GetBody())
+	}
+	if want := strings.Replace(english, "Allow any report to be exported as a CSV file.", "Support CSV and TSV exports.", 1); doc.English != want {
+		t.Errorf("English PRD = %q, want %q", doc.English, want)
+	}
+	if want := strings.Replace(translated, "允許任何報表匯出為 CSV 檔案。", "支援 CSV 與 TSV 匯出。", 1); doc.Translated != want {
+		t.Errorf("translated PRD = %q, want %q", doc.Translated, want)
+	}
+	if !strings.Contains(comments[1].GetBody(), "**Goals**") {
+		t.Errorf("unexpected confirmation: %s", comments[1].GetBody())
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD); artifact == nil || artifact.Markdown != stripArtifactMeta(comments[0].GetBody()) {
+		t.Errorf("PRD artifact = %+v", artifact)
+	}
+}
+
+func TestRegenSectionRejectsUnknownSection(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, (&PRDDocument{English: readFixture(t, "prd_en.md")}).String())
+
+	env.comment(t, "@prd-bot regen_section timeline")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "`timeline`") || !strings.Contains(body, "`success_metrics`") {
+		t.Errorf("expected a usage message, got %q", body)
+	}
+	if len(env.gemini.receivedPrompts()) != 0 {
+		t.Error("no model call expected for an unknown section")
+	}
+}
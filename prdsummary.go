@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+const (
+	// Values of PRDLayoutConfig.Collapse.
+	collapseAuto   = "auto"   // collapse PRDs longer than collapseThreshold
+	collapseAlways = "always" // always collapse
+	collapseNever  = "never"  // never collapse
+
+	// collapseThreshold is the length of the English PRD and its translation
+	// above which they are collapsed in auto mode.
+	collapseThreshold = 4000
+
+	prdSummaryBullets = 5
+)
+
+// PRDLayoutConfig controls how PRD comments are laid out.
+type PRDLayoutConfig struct {
+	// Collapse is "auto" (default) to fold long PRDs under their executive
+	// summary, "always" or "never".
+	Collapse string `yaml:"collapse"`
+}
+
+// collapse reports whether a PRD of size characters should be folded.
+func (c *PRDLayoutConfig) collapse(size int) bool {
+	mode := collapseAuto
+	if c != nil && c.Collapse != "" {
+		mode = c.Collapse
+	}
+	switch mode {
+	case collapseAlways:
+		return true
+	case collapseNever:
+		return false
+	case collapseAuto:
+	default:
+		log.Printf("Ignoring invalid prd_layout.collapse %q", mode)
+	}
+	return size > collapseThreshold
+}
+
+// addExecutiveSummary prepends an executive summary to a PRD comment and
+// folds the full document when the layout asks for it. Without a summary,
+// e.g. when the model fails, nothing is folded.
+func (b *Bot) addExecutiveSummary(ctx context.Context, prdContent string, cfg *PRDLayoutConfig) string {
+	doc, ok := parsePRDDocument(prdContent)
+	if !ok {
+		return prdContent
+	}
+	summary, err := generateExecutiveSummary(ctx, b.llm, doc.English)
+	if err != nil {
+		log.Printf("Could not summarize the PRD, posting it without a summary: %v", err)
+	}
+	doc.Summary = summary
+	doc.Collapsed = summary != "" && cfg.collapse(len(doc.English)+len(doc.Translated))
+	return doc.String()
+}
+
+func generateExecutiveSummary(ctx context.Context, llm Generator, prd string) (string, error) {
+	prompt := fmt.Sprintf(
+		"Write an executive summary of the following Product Requirements Document (PRD) for a busy stakeholder: exactly %d Markdown bullet points (\"- \"), one sentence each, covering the problem, the goal, the main requirements, the success metric and the biggest risk or open question. Respond with the bullets only.\n\n"+
+			"**PRD:**\n%s",
+		prdSummaryBullets, prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptSummary), prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to summarize the PRD: %w", err)
+	}
+	var bullets []string
+	for _, line := range strings.Split(resp, "\n") {
+		line = strings.TrimSpace(line)
+		if item, ok := strings.CutPrefix(line, "- "); ok {
+			bullets = append(bullets, "- "+strings.TrimSpace(item))
+		} else if item, ok := strings.CutPrefix(line, "* "); ok {
+			bullets = append(bullets, "- "+strings.TrimSpace(item))
+		}
+	}
+	if len(bullets) == 0 {
+		return "", fmt.Errorf("%w: the summary has no bullet points", ErrModelInvalid)
+	}
+	return strings.Join(bullets[:min(len(bullets), prdSummaryBullets)], "\n"), nil
+}
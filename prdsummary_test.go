@@ -0,0 +1,77 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPRDDocumentRoundTripCollapsed(t *testing.T) {
+	doc := &PRDDocument{
+		Summary:    "- One.\n- Two.",
+		English:    "1.  **Background:** B.\n\n5.  **Success Metrics:** M.",
+		Language:   "Japanese",
+		Translated: "1.  **背景:** B.",
+		Footer:     ReviewersIdentifier + "\n\n- `@alice` (`/export/`)\n",
+		Collapsed:  true,
+	}
+	body := doc.String()
+	for _, want := range []string{
+		PRDIdentifier + prdSeparator + prdSummaryHeading + "\n\n- One.\n- Two." + prdSeparator + "<details>\n<summary>Show the full PRD</summary>\n\n1.  **Background:** B.",
+		"### PRD (Japanese)\n\n<details>\n<summary>Show the translation</summary>\n\n1.  **背景:** B.\n\n</details>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+	parsed, ok := parsePRDDocument(body)
+	if !ok || !reflect.DeepEqual(parsed, doc) {
+		t.Fatalf("parsePRDDocument = %+v, want %+v", parsed, doc)
+	}
+	if metrics, _ := prdSectionContent(parsed.English, 4, false); metrics != "M." {
+		t.Errorf("sections should be found in a collapsed PRD, got %q", metrics)
+	}
+}
+
+func TestPRDLayoutCollapse(t *testing.T) {
+	tests := []struct {
+		cfg  *PRDLayoutConfig
+		size int
+		want bool
+	}{
+		{nil, 100, false},
+		{nil, collapseThreshold + 1, true},
+		{&PRDLayoutConfig{Collapse: collapseAlways}, 100, true},
+		{&PRDLayoutConfig{Collapse: collapseNever}, collapseThreshold + 1, false},
+		{&PRDLayoutConfig{Collapse: "sometimes"}, 100, false},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.collapse(tt.size); got != tt.want {
+			t.Errorf("%+v.collapse(%d) = %v, want %v", tt.cfg, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestNewPRDHasExecutiveSummary(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.github.addFile("acme", "widgets", RepoConfigPath, "language: Japanese\nprd_layout:\n  collapse: always\n")
+	env.gemini.on("Translate the following English PRD", "1.  **背景:** CSV.")
+	env.gemini.on("executive summary", "Here you go:\n- Analysts re-type reports.\n* Export any report as CSV.\n- A\n- B\n- C\n- D")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	body := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	doc, ok := parsePRDDocument(body)
+	if !ok || !doc.Collapsed || doc.Language != "Japanese" {
+		t.Fatalf("expected a collapsed PRD:\n%s", body)
+	}
+	if doc.Summary != "- Analysts re-type reports.\n- Export any report as CSV.\n- A\n- B\n- C" {
+		t.Errorf("unexpected summary %q", doc.Summary)
+	}
+	prompts := env.gemini.receivedPrompts()
+	if summaryPrompt := prompts[len(prompts)-1]; !strings.Contains(summaryPrompt, "30% of weekly active analysts") {
+		t.Errorf("the summary should be based on the English PRD:\n%s", summaryPrompt)
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// bucketPRDVersions holds an Artifact snapshot of every PRD version,
+	// keyed by the PRD's artifactKey, "@" and the version.
+	bucketPRDVersions = "prd_versions"
+
+	// prdVersionFlag makes read-only commands use an earlier PRD version:
+	// "--prd v1.1".
+	prdVersionFlag = "--prd"
+
+	prdVersionLabel      = "**Version:** "
+	prdChangelogOpen     = "<details>\n<summary>Changelog</summary>\n\n"
+	prdChangelogClose    = "\n\n</details>"
+	maxPRDChangelogItems = 20
+)
+
+// prdVersion is a PRD version: a generated PRD is a major version, every
+// revision of it a minor one.
+type prdVersion struct {
+	Major, Minor int
+}
+
+func (v prdVersion) String() string {
+	if v.Minor == 0 {
+		return fmt.Sprintf("v%d", v.Major)
+	}
+	return fmt.Sprintf("v%d.%d", v.Major, v.Minor)
+}
+
+func (v prdVersion) compare(w prdVersion) int {
+	return cmp.Or(cmp.Compare(v.Major, w.Major), cmp.Compare(v.Minor, w.Minor))
+}
+
+// parsePRDVersion parses "v1", "v1.2" or "1.2".
+func parsePRDVersion(s string) (prdVersion, bool) {
+	s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "v")
+	major, minor, found := strings.Cut(s, ".")
+	var v prdVersion
+	var err error
+	if v.Major, err = strconv.Atoi(major); err != nil || v.Major < 1 {
+		return prdVersion{}, false
+	}
+	if found {
+		if v.Minor, err = strconv.Atoi(minor); err != nil || v.Minor < 0 {
+			return prdVersion{}, false
+		}
+	}
+	return v, true
+}
+
+// prdChange is a changelog entry of a PRD.
+type prdChange struct {
+	Version string
+	Note    string
+}
+
+// record makes v the document's version, noting what changed.
+func (d *PRDDocument) record(v prdVersion, note string) {
+	d.Version = v.String()
+	d.Changelog = append([]prdChange{{Version: d.Version, Note: strings.Join(strings.Fields(note), " ")}}, d.Changelog...)
+	if len(d.Changelog) > maxPRDChangelogItems {
+		d.Changelog = d.Changelog[:maxPRDChangelogItems]
+	}
+}
+
+// revise bumps the minor version of the document for a revision described by
+// note. PRDs posted before versioning count as v1.
+func (d *PRDDocument) revise(note string) {
+	v, ok := parsePRDVersion(d.Version)
+	if !ok {
+		v = prdVersion{Major: 1}
+	}
+	v.Minor++
+	d.record(v, note)
+}
+
+// versionHeader renders the version and changelog shown under the PRD
+// identifier.
+func (d *PRDDocument) versionHeader() string {
+	s := prdVersionLabel + d.Version
+	if len(d.Changelog) == 0 {
+		return s
+	}
+	items := make([]string, len(d.Changelog))
+	for i, c := range d.Changelog {
+		items[i] = fmt.Sprintf("- **%s**: %s", c.Version, c.Note)
+	}
+	return s + "\n\n" + prdChangelogOpen + strings.Join(items, "\n") + prdChangelogClose
+}
+
+// parseVersionHeader reads what versionHeader rendered into d.
+func (d *PRDDocument) parseVersionHeader(header string) {
+	version, changelog, _ := strings.Cut(header, "\n")
+	d.Version = strings.TrimSpace(version)
+	changelog = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(changelog), prdChangelogOpen), prdChangelogClose)
+	for _, line := range strings.Split(changelog, "\n") {
+		item, ok := strings.CutPrefix(strings.TrimSpace(line), "- **")
+		if !ok {
+			continue
+		}
+		if version, note, ok := strings.Cut(item, "**: "); ok {
+			d.Changelog = append(d.Changelog, prdChange{Version: version, Note: note})
+		}
+	}
+}
+
+// versionNewPRD stamps a newly generated PRD with its version: v1, or the
+// next major version when the issue had PRDs before.
+func (b *Bot) versionNewPRD(owner, repo string, issueNum int, prd string) string {
+	doc, ok := parsePRDDocument(prd)
+	if !ok {
+		return prd
+	}
+	note := "Generated the PRD."
+	versions := b.prdVersions(owner, repo, issueNum)
+	next := prdVersion{Major: 1}
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		next.Major = latest.Major + 1
+		note = fmt.Sprintf("Regenerated the PRD, replacing %s.", latest)
+	}
+	doc.Changelog = nil
+	doc.record(next, note)
+	return doc.String()
+}
+
+// prdVersionKey is the key of a PRD version in bucketPRDVersions.
+func prdVersionKey(owner, repo string, issueNum int, version string) string {
+	return artifactKey(owner, repo, issueNum, ArtifactPRD) + "@" + version
+}
+
+// savePRDVersion keeps a snapshot of a versioned PRD artifact.
+func (b *Bot) savePRDVersion(artifact *Artifact) {
+	if artifact.Version == "" {
+		return
+	}
+	if err := b.store.Put(bucketPRDVersions, prdVersionKey(artifact.Owner, artifact.Repo, artifact.Issue, artifact.Version), artifact); err != nil {
+		log.Printf("Error storing PRD %s for issue #%d: %v", artifact.Version, artifact.Issue, err)
+	}
+}
+
+// prdVersions returns the stored versions of the issue's PRD, oldest first.
+func (b *Bot) prdVersions(owner, repo string, issueNum int) []prdVersion {
+	docs, err := b.store.List(bucketPRDVersions)
+	if err != nil {
+		log.Printf("Error listing the PRD versions of issue #%d: %v", issueNum, err)
+		return nil
+	}
+	prefix := prdVersionKey(owner, repo, issueNum, "")
+	var versions []prdVersion
+	for key := range docs {
+		if rest, ok := strings.CutPrefix(key, prefix); ok {
+			if v, ok := parsePRDVersion(rest); ok {
+				versions = append(versions, v)
+			}
+		}
+	}
+	slices.SortFunc(versions, prdVersion.compare)
+	return versions
+}
+
+// prdVersionArg returns the PRD version args pin with prdVersionFlag, or "",
+// and args without the flag.
+func prdVersionArg(args []string) (string, []string) {
+	var version string
+	var rest []string
+	for i := 0; i < len(args); i++ {
+		if value, ok := strings.CutPrefix(args[i], prdVersionFlag+"="); ok {
+			version = value
+		} else if args[i] == prdVersionFlag && i+1 < len(args) {
+			version = args[i+1]
+			i++
+		} else {
+			rest = append(rest, args[i])
+		}
+	}
+	return version, rest
+}
+
+// findPRDVersion returns the issue's PRD comment, or a snapshot of version
+// when one is given, or nil when the issue has no PRD. When version isn't
+// stored it replies with the versions that are and reports false.
+func (b *Bot) findPRDVersion(ctx context.Context, client *github.Client, owner, repo string, issueNum int, version string) (*github.IssueComment, bool) {
+	if version == "" {
+		comment, err := b.findPRDComment(ctx, client, owner, repo, issueNum)
+		if err != nil {
+			log.Printf("Error looking for the PRD of issue #%d: %v", issueNum, err)
+		}
+		return comment, true
+	}
+	var snapshot Artifact
+	found := false
+	if v, ok := parsePRDVersion(version); ok {
+		var err error
+		if found, err = b.store.Get(bucketPRDVersions, prdVersionKey(owner, repo, issueNum, v.String()), &snapshot); err != nil {
+			log.Printf("Error loading PRD %s of issue #%d: %v", v, issueNum, err)
+		}
+	}
+	if !found || snapshot.Markdown == "" {
+		var names []string
+		for _, v := range b.prdVersions(owner, repo, issueNum) {
+			names = append(names, "`"+v.String()+"`")
+		}
+		msg := fmt.Sprintf("I don't have version `%s` of the PRD.", version)
+		if len(names) > 0 {
+			msg += " Available versions: " + strings.Join(names, ", ") + "."
+		}
+		if found {
+			msg = fmt.Sprintf("I only kept the metadata of version `%s` of the PRD, not its text.", version)
+		}
+		b.postComment(ctx, client, owner, repo, issueNum, msg)
+		return nil, false
+	}
+	return &github.IssueComment{
+		ID:      github.Int64(snapshot.CommentID),
+		Body:    github.String(snapshot.Markdown),
+		HTMLURL: github.String(snapshot.CommentURL),
+	}, true
+}
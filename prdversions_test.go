@@ -0,0 +1,83 @@
+package main
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestPRDVersionHeaderRoundTrip(t *testing.T) {
+	doc := &PRDDocument{English: "1.  **Goals:** Ship it."}
+	doc.revise("Regenerated the Goals section.")
+	doc.revise("Translated into\nJapanese.")
+	if doc.Version != "v1.2" || len(doc.Changelog) != 2 || doc.Changelog[0].Note != "Translated into Japanese." {
+		t.Fatalf("a PRD posted before versioning should count as v1: %+v", doc)
+	}
+	body := doc.String()
+	if !strings.HasPrefix(body, PRDIdentifier+"\n\n**Version:** v1.2\n\n<details>\n<summary>Changelog</summary>\n\n- **v1.2**: Translated into Japanese.\n- **v1.1**: Regenerated the Goals section.") {
+		t.Errorf("unexpected version header:\n%s", body)
+	}
+	parsed, ok := parsePRDDocument(body)
+	if !ok || !reflect.DeepEqual(parsed, doc) {
+		t.Errorf("parsePRDDocument(%q) = %+v, %v", body, parsed, ok)
+	}
+
+	for s, want := range map[string]string{"v1": "v1", "V2.3": "v2.3", "1.0": "v1", "v0": "", "v1.x": "", "latest": ""} {
+		v, ok := parsePRDVersion(s)
+		if got := v.String(); (want == "") == ok || (ok && got != want) {
+			t.Errorf("parsePRDVersion(%q) = %s, %v; want %q", s, got, ok, want)
+		}
+	}
+}
+
+func TestNewPRDIsVersioned(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+	env.bot.store.Put(bucketPRDVersions, prdVersionKey("acme", "widgets", 42, "v1.3"), &Artifact{Kind: ArtifactPRD, Version: "v1.3"})
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	body := env.github.issueComments("acme", "widgets", 42)[0].GetBody()
+	if !strings.Contains(body, "**Version:** v2") || !strings.Contains(body, "- **v2**: Regenerated the PRD, replacing v1.3.") {
+		t.Errorf("a PRD replacing an earlier one should be the next major version:\n%s", body)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD); artifact == nil || artifact.Version != "v2" {
+		t.Errorf("PRD artifact = %+v", artifact)
+	}
+	if versions := env.bot.prdVersions("acme", "widgets", 42); !slices.Equal(versions, []prdVersion{{1, 3}, {2, 0}}) {
+		t.Errorf("versions = %v", versions)
+	}
+}
+
+func TestCommandsReferencePRDVersion(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Estimate the capacity and performance needs", "1.  **Expected Load:** 1-5 QPS.")
+	env.comment(t, "@prd-bot need_capacity_plan --appendix")
+	env.gemini.mu.Lock()
+	env.gemini.rules = nil
+	env.gemini.mu.Unlock()
+	env.gemini.on("Estimate the capacity and performance needs", "1.  **Expected Load:** 10 QPS.")
+	env.comment(t, "@prd-bot need_capacity_plan --appendix")
+
+	if prd := env.github.issueComments("acme", "widgets", 42)[0].GetBody(); !strings.Contains(prd, "**Version:** v1.2") || !strings.Contains(prd, "- **v1.1**: Added the capacity plan appendix.") {
+		t.Errorf("every revision should bump the minor version:\n%s", prd)
+	}
+
+	env.gemini.on("Estimate the WSJF prioritization factors", `{"factors": [{"name": "business_value", "value": 8}]}`)
+	env.comment(t, "@prd-bot need_priority --prd v1.1 wsjf")
+	prompts := env.gemini.receivedPrompts()
+	if prompt := prompts[len(prompts)-1]; !strings.Contains(prompt, "1-5 QPS") || strings.Contains(prompt, "10 QPS") {
+		t.Errorf("need_priority should score the pinned version:\n%s", prompt)
+	}
+
+	env.comment(t, "@prd-bot need_priority --prd v3")
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); body != "I don't have version `v3` of the PRD. Available versions: `v1.1`, `v1.2`." {
+		t.Errorf("an unknown version should list the available ones: %q", body)
+	}
+}
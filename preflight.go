@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// writeCommands are the commands that push branches or open pull requests,
+// and so need the pre-flight repository checks.
+var writeCommands = []string{CommandImplementFeature, CommandAnalyticsEvents, CommandProceed, CommandRecordDecision, CommandRollbackPlan, CommandArchitectureDoc}
+
+// writePermissions are the installation permissions write commands need.
+var writePermissions = []string{"contents", "pull_requests"}
+
+// permissionReporter is implemented by client factories that know the
+// permissions granted to an installation.
+type permissionReporter interface {
+	// Permissions returns the access level ("read" or "write") of each
+	// permission of the installation, by name such as "contents".
+	Permissions(ctx context.Context, installationID int64) (map[string]string, error)
+}
+
+// missingPermissionsError lists the installation permissions a command
+// needs but wasn't granted.
+type missingPermissionsError struct {
+	missing []string // e.g. "`contents: write`"
+}
+
+func (e *missingPermissionsError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrMissingPermissions, strings.Join(e.missing, ", "))
+}
+
+func (e *missingPermissionsError) Unwrap() error { return ErrMissingPermissions }
+
+// preflightReason describes a pre-flight failure to users.
+func preflightReason(err error) string {
+	var perms *missingPermissionsError
+	if errors.As(err, &perms) {
+		return fmt.Sprintf("My installation is missing the %s permissions this needs", strings.Join(perms.missing, " and "))
+	}
+	return "This repository can't receive pull requests from me"
+}
+
+// preflight checks that the bot can write to the repository before a command
+// clones, generates, pushes or opens pull requests, so users get a clear
+// explanation instead of a failure deep inside git. It rejects installations
+// without write permission on contents and pull requests, archived
+// repositories, repositories the token can't push to and empty repositories.
+func (b *Bot) preflight(ctx context.Context, client *github.Client, repo *github.Repository, installationID int64) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	// The webhook payload can be stale, e.g. when the repository was archived
+	// after the comment was written.
+	current, _, err := client.Repositories.Get(ctx, owner, name)
+	if err != nil {
+		log.Printf("Could not refresh %s/%s for the pre-flight checks, using the event payload: %v", owner, name, err)
+		current = repo
+	}
+	if current.GetArchived() {
+		return fmt.Errorf("%w: %s/%s", ErrRepoArchived, owner, name)
+	}
+	if reporter, ok := b.clients.(permissionReporter); ok {
+		granted, err := reporter.Permissions(ctx, installationID)
+		if err != nil {
+			log.Printf("Could not read the permissions of installation %d for the pre-flight checks: %v", installationID, err)
+		}
+		var missing []string
+		for _, perm := range writePermissions {
+			if err == nil && granted[perm] != "write" {
+				missing = append(missing, fmt.Sprintf("`%s: write`", perm))
+			}
+		}
+		if len(missing) > 0 {
+			return &missingPermissionsError{missing: missing}
+		}
+	}
+	// Repository permissions are only reported for user tokens.
+	if push, reported := current.GetPermissions()["push"]; reported && !push {
+		if current.GetFork() {
+			return fmt.Errorf("%w: %s/%s", ErrReadOnlyFork, owner, name)
+		}
+		return fmt.Errorf("%w: the token's user can't push to %s/%s", ErrNoWriteAccess, owner, name)
+	}
+
+	branch := current.GetDefaultBranch()
+	if branch == "" {
+		return fmt.Errorf("%w: %s/%s has no default branch", ErrRepoEmpty, owner, name)
+	}
+	if _, resp, err := client.Git.GetRef(ctx, owner, name, "refs/heads/"+branch); err != nil {
+		if resp != nil && (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound) {
+			return fmt.Errorf("%w: %s/%s has no commits on %s", ErrRepoEmpty, owner, name, branch)
+		}
+		log.Printf("Could not read %s of %s/%s for the pre-flight checks: %v", branch, owner, name, err)
+	}
+	return nil
+}
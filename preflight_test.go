@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestPreflightRejectsUnwritableRepositories(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		setup func(*fakeGitHub)
+		code  string
+	}{
+		{"archived", func(f *fakeGitHub) { f.archived = true }, "REPO_ARCHIVED"},
+		{"fork", func(f *fakeGitHub) { f.fork = true }, "REPO_FORK_READ_ONLY"},
+		{"empty", func(f *fakeGitHub) { f.empty = true }, "REPO_EMPTY"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env := newTestEnv(t)
+			env.github.mu.Lock()
+			tt.setup(env.github)
+			env.github.mu.Unlock()
+
+			env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+			if commands := env.runner.executed(); len(commands) != 0 {
+				t.Errorf("expected no git commands, got %v", commands)
+			}
+			comments := env.github.issueComments("acme", "widgets", 42)
+			if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "`"+tt.code+"`") {
+				t.Fatalf("expected a %s failure comment, got %+v", tt.code, comments)
+			}
+		})
+	}
+}
+
+func TestPreflightRequiresInstallationPermissions(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.permissions = map[string]string{"contents": "read", "issues": "write"}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if commands := env.runner.executed(); len(commands) != 0 {
+		t.Errorf("expected no git commands, got %v", commands)
+	}
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("expected no generation, got %d prompts", len(prompts))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected a failure comment, got %+v", comments)
+	}
+	body := comments[0].GetBody()
+	for _, want := range []string{"`MISSING_PERMISSIONS`", "`contents: write` and `pull_requests: write`", "Read and write"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("expected %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestLockedIssuesAreDeclined(t *testing.T) {
+	env := newTestEnv(t)
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issue_comment_implement_feature.json"), &event); err != nil {
+		t.Fatal(err)
+	}
+	event["issue"].(map[string]any)["locked"] = true
+	payload, _ := json.Marshal(event)
+
+	env.deliverPayload(t, "issue_comment", payload)
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("expected no generation, got %d prompts", len(prompts))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "`ISSUE_LOCKED`") {
+		t.Fatalf("expected an ISSUE_LOCKED failure comment, got %+v", comments)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// PriorityIdentifier marks comments produced by the need_priority command.
+	PriorityIdentifier = "### Priority Score"
+
+	// bucketPriority holds PriorityScore documents keyed by issueKey.
+	bucketPriority = "priority"
+
+	FrameworkRICE = "rice"
+	FrameworkWSJF = "wsjf"
+)
+
+// priorityFramework describes a prioritization framework: the factors the
+// model estimates and how they combine into a single score.
+type priorityFramework struct {
+	title   string
+	factors []priorityFactorSpec
+	score   func(v map[string]float64) float64
+}
+
+type priorityFactorSpec struct {
+	name        string
+	description string
+	positive    bool // must be strictly greater than zero
+}
+
+var priorityFrameworks = map[string]priorityFramework{
+	FrameworkRICE: {
+		title: "RICE",
+		factors: []priorityFactorSpec{
+			{name: "reach", description: "number of users or events affected per quarter"},
+			{name: "impact", description: "impact per user: 0.25 minimal, 0.5 low, 1 medium, 2 high, 3 massive"},
+			{name: "confidence", description: "confidence in the estimates, as a percentage from 0 to 100"},
+			{name: "effort", description: "total effort in person-months", positive: true},
+		},
+		score: func(v map[string]float64) float64 {
+			return v["reach"] * v["impact"] * (v["confidence"] / 100) / v["effort"]
+		},
+	},
+	FrameworkWSJF: {
+		title: "WSJF",
+		factors: []priorityFactorSpec{
+			{name: "business_value", description: "relative user/business value on the scale 1, 2, 3, 5, 8, 13, 20"},
+			{name: "time_criticality", description: "how quickly value decays when delayed, on the scale 1, 2, 3, 5, 8, 13, 20"},
+			{name: "risk_reduction", description: "risk reduction or opportunity enablement, on the scale 1, 2, 3, 5, 8, 13, 20"},
+			{name: "job_size", description: "relative size of the work, on the scale 1, 2, 3, 5, 8, 13, 20", positive: true},
+		},
+		score: func(v map[string]float64) float64 {
+			return (v["business_value"] + v["time_criticality"] + v["risk_reduction"]) / v["job_size"]
+		},
+	},
+}
+
+// PriorityFactor is one estimated input of a prioritization framework.
+type PriorityFactor struct {
+	Name        string  `json:"name"`
+	Value       float64 `json:"value"`
+	Explanation string  `json:"explanation"`
+}
+
+// PriorityScore is the stored result of need_priority for an issue.
+type PriorityScore struct {
+	Owner     string           `json:"owner"`
+	Repo      string           `json:"repo"`
+	Issue     int              `json:"issue"`
+	Title     string           `json:"title"`
+	Framework string           `json:"framework"`
+	Score     float64          `json:"score"`
+	Factors   []PriorityFactor `json:"factors"`
+	Summary   string           `json:"summary"`
+	UpdatedAt time.Time        `json:"updated_at"`
+}
+
+// processPriority scores the issue's PRD with the configured framework (or
+// the one given as argument), posts the breakdown and stores the score.
+func (b *Bot) processPriority(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandPriority, issueNum, repoOwner, repoName)
+
+	version, args := prdVersionArg(args)
+	framework := b.repoConfig(ctx, client, repo).PriorityFramework
+	if len(args) > 0 {
+		framework = strings.ToLower(args[0])
+	}
+	if framework == "" {
+		framework = FrameworkRICE
+	}
+	if _, ok := priorityFrameworks[framework]; !ok {
+		msg := fmt.Sprintf("Unknown prioritization framework `%s`. Supported frameworks are `%s` and `%s`.", framework, FrameworkRICE, FrameworkWSJF)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+		return
+	}
+
+	prdComment, ok := b.findPRDVersion(ctx, client, repoOwner, repoName, issueNum, version)
+	if !ok {
+		return
+	}
+	if prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting prioritization.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to prioritize. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+
+	score, err := scorePriority(ctx, b.llm, framework, prdComment.GetBody())
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "score the priority", "Could not produce a priority score for the PRD", err)
+		return
+	}
+	score.Owner, score.Repo, score.Issue, score.Title = repoOwner, repoName, issueNum, issue.GetTitle()
+	score.UpdatedAt = time.Now()
+
+	if err := b.store.Put(bucketPriority, issueKey(repoOwner, repoName, issueNum), score); err != nil {
+		log.Printf("Error storing priority score for issue #%d: %v", issueNum, err)
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, formatPriorityScore(score))
+}
+
+// scorePriority asks the model to estimate the framework's factors for the
+// PRD and computes the score from them.
+func scorePriority(ctx context.Context, llm Generator, framework, prd string) (*PriorityScore, error) {
+	fw := priorityFrameworks[framework]
+	var factorList strings.Builder
+	for _, f := range fw.factors {
+		fmt.Fprintf(&factorList, "- `%s`: %s\n", f.name, f.description)
+	}
+	prompt := fmt.Sprintf(
+		"Estimate the %s prioritization factors for the following Product Requirements Document (PRD).\n\n"+
+			"**Factors:**\n%s\n"+
+			"Respond with JSON only, using this shape:\n"+
+			"{\"factors\": [{\"name\": \"<factor>\", \"value\": <number>, \"explanation\": \"<one or two sentences>\"}], \"summary\": \"<one paragraph>\"}\n\n"+
+			"**Here is the PRD:**\n%s",
+		fw.title, factorList.String(), prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, CommandPriority), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate priority factors: %w", err)
+	}
+	var estimate struct {
+		Factors []PriorityFactor `json:"factors"`
+		Summary string           `json:"summary"`
+	}
+	if err := parseModelJSON(resp, &estimate); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+
+	values := make(map[string]float64)
+	byName := make(map[string]PriorityFactor)
+	for _, f := range estimate.Factors {
+		name := strings.ToLower(f.Name)
+		values[name] = f.Value
+		byName[name] = f
+	}
+	score := &PriorityScore{Framework: framework, Summary: estimate.Summary}
+	for _, spec := range fw.factors {
+		f, ok := byName[spec.name]
+		if !ok {
+			return nil, fmt.Errorf("%w: missing factor %q", ErrModelInvalid, spec.name)
+		}
+		if spec.positive && f.Value <= 0 {
+			return nil, fmt.Errorf("%w: factor %q must be positive, got %v", ErrModelInvalid, spec.name, f.Value)
+		}
+		f.Name = spec.name
+		score.Factors = append(score.Factors, f)
+	}
+	score.Score = fw.score(values)
+	return score, nil
+}
+
+func formatPriorityScore(score *PriorityScore) string {
+	fw := priorityFrameworks[score.Framework]
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%s)\n\n", PriorityIdentifier, fw.title)
+	fmt.Fprintf(&b, "**Score: %.2f**\n\n", score.Score)
+	b.WriteString("| Factor | Value | Rationale |\n|---|---|---|\n")
+	for _, f := range score.Factors {
+		fmt.Fprintf(&b, "| %s | %g | %s |\n", f.Name, f.Value, strings.ReplaceAll(f.Explanation, "|", "\\|"))
+	}
+	if score.Summary != "" {
+		fmt.Fprintf(&b, "\n%s\n", score.Summary)
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPriorityStoresRICEScore(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+"\n\nExport reports as CSV.")
+	env.gemini.on("Estimate the RICE prioritization factors", "```json\n"+`{"factors": [
+		{"name": "reach", "value": 400, "explanation": "All analysts."},
+		{"name": "impact", "value": 2, "explanation": "Saves manual work."},
+		{"name": "confidence", "value": 80, "explanation": "Validated by interviews."},
+		{"name": "effort", "value": 2, "explanation": "Two person-months."}
+	], "summary": "High value, modest effort."}`+"\n```")
+
+	env.comment(t, "@prd-bot need_priority")
+
+	var score PriorityScore
+	if ok, err := env.bot.store.Get(bucketPriority, issueKey("acme", "widgets", 42), &score); !ok || err != nil {
+		t.Fatalf("score not stored: %v", err)
+	}
+	if math.Abs(score.Score-320) > 1e-9 || score.Framework != FrameworkRICE {
+		t.Errorf("unexpected score %+v", score)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	if !strings.Contains(body, "**Score: 320.00**") || !strings.Contains(body, "| effort | 2 | Two person-months. |") {
+		t.Errorf("unexpected priority comment:\n%s", body)
+	}
+}
+
+func TestPriorityRejectsIncompleteWSJF(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier)
+	env.gemini.on("Estimate the WSJF prioritization factors", `{"factors": [{"name": "business_value", "value": 8}]}`)
+
+	env.comment(t, "@prd-bot need_priority wsjf")
+
+	if ok, _ := env.bot.store.Get(bucketPriority, issueKey("acme", "widgets", 42), &PriorityScore{}); ok {
+		t.Error("an incomplete estimate should not be stored")
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "`MODEL_INVALID_RESPONSE`") {
+		t.Errorf("unexpected reply:\n%s", body)
+	}
+}
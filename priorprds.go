@@ -0,0 +1,155 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// PriorDecisionsHeading heads the appendix of a PRD citing the prior PRDs
+	// it stays consistent with.
+	PriorDecisionsHeading = prdAppendixPrefix + " Prior Decisions"
+
+	// bucketPRDEmbeddings holds prdEmbedding documents keyed like the PRD
+	// artifacts they embed.
+	bucketPRDEmbeddings = "prd_embeddings"
+
+	// defaultPriorPRDs is how many prior PRDs a new PRD is checked against.
+	defaultPriorPRDs = 3
+	// minPriorSimilarity is the cosine similarity above which a prior PRD
+	// counts as relevant when embeddings are available.
+	minPriorSimilarity = 0.7
+	// maxPriorPRDBytes bounds the text of each prior PRD in the prompt.
+	maxPriorPRDBytes = 4 << 10
+)
+
+// priorPRD is a PRD the bot wrote earlier for another issue of the
+// repository.
+type priorPRD struct {
+	Issue   int
+	Title   string
+	English string
+	Score   float64 // cosine similarity, or shared keywords without embeddings
+}
+
+// prdEmbedding caches the embedding of a stored PRD.
+type prdEmbedding struct {
+	Vector    []float32 `json:"vector"`
+	CreatedAt time.Time `json:"created_at"` // of the artifact embedded
+}
+
+// findPriorPRDs returns up to n stored PRDs of repo most similar to issue,
+// ranked by embedding similarity when the model supports it, by shared
+// keywords otherwise. Errors are logged; the PRD is written without them.
+func (b *Bot) findPriorPRDs(ctx context.Context, repo *github.Repository, issue *github.Issue, n int) []priorPRD {
+	if n <= 0 {
+		return nil
+	}
+	artifacts, err := b.loadArtifacts()
+	if err != nil {
+		log.Printf("Error loading prior PRDs of %s: %v", repo.GetFullName(), err)
+		return nil
+	}
+	var candidates []priorPRD
+	var sources []Artifact
+	for _, artifact := range artifacts {
+		if artifact.Kind != ArtifactPRD || artifact.Markdown == "" || artifact.Issue == issue.GetNumber() ||
+			!strings.EqualFold(artifact.Owner+"/"+artifact.Repo, repo.GetFullName()) {
+			continue
+		}
+		english := artifact.Markdown
+		if doc, ok := parsePRDDocument(english); ok {
+			english = doc.English
+		}
+		candidates = append(candidates, priorPRD{Issue: artifact.Issue, Title: artifact.Title, English: truncateUTF8(strings.TrimSpace(english), maxPriorPRDBytes)})
+		sources = append(sources, artifact)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	if embedder, ok := b.llm.(Embedder); ok {
+		err := b.scorePriorPRDs(ctx, embedder, issue, candidates, sources)
+		if err == nil {
+			candidates = slices.DeleteFunc(candidates, func(p priorPRD) bool { return p.Score < minPriorSimilarity })
+			slices.SortStableFunc(candidates, func(a, b priorPRD) int { return cmp.Compare(b.Score, a.Score) })
+			return candidates[:min(len(candidates), n)]
+		}
+		log.Printf("Could not embed prior PRDs of %s, ranking by keywords: %v", repo.GetFullName(), err)
+	}
+	keywords := searchKeywords(issue.GetTitle())
+	for i, c := range candidates {
+		text := strings.ToLower(c.Title + "\n" + c.English)
+		for _, keyword := range keywords {
+			if strings.Contains(text, keyword) {
+				candidates[i].Score++
+			}
+		}
+	}
+	candidates = slices.DeleteFunc(candidates, func(p priorPRD) bool { return p.Score == 0 })
+	slices.SortStableFunc(candidates, func(a, b priorPRD) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(b.Issue, a.Issue))
+	})
+	return candidates[:min(len(candidates), n)]
+}
+
+// scorePriorPRDs sets the similarity of each candidate to issue. The
+// embeddings of PRDs are cached until the PRD changes, so only new and
+// revised PRDs are embedded.
+func (b *Bot) scorePriorPRDs(ctx context.Context, embedder Embedder, issue *github.Issue, candidates []priorPRD, sources []Artifact) error {
+	vectors := make([][]float32, len(candidates))
+	texts := []string{issue.GetTitle() + "\n\n" + issue.GetBody()}
+	var missing []int
+	for i, source := range sources {
+		var cached prdEmbedding
+		key := artifactKey(source.Owner, source.Repo, source.Issue, source.Kind)
+		if ok, err := b.store.Get(bucketPRDEmbeddings, key, &cached); err == nil && ok && cached.CreatedAt.Equal(source.CreatedAt) {
+			vectors[i] = cached.Vector
+			continue
+		}
+		missing = append(missing, i)
+		texts = append(texts, candidates[i].Title+"\n\n"+candidates[i].English)
+	}
+	embedded, err := embedder.EmbedTexts(ctx, texts)
+	if err != nil {
+		return err
+	}
+	if len(embedded) != len(texts) {
+		return fmt.Errorf("got %d embeddings for %d texts", len(embedded), len(texts))
+	}
+	for j, i := range missing {
+		vectors[i] = embedded[j+1]
+		source := sources[i]
+		key := artifactKey(source.Owner, source.Repo, source.Issue, source.Kind)
+		if err := b.store.Put(bucketPRDEmbeddings, key, &prdEmbedding{Vector: vectors[i], CreatedAt: source.CreatedAt}); err != nil {
+			log.Printf("Error caching the embedding of %s: %v", key, err)
+		}
+	}
+	for i := range candidates {
+		candidates[i].Score = cosineSimilarity(embedded[0], vectors[i])
+	}
+	return nil
+}
+
+// priorPRDsPrompt lists the prior PRDs for the PRD prompt, or returns ""
+// when there are none.
+func priorPRDsPrompt(prior []priorPRD) string {
+	if len(prior) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("**Prior PRDs of this Repository:**\n")
+	for _, p := range prior {
+		fmt.Fprintf(&b, "--- PRD of #%d %s ---\n%s\n\n", p.Issue, p.Title, p.English)
+	}
+	fmt.Fprintf(&b, "Stay consistent with the product decisions of these prior PRDs, such as their terminology, user roles, limits and formats, unless the issue asks to change them. "+
+		"End the PRD with a section headed exactly `%s` listing, one bullet each, the prior decisions this PRD follows or deliberately changes, citing each PRD by its issue number (e.g. #12).", PriorDecisionsHeading)
+	return b.String()
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func savePriorPRD(env *testEnv, number int, title, english string) {
+	issue := &github.Issue{Number: github.Int(number), Title: github.String(title)}
+	prd := (&PRDDocument{English: english, Language: "Traditional Chinese", Translated: "翻譯"}).String()
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, prd, nil)
+}
+
+func TestPRDStaysConsistentWithPriorPRDs(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	savePriorPRD(env, 12, "Export reports as PDF", "Exports are limited to 10,000 rows and named <report>-<date>.")
+	savePriorPRD(env, 30, "Dark mode", "A dark theme for every page.")
+	other := &github.Issue{Number: github.Int(5), Title: github.String("Export reports as XLSX")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "gadgets", other, "Another repository's PRD.", nil)
+	env.gemini.embedOn("Export reports as CSV", 1, 0, 0)
+	env.gemini.embedOn("Export reports as PDF", 0.9, 0.2, 0)
+	env.gemini.embedOn("Dark mode", 0, 1, 0)
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "--- PRD of #12 Export reports as PDF ---\nExports are limited to 10,000 rows") || !strings.Contains(prompt, "`"+PriorDecisionsHeading+"`") {
+		t.Errorf("the PRD prompt should cite the prior PRD:\n%s", prompt)
+	}
+	if strings.Contains(prompt, "Dark mode") || strings.Contains(prompt, "Another repository") || strings.Contains(prompt, "翻譯") {
+		t.Errorf("unrelated PRDs and translations shouldn't be in the prompt:\n%s", prompt)
+	}
+	var cached prdEmbedding
+	if ok, _ := env.bot.store.Get(bucketPRDEmbeddings, artifactKey("acme", "widgets", 12, ArtifactPRD), &cached); !ok || len(cached.Vector) != 3 {
+		t.Errorf("the embedding of the prior PRD should be cached: %+v", cached)
+	}
+}
+
+func TestPriorPRDsWithoutEmbeddings(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.llm = plainGenerator{env.bot.llm}
+	savePriorPRD(env, 12, "Export reports as PDF", "PDF exports.")
+	savePriorPRD(env, 20, "CSV import", "Reports can be imported from CSV and exported back.")
+	savePriorPRD(env, 30, "Dark mode", "A dark theme.")
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	repo := &github.Repository{Name: github.String("widgets"), FullName: github.String("acme/widgets"), Owner: &github.User{Login: github.String("acme")}}
+
+	prior := env.bot.findPriorPRDs(context.Background(), repo, issue, 3)
+
+	if len(prior) != 2 || prior[0].Issue != 20 || prior[1].Issue != 12 {
+		t.Errorf("without embeddings, PRDs should be ranked by shared keywords: %+v", prior)
+	}
+	if prior := env.bot.findPriorPRDs(context.Background(), repo, issue, 0); prior != nil {
+		t.Errorf("prior_prds: 0 should turn the lookup off: %+v", prior)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// prdSystemPrompts delivers a new issue and returns the system instructions
+// of the model requests generating its PRD, in order.
+func prdSystemPrompts(t *testing.T, env *testEnv) []string {
+	t.Helper()
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	systems := env.gemini.receivedSystemPrompts()
+	if len(systems) < 3 {
+		t.Fatalf("expected at least 3 model requests, got %d", len(systems))
+	}
+	return systems
+}
+
+func TestModelRequestsUseSystemInstructions(t *testing.T) {
+	env := newTestEnv(t)
+	systems := prdSystemPrompts(t, env)
+
+	for i, name := range []string{CommandGeneratePRD, promptDetectLanguage, promptTranslate} {
+		if systems[i] != systemPrompts[name] {
+			t.Errorf("request %d system instruction = %q, want the %s prompt", i, systems[i], name)
+		}
+	}
+	if prompt := env.gemini.receivedPrompts()[0]; strings.Contains(prompt, "product manager") || strings.Contains(prompt, "Product Manager") {
+		t.Errorf("the persona should only be in the system instruction:\n%s", prompt)
+	}
+}
+
+func TestRepositoryOverridesSystemPrompt(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", OrgConfigRepo, RepoConfigPath, "system_prompts:\n  translate: \"Translate for {{repo}}.\"\n  need_prd: \"Unused.\"\n")
+	env.github.addFile("acme", "widgets", RepoConfigPath, "language: Japanese\nsystem_prompts:\n  need_prd: \"{{default}} {{repo}} serves hospitals; write for {{language}} readers.\"\n")
+	systems := prdSystemPrompts(t, env)
+
+	if want := systemPrompts[CommandGeneratePRD] + " acme/widgets serves hospitals; write for Japanese readers."; systems[0] != want {
+		t.Errorf("PRD system instruction = %q, want %q", systems[0], want)
+	}
+	// The language is configured, so it isn't detected.
+	if systems[1] != "Translate for acme/widgets." {
+		t.Errorf("the organization's translate prompt should apply, got %q", systems[1])
+	}
+}
+
+func TestInvalidSystemPromptFallsBackToDefault(t *testing.T) {
+	set := &promptSet{repo: "acme/widgets", overrides: map[string]string{promptDetectLanguage: "{{unknown}}"}}
+	ctx := withSystemPrompt(context.WithValue(context.Background(), promptSetKey{}, set), promptDetectLanguage)
+	if got := systemPrompt(ctx); got != systemPrompts[promptDetectLanguage] {
+		t.Errorf("systemPrompt = %q, want the built-in prompt", got)
+	}
+	if got := systemPrompt(context.Background()); got != "" {
+		t.Errorf("a context without a system prompt should have none, got %q", got)
+	}
+}
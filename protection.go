@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// MergeRequirementsIdentifier heads the merge requirements listed in pull
+// request bodies.
+const MergeRequirementsIdentifier = "### Merge Requirements"
+
+// branchRules are the protection rules and rulesets that affect a pull
+// request from a new head branch into base.
+type branchRules struct {
+	Base string
+
+	CreationBlocked   bool     // rulesets forbid creating the head branch
+	RequiredChecks    []string // status checks that must pass on base
+	RequiredReviews   int      // approving reviews base requires
+	RequireSignatures bool     // base only accepts signed commits
+	SignedCommits     bool     // the bot signs its commits
+}
+
+// loadBranchRules reads the rules that apply to a pull request from head into
+// base. Repository rulesets are readable with the app's permissions; classic
+// branch protection needs the Administration permission and is skipped
+// without it. Rules that can't be read are ignored: the push reports them.
+func (b *Bot) loadBranchRules(ctx context.Context, client *github.Client, repo *github.Repository, base, head string) *branchRules {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	rules := &branchRules{Base: base, SignedCommits: b.commitBackend == commitBackendAPI || b.signer != nil}
+
+	if headRules, _, err := client.Repositories.GetRulesForBranch(ctx, owner, name, head); err != nil {
+		log.Printf("Could not read the rules of %s in %s/%s: %v", head, owner, name, err)
+	} else {
+		rules.CreationBlocked = slices.ContainsFunc(headRules, func(r *github.RepositoryRule) bool { return r.Type == "creation" })
+	}
+
+	baseRules, _, err := client.Repositories.GetRulesForBranch(ctx, owner, name, base)
+	if err != nil {
+		log.Printf("Could not read the rules of %s in %s/%s: %v", base, owner, name, err)
+	}
+	for _, rule := range baseRules {
+		switch rule.Type {
+		case "required_status_checks":
+			var params github.RequiredStatusChecksRuleParameters
+			if rule.Parameters != nil && json.Unmarshal(*rule.Parameters, &params) == nil {
+				for _, check := range params.RequiredStatusChecks {
+					rules.addCheck(check.Context)
+				}
+			}
+		case "pull_request":
+			var params github.PullRequestRuleParameters
+			if rule.Parameters != nil && json.Unmarshal(*rule.Parameters, &params) == nil {
+				rules.RequiredReviews = max(rules.RequiredReviews, params.RequiredApprovingReviewCount)
+			}
+		case "required_signatures":
+			rules.RequireSignatures = true
+		}
+	}
+
+	protection, _, err := client.Repositories.GetBranchProtection(ctx, owner, name, base)
+	if err != nil {
+		// Expected without the Administration permission or protection.
+		return rules
+	}
+	if checks := protection.GetRequiredStatusChecks(); checks != nil {
+		for _, c := range checks.Contexts {
+			rules.addCheck(c)
+		}
+		for _, check := range checks.Checks {
+			rules.addCheck(check.Context)
+		}
+	}
+	if reviews := protection.GetRequiredPullRequestReviews(); reviews != nil {
+		rules.RequiredReviews = max(rules.RequiredReviews, reviews.RequiredApprovingReviewCount)
+	}
+	if protection.GetRequiredSignatures().GetEnabled() {
+		rules.RequireSignatures = true
+	}
+	return rules
+}
+
+func (r *branchRules) addCheck(check string) {
+	if check != "" && !slices.Contains(r.RequiredChecks, check) {
+		r.RequiredChecks = append(r.RequiredChecks, check)
+	}
+}
+
+// creationError explains why the bot can't create head.
+func (r *branchRules) creationError(head string) error {
+	return fmt.Errorf("%w: a repository ruleset restricts creating %s", ErrBranchProtected, head)
+}
+
+// requirements lists what must happen before the pull request can be
+// merged, or nil when nothing beyond the usual review does.
+func (r *branchRules) requirements() []string {
+	var lines []string
+	if len(r.RequiredChecks) > 0 {
+		lines = append(lines, fmt.Sprintf("The required status checks must pass: `%s`.", strings.Join(r.RequiredChecks, "`, `")))
+	}
+	if r.RequiredReviews > 0 {
+		lines = append(lines, fmt.Sprintf("%d approving review(s) are required.", r.RequiredReviews))
+	}
+	if r.RequireSignatures && !r.SignedCommits {
+		lines = append(lines, fmt.Sprintf("`%s` requires signed commits, but my commits aren't signed, so merging is blocked. Ask the bot operator to set `COMMIT_SIGNING_KEY` or `COMMIT_BACKEND=api`.", r.Base))
+	}
+	return lines
+}
+
+// formatMergeRequirements renders the merge requirements as a pull request
+// body section, or "" when there are none.
+func (r *branchRules) formatMergeRequirements() string {
+	lines := r.requirements()
+	if len(lines) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s\n\nThe protection rules of `%s` require the following before this pull request can be merged:\n\n- %s", MergeRequirementsIdentifier, r.Base, strings.Join(lines, "\n- "))
+}
+
+// mergeNote explains the constraints that block the merge in the comment
+// announcing the pull request, or returns "" when nothing blocks it.
+func (r *branchRules) mergeNote() string {
+	lines := r.requirements()
+	if len(lines) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("\n\nBefore it can be merged into `%s`:\n\n- %s", r.Base, strings.Join(lines, "\n- "))
+}
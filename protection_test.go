@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func ruleWithParameters(t *testing.T, kind string, params any) *github.RepositoryRule {
+	t.Helper()
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw := json.RawMessage(data)
+	return &github.RepositoryRule{Type: kind, Parameters: &raw}
+}
+
+func TestImplementFeatureStopsWhenBranchCreationIsRestricted(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.rules["feature/"] = []*github.RepositoryRule{{Type: "creation"}}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("no pull request should be opened, got %d", len(pulls))
+	}
+	if slices.ContainsFunc(env.runner.executed(), func(c string) bool { return strings.HasPrefix(c, "gemini ") || strings.HasPrefix(c, "git push") }) {
+		t.Errorf("the bot shouldn't edit or push, ran %q", env.runner.executed())
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "**Error code:** `BRANCH_PROTECTED`") || !strings.Contains(last, "bypass list") {
+		t.Errorf("unexpected failure comment:\n%s", last)
+	}
+}
+
+func TestPullRequestListsMergeRequirements(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.rules["main"] = []*github.RepositoryRule{
+		ruleWithParameters(t, "required_status_checks", github.RequiredStatusChecksRuleParameters{
+			RequiredStatusChecks: []github.RuleRequiredStatusChecks{{Context: "ci/build"}},
+		}),
+		ruleWithParameters(t, "pull_request", github.PullRequestRuleParameters{RequiredApprovingReviewCount: 1}),
+		{Type: "required_signatures"},
+	}
+	env.github.protection["main"] = &github.Protection{
+		RequiredStatusChecks:       &github.RequiredStatusChecks{Contexts: []string{"ci/build", "lint"}},
+		RequiredPullRequestReviews: &github.PullRequestReviewsEnforcement{RequiredApprovingReviewCount: 2},
+	}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(pulls))
+	}
+	requirements := []string{
+		"- The required status checks must pass: `ci/build`, `lint`.",
+		"- 2 approving review(s) are required.",
+		"- `main` requires signed commits, but my commits aren't signed, so merging is blocked.",
+	}
+	body := pulls[0].GetBody()
+	if !strings.Contains(body, MergeRequirementsIdentifier) {
+		t.Errorf("expected the merge requirements in:\n%s", body)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	last := comments[len(comments)-1].GetBody()
+	for _, want := range requirements {
+		if !strings.Contains(body, want) || !strings.Contains(last, want) {
+			t.Errorf("expected %q in the pull request and the comment:\n%s\n\n%s", want, body, last)
+		}
+	}
+}
+
+func TestSignedCommitsSatisfySignatureRules(t *testing.T) {
+	rules := &branchRules{Base: "main", RequireSignatures: true, SignedCommits: true}
+	if note := rules.mergeNote(); note != "" {
+		t.Errorf("signed commits shouldn't need a note, got %q", note)
+	}
+	if section := rules.formatMergeRequirements(); section != "" {
+		t.Errorf("unexpected section %q", section)
+	}
+}
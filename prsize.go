@@ -0,0 +1,282 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// SplitPlanIdentifier marks comments proposing how to split an oversized change.
+	SplitPlanIdentifier = "### Pull Request Split Plan"
+
+	// Modes of PRSizeBudget.OnExceed, also accepted as implement_feature arguments.
+	splitModeConfirm = "confirm" // post the split plan and wait for the user
+	splitModeSplit   = "split"   // open one pull request per group
+	splitModeSingle  = "single"  // ignore the budget and open a single pull request
+
+	// maxSplitGroups bounds the number of pull requests one change is split into.
+	maxSplitGroups = 10
+)
+
+// PRSizeBudget limits the size of the pull requests opened by implement_feature.
+// A zero limit is not enforced.
+type PRSizeBudget struct {
+	MaxLines int `yaml:"max_lines"` // added plus deleted lines
+	MaxFiles int `yaml:"max_files"`
+	// OnExceed is "confirm" (default) to post a split plan first, or "split"
+	// to open the smaller pull requests right away.
+	OnExceed string `yaml:"on_exceed"`
+}
+
+// exceeded reports whether a change of the given size is over the budget.
+func (b *PRSizeBudget) exceeded(files, lines int) bool {
+	if b == nil {
+		return false
+	}
+	return b.MaxFiles > 0 && files > b.MaxFiles || b.MaxLines > 0 && lines > b.MaxLines
+}
+
+func (b *PRSizeBudget) mode() string {
+	if b != nil && b.OnExceed == splitModeSplit {
+		return splitModeSplit
+	}
+	return splitModeConfirm
+}
+
+// fileStat is the size of the change to one file.
+type fileStat struct {
+	Path    string
+	Added   int
+	Deleted int
+}
+
+func (s fileStat) lines() int { return s.Added + s.Deleted }
+
+// parseNumstat parses the output of `git diff --numstat --no-renames`. Binary
+// files count as changing no lines.
+func parseNumstat(out string) []fileStat {
+	var stats []fileStat
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || fields[2] == "" {
+			continue
+		}
+		added, _ := strconv.Atoi(fields[0])
+		deleted, _ := strconv.Atoi(fields[1])
+		stats = append(stats, fileStat{Path: fields[2], Added: added, Deleted: deleted})
+	}
+	return stats
+}
+
+func totalLines(stats []fileStat) int {
+	total := 0
+	for _, s := range stats {
+		total += s.lines()
+	}
+	return total
+}
+
+// splitGroup is a logically coherent part of a change, opened as its own pull request.
+type splitGroup struct {
+	Title   string   `json:"title"`
+	Summary string   `json:"summary"`
+	Files   []string `json:"files"`
+}
+
+// planSplit groups the changed files into smaller pull requests. The model
+// proposes the grouping; when it fails or returns an invalid plan, the files
+// are grouped by directory instead.
+func planSplit(ctx context.Context, llm Generator, issue *github.Issue, stats []fileStat, budget *PRSizeBudget) []splitGroup {
+	groups, err := generateSplitPlan(ctx, llm, issue, stats, budget)
+	if err == nil {
+		return groups
+	}
+	log.Printf("Could not plan the split of issue #%d with the model, grouping by directory: %v", issue.GetNumber(), err)
+	return groupByDirectory(stats, budget)
+}
+
+func generateSplitPlan(ctx context.Context, llm Generator, issue *github.Issue, stats []fileStat, budget *PRSizeBudget) ([]splitGroup, error) {
+	var files strings.Builder
+	for _, s := range stats {
+		fmt.Fprintf(&files, "- %s (+%d -%d)\n", s.Path, s.Added, s.Deleted)
+	}
+	var limits []string
+	if budget.MaxFiles > 0 {
+		limits = append(limits, fmt.Sprintf("at most %d files", budget.MaxFiles))
+	}
+	if budget.MaxLines > 0 {
+		limits = append(limits, fmt.Sprintf("at most %d changed lines", budget.MaxLines))
+	}
+	prompt := fmt.Sprintf(
+		"Split the following change into smaller pull requests that can be reviewed and merged independently, in merge order. Group files by logical concern (for example data model, API, UI, tests next to the code they test). Aim for %s per pull request.\n\n"+
+			"Respond with JSON only, in this format:\n"+
+			`{"groups": [{"title": "Add the CSV encoder", "summary": "One sentence describing the pull request.", "files": ["path/to/file.go"]}]}`+"\n\n"+
+			"Every file must appear in exactly one group.\n\n"+
+			"**Issue Title:** %s\n\n**Changed files:**\n%s",
+		strings.Join(limits, " and "), issue.GetTitle(), files.String(),
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptSplit), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the split plan: %w", err)
+	}
+	var result struct {
+		Groups []splitGroup `json:"groups"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	if err := validateSplitPlan(result.Groups, stats); err != nil {
+		return nil, err
+	}
+	return result.Groups, nil
+}
+
+// validateSplitPlan checks that groups assign every changed file exactly once.
+func validateSplitPlan(groups []splitGroup, stats []fileStat) error {
+	if len(groups) < 2 || len(groups) > maxSplitGroups {
+		return fmt.Errorf("%w: %d groups", ErrModelInvalid, len(groups))
+	}
+	assigned := make(map[string]bool)
+	for _, g := range groups {
+		if strings.TrimSpace(g.Title) == "" || len(g.Files) == 0 {
+			return fmt.Errorf("%w: empty group %q", ErrModelInvalid, g.Title)
+		}
+		for _, f := range g.Files {
+			if assigned[f] || !slices.ContainsFunc(stats, func(s fileStat) bool { return s.Path == f }) {
+				return fmt.Errorf("%w: file %q is unknown or in several groups", ErrModelInvalid, f)
+			}
+			assigned[f] = true
+		}
+	}
+	if len(assigned) != len(stats) {
+		return fmt.Errorf("%w: %d of %d files assigned", ErrModelInvalid, len(assigned), len(stats))
+	}
+	return nil
+}
+
+// groupByDirectory packs files into groups in path order, starting a new
+// group at a directory boundary once the current one would exceed the budget.
+func groupByDirectory(stats []fileStat, budget *PRSizeBudget) []splitGroup {
+	sorted := slices.Clone(stats)
+	slices.SortFunc(sorted, func(a, b fileStat) int { return strings.Compare(a.Path, b.Path) })
+	var groups []splitGroup
+	var current []fileStat
+	flush := func() {
+		if len(current) == 0 {
+			return
+		}
+		var files, dirs []string
+		for _, s := range current {
+			files = append(files, s.Path)
+			if dir := path.Dir(s.Path); !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+		groups = append(groups, splitGroup{
+			Title:   fmt.Sprintf("Changes in `%s`", strings.Join(dirs, "`, `")),
+			Summary: fmt.Sprintf("Changes %d files.", len(files)),
+			Files:   files,
+		})
+		current = nil
+	}
+	for _, s := range sorted {
+		if len(groups) < maxSplitGroups-1 && budget.exceeded(len(current)+1, totalLines(current)+s.lines()) {
+			flush()
+		}
+		current = append(current, s)
+	}
+	flush()
+	return groups
+}
+
+// formatSplitPlan renders groups as a Markdown list with their sizes.
+func formatSplitPlan(groups []splitGroup, stats []fileStat) string {
+	byPath := make(map[string]fileStat, len(stats))
+	for _, s := range stats {
+		byPath[s.Path] = s
+	}
+	var b strings.Builder
+	for i, g := range groups {
+		lines := 0
+		for _, f := range g.Files {
+			lines += byPath[f].lines()
+		}
+		fmt.Fprintf(&b, "%d. **%s** (%d files, %d lines)", i+1, g.Title, len(g.Files), lines)
+		if g.Summary != "" {
+			fmt.Fprintf(&b, ": %s", g.Summary)
+		}
+		fmt.Fprintf(&b, "\n   - `%s`\n", strings.Join(g.Files, "`, `"))
+	}
+	return b.String()
+}
+
+// postSplitPlan asks the user to confirm how an oversized change is split.
+func (b *Bot) postSplitPlan(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int, base string, groups []splitGroup, stats []fileStat, budget *PRSizeBudget) {
+	body := fmt.Sprintf("%s\n\nThe change for this issue touches %d files and %d lines, which is over this repository's pull request size budget (`pr_size` in `%s`). I propose splitting it into %d pull requests:\n\n%s\n"+
+		"Reply `@%s %s %s%s` to open these pull requests, or `@%s %s %s%s` to open a single pull request anyway. The change is regenerated when you reply, so the grouping may differ slightly.",
+		SplitPlanIdentifier, len(stats), totalLines(stats), RepoConfigPath, len(groups), formatSplitPlan(groups, stats),
+		b.appName, CommandImplementFeature, splitModeSplit, withBaseFlag(repo, base), b.appName, CommandImplementFeature, splitModeSingle, withBaseFlag(repo, base))
+	b.postComment(ctx, client, repo.GetOwner().GetLogin(), repo.GetName(), issueNum, body)
+}
+
+// openSplitPullRequests opens one pull request per group from the change
+// made in ws. Each part, named after branch, branches off base
+// and holds the changes of its group's files. review, the self-assessment and
+// test results of the whole change, is added to every part.
+func (b *Bot) openSplitPullRequests(ctx context.Context, client *github.Client, repo *github.Repository, issue *github.Issue, ws workspace, base, branch string, naming *NamingConfig, groups []splitGroup, refs []configReference, review string, progress *progressComment) ([]*github.PullRequest, error) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	var pulls []*github.PullRequest
+	for i, g := range groups {
+		part := fmt.Sprintf("%s-part-%d", branch, i+1)
+		message := naming.commitMessage(issue, fmt.Sprintf("Part %d/%d: %s", i+1, len(groups), g.Title), time.Now())
+		if err := ws.publishFiles(part, message, g.Files); err != nil {
+			return pulls, err
+		}
+
+		body := fmt.Sprintf("This PR is part %d of %d implementing the feature requested in #%d. It was automatically generated by @%s.\n\n%s", i+1, len(groups), issueNum, b.appName, g.Summary)
+		if len(pulls) > 0 {
+			body += fmt.Sprintf("\n\nMerge after #%d.", pulls[len(pulls)-1].GetNumber())
+		}
+		if review != "" {
+			body += "\n\n" + review
+		}
+		partRefs := slices.DeleteFunc(slices.Clone(refs), func(r configReference) bool {
+			return !slices.ContainsFunc(r.Files, func(f string) bool { return slices.Contains(g.Files, f) })
+		})
+		if checklist := formatConfigChecklist(partRefs); checklist != "" {
+			body += "\n\n" + checklist
+		}
+		pr, updated, err := openOrUpdatePullRequest(ctx, client, repoOwner, repoName, &github.NewPullRequest{
+			Title: github.String(fmt.Sprintf("Implement Feature (%d/%d): %s", i+1, len(groups), g.Title)),
+			Head:  github.String(part),
+			Base:  github.String(base),
+			Body:  github.String(body),
+		})
+		if err != nil {
+			return pulls, err
+		}
+		progress.step("Opened pull request #%d (part %d/%d)", pr.GetNumber(), i+1, len(groups))
+		if !updated {
+			b.publishEvent(EventPROpened, repoOwner, repoName, issueNum, pr.GetHTMLURL(), map[string]any{"number": pr.GetNumber(), "branch": part, "base": base})
+		}
+		b.recordPullRequest(&botPullRequest{
+			Owner:  repoOwner,
+			Repo:   repoName,
+			Number: pr.GetNumber(),
+			Issue:  issueNum,
+			Branch: part,
+			Base:   base,
+			Files:  g.Files,
+		})
+		pulls = append(pulls, pr)
+	}
+	return pulls, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const sizeNumstat = "120\t4\tinternal/export/csv.go\n80\t0\tinternal/export/csv_test.go\n30\t10\tcmd/server/routes.go\n-\t-\tdocs/logo.png\n"
+
+func TestGroupByDirectoryKeepsGroupsWithinBudget(t *testing.T) {
+	stats := parseNumstat(sizeNumstat)
+	if len(stats) != 4 || stats[0].lines() != 124 || stats[3].lines() != 0 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	budget := &PRSizeBudget{MaxLines: 150}
+	groups := groupByDirectory(stats, budget)
+	if err := validateSplitPlan(groups, stats); err != nil {
+		t.Fatalf("directory grouping is not a valid plan: %v\n%+v", err, groups)
+	}
+	for _, g := range groups {
+		var group []fileStat
+		for _, s := range stats {
+			if strings.Contains(strings.Join(g.Files, ","), s.Path) {
+				group = append(group, s)
+			}
+		}
+		if len(group) > 1 && budget.exceeded(len(group), totalLines(group)) {
+			t.Errorf("group %q is over the budget", g.Title)
+		}
+	}
+}
+
+func TestImplementFeatureSplitsOversizedChange(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "pr_size:\n  max_files: 2\n  on_exceed: split\n")
+	env.runner.outputs = map[string]string{"--numstat": sizeNumstat}
+	env.gemini.on("Split the following change", `{"groups": [
+		{"title": "Add the CSV encoder", "summary": "Encodes reports as CSV.", "files": ["internal/export/csv.go", "internal/export/csv_test.go"]},
+		{"title": "Expose the export route", "summary": "Serves the CSV export.", "files": ["cmd/server/routes.go", "docs/logo.png"]}
+	]}`)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 2 {
+		t.Fatalf("expected 2 pull requests, got %d", len(pulls))
+	}
+	if !strings.HasSuffix(pulls[1].GetHead().GetRef(), "-part-2") || !strings.Contains(pulls[1].GetBody(), "Merge after #1") {
+		t.Errorf("unexpected second pull request %q:\n%s", pulls[1].GetHead().GetRef(), pulls[1].GetBody())
+	}
+	var restored bool
+	for _, c := range env.runner.executed() {
+		if strings.HasPrefix(c, "git restore") && strings.HasSuffix(c, "-- cmd/server/routes.go docs/logo.png") {
+			restored = true
+		}
+	}
+	if !restored {
+		t.Errorf("the second part should take its files from the feature branch: %v", env.runner.executed())
+	}
+}
+
+func TestImplementFeatureAsksBeforeSplitting(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "pr_size:\n  max_lines: 100\n")
+	env.runner.outputs = map[string]string{"--numstat": sizeNumstat}
+	env.gemini.on("Split the following change", `not json`)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Fatalf("no pull request expected before confirmation, got %d", len(pulls))
+	}
+	for _, c := range env.runner.executed() {
+		if strings.HasPrefix(c, "git push") {
+			t.Errorf("nothing should be pushed before confirmation: %s", c)
+		}
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	plan := comments[len(comments)-1].GetBody()
+	if !strings.Contains(plan, SplitPlanIdentifier) || !strings.Contains(plan, "implement_feature split") || !strings.Contains(plan, "`internal/export/csv.go`") {
+		t.Errorf("unexpected split plan:\n%s", plan)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// bucketPulls holds botPullRequest documents keyed by pullKey.
+const bucketPulls = "pulls"
+
+// botPullRequest records a pull request opened by the bot and what it was generated from.
+type botPullRequest struct {
+	Owner     string    `json:"owner"`
+	Repo      string    `json:"repo"`
+	Number    int       `json:"number"`
+	Issue     int       `json:"issue"`
+	Branch    string    `json:"branch"`
+	Base      string    `json:"base"`
+	Files     []string  `json:"files"`
+	Plan      string    `json:"plan,omitempty"`     // the approved implementation plan, if any
+	HeadSHA   string    `json:"head_sha,omitempty"` // the commit the bot last pushed to Branch
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func pullKey(owner, repo string, number int) string {
+	return fmt.Sprintf("%s/%s!%d", owner, repo, number)
+}
+
+// recordPullRequest stores pr so later events can recognise it as the bot's.
+func (b *Bot) recordPullRequest(pr *botPullRequest) {
+	if pr.CreatedAt.IsZero() {
+		pr.CreatedAt = time.Now()
+	}
+	if !b.serverConfig().Data.storePlans() {
+		pr.Plan = ""
+	}
+	// A pull request updated by a retry was already counted.
+	opened := b.lookupPullRequest(pr.Owner, pr.Repo, pr.Number) == nil
+	if err := b.store.Put(bucketPulls, pullKey(pr.Owner, pr.Repo, pr.Number), pr); err != nil {
+		log.Printf("Error recording pull request #%d in %s/%s: %v", pr.Number, pr.Owner, pr.Repo, err)
+	}
+	if opened {
+		b.recordUsage(pr.Owner, pr.Repo, func(u *repoUsage) { u.PullsOpened++ })
+	}
+}
+
+// lookupPullRequest returns the stored record of a bot pull request, or nil
+// when the pull request was not opened by the bot.
+func (b *Bot) lookupPullRequest(owner, repo string, number int) *botPullRequest {
+	var pr botPullRequest
+	ok, err := b.store.Get(bucketPulls, pullKey(owner, repo, number), &pr)
+	if err != nil {
+		log.Printf("Error reading pull request record #%d in %s/%s: %v", number, owner, repo, err)
+	}
+	if !ok || err != nil {
+		return nil
+	}
+	return &pr
+}
+
+// filePullRequest describes a pull request adding or replacing a single file.
+type filePullRequest struct {
+	Branch  string // branch to create from the default branch
+	Path    string
+	Content string
+	Message string // commit message
+	Title   string
+	Body    string
+}
+
+// openFilePullRequest commits a single file to a new branch through the
+// contents API, without cloning the repository, and opens a pull request
+// against the default branch. Retries reuse the branch, skip the commit when
+// it already holds the file, and update the pull request already open from
+// it. It fails with ErrNoChanges when the default branch already has the
+// file as requested.
+func openFilePullRequest(ctx context.Context, client *github.Client, repo *github.Repository, req filePullRequest) (*github.PullRequest, error) {
+	owner, name, base := repo.GetOwner().GetLogin(), repo.GetName(), repo.GetDefaultBranch()
+	baseRef, _, err := client.Git.GetRef(ctx, owner, name, "refs/heads/"+base)
+	if err != nil {
+		return nil, githubError(ErrPullRequestFailed, fmt.Errorf("reading branch %s: %w", base, err))
+	}
+	if current, _, _, err := client.Repositories.GetContents(ctx, owner, name, req.Path, &github.RepositoryContentGetOptions{Ref: base}); err == nil && current != nil {
+		if content, err := current.GetContent(); err == nil && content == req.Content {
+			return nil, fmt.Errorf("%w: %s on %s is already up to date", ErrNoChanges, req.Path, base)
+		}
+	}
+	_, _, err = client.Git.CreateRef(ctx, owner, name, &github.Reference{
+		Ref:    github.String("refs/heads/" + req.Branch),
+		Object: &github.GitObject{SHA: baseRef.GetObject().SHA},
+	})
+	if err != nil && !unprocessable(err, "reference already exists") {
+		return nil, githubError(ErrPullRequestFailed, fmt.Errorf("creating branch %s: %w", req.Branch, err))
+	}
+
+	opts := &github.RepositoryContentFileOptions{
+		Message: github.String(req.Message),
+		Content: []byte(req.Content),
+		Branch:  github.String(req.Branch),
+	}
+	existing, _, _, err := client.Repositories.GetContents(ctx, owner, name, req.Path, &github.RepositoryContentGetOptions{Ref: req.Branch})
+	committed := false
+	if err == nil && existing != nil {
+		opts.SHA = existing.SHA
+		content, err := existing.GetContent()
+		committed = err == nil && content == req.Content
+	}
+	if !committed {
+		if _, _, err := client.Repositories.CreateFile(ctx, owner, name, req.Path, opts); err != nil {
+			return nil, githubError(ErrPullRequestFailed, fmt.Errorf("committing %s: %w", req.Path, err))
+		}
+	}
+
+	pr, _, err := openOrUpdatePullRequest(ctx, client, owner, name, &github.NewPullRequest{
+		Title: github.String(req.Title),
+		Head:  github.String(req.Branch),
+		Base:  github.String(base),
+		Body:  github.String(req.Body),
+	})
+	return pr, err
+}
+
+// openOrUpdatePullRequest opens a pull request from the head branch of req,
+// or updates the title and body of the one already open from it, so a
+// retried job neither fails nor opens a duplicate. updated reports the
+// latter.
+func openOrUpdatePullRequest(ctx context.Context, client *github.Client, owner, repo string, req *github.NewPullRequest) (pr *github.PullRequest, updated bool, err error) {
+	existing, err := findOpenPullRequest(ctx, client, owner, repo, req.GetHead(), req.GetBase())
+	if err != nil {
+		return nil, false, githubError(ErrPullRequestFailed, err)
+	}
+	if existing == nil {
+		pr, _, err = client.PullRequests.Create(ctx, owner, repo, req)
+		switch {
+		case err == nil:
+			return pr, false, nil
+		case unprocessable(err, "no commits between"):
+			return nil, false, fmt.Errorf("%w: %w", ErrNoChanges, err)
+		case !unprocessable(err, "already exists"):
+			return nil, false, githubError(ErrPullRequestFailed, err)
+		}
+		// Another delivery of the same webhook opened it meanwhile.
+		if existing, _ = findOpenPullRequest(ctx, client, owner, repo, req.GetHead(), req.GetBase()); existing == nil {
+			return nil, false, githubError(ErrPullRequestFailed, err)
+		}
+	}
+	log.Printf("Pull request #%d in %s/%s is already open from %s. Updating it.", existing.GetNumber(), owner, repo, req.GetHead())
+	pr, _, err = client.PullRequests.Edit(ctx, owner, repo, existing.GetNumber(), &github.PullRequest{Title: req.Title, Body: req.Body})
+	if err != nil {
+		return nil, false, githubError(ErrPullRequestFailed, fmt.Errorf("updating #%d: %w", existing.GetNumber(), err))
+	}
+	return pr, true, nil
+}
+
+// findOpenPullRequest returns the open pull request from branch of the
+// repository into base, or nil.
+func findOpenPullRequest(ctx context.Context, client *github.Client, owner, repo, branch, base string) (*github.PullRequest, error) {
+	pulls, _, err := client.PullRequests.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + branch,
+		Base:  base,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listing the pull requests from %s: %w", branch, err)
+	}
+	if len(pulls) == 0 {
+		return nil, nil
+	}
+	return pulls[0], nil
+}
+
+// unprocessable reports whether err is a validation failure of the GitHub
+// API whose message contains text, e.g. "A pull request already exists".
+func unprocessable(err error, text string) bool {
+	var ghErr *github.ErrorResponse
+	if !errors.As(err, &ghErr) || ghErr.Response == nil || ghErr.Response.StatusCode != http.StatusUnprocessableEntity {
+		return false
+	}
+	messages := []string{ghErr.Message}
+	for _, e := range ghErr.Errors {
+		messages = append(messages, e.Message)
+	}
+	return strings.Contains(strings.ToLower(strings.Join(messages, "\n")), text)
+}
+
+// implementationBranch names the branch implement_feature publishes to, and
+// returns the lease to replace it with, or "" to create it. Retries reuse
+// the branch of the issue's open pull request into base, leasing it at the
+// commit the bot last pushed, so that pull request is updated instead of
+// duplicated without losing commits others pushed to it since; other
+// existing branches are left alone.
+func (b *Bot) implementationBranch(ctx context.Context, client *github.Client, repo *github.Repository, ws workspace, issue *github.Issue, base string, naming *NamingConfig) (string, string) {
+	if record := b.openImplementation(ctx, client, repo, issue.GetNumber()); record != nil && record.Base == base && record.HeadSHA != "" {
+		return record.Branch, record.HeadSHA
+	}
+	branch := naming.branchName(issue, time.Now())
+	if !ws.branchExists(branch) {
+		return branch, ""
+	}
+	return fmt.Sprintf("%s-%d", branch, time.Now().Unix()), ""
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestImplementFeatureRetryUpdatesOpenPullRequest(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git rev-parse HEAD": "abc123\n"}
+	env.comment(t, "@prd-bot implement_feature")
+	env.comment(t, "@prd-bot implement_feature")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 || env.github.pullEdits != 1 {
+		t.Fatalf("the retry should update the open pull request, got %d pull requests and %d edits", len(pulls), env.github.pullEdits)
+	}
+	branch := pulls[0].GetHead().GetRef()
+	if executed := env.runner.executed(); !slices.Contains(executed, "git push --force-with-lease="+branch+":abc123 origin "+branch) {
+		t.Errorf("the retry should replace the branch %s, ran:\n%s", branch, strings.Join(executed, "\n"))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.HasPrefix(last, "I've updated the open Pull Request for issue #42") {
+		t.Errorf("unexpected final comment:\n%s", last)
+	}
+	var usage repoUsage
+	env.bot.store.Get(bucketUsage, "acme/widgets", &usage)
+	if usage.PullsOpened != 1 {
+		t.Errorf("the updated pull request should be counted once, got %d", usage.PullsOpened)
+	}
+}
+
+func TestImplementFeatureRetryKeepsCommitsPushedByOthers(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git rev-parse HEAD": "abc123\n"}
+	env.comment(t, "@prd-bot implement_feature")
+	// A reviewer pushed to the branch, so it no longer points at abc123.
+	env.runner.failOn = "--force-with-lease"
+	env.runner.outputs["--force-with-lease"] = " ! [rejected]        feature/issue-42 -> feature/issue-42 (stale info)"
+	env.comment(t, "@prd-bot implement_feature")
+
+	if slices.ContainsFunc(env.runner.executed(), func(c string) bool { return strings.HasPrefix(c, "git push --force ") }) {
+		t.Error("the retry shouldn't force the branch without a lease")
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "`BRANCH_CHANGED`") {
+		t.Errorf("expected a BRANCH_CHANGED failure, got:\n%s", last)
+	}
+}
+
+func TestImplementFeatureWithoutChanges(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.failOn = "git commit"
+	env.runner.outputs = map[string]string{"git commit": "On branch feature/issue-42\nnothing to commit, working tree clean"}
+
+	env.comment(t, "@prd-bot implement_feature")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "`NO_CHANGES`") {
+		t.Errorf("expected a NO_CHANGES failure, got:\n%s", last)
+	}
+	if slices.ContainsFunc(env.runner.executed(), func(c string) bool { return strings.HasPrefix(c, "git push") }) {
+		t.Error("an empty branch shouldn't be pushed")
+	}
+}
+
+func TestOpenFilePullRequestIsRetrySafe(t *testing.T) {
+	env := newTestEnv(t)
+	client, _ := env.github.Client(7)
+	repo := &github.Repository{Name: github.String("widgets"), Owner: &github.User{Login: github.String("acme")}, DefaultBranch: github.String("main")}
+	req := filePullRequest{Branch: "docs/prd-issue-42", Path: "docs/prd/issue-42.md", Content: "# PRD", Message: "docs: Archive", Title: "Archive #42", Body: "Archives #42."}
+
+	first, err := openFilePullRequest(context.Background(), client, repo, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Body = "Archives #42 again."
+	second, err := openFilePullRequest(context.Background(), client, repo, req)
+	if err != nil {
+		t.Fatalf("a retry should update the pull request: %v", err)
+	}
+	if second.GetNumber() != first.GetNumber() || second.GetBody() != "Archives #42 again." || len(env.github.pullRequests()) != 1 {
+		t.Errorf("expected #%d to be updated, got #%d %q", first.GetNumber(), second.GetNumber(), second.GetBody())
+	}
+
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	_, err = openFilePullRequest(context.Background(), client, repo, filePullRequest{Branch: "docs/readme", Path: "README.md", Content: "# Widgets", Title: "README"})
+	if !errors.Is(err, ErrNoChanges) {
+		t.Errorf("a file already on the default branch should fail with ErrNoChanges, got %v", err)
+	}
+}
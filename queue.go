@@ -0,0 +1,507 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Deployment modes selected with the MODE environment variable.
+const (
+	modeAll      = "all"      // receive webhooks and run the work in one process (default)
+	modeFrontend = "frontend" // receive webhooks, queue them and own the store
+	modeWorker   = "worker"   // lease queued webhooks from a frontend and run them
+)
+
+const (
+	// jobLeaseTimeout is how long a worker may hold a job before it is handed
+	// to another worker. It covers the slowest command, implement_feature.
+	jobLeaseTimeout = 30 * time.Minute
+	// leaseWait is how long a lease request waits for a job to arrive.
+	leaseWait = 25 * time.Second
+	// workerRetryDelay spaces out lease attempts while the frontend is unreachable.
+	workerRetryDelay = 5 * time.Second
+)
+
+// queuedJob is a verified webhook waiting to be handled. It is also the
+// document persisted in the webhooks and dead letter buckets.
+type queuedJob struct {
+	ID       string          `json:"id"`    // the X-GitHub-Delivery ID when there is one
+	Event    string          `json:"event"` // the X-GitHub-Event type
+	Payload  json.RawMessage `json:"payload"`
+	Attempts int             `json:"attempts"`
+	Queued   time.Time       `json:"queued"`
+	Lane     string          `json:"lane,omitempty"` // one of jobLanes; heavy when unknown
+
+	// Error and Failed record why and when the job was dead-lettered.
+	Error  string    `json:"error,omitempty"`
+	Failed time.Time `json:"failed,omitzero"`
+
+	leaseExpires time.Time
+}
+
+// newQueuedJob returns the job for a webhook, with a random ID when the
+// delivery has none.
+func newQueuedJob(id, event string, payload []byte) *queuedJob {
+	if id == "" {
+		var random [8]byte
+		rand.Read(random[:])
+		id = hex.EncodeToString(random[:])
+	}
+	return &queuedJob{ID: id, Event: event, Payload: payload, Queued: time.Now()}
+}
+
+// jobQueue is the frontend's in-memory queue of webhooks. Workers lease jobs
+// and acknowledge them once handled; jobs whose lease expires are handed out
+// again, until they have been attempted maxAttempts times. Each lane is a
+// FIFO queue, and jobs in earlier jobLanes are leased first.
+type jobQueue struct {
+	leaseTimeout time.Duration
+	maxAttempts  int
+	now          func() time.Time
+	// settled is called, without the lock, when a job leaves the queue:
+	// with an empty reason when it was handled, otherwise with the reason
+	// it was given up.
+	settled func(job *queuedJob, reason string)
+
+	mu      sync.Mutex
+	pending map[string][]*queuedJob // lane -> jobs
+	leased  map[string]*queuedJob
+	arrived chan struct{} // closed when a job is queued
+}
+
+func newJobQueue(settled func(job *queuedJob, reason string)) *jobQueue {
+	return &jobQueue{
+		leaseTimeout: jobLeaseTimeout,
+		maxAttempts:  maxDeliveryAttempts,
+		now:          time.Now,
+		settled:      settled,
+		pending:      make(map[string][]*queuedJob),
+		leased:       make(map[string]*queuedJob),
+		arrived:      make(chan struct{}),
+	}
+}
+
+// enqueue adds a job to the queue.
+func (q *jobQueue) enqueue(job *queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !slices.Contains(jobLanes, job.Lane) {
+		job.Lane = laneHeavy
+	}
+	q.pending[job.Lane] = append(q.pending[job.Lane], job)
+	close(q.arrived)
+	q.arrived = make(chan struct{})
+}
+
+// lease hands out the oldest pending job of the highest-priority lane among
+// lanes, or among all lanes when lanes is empty, waiting up to wait for one.
+func (q *jobQueue) lease(ctx context.Context, wait time.Duration, lanes []string) *queuedJob {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		q.mu.Lock()
+		abandoned := q.requeueExpired()
+		var job *queuedJob
+		for _, lane := range jobLanes {
+			if len(q.pending[lane]) == 0 || (len(lanes) > 0 && !slices.Contains(lanes, lane)) {
+				continue
+			}
+			job = q.pending[lane][0]
+			q.pending[lane] = q.pending[lane][1:]
+			job.Attempts++
+			job.leaseExpires = q.now().Add(q.leaseTimeout)
+			q.leased[job.ID] = job
+			break
+		}
+		arrived := q.arrived
+		q.mu.Unlock()
+		for _, a := range abandoned {
+			q.settle(a, fmt.Sprintf("the lease expired on each of %d attempts", a.Attempts))
+		}
+		if job != nil {
+			return job
+		}
+		select {
+		case <-arrived:
+		case <-timer.C:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// requeueExpired returns jobs whose lease expired to the front of their lane
+// and returns the ones out of attempts. The caller must hold q.mu.
+func (q *jobQueue) requeueExpired() []*queuedJob {
+	now := q.now()
+	var abandoned []*queuedJob
+	for id, job := range q.leased {
+		if !now.Before(job.leaseExpires) {
+			delete(q.leased, id)
+			if job.Attempts >= q.maxAttempts {
+				abandoned = append(abandoned, job)
+				continue
+			}
+			log.Printf("Lease of job %s (%s) expired, queueing it again.", id, job.Event)
+			q.pending[job.Lane] = append([]*queuedJob{job}, q.pending[job.Lane]...)
+		}
+	}
+	return abandoned
+}
+
+func (q *jobQueue) settle(job *queuedJob, reason string) {
+	if q.settled != nil {
+		q.settled(job, reason)
+	}
+}
+
+// finish removes a leased job. A failed job, described by reason, is queued
+// again unless it is out of attempts. It reports whether the job was leased.
+func (q *jobQueue) finish(id string, failed bool, reason string) bool {
+	q.mu.Lock()
+	job, ok := q.leased[id]
+	if !ok {
+		q.mu.Unlock()
+		return false
+	}
+	delete(q.leased, id)
+	retry := failed && job.Attempts < q.maxAttempts
+	if retry {
+		q.pending[job.Lane] = append(q.pending[job.Lane], job)
+		close(q.arrived)
+		q.arrived = make(chan struct{})
+	}
+	q.mu.Unlock()
+	switch {
+	case !failed:
+		q.settle(job, "")
+	case !retry:
+		q.settle(job, fmt.Sprintf("failed on each of %d attempts, last with: %s", job.Attempts, reason))
+	}
+	return true
+}
+
+// registerQueueHandlers serves the queue, the store and the issue locks to
+// workers.
+func (b *Bot) registerQueueHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("POST /internal/queue/lease", b.handleLease)
+	mux.HandleFunc("POST /internal/queue/jobs/{id}/{result}", b.handleJobResult)
+	mux.HandleFunc("GET /internal/store/{bucket}", b.handleStoreList)
+	mux.HandleFunc("GET /internal/store/{bucket}/{key...}", b.handleStoreDocument)
+	mux.HandleFunc("PUT /internal/store/{bucket}/{key...}", b.handleStoreDocument)
+	mux.HandleFunc("DELETE /internal/store/{bucket}/{key...}", b.handleStoreDocument)
+	mux.HandleFunc("POST /internal/locks/{key...}", b.handleLock)
+	mux.HandleFunc("DELETE /internal/locks/{key...}", b.handleLock)
+}
+
+// authorizeWorker checks the request's bearer token against WORKER_TOKEN.
+func (b *Bot) authorizeWorker(w http.ResponseWriter, r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if b.workerToken == "" || !ok || subtle.ConstantTimeCompare([]byte(token), []byte(b.workerToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+func encodeWorkerResponse(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error encoding worker response: %v", err)
+	}
+}
+
+func (b *Bot) handleLease(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeWorker(w, r) {
+		return
+	}
+	var lanes []string
+	if v := r.URL.Query().Get("lanes"); v != "" {
+		lanes = strings.Split(v, ",")
+	}
+	job := b.queue.lease(r.Context(), leaseWait, lanes)
+	if job == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	encodeWorkerResponse(w, job)
+}
+
+func (b *Bot) handleJobResult(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeWorker(w, r) {
+		return
+	}
+	var failed bool
+	var result struct {
+		Error string `json:"error"`
+	}
+	switch r.PathValue("result") {
+	case "ack":
+	case "nack":
+		failed = true
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if !b.queue.finish(r.PathValue("id"), failed, result.Error) {
+		http.Error(w, "Job is not leased", http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (b *Bot) handleStoreList(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeWorker(w, r) {
+		return
+	}
+	docs, err := b.store.List(r.PathValue("bucket"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	encodeWorkerResponse(w, docs)
+}
+
+func (b *Bot) handleStoreDocument(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeWorker(w, r) {
+		return
+	}
+	bucket, key := r.PathValue("bucket"), r.PathValue("key")
+	switch r.Method {
+	case http.MethodGet:
+		var doc json.RawMessage
+		found, err := b.store.Get(bucket, key, &doc)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else if !found {
+			http.NotFound(w, r)
+		} else {
+			encodeWorkerResponse(w, doc)
+		}
+	case http.MethodPut:
+		var doc json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&doc); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := b.store.Put(bucket, key, doc); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := b.store.Delete(bucket, key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// frontendClient talks to a frontend's internal API on behalf of a worker.
+type frontendClient struct {
+	baseURL string
+	token   string
+	client  *http.Client
+	lanes   []string // the lanes to lease jobs from; all when empty
+}
+
+func newFrontendClient(baseURL, token string) *frontendClient {
+	// Lease requests are held open for leaseWait.
+	return &frontendClient{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  &http.Client{Transport: sharedTransport, Timeout: leaseWait + githubRequestTimeout},
+	}
+}
+
+func (c *frontendClient) do(ctx context.Context, method, path string, body any) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return resp, nil
+}
+
+// expect closes resp and returns an error unless it has one of the statuses.
+func expect(resp *http.Response, statuses ...int) error {
+	defer resp.Body.Close()
+	for _, status := range statuses {
+		if resp.StatusCode == status {
+			return nil
+		}
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	return fmt.Errorf("%s %s: %s: %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, bytes.TrimSpace(msg))
+}
+
+// lease waits for the next job, returning nil when none arrived in time.
+func (c *frontendClient) lease(ctx context.Context) (*queuedJob, error) {
+	path := "/internal/queue/lease"
+	if len(c.lanes) > 0 {
+		path += "?lanes=" + url.QueryEscape(strings.Join(c.lanes, ","))
+	}
+	resp, err := c.do(ctx, http.MethodPost, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNoContent {
+		resp.Body.Close()
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expect(resp, http.StatusOK)
+	}
+	defer resp.Body.Close()
+	var job queuedJob
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return nil, fmt.Errorf("decoding job: %w", err)
+	}
+	return &job, nil
+}
+
+// finish acknowledges a job, or reports it failed with cause when it isn't nil.
+func (c *frontendClient) finish(ctx context.Context, id string, cause error) error {
+	result, body := "ack", any(nil)
+	if cause != nil {
+		result, body = "nack", map[string]string{"error": cause.Error()}
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/internal/queue/jobs/"+url.PathEscape(id)+"/"+result, body)
+	if err != nil {
+		return err
+	}
+	return expect(resp, http.StatusNoContent)
+}
+
+// remoteStore is the Store of a worker: the frontend's store, over HTTP.
+type remoteStore struct {
+	frontend *frontendClient
+}
+
+func storePath(bucket, key string) string {
+	return "/internal/store/" + url.PathEscape(bucket) + "/" + url.PathEscape(key)
+}
+
+func (s *remoteStore) Get(bucket, key string, v any) (bool, error) {
+	resp, err := s.frontend.do(context.Background(), http.MethodGet, storePath(bucket, key), nil)
+	if err != nil {
+		return false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, expect(resp, http.StatusOK)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (s *remoteStore) Put(bucket, key string, v any) error {
+	resp, err := s.frontend.do(context.Background(), http.MethodPut, storePath(bucket, key), v)
+	if err != nil {
+		return err
+	}
+	return expect(resp, http.StatusNoContent)
+}
+
+func (s *remoteStore) Delete(bucket, key string) error {
+	resp, err := s.frontend.do(context.Background(), http.MethodDelete, storePath(bucket, key), nil)
+	if err != nil {
+		return err
+	}
+	return expect(resp, http.StatusNoContent)
+}
+
+func (s *remoteStore) List(bucket string) (map[string]json.RawMessage, error) {
+	resp, err := s.frontend.do(context.Background(), http.MethodGet, "/internal/store/"+url.PathEscape(bucket), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, expect(resp, http.StatusOK)
+	}
+	defer resp.Body.Close()
+	var docs map[string]json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&docs); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", bucket, err)
+	}
+	return docs, nil
+}
+
+// runWorker leases webhooks from the frontend and handles them one at a
+// time until ctx is cancelled. Scale out by running more workers.
+func (b *Bot) runWorker(ctx context.Context, frontend *frontendClient) {
+	for ctx.Err() == nil {
+		job, err := frontend.lease(ctx)
+		if err != nil {
+			log.Printf("Error leasing a job: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(workerRetryDelay):
+			}
+			continue
+		}
+		if job == nil {
+			continue
+		}
+		log.Printf("Handling job %s (%s, attempt %d).", job.ID, job.Event, job.Attempts)
+		// Wait for the handlers the event dispatched before acknowledging it.
+		scope, err := b.handleScoped(job)
+		scope.wg.Wait()
+		if err == nil {
+			err = scope.err()
+		}
+		if err != nil {
+			log.Printf("Error handling job %s: %v", job.ID, err)
+		}
+		if errors.Is(err, errInvalidEvent) {
+			// Retrying can't fix the payload.
+			err = nil
+		}
+		if err := frontend.finish(ctx, job.ID, err); err != nil {
+			log.Printf("Error finishing job %s: %v", job.ID, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobQueueRedeliversExpiredLeases(t *testing.T) {
+	q := newJobQueue(nil)
+	now := time.Now()
+	q.now = func() time.Time { return now }
+	id := "delivery-1"
+	q.enqueue(newQueuedJob(id, "issues", []byte(`{}`)))
+
+	job := q.lease(context.Background(), 0, nil)
+	if job == nil || job.ID != id || job.Attempts != 1 {
+		t.Fatalf("unexpected lease %+v", job)
+	}
+	if again := q.lease(context.Background(), 0, nil); again != nil {
+		t.Fatalf("a leased job shouldn't be handed out twice, got %+v", again)
+	}
+	now = now.Add(jobLeaseTimeout)
+	if job = q.lease(context.Background(), 0, nil); job == nil || job.Attempts != 2 {
+		t.Fatalf("the expired job should be leased again, got %+v", job)
+	}
+	if !q.finish(job.ID, false, "") || q.finish(job.ID, false, "") {
+		t.Error("a job can be acknowledged once")
+	}
+}
+
+func TestWorkerHandlesQueuedWebhooks(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", "翻譯")
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", "1.  **Background:** Export reports as CSV.")
+
+	// env.bot is the frontend: it queues the webhook and owns the store.
+	frontend := env.bot
+	frontend.queue = newJobQueue(frontend.settleDelivery)
+	frontend.workerToken = "w0rker"
+	mux := http.NewServeMux()
+	frontend.registerQueueHandlers(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	if rec := env.deliver(t, "issues", "issues_opened.json"); rec.Code != http.StatusAccepted {
+		t.Fatalf("the frontend should accept the webhook for later, got %d", rec.Code)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Fatalf("the frontend shouldn't handle the webhook itself, got %+v", comments)
+	}
+
+	client := newFrontendClient(server.URL, "w0rker")
+	worker := NewBot(frontend.appName, testWebhookSecret, frontend.clients, frontend.llm)
+	worker.store = &remoteStore{frontend: client}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		worker.runWorker(ctx, client)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		artifact, err := frontend.loadArtifact("acme", "widgets", 42, ArtifactPRD)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if artifact != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the worker didn't store the PRD in the frontend's store")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 {
+		t.Errorf("expected the worker to post the PRD, got %d comments", len(comments))
+	}
+}
+
+func TestJobQueueLeasesQuickJobsFirst(t *testing.T) {
+	q := newJobQueue(nil)
+	for _, job := range []*queuedJob{
+		{ID: "implement-1", Lane: laneHeavy},
+		{ID: "implement-2", Lane: laneHeavy},
+		{ID: "prd", Lane: laneQuick},
+		{ID: "legacy"},
+	} {
+		q.enqueue(job)
+	}
+
+	if job := q.lease(context.Background(), 0, []string{laneHeavy}); job == nil || job.ID != "implement-1" {
+		t.Fatalf("a heavy-only lease should skip quick jobs, got %+v", job)
+	}
+	var order []string
+	for job := q.lease(context.Background(), 0, nil); job != nil; job = q.lease(context.Background(), 0, nil) {
+		order = append(order, job.ID)
+	}
+	if want := "prd implement-2 legacy"; strings.Join(order, " ") != want {
+		t.Errorf("lease order = %q, want %q", strings.Join(order, " "), want)
+	}
+	q.enqueue(&queuedJob{ID: "implement-3", Lane: laneHeavy})
+	if job := q.lease(context.Background(), 0, []string{laneQuick}); job != nil {
+		t.Errorf("a quick-only lease shouldn't take a heavy job, got %+v", job)
+	}
+}
+
+func TestJobLane(t *testing.T) {
+	env := newTestEnv(t)
+	tests := []struct {
+		event   string
+		payload string
+		want    string
+	}{
+		{"issue_comment", `{"action": "created", "comment": {"body": "@prd-bot need_prd"}}`, laneQuick},
+		{"issue_comment", `{"action": "created", "comment": {"body": "@prd-bot implement_feature --base release/1.x"}}`, laneHeavy},
+		{"issue_comment", `{"action": "created", "comment": {"body": "@prd-bot proceed"}}`, laneHeavy},
+		{"issues", `{"action": "opened"}`, laneQuick},
+		{"issues", `{"action": "labeled"}`, laneHeavy},
+		{"push", `{}`, laneHeavy},
+	}
+	for _, tt := range tests {
+		if got := env.bot.jobLane(tt.event, []byte(tt.payload)); got != tt.want {
+			t.Errorf("jobLane(%s, %s) = %s, want %s", tt.event, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestParseLanes(t *testing.T) {
+	if lanes, err := parseLanes(" quick, "); err != nil || len(lanes) != 1 || lanes[0] != laneQuick {
+		t.Errorf("parseLanes = %q, %v", lanes, err)
+	}
+	if _, err := parseLanes("quick,urgent"); err == nil {
+		t.Error("expected an error for an unknown lane")
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// GitHub computes mergeability asynchronously after a push, so the bot polls
+// a few times before giving up on a pull request.
+var (
+	mergeabilityAttempts     = 4
+	mergeabilityPollInterval = 5 * time.Second
+)
+
+// handlePush rebases the bot's conflicting pull requests when their base
+// (the default branch) moves.
+func (b *Bot) handlePush(ctx context.Context, event *github.PushEvent) {
+	repo := event.GetRepo()
+	base := repo.GetDefaultBranch()
+	if event.GetRef() != "refs/heads/"+base {
+		log.Printf("Ignoring push to %s in %s.", event.GetRef(), repo.GetFullName())
+		return
+	}
+	owner, name, installationID := repo.GetOwner().GetLogin(), repo.GetName(), event.GetInstallation().GetID()
+	client, err := b.clients.Client(installationID)
+	if err != nil {
+		log.Printf("Error creating GitHub client for push event: %v", err)
+		return
+	}
+	if configChanged(event) {
+		// Settings such as the PRD language apply from the next webhook.
+		b.config.Invalidate(owner, name)
+		if name != OrgConfigRepo {
+			b.dispatch(ctx, func() {
+				b.refreshPRDTranslations(b.withInstallation(context.Background(), installationID), client, owner, name)
+			})
+		}
+	}
+	b.dispatch(ctx, func() {
+		b.rebaseBotPullRequests(b.withInstallation(context.Background(), installationID), client, owner, name, base, installationID)
+	})
+}
+
+// rebaseBotPullRequests brings every conflicting bot pull request targeting
+// base up to date.
+func (b *Bot) rebaseBotPullRequests(ctx context.Context, client *github.Client, owner, repo, base string, installationID int64) {
+	opts := &github.PullRequestListOptions{State: "open", Base: base, ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		pulls, resp, err := client.PullRequests.List(ctx, owner, repo, opts)
+		if err != nil {
+			log.Printf("Error listing pull requests of %s/%s: %v", owner, repo, err)
+			return
+		}
+		for _, pull := range pulls {
+			record := b.lookupPullRequest(owner, repo, pull.GetNumber())
+			if record == nil {
+				continue
+			}
+			conflicted, err := hasConflicts(ctx, client, owner, repo, pull.GetNumber())
+			if err != nil {
+				log.Printf("Error checking mergeability of #%d in %s/%s: %v", pull.GetNumber(), owner, repo, err)
+				continue
+			}
+			if !conflicted {
+				continue
+			}
+			record.Base = base
+			b.rebasePullRequest(ctx, client, record, installationID)
+		}
+		if resp.NextPage == 0 {
+			return
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// hasConflicts reports whether the pull request can no longer be merged
+// cleanly. An unknown mergeability is treated as no conflict.
+func hasConflicts(ctx context.Context, client *github.Client, owner, repo string, number int) (bool, error) {
+	for attempt := 0; attempt < mergeabilityAttempts; attempt++ {
+		pr, _, err := client.PullRequests.Get(ctx, owner, repo, number)
+		if err != nil {
+			return false, err
+		}
+		if pr.Mergeable != nil {
+			return !pr.GetMergeable() || pr.GetMergeableState() == "dirty", nil
+		}
+		time.Sleep(mergeabilityPollInterval)
+	}
+	return false, nil
+}
+
+// rebasePullRequest rebases the pull request branch onto its base. When the
+// rebase conflicts, it regenerates the AI change on top of the new base
+// instead, and asks for manual resolution only when that fails too.
+func (b *Bot) rebasePullRequest(ctx context.Context, client *github.Client, pr *botPullRequest, installationID int64) {
+	log.Printf("Rebasing bot pull request #%d in %s/%s onto %s", pr.Number, pr.Owner, pr.Repo, pr.Base)
+
+	tempDir, err := b.workdirs.allocate(fmt.Sprintf("rebase-%d-*", pr.Number))
+	if err != nil {
+		log.Printf("Error creating temporary directory for rebase: %v", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+
+	manual := func(reason string, err error) {
+		log.Printf("Automatic rebase of #%d failed: %s: %v", pr.Number, reason, err)
+		msg := fmt.Sprintf("`%s` changed and this pull request now has conflicts. I couldn't update it automatically (%s), so it needs manual resolution:\n\n"+
+			"```bash\ngit fetch origin\ngit checkout %s\ngit rebase origin/%s\n```", pr.Base, reason, pr.Branch, pr.Base)
+		b.postComment(ctx, client, pr.Owner, pr.Repo, pr.Number, msg)
+	}
+
+	token, err := b.clients.Token(ctx, installationID)
+	if err != nil {
+		manual("could not get an installation token", err)
+		return
+	}
+	cloneURL := fmt.Sprintf("https://x-access-token:%s@%s/%s/%s.git", token, b.gitHost, pr.Owner, pr.Repo)
+	if out, err := b.runner(tempDir, "git", "clone", cloneURL, "."); err != nil {
+		manual("could not clone the repository", gitError(ErrCloneFailed, out, err))
+		return
+	}
+	if err := b.workdirs.check(tempDir); err != nil {
+		manual("the repository is too large to check out", err)
+		return
+	}
+	if err := b.configureGitIdentity(tempDir); err != nil {
+		manual("could not set the git identity", err)
+		return
+	}
+	if out, err := b.runner(tempDir, "git", "checkout", pr.Branch); err != nil {
+		manual("could not check out the branch", gitError(ErrGitFailed, out, err))
+		return
+	}
+	// Only a branch still at the commit the bot pushed holds nothing but the
+	// bot's change; commits others pushed must survive.
+	head, err := b.runner(tempDir, "git", "rev-parse", "HEAD")
+	if err != nil {
+		manual("could not read the branch", gitError(ErrGitFailed, head, err))
+		return
+	}
+	botOnly := pr.HeadSHA != "" && strings.TrimSpace(head) == pr.HeadSHA
+
+	if _, err := b.runner(tempDir, "git", "rebase", "origin/"+pr.Base); err == nil {
+		if out, err := b.runner(tempDir, "git", "push", "--force-with-lease", "origin", pr.Branch); err != nil {
+			manual("could not push the rebased branch", gitError(ErrPushFailed, out, err))
+			return
+		}
+		b.recordPushedHead(tempDir, pr, botOnly)
+		b.postComment(ctx, client, pr.Owner, pr.Repo, pr.Number, fmt.Sprintf("I rebased this pull request onto the latest `%s` to resolve the conflicts.", pr.Base))
+		return
+	}
+	if out, err := b.runner(tempDir, "git", "rebase", "--abort"); err != nil {
+		manual("could not abort the conflicting rebase", gitError(ErrGitFailed, out, err))
+		return
+	}
+
+	// The original commit doesn't apply cleanly; regenerate the change from the issue on top of the new base.
+	if !botOnly {
+		manual("the rebase conflicted and the branch has commits I didn't make, which regenerating the change would drop", fmt.Errorf("%w: %s is at %s, not %q", ErrBranchChanged, pr.Branch, strings.TrimSpace(head), pr.HeadSHA))
+		return
+	}
+	if status, ok := b.checkBudget(ctx, installationID); !ok {
+		manual("the rebase conflicted and this installation has used its monthly model budget, "+status.summary()+", so I didn't regenerate the change", fmt.Errorf("installation %d is over its monthly budget", installationID))
+		return
+	}
+	issue, _, err := client.Issues.Get(ctx, pr.Owner, pr.Repo, pr.Issue)
+	if err != nil {
+		manual("the rebase conflicted and the original issue could not be read", err)
+		return
+	}
+	if out, err := b.runner(tempDir, "git", "checkout", "-B", pr.Branch, "origin/"+pr.Base); err != nil {
+		manual("could not reset the branch", gitError(ErrGitFailed, out, err))
+		return
+	}
+	if err := b.runGeminiEdit(tempDir, issue, pr.Files, pr.Plan, "", ""); err != nil {
+		manual("the rebase conflicted and re-applying the change failed", err)
+		return
+	}
+	if out, err := b.runner(tempDir, "git", "add", "."); err != nil {
+		manual("could not stage the re-applied change", gitError(ErrGitFailed, out, err))
+		return
+	}
+	if out, err := b.runner(tempDir, "git", "commit", "-m", b.namingConfig(ctx, client, pr.Owner, pr.Repo).commitMessage(issue, "", time.Now())); err != nil {
+		manual("could not commit the re-applied change", gitError(ErrGitFailed, out, err))
+		return
+	}
+	if out, err := b.runner(tempDir, "git", "push", "--force-with-lease="+pr.Branch+":"+pr.HeadSHA, "origin", pr.Branch); err != nil {
+		manual("could not push the re-applied change", gitError(ErrPushFailed, out, err))
+		return
+	}
+	b.recordPushedHead(tempDir, pr, true)
+	b.postComment(ctx, client, pr.Owner, pr.Repo, pr.Number, fmt.Sprintf("The original change no longer applied cleanly on `%s`, so I regenerated it from #%d on top of the latest base. Please review the new commit.", pr.Base, pr.Issue))
+}
+
+// recordPushedHead records the commit just pushed from dir as pr's head when
+// the bot made every commit of it, so later updates may replace the branch,
+// and forgets the head otherwise.
+func (b *Bot) recordPushedHead(dir string, pr *botPullRequest, botOnly bool) {
+	pr.HeadSHA = ""
+	if botOnly {
+		if head, err := b.runner(dir, "git", "rev-parse", "HEAD"); err == nil {
+			pr.HeadSHA = strings.TrimSpace(head)
+		}
+	}
+	b.recordPullRequest(pr)
+}
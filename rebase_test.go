@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// openBotPull creates issue #42's pull request through implement_feature and
+// marks it as conflicting.
+func openBotPull(t *testing.T, env *testEnv) int {
+	t.Helper()
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(pulls))
+	}
+	env.github.setMergeable(pulls[0].GetNumber(), false)
+	env.runner.commands = nil
+	return pulls[0].GetNumber()
+}
+
+func TestPushRebasesConflictingBotPull(t *testing.T) {
+	env := newTestEnv(t)
+	number := openBotPull(t, env)
+
+	env.deliver(t, "push", "push_main.json")
+
+	executed := strings.Join(env.runner.executed(), "\n")
+	if !strings.Contains(executed, "git rebase origin/main") || !strings.Contains(executed, "git push --force-with-lease origin feature/issue-42-") {
+		t.Errorf("expected a rebase and push, got:\n%s", executed)
+	}
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "I rebased this pull request") {
+		t.Errorf("expected a rebase comment on the pull request, got %+v", comments)
+	}
+}
+
+func TestPushReappliesChangeWhenRebaseConflicts(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git rev-parse HEAD": "abc123\n"}
+	number := openBotPull(t, env)
+	env.runner.failOn = "git rebase origin/main"
+
+	env.deliver(t, "push", "push_main.json")
+
+	executed := strings.Join(env.runner.executed(), "\n")
+	for _, want := range []string{"git rebase --abort", "git checkout -B feature/issue-42-", "gemini ", ":abc123 origin feature/issue-42-"} {
+		if !strings.Contains(executed, want) {
+			t.Errorf("expected command containing %q, got:\n%s", want, executed)
+		}
+	}
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "regenerated it from #42") {
+		t.Errorf("expected a re-apply comment, got %+v", comments)
+	}
+}
+
+func TestPushKeepsCommitsPushedByOthers(t *testing.T) {
+	env := newTestEnv(t)
+	env.runner.outputs = map[string]string{"git rev-parse HEAD": "abc123\n"}
+	number := openBotPull(t, env)
+	// A reviewer pushed to the branch after the bot.
+	env.runner.outputs["git rev-parse HEAD"] = "def456\n"
+	env.runner.failOn = "git rebase origin/main"
+
+	env.deliver(t, "push", "push_main.json")
+
+	executed := strings.Join(env.runner.executed(), "\n")
+	if strings.Contains(executed, "gemini ") || strings.Contains(executed, "git push") {
+		t.Errorf("the branch shouldn't be regenerated, ran:\n%s", executed)
+	}
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "the branch has commits I didn't make") {
+		t.Errorf("expected a manual resolution comment, got %+v", comments)
+	}
+}
+
+func TestPushAsksForManualResolution(t *testing.T) {
+	env := newTestEnv(t)
+	number := openBotPull(t, env)
+	env.runner.failOn = "git rebase"
+
+	env.deliver(t, "push", "push_main.json")
+
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "needs manual resolution") {
+		t.Errorf("expected a manual resolution comment, got %+v", comments)
+	}
+}
+
+func TestPushIgnoresMergeablePulls(t *testing.T) {
+	env := newTestEnv(t)
+	number := openBotPull(t, env)
+	env.github.setMergeable(number, true)
+
+	env.deliver(t, "push", "push_main.json")
+
+	if executed := env.runner.executed(); len(executed) != 0 {
+		t.Errorf("expected no commands for a mergeable pull request, got %v", executed)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// processRegenSection regenerates one section of the issue's PRD, e.g.
+// `@bot regen_section goals focus on mobile`, and patches the PRD comment in
+// place so the other sections are left exactly as they were.
+func (b *Bot) processRegenSection(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandRegenSection, issueNum, repoOwner, repoName)
+
+	usage := fmt.Sprintf("Usage: `@%s %s <section> [guidance]`, where section is one of `%s`.", b.appName, CommandRegenSection, strings.Join(prdSectionKeys(), "`, `"))
+	if len(args) == 0 {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, usage)
+		return
+	}
+	section, ok := findPRDSection(args[0])
+	if !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I don't know a PRD section named `%s`. %s", args[0], usage))
+		return
+	}
+	title := prdSections[section].Title
+
+	prdComment, err := b.findPRDComment(ctx, client, repoOwner, repoName, issueNum)
+	if err != nil || prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting section regeneration.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to update. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	doc, ok := parsePRDDocument(prdComment.GetBody())
+	if !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't recognize the layout of the PRD comment, so I left it unchanged.")
+		return
+	}
+	if _, ok := prdSectionContent(doc.English, section, false); !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I couldn't find the **%s** section in the PRD, so I left it unchanged.", title))
+		return
+	}
+
+	content, err := generatePRDSection(ctx, b.llm, issue, doc.English, prdSections[section], strings.Join(args[1:], " "))
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "regenerate the PRD section", "Could not regenerate the section", err)
+		return
+	}
+	doc.English, _ = replacePRDSection(doc.English, section, content, false)
+
+	var notes []string
+	if doc.Language != "" {
+		translated, err := translatePRDSection(ctx, b.llm, content, doc.Language)
+		if err == nil {
+			doc.Translated, ok = replacePRDSection(doc.Translated, section, translated, true)
+		}
+		if err != nil || !ok {
+			log.Printf("Could not update the %s translation of section %q for issue #%d: %v", doc.Language, title, issueNum, err)
+			notes = append(notes, fmt.Sprintf("The %s translation of this section was not updated.", doc.Language))
+		}
+	}
+
+	doc.revise(fmt.Sprintf("Regenerated the %s section.", title))
+	body := doc.String()
+	edited, _, err := client.Issues.EditComment(ctx, repoOwner, repoName, prdComment.GetID(), &github.IssueComment{Body: github.String(b.stampArtifact(ctx, ArtifactPRD, body))})
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "regenerate the PRD section", "Could not update the PRD comment", err)
+		return
+	}
+	b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, body, edited)
+
+	confirmation := fmt.Sprintf("I regenerated the **%s** section of the [PRD](%s); the other sections are unchanged.", title, prdComment.GetHTMLURL())
+	if len(notes) > 0 {
+		confirmation += "\n\n_" + strings.Join(notes, " ") + "_"
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, confirmation)
+}
+
+func generatePRDSection(ctx context.Context, llm Generator, issue *github.Issue, prd string, section PRDSection, guidance string) (string, error) {
+	if guidance != "" {
+		guidance = fmt.Sprintf("**Reviewer Guidance:**\n%s\n\n", guidance)
+	}
+	prompt := fmt.Sprintf(
+		"Rewrite only the **%s** section (%s) of the following Product Requirements Document (PRD), keeping it consistent with the other sections. The section should be in English.\n\n"+
+			"**GitHub Issue Title:**\n%s\n\n"+
+			"**GitHub Issue Body:**\n%s\n\n"+
+			"**Current PRD:**\n%s\n\n"+
+			"%s"+
+			"Respond with the new content of the section only, without its heading and without any other section.",
+		section.Title, section.Description, issue.GetTitle(), issue.GetBody(), prd, guidance,
+	)
+	content, err := generateMarkdown(withSystemPrompt(ctx, CommandRegenSection), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to regenerate PRD section %s: %w", section.Key, err)
+	}
+	return strings.TrimSpace(content), nil
+}
+
+func translatePRDSection(ctx context.Context, llm Generator, content, language string) (string, error) {
+	prompt := fmt.Sprintf("Translate the following section of an English PRD into %s. Maintain the original formatting and respond with the translation only.\n\n**English Section:**\n%s", language, content)
+	translated, err := generateMarkdown(withSystemPrompt(ctx, promptTranslate), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to translate PRD section: %w", err)
+	}
+	return strings.TrimSpace(translated), nil
+}
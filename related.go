@@ -0,0 +1,184 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// RelatedWorkIdentifier heads the related issues and pull requests listed
+	// under a PRD.
+	RelatedWorkIdentifier = "### Related Work"
+
+	// maxRelatedCandidates is how many search results are ranked.
+	maxRelatedCandidates = 20
+	// maxRelatedWork is how many related items a PRD lists.
+	maxRelatedWork = 5
+	// minRelatedSimilarity is the cosine similarity above which a candidate
+	// counts as related when embeddings are available.
+	minRelatedSimilarity = 0.75
+	// maxSearchKeywords bounds the words of the search query.
+	maxSearchKeywords = 6
+
+	defaultEmbeddingModel = "text-embedding-004"
+)
+
+// Embedder is implemented by generators that can embed texts, to rank
+// related work by meaning rather than by shared words.
+type Embedder interface {
+	EmbedTexts(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+// relatedItem is an existing issue or pull request related to a new one.
+type relatedItem struct {
+	Number      int
+	Title       string
+	URL         string
+	State       string // "open" or "closed"
+	PullRequest bool
+	Score       float64 // cosine similarity, or 0 when ranked by search
+}
+
+// kind describes the item, e.g. "open issue" or "closed pull request".
+func (r relatedItem) kind() string {
+	if r.PullRequest {
+		return r.State + " pull request"
+	}
+	return r.State + " issue"
+}
+
+// searchStopWords are common words left out of search queries.
+var searchStopWords = map[string]bool{
+	"the": true, "and": true, "for": true, "with": true, "from": true, "into": true, "that": true,
+	"this": true, "should": true, "can": true, "add": true, "support": true, "allow": true,
+	"make": true, "when": true, "new": true, "use": true, "not": true, "are": true, "all": true,
+}
+
+// searchKeywords picks the distinctive words of an issue title for a search
+// query. Words of scripts written without spaces are kept whole.
+func searchKeywords(title string) []string {
+	var keywords []string
+	for _, word := range strings.FieldsFunc(strings.ToLower(title), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len([]rune(word)) < 3 && !strings.ContainsFunc(word, func(r rune) bool { return r > unicode.MaxLatin1 }) {
+			continue
+		}
+		if searchStopWords[word] || slices.Contains(keywords, word) {
+			continue
+		}
+		if keywords = append(keywords, word); len(keywords) == maxSearchKeywords {
+			break
+		}
+	}
+	return keywords
+}
+
+// findRelatedWork returns the existing issues and pull requests of repo most
+// related to issue: candidates come from a keyword search and are ranked by
+// embedding similarity when the model supports it, by search relevance
+// otherwise. Errors are logged; the PRD is written without related work.
+func (b *Bot) findRelatedWork(ctx context.Context, client *github.Client, repo *github.Repository, issue *github.Issue) []relatedItem {
+	keywords := searchKeywords(issue.GetTitle())
+	if len(keywords) == 0 {
+		return nil
+	}
+	query := fmt.Sprintf("repo:%s %s", repo.GetFullName(), strings.Join(keywords, " OR "))
+	result, _, err := client.Search.Issues(ctx, query, &github.SearchOptions{ListOptions: github.ListOptions{PerPage: maxRelatedCandidates}})
+	if err != nil {
+		log.Printf("Error searching work related to issue #%d in %s: %v", issue.GetNumber(), repo.GetFullName(), err)
+		return nil
+	}
+	var candidates []relatedItem
+	for _, found := range result.Issues {
+		if found.GetNumber() == issue.GetNumber() {
+			continue
+		}
+		candidates = append(candidates, relatedItem{
+			Number:      found.GetNumber(),
+			Title:       found.GetTitle(),
+			URL:         found.GetHTMLURL(),
+			State:       found.GetState(),
+			PullRequest: found.IsPullRequest(),
+		})
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	embedder, ok := b.llm.(Embedder)
+	if !ok {
+		return candidates[:min(len(candidates), maxRelatedWork)]
+	}
+	texts := []string{issue.GetTitle() + "\n\n" + issue.GetBody()}
+	for _, c := range candidates {
+		texts = append(texts, c.Title)
+	}
+	vectors, err := embedder.EmbedTexts(ctx, texts)
+	if err != nil || len(vectors) != len(texts) {
+		log.Printf("Could not embed work related to issue #%d, ranking by search relevance: %v", issue.GetNumber(), err)
+		return candidates[:min(len(candidates), maxRelatedWork)]
+	}
+	for i := range candidates {
+		candidates[i].Score = cosineSimilarity(vectors[0], vectors[i+1])
+	}
+	candidates = slices.DeleteFunc(candidates, func(c relatedItem) bool { return c.Score < minRelatedSimilarity })
+	slices.SortStableFunc(candidates, func(a, b relatedItem) int { return cmp.Compare(b.Score, a.Score) })
+	return candidates[:min(len(candidates), maxRelatedWork)]
+}
+
+// cosineSimilarity returns the cosine of the angle between a and b, or 0
+// when either is empty or zero.
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// relatedWorkPrompt lists related work for the PRD prompt, or returns ""
+// when there is none.
+func relatedWorkPrompt(items []relatedItem) string {
+	if len(items) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("**Related Issues and Pull Requests:**\n")
+	for _, item := range items {
+		fmt.Fprintf(&b, "- #%d %s (%s)\n", item.Number, item.Title, item.kind())
+	}
+	b.WriteString("Don't re-specify functionality these already built or plan: refer to them by number and focus the requirements on what is new.")
+	return b.String()
+}
+
+// formatRelatedWork renders related work as the PRD section listing it, or
+// returns "" when there is none.
+func formatRelatedWork(items []relatedItem) string {
+	if len(items) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nThese existing issues and pull requests look related. Check them before building anything twice:\n", RelatedWorkIdentifier)
+	for _, item := range items {
+		title := strings.NewReplacer("[", "\\[", "]", "\\]").Replace(item.Title)
+		fmt.Fprintf(&b, "\n- [#%d %s](%s) (%s)", item.Number, title, item.URL, item.kind())
+	}
+	return b.String()
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func searchResult(number int, title, state string, pull bool) *github.Issue {
+	issue := &github.Issue{
+		Number:  github.Int(number),
+		Title:   github.String(title),
+		State:   github.String(state),
+		HTMLURL: github.String("https://github.com/acme/widgets/issues/" + strconv.Itoa(number)),
+	}
+	if pull {
+		issue.PullRequestLinks = &github.PullRequestLinks{URL: github.String("https://api.github.com/repos/acme/widgets/pulls/" + strconv.Itoa(number))}
+	}
+	return issue
+}
+
+func TestSearchKeywords(t *testing.T) {
+	tests := map[string][]string{
+		"Export reports as CSV":                  {"export", "reports", "csv"},
+		"Add support for the dark mode, dark UI": {"dark", "mode"},
+		"匯出報表":                                   {"匯出報表"},
+		"a b c":                                  nil,
+	}
+	for title, want := range tests {
+		if got := searchKeywords(title); !slices.Equal(got, want) {
+			t.Errorf("searchKeywords(%q) = %q, want %q", title, got, want)
+		}
+	}
+}
+
+func TestPRDListsRelatedWork(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.github.searchResults = []*github.Issue{
+		searchResult(42, "Export reports as CSV", "open", false),
+		searchResult(12, "Export reports as PDF", "open", false),
+		searchResult(30, "Dark mode", "open", false),
+		searchResult(15, "CSV export for [dashboards]", "closed", true),
+	}
+	env.gemini.embedOn("Export reports as CSV", 1, 0, 0)
+	env.gemini.embedOn("Export reports as PDF", 0.8, 0.3, 0)
+	env.gemini.embedOn("CSV export for [dashboards]", 1, 0.1, 0)
+	env.gemini.embedOn("Dark mode", 0, 1, 0)
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_en.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	if len(env.github.searches) != 1 || env.github.searches[0] != "repo:acme/widgets export OR reports OR csv" {
+		t.Errorf("unexpected searches %q", env.github.searches)
+	}
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "- #15 CSV export for [dashboards] (closed pull request)\n- #12 Export reports as PDF (open issue)\nDon't re-specify") {
+		t.Errorf("the PRD prompt should list the related work:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	doc, ok := parsePRDDocument(comments[0].GetBody())
+	if !ok {
+		t.Fatalf("unexpected PRD comment:\n%s", comments[0].GetBody())
+	}
+	want := RelatedWorkIdentifier + "\n\nThese existing issues and pull requests look related. Check them before building anything twice:\n\n" +
+		"- [#15 CSV export for \\[dashboards\\]](https://github.com/acme/widgets/issues/15) (closed pull request)\n" +
+		"- [#12 Export reports as PDF](https://github.com/acme/widgets/issues/12) (open issue)"
+	if doc.Related != want {
+		t.Errorf("Related = %q, want %q", doc.Related, want)
+	}
+	if strings.Contains(doc.English, RelatedWorkIdentifier) || strings.Contains(doc.Translated, RelatedWorkIdentifier) {
+		t.Errorf("the related work should be parsed out of the PRD: %+v", doc)
+	}
+}
+
+// plainGenerator is a Generator that can't embed texts.
+type plainGenerator struct{ Generator }
+
+func TestRelatedWorkWithoutEmbeddings(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.llm = plainGenerator{env.bot.llm}
+	env.github.searchResults = []*github.Issue{
+		searchResult(30, "Dark mode", "open", false),
+		searchResult(12, "Export reports as PDF", "closed", false),
+	}
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	repo := &github.Repository{Name: github.String("widgets"), FullName: github.String("acme/widgets"), Owner: &github.User{Login: github.String("acme")}}
+
+	client, _ := env.github.Client(7)
+
+	related := env.bot.findRelatedWork(context.Background(), client, repo, issue)
+
+	if len(related) != 2 || related[0].Number != 30 || related[1].kind() != "closed issue" {
+		t.Errorf("without embeddings, the search order should be kept: %+v", related)
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// bucketInstallations maps "owner/repo" to the installation the bot last
+	// received events for, so background jobs can act on the repository.
+	bucketInstallations = "installations"
+	// bucketReminders records the reminders sent, keyed by issueKey or
+	// pullKey and the reminder kind, so each is sent only once.
+	bucketReminders = "reminders"
+
+	defaultReminderInterval = time.Hour
+
+	reminderSubTasks = "sub_tasks"
+	reminderReview   = "review"
+
+	// Values of ReminderConfig.Notify.
+	notifyComment = "comment"
+	notifySlack   = "slack"
+	notifyBoth    = "both"
+)
+
+// ReminderConfig sets when the bot nudges about stalled work. A zero
+// threshold turns that reminder off; both are off by default.
+type ReminderConfig struct {
+	// SubTasksAfterDays reminds when a PRD has had no sub-tasks for that many days.
+	SubTasksAfterDays int `yaml:"sub_tasks_after_days"`
+	// ReviewAfterDays reminds when a bot pull request has had no review for that many days.
+	ReviewAfterDays int `yaml:"review_after_days"`
+	// Notify is "comment" (default), "slack" or "both". Slack needs
+	// SLACK_WEBHOOK_URL on the server.
+	Notify string `yaml:"notify"`
+}
+
+func (c *ReminderConfig) enabled() bool {
+	return c != nil && (c.SubTasksAfterDays > 0 || c.ReviewAfterDays > 0)
+}
+
+func (c *ReminderConfig) comment() bool { return c.Notify != notifySlack }
+func (c *ReminderConfig) slack() bool   { return c.Notify == notifySlack || c.Notify == notifyBoth }
+
+// rememberInstallation records the installation serving the repository.
+func (b *Bot) rememberInstallation(repo *github.Repository, installationID int64) {
+	key := repo.GetFullName()
+	var known int64
+	if ok, _ := b.store.Get(bucketInstallations, key, &known); ok && known == installationID {
+		return
+	}
+	if err := b.store.Put(bucketInstallations, key, installationID); err != nil {
+		log.Printf("Error recording the installation of %s: %v", key, err)
+	}
+}
+
+// reminderLoop sends the due reminders every interval until ctx is cancelled.
+func (b *Bot) reminderLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, interval)
+			b.sendReminders(roundCtx, time.Now())
+			cancel()
+		}
+	}
+}
+
+// stalledItem is a PRD or pull request a reminder may be due for.
+type stalledItem struct {
+	owner, repo string
+	number      int // the issue, or the pull request
+	kind        string
+	since       time.Time
+}
+
+// sendReminders reminds about PRDs without sub-tasks and bot pull requests
+// without reviews that are older than their repository's thresholds.
+func (b *Bot) sendReminders(ctx context.Context, now time.Time) {
+	var items []stalledItem
+	artifacts, err := b.store.List(bucketArtifacts)
+	if err != nil {
+		log.Printf("Error listing artifacts for reminders: %v", err)
+	}
+	for _, doc := range artifacts {
+		var artifact Artifact
+		if json.Unmarshal(doc, &artifact) != nil || artifact.Kind != ArtifactPRD {
+			continue
+		}
+		if subTasks, _ := b.loadArtifact(artifact.Owner, artifact.Repo, artifact.Issue, ArtifactSubTasks); subTasks == nil {
+			items = append(items, stalledItem{artifact.Owner, artifact.Repo, artifact.Issue, reminderSubTasks, artifact.CreatedAt})
+		}
+	}
+	pulls, err := b.store.List(bucketPulls)
+	if err != nil {
+		log.Printf("Error listing pull requests for reminders: %v", err)
+	}
+	for _, doc := range pulls {
+		var pr botPullRequest
+		if json.Unmarshal(doc, &pr) == nil {
+			items = append(items, stalledItem{pr.Owner, pr.Repo, pr.Number, reminderReview, pr.CreatedAt})
+		}
+	}
+
+	// Configuration is loaded once per repository and round.
+	configs := make(map[string]*RepoConfig)
+	clients := make(map[string]*github.Client)
+	for _, item := range items {
+		key := item.key()
+		if ok, _ := b.store.Get(bucketReminders, key, new(time.Time)); ok {
+			continue
+		}
+		fullName := item.owner + "/" + item.repo
+		cfg, seen := configs[fullName]
+		if !seen {
+			cfg, clients[fullName] = b.reminderConfig(ctx, item.owner, item.repo)
+			configs[fullName] = cfg
+		}
+		// Outside the repository's working hours, due reminders wait for
+		// the next working day.
+		if cfg == nil || !cfg.Reminders.enabled() || !cfg.Schedule.open(now) {
+			continue
+		}
+		days := cfg.Reminders.SubTasksAfterDays
+		if item.kind == reminderReview {
+			days = cfg.Reminders.ReviewAfterDays
+		}
+		if days <= 0 || cfg.Schedule.elapsedDays(item.since, now) < float64(days) {
+			continue
+		}
+		sent, err := b.remind(ctx, clients[fullName], cfg.Reminders, item, fmt.Sprintf("%d %s", days, cfg.Schedule.dayUnit()))
+		if err != nil {
+			log.Printf("Error sending the %s reminder for %s: %v", item.kind, key, err)
+			continue
+		}
+		// Items that no longer need a reminder are recorded too, so they
+		// aren't checked again.
+		if err := b.store.Put(bucketReminders, key, now); err != nil {
+			log.Printf("Error recording the reminder for %s: %v", key, err)
+		}
+		if sent {
+			log.Printf("Sent the %s reminder for %s.", item.kind, key)
+		}
+	}
+}
+
+func (i stalledItem) key() string {
+	if i.kind == reminderReview {
+		return pullKey(i.owner, i.repo, i.number) + "/" + i.kind
+	}
+	return issueKey(i.owner, i.repo, i.number) + "/" + i.kind
+}
+
+// installationClient returns a client of the installation serving
+// owner/repo, or nil when the bot doesn't know it or the repository
+// isn't allowlisted.
+func (b *Bot) installationClient(owner, repo string) *github.Client {
+	var installationID int64
+	if ok, err := b.store.Get(bucketInstallations, owner+"/"+repo, &installationID); err != nil || !ok {
+		return nil
+	}
+	if !b.allowed(installationID, owner+"/"+repo) {
+		return nil
+	}
+	client, err := b.clients.Client(installationID)
+	if err != nil {
+		log.Printf("Error creating GitHub client for %s/%s: %v", owner, repo, err)
+		return nil
+	}
+	return client
+}
+
+// reminderConfig returns the configuration and a client for owner/repo, or
+// nils when the bot doesn't know the repository's installation.
+func (b *Bot) reminderConfig(ctx context.Context, owner, repo string) (*RepoConfig, *github.Client) {
+	client := b.installationClient(owner, repo)
+	if client == nil {
+		return nil, nil
+	}
+	cfg, err := b.config.Load(ctx, client, owner, repo)
+	if err != nil {
+		log.Printf("Error loading config for reminders in %s/%s: %v", owner, repo, err)
+		return nil, nil
+	}
+	return cfg, client
+}
+
+// remind sends the reminder for item unless the work moved on in the
+// meantime: the issue or pull request was closed, or the pull request was
+// reviewed. age is the threshold the item passed, e.g. "3 days". It reports
+// whether a reminder was sent.
+func (b *Bot) remind(ctx context.Context, client *github.Client, cfg *ReminderConfig, item stalledItem, age string) (bool, error) {
+	var message, url string
+	switch item.kind {
+	case reminderSubTasks:
+		issue, _, err := client.Issues.Get(ctx, item.owner, item.repo, item.number)
+		if err != nil {
+			return false, err
+		}
+		if issue.GetState() != "open" {
+			return false, nil
+		}
+		url = issue.GetHTMLURL()
+		message = fmt.Sprintf("Friendly reminder: the PRD for this issue was posted more than %s ago and hasn't been broken down into sub-tasks yet. When it's ready, run `@%s %s`.", age, b.appName, CommandGenerateSubTask)
+	case reminderReview:
+		pr, _, err := client.PullRequests.Get(ctx, item.owner, item.repo, item.number)
+		if err != nil {
+			return false, err
+		}
+		if pr.GetState() != "open" {
+			return false, nil
+		}
+		reviews, _, err := client.PullRequests.ListReviews(ctx, item.owner, item.repo, item.number, nil)
+		if err != nil {
+			return false, err
+		}
+		if len(reviews) > 0 {
+			return false, nil
+		}
+		url = pr.GetHTMLURL()
+		message = fmt.Sprintf("Friendly reminder: this pull request has been waiting for a review for more than %s.", age)
+	}
+
+	if cfg.comment() {
+		if b.postComment(ctx, client, item.owner, item.repo, item.number, message) == nil {
+			return false, fmt.Errorf("posting the reminder comment failed")
+		}
+	}
+	if cfg.slack() {
+		if b.slack == nil {
+			log.Printf("Slack reminders are configured for %s/%s, but SLACK_WEBHOOK_URL is not set.", item.owner, item.repo)
+		} else if err := b.slack.notify(ctx, fmt.Sprintf("%s/%s#%d: %s <%s>", item.owner, item.repo, item.number, message, url)); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// slackNotifier posts messages to a Slack incoming webhook.
+type slackNotifier struct {
+	webhookURL string
+	client     *http.Client
+}
+
+func newSlackNotifier(webhookURL string) *slackNotifier {
+	return &slackNotifier{webhookURL: webhookURL, client: &http.Client{Transport: sharedTransport, Timeout: githubRequestTimeout}}
+}
+
+func (s *slackNotifier) notify(ctx context.Context, text string) error {
+	payload, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhookURL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to Slack: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("posting to Slack: %s", resp.Status)
+	}
+	return nil
+}
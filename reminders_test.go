@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestRemindersForStalledArtifacts(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "reminders:\n  sub_tasks_after_days: 3\n  review_after_days: 2\n  notify: both\n")
+	var slackMessages []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		slackMessages = append(slackMessages, msg.Text)
+	}))
+	defer slack.Close()
+	env.bot.slack = newSlackNotifier(slack.URL)
+	env.bot.rememberInstallation(&github.Repository{FullName: github.String("acme/widgets")}, 7)
+
+	now := time.Now()
+	env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.addIssue("acme", "widgets", 43, "Dark mode", "open")
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(42)}, PRDIdentifier, nil)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", &github.Issue{Number: github.Int(43)}, PRDIdentifier, nil)
+	env.bot.saveArtifact(ArtifactSubTasks, "acme", "widgets", &github.Issue{Number: github.Int(43)}, "- [ ] Task", nil)
+	env.github.addPull("acme", "widgets", "Unreviewed")
+	env.github.addPull("acme", "widgets", "Reviewed")
+	env.github.mu.Lock()
+	env.github.reviews[2] = 1
+	env.github.mu.Unlock()
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 1, Issue: 42, CreatedAt: now.Add(-72 * time.Hour)})
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 2, Issue: 42, CreatedAt: now.Add(-72 * time.Hour)})
+
+	// The PRD is only a moment old.
+	env.bot.sendReminders(context.Background(), now)
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 0 {
+		t.Fatalf("the PRD isn't stalled yet, got %+v", comments)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 1); len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "waiting for a review for more than 2 days") {
+		t.Errorf("expected a review reminder on the unreviewed pull request, got %+v", comments)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 2); len(comments) != 0 {
+		t.Errorf("the reviewed pull request shouldn't get a reminder, got %+v", comments)
+	}
+
+	later := now.Add(4 * 24 * time.Hour)
+	env.bot.sendReminders(context.Background(), later)
+	env.bot.sendReminders(context.Background(), later)
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "`@"+env.bot.appName+" need_sub_task`") {
+		t.Errorf("expected a single sub-task reminder, got %+v", comments)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 43); len(comments) != 0 {
+		t.Errorf("issue #43 has sub-tasks, got %+v", comments)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 1); len(comments) != 1 {
+		t.Errorf("reminders should be sent once, got %d", len(comments))
+	}
+	if len(slackMessages) != 2 || !strings.HasPrefix(slackMessages[1], "acme/widgets#42: ") {
+		t.Errorf("unexpected Slack messages %q", slackMessages)
+	}
+}
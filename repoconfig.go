@@ -0,0 +1,415 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// RepoConfigPath is the per-repository configuration file.
+	RepoConfigPath = ".agent-prd.yml"
+	// OrgConfigRepo is the repository holding organization-wide defaults.
+	OrgConfigRepo = ".agent-prd"
+
+	defaultConfigCacheTTL = 5 * time.Minute
+)
+
+// RepoConfig holds the behaviour settings for a repository. Fields left unset
+// in a repository's file inherit the organization defaults, which in turn
+// inherit the built-in defaults.
+type RepoConfig struct {
+	// AutoPRD controls whether a PRD is generated automatically for new issues.
+	AutoPRD *bool `yaml:"auto_prd"`
+	// Language forces the PRD translation language instead of detecting it from the issue.
+	Language string `yaml:"language"`
+	// DisabledCommands lists commands the bot refuses to run in the repository.
+	DisabledCommands []string `yaml:"disabled_commands"`
+	// PriorityFramework selects the need_priority framework: "rice" (default) or "wsjf".
+	PriorityFramework string `yaml:"priority_framework"`
+	// Onboarding controls whether assignees of sub-issues get an onboarding checklist.
+	Onboarding *bool `yaml:"onboarding"`
+	// ArchiveOnClose controls whether the artifacts of a closed issue are
+	// committed to docs/prd through a pull request.
+	ArchiveOnClose *bool `yaml:"archive_on_close"`
+	// PRSize is the size budget of implement_feature pull requests; larger
+	// changes are split. There is no budget by default.
+	PRSize *PRSizeBudget `yaml:"pr_size"`
+	// Stakeholders controls the reviewers suggested under new PRDs.
+	Stakeholders *StakeholderConfig `yaml:"stakeholders"`
+	// Reminders sets when stalled PRDs and pull requests get a reminder.
+	Reminders *ReminderConfig `yaml:"reminders"`
+	// Schedule is the team's time zone and working time, which reminders
+	// are sent within. They are sent at any time by default.
+	Schedule *ScheduleConfig `yaml:"schedule"`
+	// PlanPreview makes implement_feature post a plan for approval first.
+	PlanPreview *PlanPreviewConfig `yaml:"plan_preview"`
+	// AutoImplement starts implement_feature on assignment or labeling.
+	AutoImplement *AutoImplementConfig `yaml:"auto_implement"`
+	// Naming sets the branch names and commit messages of implement_feature.
+	Naming *NamingConfig `yaml:"naming"`
+	// SubTaskOwners suggests and optionally assigns owners of sub-tasks.
+	SubTaskOwners *SubTaskOwnersConfig `yaml:"sub_task_owners"`
+	// PRDLayout controls whether PRD comments are collapsed under their summary.
+	PRDLayout *PRDLayoutConfig `yaml:"prd_layout"`
+	// Tests selects the tests implement_feature runs before opening a pull
+	// request. None run by default.
+	Tests *TestsConfig `yaml:"tests"`
+	// Container builds the repository's Dockerfile or devcontainer to
+	// validate implement_feature changes. Off by default.
+	Container *ContainerConfig `yaml:"container"`
+	// GoChecks builds and vets Go changes of implement_feature. Off by
+	// default.
+	GoChecks *GoChecksConfig `yaml:"go_checks"`
+	// FixLoop bounds the edits fixing the failures of go_checks, tests and
+	// container.
+	FixLoop *FixLoopConfig `yaml:"fix_loop"`
+	// Format runs the repository's formatters on the files implement_feature
+	// edits before committing them. Off by default.
+	Format *FormatConfig `yaml:"format"`
+	// LongContext gives PRDs and implementations the whole repository in a
+	// long-context prompt when it fits. Off by default.
+	LongContext *LongContextConfig `yaml:"long_context"`
+	// EpicProgress keeps a progress comment on parent issues with
+	// sub-issues. Off by default.
+	EpicProgress *EpicProgressConfig `yaml:"epic_progress"`
+	// Compliance flags the repository as regulated, which enables
+	// need_compliance_check.
+	Compliance *ComplianceConfig `yaml:"compliance"`
+	// Execution selects where implement_feature clones and edits the code:
+	// on the bot's host (default) or on the repository's Actions runners.
+	Execution *ExecutionConfig `yaml:"execution"`
+	// PriorPRDs is how many similar past PRDs of the repository a new PRD
+	// stays consistent with; 3 by default, 0 turns it off.
+	PriorPRDs *int `yaml:"prior_prds"`
+	// SubTaskContext is how many existing files of the repository
+	// need_sub_task summarizes so sub-tasks name the code they change; 8 by
+	// default, 0 turns it off.
+	SubTaskContext *int `yaml:"sub_task_context"`
+	// MinimizeOutdated hides the bot's comments that a command's new
+	// comments supersede, such as old progress messages or a replaced PRD.
+	// On by default.
+	MinimizeOutdated *bool `yaml:"minimize_outdated"`
+	// LearnFromFeedback adds the latest written feedback on the
+	// repository's PRDs and sub-tasks to the prompts that write them. Off
+	// by default.
+	LearnFromFeedback *bool `yaml:"learn_from_feedback"`
+	// CloseOnMerge closes an issue, ticks its sub-task checklist and
+	// closes its sub-issues once the bot's pull requests for it are merged
+	// with every acceptance check verified. On by default.
+	CloseOnMerge *bool `yaml:"close_on_merge"`
+	// AllowedBots lists the bot accounts (e.g. "renovate[bot]") whose
+	// comments may run commands. Commands from other bots are ignored.
+	AllowedBots []string `yaml:"allowed_bots"`
+	// Pipelines are the named sequences of commands `run <name>` runs.
+	Pipelines map[string]*PipelineConfig `yaml:"pipelines"`
+	// FastPath gives small issues, such as typo fixes and dependency bumps,
+	// a mini PRD or sub-tasks only. Off by default.
+	FastPath *FastPathConfig `yaml:"fast_path"`
+	// ProductSignals posts a weekly digest of new issues grouped by theme
+	// to a discussion or Slack. Off by default.
+	ProductSignals *ProductSignalsConfig `yaml:"product_signals"`
+	// SystemPrompts overrides the built-in system prompts by prompt name
+	// (e.g. "need_prd" or "translate"). Each is a template that may use
+	// {{default}}, {{repo}} and {{language}}.
+	SystemPrompts map[string]string `yaml:"system_prompts"`
+}
+
+// defaultRepoConfig returns the built-in defaults.
+func defaultRepoConfig() *RepoConfig {
+	autoPRD := true
+	return &RepoConfig{AutoPRD: &autoPRD}
+}
+
+// merge overlays the fields set in override onto c.
+func (c *RepoConfig) merge(override *RepoConfig) {
+	if override == nil {
+		return
+	}
+	if override.AutoPRD != nil {
+		c.AutoPRD = override.AutoPRD
+	}
+	if override.Language != "" {
+		c.Language = override.Language
+	}
+	if override.DisabledCommands != nil {
+		c.DisabledCommands = override.DisabledCommands
+	}
+	if override.PriorityFramework != "" {
+		c.PriorityFramework = override.PriorityFramework
+	}
+	if override.Onboarding != nil {
+		c.Onboarding = override.Onboarding
+	}
+	if override.ArchiveOnClose != nil {
+		c.ArchiveOnClose = override.ArchiveOnClose
+	}
+	if override.PRSize != nil {
+		c.PRSize = override.PRSize
+	}
+	if override.Stakeholders != nil {
+		c.Stakeholders = override.Stakeholders
+	}
+	if override.Reminders != nil {
+		c.Reminders = override.Reminders
+	}
+	if override.Schedule != nil {
+		c.Schedule = override.Schedule
+	}
+	if override.PlanPreview != nil {
+		c.PlanPreview = override.PlanPreview
+	}
+	if override.AutoImplement != nil {
+		c.AutoImplement = override.AutoImplement
+	}
+	if override.Naming != nil {
+		c.Naming = override.Naming
+	}
+	if override.SubTaskOwners != nil {
+		c.SubTaskOwners = override.SubTaskOwners
+	}
+	if override.PRDLayout != nil {
+		c.PRDLayout = override.PRDLayout
+	}
+	if override.Tests != nil {
+		c.Tests = override.Tests
+	}
+	if override.Container != nil {
+		c.Container = override.Container
+	}
+	if override.GoChecks != nil {
+		c.GoChecks = override.GoChecks
+	}
+	if override.FixLoop != nil {
+		c.FixLoop = override.FixLoop
+	}
+	if override.Format != nil {
+		c.Format = override.Format
+	}
+	if override.LongContext != nil {
+		c.LongContext = override.LongContext
+	}
+	if override.EpicProgress != nil {
+		c.EpicProgress = override.EpicProgress
+	}
+	if override.Compliance != nil {
+		c.Compliance = override.Compliance
+	}
+	if override.Execution != nil {
+		c.Execution = override.Execution
+	}
+	if override.PriorPRDs != nil {
+		c.PriorPRDs = override.PriorPRDs
+	}
+	if override.SubTaskContext != nil {
+		c.SubTaskContext = override.SubTaskContext
+	}
+	if override.MinimizeOutdated != nil {
+		c.MinimizeOutdated = override.MinimizeOutdated
+	}
+	if override.LearnFromFeedback != nil {
+		c.LearnFromFeedback = override.LearnFromFeedback
+	}
+	if override.CloseOnMerge != nil {
+		c.CloseOnMerge = override.CloseOnMerge
+	}
+	if override.AllowedBots != nil {
+		c.AllowedBots = override.AllowedBots
+	}
+	if override.FastPath != nil {
+		c.FastPath = override.FastPath
+	}
+	if override.ProductSignals != nil {
+		c.ProductSignals = override.ProductSignals
+	}
+	// Like prompts, pipelines merge one by one.
+	for name, pipeline := range override.Pipelines {
+		if c.Pipelines == nil {
+			c.Pipelines = make(map[string]*PipelineConfig)
+		}
+		c.Pipelines[name] = pipeline
+	}
+	// Prompts merge one by one, so a repository can replace a single prompt
+	// and keep the organization's others.
+	for name, prompt := range override.SystemPrompts {
+		if c.SystemPrompts == nil {
+			c.SystemPrompts = make(map[string]string)
+		}
+		c.SystemPrompts[name] = prompt
+	}
+}
+
+// AutoPRDEnabled reports whether new issues should get a PRD automatically.
+func (c *RepoConfig) AutoPRDEnabled() bool {
+	return c.AutoPRD == nil || *c.AutoPRD
+}
+
+// OnboardingEnabled reports whether sub-issue assignees should get an onboarding checklist.
+func (c *RepoConfig) OnboardingEnabled() bool {
+	return c.Onboarding == nil || *c.Onboarding
+}
+
+// ArchiveOnCloseEnabled reports whether closed issues should have their
+// artifacts archived. It is off by default.
+func (c *RepoConfig) ArchiveOnCloseEnabled() bool {
+	return c.ArchiveOnClose != nil && *c.ArchiveOnClose
+}
+
+// MinimizeOutdatedEnabled reports whether superseded bot comments are
+// hidden automatically.
+func (c *RepoConfig) MinimizeOutdatedEnabled() bool {
+	return c.MinimizeOutdated == nil || *c.MinimizeOutdated
+}
+
+// LearnFromFeedbackEnabled reports whether prompts include the
+// repository's feedback on earlier artifacts.
+func (c *RepoConfig) LearnFromFeedbackEnabled() bool {
+	return c.LearnFromFeedback != nil && *c.LearnFromFeedback
+}
+
+// CloseOnMergeEnabled reports whether issues are closed once their bot
+// pull requests are merged.
+func (c *RepoConfig) CloseOnMergeEnabled() bool {
+	return c.CloseOnMerge == nil || *c.CloseOnMerge
+}
+
+// SenderAllowed reports whether sender may run commands: any user, but
+// only the bots listed in allowed_bots.
+func (c *RepoConfig) SenderAllowed(sender *github.User) bool {
+	if sender.GetType() != "Bot" {
+		return true
+	}
+	return slices.ContainsFunc(c.AllowedBots, func(login string) bool { return strings.EqualFold(login, sender.GetLogin()) })
+}
+
+// PriorPRDCount returns how many prior PRDs a new PRD is checked against.
+func (c *RepoConfig) PriorPRDCount() int {
+	if c.PriorPRDs == nil {
+		return defaultPriorPRDs
+	}
+	return max(*c.PriorPRDs, 0)
+}
+
+// SubTaskContextFiles returns how many existing files sub-tasks build on.
+func (c *RepoConfig) SubTaskContextFiles() int {
+	if c.SubTaskContext == nil {
+		return defaultSubTaskContextFiles
+	}
+	return max(*c.SubTaskContext, 0)
+}
+
+// CommandEnabled reports whether command may run in the repository.
+func (c *RepoConfig) CommandEnabled(command string) bool {
+	return !slices.Contains(c.DisabledCommands, command)
+}
+
+// ConfigLoader resolves repository configuration from the organization config
+// repository and the repository's own file, caching the raw files for a while
+// so every webhook does not cost two extra API calls.
+type ConfigLoader struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mu    sync.Mutex
+	cache map[string]cachedConfigFile
+}
+
+type cachedConfigFile struct {
+	config  *RepoConfig // nil when the file does not exist
+	expires time.Time
+}
+
+// NewConfigLoader creates a loader that caches configuration files for ttl.
+func NewConfigLoader(ttl time.Duration) *ConfigLoader {
+	return &ConfigLoader{
+		ttl:   ttl,
+		now:   time.Now,
+		cache: make(map[string]cachedConfigFile),
+	}
+}
+
+// Load returns the effective configuration for owner/repo.
+func (l *ConfigLoader) Load(ctx context.Context, client *github.Client, owner, repo string) (*RepoConfig, error) {
+	cfg := defaultRepoConfig()
+
+	// A broken organization file mustn't hide the repository's own.
+	if orgConfig, err := l.file(ctx, client, owner, OrgConfigRepo); err != nil {
+		log.Printf("Error loading organization config for %s, using the repository config alone: %v", owner, err)
+	} else {
+		cfg.merge(orgConfig)
+	}
+
+	repoConfig, err := l.file(ctx, client, owner, repo)
+	if err != nil {
+		return cfg, fmt.Errorf("loading repository config for %s/%s: %w", owner, repo, err)
+	}
+	cfg.merge(repoConfig)
+	return cfg, nil
+}
+
+// Invalidate drops the cached configuration file of owner/repo.
+func (l *ConfigLoader) Invalidate(owner, repo string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.cache, owner+"/"+repo)
+}
+
+func (l *ConfigLoader) file(ctx context.Context, client *github.Client, owner, repo string) (*RepoConfig, error) {
+	key := owner + "/" + repo
+	l.mu.Lock()
+	cached, ok := l.cache[key]
+	l.mu.Unlock()
+	if ok && l.now().Before(cached.expires) {
+		return cached.config, nil
+	}
+
+	cfg, err := fetchRepoConfig(ctx, client, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	l.cache[key] = cachedConfigFile{config: cfg, expires: l.now().Add(l.ttl)}
+	l.mu.Unlock()
+	return cfg, nil
+}
+
+// fetchRepoConfig reads and parses RepoConfigPath from owner/repo. A missing
+// repository or file yields a nil config and no error.
+func fetchRepoConfig(ctx context.Context, client *github.Client, owner, repo string) (*RepoConfig, error) {
+	file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, RepoConfigPath, nil)
+	if err != nil {
+		var ghErr *github.ErrorResponse
+		if errors.As(err, &ghErr) && ghErr.Response != nil && ghErr.Response.StatusCode == http.StatusNotFound {
+			return nil, nil
+		}
+		return nil, err
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", RepoConfigPath, err)
+	}
+	var cfg RepoConfig
+	if err := yaml.Unmarshal([]byte(content), &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", RepoConfigPath, err)
+	}
+	return &cfg, nil
+}
+
+// repoConfig loads the effective configuration for repo, falling back to the
+// defaults (and logging) when it cannot be read.
+func (b *Bot) repoConfig(ctx context.Context, client *github.Client, repo *github.Repository) *RepoConfig {
+	cfg, err := b.config.Load(ctx, client, repo.GetOwner().GetLogin(), repo.GetName())
+	if err != nil {
+		log.Printf("Error loading config, using defaults where needed: %v", err)
+	}
+	return cfg
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfigLoaderMergesOrgAndRepo(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addFile("acme", OrgConfigRepo, RepoConfigPath, "auto_prd: false\nlanguage: Japanese\ndisabled_commands: [implement_feature]\n")
+	gh.addFile("acme", "widgets", RepoConfigPath, "language: Traditional Chinese\n")
+	client, _ := gh.Client(0)
+
+	cfg, err := NewConfigLoader(time.Minute).Load(context.Background(), client, "acme", "widgets")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.AutoPRDEnabled() {
+		t.Error("auto_prd should be inherited from the organization config")
+	}
+	if cfg.Language != "Traditional Chinese" {
+		t.Errorf("Language = %q, want the repository override", cfg.Language)
+	}
+	if cfg.CommandEnabled(CommandImplementFeature) {
+		t.Error("implement_feature should be disabled by the organization config")
+	}
+}
+
+func TestConfigLoaderKeepsRepoConfigWhenOrgConfigFails(t *testing.T) {
+	gh := newFakeGitHub(t)
+	gh.addFile("acme", OrgConfigRepo, RepoConfigPath, "auto_prd: [not a bool\n")
+	gh.addFile("acme", "widgets", RepoConfigPath, "disabled_commands: [implement_feature]\n")
+	client, _ := gh.Client(0)
+
+	cfg, err := NewConfigLoader(time.Minute).Load(context.Background(), client, "acme", "widgets")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.CommandEnabled(CommandImplementFeature) {
+		t.Error("the repository config should apply when the organization config is broken")
+	}
+}
+
+func TestConfigLoaderDefaultsAndCache(t *testing.T) {
+	gh := newFakeGitHub(t)
+	client, _ := gh.Client(0)
+	loader := NewConfigLoader(time.Minute)
+	now := time.Now()
+	loader.now = func() time.Time { return now }
+
+	cfg, err := loader.Load(context.Background(), client, "acme", "widgets")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !cfg.AutoPRDEnabled() || !cfg.CommandEnabled(CommandGeneratePRD) {
+		t.Errorf("unexpected defaults: %+v", cfg)
+	}
+
+	gh.addFile("acme", "widgets", RepoConfigPath, "auto_prd: false\n")
+	if cfg, _ := loader.Load(context.Background(), client, "acme", "widgets"); !cfg.AutoPRDEnabled() {
+		t.Error("expected the cached (missing) file to be used before expiry")
+	}
+	now = now.Add(2 * time.Minute)
+	if cfg, _ := loader.Load(context.Background(), client, "acme", "widgets"); cfg.AutoPRDEnabled() {
+		t.Error("expected the file to be re-read after expiry")
+	}
+}
+
+func TestDisabledCommandIsRefused(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "disabled_commands: [implement_feature]\n")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "is disabled for this repository") {
+		t.Fatalf("expected a disabled-command reply, got %+v", comments)
+	}
+	if executed := env.runner.executed(); len(executed) != 0 {
+		t.Errorf("expected no commands to run, got %v", executed)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// readmeNames are the names of a repository's main README, most preferred
+// first, compared case-insensitively.
+var readmeNames = []string{"README.md", "README.rst", "README.txt", "README"}
+
+// docsIndexPath is project documentation merged into the README context.
+const docsIndexPath = "docs/index.md"
+
+// localizedReadmePattern matches translated READMEs such as README.zh-TW.md
+// or README_ja.md, capturing the language tag.
+var localizedReadmePattern = regexp.MustCompile(`(?i)^README[._-]([a-z]{2,3}(?:[-_][a-z0-9]{2,4})?)\.(?:md|rst|txt)$`)
+
+// contextSource is a file the repository context is built from.
+type contextSource struct {
+	Path    string
+	Content string
+}
+
+// resolveRepoContext returns what prompts learn about the repository: its
+// README, merged with docs/index.md when the repository has one. Without a
+// README.md it falls back to README.rst, README.txt or README, then to a
+// translated README. A repository without any of them gets a note instead of
+// a failure.
+func (b *Bot) resolveRepoContext(ctx context.Context, client *github.Client, repo *github.Repository) (string, error) {
+	paths, err := contextSourcePaths(ctx, client, repo)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrReadmeUnavailable, err)
+	}
+	var sources []contextSource
+	for _, p := range paths {
+		source, err := fetchContextSource(ctx, client, repo, p)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrReadmeUnavailable, err)
+		}
+		sources = append(sources, source)
+	}
+	if len(sources) == 0 {
+		log.Printf("%s has no README or docs/index.md. Generating without repository context.", repo.GetFullName())
+		return "(The repository has no README. Rely on the issue for context.)", nil
+	}
+	return b.condenseReadme(ctx, repo, mergeContextSources(sources)), nil
+}
+
+// contextSourcePaths selects the context files from the repository's root
+// and docs directories.
+func contextSourcePaths(ctx context.Context, client *github.Client, repo *github.Repository) ([]string, error) {
+	root, err := listDirectory(ctx, client, repo, "")
+	if err != nil {
+		return nil, err
+	}
+	paths := selectReadme(root)
+	docs, err := listDirectory(ctx, client, repo, path.Dir(docsIndexPath))
+	if err != nil {
+		return nil, err
+	}
+	if slices.Contains(docs, path.Base(docsIndexPath)) {
+		paths = append(paths, docsIndexPath)
+	}
+	return paths, nil
+}
+
+// selectReadme picks the README among the file names of the root directory:
+// the most preferred main README, or else a translated one, English first.
+func selectReadme(names []string) []string {
+	for _, want := range readmeNames {
+		for _, name := range names {
+			if strings.EqualFold(name, want) {
+				return []string{name}
+			}
+		}
+	}
+	var localized []string
+	for _, name := range names {
+		if m := localizedReadmePattern.FindStringSubmatch(name); m != nil {
+			if tag := strings.ToLower(m[1]); tag == "en" || strings.HasPrefix(tag, "en-") || strings.HasPrefix(tag, "en_") {
+				return []string{name}
+			}
+			localized = append(localized, name)
+		}
+	}
+	if len(localized) == 0 {
+		return nil
+	}
+	slices.Sort(localized)
+	return localized[:1]
+}
+
+// listDirectory returns the names of the files in dir, or none when it
+// doesn't exist.
+func listDirectory(ctx context.Context, client *github.Client, repo *github.Repository, dir string) ([]string, error) {
+	_, entries, resp, err := client.Repositories.GetContents(ctx, repo.GetOwner().GetLogin(), repo.GetName(), dir, nil)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if entry.GetType() == "file" {
+			names = append(names, entry.GetName())
+		}
+	}
+	return names, nil
+}
+
+// fetchContextSource reads the context file at p. A file too large to use
+// is replaced by a note saying so.
+func fetchContextSource(ctx context.Context, client *github.Client, repo *github.Repository, p string) (contextSource, error) {
+	file, _, _, err := client.Repositories.GetContents(ctx, repo.GetOwner().GetLogin(), repo.GetName(), p, nil)
+	if err != nil {
+		return contextSource{}, err
+	}
+	if file == nil {
+		return contextSource{}, errors.New(p + " is not a file")
+	}
+	if file.GetSize() > maxReadmeSummaryBytes {
+		return tooLargeSource(repo, p, file.GetSize()), nil
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return contextSource{}, err
+	}
+	if len(content) > maxReadmeSummaryBytes {
+		return tooLargeSource(repo, p, len(content)), nil
+	}
+	return contextSource{Path: p, Content: content}, nil
+}
+
+func tooLargeSource(repo *github.Repository, p string, size int) contextSource {
+	log.Printf("%s of %s is %d KB, above the %d KB limit. Leaving it out.", p, repo.GetFullName(), size/1024, maxReadmeSummaryBytes/1024)
+	return contextSource{Path: p, Content: fmt.Sprintf("(%s is %d KB, too large to include.)", p, size/1024)}
+}
+
+// mergeContextSources joins the sources, each under its path when there are
+// several.
+func mergeContextSources(sources []contextSource) string {
+	if len(sources) == 1 {
+		return sources[0].Content
+	}
+	parts := make([]string, len(sources))
+	for i, source := range sources {
+		parts[i] = fmt.Sprintf("--- %s ---\n%s", source.Path, strings.TrimSpace(source.Content))
+	}
+	return strings.Join(parts, "\n\n")
+}
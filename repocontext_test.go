@@ -0,0 +1,60 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSelectReadme(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  []string
+	}{
+		{[]string{"go.mod", "README.rst", "readme.md"}, []string{"readme.md"}},
+		{[]string{"README.txt", "README.rst"}, []string{"README.rst"}},
+		{[]string{"README.zh-TW.md", "README.ja.md", "main.go"}, []string{"README.ja.md"}},
+		{[]string{"README.zh-TW.md", "README_en.md"}, []string{"README_en.md"}},
+		{[]string{"README.template", "main.go"}, nil},
+	}
+	for _, tt := range tests {
+		if got := selectReadme(tt.names); !slices.Equal(got, tt.want) {
+			t.Errorf("selectReadme(%v) = %v, want %v", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestRepoContextFallsBackToOtherSources(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.zh-TW.md", "# Widgets\n報表工具。")
+	env.github.addFile("acme", "widgets", "docs/index.md", "Widgets exports reports.")
+	env.github.addFile("acme", "widgets", "docs/setup.md", "Run make.")
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.comment(t, "@prd-bot need_prd")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "--- README.zh-TW.md ---\n# Widgets\n報表工具。\n\n--- docs/index.md ---\nWidgets exports reports.") || strings.Contains(prompt, "Run make.") {
+		t.Errorf("the PRD prompt should merge the translated README and docs/index.md:\n%s", prompt)
+	}
+}
+
+func TestRepoContextWithoutReadme(t *testing.T) {
+	env := newTestEnv(t)
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", string(loadFixture(t, "gemini/prd_translated.md")))
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", string(loadFixture(t, "gemini/prd_en.md")))
+
+	env.comment(t, "@prd-bot need_prd")
+
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "(The repository has no README.") {
+		t.Errorf("the PRD prompt should note the missing README:\n%s", prompt)
+	}
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 || !strings.HasPrefix(comments[0].GetBody(), PRDIdentifier) {
+		t.Error("a missing README shouldn't stop the PRD")
+	}
+}
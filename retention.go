@@ -0,0 +1,282 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandPurgeData deletes the data stored for an issue or repository.
+	CommandPurgeData = "purge_data"
+
+	retentionCheckInterval = time.Hour
+)
+
+// dataBuckets are the buckets holding data about repositories and issues,
+// which retention and purging apply to.
+var dataBuckets = []string{
+	bucketArtifacts, bucketPulls, bucketPlans, bucketReminders, bucketWizard,
+	bucketPriority, bucketOnboarding, bucketArchives, bucketBacklog, bucketInstallations, bucketUsage,
+	bucketJobHistory, bucketPRDEmbeddings, bucketPRDVersions, bucketFeedback, bucketPipelines, bucketSignals,
+	bucketTaskOwners, bucketCompliance, bucketDeadLetters,
+}
+
+// DataConfig controls what the bot keeps in its store and for how long.
+// Prompts and diffs are never stored.
+type DataConfig struct {
+	// StoreDocuments keeps the text of generated documents (model
+	// responses). When false only their metadata and comment links are kept,
+	// so exports and archives are empty. Defaults to true.
+	StoreDocuments *bool `yaml:"store_documents"`
+	// StorePlans keeps approved implementation plans with the bot's pull
+	// requests, to re-apply them on conflicts. Defaults to true.
+	StorePlans *bool `yaml:"store_plans"`
+	// Retention maps a bucket to how long its documents are kept, e.g.
+	// "artifacts: 90d" or "pulls: 720h". Buckets without an entry are kept.
+	Retention map[string]string `yaml:"retention"`
+}
+
+func (c DataConfig) storeDocuments() bool { return c.StoreDocuments == nil || *c.StoreDocuments }
+func (c DataConfig) storePlans() bool     { return c.StorePlans == nil || *c.StorePlans }
+
+// parseRetention parses a retention period: a Go duration or a number of days such as "90d".
+func parseRetention(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n <= 0 {
+			return 0, fmt.Errorf("invalid retention %q", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid retention %q", s)
+	}
+	return d, nil
+}
+
+// validate checks the retention entries.
+func (c DataConfig) validate() error {
+	for bucket, period := range c.Retention {
+		if !slices.Contains(dataBuckets, bucket) {
+			return fmt.Errorf("data.retention: unknown bucket %q, expected one of %s", bucket, strings.Join(dataBuckets, ", "))
+		}
+		if _, err := parseRetention(period); err != nil {
+			return fmt.Errorf("data.retention.%s: %w", bucket, err)
+		}
+	}
+	return nil
+}
+
+// documentTime returns when a stored document was created: its created_at
+// field, when a dead letter failed or was queued, or the document itself
+// when it is a timestamp.
+func documentTime(doc json.RawMessage) (time.Time, bool) {
+	var t time.Time
+	if json.Unmarshal(doc, &t) == nil {
+		return t, true
+	}
+	var fields struct {
+		CreatedAt time.Time `json:"created_at"`
+		Failed    time.Time `json:"failed"`
+		Queued    time.Time `json:"queued"`
+	}
+	if json.Unmarshal(doc, &fields) != nil {
+		return time.Time{}, false
+	}
+	for _, t := range []time.Time{fields.CreatedAt, fields.Failed, fields.Queued} {
+		if !t.IsZero() {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// retentionLoop purges expired documents every interval until ctx is cancelled.
+func (b *Bot) retentionLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.purgeExpired(time.Now())
+		}
+	}
+}
+
+// purgeExpired deletes the documents older than their bucket's retention.
+// Documents without a creation time are kept.
+func (b *Bot) purgeExpired(now time.Time) int {
+	purged := 0
+	for bucket, period := range b.serverConfig().Data.Retention {
+		retention, err := parseRetention(period)
+		if err != nil {
+			continue
+		}
+		docs, err := b.store.List(bucket)
+		if err != nil {
+			log.Printf("Error listing %s for retention: %v", bucket, err)
+			continue
+		}
+		for key, doc := range docs {
+			if created, ok := documentTime(doc); ok && now.Sub(created) > retention {
+				if err := b.store.Delete(bucket, key); err != nil {
+					log.Printf("Error purging %s/%s: %v", bucket, key, err)
+					continue
+				}
+				purged++
+			}
+		}
+	}
+	if purged > 0 {
+		log.Printf("Purged %d documents past their retention.", purged)
+	}
+	return purged
+}
+
+// purgeData deletes every document about owner/repo, or only about the
+// issue when issueNum is not zero, including the issue's pull requests.
+func (b *Bot) purgeData(owner, repo string, issueNum int) (int, error) {
+	repoKey := owner + "/" + repo
+	var prefixes []string // a key matches when it equals a prefix or continues it with a separator
+	if issueNum == 0 {
+		prefixes = []string{repoKey}
+	} else {
+		prefixes = []string{issueKey(owner, repo, issueNum)}
+		pulls, err := b.store.List(bucketPulls)
+		if err != nil {
+			return 0, err
+		}
+		for _, doc := range pulls {
+			var pr botPullRequest
+			if json.Unmarshal(doc, &pr) == nil && pr.Owner == owner && pr.Repo == repo && pr.Issue == issueNum {
+				prefixes = append(prefixes, pullKey(owner, repo, pr.Number))
+			}
+		}
+	}
+	matches := func(key string) bool {
+		for _, prefix := range prefixes {
+			rest, ok := strings.CutPrefix(key, prefix)
+			if ok && (rest == "" || strings.ContainsAny(rest[:1], "#!/@")) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Dead letters are keyed by delivery ID, so they match by the
+	// repository their payload names.
+	matchesDoc := func(bucket, key string, doc json.RawMessage) bool {
+		if bucket != bucketDeadLetters {
+			return matches(key)
+		}
+		var job queuedJob
+		if json.Unmarshal(doc, &job) != nil {
+			return false
+		}
+		target, number := deadLetterTarget(job.Payload)
+		if target != repoKey {
+			return false
+		}
+		return issueNum == 0 || slices.ContainsFunc(prefixes, func(prefix string) bool {
+			return prefix == issueKey(owner, repo, number) || prefix == pullKey(owner, repo, number)
+		})
+	}
+
+	purged := 0
+	for _, bucket := range dataBuckets {
+		docs, err := b.store.List(bucket)
+		if err != nil {
+			return purged, err
+		}
+		for key, doc := range docs {
+			if !matchesDoc(bucket, key, doc) {
+				continue
+			}
+			if err := b.store.Delete(bucket, key); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// deadLetterTarget returns the repository, as "owner/repo", and the issue or
+// pull request number a webhook payload is about, or zero when it names
+// none.
+func deadLetterTarget(payload json.RawMessage) (string, int) {
+	var target struct {
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+		Issue struct {
+			Number int `json:"number"`
+		} `json:"issue"`
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+	}
+	if json.Unmarshal(payload, &target) != nil {
+		return "", 0
+	}
+	return target.Repository.FullName, cmp.Or(target.Issue.Number, target.PullRequest.Number)
+}
+
+// processPurgeData deletes the stored data of the issue, or of the whole
+// repository with `purge_data repo`. Only maintainers may run it.
+func (b *Bot) processPurgeData(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	if !b.requireMaintainer(ctx, client, repo, issueNum, CommandPurgeData) {
+		return
+	}
+	scope, target := issueNum, fmt.Sprintf("issue #%d", issueNum)
+	if len(args) > 0 && args[0] == "repo" {
+		scope, target = 0, fmt.Sprintf("`%s/%s`", repoOwner, repoName)
+	}
+	purged, err := b.purgeData(repoOwner, repoName, scope)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "purge the stored data", "Could not delete every stored document", err)
+		return
+	}
+	log.Printf("Purged %d documents of %s in %s/%s at the request of %s.", purged, target, repoOwner, repoName, commandSender(ctx).GetLogin())
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've deleted the %d documents I stored about %s. Comments and pull requests on GitHub are not affected.", purged, target))
+}
+
+// handleDataPurge serves DELETE /repos/{owner}/{repo}/data, deleting the
+// stored data of the repository, or of one issue with ?issue=N.
+func (b *Bot) handleDataPurge(w http.ResponseWriter, r *http.Request) {
+	if !b.authorizeAPI(w, r) {
+		return
+	}
+	issueNum := 0
+	if v := r.URL.Query().Get("issue"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid issue number", http.StatusBadRequest)
+			return
+		}
+		issueNum = n
+	}
+	purged, err := b.purgeData(r.PathValue("owner"), r.PathValue("repo"), issueNum)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"purged": purged}); err != nil {
+		log.Printf("Error encoding purge response: %v", err)
+	}
+}
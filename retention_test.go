@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestParseRetention(t *testing.T) {
+	for in, want := range map[string]time.Duration{"90d": 90 * 24 * time.Hour, "36h": 36 * time.Hour} {
+		if got, err := parseRetention(in); err != nil || got != want {
+			t.Errorf("parseRetention(%q) = %v, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "d", "-1d", "0h", "soon"} {
+		if _, err := parseRetention(in); err == nil {
+			t.Errorf("parseRetention(%q) succeeded", in)
+		}
+	}
+}
+
+func TestServerConfigValidatesRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.yaml")
+	for content, valid := range map[string]bool{
+		"data:\n  retention:\n    artifacts: 90d\n    reminders: 720h\n": true,
+		"data:\n  retention:\n    prompts: 30d\n":                        false,
+		"data:\n  retention:\n    pulls: forever\n":                      false,
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadServerConfig(path); (err == nil) != valid {
+			t.Errorf("loadServerConfig(%q) error = %v", content, err)
+		}
+	}
+}
+
+func TestPurgeExpired(t *testing.T) {
+	env := newTestEnv(t)
+	now := time.Now()
+	env.bot.applyServerConfig(&ServerConfig{Data: DataConfig{Retention: map[string]string{bucketArtifacts: "30d", bucketReminders: "30d", bucketDeadLetters: "30d"}}})
+	put := func(bucket, key string, doc any) {
+		t.Helper()
+		if err := env.bot.store.Put(bucket, key, doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	put(bucketArtifacts, "acme/widgets#1/prd", &Artifact{Kind: ArtifactPRD, CreatedAt: now.Add(-40 * 24 * time.Hour)})
+	put(bucketArtifacts, "acme/widgets#2/prd", &Artifact{Kind: ArtifactPRD, CreatedAt: now.Add(-10 * 24 * time.Hour)})
+	put(bucketReminders, "acme/widgets#1/sub_tasks", now.Add(-31*24*time.Hour))
+	put(bucketPulls, "acme/widgets!7", &botPullRequest{Owner: "acme", Repo: "widgets", Number: 7, CreatedAt: now.Add(-400 * 24 * time.Hour)})
+	put(bucketDeadLetters, "delivery-1", &queuedJob{ID: "delivery-1", Queued: now.Add(-41 * 24 * time.Hour), Failed: now.Add(-40 * 24 * time.Hour)})
+	put(bucketDeadLetters, "delivery-2", &queuedJob{ID: "delivery-2", Queued: now.Add(-41 * 24 * time.Hour), Failed: now.Add(-time.Hour)})
+
+	if purged := env.bot.purgeExpired(now); purged != 3 {
+		t.Errorf("purged %d documents, want 3", purged)
+	}
+	for bucket, keys := range map[string][]string{bucketArtifacts: {"acme/widgets#2/prd"}, bucketReminders: nil, bucketPulls: {"acme/widgets!7"}, bucketDeadLetters: {"delivery-2"}} {
+		docs, _ := env.bot.store.List(bucket)
+		if len(docs) != len(keys) {
+			t.Errorf("%s holds %d documents, want %v", bucket, len(docs), keys)
+		}
+		for _, key := range keys {
+			if _, ok := docs[key]; !ok {
+				t.Errorf("%s/%s was purged", bucket, key)
+			}
+		}
+	}
+}
+
+func TestDataConfigDisablesStorage(t *testing.T) {
+	env := newTestEnv(t)
+	off := false
+	env.bot.applyServerConfig(&ServerConfig{Data: DataConfig{StoreDocuments: &off, StorePlans: &off}})
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("CSV export")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, "proprietary details", &github.IssueComment{ID: github.Int64(9)})
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 7, Issue: 42, Plan: "1. Change report.go"})
+
+	artifact, err := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD)
+	if err != nil || artifact == nil || artifact.Markdown != "" || artifact.CommentID != 9 {
+		t.Errorf("stored artifact = %+v (%v), want metadata only", artifact, err)
+	}
+	if pr := env.bot.lookupPullRequest("acme", "widgets", 7); pr == nil || pr.Plan != "" {
+		t.Errorf("stored pull request = %+v, want no plan", pr)
+	}
+}
+
+// seedIssueData stores documents about issues #42 and #43 of acme/widgets,
+// one bot pull request for #42, and data of another repository.
+func seedIssueData(t *testing.T, bot *Bot) {
+	t.Helper()
+	docs := []struct {
+		bucket, key string
+		doc         any
+	}{
+		{bucketArtifacts, "acme/widgets#42/prd", &Artifact{Kind: ArtifactPRD}},
+		{bucketArtifacts, "acme/widgets#420/prd", &Artifact{Kind: ArtifactPRD}},
+		{bucketArtifacts, "acme/widgets#43/prd", &Artifact{Kind: ArtifactPRD}},
+		{bucketPriority, "acme/widgets#42", &PriorityScore{Issue: 42}},
+		{bucketTaskOwners, "acme/widgets#42", []taskOwners{{Task: "Add the CSV encoder"}}},
+		{bucketCompliance, "acme/widgets#42", complianceIssue{Number: 44}},
+		{bucketDeadLetters, "delivery-42", &queuedJob{ID: "delivery-42", Event: "issue_comment", Payload: json.RawMessage(`{"repository":{"full_name":"acme/widgets"},"issue":{"number":42}}`)}},
+		{bucketDeadLetters, "delivery-43", &queuedJob{ID: "delivery-43", Event: "issues", Payload: json.RawMessage(`{"repository":{"full_name":"acme/widgets"},"issue":{"number":43}}`)}},
+		{bucketPulls, "acme/widgets!7", &botPullRequest{Owner: "acme", Repo: "widgets", Number: 7, Issue: 42}},
+		{bucketReminders, "acme/widgets!7/review", time.Now()},
+		{bucketInstallations, "acme/widgets", 99},
+		{bucketArtifacts, "acme/widgets-ui#42/prd", &Artifact{Kind: ArtifactPRD}},
+	}
+	for _, d := range docs {
+		if err := bot.store.Put(d.bucket, d.key, d.doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storedKeys(t *testing.T, bot *Bot) []string {
+	t.Helper()
+	var keys []string
+	for _, bucket := range dataBuckets {
+		docs, err := bot.store.List(bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for key := range docs {
+			keys = append(keys, bucket+":"+key)
+		}
+	}
+	return keys
+}
+
+func TestPurgeDataCommand(t *testing.T) {
+	env := newTestEnv(t)
+	seedIssueData(t, env.bot)
+
+	env.comment(t, "@prd-bot purge_data")
+	if comments := env.github.issueComments("acme", "widgets", 42); len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "only repository maintainers and admins") {
+		t.Fatalf("expected a permission refusal, got %+v", comments)
+	}
+
+	env.github.setRole("alice", "maintain")
+	env.comment(t, "@prd-bot purge_data")
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "deleted the 7 documents") {
+		t.Errorf("unexpected reply: %s", last)
+	}
+	keys := strings.Join(storedKeys(t, env.bot), " ")
+	for _, gone := range []string{"artifacts:acme/widgets#42/prd", "priority:", "pulls:", "reminders:", "taskowners:", "compliance:", "deadletters:delivery-42"} {
+		if strings.Contains(keys, gone) {
+			t.Errorf("%s was not purged: %s", gone, keys)
+		}
+	}
+	for _, kept := range []string{"artifacts:acme/widgets#420/prd", "artifacts:acme/widgets#43/prd", "installations:acme/widgets", "artifacts:acme/widgets-ui#42/prd", "deadletters:delivery-43"} {
+		if !strings.Contains(keys, kept) {
+			t.Errorf("%s was purged: %s", kept, keys)
+		}
+	}
+
+	env.comment(t, "@prd-bot purge_data repo")
+	if keys := storedKeys(t, env.bot); len(keys) != 1 || keys[0] != "artifacts:acme/widgets-ui#42/prd" {
+		t.Errorf("after purging the repository, stored %v", keys)
+	}
+}
+
+func TestDataPurgeAPI(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.apiToken = "s3cret"
+	seedIssueData(t, env.bot)
+	mux := http.NewServeMux()
+	mux.HandleFunc("DELETE /repos/{owner}/{repo}/data", env.bot.handleDataPurge)
+	purge := func(url, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodDelete, url, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := purge("/repos/acme/widgets/data", "wrong"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token returned %d", rec.Code)
+	}
+	if rec := purge("/repos/acme/widgets/data?issue=x", "s3cret"); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid issue returned %d", rec.Code)
+	}
+	if rec := purge("/repos/acme/widgets/data?issue=43", "s3cret"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"purged":2}` {
+		t.Errorf("issue purge = %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := purge("/repos/acme/widgets/data", "s3cret"); rec.Code != http.StatusOK || strings.TrimSpace(rec.Body.String()) != `{"purged":9}` {
+		t.Errorf("repository purge = %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandAsk answers a question about one of the bot's pull requests.
+	// Mentions on a bot pull request that aren't a command are treated as
+	// questions too, e.g. `@bot why did you change report.go?`.
+	CommandAsk = "ask"
+
+	// AnswerIdentifier marks comments answering questions about a bot pull request.
+	AnswerIdentifier = "### About this change"
+
+	maxAnswerPatchBytes = 40 * 1024
+)
+
+// pullRequestHistory is what a bot pull request was generated from.
+type pullRequestHistory struct {
+	Issue *github.Issue // the issue implemented
+	PRD   string        // the PRD of the issue, when stored
+	Plan  string        // the approved implementation plan, if any
+	Diff  string        // the diff hunks relevant to the question
+}
+
+// isQuestionForPullRequest reports whether a mention that isn't a known
+// command was made on one of the bot's pull requests, where it is answered
+// as a question.
+func (b *Bot) isQuestionForPullRequest(issue *github.Issue, repo *github.Repository) bool {
+	return issue.IsPullRequest() && b.lookupPullRequest(repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()) != nil
+}
+
+// processAsk answers a question about a bot pull request, grounded in the
+// issue, PRD and plan it was generated from and the diff it made.
+func (b *Bot) processAsk(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, number := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	record := b.lookupPullRequest(repoOwner, repoName, number)
+	if record == nil {
+		b.postComment(ctx, client, repoOwner, repoName, number, "I can only answer questions on pull requests I opened.")
+		return
+	}
+	question := strings.TrimSpace(strings.Join(args, " "))
+	if question == "" {
+		b.postComment(ctx, client, repoOwner, repoName, number, fmt.Sprintf("Ask me about this change, e.g. `@%s why did you change report.go?`", b.appName))
+		return
+	}
+	log.Printf("Answering a question on pull request #%d in %s/%s", number, repoOwner, repoName)
+
+	history, err := b.loadPullRequestHistory(ctx, client, record, question, "")
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, number, "answer the question", "Could not load the history of this pull request", err)
+		return
+	}
+	answer, err := generateChangeAnswer(ctx, b.llm, history, question)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, number, "answer the question", "Could not generate the answer", err)
+		return
+	}
+	b.postComment(ctx, client, repoOwner, repoName, number, formatChangeAnswer(question, answer))
+}
+
+// handleReviewCommentQuestion answers a review comment mentioning the bot on
+// one of its pull requests, in the comment's thread, using the commented
+// diff hunk.
+func (b *Bot) handleReviewCommentQuestion(ctx context.Context, event *github.PullRequestReviewCommentEvent) {
+	comment, repo := event.GetComment(), event.GetRepo()
+	if event.GetAction() != "created" {
+		return
+	}
+	mention := "@" + b.appName
+	body := strings.TrimSpace(comment.GetBody())
+	question, ok := strings.CutPrefix(body, mention)
+	if !ok || !strings.HasPrefix(question, " ") {
+		return
+	}
+	owner, name, number := repo.GetOwner().GetLogin(), repo.GetName(), event.GetPullRequest().GetNumber()
+	record := b.lookupPullRequest(owner, name, number)
+	if record == nil {
+		return
+	}
+	client, err := b.clients.Client(event.GetInstallation().GetID())
+	if err != nil {
+		log.Printf("Error creating GitHub client for review comment: %v", err)
+		return
+	}
+	question = strings.TrimSpace(question)
+	hunk := fmt.Sprintf("--- %s\n%s", comment.GetPath(), comment.GetDiffHunk())
+	b.dispatch(ctx, func() {
+		ctx := b.withInstallation(withSender(context.Background(), event.GetSender()), event.GetInstallation().GetID())
+		cfg := b.repoConfig(ctx, client, repo)
+		if !cfg.CommandEnabled(CommandAsk) {
+			log.Printf("Command '%s' is disabled for %s.", CommandAsk, repo.GetFullName())
+			return
+		}
+		if status, ok := b.checkBudget(ctx, event.GetInstallation().GetID()); !ok {
+			log.Printf("Installation %d is over its monthly budget. Not answering the review comment on #%d in %s/%s.", event.GetInstallation().GetID(), number, owner, name)
+			if _, _, err := client.PullRequests.CreateCommentInReplyTo(ctx, owner, name, number, b.budgetExceededMessage(CommandAsk, status), comment.GetID()); err != nil {
+				log.Printf("Error replying to the review comment on #%d in %s/%s: %v", number, owner, name, err)
+			}
+			return
+		}
+		ctx = withPrompts(ctx, cfg, repo)
+		log.Printf("Answering a review comment on pull request #%d in %s/%s", number, owner, name)
+		history, err := b.loadPullRequestHistory(ctx, client, record, question, hunk)
+		if err != nil {
+			log.Printf("Error loading the history of #%d in %s/%s: %v", number, owner, name, err)
+			return
+		}
+		answer, err := generateChangeAnswer(ctx, b.llm, history, question)
+		if err != nil {
+			log.Printf("Error answering the review comment on #%d in %s/%s: %v", number, owner, name, err)
+			return
+		}
+		if _, _, err := client.PullRequests.CreateCommentInReplyTo(ctx, owner, name, number, answer, comment.GetID()); err != nil {
+			log.Printf("Error replying to the review comment on #%d in %s/%s: %v", number, owner, name, err)
+		}
+	})
+}
+
+// loadPullRequestHistory collects what record was generated from. hunk is
+// the diff under discussion; when empty, the patches of the files the
+// question names are used, or every patch when it names none.
+func (b *Bot) loadPullRequestHistory(ctx context.Context, client *github.Client, record *botPullRequest, question, hunk string) (*pullRequestHistory, error) {
+	issue, _, err := client.Issues.Get(ctx, record.Owner, record.Repo, record.Issue)
+	if err != nil {
+		return nil, fmt.Errorf("reading issue #%d: %w", record.Issue, err)
+	}
+	history := &pullRequestHistory{Issue: issue, Plan: record.Plan, Diff: hunk}
+	if prd, _ := b.loadArtifact(record.Owner, record.Repo, record.Issue, ArtifactPRD); prd != nil {
+		history.PRD = prd.Markdown
+	}
+	if hunk != "" {
+		return history, nil
+	}
+
+	files, _, err := client.PullRequests.ListFiles(ctx, record.Owner, record.Repo, record.Number, &github.ListOptions{PerPage: 100})
+	if err != nil {
+		return nil, fmt.Errorf("listing the files of #%d: %w", record.Number, err)
+	}
+	var named []*github.CommitFile
+	for _, f := range files {
+		if strings.Contains(question, f.GetFilename()) || strings.Contains(question, path.Base(f.GetFilename())) {
+			named = append(named, f)
+		}
+	}
+	if len(named) == 0 {
+		named = files
+	}
+	var diff strings.Builder
+	for _, f := range named {
+		patch := fmt.Sprintf("--- %s\n%s\n", f.GetFilename(), f.GetPatch())
+		if diff.Len()+len(patch) > maxAnswerPatchBytes {
+			fmt.Fprintf(&diff, "(further changes omitted)\n")
+			break
+		}
+		diff.WriteString(patch)
+	}
+	history.Diff = diff.String()
+	return history, nil
+}
+
+func generateChangeAnswer(ctx context.Context, llm Generator, history *pullRequestHistory, question string) (string, error) {
+	var sources strings.Builder
+	fmt.Fprintf(&sources, "**Issue #%d:** %s\n\n%s\n\n", history.Issue.GetNumber(), history.Issue.GetTitle(), history.Issue.GetBody())
+	if history.PRD != "" {
+		fmt.Fprintf(&sources, "**PRD:**\n%s\n\n", history.PRD)
+	}
+	if history.Plan != "" {
+		fmt.Fprintf(&sources, "**Approved implementation plan:**\n%s\n\n", history.Plan)
+	}
+	fmt.Fprintf(&sources, "**Diff:**\n```diff\n%s\n```", history.Diff)
+
+	prompt := fmt.Sprintf(
+		"You wrote the change of this pull request following the GitHub issue (and the implementation plan, when there is one). A reviewer asks a question about the change. Answer it in a few sentences, grounded only in the history below: cite the part of the issue, PRD or plan that motivated the change, and quote the relevant lines of the diff. If the history doesn't explain the change, suggest what the reviewer could check instead of guessing.\n\n"+
+			"**Question:** %s\n\n%s",
+		question, sources.String(),
+	)
+	answer, err := generateMarkdown(withSystemPrompt(ctx, CommandAsk), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to answer the question: %w", err)
+	}
+	return strings.TrimSpace(answer), nil
+}
+
+func formatChangeAnswer(question, answer string) string {
+	return fmt.Sprintf("%s\n\n> %s\n\n%s", AnswerIdentifier, strings.ReplaceAll(question, "\n", "\n> "), answer)
+}
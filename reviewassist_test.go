@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// commentOnPull delivers an issue_comment webhook on pull request number.
+func (env *testEnv) commentOnPull(t *testing.T, number int, body string) {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/issue_comment_need_sub_task.json"), &event); err != nil {
+		t.Fatalf("decoding comment fixture: %v", err)
+	}
+	issue := event["issue"].(map[string]any)
+	issue["number"] = number
+	issue["pull_request"] = map[string]any{"url": "https://api.github.com/repos/acme/widgets/pulls/7"}
+	event["comment"].(map[string]any)["body"] = body
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding comment payload: %v", err)
+	}
+	env.deliverPayload(t, "issue_comment", payload)
+}
+
+func seedBotPull(t *testing.T, env *testEnv) {
+	t.Helper()
+	issue := env.github.addIssue("acme", "widgets", 42, "Export reports as CSV", "open")
+	env.github.mu.Lock()
+	issue.Body = github.String("Users need CSV exports.\n\nFiles: report.go, export/csv.go")
+	env.github.pullFiles[7] = []*github.CommitFile{
+		{Filename: github.String("report.go"), Patch: github.String("@@ -1 +1,2 @@\n+rows := buildRows()")},
+		{Filename: github.String("export/csv.go"), Patch: github.String("@@ -0,0 +1 @@\n+func WriteCSV() {}")},
+	}
+	env.github.mu.Unlock()
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 7, Issue: 42, Plan: "1. Extract buildRows in report.go"})
+}
+
+func TestQuestionOnBotPullRequest(t *testing.T) {
+	env := newTestEnv(t)
+	seedBotPull(t, env)
+	env.gemini.on("A reviewer asks a question about the change", "buildRows was extracted so the CSV writer can reuse it, as step 1 of the plan says.")
+
+	env.commentOnPull(t, 7, "@prd-bot why did you change report.go?")
+
+	comments := env.github.issueComments("acme", "widgets", 7)
+	if len(comments) != 1 {
+		t.Fatalf("expected one answer, got %d comments", len(comments))
+	}
+	body := comments[0].GetBody()
+	if !strings.HasPrefix(body, AnswerIdentifier) || !strings.Contains(body, "> why did you change report.go?") || !strings.Contains(body, "step 1 of the plan") {
+		t.Errorf("unexpected answer:\n%s", body)
+	}
+	prompt := env.gemini.receivedPrompts()[0]
+	for _, want := range []string{"Users need CSV exports.", "1. Extract buildRows in report.go", "+rows := buildRows()"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q:\n%s", want, prompt)
+		}
+	}
+	if strings.Contains(prompt, "WriteCSV") {
+		t.Errorf("prompt includes the diff of a file the question doesn't name:\n%s", prompt)
+	}
+}
+
+func TestQuestionOnOtherPullRequestIgnored(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.commentOnPull(t, 8, "@prd-bot why did you change report.go?")
+
+	if comments := env.github.issueComments("acme", "widgets", 8); len(comments) != 0 {
+		t.Errorf("expected no reply on a pull request the bot didn't open, got %+v", comments)
+	}
+}
+
+func TestReviewCommentQuestionRepliesInThread(t *testing.T) {
+	env := newTestEnv(t)
+	seedBotPull(t, env)
+	env.gemini.on("A reviewer asks a question about the change", "It writes the header row first.")
+	payload, err := json.Marshal(map[string]any{
+		"action":       "created",
+		"installation": map[string]any{"id": 1},
+		"repository":   map[string]any{"name": "widgets", "full_name": "acme/widgets", "owner": map[string]any{"login": "acme"}},
+		"pull_request": map[string]any{"number": 7},
+		"sender":       map[string]any{"login": "alice"},
+		"comment": map[string]any{
+			"id": 55, "body": "@prd-bot why this line?", "path": "export/csv.go",
+			"diff_hunk": "@@ -0,0 +1 @@\n+writeHeader(w)",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env.deliverPayload(t, "pull_request_review_comment", payload)
+
+	env.github.mu.Lock()
+	defer env.github.mu.Unlock()
+	if len(env.github.reviewComments) != 1 {
+		t.Fatalf("expected one reply, got %d", len(env.github.reviewComments))
+	}
+	reply := env.github.reviewComments[0]
+	if reply.GetInReplyTo() != 55 || reply.GetBody() != "It writes the header row first." {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// ReviewChecklistIdentifier heads the reviewer checklist in pull request
+// bodies.
+const ReviewChecklistIdentifier = "### Reviewer Checklist"
+
+// reviewCheck is a check a reviewer makes to verify one acceptance
+// criterion of the PRD, with the changed files implementing it.
+type reviewCheck struct {
+	Check     string   `json:"check"`
+	Criterion string   `json:"criterion"`
+	Files     []string `json:"files"`
+}
+
+// reviewChecklist returns the "Reviewer Checklist" section for a pull
+// request implementing issue, generated from the acceptance criteria of
+// its PRD, or of its parent's when it is a sub-issue. It is "" when there
+// is no PRD or the model can't write the checklist.
+func (b *Bot) reviewChecklist(ctx context.Context, client *github.Client, repo *github.Repository, issue *github.Issue, diff string, stats []fileStat) string {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	prdIssue := issue.GetNumber()
+	comment, _ := b.findPRDComment(ctx, client, owner, name, prdIssue)
+	if comment == nil {
+		if parent, _ := parentIssue(ctx, client, owner, name, prdIssue); parent != nil {
+			prdIssue = parent.GetNumber()
+			comment, _ = b.findPRDComment(ctx, client, owner, name, prdIssue)
+		}
+	}
+	if comment == nil {
+		return ""
+	}
+	prd := comment.GetBody()
+	if doc, ok := parsePRDDocument(prd); ok {
+		prd = doc.English
+	}
+	var changed []string
+	for _, s := range stats {
+		changed = append(changed, s.Path)
+	}
+	checks, err := generateReviewChecklist(ctx, b.llm, issue, prd, diff, changed)
+	if err != nil {
+		log.Printf("Could not write the reviewer checklist for issue #%d, opening the pull request without it: %v", issue.GetNumber(), err)
+		return ""
+	}
+	return formatReviewChecklist(checks, prdIssue)
+}
+
+func generateReviewChecklist(ctx context.Context, llm Generator, issue *github.Issue, prd, diff string, changed []string) ([]reviewCheck, error) {
+	if len(diff) > maxAssessedDiff {
+		diff = diff[:maxAssessedDiff] + "\n[diff truncated]"
+	}
+	prompt := fmt.Sprintf(
+		"Write the reviewer checklist of the following change, which implements a GitHub issue, from the acceptance criteria of its Product Requirements Document (PRD): its requirements and user stories. "+
+			"Only include the criteria that apply to this issue. Turn each into one concrete check a reviewer can run or read for, such as \"Verify `GET /reports/{id}.csv` returns 404 when the report doesn't exist\", "+
+			"and map it to the changed files that implement it. Leave the files empty when no changed file implements a criterion.\n\n"+
+			"Only choose files from the changed files below. Respond with JSON only, in this format:\n"+
+			`{"items": [{"check": "Verify ...", "criterion": "The requirement it verifies", "files": ["report.go"]}]}`+"\n\n"+
+			"**Issue Title:** %s\n\n**Changed Files:**\n%s\n\n**PRD:**\n%s\n\n**Diff:**\n```diff\n%s\n```",
+		issue.GetTitle(), strings.Join(changed, "\n"), prd, diff,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptReviewChecklist), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the reviewer checklist: %w", err)
+	}
+	var result struct {
+		Items []reviewCheck `json:"items"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	var checks []reviewCheck
+	for _, item := range result.Items {
+		item.Check = strings.TrimSpace(item.Check)
+		if item.Check == "" {
+			continue
+		}
+		item.Files = slices.DeleteFunc(item.Files, func(f string) bool { return !slices.Contains(changed, f) })
+		checks = append(checks, item)
+	}
+	return checks, nil
+}
+
+// formatReviewChecklist renders the checks as a pull request body section.
+// Checks no changed file implements are flagged, as the change may miss
+// their criteria.
+func formatReviewChecklist(checks []reviewCheck, prdIssue int) string {
+	if len(checks) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\nFrom the acceptance criteria of the PRD in #%d. Tick each check once you have verified it.\n", ReviewChecklistIdentifier, prdIssue)
+	for _, c := range checks {
+		line := "\n- [ ] " + c.Check
+		if len(c.Files) > 0 {
+			line += " (`" + strings.Join(c.Files, "`, `") + "`)"
+		} else {
+			line += " — _no changed file implements this; it may be missing._"
+		}
+		b.WriteString(line)
+	}
+	return b.String()
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPullRequestListsReviewerChecklist(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"4.  **Requirements:** Export reports as CSV. Missing reports return 404.")
+	env.runner.outputs = map[string]string{
+		"--unified=0": "--- a/export/csv.go\n+++ b/export/csv.go\n@@ -10,0 +11 @@\n+func Export() {}\n",
+		"--numstat":   "1\t0\texport/csv.go\n",
+	}
+	env.gemini.on("Write the reviewer checklist", `{"items": [
+		{"check": "Verify reports download as CSV", "criterion": "Export reports as CSV.", "files": ["export/csv.go", "invented.go"]},
+		{"check": "Verify GET /reports/{id}.csv returns 404 when the report doesn't exist", "criterion": "Missing reports return 404.", "files": []},
+		{"check": " ", "files": ["export/csv.go"]}
+	]}`)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected 1 pull request, got %d", len(pulls))
+	}
+	want := ReviewChecklistIdentifier + "\n\nFrom the acceptance criteria of the PRD in #42. Tick each check once you have verified it.\n" +
+		"\n- [ ] Verify reports download as CSV (`export/csv.go`)" +
+		"\n- [ ] Verify GET /reports/{id}.csv returns 404 when the report doesn't exist — _no changed file implements this; it may be missing._"
+	if body := pulls[0].GetBody(); !strings.HasSuffix(body, want) {
+		t.Errorf("expected the checklist %q in:\n%s", want, body)
+	}
+	var prompt string
+	for _, p := range env.gemini.receivedPrompts() {
+		if strings.HasPrefix(p, "Write the reviewer checklist") {
+			prompt = p
+		}
+	}
+	for _, want := range []string{"Missing reports return 404.", "**Changed Files:**\nexport/csv.go\n", "+func Export() {}"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("expected %q in the checklist prompt:\n%s", want, prompt)
+		}
+	}
+}
+
+func TestReviewerChecklistNeedsPRD(t *testing.T) {
+	env := newTestEnv(t)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	for _, p := range env.gemini.receivedPrompts() {
+		if strings.HasPrefix(p, "Write the reviewer checklist") {
+			t.Fatalf("an issue without a PRD shouldn't get a checklist:\n%s", p)
+		}
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 1 || strings.Contains(pulls[0].GetBody(), ReviewChecklistIdentifier) {
+		t.Errorf("expected a pull request without a checklist, got %v", pulls)
+	}
+}
+
+func TestInvalidReviewerChecklistIsLeftOut(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"4.  **Requirements:** Export reports as CSV.")
+	env.gemini.on("Write the reviewer checklist", "I can't tell which criteria apply.")
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	if pulls := env.github.pullRequests(); len(pulls) != 1 || strings.Contains(pulls[0].GetBody(), ReviewChecklistIdentifier) {
+		t.Errorf("the pull request should be opened without the checklist, got %v", pulls)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"go/format"
+	"log"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandRollbackPlan = "need_rollback_plan"
+
+	// RollbackPlanIdentifier marks comments produced by the
+	// need_rollback_plan command.
+	RollbackPlanIdentifier = "### Rollback Plan"
+
+	ArtifactRollbackPlan = "rollback_plan"
+
+	// featureFlagsPath is the Go file holding the feature flags scaffolded
+	// in Go repositories.
+	featureFlagsPath = "internal/featureflags/featureflags.go"
+
+	// featureFlagsMarker is the line of the Config struct new flags are
+	// added above.
+	featureFlagsMarker = "\t// agent-prd:flags (new flags are added above this line)"
+)
+
+// shipFlag is the feature flag a feature ships dark behind in the target
+// repository.
+type shipFlag struct {
+	Field string // Go field of featureflags.Config
+	Env   string // environment variable turning it on
+}
+
+// newShipFlag names the flag of issue after its title.
+func newShipFlag(issue *github.Issue) shipFlag {
+	words := strings.FieldsFunc(slugify(issue.GetTitle()), func(r rune) bool { return r == '-' })
+	var field strings.Builder
+	for _, word := range words {
+		field.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	name := field.String()
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		name = fmt.Sprintf("Issue%d%s", issue.GetNumber(), name)
+	}
+	env := "FEATURE_" + strings.ToUpper(strings.Join(words, "_"))
+	if len(words) == 0 {
+		env = fmt.Sprintf("FEATURE_ISSUE_%d", issue.GetNumber())
+	}
+	return shipFlag{Field: name, Env: env}
+}
+
+// processRollbackPlan posts how to roll back the feature described by the
+// PRD. In Go repositories with an open implementation pull request, it also
+// commits a feature flag, off by default, to the pull request's branch so the
+// feature ships dark and the first rollback step is turning it off.
+func (b *Bot) processRollbackPlan(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandRollbackPlan, issueNum, repoOwner, repoName)
+
+	version, _ := prdVersionArg(args)
+	prdComment, ok := b.findPRDVersion(ctx, client, repoOwner, repoName, issueNum, version)
+	if !ok {
+		return
+	}
+	if prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting rollback plan.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to plan a rollback for. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	prd := prdComment.GetBody()
+	if doc, ok := parsePRDDocument(prd); ok {
+		prd = doc.English
+	}
+
+	pr := b.openImplementation(ctx, client, repo, issueNum)
+	var flag *shipFlag
+	var module string
+	if pr != nil {
+		if module = goModulePath(ctx, client, repo, pr.Branch); module != "" {
+			f := newShipFlag(issue)
+			flag = &f
+		}
+	}
+
+	plan, err := generateRollbackPlan(ctx, b.llm, issue, prd, pr, flag)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "plan the rollback", "Could not generate the rollback plan", err)
+		return
+	}
+
+	body := RollbackPlanIdentifier + "\n\n" + plan
+	switch {
+	case flag != nil:
+		if err := scaffoldFeatureFlag(ctx, client, repo, pr.Branch, issue, *flag); err != nil {
+			log.Printf("Error scaffolding the feature flag of issue #%d on %s: %v", issueNum, pr.Branch, err)
+			body += fmt.Sprintf("\n\n_I couldn't add the `%s` feature flag to #%d: %v_", flag.Env, pr.Number, err)
+		} else {
+			body += "\n\n" + formatFeatureFlagUsage(*flag, module, pr.Number)
+		}
+	case pr == nil:
+		body += fmt.Sprintf("\n\n_Run this command again once `%s` has opened a pull request to scaffold a feature flag in Go repositories._", CommandImplementFeature)
+	}
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactRollbackPlan, body)
+	b.saveArtifact(ArtifactRollbackPlan, repoOwner, repoName, issue, body, comment)
+}
+
+// openImplementation returns the most recent open bot pull request for the
+// issue, or nil.
+func (b *Bot) openImplementation(ctx context.Context, client *github.Client, repo *github.Repository, issueNum int) *botPullRequest {
+	pulls := b.relatedPullRequests(repo.GetOwner().GetLogin(), repo.GetName(), issueNum)
+	for _, record := range slices.Backward(pulls) {
+		pr, _, err := client.PullRequests.Get(ctx, record.Owner, record.Repo, record.Number)
+		if err != nil {
+			log.Printf("Error reading pull request #%d in %s: %v", record.Number, repo.GetFullName(), err)
+			continue
+		}
+		if pr.GetState() == "open" && record.Branch != "" {
+			return &record
+		}
+	}
+	return nil
+}
+
+// goModulePath returns the module path of the go.mod at the root of ref, or
+// "" when the repository isn't a Go module.
+func goModulePath(ctx context.Context, client *github.Client, repo *github.Repository, ref string) string {
+	file, _, _, err := client.Repositories.GetContents(ctx, repo.GetOwner().GetLogin(), repo.GetName(), "go.mod", &github.RepositoryContentGetOptions{Ref: ref})
+	if err != nil || file == nil {
+		return ""
+	}
+	content, err := file.GetContent()
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if path, ok := strings.CutPrefix(strings.TrimSpace(line), "module "); ok {
+			return strings.Trim(strings.TrimSpace(path), `"`)
+		}
+	}
+	return ""
+}
+
+func generateRollbackPlan(ctx context.Context, llm Generator, issue *github.Issue, prd string, pr *botPullRequest, flag *shipFlag) (string, error) {
+	var details strings.Builder
+	if pr != nil && len(pr.Files) > 0 {
+		fmt.Fprintf(&details, "**Files changed by the implementation (#%d):** %s\n\n", pr.Number, strings.Join(pr.Files, ", "))
+	}
+	if flag != nil {
+		fmt.Fprintf(&details, "**Feature flag:** the feature ships behind the `%s` environment variable, off by default. Turning it off is the first rollback step.\n\n", flag.Env)
+	}
+	prompt := fmt.Sprintf(
+		"Write a rollback plan for the feature described in the following Product Requirements Document (PRD), for the on-call engineer who may need to undo its release.\n\n"+
+			"Format the output as GitHub-flavored Markdown under these headings:\n"+
+			"1.  **Rollback Triggers:** (The errors, metrics and alert thresholds that should trigger a rollback)\n"+
+			"2.  **Rollback Steps:** (Numbered steps, fastest first: disable the feature, revert the deploy, then clean up)\n"+
+			"3.  **Data & Migrations:** (Whether data or schema changes can be reversed, what to back up before release, and how to handle data written while the feature was on)\n"+
+			"4.  **Verification:** (How to confirm the rollback worked)\n"+
+			"5.  **Communication:** (Who to notify and what users will notice)\n\n"+
+			"Keep every step concrete and say so when the PRD doesn't give enough detail.\n\n"+
+			"**Issue Title:** %s\n\n"+
+			"%s"+
+			"**Here is the PRD:**\n%s",
+		issue.GetTitle(), details.String(), prd,
+	)
+	plan, err := generateMarkdown(withSystemPrompt(ctx, CommandRollbackPlan), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate rollback plan: %w", err)
+	}
+	return strings.TrimSpace(plan), nil
+}
+
+// scaffoldFeatureFlag commits flag to the featureflags package on branch,
+// creating the package when the branch doesn't have it yet.
+func scaffoldFeatureFlag(ctx context.Context, client *github.Client, repo *github.Repository, branch string, issue *github.Issue, flag shipFlag) error {
+	owner, name := repo.GetOwner().GetLogin(), repo.GetName()
+	opts := &github.RepositoryContentFileOptions{
+		Message: github.String(fmt.Sprintf("feat: Add the %s feature flag for #%d", flag.Env, issue.GetNumber())),
+		Branch:  github.String(branch),
+	}
+	source := featureFlagsSource
+	if existing, _, _, err := client.Repositories.GetContents(ctx, owner, name, featureFlagsPath, &github.RepositoryContentGetOptions{Ref: branch}); err == nil && existing != nil {
+		if source, err = existing.GetContent(); err != nil {
+			return err
+		}
+		opts.SHA = existing.SHA
+	}
+	content, err := addFeatureFlag(source, issue, flag)
+	if err != nil {
+		return err
+	}
+	if content == source {
+		return nil
+	}
+	opts.Content = []byte(content)
+	if _, _, err := client.Repositories.CreateFile(ctx, owner, name, featureFlagsPath, opts); err != nil {
+		return githubError(ErrPullRequestFailed, fmt.Errorf("committing %s: %w", featureFlagsPath, err))
+	}
+	return nil
+}
+
+// addFeatureFlag adds the field and guard of flag to the featureflags
+// source, unless it already has the field.
+func addFeatureFlag(source string, issue *github.Issue, flag shipFlag) (string, error) {
+	if strings.Contains(source, fmt.Sprintf("`env:%q`", flag.Env)) {
+		return source, nil
+	}
+	if !strings.Contains(source, featureFlagsMarker) {
+		return "", fmt.Errorf("%s has no %q line to add the flag above", featureFlagsPath, strings.TrimSpace(featureFlagsMarker))
+	}
+	title := strings.ReplaceAll(issue.GetTitle(), "\n", " ")
+	field := fmt.Sprintf("\t// %s gates #%d: %s.\n\t%s bool `env:%q`\n", flag.Field, issue.GetNumber(), title, flag.Field, flag.Env)
+	guard := fmt.Sprintf("\n// %sEnabled reports whether the feature of #%d is turned on.\nfunc %sEnabled() bool {\n\treturn Load().%s\n}\n", flag.Field, issue.GetNumber(), flag.Field, flag.Field)
+	source = strings.Replace(source, featureFlagsMarker, field+featureFlagsMarker, 1)
+	formatted, err := format.Source([]byte(strings.TrimRight(source, "\n") + "\n" + guard))
+	if err != nil {
+		return "", fmt.Errorf("formatting %s: %w", featureFlagsPath, err)
+	}
+	return string(formatted), nil
+}
+
+// featureFlagsSource is the featureflags package before any flag is added.
+const featureFlagsSource = `// Package featureflags holds the flags new features ship behind. Every flag
+// is off unless its environment variable is set to true, so a feature can be
+// released dark and rolled back without a deploy.
+package featureflags
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+)
+
+// Config holds one field per feature flag, tagged with its environment
+// variable.
+type Config struct {
+` + featureFlagsMarker + `
+}
+
+// Load reads the flags from the environment.
+func Load() Config {
+	var c Config
+	v := reflect.ValueOf(&c).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		on, _ := strconv.ParseBool(os.Getenv(v.Type().Field(i).Tag.Get("env")))
+		v.Field(i).SetBool(on)
+	}
+	return c
+}
+`
+
+// formatFeatureFlagUsage tells the implementer how to guard the feature.
+func formatFeatureFlagUsage(flag shipFlag, module string, prNumber int) string {
+	return fmt.Sprintf("#### Feature Flag\n\nI've added the `%s` feature flag to `%s` in #%d. It is off by default, so the feature ships dark. Guard the feature's entry points with it:\n\n"+
+		"```go\nimport \"%s/internal/featureflags\"\n\nif featureflags.%sEnabled() {\n\t// the new behaviour\n}\n```\n\n"+
+		"Set `%s=true` to turn the feature on, and unset it to roll back.",
+		flag.Env, featureFlagsPath, prNumber, module, flag.Field, flag.Env)
+}
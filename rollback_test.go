@@ -0,0 +1,91 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const rollbackReply = "1.  **Rollback Triggers:** Export errors above 1%.\n2.  **Rollback Steps:** Turn the flag off."
+
+func TestRollbackPlanScaffoldsFeatureFlag(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.github.addFile("acme", "widgets", "go.mod", "module github.com/acme/widgets\n\ngo 1.22\n")
+	number := env.github.addPull("acme", "widgets", "Export reports as CSV")
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: number, Issue: 42, Branch: "feature/issue-42", Files: []string{"export/csv.go"}})
+	env.gemini.on("Write a rollback plan", rollbackReply)
+
+	env.comment(t, "@prd-bot need_rollback_plan")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "`FEATURE_EXPORT_REPORTS_AS_CSV`") || !strings.Contains(prompt, "export/csv.go") {
+		t.Errorf("the prompt should name the flag and the changed files:\n%s", prompt)
+	}
+	source, ok := env.github.committed("acme", "widgets", "feature/issue-42", featureFlagsPath)
+	if !ok {
+		t.Fatalf("%s was not committed to the implementation branch", featureFlagsPath)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), featureFlagsPath, source, 0); err != nil {
+		t.Errorf("the scaffolded package doesn't parse: %v\n%s", err, source)
+	}
+	for _, want := range []string{"ExportReportsAsCsv bool `env:\"FEATURE_EXPORT_REPORTS_AS_CSV\"`", "func ExportReportsAsCsvEnabled() bool", featureFlagsMarker} {
+		if !strings.Contains(source, want) {
+			t.Errorf("the featureflags package should contain %q:\n%s", want, source)
+		}
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	body := comments[len(comments)-1].GetBody()
+	for _, want := range []string{RollbackPlanIdentifier, "Turn the flag off.", `import "github.com/acme/widgets/internal/featureflags"`, "featureflags.ExportReportsAsCsvEnabled()"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the reply should contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestRollbackPlanAddsToExistingFlags(t *testing.T) {
+	issue := &github.Issue{Number: github.Int(7), Title: github.String("Dark mode")}
+	first, err := addFeatureFlag(featureFlagsSource, issue, newShipFlag(issue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	issue = &github.Issue{Number: github.Int(42), Title: github.String("2FA login")}
+	second, err := addFeatureFlag(first, issue, newShipFlag(issue))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"DarkMode ", "`env:\"FEATURE_DARK_MODE\"`", "Issue422faLogin ", "`env:\"FEATURE_2FA_LOGIN\"`", "func Issue422faLoginEnabled() bool"} {
+		if !strings.Contains(second, want) {
+			t.Errorf("expected %q in:\n%s", want, second)
+		}
+	}
+	if again, _ := addFeatureFlag(second, issue, newShipFlag(issue)); again != second {
+		t.Error("adding a flag twice should leave the package unchanged")
+	}
+	if _, err := addFeatureFlag("package featureflags\n", issue, newShipFlag(issue)); err == nil {
+		t.Error("expected an error without the marker line")
+	}
+}
+
+func TestRollbackPlanWithoutImplementation(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.github.addFile("acme", "widgets", "go.mod", "module github.com/acme/widgets\n")
+	env.gemini.on("Write a rollback plan", rollbackReply)
+
+	env.comment(t, "@prd-bot need_rollback_plan")
+
+	if prompt := env.gemini.receivedPrompts()[0]; strings.Contains(prompt, "Feature flag") {
+		t.Errorf("no flag should be planned without an implementation:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "once `implement_feature` has opened a pull request") {
+		t.Errorf("unexpected reply:\n%s", body)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactRollbackPlan); artifact == nil {
+		t.Error("the rollback plan should be saved")
+	}
+}
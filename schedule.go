@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+)
+
+// defaultWorkingHours are the local hours scheduled notifications are sent
+// in when a schedule doesn't set them.
+const defaultWorkingHours = "09:00-17:00"
+
+// defaultWorkingDays are the working days of a schedule that doesn't set
+// them.
+var defaultWorkingDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// ScheduleConfig is the local working time of a repository's team. With a
+// schedule, reminders are only sent on working days within working hours in
+// its time zone, and their thresholds count working days. Without one, they
+// are sent as soon as they are due.
+type ScheduleConfig struct {
+	// TimeZone is an IANA time zone such as "Asia/Taipei"; UTC by default.
+	TimeZone string `yaml:"time_zone"`
+	// WorkingDays are the days of the week notifications are sent on, e.g.
+	// [mon, tue, wed, thu, fri] (the default).
+	WorkingDays []string `yaml:"working_days"`
+	// Hours is the local time range notifications are sent in, e.g.
+	// "09:00-17:00" (the default).
+	Hours string `yaml:"hours"`
+}
+
+// location returns the time zone of the schedule, UTC when it is unknown.
+func (c *ScheduleConfig) location() *time.Location {
+	if c == nil || c.TimeZone == "" {
+		return time.UTC
+	}
+	loc, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		log.Printf("Ignoring the unknown schedule.time_zone %q: %v", c.TimeZone, err)
+		return time.UTC
+	}
+	return loc
+}
+
+// workingDays returns the working days of the schedule. Unknown names are
+// ignored, and none known means the default days.
+func (c *ScheduleConfig) workingDays() []time.Weekday {
+	var days []time.Weekday
+	for _, name := range c.WorkingDays {
+		day, ok := parseWeekday(name)
+		if !ok {
+			log.Printf("Ignoring the unknown day %q of schedule.working_days", name)
+			continue
+		}
+		days = append(days, day)
+	}
+	if len(days) == 0 {
+		return defaultWorkingDays
+	}
+	return days
+}
+
+// workingHours returns the working hours of the schedule as offsets from
+// local midnight.
+func (c *ScheduleConfig) workingHours() (start, end time.Duration) {
+	hours := c.Hours
+	if hours == "" {
+		hours = defaultWorkingHours
+	}
+	start, end, err := parseHourRange(hours)
+	if err != nil {
+		log.Printf("Ignoring the invalid schedule.hours %q: %v", c.Hours, err)
+		start, end, _ = parseHourRange(defaultWorkingHours)
+	}
+	return start, end
+}
+
+// open reports whether notifications may be sent at now: always without a
+// schedule, within the working hours of working days with one.
+func (c *ScheduleConfig) open(now time.Time) bool {
+	if c == nil {
+		return true
+	}
+	local := now.In(c.location())
+	start, end := c.workingHours()
+	sinceMidnight := local.Sub(startOfDay(local))
+	return slices.Contains(c.workingDays(), local.Weekday()) && sinceMidnight >= start && sinceMidnight < end
+}
+
+// elapsedDays returns how many days passed from since to now: calendar days
+// without a schedule, only the time on working days with one.
+func (c *ScheduleConfig) elapsedDays(since, now time.Time) float64 {
+	if c == nil {
+		return now.Sub(since).Hours() / 24
+	}
+	days := c.workingDays()
+	var working time.Duration
+	for day := startOfDay(since.In(c.location())); day.Before(now); day = day.AddDate(0, 0, 1) {
+		if !slices.Contains(days, day.Weekday()) {
+			continue
+		}
+		from, to := maxTime(day, since), minTime(day.AddDate(0, 0, 1), now)
+		if to.After(from) {
+			working += to.Sub(from)
+		}
+	}
+	return working.Hours() / 24
+}
+
+// dayUnit names the days thresholds are counted in.
+func (c *ScheduleConfig) dayUnit() string {
+	if c == nil {
+		return "days"
+	}
+	return "working days"
+}
+
+// parseWeekday parses a day of the week such as "mon" or "Monday".
+func parseWeekday(name string) (time.Weekday, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for day := time.Sunday; day <= time.Saturday; day++ {
+		full := strings.ToLower(day.String())
+		if len(name) >= 3 && strings.HasPrefix(full, name) {
+			return day, true
+		}
+	}
+	return 0, false
+}
+
+// parseHourRange parses a local time range such as "09:00-17:00".
+func parseHourRange(hours string) (start, end time.Duration, err error) {
+	from, to, ok := strings.Cut(hours, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected a range such as %q", defaultWorkingHours)
+	}
+	if start, err = parseClock(from); err != nil {
+		return 0, 0, err
+	}
+	if end, err = parseClock(to); err != nil {
+		return 0, 0, err
+	}
+	if end <= start {
+		return 0, 0, fmt.Errorf("%s doesn't end after it starts", hours)
+	}
+	return start, end, nil
+}
+
+// parseClock parses a time of day such as "09:00", or "24:00" for the end
+// of the day.
+func parseClock(clock string) (time.Duration, error) {
+	clock = strings.TrimSpace(clock)
+	if clock == "24:00" {
+		return 24 * time.Hour, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", clock)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// startOfDay returns the local midnight starting the day of t.
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
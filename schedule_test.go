@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestRemindersFollowSchedule(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "reminders:\n  review_after_days: 1\nschedule:\n  time_zone: Asia/Taipei\n")
+	env.bot.rememberInstallation(&github.Repository{FullName: github.String("acme/widgets")}, 7)
+	taipei, err := time.LoadLocation("Asia/Taipei")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	env.github.addPull("acme", "widgets", "Unreviewed")
+	// Thursday 10:00 in Taipei.
+	env.bot.recordPullRequest(&botPullRequest{Owner: "acme", Repo: "widgets", Number: 1, Issue: 42, CreatedAt: time.Date(2026, 10, 15, 10, 0, 0, 0, taipei)})
+
+	// Due since Friday 10:00, but outside working hours until Monday.
+	for _, now := range []time.Time{
+		time.Date(2026, 10, 16, 9, 0, 0, 0, taipei),    // Friday, 23 working hours later
+		time.Date(2026, 10, 16, 20, 0, 0, 0, taipei),   // Friday evening
+		time.Date(2026, 10, 17, 10, 0, 0, 0, taipei),   // Saturday
+		time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC), // Monday 07:00 in Taipei
+	} {
+		env.bot.sendReminders(context.Background(), now)
+		if comments := env.github.issueComments("acme", "widgets", 1); len(comments) != 0 {
+			t.Fatalf("no reminder should be sent at %s, got %+v", now, comments)
+		}
+	}
+	env.bot.sendReminders(context.Background(), time.Date(2026, 10, 19, 9, 0, 0, 0, taipei))
+
+	comments := env.github.issueComments("acme", "widgets", 1)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "more than 1 working days") {
+		t.Errorf("expected a reminder on Monday morning, got %+v", comments)
+	}
+}
+
+func TestScheduleOpen(t *testing.T) {
+	cfg := &ScheduleConfig{TimeZone: "America/New_York", WorkingDays: []string{"Sun", "monday"}, Hours: "08:30-12:00"}
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	for _, tt := range []struct {
+		now  time.Time
+		want bool
+	}{
+		{time.Date(2026, 10, 18, 8, 30, 0, 0, newYork), true},  // Sunday
+		{time.Date(2026, 10, 19, 11, 59, 0, 0, newYork), true}, // Monday
+		{time.Date(2026, 10, 19, 12, 0, 0, 0, newYork), false},
+		{time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC), false}, // 08:00 in New York
+		{time.Date(2026, 10, 20, 9, 0, 0, 0, newYork), false},   // Tuesday
+	} {
+		if got := cfg.open(tt.now); got != tt.want {
+			t.Errorf("open(%s) = %v, want %v", tt.now, got, tt.want)
+		}
+	}
+	var none *ScheduleConfig
+	if !none.open(time.Date(2026, 10, 18, 3, 0, 0, 0, time.UTC)) {
+		t.Error("without a schedule, reminders may be sent at any time")
+	}
+}
+
+func TestScheduleElapsedDays(t *testing.T) {
+	friday := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	cfg := &ScheduleConfig{}
+	if got := cfg.elapsedDays(friday, friday.AddDate(0, 0, 3)); got != 1 {
+		t.Errorf("Friday noon to Monday noon is %g working days, want 1", got)
+	}
+	if got := (*ScheduleConfig)(nil).elapsedDays(friday, friday.AddDate(0, 0, 3)); got != 3 {
+		t.Errorf("without a schedule, Friday noon to Monday noon is %g days, want 3", got)
+	}
+}
+
+func TestScheduleInvalidSettingsFallBack(t *testing.T) {
+	cfg := &ScheduleConfig{TimeZone: "Mars/Olympus", WorkingDays: []string{"someday"}, Hours: "17:00-09:00"}
+	if cfg.location() != time.UTC {
+		t.Error("an unknown time zone should fall back to UTC")
+	}
+	if days := cfg.workingDays(); len(days) != 5 || days[0] != time.Monday {
+		t.Errorf("unknown days should fall back to Monday to Friday, got %v", days)
+	}
+	if start, end := cfg.workingHours(); start != 9*time.Hour || end != 17*time.Hour {
+		t.Errorf("invalid hours should fall back to 09:00-17:00, got %s-%s", start, end)
+	}
+}
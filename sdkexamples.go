@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// CommandSDKExamples writes usage examples of what a pull request adds
+	// to a library, in the languages its users call it from.
+	CommandSDKExamples = "need_sdk_examples"
+
+	// SDKExamplesIdentifier marks comments produced by need_sdk_examples.
+	SDKExamplesIdentifier = "### SDK Examples"
+
+	// sdkExamplesDir is where --commit adds the examples on the pull
+	// request's branch, one directory per language.
+	sdkExamplesDir = "examples"
+
+	// maxExampleLanguages bounds the languages examples are written in.
+	maxExampleLanguages = 4
+)
+
+// exampleLanguages names the languages of source file extensions.
+var exampleLanguages = map[string]string{
+	".go": "Go", ".py": "Python", ".js": "JavaScript", ".mjs": "JavaScript", ".ts": "TypeScript",
+	".java": "Java", ".kt": "Kotlin", ".rb": "Ruby", ".rs": "Rust", ".cs": "C#", ".php": "PHP",
+	".swift": "Swift", ".dart": "Dart", ".c": "C", ".cpp": "C++",
+}
+
+// exampleFilenamePattern matches the file names examples may be committed
+// under.
+var exampleFilenamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// sdkExample is a usage example the model wrote.
+type sdkExample struct {
+	Language    string `json:"language"`
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Filename    string `json:"filename"`
+	Code        string `json:"code"`
+}
+
+// path is where --commit adds the example, or "" when its file name isn't
+// usable.
+func (e sdkExample) path() string {
+	dir := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, codeFenceLanguage(e.Language))
+	if dir == "" || !exampleFilenamePattern.MatchString(e.Filename) || strings.Contains(e.Filename, "..") {
+		return ""
+	}
+	return path.Join(sdkExamplesDir, dir, e.Filename)
+}
+
+// processSDKExamples writes usage examples of what a library pull request
+// adds, in the languages named by args or those of its changed source
+// files, and posts them on the pull request. With --commit it also adds
+// them under examples/ on the pull request's branch.
+func (b *Bot) processSDKExamples(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, number := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	if !issue.IsPullRequest() {
+		b.postComment(ctx, client, repoOwner, repoName, number, fmt.Sprintf("`%s` works on pull requests. Comment it on the pull request whose feature needs examples.", CommandSDKExamples))
+		return
+	}
+	log.Printf("Processing '%s' for pull request #%d in %s/%s", CommandSDKExamples, number, repoOwner, repoName)
+	fail := func(reason string, err error) {
+		b.reportFailure(ctx, client, repoOwner, repoName, number, "write SDK examples", reason, err)
+	}
+
+	commit := slices.Contains(args, "--commit")
+	var languages []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") && !slices.Contains(languages, arg) {
+			languages = append(languages, arg)
+		}
+	}
+
+	pr, _, err := client.PullRequests.Get(ctx, repoOwner, repoName, number)
+	if err != nil {
+		fail("Could not read the pull request", err)
+		return
+	}
+	files, err := listPullRequestFiles(ctx, client, repoOwner, repoName, number)
+	if err != nil {
+		fail("Could not list the changed files", err)
+		return
+	}
+	if len(languages) == 0 {
+		languages = changedLanguages(files)
+	}
+	if len(languages) > maxExampleLanguages {
+		languages = languages[:maxExampleLanguages]
+	}
+	repoContext, err := b.resolveRepoContext(ctx, client, repo)
+	if err != nil {
+		log.Printf("Could not read the README of %s for SDK examples: %v", repo.GetFullName(), err)
+		repoContext = "(The README could not be read.)"
+	}
+	examples, err := generateSDKExamples(ctx, b.llm, pr, files, repoContext, languages)
+	if err != nil {
+		fail("Could not write the examples", err)
+		return
+	}
+	if len(examples) == 0 {
+		b.postComment(ctx, client, repoOwner, repoName, number, SDKExamplesIdentifier+"\n\nI found nothing in this pull request that the library's users call, so there are no examples to write.")
+		return
+	}
+
+	var note string
+	if commit {
+		note = commitSDKExamples(ctx, client, repo, pr, examples)
+	}
+	b.postComment(ctx, client, repoOwner, repoName, number, formatSDKExamples(examples, note))
+}
+
+// changedLanguages returns the languages of the source files a pull
+// request changes, most changed files first, leaving out tests.
+func changedLanguages(files []*github.CommitFile) []string {
+	counts := make(map[string]int)
+	var languages []string
+	for _, f := range files {
+		name := f.GetFilename()
+		if f.GetStatus() == "removed" || isTestPath(name) {
+			continue
+		}
+		language := exampleLanguages[path.Ext(name)]
+		if language == "" {
+			continue
+		}
+		if counts[language] == 0 {
+			languages = append(languages, language)
+		}
+		counts[language]++
+	}
+	slices.SortStableFunc(languages, func(a, b string) int { return counts[b] - counts[a] })
+	return languages
+}
+
+// isTestPath reports whether p looks like a test file.
+func isTestPath(p string) bool {
+	base := strings.ToLower(path.Base(p))
+	stem := strings.TrimSuffix(base, path.Ext(base))
+	return strings.HasSuffix(stem, "_test") || strings.HasSuffix(stem, ".test") || strings.HasSuffix(stem, ".spec") ||
+		strings.HasPrefix(stem, "test_") || slices.Contains(strings.Split(path.Dir(p), "/"), "tests")
+}
+
+func generateSDKExamples(ctx context.Context, llm Generator, pr *github.PullRequest, files []*github.CommitFile, repoContext string, languages []string) ([]sdkExample, error) {
+	var diff strings.Builder
+	for _, f := range files {
+		patch := fmt.Sprintf("--- %s (%s)\n%s\n", f.GetFilename(), f.GetStatus(), f.GetPatch())
+		if diff.Len()+len(patch) > maxBreakingPatchBytes {
+			diff.WriteString("(further changes omitted)\n")
+			break
+		}
+		diff.WriteString(patch)
+	}
+	target := "the languages of the library's public code in the diff"
+	if len(languages) > 0 {
+		target = strings.Join(languages, ", ")
+	}
+	prompt := fmt.Sprintf(
+		"Write usage examples of what this pull request adds or changes in a library, for the developers who use the library. "+
+			"Write one short, complete example per language, in: %s. "+
+			"Each example calls the new feature the way a user of the published library would: import it by its public module or package name, set up only what the call needs, and show the result. "+
+			"Use only the API the diff and the README show; don't invent functions or options. "+
+			"Skip a language the library can't be used from, and write no examples when the change adds nothing its users call.\n\n"+
+			"Respond with only a JSON object: {\"examples\": [{\"language\": \"Go\", \"title\": \"what it shows\", \"description\": \"one sentence\", \"filename\": \"a file name for it, e.g. stream_export.go\", \"code\": \"the example\"}]}.\n\n"+
+			"**Pull Request:** %s\n\n%s\n\n"+
+			"**Repository Context:**\n%s\n\n"+
+			"**Diff:**\n```diff\n%s```",
+		target, pr.GetTitle(), pr.GetBody(), repoContext, diff.String(),
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, CommandSDKExamples), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write the examples: %w", err)
+	}
+	var result struct {
+		Examples []sdkExample `json:"examples"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(result.Examples, func(e sdkExample) bool {
+		return strings.TrimSpace(e.Code) == "" || strings.TrimSpace(e.Language) == ""
+	}), nil
+}
+
+// commitSDKExamples adds the examples under examples/ on the branch of pr,
+// returning a note on what it committed for the comment.
+func commitSDKExamples(ctx context.Context, client *github.Client, repo *github.Repository, pr *github.PullRequest, examples []sdkExample) string {
+	owner, name, branch := repo.GetOwner().GetLogin(), repo.GetName(), pr.GetHead().GetRef()
+	if head := pr.GetHead().GetRepo(); head != nil && head.GetFullName() != repo.GetFullName() {
+		return "_I couldn't add the examples to the branch of this pull request, because it comes from a fork._"
+	}
+	var committed, failed []string
+	for _, e := range examples {
+		p := e.path()
+		if p == "" {
+			failed = append(failed, fmt.Sprintf("%s (the file name %q isn't usable)", e.Language, e.Filename))
+			continue
+		}
+		opts := &github.RepositoryContentFileOptions{
+			Message: github.String(fmt.Sprintf("docs: Add the %s example of #%d", e.Language, pr.GetNumber())),
+			Content: []byte(strings.TrimRight(e.Code, "\n") + "\n"),
+			Branch:  github.String(branch),
+		}
+		if existing, _, _, err := client.Repositories.GetContents(ctx, owner, name, p, &github.RepositoryContentGetOptions{Ref: branch}); err == nil && existing != nil {
+			opts.SHA = existing.SHA
+		}
+		if _, _, err := client.Repositories.CreateFile(ctx, owner, name, p, opts); err != nil {
+			log.Printf("Error committing %s to %s of %s: %v", p, branch, repo.GetFullName(), err)
+			failed = append(failed, fmt.Sprintf("`%s`", p))
+			continue
+		}
+		committed = append(committed, fmt.Sprintf("`%s`", p))
+	}
+	var notes []string
+	if len(committed) > 0 {
+		notes = append(notes, fmt.Sprintf("_I added %s to the branch of this pull request._", strings.Join(committed, ", ")))
+	}
+	if len(failed) > 0 {
+		notes = append(notes, fmt.Sprintf("_I couldn't commit %s._", strings.Join(failed, ", ")))
+	}
+	return strings.Join(notes, "\n")
+}
+
+func formatSDKExamples(examples []sdkExample, note string) string {
+	var sb strings.Builder
+	sb.WriteString(SDKExamplesIdentifier + "\n\nHere is how the library's users can call what this pull request adds. Check that the examples run before publishing them.\n")
+	for _, e := range examples {
+		fmt.Fprintf(&sb, "\n#### %s", e.Language)
+		if e.Title != "" {
+			sb.WriteString(": " + e.Title)
+		}
+		sb.WriteString("\n\n")
+		if e.Description != "" {
+			sb.WriteString(e.Description + "\n\n")
+		}
+		fmt.Fprintf(&sb, "```%s\n%s\n```\n", codeFenceLanguage(e.Language), strings.TrimRight(e.Code, "\n"))
+	}
+	if note != "" {
+		sb.WriteString("\n" + note + "\n")
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+// codeFenceLanguage is the info string of a code block in language.
+func codeFenceLanguage(language string) string {
+	switch l := strings.ToLower(language); l {
+	case "c#":
+		return "csharp"
+	case "c++":
+		return "cpp"
+	default:
+		return strings.ReplaceAll(l, " ", "")
+	}
+}
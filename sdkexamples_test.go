@@ -0,0 +1,111 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const sdkExamplesReply = "```json\n" + `{"examples": [
+  {"language": "Go", "title": "Stream an export", "description": "Writes rows as they are read.", "filename": "stream_export.go", "code": "package main\n\nfunc main() {}\n"},
+  {"language": "Python", "title": "Stream an export", "filename": "../escape.py", "code": "import widgets\n"}
+]}` + "\n```"
+
+func TestSDKExamplesPostsExamples(t *testing.T) {
+	env := newTestEnv(t)
+	number := env.github.addPull("acme", "widgets", "Streamed CSV exports")
+	seedPullFiles(env, number, map[string][2]string{
+		"export/csv.go":      {baseCSV, headCSV},
+		"export/csv_test.go": {"package export", "package export\n\nfunc TestNew() {}"},
+		"README.md":          {"Widgets", "Widgets export"},
+	})
+	env.gemini.on("Write usage examples", sdkExamplesReply)
+
+	env.commentOnPull(t, number, "@prd-bot need_sdk_examples")
+
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 {
+		t.Fatalf("expected one comment, got %d", len(comments))
+	}
+	body := comments[0].GetBody()
+	for _, want := range []string{SDKExamplesIdentifier, "#### Go: Stream an export\n\nWrites rows as they are read.\n\n```go\npackage main\n\nfunc main() {}\n```", "#### Python: Stream an export\n\n```python\nimport widgets\n```"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the comment should contain %q:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "branch") {
+		t.Errorf("nothing should be committed without --commit:\n%s", body)
+	}
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "per language, in: Go.") || !strings.Contains(prompt, "Streamed CSV exports") {
+		t.Errorf("the prompt should name the languages of the changed code:\n%s", prompt)
+	}
+}
+
+func TestSDKExamplesCommitsToBranch(t *testing.T) {
+	env := newTestEnv(t)
+	number := env.github.addPull("acme", "widgets", "Streamed CSV exports")
+	seedPullFiles(env, number, map[string][2]string{"export/csv.go": {baseCSV, headCSV}})
+	env.github.mu.Lock()
+	env.github.pulls[number-1].Head.Ref = github.String("feature/stream")
+	env.github.mu.Unlock()
+	env.gemini.on("Write usage examples", sdkExamplesReply)
+
+	env.commentOnPull(t, number, "@prd-bot need_sdk_examples Go Python --commit")
+
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "per language, in: Go, Python.") {
+		t.Errorf("the prompt should name the requested languages:\n%s", prompt)
+	}
+	content, ok := env.github.committed("acme", "widgets", "feature/stream", "examples/go/stream_export.go")
+	if !ok || content != "package main\n\nfunc main() {}\n" {
+		t.Errorf("the Go example should be committed, got %q", content)
+	}
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 {
+		t.Fatalf("expected one comment, got %d", len(comments))
+	}
+	body := comments[0].GetBody()
+	if !strings.Contains(body, "_I added `examples/go/stream_export.go` to the branch of this pull request._") ||
+		!strings.Contains(body, `_I couldn't commit Python (the file name "../escape.py" isn't usable)._`) {
+		t.Errorf("unexpected comment:\n%s", body)
+	}
+}
+
+func TestSDKExamplesNothingToShow(t *testing.T) {
+	env := newTestEnv(t)
+	number := env.github.addPull("acme", "widgets", "Fix a typo")
+	seedPullFiles(env, number, map[string][2]string{"README.md": {"Wigdets", "Widgets"}})
+	env.gemini.on("Write usage examples", `{"examples": []}`)
+
+	env.commentOnPull(t, number, "@prd-bot need_sdk_examples")
+
+	comments := env.github.issueComments("acme", "widgets", number)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "no examples to write") {
+		t.Errorf("unexpected comments: %v", comments)
+	}
+}
+
+func TestSDKExamplesOnIssue(t *testing.T) {
+	env := newTestEnv(t)
+	env.comment(t, "@prd-bot need_sdk_examples")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 || !strings.Contains(comments[0].GetBody(), "works on pull requests") {
+		t.Errorf("unexpected comments: %v", comments)
+	}
+}
+
+func TestChangedLanguages(t *testing.T) {
+	var files []*github.CommitFile
+	for name, status := range map[string]string{
+		"client.py": "modified", "api.py": "added", "lib/index.ts": "modified",
+		"tests/test_api.py": "added", "lib/index.spec.ts": "added", "old.go": "removed", "README.md": "modified",
+	} {
+		files = append(files, &github.CommitFile{Filename: github.String(name), Status: github.String(status)})
+	}
+	slices.SortFunc(files, func(a, b *github.CommitFile) int { return strings.Compare(a.GetFilename(), b.GetFilename()) })
+	if got := changedLanguages(files); !slices.Equal(got, []string{"Python", "TypeScript"}) {
+		t.Errorf("changedLanguages = %q", got)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+// errNoSecretBox reports that a secret can't be stored because
+// STORE_ENCRYPTION_KEY is not set.
+var errNoSecretBox = errors.New("secret storage is disabled: set STORE_ENCRYPTION_KEY to enable it")
+
+// secretBox encrypts secrets kept in the store with AES-256-GCM.
+type secretBox struct {
+	aead cipher.AEAD
+}
+
+// newSecretBox returns a box for key, the base64 encoding of 32 random
+// bytes (e.g. `openssl rand -base64 32`).
+func newSecretBox(key string) (*secretBox, error) {
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("decoding the key: %w", err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("the key is %d bytes long, expected 32", len(raw))
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretBox{aead: aead}, nil
+}
+
+// seal encrypts plaintext bound to label, e.g. the store key the secret is
+// saved under, so a sealed value copied elsewhere doesn't open.
+func (s *secretBox) seal(plaintext, label string) (string, error) {
+	if s == nil {
+		return "", errNoSecretBox
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plaintext), []byte(label))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts a value sealed with the same label.
+func (s *secretBox) open(sealed, label string) (string, error) {
+	if s == nil {
+		return "", errNoSecretBox
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return "", errors.New("malformed sealed secret")
+	}
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte(label))
+	if err != nil {
+		return "", fmt.Errorf("decrypting secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// encryptedBuckets hold webhook payloads, which carry issue and comment text
+// and are encrypted at rest when STORE_ENCRYPTION_KEY is set. Installation
+// API keys are sealed field by field, so they stay encrypted without it.
+var encryptedBuckets = []string{bucketWebhooks, bucketDeadLetters}
+
+// sealedDocument is how an encrypted document is kept in the underlying store.
+type sealedDocument struct {
+	Sealed string `json:"$sealed"`
+}
+
+// encryptedStore encrypts the documents of encryptedBuckets before they
+// reach the underlying store. Documents stored before encryption was enabled
+// are still read; sealExisting encrypts them.
+type encryptedStore struct {
+	Store
+	box *secretBox
+}
+
+func storeLabel(bucket, key string) string {
+	return bucket + "/" + key
+}
+
+// open returns the JSON document stored as raw, decrypting it when sealed.
+func (s *encryptedStore) open(bucket, key string, raw json.RawMessage) (json.RawMessage, bool, error) {
+	var doc sealedDocument
+	if json.Unmarshal(raw, &doc) != nil || doc.Sealed == "" {
+		return raw, false, nil
+	}
+	plaintext, err := s.box.open(doc.Sealed, storeLabel(bucket, key))
+	if err != nil {
+		return nil, true, fmt.Errorf("decrypting %s/%s: %w", bucket, key, err)
+	}
+	return json.RawMessage(plaintext), true, nil
+}
+
+func (s *encryptedStore) Get(bucket, key string, v any) (bool, error) {
+	if !slices.Contains(encryptedBuckets, bucket) {
+		return s.Store.Get(bucket, key, v)
+	}
+	var raw json.RawMessage
+	found, err := s.Store.Get(bucket, key, &raw)
+	if !found || err != nil {
+		return found, err
+	}
+	data, _, err := s.open(bucket, key, raw)
+	if err != nil {
+		return true, err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (s *encryptedStore) Put(bucket, key string, v any) error {
+	if !slices.Contains(encryptedBuckets, bucket) {
+		return s.Store.Put(bucket, key, v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s/%s: %w", bucket, key, err)
+	}
+	sealed, err := s.box.seal(string(data), storeLabel(bucket, key))
+	if err != nil {
+		return fmt.Errorf("encrypting %s/%s: %w", bucket, key, err)
+	}
+	return s.Store.Put(bucket, key, sealedDocument{Sealed: sealed})
+}
+
+func (s *encryptedStore) List(bucket string) (map[string]json.RawMessage, error) {
+	docs, err := s.Store.List(bucket)
+	if err != nil || !slices.Contains(encryptedBuckets, bucket) {
+		return docs, err
+	}
+	for key, raw := range docs {
+		data, _, err := s.open(bucket, key, raw)
+		if err != nil {
+			log.Printf("Skipping unreadable document: %v", err)
+			delete(docs, key)
+			continue
+		}
+		docs[key] = data
+	}
+	return docs, nil
+}
+
+// sealExisting encrypts the documents of encryptedBuckets stored in clear
+// text, e.g. before STORE_ENCRYPTION_KEY was set, and returns how many it
+// encrypted.
+func (s *encryptedStore) sealExisting() (int, error) {
+	sealed := 0
+	for _, bucket := range encryptedBuckets {
+		docs, err := s.Store.List(bucket)
+		if err != nil {
+			return sealed, err
+		}
+		for key, raw := range docs {
+			if _, encrypted, _ := s.open(bucket, key, raw); encrypted {
+				continue
+			}
+			if err := s.Put(bucket, key, raw); err != nil {
+				return sealed, err
+			}
+			sealed++
+		}
+	}
+	return sealed, nil
+}
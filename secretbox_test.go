@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestSecretBox(t *testing.T) {
+	box, err := newSecretBox(testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := box.seal("AIza-secret", "apikeys/7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "AIza") {
+		t.Errorf("the sealed value leaks the secret: %s", sealed)
+	}
+	if got, err := box.open(sealed, "apikeys/7"); err != nil || got != "AIza-secret" {
+		t.Errorf("open = %q, %v", got, err)
+	}
+	if _, err := box.open(sealed, "apikeys/8"); err == nil {
+		t.Error("a secret sealed for another label should not open")
+	}
+	if _, err := newSecretBox("c2hvcnQ="); err == nil {
+		t.Error("a short key should be rejected")
+	}
+	var disabled *secretBox
+	if _, err := disabled.seal("x", "y"); err != errNoSecretBox {
+		t.Errorf("seal without a key = %v, want errNoSecretBox", err)
+	}
+}
+
+func TestEncryptedStore(t *testing.T) {
+	box, err := newSecretBox(testEncryptionKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := newMemoryStore()
+	legacy := queuedJob{ID: "old", Event: "issues", Payload: json.RawMessage(`{"body":"legacy secret"}`)}
+	if err := inner.Put(bucketWebhooks, "old", legacy); err != nil {
+		t.Fatal(err)
+	}
+	store := &encryptedStore{Store: inner, box: box}
+	if n, err := store.sealExisting(); n != 1 || err != nil {
+		t.Fatalf("sealExisting = %d, %v", n, err)
+	}
+	job := queuedJob{ID: "new", Event: "issue_comment", Payload: json.RawMessage(`{"body":"top secret"}`)}
+	if err := store.Put(bucketWebhooks, "new", job); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(bucketArtifacts, "plain", map[string]string{"body": "public"}); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, _ := inner.List(bucketWebhooks)
+	for key, doc := range raw {
+		if strings.Contains(string(doc), "secret") || !strings.Contains(string(doc), "$sealed") {
+			t.Errorf("webhook %s is stored in clear text: %s", key, doc)
+		}
+	}
+	if plain, _ := inner.List(bucketArtifacts); !strings.Contains(string(plain["plain"]), "public") {
+		t.Errorf("other buckets should not be encrypted: %s", plain["plain"])
+	}
+
+	var got queuedJob
+	if found, err := store.Get(bucketWebhooks, "new", &got); !found || err != nil || string(got.Payload) != `{"body":"top secret"}` {
+		t.Errorf("Get = %+v, %v, %v", got, found, err)
+	}
+	docs, err := store.List(bucketWebhooks)
+	if err != nil || len(docs) != 2 || !strings.Contains(string(docs["old"]), "legacy secret") {
+		t.Errorf("List = %v, %v", docs, err)
+	}
+	if n, _ := store.sealExisting(); n != 0 {
+		t.Errorf("sealed documents should not be sealed again, sealed %d", n)
+	}
+
+	other, _ := newSecretBox("ZmVkY2JhOTg3NjU0MzIxMGZlZGNiYTk4NzY1NDMyMTA=")
+	if _, err := (&encryptedStore{Store: inner, box: other}).Get(bucketWebhooks, "new", &got); err == nil {
+		t.Error("documents should not decrypt with another key")
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// serverConfigPollInterval is how often the server config file is checked
+// for changes.
+var serverConfigPollInterval = 10 * time.Second
+
+// ServerConfig holds the server-wide settings that can be changed at runtime
+// by editing the file named by SERVER_CONFIG_PATH, without a restart.
+type ServerConfig struct {
+	// Model overrides the model used for generation; empty keeps the one
+	// configured at startup.
+	Model string `yaml:"model"`
+	// DisabledCommands lists commands disabled on every repository.
+	DisabledCommands []string `yaml:"disabled_commands"`
+	// RateLimit bounds how many commands each repository may run.
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+	// Data controls what the bot stores and how long it keeps it.
+	Data DataConfig `yaml:"data"`
+	// Budget caps each installation's monthly model usage.
+	Budget BudgetConfig `yaml:"budget"`
+	// Webhooks receive the bot's lifecycle events.
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+}
+
+// RateLimitConfig limits command usage per repository. Zero means unlimited.
+type RateLimitConfig struct {
+	CommandsPerHour int `yaml:"commands_per_hour"`
+}
+
+// loadServerConfig reads and validates the server config file.
+func loadServerConfig(path string) (*ServerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &ServerConfig{}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid server config %s: %w", path, err)
+	}
+	if cfg.RateLimit.CommandsPerHour < 0 {
+		return nil, fmt.Errorf("invalid server config %s: rate_limit.commands_per_hour must not be negative", path)
+	}
+	if err := cfg.Data.validate(); err != nil {
+		return nil, fmt.Errorf("invalid server config %s: %w", path, err)
+	}
+	if err := cfg.Budget.validate(); err != nil {
+		return nil, fmt.Errorf("invalid server config %s: %w", path, err)
+	}
+	for i, hook := range cfg.Webhooks {
+		if err := hook.validate(); err != nil {
+			return nil, fmt.Errorf("invalid server config %s: webhooks[%d]: %w", path, i, err)
+		}
+	}
+	return cfg, nil
+}
+
+// serverConfig returns the settings currently in effect.
+func (b *Bot) serverConfig() *ServerConfig {
+	return b.settings.Load()
+}
+
+// applyServerConfig makes cfg the settings in effect and updates the model.
+func (b *Bot) applyServerConfig(cfg *ServerConfig) {
+	b.settings.Store(cfg)
+	if g, ok := b.llm.(interface{ SetModel(string) }); ok {
+		g.SetModel(cfg.Model)
+	}
+}
+
+// reloadServerConfig loads path and applies it, keeping the current settings
+// when the file is invalid.
+func (b *Bot) reloadServerConfig(path string) error {
+	cfg, err := loadServerConfig(path)
+	if err != nil {
+		return err
+	}
+	b.applyServerConfig(cfg)
+	log.Printf("Loaded server config from %s (model %q, %d disabled commands, %d commands/hour per repository).",
+		path, cfg.Model, len(cfg.DisabledCommands), cfg.RateLimit.CommandsPerHour)
+	return nil
+}
+
+// watchServerConfig reloads the server config on SIGHUP and whenever the
+// file's modification time changes, until ctx is cancelled.
+func (b *Bot) watchServerConfig(ctx context.Context, path string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	ticker := time.NewTicker(serverConfigPollInterval)
+	defer ticker.Stop()
+
+	var lastMod time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastMod = info.ModTime()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			log.Printf("Received SIGHUP, reloading server config.")
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastMod) {
+				continue
+			}
+			lastMod = info.ModTime()
+		}
+		if err := b.reloadServerConfig(path); err != nil {
+			log.Printf("Keeping the previous server config: %v", err)
+		}
+	}
+}
+
+// rateLimiter counts recent commands per repository in a sliding one-hour window.
+type rateLimiter struct {
+	mu     sync.Mutex
+	now    func() time.Time
+	recent map[string][]time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{now: time.Now, recent: make(map[string][]time.Time)}
+}
+
+// allow records a command for key and reports whether it is within limit
+// commands per hour. A limit of zero or less allows everything.
+func (l *rateLimiter) allow(key string, limit int) bool {
+	if limit <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	cutoff := now.Add(-time.Hour)
+	recent := slices.DeleteFunc(l.recent[key], func(t time.Time) bool { return !t.After(cutoff) })
+	if len(recent) >= limit {
+		l.recent[key] = recent
+		return false
+	}
+	l.recent[key] = append(recent, now)
+	return true
+}
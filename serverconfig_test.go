@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerConfigReload(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+"\n\nPRD")
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Task")
+	path := filepath.Join(t.TempDir(), "server.yml")
+
+	if err := os.WriteFile(path, []byte("model: gemini-1.5-pro\ndisabled_commands: [explain]\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.bot.reloadServerConfig(path); err != nil {
+		t.Fatalf("reloadServerConfig: %v", err)
+	}
+	env.comment(t, "@prd-bot explain")
+	env.comment(t, "@prd-bot need_sub_task")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 3 || !strings.Contains(comments[1].GetBody(), "`explain` command is currently disabled") {
+		t.Fatalf("expected explain to be refused, got %d comments", len(comments))
+	}
+	if models := env.gemini.receivedModels(); len(models) != 1 || models[0] != "gemini-1.5-pro" {
+		t.Errorf("models = %q, want the reloaded model", models)
+	}
+
+	if err := os.WriteFile(path, []byte("rate_limit: {commands_per_hour: -1}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.bot.reloadServerConfig(path); err == nil {
+		t.Error("expected an invalid config to be rejected")
+	}
+	if cfg := env.bot.serverConfig(); cfg.Model != "gemini-1.5-pro" {
+		t.Errorf("invalid config replaced the settings: %+v", cfg)
+	}
+}
+
+func TestWatchServerConfigPicksUpFileChanges(t *testing.T) {
+	defer func(old time.Duration) { serverConfigPollInterval = old }(serverConfigPollInterval)
+	serverConfigPollInterval = 10 * time.Millisecond
+	env := newTestEnv(t)
+	path := filepath.Join(t.TempDir(), "server.yml")
+	if err := os.WriteFile(path, []byte("model: a\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Backdate the file so the rewrite below changes its modification time
+	// even on coarse-grained filesystems.
+	if err := os.Chtimes(path, time.Now(), time.Now().Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go env.bot.watchServerConfig(ctx, path)
+	time.Sleep(50 * time.Millisecond)
+
+	if err := os.WriteFile(path, []byte("rate_limit: {commands_per_hour: 5}\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for env.bot.serverConfig().RateLimit.CommandsPerHour != 5 {
+		if time.Now().After(deadline) {
+			t.Fatal("server config was not reloaded after the file changed")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := newRateLimiter()
+	l.now = func() time.Time { return now }
+
+	if !l.allow("acme/widgets", 2) || !l.allow("acme/widgets", 2) {
+		t.Fatal("first two commands should be allowed")
+	}
+	if l.allow("acme/widgets", 2) {
+		t.Error("third command within the hour should be refused")
+	}
+	if !l.allow("acme/gadgets", 2) {
+		t.Error("limits should be per repository")
+	}
+	now = now.Add(time.Hour + time.Second)
+	if !l.allow("acme/widgets", 2) {
+		t.Error("commands older than an hour should not count")
+	}
+	if !l.allow("acme/widgets", 0) {
+		t.Error("a zero limit should allow everything")
+	}
+}
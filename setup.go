@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// bucketSetup holds the GitHub App created through /setup under
+	// setupAppKey, its secrets sealed with STORE_ENCRYPTION_KEY.
+	bucketSetup = "setup"
+	setupAppKey = "app"
+
+	// setupStateTTL is how long a manifest sent to GitHub can be completed.
+	setupStateTTL = time.Hour
+
+	defaultSetupAppName = "agent-prd"
+)
+
+// setupPermissions and setupEvents are what the App created through /setup
+// asks for: what the commands read and write, and the events they handle.
+var (
+	setupPermissions = map[string]string{
+		"contents":      "write",
+		"issues":        "write",
+		"pull_requests": "write",
+		"metadata":      "read",
+	}
+	setupEvents = []string{"issues", "issue_comment", "push", "pull_request", "pull_request_review_comment", "sub_issues"}
+)
+
+// appManifest is a GitHub App manifest:
+// https://docs.github.com/apps/sharing-github-apps/registering-a-github-app-from-a-manifest
+type appManifest struct {
+	Name           string            `json:"name"`
+	URL            string            `json:"url"`
+	HookAttributes map[string]any    `json:"hook_attributes"`
+	RedirectURL    string            `json:"redirect_url"`
+	Public         bool              `json:"public"`
+	Permissions    map[string]string `json:"default_permissions"`
+	Events         []string          `json:"default_events"`
+}
+
+// newAppManifest describes the App of a bot served at publicURL.
+func newAppManifest(publicURL, name string) appManifest {
+	return appManifest{
+		Name:           name,
+		URL:            publicURL,
+		HookAttributes: map[string]any{"url": publicURL + "/webhook", "active": true},
+		RedirectURL:    publicURL + "/setup/callback",
+		Permissions:    setupPermissions,
+		Events:         setupEvents,
+	}
+}
+
+// storedApp is the GitHub App created through /setup.
+type storedApp struct {
+	ID        int64     `json:"id"`
+	Slug      string    `json:"slug"`
+	HTMLURL   string    `json:"html_url"`
+	ClientID  string    `json:"client_id"`
+	Sealed    string    `json:"sealed"` // the JSON of appSecrets
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// appSecrets are the credentials GitHub returns once for a new App.
+type appSecrets struct {
+	PrivateKey    string `json:"private_key"` // PEM
+	WebhookSecret string `json:"webhook_secret"`
+	ClientSecret  string `json:"client_secret"`
+}
+
+func setupAppLabel() string {
+	return bucketSetup + "/" + setupAppKey
+}
+
+// saveSetupApp seals the credentials of a newly created App into store.
+func saveSetupApp(store Store, box *secretBox, app *github.AppConfig) error {
+	secrets, err := json.Marshal(appSecrets{PrivateKey: app.GetPEM(), WebhookSecret: app.GetWebhookSecret(), ClientSecret: app.GetClientSecret()})
+	if err != nil {
+		return err
+	}
+	sealed, err := box.seal(string(secrets), setupAppLabel())
+	if err != nil {
+		return err
+	}
+	record := storedApp{ID: app.GetID(), Slug: app.GetSlug(), HTMLURL: app.GetHTMLURL(), ClientID: app.GetClientID(), Sealed: sealed, CreatedAt: time.Now().UTC()}
+	return store.Put(bucketSetup, setupAppKey, &record)
+}
+
+// loadSetupApp returns the App created through /setup and its credentials,
+// or nil when there is none.
+func loadSetupApp(store Store, box *secretBox) (*storedApp, *appSecrets, error) {
+	var app storedApp
+	found, err := store.Get(bucketSetup, setupAppKey, &app)
+	if err != nil || !found {
+		return nil, nil, err
+	}
+	plaintext, err := box.open(app.Sealed, setupAppLabel())
+	if err != nil {
+		return nil, nil, err
+	}
+	var secrets appSecrets
+	if err := json.Unmarshal([]byte(plaintext), &secrets); err != nil {
+		return nil, nil, fmt.Errorf("decoding the App credentials: %w", err)
+	}
+	return &app, &secrets, nil
+}
+
+// applySetupApp takes the GitHub App settings from the App created through
+// /setup. A webhook secret or App name configured otherwise is kept.
+func (c *StartupConfig) applySetupApp(app *storedApp, secrets *appSecrets) {
+	c.AppID = app.ID
+	c.AppPrivateKey = base64.StdEncoding.EncodeToString([]byte(secrets.PrivateKey))
+	c.sources["GITHUB_APP_ID"], c.sources["GITHUB_APP_PRIVATE_KEY"] = sourceSetup, sourceSetup
+	if c.AppName == "" {
+		c.AppName = app.Slug
+		c.sources["GITHUB_APP_NAME"] = sourceSetup
+	}
+	if c.WebhookSecret == "" {
+		c.WebhookSecret = secrets.WebhookSecret
+		c.sources["GITHUB_WEBHOOK_SECRET"] = sourceSetup
+	}
+}
+
+// appSetup serves the GitHub App manifest flow, which creates the bot's App
+// on GitHub and hands its credentials back, until it completes.
+type appSetup struct {
+	publicURL string
+	webURL    string // the GitHub the App is created on
+	token     string // authorizes /setup; logged at startup
+	store     Store
+	secrets   *secretBox
+	api       *github.Client // completes the handshake; unauthenticated
+
+	mu     sync.Mutex
+	states map[string]time.Time // manifests sent to GitHub, by state
+	done   chan struct{}        // closed once the App is stored
+}
+
+func newAppSetup(publicURL string, server githubServer, store Store, secrets *secretBox) *appSetup {
+	return &appSetup{
+		publicURL: strings.TrimRight(publicURL, "/"),
+		webURL:    server.webURL(),
+		token:     randomString(),
+		store:     store,
+		secrets:   secrets,
+		api:       server.newClient(nil),
+		states:    make(map[string]time.Time),
+		done:      make(chan struct{}),
+	}
+}
+
+func (s *appSetup) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /setup", s.handleSetup)
+	mux.HandleFunc("GET /setup/callback", s.handleCallback)
+}
+
+// handleSetup renders a form posting the App manifest to GitHub, under the
+// user's account or, with ?org=, an organization:
+// GET /setup?token=...&org=...&name=...
+func (s *appSetup) handleSetup(w http.ResponseWriter, r *http.Request) {
+	if subtle.ConstantTimeCompare([]byte(r.URL.Query().Get("token")), []byte(s.token)) != 1 {
+		http.Error(w, "Forbidden: open the /setup link printed in the bot's log", http.StatusForbidden)
+		return
+	}
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = defaultSetupAppName
+	}
+	manifest, err := json.Marshal(newAppManifest(s.publicURL, name))
+	if err != nil {
+		http.Error(w, "Error encoding the manifest", http.StatusInternalServerError)
+		return
+	}
+	state := randomString()
+	s.mu.Lock()
+	for old, created := range s.states {
+		if time.Since(created) > setupStateTTL {
+			delete(s.states, old)
+		}
+	}
+	s.states[state] = time.Now()
+	s.mu.Unlock()
+
+	action := s.webURL + "/settings/apps/new"
+	if org := r.URL.Query().Get("org"); org != "" {
+		action = s.webURL + "/organizations/" + url.PathEscape(org) + "/settings/apps/new"
+	}
+	page := setupPage{Action: action + "?state=" + url.QueryEscape(state), Manifest: string(manifest), Name: name, PublicURL: s.publicURL}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := setupTemplates.ExecuteTemplate(w, "setup", page); err != nil {
+		log.Printf("Error rendering the setup page: %v", err)
+	}
+}
+
+// handleCallback completes the manifest flow GitHub redirects back to with a
+// temporary code, and stores the new App's credentials.
+func (s *appSetup) handleCallback(w http.ResponseWriter, r *http.Request) {
+	state, code := r.URL.Query().Get("state"), r.URL.Query().Get("code")
+	s.mu.Lock()
+	created, ok := s.states[state]
+	delete(s.states, state)
+	s.mu.Unlock()
+	if !ok || time.Since(created) > setupStateTTL || code == "" {
+		http.Error(w, "Unknown or expired setup: start again from /setup", http.StatusBadRequest)
+		return
+	}
+
+	app, _, err := s.api.Apps.CompleteAppManifest(r.Context(), code)
+	if err != nil {
+		log.Printf("Error completing the GitHub App manifest: %v", err)
+		http.Error(w, "GitHub didn't return the App's credentials. Start again from /setup.", http.StatusBadGateway)
+		return
+	}
+	if err := saveSetupApp(s.store, s.secrets, app); err != nil {
+		log.Printf("Error storing the credentials of the GitHub App %s: %v", app.GetSlug(), err)
+		http.Error(w, "Error storing the App's credentials", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Created the GitHub App %s (%d) through /setup.", app.GetSlug(), app.GetID())
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page := setupPage{Name: app.GetSlug(), AppURL: app.GetHTMLURL(), PublicURL: s.publicURL}
+	if err := setupTemplates.ExecuteTemplate(w, "done", page); err != nil {
+		log.Printf("Error rendering the setup page: %v", err)
+	}
+	s.mu.Lock()
+	select {
+	case <-s.done:
+	default:
+		close(s.done)
+	}
+	s.mu.Unlock()
+}
+
+// runAppSetup serves the manifest flow on port until an App is created, so
+// the bot can start with its credentials.
+func runAppSetup(port, publicURL string, gh githubServer, store Store, secrets *secretBox) error {
+	setup := newAppSetup(publicURL, gh, store, secrets)
+	mux := http.NewServeMux()
+	setup.register(mux)
+	server := &http.Server{Addr: ":" + port, Handler: mux}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	log.Printf("No GitHub credentials are configured. Open %s/setup?token=%s to create the GitHub App.", setup.publicURL, setup.token)
+
+	select {
+	case err := <-errs:
+		return err
+	case <-setup.done:
+	}
+	// Shutdown lets the callback page finish.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+type setupPage struct {
+	Action    string // where the manifest is posted
+	Manifest  string
+	Name      string
+	AppURL    string
+	PublicURL string
+}
+
+var setupTemplates = template.Must(template.New("setup").Parse(`{{define "head"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PRD bot setup</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2rem; color: #1f2328; max-width: 48rem; }
+code { background: #f6f8fa; padding: .1rem .3rem; }
+</style>
+</head>
+<body>
+<h1>PRD bot setup</h1>
+{{end}}
+{{define "setup"}}{{template "head"}}
+<p>This creates a GitHub App named <code>{{.Name}}</code> that sends its webhooks to <code>{{.PublicURL}}/webhook</code>, with write access to issues, pull requests and contents. GitHub asks you to confirm before creating it.</p>
+<form action="{{.Action}}" method="post">
+<input type="hidden" name="manifest" value="{{.Manifest}}">
+<button type="submit">Create the GitHub App</button>
+</form>
+</body>
+</html>
+{{end}}
+{{define "done"}}{{template "head"}}
+<p>The GitHub App <code>{{.Name}}</code> was created and its credentials are stored. The bot is starting with them.</p>
+<p>Next, <a href="{{.AppURL}}/installations/new">install the App</a> on the repositories it should work on.</p>
+</body>
+</html>
+{{end}}`))
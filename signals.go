@@ -0,0 +1,325 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// ProductSignalsIdentifier starts the weekly digest of new issues.
+	ProductSignalsIdentifier = "### Product Signals"
+
+	// bucketSignals records when each repository, by "owner/repo", last got
+	// its digest.
+	bucketSignals = "signals"
+
+	promptSignals = "product_signals"
+
+	signalsCheckInterval = time.Hour
+	// signalsWindow is the period each digest covers.
+	signalsWindow = 7 * 24 * time.Hour
+	// maxSignalIssues caps the issues a digest clusters.
+	maxSignalIssues = 200
+	// minThemeSimilarity is how similar to the first issue of a theme the
+	// embedding of another issue must be to join it.
+	minThemeSimilarity = 0.8
+	// maxSignalExcerpt caps the part of each issue body the model reads.
+	maxSignalExcerpt = 300
+)
+
+// ProductSignalsConfig posts a weekly digest of the repository's new issues,
+// grouped by theme, for product planning.
+type ProductSignalsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Day is the day of the week the digest is posted on, within the
+	// working hours of schedule; Monday by default.
+	Day string `yaml:"day"`
+	// Discussion is the number of the discussion the digest is posted to
+	// as a comment.
+	Discussion int `yaml:"discussion"`
+	// Slack also sends the digest to SLACK_WEBHOOK_URL.
+	Slack bool `yaml:"slack"`
+}
+
+func (c *ProductSignalsConfig) enabled() bool {
+	return c != nil && c.Enabled && (c.Discussion > 0 || c.Slack)
+}
+
+func (c *ProductSignalsConfig) day() time.Weekday {
+	if day, ok := parseWeekday(c.Day); ok {
+		return day
+	}
+	return time.Monday
+}
+
+// signalTheme is a group of similar new issues.
+type signalTheme struct {
+	Name    string
+	Summary string
+	Issues  []*github.Issue
+}
+
+// signalsLoop posts the due digests every interval until ctx is cancelled.
+func (b *Bot) signalsLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			roundCtx, cancel := context.WithTimeout(ctx, interval)
+			b.sendProductSignals(roundCtx, time.Now())
+			cancel()
+		}
+	}
+}
+
+// sendProductSignals posts the digest of each repository whose digest day
+// it is at now and that hasn't had this week's digest yet.
+func (b *Bot) sendProductSignals(ctx context.Context, now time.Time) {
+	repos, err := b.store.List(bucketInstallations)
+	if err != nil {
+		log.Printf("Error listing repositories for product signals: %v", err)
+		return
+	}
+	for fullName, doc := range repos {
+		owner, name, ok := strings.Cut(fullName, "/")
+		if !ok {
+			continue
+		}
+		var installationID int64
+		if err := json.Unmarshal(doc, &installationID); err != nil {
+			log.Printf("Error decoding the installation of %s: %v", fullName, err)
+			continue
+		}
+		var last time.Time
+		if ok, _ := b.store.Get(bucketSignals, fullName, &last); ok && now.Sub(last) < signalsWindow-24*time.Hour {
+			continue
+		}
+		cfg, client := b.reminderConfig(ctx, owner, name)
+		if cfg == nil || !cfg.ProductSignals.enabled() || !cfg.Schedule.open(now) ||
+			now.In(cfg.Schedule.location()).Weekday() != cfg.ProductSignals.day() {
+			continue
+		}
+		// The themes are named, and the issues embedded, on the
+		// installation's key and budget.
+		repoCtx := b.withInstallation(ctx, installationID)
+		if _, ok := b.checkBudget(repoCtx, installationID); !ok {
+			log.Printf("Installation %d is over its monthly budget. Skipping the product signals of %s.", installationID, fullName)
+			continue
+		}
+		if err := b.postProductSignals(repoCtx, client, owner, name, cfg.ProductSignals, now); err != nil {
+			log.Printf("Error posting the product signals of %s: %v", fullName, err)
+			continue
+		}
+		if err := b.store.Put(bucketSignals, fullName, now); err != nil {
+			log.Printf("Error recording the product signals of %s: %v", fullName, err)
+		}
+	}
+}
+
+// postProductSignals posts the digest of the issues opened in owner/repo in
+// the week before now.
+func (b *Bot) postProductSignals(ctx context.Context, client *github.Client, owner, repo string, cfg *ProductSignalsConfig, now time.Time) error {
+	since := now.Add(-signalsWindow)
+	issues, err := b.newIssues(ctx, client, owner, repo, since)
+	if err != nil {
+		return err
+	}
+	if len(issues) == 0 {
+		log.Printf("No new issues in %s this week, skipping the product signals.", owner+"/"+repo)
+		return nil
+	}
+	themes, other := b.groupSignals(ctx, issues)
+	digest := formatProductSignals(owner+"/"+repo, since, now, len(issues), themes, other)
+
+	var link string
+	var errs []error
+	if cfg.Discussion > 0 {
+		if link, err = addDiscussionComment(ctx, client, owner, repo, cfg.Discussion, digest); err != nil {
+			errs = append(errs, fmt.Errorf("commenting on discussion #%d: %w", cfg.Discussion, err))
+		}
+	}
+	if cfg.Slack {
+		if b.slack == nil {
+			log.Printf("Product signals for %s/%s are configured for Slack, but SLACK_WEBHOOK_URL is not set.", owner, repo)
+		} else if err := b.slack.notify(ctx, formatSlackSignals(owner+"/"+repo, len(issues), themes, other, link)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		log.Printf("Posted the product signals of %s/%s: %d issues in %d themes.", owner, repo, len(issues), len(themes))
+	}
+	return errors.Join(errs...)
+}
+
+// newIssues returns the issues, not pull requests, people opened in
+// owner/repo since since, newest first.
+func (b *Bot) newIssues(ctx context.Context, client *github.Client, owner, repo string, since time.Time) ([]*github.Issue, error) {
+	var issues []*github.Issue
+	opts := &github.IssueListByRepoOptions{State: "all", Sort: "created", Direction: "desc", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := client.Issues.ListByRepo(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("listing the issues of %s/%s: %w", owner, repo, err)
+		}
+		for _, issue := range page {
+			if issue.GetCreatedAt().Before(since) {
+				return issues, nil
+			}
+			if issue.IsPullRequest() || issue.GetUser().GetType() == "Bot" {
+				continue
+			}
+			issues = append(issues, issue)
+			if len(issues) == maxSignalIssues {
+				return issues, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return issues, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// groupSignals groups issues into themes, largest first, and returns them
+// with the issues that fit no theme. Issues are grouped by the similarity of
+// their embeddings or, without embeddings, by the keywords of their titles,
+// and the model names the themes.
+func (b *Bot) groupSignals(ctx context.Context, issues []*github.Issue) ([]signalTheme, []*github.Issue) {
+	similar := func(i, j int) bool {
+		return slices.ContainsFunc(searchKeywords(issues[i].GetTitle()), func(k string) bool {
+			return slices.Contains(searchKeywords(issues[j].GetTitle()), k)
+		})
+	}
+	if embedder, ok := b.llm.(Embedder); ok {
+		texts := make([]string, len(issues))
+		for i, issue := range issues {
+			texts[i] = issue.GetTitle() + "\n\n" + truncateUTF8(issue.GetBody(), maxSignalExcerpt)
+		}
+		vectors, err := embedder.EmbedTexts(ctx, texts)
+		if err == nil && len(vectors) == len(issues) {
+			similar = func(i, j int) bool { return cosineSimilarity(vectors[i], vectors[j]) >= minThemeSimilarity }
+		} else {
+			log.Printf("Could not embed the new issues, grouping them by keywords: %v", err)
+		}
+	}
+
+	// Each issue joins the first group whose first issue it is similar to.
+	var groups [][]int
+	for i := range issues {
+		found := slices.IndexFunc(groups, func(g []int) bool { return similar(g[0], i) })
+		if found < 0 {
+			groups = append(groups, []int{i})
+		} else {
+			groups[found] = append(groups[found], i)
+		}
+	}
+	slices.SortStableFunc(groups, func(a, b []int) int { return cmp.Compare(len(b), len(a)) })
+
+	var themes []signalTheme
+	var other []*github.Issue
+	for _, group := range groups {
+		members := make([]*github.Issue, len(group))
+		for k, i := range group {
+			members[k] = issues[i]
+		}
+		if len(members) == 1 {
+			other = append(other, members[0])
+			continue
+		}
+		themes = append(themes, signalTheme{Name: members[0].GetTitle(), Issues: members})
+	}
+	if len(themes) > 0 {
+		if err := nameSignalThemes(ctx, b.llm, themes); err != nil {
+			log.Printf("Could not name the themes of the new issues, using their first titles: %v", err)
+		}
+	}
+	return themes, other
+}
+
+// nameSignalThemes has the model name and summarize each theme.
+func nameSignalThemes(ctx context.Context, llm Generator, themes []signalTheme) error {
+	var groups strings.Builder
+	for i, theme := range themes {
+		fmt.Fprintf(&groups, "Group %d:\n", i+1)
+		for _, issue := range theme.Issues {
+			fmt.Fprintf(&groups, "- #%d %s: %s\n", issue.GetNumber(), issue.GetTitle(), strings.Join(strings.Fields(truncateUTF8(issue.GetBody(), maxSignalExcerpt)), " "))
+		}
+		groups.WriteString("\n")
+	}
+	prompt := fmt.Sprintf(
+		"The following groups of GitHub issues were opened this week, each group about a similar topic. For each group, name its theme in a few words and summarize in one sentence what users are asking for.\n\n"+
+			"%s"+
+			"Respond with a JSON array with one object per group, in the same order, e.g. [{\"theme\": \"CSV export\", \"summary\": \"Analysts want to open report data in spreadsheets.\"}].",
+		groups.String(),
+	)
+	text, err := llm.GenerateText(withSystemPrompt(ctx, promptSignals), prompt)
+	if err != nil {
+		return err
+	}
+	var named []struct {
+		Theme   string `json:"theme"`
+		Summary string `json:"summary"`
+	}
+	if err := parseModelJSON(text, &named); err != nil {
+		return err
+	}
+	if len(named) != len(themes) {
+		return fmt.Errorf("got %d themes for %d groups", len(named), len(themes))
+	}
+	for i, n := range named {
+		if name := strings.TrimSpace(n.Theme); name != "" {
+			themes[i].Name = name
+		}
+		themes[i].Summary = strings.TrimSpace(n.Summary)
+	}
+	return nil
+}
+
+// formatProductSignals renders the digest of count issues opened in repo
+// between since and until.
+func formatProductSignals(repo string, since, until time.Time, count int, themes []signalTheme, other []*github.Issue) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n**%s**: %d new issue(s) from %s to %s, in %d theme(s).\n", ProductSignalsIdentifier, repo, count, since.Format(time.DateOnly), until.Format(time.DateOnly), len(themes))
+	if len(other) > 0 {
+		themes = append(themes, signalTheme{Name: "Other requests", Issues: other})
+	}
+	for _, theme := range themes {
+		fmt.Fprintf(&b, "\n#### %s (%d)\n\n", theme.Name, len(theme.Issues))
+		if theme.Summary != "" {
+			b.WriteString(theme.Summary + "\n\n")
+		}
+		for _, issue := range theme.Issues {
+			fmt.Fprintf(&b, "- #%d %s\n", issue.GetNumber(), issue.GetTitle())
+		}
+	}
+	return b.String()
+}
+
+// formatSlackSignals renders the digest as a Slack message, linking to the
+// full digest when it was posted to a discussion.
+func formatSlackSignals(repo string, count int, themes []signalTheme, other []*github.Issue, link string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*Product Signals for %s*: %d new issue(s) this week", repo, count)
+	for _, theme := range themes {
+		fmt.Fprintf(&b, "\n• *%s* (%d): %s", theme.Name, len(theme.Issues), theme.Summary)
+	}
+	if len(other) > 0 {
+		fmt.Fprintf(&b, "\n• Other requests (%d)", len(other))
+	}
+	if link != "" {
+		fmt.Fprintf(&b, "\n<%s|Full digest>", link)
+	}
+	return b.String()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+func TestWeeklyProductSignals(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "product_signals:\n  enabled: true\n  day: mon\n  discussion: 3\n  slack: true\n")
+	var slackMessages []string
+	slack := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg struct{ Text string }
+		json.NewDecoder(r.Body).Decode(&msg)
+		slackMessages = append(slackMessages, msg.Text)
+	}))
+	defer slack.Close()
+	env.bot.slack = newSlackNotifier(slack.URL)
+	env.bot.rememberInstallation(&github.Repository{FullName: github.String("acme/widgets")}, 7)
+
+	monday := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	for number, issue := range map[int]struct {
+		title string
+		age   time.Duration
+	}{
+		50: {"Export reports as CSV", 24 * time.Hour},
+		51: {"CSV export for dashboards", 48 * time.Hour},
+		52: {"Dark mode", 72 * time.Hour},
+		49: {"CSV import", 10 * 24 * time.Hour},
+	} {
+		added := env.github.addIssue("acme", "widgets", number, issue.title, "open")
+		added.CreatedAt = &github.Timestamp{Time: monday.Add(-issue.age)}
+	}
+	env.gemini.embedOn("CSV", 1, 0, 0)
+	env.gemini.embedOn("Dark", 0, 1, 0)
+	env.gemini.on("opened this week", `[{"theme": "CSV export", "summary": "Analysts want report data in spreadsheets."}]`)
+
+	env.bot.sendProductSignals(t.Context(), monday.Add(-24*time.Hour))
+	if posted := env.github.discussionComments(); len(posted) != 0 {
+		t.Fatalf("the digest should wait for its day, got %q", posted)
+	}
+
+	env.bot.sendProductSignals(t.Context(), monday)
+	env.bot.sendProductSignals(t.Context(), monday.Add(time.Hour))
+
+	posted := env.github.discussionComments()
+	if len(posted) != 1 {
+		t.Fatalf("expected one digest, got %d", len(posted))
+	}
+	digest := posted[0]
+	for _, want := range []string{
+		ProductSignalsIdentifier,
+		"**acme/widgets**: 3 new issue(s) from 2026-10-12 to 2026-10-19, in 1 theme(s).",
+		"#### CSV export (2)\n\nAnalysts want report data in spreadsheets.\n\n- #51 CSV export for dashboards\n- #50 Export reports as CSV\n",
+		"#### Other requests (1)\n\n- #52 Dark mode\n",
+	} {
+		if !strings.Contains(digest, want) {
+			t.Errorf("the digest should contain %q:\n%s", want, digest)
+		}
+	}
+	if strings.Contains(digest, "#49") {
+		t.Errorf("issues older than a week don't belong in the digest:\n%s", digest)
+	}
+	if len(slackMessages) != 1 || !strings.Contains(slackMessages[0], "• *CSV export* (2): Analysts want report data in spreadsheets.") || !strings.Contains(slackMessages[0], "<https://github.com/discussions/comment|Full digest>") {
+		t.Errorf("unexpected Slack messages %q", slackMessages)
+	}
+	if spend, _ := env.bot.loadSpend(7); spend.tokens() == 0 {
+		t.Error("naming the themes should be metered to the installation")
+	}
+}
+
+func TestProductSignalsSkipInstallationsOverBudget(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "product_signals:\n  enabled: true\n  day: mon\n  discussion: 3\n")
+	env.bot.rememberInstallation(&github.Repository{FullName: github.String("acme/widgets")}, 7)
+	monday := time.Date(2026, 10, 19, 10, 0, 0, 0, time.UTC)
+	for number, title := range map[int]string{50: "Export reports as CSV", 51: "CSV export for dashboards"} {
+		added := env.github.addIssue("acme", "widgets", number, title, "open")
+		added.CreatedAt = &github.Timestamp{Time: monday.Add(-24 * time.Hour)}
+	}
+	env.gemini.embedOn("CSV", 1, 0, 0)
+	exceedBudget(env, 7)
+
+	env.bot.sendProductSignals(t.Context(), monday)
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("no themes should be named over budget, got %d prompts", len(prompts))
+	}
+	if posted := env.github.discussionComments(); len(posted) != 0 {
+		t.Errorf("expected no digest over budget, got %q", posted)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Values of COMMIT_SIGNING_FORMAT.
+	signingFormatSSH     = "ssh"
+	signingFormatOpenPGP = "openpgp"
+)
+
+// commitSigner signs the bot's commits with an SSH or OpenPGP key, so they
+// show as verified when the key is registered to the committer's account.
+type commitSigner struct {
+	format string
+	// gitConfig lists the settings making git sign commits, as key/value pairs.
+	gitConfig [][2]string
+	// name and email override the committer identity; they must match the
+	// account the key belongs to.
+	name, email string
+}
+
+// decodeSigningKey accepts a key as-is or base64 encoded, like the
+// GitHub App private key.
+func decodeSigningKey(key string) ([]byte, error) {
+	key = strings.TrimSpace(key)
+	if strings.HasPrefix(key, "-----BEGIN") {
+		return []byte(key + "\n"), nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || !strings.HasPrefix(strings.TrimSpace(string(decoded)), "-----BEGIN") {
+		return nil, fmt.Errorf("the key is neither an armored key nor a base64-encoded one")
+	}
+	return decoded, nil
+}
+
+// newCommitSigner installs key in a private directory and returns the
+// signer using it. format is "ssh" or "openpgp"; when empty it is detected
+// from the key. OpenPGP keys are imported into a dedicated keyring with run.
+func newCommitSigner(format, key, name, email string, run CommandRunner) (*commitSigner, error) {
+	keyBytes, err := decodeSigningKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if format == "" {
+		format = signingFormatSSH
+		if strings.Contains(string(keyBytes), "BEGIN PGP PRIVATE KEY BLOCK") {
+			format = signingFormatOpenPGP
+		}
+	}
+	dir, err := os.MkdirTemp("", "commit-signing-*")
+	if err != nil {
+		return nil, err
+	}
+	keyPath := filepath.Join(dir, "signing-key")
+	if err := os.WriteFile(keyPath, keyBytes, 0o600); err != nil {
+		return nil, err
+	}
+	signer := &commitSigner{format: format, name: name, email: email}
+
+	switch format {
+	case signingFormatSSH:
+		signer.gitConfig = [][2]string{{"gpg.format", "ssh"}, {"user.signingkey", keyPath}}
+	case signingFormatOpenPGP:
+		home := filepath.Join(dir, "gnupg")
+		if err := os.Mkdir(home, 0o700); err != nil {
+			return nil, err
+		}
+		if out, err := run(dir, "gpg", "--homedir", home, "--batch", "--import", keyPath); err != nil {
+			return nil, fmt.Errorf("importing the OpenPGP key: %w: %s", err, out)
+		}
+		// The key material is in the keyring now.
+		if err := os.Remove(keyPath); err != nil {
+			return nil, err
+		}
+		out, err := run(dir, "gpg", "--homedir", home, "--batch", "--with-colons", "--list-secret-keys")
+		if err != nil {
+			return nil, fmt.Errorf("listing the imported OpenPGP key: %w: %s", err, out)
+		}
+		fingerprint := secretKeyFingerprint(out)
+		if fingerprint == "" {
+			return nil, fmt.Errorf("the OpenPGP key has no secret key")
+		}
+		// git passes its own arguments to gpg.program, so the keyring is
+		// selected by a wrapper.
+		wrapper := filepath.Join(dir, "gpg")
+		script := fmt.Sprintf("#!/bin/sh\nexec gpg --homedir '%s' \"$@\"\n", home)
+		if err := os.WriteFile(wrapper, []byte(script), 0o700); err != nil {
+			return nil, err
+		}
+		signer.gitConfig = [][2]string{{"gpg.format", "openpgp"}, {"gpg.program", wrapper}, {"user.signingkey", fingerprint}}
+	default:
+		return nil, fmt.Errorf("unsupported signing format %q, expected %s or %s", format, signingFormatSSH, signingFormatOpenPGP)
+	}
+	signer.gitConfig = append(signer.gitConfig, [2]string{"commit.gpgsign", "true"})
+	return signer, nil
+}
+
+// secretKeyFingerprint returns the fingerprint of the first secret key in
+// gpg's --with-colons listing.
+func secretKeyFingerprint(listing string) string {
+	inSecretKey := false
+	for _, line := range strings.Split(listing, "\n") {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "sec":
+			inSecretKey = true
+		case fields[0] == "fpr" && inSecretKey && len(fields) > 9:
+			return fields[9]
+		}
+	}
+	return ""
+}
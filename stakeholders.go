@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// ReviewersIdentifier heads the footer of a PRD comment suggesting reviewers.
+const ReviewersIdentifier = "### Reviewers suggested"
+
+const (
+	// Modes of StakeholderConfig.
+	stakeholdersOff     = "off"
+	stakeholdersList    = "list"    // show the owners without notifying them
+	stakeholdersMention = "mention" // @-mention the owners
+
+	defaultMaxStakeholders = 5
+)
+
+// codeownersPaths are the locations GitHub reads CODEOWNERS from, in order.
+var codeownersPaths = []string{".github/CODEOWNERS", "CODEOWNERS", "docs/CODEOWNERS"}
+
+// StakeholderConfig controls the "Reviewers suggested" footer of PRDs.
+type StakeholderConfig struct {
+	// Mode is "list" (default) to name the owners without notifying them,
+	// "mention" to @-mention them, or "off".
+	Mode string `yaml:"mode"`
+	// Max bounds the number of suggested reviewers; 5 by default.
+	Max int `yaml:"max"`
+	// Exclude lists owners never suggested, e.g. "@acme/everyone".
+	Exclude []string `yaml:"exclude"`
+}
+
+func (c *StakeholderConfig) mode() string {
+	if c == nil || c.Mode == "" {
+		return stakeholdersList
+	}
+	return c.Mode
+}
+
+func (c *StakeholderConfig) max() int {
+	if c == nil || c.Max <= 0 {
+		return defaultMaxStakeholders
+	}
+	return c.Max
+}
+
+// codeownersRule is one line of a CODEOWNERS file.
+type codeownersRule struct {
+	Pattern string
+	Owners  []string
+	re      *regexp.Regexp
+}
+
+// parseCodeowners parses a CODEOWNERS file. Rules without owners are kept,
+// since they remove ownership of the paths they match.
+func parseCodeowners(content string) []codeownersRule {
+	var rules []codeownersRule
+	for _, line := range strings.Split(content, "\n") {
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		rules = append(rules, codeownersRule{Pattern: fields[0], Owners: fields[1:], re: codeownersPattern(fields[0])})
+	}
+	return rules
+}
+
+// codeownersPattern compiles a gitignore-style CODEOWNERS pattern. Patterns
+// containing a slash other than a trailing one are anchored to the root; a
+// match on a directory covers everything below it.
+func codeownersPattern(pattern string) *regexp.Regexp {
+	anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+	pattern = strings.Trim(pattern, "/")
+	var re strings.Builder
+	re.WriteString("^")
+	if !anchored {
+		re.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			re.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			re.WriteString(".*")
+			i++
+		case c == '*':
+			re.WriteString("[^/]*")
+		case c == '?':
+			re.WriteString("[^/]")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString("(?:/.*)?$")
+	return regexp.MustCompile(re.String())
+}
+
+// codeownersFor returns the rule owning path: the last matching one.
+func codeownersFor(rules []codeownersRule, path string) *codeownersRule {
+	for i := len(rules) - 1; i >= 0; i-- {
+		if rules[i].re.MatchString(path) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+var (
+	urlPattern = regexp.MustCompile(`https?://\S+`)
+	// pathPattern matches repository paths such as `internal/export/csv.go` or `docs/`.
+	pathPattern = regexp.MustCompile(`(?:[\w.-]+/)+[\w.-]*`)
+)
+
+// mentionedPaths returns the repository paths named in text.
+func mentionedPaths(text string) []string {
+	var paths []string
+	for _, p := range pathPattern.FindAllString(urlPattern.ReplaceAllString(text, ""), -1) {
+		p = strings.TrimPrefix(p, "./")
+		if !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// fetchCodeowners reads the repository's CODEOWNERS file, or returns nil
+// when it has none.
+func fetchCodeowners(ctx context.Context, client *github.Client, owner, repo string) []codeownersRule {
+	for _, path := range codeownersPaths {
+		file, _, _, err := client.Repositories.GetContents(ctx, owner, repo, path, nil)
+		if err != nil {
+			continue
+		}
+		content, err := file.GetContent()
+		if err != nil {
+			log.Printf("Error decoding %s of %s/%s: %v", path, owner, repo, err)
+			return nil
+		}
+		return parseCodeowners(content)
+	}
+	return nil
+}
+
+// stakeholder is a suggested reviewer and the areas that make it relevant.
+type stakeholder struct {
+	Owner string
+	Areas []string
+}
+
+// suggestReviewers determines the owners of the areas a PRD affects: paths
+// named in the issue or PRD, and the CODEOWNERS patterns the model judges
+// affected. Owners are ordered by the number of areas they own.
+func suggestReviewers(ctx context.Context, llm Generator, rules []codeownersRule, issue *github.Issue, prd string, exclude []string, limit int) []stakeholder {
+	areas := make(map[string][]string) // owner -> areas
+	var order []string
+	add := func(area string, owners []string) {
+		for _, owner := range owners {
+			if !strings.HasPrefix(owner, "@") || slices.ContainsFunc(exclude, func(e string) bool { return strings.EqualFold(e, owner) }) {
+				continue
+			}
+			if _, ok := areas[owner]; !ok {
+				order = append(order, owner)
+			}
+			if !slices.Contains(areas[owner], area) {
+				areas[owner] = append(areas[owner], area)
+			}
+		}
+	}
+
+	paths := parseFilePathsFromIssue(issue.GetBody())
+	for _, p := range mentionedPaths(issue.GetBody() + "\n" + prd) {
+		if !slices.Contains(paths, p) {
+			paths = append(paths, p)
+		}
+	}
+	for _, p := range paths {
+		// The catch-all owners are everyone's fallback, not stakeholders.
+		if rule := codeownersFor(rules, p); rule != nil && rule.Pattern != "*" {
+			add(p, rule.Owners)
+		}
+	}
+
+	patterns, err := generateAffectedAreas(ctx, llm, rules, issue, prd)
+	if err != nil {
+		log.Printf("Could not determine the areas affected by issue #%d, using the paths it names: %v", issue.GetNumber(), err)
+	}
+	for _, pattern := range patterns {
+		for i := len(rules) - 1; i >= 0; i-- {
+			if rules[i].Pattern == pattern {
+				add(pattern, rules[i].Owners)
+				break
+			}
+		}
+	}
+
+	stakeholders := make([]stakeholder, 0, len(order))
+	for _, owner := range order {
+		stakeholders = append(stakeholders, stakeholder{Owner: owner, Areas: areas[owner]})
+	}
+	slices.SortStableFunc(stakeholders, func(a, b stakeholder) int { return len(b.Areas) - len(a.Areas) })
+	if len(stakeholders) > limit {
+		stakeholders = stakeholders[:limit]
+	}
+	return stakeholders
+}
+
+// generateAffectedAreas asks the model which CODEOWNERS patterns cover the
+// areas the PRD affects. Patterns that aren't in rules are dropped.
+func generateAffectedAreas(ctx context.Context, llm Generator, rules []codeownersRule, issue *github.Issue, prd string) ([]string, error) {
+	var patterns []string
+	for _, r := range rules {
+		if len(r.Owners) > 0 && !slices.Contains(patterns, r.Pattern) && r.Pattern != "*" {
+			patterns = append(patterns, r.Pattern)
+		}
+	}
+	if len(patterns) == 0 {
+		return nil, nil
+	}
+	prompt := fmt.Sprintf(
+		"The following CODEOWNERS patterns assign owners to areas of a repository. Select the patterns covering the code a Product Requirements Document (PRD) will most likely change. Select only areas that clearly need to change.\n\n"+
+			"Respond with JSON only, in this format:\n"+
+			`{"patterns": ["/internal/export/"]}`+"\n\n"+
+			"**CODEOWNERS patterns:**\n- %s\n\n"+
+			"**Issue Title:** %s\n\n"+
+			"**PRD:**\n%s",
+		strings.Join(patterns, "\n- "), issue.GetTitle(), prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, promptStakeholders), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine the affected areas: %w", err)
+	}
+	var result struct {
+		Patterns []string `json:"patterns"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	return slices.DeleteFunc(result.Patterns, func(p string) bool { return !slices.Contains(patterns, p) }), nil
+}
+
+// formatReviewersFooter renders the suggested reviewers. In list mode owners
+// are written as code so GitHub doesn't notify them.
+func formatReviewersFooter(stakeholders []stakeholder, mode string) string {
+	var b strings.Builder
+	b.WriteString(ReviewersIdentifier + "\n\nBased on `CODEOWNERS` and the areas this PRD affects:\n\n")
+	for _, s := range stakeholders {
+		owner := s.Owner
+		if mode != stakeholdersMention {
+			owner = "`" + owner + "`"
+		}
+		fmt.Fprintf(&b, "- %s (`%s`)\n", owner, strings.Join(s.Areas, "`, `"))
+	}
+	return b.String()
+}
+
+// addReviewersFooter appends the suggested reviewers to a PRD comment. The
+// comment is returned unchanged when the repository turned the footer off,
+// has no CODEOWNERS or no owner is relevant.
+func (b *Bot) addReviewersFooter(ctx context.Context, client *github.Client, repo *github.Repository, issue *github.Issue, prdContent string, cfg *StakeholderConfig) string {
+	mode := cfg.mode()
+	if mode == stakeholdersOff {
+		return prdContent
+	}
+	doc, ok := parsePRDDocument(prdContent)
+	if !ok {
+		return prdContent
+	}
+	rules := fetchCodeowners(ctx, client, repo.GetOwner().GetLogin(), repo.GetName())
+	if len(rules) == 0 {
+		return prdContent
+	}
+	var exclude []string
+	if cfg != nil {
+		exclude = cfg.Exclude
+	}
+	exclude = append(exclude, "@"+issue.GetUser().GetLogin(), "@"+b.appName)
+	stakeholders := suggestReviewers(ctx, b.llm, rules, issue, doc.English, exclude, cfg.max())
+	if len(stakeholders) == 0 {
+		return prdContent
+	}
+	doc.Footer = formatReviewersFooter(stakeholders, mode)
+	return doc.String()
+}
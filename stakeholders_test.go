@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const testCodeowners = `# Default owners
+*              @acme/core
+/export/       @acme/exports @alice
+*.md           @acme/docs
+/billing/      @carol
+/billing/legacy/
+`
+
+func TestCodeownersLastMatchWins(t *testing.T) {
+	rules := parseCodeowners(testCodeowners)
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"export/csv.go", "/export/"},
+		{"export/README.md", "*.md"},
+		{"docs/guide.md", "*.md"},
+		{"nested/export/csv.go", "*"},
+		{"billing/legacy/invoice.go", "/billing/legacy/"},
+		{"billing", "/billing/"},
+	} {
+		if rule := codeownersFor(rules, tt.path); rule == nil || rule.Pattern != tt.want {
+			t.Errorf("codeownersFor(%q) = %+v, want %s", tt.path, rule, tt.want)
+		}
+	}
+	if owners := codeownersFor(rules, "billing/legacy/x.go").Owners; len(owners) != 0 {
+		t.Errorf("a rule without owners should remove ownership, got %v", owners)
+	}
+}
+
+func TestPRDSuggestsReviewersFromCodeowners(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.github.addFile("acme", "widgets", ".github/CODEOWNERS", testCodeowners)
+	env.gemini.on("Detect the primary language", "Traditional Chinese")
+	env.gemini.on("Translate the following English PRD", "翻譯")
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+	env.gemini.on("Create a Product Requirements Document", "1.  **Background:** Export reports as CSV.")
+	env.gemini.on("CODEOWNERS patterns assign owners", `{"patterns": ["/billing/", "/not/a/pattern/"]}`)
+
+	env.deliver(t, "issues", "issues_opened.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 1 {
+		t.Fatalf("expected the PRD comment, got %d comments", len(comments))
+	}
+	body := comments[0].GetBody()
+	doc, ok := parsePRDDocument(body)
+	if !ok || !strings.HasPrefix(doc.Footer, ReviewersIdentifier) || doc.Translated != "翻譯" {
+		t.Fatalf("expected a reviewers footer after the translation:\n%s", body)
+	}
+	// @alice wrote the issue and the catch-all owners aren't stakeholders.
+	if doc.Footer != ReviewersIdentifier+"\n\nBased on `CODEOWNERS` and the areas this PRD affects:\n\n- `@acme/exports` (`export/csv.go`)\n- `@carol` (`/billing/`)\n" {
+		t.Errorf("unexpected footer:\n%s", doc.Footer)
+	}
+	if doc.String() != stripArtifactMeta(body) {
+		t.Error("the PRD comment should round-trip through PRDDocument")
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Sources of startup settings, from the lowest to the highest precedence.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+	// sourceSetup marks the GitHub App settings created through /setup.
+	sourceSetup = "setup"
+)
+
+// StartupConfig holds the settings read once at startup. Each field is named
+// by its environment variable in the `env` tag; the same name in lower case
+// is its key in the file named by CONFIG_FILE or -config, and in kebab case
+// its command-line flag (e.g. -gemini-model). Flags override the environment,
+// which overrides the file, which overrides the defaults. Fields tagged
+// `secret` are redacted when the configuration is printed.
+type StartupConfig struct {
+	Port      string `env:"PORT"`
+	PublicURL string `env:"PUBLIC_URL"`
+
+	WebhookSecret string `env:"GITHUB_WEBHOOK_SECRET" secret:"true"`
+	AppID         int64  `env:"GITHUB_APP_ID"`
+	AppPrivateKey string `env:"GITHUB_APP_PRIVATE_KEY" secret:"true"`
+	// AppKeyRefresh is how often a private key kept in a file or secrets
+	// manager is reloaded.
+	AppKeyRefresh time.Duration `env:"GITHUB_APP_PRIVATE_KEY_REFRESH"`
+	AppName       string        `env:"GITHUB_APP_NAME"`
+	GitHubToken   string        `env:"GITHUB_TOKEN" secret:"true"`
+	// GitHubAPIURL and GitHubUploadURL point the bot at a GitHub Enterprise
+	// Server, e.g. https://github.example.com/api/v3/.
+	GitHubAPIURL     string `env:"GITHUB_API_URL"`
+	GitHubUploadURL  string `env:"GITHUB_UPLOAD_URL"`
+	APIToken         string `env:"API_TOKEN" secret:"true"`
+	ServerConfigPath string `env:"SERVER_CONFIG_PATH"`
+
+	// The credentials of AWS Secrets Manager, when the private key is kept
+	// there.
+	AWSRegion          string `env:"AWS_REGION"`
+	AWSAccessKeyID     string `env:"AWS_ACCESS_KEY_ID"`
+	AWSSecretAccessKey string `env:"AWS_SECRET_ACCESS_KEY" secret:"true"`
+	AWSSessionToken    string `env:"AWS_SESSION_TOKEN" secret:"true"`
+
+	GoogleAPIKey  string        `env:"GOOGLE_API_KEY" secret:"true"`
+	GeminiModel   string        `env:"GEMINI_MODEL"`
+	OpenAIBaseURL string        `env:"OPENAI_BASE_URL"`
+	OpenAIModel   string        `env:"OPENAI_MODEL"`
+	OpenAIAPIKey  string        `env:"OPENAI_API_KEY" secret:"true"`
+	OpenAITimeout time.Duration `env:"OPENAI_TIMEOUT"`
+
+	StorePath          string `env:"STORE_PATH"`
+	StoreEncryptionKey string `env:"STORE_ENCRYPTION_KEY" secret:"true"`
+
+	Mode        string `env:"MODE"`
+	WorkerToken string `env:"WORKER_TOKEN" secret:"true"`
+	FrontendURL string `env:"FRONTEND_URL"`
+	WorkerLanes string `env:"WORKER_LANES"`
+
+	FeatureFlags string `env:"FEATURE_FLAGS"`
+	Allowlist    string `env:"ALLOWLIST"`
+
+	CommitBackend       string `env:"COMMIT_BACKEND"`
+	CommitSigningKey    string `env:"COMMIT_SIGNING_KEY" secret:"true"`
+	CommitSigningFormat string `env:"COMMIT_SIGNING_FORMAT"`
+	CommitAuthorName    string `env:"COMMIT_AUTHOR_NAME"`
+	CommitAuthorEmail   string `env:"COMMIT_AUTHOR_EMAIL"`
+
+	WorkspaceRoot     string `env:"WORKSPACE_ROOT"`
+	WorkspaceQuota    string `env:"WORKSPACE_QUOTA"`
+	WorkspaceJobLimit string `env:"WORKSPACE_JOB_LIMIT"`
+
+	OIDCIssuer         string `env:"OIDC_ISSUER"`
+	OIDCClientID       string `env:"OIDC_CLIENT_ID"`
+	OIDCClientSecret   string `env:"OIDC_CLIENT_SECRET" secret:"true"`
+	ViewerURL          string `env:"VIEWER_URL"`
+	ViewerEmailDomains string `env:"VIEWER_EMAIL_DOMAINS"`
+	// ViewerAllowAnyAccount lets every account of the OIDC provider in when
+	// VIEWER_EMAIL_DOMAINS is empty.
+	ViewerAllowAnyAccount bool `env:"VIEWER_ALLOW_ANY_ACCOUNT"`
+	// ViewerSessionSecret signs the viewer's cookies, so sign-ins survive
+	// restarts and work across replicas.
+	ViewerSessionSecret string `env:"VIEWER_SESSION_SECRET" secret:"true"`
+
+	SlackWebhookURL  string        `env:"SLACK_WEBHOOK_URL" secret:"true"`
+	ReminderInterval time.Duration `env:"REMINDER_INTERVAL"`
+	PollRepos        string        `env:"POLL_REPOS"`
+	PollInterval     time.Duration `env:"POLL_INTERVAL"`
+
+	ErrorReportingDSN string `env:"ERROR_REPORTING_DSN" secret:"true"`
+
+	Chaos string `env:"CHAOS"`
+
+	// sources records where each setting came from, by environment variable.
+	sources map[string]string
+}
+
+// defaultStartupConfig returns the built-in defaults.
+func defaultStartupConfig() *StartupConfig {
+	return &StartupConfig{
+		Port:             "8080",
+		AppKeyRefresh:    defaultAppKeyRefresh,
+		GeminiModel:      defaultGeminiModel,
+		OpenAITimeout:    openAIRequestTimeout,
+		Mode:             modeAll,
+		CommitBackend:    commitBackendGit,
+		WorkspaceRoot:    filepath.Join(os.TempDir(), defaultWorkspaceDir),
+		ReminderInterval: defaultReminderInterval,
+		PollInterval:     defaultPollInterval,
+	}
+}
+
+// githubServer returns the GitHub instance the settings point at.
+func (c *StartupConfig) githubServer() githubServer {
+	return githubServer{apiURL: c.GitHubAPIURL, uploadURL: c.GitHubUploadURL}
+}
+
+// configField is a field of StartupConfig and its names.
+type configField struct {
+	value  reflect.Value
+	env    string
+	secret bool
+}
+
+func (f configField) key() string  { return strings.ToLower(f.env) }
+func (f configField) flag() string { return strings.ReplaceAll(f.key(), "_", "-") }
+
+func (c *StartupConfig) fields() []configField {
+	v := reflect.ValueOf(c).Elem()
+	var fields []configField
+	for i := range v.NumField() {
+		sf := v.Type().Field(i)
+		if env := sf.Tag.Get("env"); env != "" {
+			fields = append(fields, configField{value: v.Field(i), env: env, secret: sf.Tag.Get("secret") == "true"})
+		}
+	}
+	return fields
+}
+
+// set parses raw into the field and records its source.
+func (c *StartupConfig) set(f configField, raw, source string) error {
+	raw = strings.TrimSpace(raw)
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.env, raw)
+		}
+		f.value.SetInt(n)
+	case bool:
+		v, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not true or false", f.env, raw)
+		}
+		f.value.SetBool(v)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("%s: %q is not a positive duration such as 90s or 5m", f.env, raw)
+		}
+		f.value.SetInt(int64(d))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.env, f.value.Type())
+	}
+	c.sources[f.env] = source
+	return nil
+}
+
+// loadStartupConfig reads the defaults, the configuration file, the
+// environment through getenv and the command-line args, in that order, and
+// validates the result. All the problems found are reported together.
+func loadStartupConfig(args []string, getenv func(string) string) (*StartupConfig, error) {
+	cfg := defaultStartupConfig()
+	cfg.sources = make(map[string]string)
+	fields := cfg.fields()
+
+	fs := flag.NewFlagSet("agent-prd", flag.ContinueOnError)
+	configPath := fs.String("config", getenv("CONFIG_FILE"), "YAML file of settings, keyed by the lower-case environment variable names")
+	flagValues := make(map[string]*string, len(fields))
+	for _, f := range fields {
+		flagValues[f.env] = fs.String(f.flag(), "", "overrides "+f.env)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	if *configPath != "" {
+		errs = append(errs, cfg.loadFile(*configPath, fields))
+	}
+	for _, f := range fields {
+		if v := getenv(f.env); strings.TrimSpace(v) != "" {
+			errs = append(errs, cfg.set(f, v, sourceEnv))
+		}
+	}
+	set := make(map[string]bool)
+	fs.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	for _, f := range fields {
+		if set[f.flag()] {
+			errs = append(errs, cfg.set(f, *flagValues[f.env], sourceFlag))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// loadFile applies the settings of the YAML file at path.
+func (c *StartupConfig) loadFile(path string, fields []configField) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	byKey := make(map[string]configField, len(fields))
+	for _, f := range fields {
+		byKey[f.key()] = f
+	}
+	var errs []error
+	for key, value := range values {
+		f, ok := byKey[key]
+		if !ok {
+			errs = append(errs, fmt.Errorf("config file %s: unknown setting %q", path, key))
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		errs = append(errs, c.set(f, fmt.Sprint(value), sourceFile))
+	}
+	return errors.Join(errs...)
+}
+
+// validate checks that the required settings are present and consistent.
+func (c *StartupConfig) validate() error {
+	var errs []error
+	// Without GitHub credentials, PUBLIC_URL serves /setup to create the App.
+	// Workers don't own the store the App is kept in.
+	setup := c.PublicURL != "" && c.Mode != modeWorker && c.AppID == 0 && c.AppPrivateKey == "" && c.GitHubToken == ""
+	if c.WebhookSecret == "" && !setup {
+		errs = append(errs, errors.New("GITHUB_WEBHOOK_SECRET is required"))
+	}
+	switch {
+	case setup:
+		if c.StoreEncryptionKey == "" {
+			errs = append(errs, errors.New("STORE_ENCRYPTION_KEY is required to store the GitHub App created through PUBLIC_URL/setup"))
+		}
+	case c.AppID != 0 || c.AppPrivateKey != "":
+		if c.AppID == 0 || c.AppPrivateKey == "" {
+			errs = append(errs, errors.New("GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY must be set together"))
+		}
+		if c.AppName == "" {
+			errs = append(errs, errors.New("GITHUB_APP_NAME is required with GitHub App credentials"))
+		}
+		if c.AppKeyRefresh <= 0 {
+			errs = append(errs, errors.New("GITHUB_APP_PRIVATE_KEY_REFRESH must be positive"))
+		}
+	case c.GitHubToken == "":
+		errs = append(errs, errors.New("GitHub credentials are required: set GITHUB_APP_ID and GITHUB_APP_PRIVATE_KEY, or GITHUB_TOKEN"))
+	}
+	for name, value := range map[string]string{"GITHUB_API_URL": c.GitHubAPIURL, "GITHUB_UPLOAD_URL": c.GitHubUploadURL} {
+		if u, err := url.Parse(value); value != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+			errs = append(errs, fmt.Errorf("%s %q is invalid: expected an absolute URL", name, value))
+		}
+	}
+	if c.GitHubUploadURL != "" && c.GitHubAPIURL == "" {
+		errs = append(errs, errors.New("GITHUB_API_URL is required with GITHUB_UPLOAD_URL"))
+	}
+	if c.GoogleAPIKey == "" && c.OpenAIBaseURL == "" {
+		errs = append(errs, errors.New("a model is required: set GOOGLE_API_KEY, or OPENAI_BASE_URL and OPENAI_MODEL"))
+	}
+	if c.OpenAIBaseURL != "" && c.OpenAIModel == "" {
+		errs = append(errs, errors.New("OPENAI_MODEL is required with OPENAI_BASE_URL"))
+	}
+	switch c.Mode {
+	case modeAll:
+	case modeFrontend, modeWorker:
+		if c.WorkerToken == "" {
+			errs = append(errs, fmt.Errorf("WORKER_TOKEN is required with MODE=%s", c.Mode))
+		}
+		if c.Mode == modeWorker && c.FrontendURL == "" {
+			errs = append(errs, errors.New("FRONTEND_URL is required with MODE=worker"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("MODE %q is invalid: expected %s, %s or %s", c.Mode, modeAll, modeFrontend, modeWorker))
+	}
+	if c.CommitBackend != commitBackendGit && c.CommitBackend != commitBackendAPI {
+		errs = append(errs, fmt.Errorf("COMMIT_BACKEND %q is invalid: expected %s or %s", c.CommitBackend, commitBackendGit, commitBackendAPI))
+	}
+	if c.OIDCIssuer != "" {
+		if c.OIDCClientID == "" || c.OIDCClientSecret == "" || c.ViewerURL == "" || c.ViewerSessionSecret == "" {
+			errs = append(errs, errors.New("OIDC_CLIENT_ID, OIDC_CLIENT_SECRET, VIEWER_URL and VIEWER_SESSION_SECRET are required with OIDC_ISSUER"))
+		}
+		if strings.TrimSpace(c.ViewerEmailDomains) == "" && !c.ViewerAllowAnyAccount {
+			errs = append(errs, errors.New("VIEWER_EMAIL_DOMAINS is required with OIDC_ISSUER; set VIEWER_ALLOW_ANY_ACCOUNT=true to let every account of the provider in"))
+		}
+	}
+	for name, size := range map[string]string{"WORKSPACE_QUOTA": c.WorkspaceQuota, "WORKSPACE_JOB_LIMIT": c.WorkspaceJobLimit} {
+		if _, err := parseByteSize(size); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	if c.ErrorReportingDSN != "" {
+		if _, err := newErrorReporter(c.ErrorReportingDSN); err != nil {
+			errs = append(errs, fmt.Errorf("ERROR_REPORTING_DSN: %w", err))
+		}
+	}
+	if _, err := parseChaos(c.Chaos); err != nil {
+		errs = append(errs, fmt.Errorf("CHAOS: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// redacted returns the effective settings one per line, with their source,
+// showing only whether secrets are set.
+func (c *StartupConfig) redacted() string {
+	var b strings.Builder
+	for _, f := range c.fields() {
+		value := fmt.Sprint(f.value.Interface())
+		if f.value.IsZero() {
+			value = ""
+		} else if f.secret {
+			value = "[redacted]"
+		}
+		fmt.Fprintf(&b, "  %s=%s (%s)\n", f.env, value, cmp.Or(c.sources[f.env], sourceDefault))
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
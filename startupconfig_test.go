@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// environ returns a getenv reading vars.
+func environ(vars map[string]string) func(string) string {
+	return func(name string) string { return vars[name] }
+}
+
+var minimalEnv = map[string]string{
+	"GITHUB_WEBHOOK_SECRET": "hook-secret",
+	"GITHUB_TOKEN":          "ghp_token",
+	"GOOGLE_API_KEY":        "google-key",
+}
+
+func TestStartupConfigDefaults(t *testing.T) {
+	cfg, err := loadStartupConfig(nil, environ(minimalEnv))
+	if err != nil {
+		t.Fatalf("loadStartupConfig: %v", err)
+	}
+	if cfg.Port != "8080" || cfg.GeminiModel != defaultGeminiModel || cfg.Mode != modeAll || cfg.CommitBackend != commitBackendGit || cfg.PollInterval != defaultPollInterval || cfg.OpenAITimeout != openAIRequestTimeout {
+		t.Errorf("unexpected defaults %+v", cfg)
+	}
+	if cfg.GitHubToken != "ghp_token" || cfg.WorkspaceRoot == "" {
+		t.Errorf("unexpected settings %+v", cfg)
+	}
+}
+
+func TestStartupConfigPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-prd.yml")
+	file := "port: 9000\ngemini_model: gemini-file\nreminder_interval: 30m\nworker_lanes: quick\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	env := map[string]string{"CONFIG_FILE": path, "GEMINI_MODEL": "gemini-env", "PORT": "9100"}
+	for k, v := range minimalEnv {
+		env[k] = v
+	}
+
+	cfg, err := loadStartupConfig([]string{"-port", "9200"}, environ(env))
+	if err != nil {
+		t.Fatalf("loadStartupConfig: %v", err)
+	}
+	if cfg.Port != "9200" || cfg.GeminiModel != "gemini-env" || cfg.ReminderInterval != 30*time.Minute || cfg.WorkerLanes != "quick" {
+		t.Errorf("flags should override the environment, which overrides the file: %+v", cfg)
+	}
+	redacted := cfg.redacted()
+	for _, want := range []string{"PORT=9200 (flag)", "GEMINI_MODEL=gemini-env (env)", "REMINDER_INTERVAL=30m0s (file)", "COMMIT_BACKEND=git (default)", "GOOGLE_API_KEY=[redacted] (env)", "OPENAI_API_KEY= (default)"} {
+		if !strings.Contains(redacted, want) {
+			t.Errorf("redacted config should contain %q:\n%s", want, redacted)
+		}
+	}
+	for _, secret := range []string{"hook-secret", "ghp_token", "google-key"} {
+		if strings.Contains(redacted, secret) {
+			t.Errorf("redacted config leaks %q:\n%s", secret, redacted)
+		}
+	}
+}
+
+func TestStartupConfigValidation(t *testing.T) {
+	_, err := loadStartupConfig([]string{"-mode", "worker", "-poll-interval", "soon"}, environ(map[string]string{"GITHUB_APP_ID": "12", "OPENAI_BASE_URL": "http://localhost:11434/v1"}))
+	if err == nil || !strings.Contains(err.Error(), "POLL_INTERVAL") {
+		t.Fatalf("expected the invalid duration to be reported, got %v", err)
+	}
+
+	_, err = loadStartupConfig([]string{"-mode", "worker"}, environ(map[string]string{"GITHUB_APP_ID": "12", "OPENAI_BASE_URL": "http://localhost:11434/v1", "COMMIT_BACKEND": "svn", "WORKSPACE_QUOTA": "lots", "CHAOS": "disk_full", "GITHUB_UPLOAD_URL": "uploads.example.com", "OIDC_ISSUER": "https://acme.okta.com"}))
+	if err == nil {
+		t.Fatal("expected validation errors")
+	}
+	for _, want := range []string{"GITHUB_WEBHOOK_SECRET is required", "GITHUB_APP_PRIVATE_KEY must be set together", "GITHUB_APP_NAME is required", "OPENAI_MODEL is required", "WORKER_TOKEN is required", "FRONTEND_URL is required", "COMMIT_BACKEND \"svn\"", "WORKSPACE_QUOTA", "CHAOS: unknown fault \"disk_full\"", "GITHUB_UPLOAD_URL \"uploads.example.com\" is invalid", "GITHUB_API_URL is required with GITHUB_UPLOAD_URL", "VIEWER_SESSION_SECRET are required with OIDC_ISSUER", "VIEWER_EMAIL_DOMAINS is required with OIDC_ISSUER"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("every problem should be reported at once, missing %q in:\n%v", want, err)
+		}
+	}
+}
+
+func TestStartupConfigUnknownFileSetting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-prd.yml")
+	if err := os.WriteFile(path, []byte("gemini_modle: typo\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadStartupConfig([]string{"-config", path}, environ(minimalEnv)); err == nil || !strings.Contains(err.Error(), `unknown setting "gemini_modle"`) {
+		t.Errorf("expected the unknown setting to be reported, got %v", err)
+	}
+}
+
+func TestStartupConfigViewerAllowsAnyAccountByOptIn(t *testing.T) {
+	env := map[string]string{"OIDC_ISSUER": "https://acme.okta.com", "OIDC_CLIENT_ID": "viewer", "OIDC_CLIENT_SECRET": "shh", "VIEWER_URL": "https://bot.example.com", "VIEWER_SESSION_SECRET": "session-secret", "VIEWER_ALLOW_ANY_ACCOUNT": "true"}
+	for k, v := range minimalEnv {
+		env[k] = v
+	}
+	cfg, err := loadStartupConfig(nil, environ(env))
+	if err != nil {
+		t.Fatalf("loadStartupConfig: %v", err)
+	}
+	if !cfg.ViewerAllowAnyAccount || !strings.Contains(cfg.redacted(), "VIEWER_SESSION_SECRET=[redacted] (env)") {
+		t.Errorf("unexpected viewer settings:\n%s", cfg.redacted())
+	}
+
+	env["VIEWER_ALLOW_ANY_ACCOUNT"] = "sure"
+	if _, err := loadStartupConfig(nil, environ(env)); err == nil || !strings.Contains(err.Error(), "VIEWER_ALLOW_ANY_ACCOUNT") {
+		t.Errorf("expected the invalid boolean to be reported, got %v", err)
+	}
+}
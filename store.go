@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Store persists bot artifacts and state as JSON documents grouped into buckets.
+type Store interface {
+	// Get decodes the document stored under bucket/key into v and reports whether it exists.
+	Get(bucket, key string, v any) (bool, error)
+	// Put stores v as JSON under bucket/key, replacing any previous document.
+	Put(bucket, key string, v any) error
+	// Delete removes bucket/key. Deleting a missing document is not an error.
+	Delete(bucket, key string) error
+	// List returns every document in bucket keyed by document key.
+	List(bucket string) (map[string]json.RawMessage, error)
+}
+
+// newMemoryStore returns a store that is never written to disk.
+func newMemoryStore() *jsonStore {
+	return &jsonStore{buckets: make(map[string]map[string]json.RawMessage)}
+}
+
+// OpenStore returns a store persisted to the JSON file at path, or an
+// in-memory store when path is empty.
+func OpenStore(path string) (Store, error) {
+	s := newMemoryStore()
+	s.path = path
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading store %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.buckets); err != nil {
+		return nil, fmt.Errorf("parsing store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// jsonStore keeps all documents in memory and, when path is set, rewrites the
+// whole database to disk before a change returns. Changes made while the
+// file is being written are written together by the next flush, so
+// concurrent handlers share one write instead of queueing for their own.
+// The bot's data volume is small enough that this is simpler and safer than
+// a real database.
+type jsonStore struct {
+	path string
+
+	mu      sync.RWMutex
+	buckets map[string]map[string]json.RawMessage
+	changes uint64 // changes made to buckets, guarded by mu
+
+	flushMu sync.Mutex // serializes writes of path
+	flushed uint64     // changes written to path, guarded by flushMu
+}
+
+func (s *jsonStore) Get(bucket, key string, v any) (bool, error) {
+	s.mu.RLock()
+	data, ok := s.buckets[bucket][key]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("decoding %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (s *jsonStore) Put(bucket, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("encoding %s/%s: %w", bucket, key, err)
+	}
+	s.mu.Lock()
+	if s.buckets[bucket] == nil {
+		s.buckets[bucket] = make(map[string]json.RawMessage)
+	}
+	s.buckets[bucket][key] = data
+	s.changes++
+	change := s.changes
+	s.mu.Unlock()
+	return s.flush(change)
+}
+
+func (s *jsonStore) Delete(bucket, key string) error {
+	s.mu.Lock()
+	if _, ok := s.buckets[bucket][key]; !ok {
+		s.mu.Unlock()
+		return nil
+	}
+	delete(s.buckets[bucket], key)
+	s.changes++
+	change := s.changes
+	s.mu.Unlock()
+	return s.flush(change)
+}
+
+func (s *jsonStore) List(bucket string) (map[string]json.RawMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	docs := make(map[string]json.RawMessage, len(s.buckets[bucket]))
+	for k, v := range s.buckets[bucket] {
+		docs[k] = v
+	}
+	return docs, nil
+}
+
+// flush writes the database to disk atomically, unless a flush that
+// started after change was made already wrote it.
+func (s *jsonStore) flush(change uint64) error {
+	if s.path == "" {
+		return nil
+	}
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	if s.flushed >= change {
+		return nil
+	}
+	s.mu.RLock()
+	data, err := json.Marshal(s.buckets)
+	changes := s.changes
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("encoding store: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("writing store: %w", err)
+	}
+	s.flushed = changes
+	return nil
+}
+
+// issueKey identifies an issue across repositories in store keys.
+func issueKey(owner, repo string, issueNum int) string {
+	return fmt.Sprintf("%s/%s#%d", owner, repo, issueNum)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestFileStorePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	if err := store.Put("things", "a", map[string]int{"n": 1}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put("things", "b", map[string]int{"n": 2}); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Delete("things", "b"); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	var got map[string]int
+	if ok, err := reopened.Get("things", "a", &got); !ok || err != nil || got["n"] != 1 {
+		t.Errorf("Get(a) = %v, %v, %v", got, ok, err)
+	}
+	if ok, _ := reopened.Get("things", "b", &got); ok {
+		t.Error("deleted document should not exist")
+	}
+	docs, _ := reopened.List("things")
+	if len(docs) != 1 {
+		t.Errorf("List returned %d documents, want 1", len(docs))
+	}
+}
+
+func TestFileStorePersistsConcurrentWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("OpenStore: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := range 50 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := store.Put("things", fmt.Sprint(i), i); err != nil {
+				t.Errorf("Put(%d): %v", i, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatalf("reopening store: %v", err)
+	}
+	if docs, _ := reopened.List("things"); len(docs) != 50 {
+		t.Errorf("List returned %d documents, want every write", len(docs))
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// SubTasksIdentifier marks comments produced by the need_sub_task command.
+	SubTasksIdentifier = "### Generated Sub-tasks"
+
+	// subTasksIntro follows the identifier in sub-task comments.
+	subTasksIntro = "Based on the PRD, here are the suggested sub-tasks:"
+
+	// subTasksFreshFlag makes need_sub_task post a new checklist instead of
+	// updating the previous one.
+	subTasksFreshFlag = "--fresh"
+)
+
+var (
+	checklistItemPattern = regexp.MustCompile(`(?m)^[ \t]*[-*] \[([ xX])\] (.+)$`)
+	// issueLinkPattern finds references to issues and links in a checklist
+	// item, e.g. after GitHub converted it to an issue.
+	issueLinkPattern = regexp.MustCompile(`(^|[\s(])#\d+\b|https?://`)
+)
+
+// checklistItem is an item of a sub-task checklist.
+type checklistItem struct {
+	Line    string // the item as it appears in the comment
+	Text    string
+	Checked bool
+}
+
+// pinned reports whether the item must survive a regeneration as it is:
+// it was completed, or it links to the issue or pull request doing it.
+func (i checklistItem) pinned() bool {
+	return i.Checked || issueLinkPattern.MatchString(i.Text)
+}
+
+// parseChecklist returns the checklist items of a sub-task comment, leaving
+// out the owners section.
+func parseChecklist(markdown string) []checklistItem {
+	markdown, _, _ = strings.Cut(markdown, OwnersIdentifier)
+	var items []checklistItem
+	for _, m := range checklistItemPattern.FindAllStringSubmatch(markdown, -1) {
+		items = append(items, checklistItem{Line: strings.TrimRight(m[0], " \r"), Text: strings.TrimSpace(m[2]), Checked: m[1] != " "})
+	}
+	return items
+}
+
+// findSubTasksComment returns the latest sub-task comment the bot posted on
+// the issue, or nil.
+func (b *Bot) findSubTasksComment(ctx context.Context, client *github.Client, repoOwner, repoName string, issueNum int) (*github.IssueComment, error) {
+	comments, _, err := client.Issues.ListComments(ctx, repoOwner, repoName, issueNum, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching comments for issue #%d: %w", issueNum, err)
+	}
+	for i := len(comments) - 1; i >= 0; i-- {
+		if !b.isBotUser(comments[i].GetUser()) {
+			continue
+		}
+		if meta, ok := parseArtifactMeta(comments[i].GetBody()); ok && meta.Type != ArtifactSubTasks {
+			continue
+		}
+		if strings.HasPrefix(comments[i].GetBody(), SubTasksIdentifier) {
+			return comments[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// taskChange is the model's verdict on one task of the revised checklist:
+// Previous is the 1-based number of the task it continues, if any, and Text
+// its new wording when it changed or the task is new.
+type taskChange struct {
+	Previous int    `json:"previous,omitempty"`
+	Text     string `json:"text,omitempty"`
+}
+
+// checklistUpdate counts what an update changed in a checklist.
+type checklistUpdate struct {
+	Added, Renamed, Removed, Pinned int
+}
+
+func (u checklistUpdate) changed() bool {
+	return u.Added+u.Renamed+u.Removed > 0
+}
+
+func (u checklistUpdate) String() string {
+	s := fmt.Sprintf("%d added, %d renamed and %d removed", u.Added, u.Renamed, u.Removed)
+	if u.Pinned > 0 {
+		s += fmt.Sprintf("; %d completed or linked sub-tasks were kept as they were", u.Pinned)
+	}
+	return s
+}
+
+// reviseChecklist asks the model how the previous checklist should change
+// for the revised PRD.
+func reviseChecklist(ctx context.Context, llm Generator, prd string, previous []checklistItem) ([]taskChange, error) {
+	var list strings.Builder
+	for i, item := range previous {
+		state := "open"
+		if item.Checked {
+			state = "done"
+		}
+		fmt.Fprintf(&list, "%d. [%s] %s\n", i+1, state, item.Text)
+	}
+	prompt := fmt.Sprintf(
+		"The Product Requirements Document (PRD) below was revised after it was broken down into the numbered sub-tasks that follow. Update the sub-tasks to match the revised PRD, changing only what the revision requires.\n\n"+
+			"Respond with JSON only, listing the updated sub-tasks in order:\n"+
+			`{"tasks": [{"previous": 1}, {"previous": 2, "text": "reworded sub-task"}, {"text": "new sub-task"}]}`+"\n\n"+
+			"Use `previous` alone to keep a sub-task unchanged, `previous` with `text` when its wording must change, and `text` alone for a new sub-task. Leave out sub-tasks the revised PRD no longer needs. Keep done sub-tasks unless the PRD dropped their feature.\n\n"+
+			"**Previous sub-tasks:**\n%s\n"+
+			"**Revised PRD:**\n%s",
+		list.String(), prd,
+	)
+	resp, err := llm.GenerateText(withSystemPrompt(ctx, CommandGenerateSubTask), prompt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to revise sub-tasks: %w", err)
+	}
+	var result struct {
+		Tasks []taskChange `json:"tasks"`
+	}
+	if err := parseModelJSON(resp, &result); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrModelInvalid, err)
+	}
+	if len(result.Tasks) == 0 {
+		return nil, fmt.Errorf("%w: no sub-tasks", ErrModelInvalid)
+	}
+	return result.Tasks, nil
+}
+
+// mergeChecklist applies changes to the previous checklist. Kept items keep
+// their line, check mark and links. Pinned items are never reworded, and
+// stay in place when the model leaves them out.
+func mergeChecklist(previous []checklistItem, changes []taskChange) ([]string, checklistUpdate) {
+	type entry struct {
+		from int // index of the previous item, or -1
+		line string
+	}
+	var entries []entry
+	var update checklistUpdate
+	used := make([]bool, len(previous))
+	for _, change := range changes {
+		i := change.Previous - 1
+		text := strings.TrimSpace(change.Text)
+		if i < 0 || i >= len(previous) || used[i] {
+			if text != "" {
+				entries = append(entries, entry{-1, "- [ ] " + text})
+				update.Added++
+			}
+			continue
+		}
+		used[i] = true
+		item := previous[i]
+		if text != "" && normalizeTask(text) != normalizeTask(item.Text) && !item.pinned() {
+			entries = append(entries, entry{i, "- [ ] " + text})
+			update.Renamed++
+			continue
+		}
+		entries = append(entries, entry{i, item.Line})
+	}
+	for i, item := range previous {
+		switch {
+		case used[i]:
+			if item.pinned() {
+				update.Pinned++
+			}
+		case item.pinned():
+			// Insert it after the items that came before it.
+			at := 0
+			for j, e := range entries {
+				if e.from >= 0 && e.from < i {
+					at = j + 1
+				}
+			}
+			entries = slices.Insert(entries, at, entry{i, item.Line})
+			update.Pinned++
+		default:
+			update.Removed++
+		}
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = e.line
+	}
+	return lines, update
+}
+
+// formatSubTasks renders a sub-task comment from its checklist.
+func formatSubTasks(checklist string) string {
+	return fmt.Sprintf("%s\n\n%s\n\n%s", SubTasksIdentifier, subTasksIntro, checklist)
+}
+
+// updateSubTasks revises the previous sub-task comment for the current PRD
+// in place, so completed items, their links and the comment's URL survive.
+func (b *Bot) updateSubTasks(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, prd string, previous *github.IssueComment) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	items := parseChecklist(previous.GetBody())
+	changes, err := reviseChecklist(ctx, b.llm, prd, items)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "update the sub-tasks", "Could not revise the sub-tasks", err)
+		return
+	}
+	lines, update := mergeChecklist(items, changes)
+	if !update.changed() {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("The [sub-tasks](%s) are already up to date with the PRD. Run `@%s %s %s` for a new checklist.", previous.GetHTMLURL(), b.appName, CommandGenerateSubTask, subTasksFreshFlag))
+		return
+	}
+
+	body := formatSubTasks(strings.Join(lines, "\n"))
+	if areas := codeAreasSection(previous.GetBody()); areas != "" {
+		body += "\n\n" + areas
+	}
+	if cfg := b.repoConfig(ctx, client, repo).SubTaskOwners; cfg.enabled() {
+		body = b.addTaskOwners(ctx, client, repo, issueNum, prd, body, cfg)
+	}
+	edited, _, err := client.Issues.EditComment(ctx, repoOwner, repoName, previous.GetID(), &github.IssueComment{Body: github.String(b.stampArtifact(ctx, ArtifactSubTasks, body))})
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "update the sub-tasks", "Could not edit the sub-task comment", err)
+		return
+	}
+	log.Printf("Updated the sub-tasks of issue #%d: %s.", issueNum, update)
+	b.saveArtifact(ArtifactSubTasks, repoOwner, repoName, issue, body, edited)
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I've updated the [sub-tasks](%s) for the revised PRD: %s.", edited.GetHTMLURL(), update))
+}
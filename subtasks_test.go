@@ -0,0 +1,97 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const previousSubTasks = SubTasksIdentifier + "\n\n" + subTasksIntro + "\n\n" +
+	"- [x] Add the CSV encoder.\n" +
+	"- [ ] #51\n" +
+	"- [ ] Add an export button.\n" +
+	"- [ ] Email the report weekly.\n\n" +
+	OwnersIdentifier + "\n\n- Add the CSV encoder.: `bob` (CODEOWNERS)\n"
+
+func TestMergeChecklistKeepsCompletedAndLinkedItems(t *testing.T) {
+	previous := parseChecklist(previousSubTasks)
+	if len(previous) != 4 {
+		t.Fatalf("expected 4 items without the owners section, got %+v", previous)
+	}
+	lines, update := mergeChecklist(previous, []taskChange{
+		{Previous: 1, Text: "Rewrite the CSV encoder."},
+		{Previous: 3, Text: "Add an export button to every report."},
+		{Text: "Document the CSV format."},
+	})
+	want := []string{
+		"- [x] Add the CSV encoder.",
+		"- [ ] #51",
+		"- [ ] Add an export button to every report.",
+		"- [ ] Document the CSV format.",
+	}
+	if !slices.Equal(lines, want) {
+		t.Errorf("mergeChecklist =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+	if update != (checklistUpdate{Added: 1, Renamed: 1, Removed: 1, Pinned: 2}) {
+		t.Errorf("unexpected update %+v", update)
+	}
+}
+
+func TestSubTasksRerunUpdatesChecklist(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Requirements:** An export button on every report.")
+	previous := env.github.addComment("acme", "widgets", 42, previousSubTasks)
+	env.gemini.on("was revised after it was broken down", `{"tasks": [{"previous": 1}, {"previous": 2}, {"previous": 3, "text": "Add an export button to every report."}]}`)
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "1. [done] Add the CSV encoder.") || !strings.Contains(prompt, "4. [open] Email the report weekly.") {
+		t.Errorf("the prompt should number the previous sub-tasks:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 3 {
+		t.Fatalf("expected the checklist to be edited and a note posted, got %d comments", len(comments))
+	}
+	edited := comments[1].GetBody()
+	if comments[1].GetID() != previous.GetID() || !strings.Contains(edited, "- [x] Add the CSV encoder.\n- [ ] #51\n- [ ] Add an export button to every report.") || strings.Contains(edited, "weekly") {
+		t.Errorf("unexpected checklist:\n%s", edited)
+	}
+	if note := comments[2].GetBody(); !strings.Contains(note, "0 added, 1 renamed and 1 removed; 2 completed or linked sub-tasks were kept") {
+		t.Errorf("unexpected note:\n%s", note)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactSubTasks); artifact == nil || artifact.Markdown != stripArtifactMeta(edited) {
+		t.Errorf("the artifact should hold the updated checklist, got %+v", artifact)
+	}
+}
+
+func TestSubTasksRerunWithoutChanges(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.github.addComment("acme", "widgets", 42, previousSubTasks)
+	env.gemini.on("was revised after it was broken down", `{"tasks": [{"previous": 1}, {"previous": 2}, {"previous": 3}, {"previous": 4}]}`)
+
+	env.comment(t, "@prd-bot need_sub_task")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if comments[1].GetBody() != previousSubTasks {
+		t.Errorf("the checklist shouldn't change:\n%s", comments[1].GetBody())
+	}
+	if note := comments[len(comments)-1].GetBody(); !strings.Contains(note, "already up to date") {
+		t.Errorf("unexpected note:\n%s", note)
+	}
+}
+
+func TestSubTasksFreshFlagPostsNewChecklist(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, PRDIdentifier+prdSeparator+"1.  **Background:** B.")
+	env.github.addComment("acme", "widgets", 42, previousSubTasks)
+	env.gemini.on("Break down the following Product Requirements Document", "- [ ] Add the CSV encoder.")
+
+	env.comment(t, "@prd-bot need_sub_task --fresh")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if len(comments) != 3 || comments[1].GetBody() != previousSubTasks || !strings.HasPrefix(comments[2].GetBody(), SubTasksIdentifier) {
+		t.Errorf("expected a new checklist next to the previous one, got %d comments", len(comments))
+	}
+}
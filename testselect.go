@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// TestResultsIdentifier heads the results of the tests run before a pull
+	// request is opened.
+	TestResultsIdentifier = "### Test Results"
+
+	// Values of TestsConfig.Run.
+	testRunOff      = "off"      // don't run tests (default)
+	testRunAffected = "affected" // test the packages the change can affect
+	testRunAll      = "all"      // test every package
+
+	// coverProfileName is the coverage profile written in the working copy
+	// while the tests run. It is removed before anything is published.
+	coverProfileName = ".agent-prd-cover.out"
+
+	// maxTestOutput caps the test output quoted in pull requests, in bytes.
+	maxTestOutput = 4000
+	// maxListedFailures bounds the failed tests listed in pull requests.
+	maxListedFailures = 10
+)
+
+// TestsConfig controls the tests implement_feature runs on the change before
+// opening a pull request. Only Go modules are supported.
+type TestsConfig struct {
+	// Run is "affected" to test only the packages the change can affect
+	// through the import graph, "all", or "off" (default).
+	Run string `yaml:"run"`
+	// MaxPackages bounds the packages tested in affected mode. The packages
+	// closest to the change in the import graph are tested first. 0 means no
+	// limit.
+	MaxPackages int `yaml:"max_packages"`
+	// Timeout is passed to go test, e.g. "10m".
+	Timeout string `yaml:"timeout"`
+}
+
+func (c *TestsConfig) mode() string {
+	if c == nil || (c.Run != testRunAffected && c.Run != testRunAll) {
+		return testRunOff
+	}
+	return c.Run
+}
+
+// goPackage is a package of the module under test, as listed by go list.
+type goPackage struct {
+	ImportPath   string
+	Dir          string
+	Imports      []string
+	TestImports  []string
+	XTestImports []string
+}
+
+// testSelection is the packages chosen for a test run.
+type testSelection struct {
+	Changed  []string // packages with changed files
+	Packages []string // packages to test, closest to the change first; empty with All
+	All      bool     // test every package
+	Affected int      // packages the change can affect
+}
+
+// testRun is the outcome of the tests run on a change.
+type testRun struct {
+	Selection testSelection
+	Limit     int // the max_packages limit that applied, if any
+	Passed    bool
+	Failures  []string // failed tests or packages
+	Output    string   // go test output, kept on failure
+	// Covered and Statements count the statements in changed code that the
+	// tests executed, and all of them. Statements is 0 without coverage.
+	Covered, Statements int
+}
+
+// runChangeTests runs the tests the repository configured on the change made
+// in ws. It reports nothing when no tests are configured or the repository
+// isn't a Go module.
+func (b *Bot) runChangeTests(ctx context.Context, client *github.Client, repo *github.Repository, ws workspace, progress *progressComment) checkResult {
+	cfg := b.repoConfig(ctx, client, repo).Tests
+	if cfg.mode() == testRunOff {
+		return checkResult{}
+	}
+	dir := ws.dir()
+	if _, err := os.Stat(filepath.Join(dir, "go.mod")); err != nil {
+		log.Printf("Not running tests for %s: only Go modules are supported", repo.GetFullName())
+		return checkResult{}
+	}
+	diff, stats, err := ws.changes()
+	var run *testRun
+	if err == nil {
+		run, err = b.testChange(dir, cfg, diff, stats)
+	}
+	if err != nil {
+		log.Printf("Could not run the tests of %s: %v", repo.GetFullName(), err)
+		return checkResult{Section: fmt.Sprintf("%s\n\nI couldn't run the tests before opening this pull request. Run them before merging.", TestResultsIdentifier)}
+	}
+	if run == nil {
+		return checkResult{Section: fmt.Sprintf("%s\n\nNo Go package is affected by this change, so I didn't run any tests.", TestResultsIdentifier)}
+	}
+	if run.Passed {
+		progress.step("Ran the tests of %s: passed", run.scope())
+		return checkResult{Section: run.format()}
+	}
+	progress.step("Ran the tests of %s: failed", run.scope())
+	return checkResult{Section: run.format(), Failures: "$ go test\n" + tailOutput(run.Output)}
+}
+
+// testChange selects and runs the tests of the change in dir. It returns nil
+// when the change affects no package.
+func (b *Bot) testChange(dir string, cfg *TestsConfig, diff string, stats []fileStat) (*testRun, error) {
+	out, err := b.runner(dir, "go", "list", "-e", "-json=ImportPath,Dir,Imports,TestImports,XTestImports", "./...")
+	if err != nil {
+		return nil, fmt.Errorf("listing packages: %w: %s", err, out)
+	}
+	pkgs, err := parseGoList(out)
+	if err != nil {
+		return nil, fmt.Errorf("listing packages: %w", err)
+	}
+	changed := make([]string, len(stats))
+	for i, s := range stats {
+		changed[i] = s.Path
+	}
+	selection := selectTests(pkgs, dir, changed)
+	if cfg.mode() == testRunAll {
+		selection.All, selection.Packages = true, nil
+	}
+	run := &testRun{Selection: selection}
+	if !selection.All {
+		if len(selection.Packages) == 0 {
+			return nil, nil
+		}
+		if cfg.MaxPackages > 0 && len(selection.Packages) > cfg.MaxPackages {
+			run.Selection.Packages, run.Limit = selection.Packages[:cfg.MaxPackages], cfg.MaxPackages
+		}
+	}
+
+	args := []string{"test", "-count=1", "-coverprofile=" + coverProfileName}
+	if len(selection.Changed) > 0 {
+		args = append(args, "-coverpkg="+strings.Join(selection.Changed, ","))
+	}
+	if cfg.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid tests.timeout %q: %w", cfg.Timeout, err)
+		}
+		args = append(args, "-timeout="+cfg.Timeout)
+	}
+	if run.Selection.All {
+		args = append(args, "./...")
+	} else {
+		args = append(args, run.Selection.Packages...)
+	}
+	profile := filepath.Join(dir, coverProfileName)
+	defer os.Remove(profile)
+	out, err = b.runner(dir, "go", args...)
+	run.Passed = err == nil
+	if !run.Passed {
+		run.Failures, run.Output = testFailures(out), out
+	}
+	if data, err := os.ReadFile(profile); err == nil {
+		run.Covered, run.Statements = changeCoverage(parseCoverProfile(string(data)), pkgs, dir, diff, changed)
+	}
+	return run, nil
+}
+
+// parseGoList decodes the stream of JSON objects printed by go list -json.
+func parseGoList(out string) ([]goPackage, error) {
+	var pkgs []goPackage
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var pkg goPackage
+		if err := dec.Decode(&pkg); errors.Is(err, io.EOF) {
+			return pkgs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+}
+
+// selectTests returns the packages of pkgs, rooted at root, that the changed
+// files (relative to root) can affect: the packages holding them and the
+// packages importing those, directly or not, closest first. Changes to the
+// module files affect everything; Markdown files affect nothing.
+func selectTests(pkgs []goPackage, root string, changed []string) testSelection {
+	byDir := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		if rel, err := filepath.Rel(root, pkg.Dir); err == nil {
+			byDir[filepath.ToSlash(rel)] = pkg.ImportPath
+		}
+	}
+	var selection testSelection
+	distance := make(map[string]int)
+	for _, file := range changed {
+		switch path.Base(file) {
+		case "go.mod", "go.sum", "go.work", "go.work.sum":
+			if path.Dir(file) == "." {
+				selection.All = true
+			}
+		}
+		if path.Ext(file) == ".md" {
+			continue
+		}
+		// Files under a package directory, e.g. its testdata, belong to it.
+		for dir := path.Dir(file); ; dir = path.Dir(dir) {
+			if pkg, ok := byDir[dir]; ok {
+				if _, seen := distance[pkg]; !seen {
+					distance[pkg] = 0
+					selection.Changed = append(selection.Changed, pkg)
+				}
+				break
+			}
+			if dir == "." || dir == "/" {
+				break
+			}
+		}
+	}
+	slices.Sort(selection.Changed)
+
+	importers := make(map[string][]string)
+	for _, pkg := range pkgs {
+		for _, imp := range pkg.Imports {
+			importers[imp] = append(importers[imp], pkg.ImportPath)
+		}
+	}
+	queue := slices.Clone(selection.Changed)
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, importer := range importers[pkg] {
+			if _, seen := distance[importer]; !seen {
+				distance[importer] = distance[pkg] + 1
+				queue = append(queue, importer)
+			}
+		}
+	}
+	// Tests importing an affected package are affected too, but not the
+	// packages importing theirs.
+	for _, pkg := range pkgs {
+		if _, seen := distance[pkg.ImportPath]; seen {
+			continue
+		}
+		best := -1
+		for _, imp := range slices.Concat(pkg.TestImports, pkg.XTestImports) {
+			if d, ok := distance[imp]; ok && (best < 0 || d < best) {
+				best = d
+			}
+		}
+		if best >= 0 {
+			distance[pkg.ImportPath] = best + 1
+		}
+	}
+
+	for pkg := range distance {
+		selection.Packages = append(selection.Packages, pkg)
+	}
+	slices.SortFunc(selection.Packages, func(a, b string) int {
+		return cmp.Or(cmp.Compare(distance[a], distance[b]), strings.Compare(a, b))
+	})
+	selection.Affected = len(selection.Packages)
+	if selection.All {
+		selection.Packages = nil
+	}
+	return selection
+}
+
+var failedTestPattern = regexp.MustCompile(`(?m)^\s*--- FAIL: (\S+)|^FAIL\s+(\S+)\s`)
+
+// testFailures returns the failed tests in go test output, or the failed
+// packages when no test failed, e.g. because a package didn't build.
+func testFailures(out string) []string {
+	var tests, packages []string
+	for _, m := range failedTestPattern.FindAllStringSubmatch(out, -1) {
+		if m[1] != "" {
+			tests = append(tests, m[1])
+		} else if !slices.Contains(packages, m[2]) {
+			packages = append(packages, m[2])
+		}
+	}
+	if len(tests) > 0 {
+		return tests
+	}
+	return packages
+}
+
+// coverBlock is a block of statements of a coverage profile.
+type coverBlock struct {
+	StartLine, EndLine int
+	Statements, Count  int
+}
+
+// parseCoverProfile parses a Go coverage profile into blocks by file name
+// (import path and base name). Blocks reported by several test binaries are
+// merged, executed when any executed them.
+func parseCoverProfile(data string) map[string][]coverBlock {
+	type blockKey struct {
+		file  string
+		block string
+	}
+	merged := make(map[blockKey]coverBlock)
+	var order []blockKey
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		// name.go:12.34,15.2 3 1
+		line := scanner.Text()
+		if strings.HasPrefix(line, "mode:") {
+			continue
+		}
+		file, rest, ok := strings.Cut(line, ":")
+		fields := strings.Fields(rest)
+		if !ok || len(fields) != 3 {
+			continue
+		}
+		start, end, ok := strings.Cut(fields[0], ",")
+		if !ok {
+			continue
+		}
+		startLine, err1 := strconv.Atoi(strings.Split(start, ".")[0])
+		endLine, err2 := strconv.Atoi(strings.Split(end, ".")[0])
+		stmts, err3 := strconv.Atoi(fields[1])
+		count, err4 := strconv.Atoi(fields[2])
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			continue
+		}
+		key := blockKey{file, fields[0]}
+		block, seen := merged[key]
+		if !seen {
+			order = append(order, key)
+			block = coverBlock{StartLine: startLine, EndLine: endLine, Statements: stmts}
+		}
+		block.Count = max(block.Count, count)
+		merged[key] = block
+	}
+	blocks := make(map[string][]coverBlock)
+	for _, key := range order {
+		blocks[key.file] = append(blocks[key.file], merged[key])
+	}
+	return blocks
+}
+
+// changedLines returns the lines each file gained in a zero-context diff, by
+// path. Files whose diff has no hunk headers map to nil: all their lines
+// count as changed.
+func changedLines(diff string) map[string]map[int]bool {
+	lines := make(map[string]map[int]bool)
+	var current string
+	for _, line := range strings.Split(diff, "\n") {
+		if file, ok := strings.CutPrefix(line, "+++ b/"); ok {
+			current = file
+			if _, seen := lines[current]; !seen {
+				lines[current] = nil
+			}
+			continue
+		}
+		hunk, ok := strings.CutPrefix(line, "@@ ")
+		if !ok || current == "" {
+			continue
+		}
+		// @@ -a,b +c,d @@
+		fields := strings.Fields(hunk)
+		if len(fields) < 2 || !strings.HasPrefix(fields[1], "+") {
+			continue
+		}
+		startText, countText, hasCount := strings.Cut(strings.TrimPrefix(fields[1], "+"), ",")
+		start, err := strconv.Atoi(startText)
+		count := 1
+		if hasCount {
+			count, err = strconv.Atoi(countText)
+		}
+		if err != nil {
+			continue
+		}
+		if lines[current] == nil {
+			lines[current] = make(map[int]bool)
+		}
+		for n := start; n < start+count; n++ {
+			lines[current][n] = true
+		}
+	}
+	return lines
+}
+
+// changeCoverage counts the statements of the changed Go files (relative to
+// root) that the profile reports executed, and all their statements. Only
+// the blocks touching changed lines count.
+func changeCoverage(profile map[string][]coverBlock, pkgs []goPackage, root, diff string, changed []string) (covered, statements int) {
+	relDir := make(map[string]string, len(pkgs))
+	for _, pkg := range pkgs {
+		if rel, err := filepath.Rel(root, pkg.Dir); err == nil {
+			relDir[pkg.ImportPath] = filepath.ToSlash(rel)
+		}
+	}
+	lines := changedLines(diff)
+	for name, blocks := range profile {
+		dir, ok := relDir[path.Dir(name)]
+		if !ok {
+			continue
+		}
+		file := path.Join(dir, path.Base(name))
+		if !slices.Contains(changed, file) {
+			continue
+		}
+		fileLines, inDiff := lines[file]
+		for _, block := range blocks {
+			touched := !inDiff || fileLines == nil
+			for n := block.StartLine; !touched && n <= block.EndLine; n++ {
+				touched = fileLines[n]
+			}
+			if !touched {
+				continue
+			}
+			statements += block.Statements
+			if block.Count > 0 {
+				covered += block.Statements
+			}
+		}
+	}
+	return covered, statements
+}
+
+// scope describes the packages tested, e.g. "3 packages".
+func (r *testRun) scope() string {
+	if r.Selection.All {
+		return "every package"
+	}
+	if len(r.Selection.Packages) == 1 {
+		return "1 package"
+	}
+	return fmt.Sprintf("%d packages", len(r.Selection.Packages))
+}
+
+// format renders the run as a pull request body section.
+func (r *testRun) format() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n\n", TestResultsIdentifier)
+	if r.Passed {
+		fmt.Fprintf(&b, "**Passed:** the tests of %s passed on this change.", r.scope())
+	} else {
+		fmt.Fprintf(&b, "> [!WARNING]\n> The tests of %s failed on this change. Fix them before merging.", r.scope())
+	}
+	if r.Selection.All {
+		b.WriteString("\n\n- **Selection:** every package of the module.")
+	} else {
+		fmt.Fprintf(&b, "\n\n- **Selection:** the packages the change can affect through the import graph, closest first: `%s`.", strings.Join(r.Selection.Packages, "`, `"))
+		if r.Limit > 0 {
+			fmt.Fprintf(&b, " %d more affected package(s) weren't tested because of the `max_packages: %d` limit.", r.Selection.Affected-len(r.Selection.Packages), r.Limit)
+		}
+	}
+	if r.Statements > 0 {
+		fmt.Fprintf(&b, "\n- **Coverage of the change:** %d of %d statements in changed code (%.1f%%).", r.Covered, r.Statements, 100*float64(r.Covered)/float64(r.Statements))
+	} else {
+		b.WriteString("\n- **Coverage of the change:** unknown; no tested statement is part of the change.")
+	}
+	if len(r.Failures) > 0 {
+		failures := r.Failures[:min(len(r.Failures), maxListedFailures)]
+		fmt.Fprintf(&b, "\n- **Failures:** `%s`", strings.Join(failures, "`, `"))
+		if more := len(r.Failures) - len(failures); more > 0 {
+			fmt.Fprintf(&b, " and %d more", more)
+		}
+		b.WriteString(".")
+	}
+	if !r.Passed && r.Output != "" {
+		fmt.Fprintf(&b, "\n\n<details>\n<summary>Test output</summary>\n\n```\n%s\n```\n</details>", tailOutput(r.Output))
+	}
+	return b.String()
+}
+
+// tailOutput trims command output to its last maxTestOutput bytes, where
+// test and build tools report failures.
+func tailOutput(out string) string {
+	out = strings.TrimSpace(out)
+	if len(out) > maxTestOutput {
+		out = "[output truncated]\n" + out[len(out)-maxTestOutput:]
+	}
+	return out
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// testModule is a module at root: the root package imports report, which
+// imports export; cli only imports report in its tests, and api imports cli.
+func testModule(root string) []goPackage {
+	return []goPackage{
+		{ImportPath: "ex.com/m", Dir: root, Imports: []string{"ex.com/m/report", "fmt"}},
+		{ImportPath: "ex.com/m/report", Dir: filepath.Join(root, "report"), Imports: []string{"ex.com/m/export"}},
+		{ImportPath: "ex.com/m/export", Dir: filepath.Join(root, "export"), Imports: []string{"encoding/csv"}, TestImports: []string{"testing"}},
+		{ImportPath: "ex.com/m/cli", Dir: filepath.Join(root, "cli"), XTestImports: []string{"ex.com/m/report"}},
+		{ImportPath: "ex.com/m/api", Dir: filepath.Join(root, "api"), Imports: []string{"ex.com/m/cli"}},
+		{ImportPath: "ex.com/m/util", Dir: filepath.Join(root, "util")},
+	}
+}
+
+func TestSelectTests(t *testing.T) {
+	root := filepath.FromSlash("/work")
+	selection := selectTests(testModule(root), root, []string{"export/csv.go", "export/testdata/rows.csv", "README.md"})
+	if !slices.Equal(selection.Changed, []string{"ex.com/m/export"}) {
+		t.Errorf("changed packages = %v", selection.Changed)
+	}
+	want := []string{"ex.com/m/export", "ex.com/m/report", "ex.com/m", "ex.com/m/cli"}
+	if !slices.Equal(selection.Packages, want) || selection.All || selection.Affected != 4 {
+		t.Errorf("selection = %+v, want packages %v", selection, want)
+	}
+
+	if selection := selectTests(testModule(root), root, []string{"docs/guide.md"}); len(selection.Packages) != 0 {
+		t.Errorf("documentation changes shouldn't select tests, got %v", selection.Packages)
+	}
+	if selection := selectTests(testModule(root), root, []string{"go.mod", "util/util.go"}); !selection.All || selection.Packages != nil {
+		t.Errorf("changing go.mod should test everything, got %+v", selection)
+	}
+}
+
+func TestChangeCoverage(t *testing.T) {
+	root := filepath.FromSlash("/work")
+	profile := parseCoverProfile("mode: set\n" +
+		"ex.com/m/export/csv.go:11.2,13.3 2 0\n" +
+		"ex.com/m/export/csv.go:20.1,22.2 4 0\n" +
+		"ex.com/m/export/csv.go:12.1,12.9 1 0\n" +
+		"ex.com/m/report/report.go:1.1,2.2 5 1\n" +
+		"ex.com/m/export/csv.go:11.2,13.3 2 1\n")
+	if blocks := profile["ex.com/m/export/csv.go"]; len(blocks) != 3 || blocks[0].Count != 1 {
+		t.Fatalf("blocks of several test binaries should merge, got %+v", blocks)
+	}
+	diff := "diff --git a/export/csv.go b/export/csv.go\n--- a/export/csv.go\n+++ b/export/csv.go\n@@ -10,0 +11,3 @@\n+a\n+b\n+c\n"
+	covered, statements := changeCoverage(profile, testModule(root), root, diff, []string{"export/csv.go"})
+	if covered != 2 || statements != 3 {
+		t.Errorf("changeCoverage = %d of %d, want 2 of 3", covered, statements)
+	}
+
+	// Without hunks, as from the contents API backend, whole files count.
+	covered, statements = changeCoverage(profile, testModule(root), root, "--- a/export/csv.go\n+++ b/export/csv.go\n+a\n", []string{"export/csv.go"})
+	if covered != 2 || statements != 7 {
+		t.Errorf("changeCoverage without hunks = %d of %d, want 2 of 7", covered, statements)
+	}
+}
+
+func TestTestFailures(t *testing.T) {
+	out := "--- FAIL: TestExport (0.00s)\n    csv_test.go:9: oops\nFAIL\nFAIL\tex.com/m/export\t0.01s\nok  \tex.com/m/report\t0.02s\n"
+	if got := testFailures(out); !slices.Equal(got, []string{"TestExport"}) {
+		t.Errorf("testFailures = %v", got)
+	}
+	out = "# ex.com/m/export\nexport/csv.go:3:1: syntax error\nFAIL\tex.com/m/export [build failed]\n"
+	if got := testFailures(out); !slices.Equal(got, []string{"ex.com/m/export"}) {
+		t.Errorf("testFailures of a build failure = %v", got)
+	}
+}
+
+// goModuleRunner makes the fake clone a Go module with the packages of
+// testModule, changed in export/csv.go.
+func goModuleRunner(t *testing.T, env *testEnv) {
+	t.Helper()
+	env.runner.effects = map[string]func(string){
+		"git clone": func(dir string) {
+			if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module ex.com/m\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		},
+		"go test": func(dir string) {
+			profile := "mode: set\nex.com/m/export/csv.go:11.2,13.3 3 1\nex.com/m/export/csv.go:14.1,14.9 1 0\n"
+			if err := os.WriteFile(filepath.Join(dir, coverProfileName), []byte(profile), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	env.runner.outputs = map[string]string{
+		"--unified=0": "--- a/export/csv.go\n+++ b/export/csv.go\n@@ -10,0 +11,4 @@\n+a\n+b\n+c\n+d\n",
+		"--numstat":   "4\t0\texport/csv.go\n",
+	}
+	env.runner.replies = map[string]func(string) string{
+		"go list": func(dir string) string {
+			var out strings.Builder
+			for _, pkg := range testModule(dir) {
+				data, _ := json.Marshal(pkg)
+				out.Write(data)
+				out.WriteString("\n")
+			}
+			return out.String()
+		},
+	}
+}
+
+func TestImplementFeatureRunsAffectedTests(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "tests:\n  run: affected\n  max_packages: 2\n  timeout: 5m\n")
+	goModuleRunner(t, env)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	var goTest string
+	for _, line := range env.runner.executed() {
+		if strings.HasPrefix(line, "go test") {
+			goTest = line
+		}
+	}
+	if want := "go test -count=1 -coverprofile=" + coverProfileName + " -coverpkg=ex.com/m/export -timeout=5m ex.com/m/export ex.com/m/report"; goTest != want {
+		t.Errorf("ran %q, want %q", goTest, want)
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("expected a pull request, got %d", len(pulls))
+	}
+	body := pulls[0].GetBody()
+	for _, want := range []string{TestResultsIdentifier, "**Passed:** the tests of 2 packages passed", "`ex.com/m/export`, `ex.com/m/report`", "2 more affected package(s) weren't tested", "3 of 4 statements in changed code (75.0%)"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("pull request body should contain %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestImplementFeatureReportsFailingTests(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "tests:\n  run: all\n")
+	goModuleRunner(t, env)
+	env.runner.failOn = "go test"
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	executed := strings.Join(env.runner.executed(), "\n")
+	if !strings.Contains(executed, "-coverpkg=ex.com/m/export ./...") {
+		t.Errorf("expected every package to be tested:\n%s", executed)
+	}
+	pulls := env.github.pullRequests()
+	if len(pulls) != 1 {
+		t.Fatalf("failing tests shouldn't prevent the pull request, got %d", len(pulls))
+	}
+	body := pulls[0].GetBody()
+	if !strings.Contains(body, "> [!WARNING]\n> The tests of every package failed") || !strings.Contains(body, "simulated failure") {
+		t.Errorf("the pull request should report the failure:\n%s", body)
+	}
+}
+
+func TestImplementFeatureWithoutTestsConfig(t *testing.T) {
+	env := newTestEnv(t)
+	goModuleRunner(t, env)
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	for _, line := range env.runner.executed() {
+		if strings.HasPrefix(line, "go ") {
+			t.Errorf("no Go command should run without tests configured, ran %q", line)
+		}
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 1 || strings.Contains(pulls[0].GetBody(), TestResultsIdentifier) {
+		t.Errorf("expected a pull request without test results")
+	}
+}
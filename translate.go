@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandTranslatePRD = "translate_prd"
+
+	// maxTranslationRefreshes bounds the PRDs retranslated when the
+	// configured language of a repository changes; the most recent ones are
+	// retranslated first.
+	maxTranslationRefreshes = 20
+)
+
+// languageCodes maps common language codes to the language names used in
+// prompts and PRD headings.
+var languageCodes = map[string]string{
+	"de": "German", "en": "English", "es": "Spanish", "fr": "French", "id": "Indonesian",
+	"it": "Italian", "ja": "Japanese", "ko": "Korean", "pt": "Portuguese", "ru": "Russian",
+	"th": "Thai", "vi": "Vietnamese", "zh": "Chinese",
+	"zh-cn": "Simplified Chinese", "zh-hans": "Simplified Chinese",
+	"zh-tw": "Traditional Chinese", "zh-hant": "Traditional Chinese",
+}
+
+// languageName returns the language name for a code such as "ja" or "zh-TW",
+// or language itself when it isn't a known code.
+func languageName(language string) string {
+	language = strings.TrimSpace(language)
+	if name, ok := languageCodes[strings.ToLower(strings.ReplaceAll(language, "_", "-"))]; ok {
+		return name
+	}
+	return language
+}
+
+// processTranslatePRD replaces the translation of the issue's PRD, e.g.
+// `@bot translate_prd ja`, or translates it into the configured language
+// when no language is given. The English PRD is left as it is.
+func (b *Bot) processTranslatePRD(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandTranslatePRD, issueNum, repoOwner, repoName)
+
+	language := languageName(strings.Join(args, " "))
+	if language == "" {
+		language = languageName(b.repoConfig(ctx, client, repo).Language)
+	}
+	if language == "" {
+		usage := fmt.Sprintf("Usage: `@%s %s <language>`, e.g. `ja` or `Traditional Chinese`. Without a language I use the `language` of `%s`, which isn't set.", b.appName, CommandTranslatePRD, RepoConfigPath)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, usage)
+		return
+	}
+
+	prdComment, err := b.findPRDComment(ctx, client, repoOwner, repoName, issueNum)
+	if err != nil || prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting translation.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to translate. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	doc, ok := parsePRDDocument(prdComment.GetBody())
+	if !ok {
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't recognize the layout of the PRD comment, so I left it unchanged.")
+		return
+	}
+
+	if err := b.retranslatePRD(ctx, client, repoOwner, repoName, issue, prdComment.GetID(), doc, language); err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "translate the PRD", "Could not translate the PRD", err)
+		return
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, fmt.Sprintf("I translated the [PRD](%s) into %s; the English PRD is unchanged.", prdComment.GetHTMLURL(), language))
+}
+
+// retranslatePRD replaces the translation of the PRD doc, posted as comment
+// commentID, with one into language.
+func (b *Bot) retranslatePRD(ctx context.Context, client *github.Client, owner, repo string, issue *github.Issue, commentID int64, doc *PRDDocument, language string) error {
+	translated, err := translatePRD(ctx, b.llm, doc.English, language)
+	if err != nil {
+		return err
+	}
+	doc.Language, doc.Translated = language, translated
+	doc.revise(fmt.Sprintf("Translated into %s.", language))
+	body := doc.String()
+	edited, _, err := client.Issues.EditComment(ctx, owner, repo, commentID, &github.IssueComment{Body: github.String(b.stampArtifact(ctx, ArtifactPRD, body))})
+	if err != nil {
+		return fmt.Errorf("updating the PRD comment: %w", err)
+	}
+	b.saveArtifact(ArtifactPRD, owner, repo, issue, body, edited)
+	return nil
+}
+
+func translatePRD(ctx context.Context, llm Generator, english, language string) (string, error) {
+	prompt := fmt.Sprintf("Translate the following English PRD into %s. Maintain the original formatting and structure.\n\n**English PRD:**\n%s", language, english)
+	translated, err := generateMarkdown(withSystemPrompt(ctx, promptTranslate), llm, prompt)
+	if err != nil {
+		return "", fmt.Errorf("failed to translate PRD: %w", err)
+	}
+	return translated, nil
+}
+
+// configChanged reports whether the push changed the configuration file.
+func configChanged(event *github.PushEvent) bool {
+	for _, commit := range event.Commits {
+		if slices.Contains(commit.Added, RepoConfigPath) || slices.Contains(commit.Modified, RepoConfigPath) || slices.Contains(commit.Removed, RepoConfigPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshPRDTranslations retranslates the PRDs stored for owner/repo that
+// aren't translated into the configured language, after a push changed the
+// configuration. The PRD comments are read again, as they may have been
+// edited since; those that no longer parse are left alone.
+func (b *Bot) refreshPRDTranslations(ctx context.Context, client *github.Client, owner, name string) {
+	repo := &github.Repository{Owner: &github.User{Login: github.String(owner)}, Name: github.String(name), FullName: github.String(owner + "/" + name)}
+	cfg := b.repoConfig(ctx, client, repo)
+	language := languageName(cfg.Language)
+	if language == "" {
+		return
+	}
+	ctx = withPrompts(ctx, cfg, repo)
+	docs, err := b.store.List(bucketArtifacts)
+	if err != nil {
+		log.Printf("Error listing the PRDs of %s/%s to retranslate: %v", owner, name, err)
+		return
+	}
+	var prds []*Artifact
+	for _, data := range docs {
+		var artifact Artifact
+		if err := json.Unmarshal(data, &artifact); err != nil {
+			continue
+		}
+		if artifact.Kind == ArtifactPRD && strings.EqualFold(artifact.Owner, owner) && strings.EqualFold(artifact.Repo, name) {
+			prds = append(prds, &artifact)
+		}
+	}
+	slices.SortFunc(prds, func(a, b *Artifact) int { return b.CreatedAt.Compare(a.CreatedAt) })
+
+	refreshed := 0
+	for _, prd := range prds {
+		comment, err := b.findPRDComment(ctx, client, owner, name, prd.Issue)
+		if err != nil || comment == nil {
+			log.Printf("Skipping the PRD of issue #%d in %s/%s: %v", prd.Issue, owner, name, err)
+			continue
+		}
+		doc, ok := parsePRDDocument(comment.GetBody())
+		if !ok || strings.EqualFold(doc.Language, language) {
+			continue
+		}
+		if refreshed == maxTranslationRefreshes {
+			log.Printf("Retranslated %d PRDs of %s/%s into %s; run `%s` on older issues.", refreshed, owner, name, language, CommandTranslatePRD)
+			return
+		}
+		issue := &github.Issue{Number: github.Int(prd.Issue), Title: github.String(prd.Title)}
+		if err := b.retranslatePRD(ctx, client, owner, name, issue, comment.GetID(), doc, language); err != nil {
+			log.Printf("Error retranslating the PRD of issue #%d in %s/%s: %v", prd.Issue, owner, name, err)
+			continue
+		}
+		refreshed++
+	}
+	if refreshed > 0 {
+		log.Printf("Retranslated %d PRDs of %s/%s into %s.", refreshed, owner, name, language)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v58/github"
+)
+
+var translatedPRD = (&PRDDocument{
+	English:    "1.  **Background:** Users export reports as CSV.",
+	Language:   "Japanese",
+	Translated: "1.  **背景:** ユーザーはレポートを CSV でエクスポートします。",
+	Footer:     ReviewersIdentifier + "\n\n@alice",
+}).String()
+
+func TestTranslatePRDReplacesOnlyTheTranslation(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, translatedPRD)
+	env.gemini.on("Translate the following English PRD into Korean", "1.  **배경:** 사용자는 보고서를 CSV로 내보냅니다.")
+
+	env.comment(t, "@prd-bot translate_prd ko")
+
+	prompts := env.gemini.receivedPrompts()
+	if len(prompts) != 1 || !strings.Contains(prompts[0], "Users export reports as CSV.") {
+		t.Fatalf("expected a single translation request of the English PRD, got %q", prompts)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	doc, ok := parsePRDDocument(comments[0].GetBody())
+	if !ok || doc.Language != "Korean" || !strings.Contains(doc.Translated, "배경") {
+		t.Fatalf("expected a Korean translation:\n%s", comments[0].GetBody())
+	}
+	if doc.English != "1.  **Background:** Users export reports as CSV." || !strings.Contains(doc.Footer, "@alice") {
+		t.Errorf("the English PRD and the footer should be unchanged:\n%s", comments[0].GetBody())
+	}
+	if !strings.Contains(comments[len(comments)-1].GetBody(), "translated the [PRD]") {
+		t.Errorf("expected a confirmation, got:\n%s", comments[len(comments)-1].GetBody())
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactPRD); artifact == nil || artifact.Markdown != stripArtifactMeta(comments[0].GetBody()) {
+		t.Errorf("the PRD artifact should be updated, got %+v", artifact)
+	}
+}
+
+func TestTranslatePRDWithoutLanguage(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, translatedPRD)
+
+	env.comment(t, "@prd-bot translate_prd")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("nothing should be translated without a language, got %d prompts", len(prompts))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "Usage:") {
+		t.Errorf("expected usage, got:\n%s", body)
+	}
+}
+
+// pushPayload is the push fixture with one commit modifying files.
+func pushPayload(t *testing.T, modified ...string) []byte {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(loadFixture(t, "webhooks/push_main.json"), &event); err != nil {
+		t.Fatalf("decoding push fixture: %v", err)
+	}
+	event["commits"] = []map[string]any{{"id": "2222222222222222222222222222222222222222", "modified": modified}}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding push payload: %v", err)
+	}
+	return payload
+}
+
+func TestConfigLanguageChangeRetranslatesPRDs(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "language: Japanese\n")
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	current := env.github.addComment("acme", "widgets", 42, translatedPRD)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, translatedPRD, current)
+	issue7 := &github.Issue{Number: github.Int(7), Title: github.String("Dark mode")}
+	korean := strings.Replace(translatedPRD, "(Japanese)", "(Korean)", 1)
+	done := env.github.addComment("acme", "widgets", 7, korean)
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue7, korean, done)
+	env.gemini.on("Translate the following English PRD into Korean", "1.  **배경:** CSV.")
+
+	// The cached configuration must not hide the change.
+	client, _ := env.github.Client(7)
+	env.bot.repoConfig(t.Context(), client, &github.Repository{Owner: &github.User{Login: github.String("acme")}, Name: github.String("widgets")})
+	env.github.addFile("acme", "widgets", RepoConfigPath, "language: ko\n")
+	env.deliverPayload(t, "push", pushPayload(t, "README.md", RepoConfigPath))
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 1 {
+		t.Fatalf("only the Japanese PRD should be retranslated, got %d prompts", len(prompts))
+	}
+	if doc, _ := parsePRDDocument(env.github.issueComments("acme", "widgets", 42)[0].GetBody()); doc == nil || doc.Language != "Korean" {
+		t.Errorf("the PRD of #42 should be translated into Korean, got %+v", doc)
+	}
+	if body := env.github.issueComments("acme", "widgets", 7)[0].GetBody(); body != korean {
+		t.Errorf("the PRD already in Korean should be unchanged:\n%s", body)
+	}
+}
+
+func TestPushWithoutConfigChangeKeepsTranslations(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", RepoConfigPath, "language: Korean\n")
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, translatedPRD, env.github.addComment("acme", "widgets", 42, translatedPRD))
+
+	env.deliverPayload(t, "push", pushPayload(t, "README.md"))
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("a push that doesn't change the configuration shouldn't retranslate, got %d prompts", len(prompts))
+	}
+}
+
+func TestLanguageName(t *testing.T) {
+	for in, want := range map[string]string{"ja": "Japanese", "zh_TW": "Traditional Chinese", "ZH-hant": "Traditional Chinese", "Klingon": "Klingon", " ": ""} {
+		if got := languageName(in); got != want {
+			t.Errorf("languageName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bradleyfalzon/ghinstallation/v2"
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// githubRequestTimeout bounds a single GitHub API request.
+	githubRequestTimeout = time.Minute
+	// modelRequestTimeout bounds a single model request; generation is slow.
+	modelRequestTimeout = 5 * time.Minute
+	// installationClientTTL is how long an installation's client is reused.
+	// Its transport refreshes the installation token by itself, so expiry only
+	// keeps the cache from growing with installations that went quiet.
+	installationClientTTL = time.Hour
+)
+
+// sharedTransport is the connection pool used for all GitHub and model
+// traffic, so connections are kept alive and reused across requests.
+var sharedTransport = newHTTPTransport()
+
+// githubTransport and geminiTransport carry the GitHub API and Gemini
+// traffic over sharedTransport. CHAOS wraps them to inject faults.
+var (
+	githubTransport http.RoundTripper = sharedTransport
+	geminiTransport http.RoundTripper = sharedTransport
+)
+
+func newHTTPTransport() *http.Transport {
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   20,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// githubServer is the GitHub instance the bot talks to: github.com, or a
+// GitHub Enterprise Server named by GITHUB_API_URL and GITHUB_UPLOAD_URL.
+type githubServer struct {
+	apiURL    string // empty for github.com
+	uploadURL string // defaults to the web URL of apiURL
+}
+
+// newClient returns a GitHub client for the server sending requests through
+// httpClient, or http.DefaultClient when it is nil.
+func (s githubServer) newClient(httpClient *http.Client) *github.Client {
+	client := github.NewClient(httpClient)
+	if s.apiURL == "" {
+		return client
+	}
+	uploadURL := cmp.Or(s.uploadURL, s.webURL())
+	// validate has checked the URLs.
+	enterprise, err := client.WithEnterpriseURLs(s.apiURL, uploadURL)
+	if err != nil {
+		return client
+	}
+	return enterprise
+}
+
+// apiBaseURL is the API root as ghinstallation takes it, without the
+// trailing slash.
+func (s githubServer) apiBaseURL() string {
+	return strings.TrimSuffix(s.newClient(nil).BaseURL.String(), "/")
+}
+
+// host returns the host repositories are cloned from, e.g. github.com.
+func (s githubServer) host() string {
+	if s.apiURL == "" {
+		return defaultGitHost
+	}
+	u, err := url.Parse(s.apiURL)
+	if err != nil {
+		return defaultGitHost
+	}
+	return u.Host
+}
+
+// webURL returns the root of the server's web pages, e.g.
+// https://github.com.
+func (s githubServer) webURL() string {
+	if u, err := url.Parse(s.apiURL); err == nil && s.apiURL != "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return "https://" + defaultGitHost
+}
+
+// appClientFactory authenticates as a GitHub App installation. It signs app
+// JWTs with a single app transport and reuses each installation's client,
+// and so its cached token, until the client expires.
+type appClientFactory struct {
+	apps   *ghinstallation.AppsTransport
+	server githubServer
+	signer *rotatingSigner
+	ttl    time.Duration
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[int64]*installationClient
+}
+
+type installationClient struct {
+	transport *ghinstallation.Transport
+	client    *github.Client
+	expires   time.Time
+}
+
+func newAppClientFactory(appID int64, privateKey []byte, server githubServer) (*appClientFactory, error) {
+	signer := &rotatingSigner{}
+	if err := signer.set(privateKey); err != nil {
+		return nil, fmt.Errorf("failed to create app transport: %w", err)
+	}
+	apps, err := ghinstallation.NewAppsTransportWithOptions(githubTransport, appID, ghinstallation.WithSigner(signer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create app transport: %w", err)
+	}
+	apps.BaseURL = server.apiBaseURL()
+	return &appClientFactory{
+		apps:    apps,
+		server:  server,
+		signer:  signer,
+		ttl:     installationClientTTL,
+		now:     time.Now,
+		clients: make(map[int64]*installationClient),
+	}, nil
+}
+
+// rotateKey signs the App's next JWTs with the PEM key privateKey. The
+// installation tokens minted with the previous key stay valid until they
+// expire.
+func (f *appClientFactory) rotateKey(privateKey []byte) error {
+	return f.signer.set(privateKey)
+}
+
+// installation returns the cached client of the installation, creating it
+// when it is missing or expired.
+func (f *appClientFactory) installation(installationID int64) *installationClient {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := f.now()
+	if c, ok := f.clients[installationID]; ok && now.Before(c.expires) {
+		return c
+	}
+	for id, c := range f.clients {
+		if !now.Before(c.expires) {
+			delete(f.clients, id)
+		}
+	}
+	itr := ghinstallation.NewFromAppsTransport(f.apps, installationID)
+	c := &installationClient{
+		transport: itr,
+		client:    f.server.newClient(&http.Client{Transport: itr, Timeout: githubRequestTimeout}),
+		expires:   now.Add(f.ttl),
+	}
+	f.clients[installationID] = c
+	return c
+}
+
+// Client returns a GitHub client authenticated as the given installation.
+func (f *appClientFactory) Client(installationID int64) (*github.Client, error) {
+	return f.installation(installationID).client, nil
+}
+
+// Token returns an installation access token usable for git over HTTPS.
+func (f *appClientFactory) Token(ctx context.Context, installationID int64) (string, error) {
+	token, err := f.installation(installationID).transport.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get installation token: %w", err)
+	}
+	return token, nil
+}
+
+// Permissions returns the permissions granted to the installation, as
+// reported with its access token.
+func (f *appClientFactory) Permissions(ctx context.Context, installationID int64) (map[string]string, error) {
+	transport := f.installation(installationID).transport
+	if _, err := transport.Token(ctx); err != nil {
+		return nil, fmt.Errorf("failed to get installation token: %w", err)
+	}
+	perms, err := transport.Permissions()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"contents":      perms.GetContents(),
+		"pull_requests": perms.GetPullRequests(),
+		"issues":        perms.GetIssues(),
+	}, nil
+}
+
+// RepoInstallation returns the ID of the app's installation on owner/repo.
+func (f *appClientFactory) RepoInstallation(ctx context.Context, owner, repo string) (int64, error) {
+	client := f.server.newClient(&http.Client{Transport: f.apps, Timeout: githubRequestTimeout})
+	installation, _, err := client.Apps.FindRepositoryInstallation(ctx, owner, repo)
+	if err != nil {
+		return 0, err
+	}
+	return installation.GetID(), nil
+}
+
+// patClientFactory authenticates every request with a personal access token,
+// for users who cannot install a GitHub App. Installation IDs are ignored.
+type patClientFactory struct {
+	token  string
+	client *github.Client
+}
+
+func newPATClientFactory(token string, server githubServer) *patClientFactory {
+	client := server.newClient(&http.Client{Transport: githubTransport, Timeout: githubRequestTimeout}).WithAuthToken(token)
+	return &patClientFactory{token: token, client: client}
+}
+
+// Client returns a GitHub client authenticated with the token.
+func (f *patClientFactory) Client(int64) (*github.Client, error) {
+	return f.client, nil
+}
+
+// Token returns the personal access token for git over HTTPS.
+func (f *patClientFactory) Token(context.Context, int64) (string, error) {
+	return f.token, nil
+}
+
+// login returns the login of the token's user, used as the bot's mention name.
+func (f *patClientFactory) login(ctx context.Context) (string, error) {
+	user, _, err := f.client.Users.Get(ctx, "")
+	if err != nil {
+		return "", err
+	}
+	return user.GetLogin(), nil
+}
+
+// apiKeyTransport adds a Google API key to requests. The genai SDK ignores
+// option.WithAPIKey when given an HTTP client, so the key is added here.
+type apiKeyTransport struct {
+	key  string
+	base http.RoundTripper
+}
+
+func (t *apiKeyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("x-goog-api-key", t.key)
+	return t.base.RoundTrip(req)
+}
+
+// geminiHTTPClient returns the pooled HTTP client used for Gemini requests.
+func geminiHTTPClient(apiKey string) *http.Client {
+	return &http.Client{Transport: &apiKeyTransport{key: apiKey, base: geminiTransport}, Timeout: modelRequestTimeout}
+}
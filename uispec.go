@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	CommandUISpec = "need_ui_spec"
+
+	// UISpecIdentifier marks comments produced by the need_ui_spec command.
+	UISpecIdentifier = "### UI Specification"
+
+	ArtifactUISpec = "ui_spec"
+
+	// maxMockups bounds the images of an issue sent to the model.
+	maxMockups = 4
+	// maxMockupSize caps the size of one mockup image, in bytes.
+	maxMockupSize = 4 << 20
+)
+
+// mockupHosts are the hosts mockups are downloaded from, matched with
+// path.Match: the ones GitHub serves issue attachments from. Images linked
+// from elsewhere are listed in the prompt without being downloaded.
+var mockupHosts = []string{"github.com", "*.githubusercontent.com"}
+
+// mockupTypes are the image types the model accepts.
+var mockupTypes = map[string]bool{"image/png": true, "image/jpeg": true, "image/webp": true, "image/gif": true}
+
+// mockupPattern matches Markdown images and HTML img tags, the forms GitHub
+// uses for images attached to issues.
+var mockupPattern = regexp.MustCompile(`!\[[^\]]*\]\(\s*<?(https?://[^)\s>]+)>?[^)]*\)|<img\s[^>]*src\s*=\s*["'](https?://[^"']+)["']`)
+
+// ImageGenerator is implemented by generators that accept images along with
+// the prompt.
+type ImageGenerator interface {
+	GenerateWithImages(ctx context.Context, prompt string, images []modelImage) (string, error)
+}
+
+// modelImage is an image sent to the model.
+type modelImage struct {
+	MIMEType string
+	Data     []byte
+}
+
+// processUISpec posts a UI specification of the feature described by the
+// PRD: its screens, their states and a component inventory. Mockups attached
+// to the issue are shown to the model when it accepts images.
+func (b *Bot) processUISpec(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandUISpec, issueNum, repoOwner, repoName)
+
+	version, _ := prdVersionArg(args)
+	prdComment, ok := b.findPRDVersion(ctx, client, repoOwner, repoName, issueNum, version)
+	if !ok {
+		return
+	}
+	if prdComment == nil {
+		log.Printf("No PRD comment found for issue #%d. Aborting UI specification.", issueNum)
+		noPrdMessage := fmt.Sprintf("I couldn't find a PRD to specify the UI for. Please run `@%s %s` first.", b.appName, CommandGeneratePRD)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, noPrdMessage)
+		return
+	}
+	prd := prdComment.GetBody()
+	if doc, ok := parsePRDDocument(prd); ok {
+		prd = doc.English
+	}
+
+	urls := mockupURLs(issue.GetBody())
+	var images []modelImage
+	if _, ok := b.llm.(ImageGenerator); ok {
+		images = downloadMockups(ctx, urls)
+	}
+
+	spec, err := generateUISpec(ctx, b.llm, issue, prd, urls, images)
+	if err != nil {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "specify the UI", "Could not generate the UI specification", err)
+		return
+	}
+
+	body := UISpecIdentifier + "\n\n" + spec
+	switch {
+	case len(images) > 0:
+		body += fmt.Sprintf("\n\n_Based on the PRD and %d mockup(s) attached to the issue._", len(images))
+	case len(urls) > 0:
+		body += "\n\n_I couldn't look at the mockups linked in the issue, so this specification is based on the PRD alone. Check it against them._"
+	}
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactUISpec, body)
+	b.saveArtifact(ArtifactUISpec, repoOwner, repoName, issue, body, comment)
+}
+
+// mockupURLs returns the URLs of the images in an issue body, at most
+// maxMockups.
+func mockupURLs(body string) []string {
+	var urls []string
+	for _, m := range mockupPattern.FindAllStringSubmatch(body, -1) {
+		u := m[1]
+		if u == "" {
+			u = m[2]
+		}
+		if urls = append(urls, u); len(urls) == maxMockups {
+			break
+		}
+	}
+	return urls
+}
+
+// downloadMockups downloads the images GitHub hosts among urls. Images that
+// can't be downloaded are skipped: the specification is written without them.
+func downloadMockups(ctx context.Context, urls []string) []modelImage {
+	client := &http.Client{Timeout: 30 * time.Second}
+	var images []modelImage
+	for _, u := range urls {
+		image, err := downloadMockup(ctx, client, u)
+		if err != nil {
+			log.Printf("Skipping mockup %s: %v", u, err)
+			continue
+		}
+		images = append(images, *image)
+	}
+	return images
+}
+
+func downloadMockup(ctx context.Context, client *http.Client, rawURL string) (*modelImage, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if !mockupHostAllowed(u.Host) {
+		return nil, fmt.Errorf("%s isn't a GitHub attachment host", u.Host)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if !mockupTypes[mediaType] {
+		return nil, fmt.Errorf("unsupported content type %q", mediaType)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxMockupSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxMockupSize {
+		return nil, fmt.Errorf("larger than %s", formatBytes(maxMockupSize))
+	}
+	return &modelImage{MIMEType: mediaType, Data: data}, nil
+}
+
+func mockupHostAllowed(host string) bool {
+	for _, pattern := range mockupHosts {
+		if ok, _ := path.Match(pattern, strings.ToLower(host)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func generateUISpec(ctx context.Context, llm Generator, issue *github.Issue, prd string, urls []string, images []modelImage) (string, error) {
+	var mockups string
+	switch {
+	case len(images) > 0:
+		mockups = "The attached images are the mockups of the feature. Follow their layout and components, and call out where they disagree with the PRD.\n\n"
+	case len(urls) > 0:
+		mockups = "The issue links mockups you can't see. Don't guess their content; note under Open Questions that the specification must be checked against them.\n\n"
+	}
+	prompt := fmt.Sprintf(
+		"Write the UI specification of the feature described in the following Product Requirements Document (PRD), for the designers and frontend engineers who will build it.\n\n"+
+			"%sFormat the output as GitHub-flavored Markdown under these headings:\n"+
+			"1.  **Screens:** (Each screen or view, its purpose, entry points and the main user actions)\n"+
+			"2.  **Component Inventory:** (A table with the columns `Component`, `Screen`, `Purpose` and `States`, listing every component, reusing existing design-system components where likely)\n"+
+			"3.  **States:** (Loading, empty, error, partial and success states of each screen, with their copy)\n"+
+			"4.  **Accessibility:** (Keyboard navigation, focus order, screen reader labels, color contrast and motion)\n"+
+			"5.  **Open Questions:** (Design decisions the PRD leaves open)\n\n"+
+			"**Issue Title:** %s\n\n"+
+			"**Here is the PRD:**\n%s",
+		mockups, issue.GetTitle(), prd,
+	)
+	ctx = withSystemPrompt(ctx, CommandUISpec)
+	var spec string
+	var err error
+	if generator, ok := llm.(ImageGenerator); ok && len(images) > 0 {
+		spec, err = generator.GenerateWithImages(ctx, prompt, images)
+	} else {
+		spec, err = llm.GenerateText(ctx, prompt)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to generate UI specification: %w", err)
+	}
+	return strings.TrimSpace(spec), nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+const uiSpecReply = "1.  **Screens:** Export dialog.\n\n2.  **Component Inventory:**\n\n| Component | Screen | Purpose | States |\n| --- | --- | --- | --- |\n| ExportButton | Reports | Starts the export | idle, busy |"
+
+// uiSpecPayload is a need_ui_spec comment on issue #42 with the issue body.
+func uiSpecPayload(t *testing.T, issueBody string) []byte {
+	t.Helper()
+	var event map[string]any
+	if err := json.Unmarshal(commentPayload(t, "@prd-bot need_ui_spec"), &event); err != nil {
+		t.Fatalf("decoding comment payload: %v", err)
+	}
+	event["issue"].(map[string]any)["body"] = issueBody
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatalf("encoding comment payload: %v", err)
+	}
+	return payload
+}
+
+// serveMockups serves a PNG at /dialog.png and HTML at /page.html, and allows
+// the server as a mockup host for the test.
+func serveMockups(t *testing.T) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/dialog.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG\r\n\x1a\nfake"))
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	saved := mockupHosts
+	mockupHosts = []string{strings.TrimPrefix(server.URL, "http://")}
+	t.Cleanup(func() { mockupHosts = saved })
+	return server
+}
+
+func TestUISpecWithMockups(t *testing.T) {
+	env := newTestEnv(t)
+	server := serveMockups(t)
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Write the UI specification", uiSpecReply)
+
+	body := "Users need CSV exports.\n\n![Export dialog](" + server.URL + "/dialog.png)\n" +
+		`<img width="300" src="` + server.URL + `/page.html">` + "\n![elsewhere](https://example.com/mock.png)"
+	env.deliverPayload(t, "issue_comment", uiSpecPayload(t, body))
+
+	prompt := env.gemini.receivedPrompts()[0]
+	if !strings.Contains(prompt, "Users export reports as CSV.") || !strings.Contains(prompt, "attached images are the mockups") {
+		t.Errorf("prompt should include the PRD and refer to the mockups:\n%s", prompt)
+	}
+	if images := env.gemini.receivedImages(); !slices.Equal(images, []string{"image/png"}) {
+		t.Errorf("only the PNG from an allowed host should be sent, got %v", images)
+	}
+	if system := env.gemini.receivedSystemPrompts()[0]; !strings.Contains(system, "product designer") {
+		t.Errorf("unexpected system prompt %q", system)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	reply := comments[len(comments)-1].GetBody()
+	if !strings.HasPrefix(reply, UISpecIdentifier) || !strings.Contains(reply, "| ExportButton |") || !strings.Contains(reply, "1 mockup(s)") {
+		t.Errorf("unexpected UI specification comment:\n%s", reply)
+	}
+	if artifact, _ := env.bot.loadArtifact("acme", "widgets", 42, ArtifactUISpec); artifact == nil || artifact.Markdown != stripArtifactMeta(reply) {
+		t.Errorf("UI specification artifact = %+v", artifact)
+	}
+}
+
+func TestUISpecWithUnreadableMockups(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addComment("acme", "widgets", 42, capacityPRD)
+	env.gemini.on("Write the UI specification", uiSpecReply)
+
+	env.deliverPayload(t, "issue_comment", uiSpecPayload(t, "![mock](https://example.com/mock.png)"))
+
+	if prompt := env.gemini.receivedPrompts()[0]; !strings.Contains(prompt, "links mockups you can't see") {
+		t.Errorf("prompt should say the mockups couldn't be read:\n%s", prompt)
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if reply := comments[len(comments)-1].GetBody(); !strings.Contains(reply, "based on the PRD alone") {
+		t.Errorf("the comment should warn the mockups weren't used:\n%s", reply)
+	}
+}
+
+func TestUISpecWithoutPRD(t *testing.T) {
+	env := newTestEnv(t)
+	env.comment(t, "@prd-bot need_ui_spec")
+
+	if prompts := env.gemini.receivedPrompts(); len(prompts) != 0 {
+		t.Errorf("the model shouldn't be called without a PRD, got %d prompts", len(prompts))
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.Contains(body, "@prd-bot need_prd") {
+		t.Errorf("expected a hint to generate the PRD first:\n%s", body)
+	}
+}
+
+func TestMockupURLs(t *testing.T) {
+	body := "![a](https://github.com/user-attachments/assets/1) text <img alt=\"b\" src='https://x.githubusercontent.com/2.png'>\n" +
+		"![c](<https://github.com/3.png> \"title\") ![d](https://github.com/4) ![e](https://github.com/5)"
+	want := []string{"https://github.com/user-attachments/assets/1", "https://x.githubusercontent.com/2.png", "https://github.com/3.png", "https://github.com/4"}
+	if got := mockupURLs(body); !slices.Equal(got, want) {
+		t.Errorf("mockupURLs = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// maxViewerResults limits the artifacts and jobs a viewer page lists.
+	maxViewerResults = 100
+
+	// snippetRunes is the length of the excerpt shown with a search result.
+	snippetRunes = 200
+)
+
+// registerViewerHandlers adds the artifact viewer, for people who follow
+// the bot's work outside GitHub, to mux. Every page requires an OIDC
+// sign-in.
+func (b *Bot) registerViewerHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("GET /viewer", b.handleViewerSearch)
+	mux.HandleFunc("GET /viewer/artifacts/{owner}/{repo}/{number}/{kind}", b.handleViewerArtifact)
+	mux.HandleFunc("GET /viewer/jobs", b.handleViewerJobs)
+	mux.HandleFunc("GET /viewer/login", b.handleViewerLogin)
+	mux.HandleFunc("GET /viewer/callback", b.handleViewerCallback)
+	mux.HandleFunc("POST /viewer/logout", b.handleViewerLogout)
+}
+
+// viewerHit is an artifact matching a search.
+type viewerHit struct {
+	Artifact
+	Snippet string
+	score   int
+}
+
+func (h viewerHit) Path() string {
+	return "/viewer/artifacts/" + h.Owner + "/" + h.Repo + "/" + strconv.Itoa(h.Issue) + "/" + h.Kind
+}
+
+// searchArtifacts returns the artifacts containing every term of query, in
+// their title, repository, kind or text, best matches first. An empty query
+// returns the latest artifacts. repo, when set, limits the search to a
+// repository ("owner/repo") or an owner.
+func searchArtifacts(artifacts []Artifact, query, repo string) []viewerHit {
+	terms := strings.Fields(strings.ToLower(query))
+	var hits []viewerHit
+	for _, artifact := range artifacts {
+		if !matchesRepo(artifact.Owner, artifact.Repo, repo) {
+			continue
+		}
+		text := strings.ToLower(strings.Join([]string{artifact.Title, artifact.Owner + "/" + artifact.Repo, artifact.Kind, artifact.Markdown}, "\n"))
+		hit := viewerHit{Artifact: artifact}
+		for _, term := range terms {
+			n := strings.Count(text, term)
+			if n == 0 {
+				hit.score = -1
+				break
+			}
+			hit.score += n
+		}
+		if hit.score < 0 {
+			continue
+		}
+		hit.Snippet = snippet(artifact.Markdown, terms)
+		hits = append(hits, hit)
+	}
+	slices.SortFunc(hits, func(a, b viewerHit) int {
+		return cmp.Or(cmp.Compare(b.score, a.score), b.CreatedAt.Compare(a.CreatedAt))
+	})
+	return hits[:min(len(hits), maxViewerResults)]
+}
+
+func matchesRepo(owner, name, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	if !strings.Contains(filter, "/") {
+		return strings.EqualFold(owner, filter)
+	}
+	return strings.EqualFold(owner+"/"+name, filter)
+}
+
+// snippet returns the part of text around the first term found in it, or
+// its beginning.
+func snippet(text string, terms []string) string {
+	start := 0
+	for _, term := range terms {
+		if loc := regexp.MustCompile("(?i)" + regexp.QuoteMeta(term)).FindStringIndex(text); loc != nil {
+			start = max(0, utf8.RuneCountInString(text[:loc[0]])-snippetRunes/4)
+			break
+		}
+	}
+	runes := []rune(text)
+	end := min(len(runes), start+snippetRunes)
+	s := strings.Join(strings.Fields(string(runes[start:end])), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
+
+// loadArtifacts returns every stored artifact.
+func (b *Bot) loadArtifacts() ([]Artifact, error) {
+	docs, err := b.store.List(bucketArtifacts)
+	if err != nil {
+		return nil, err
+	}
+	artifacts := make([]Artifact, 0, len(docs))
+	for key, doc := range docs {
+		var artifact Artifact
+		if err := json.Unmarshal(doc, &artifact); err != nil {
+			log.Printf("Skipping unreadable artifact %s: %v", key, err)
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// viewerPage is what the viewer templates render.
+type viewerPage struct {
+	User     *viewerSession
+	Query    string
+	Repo     string
+	Hits     []viewerHit
+	Artifact *Artifact
+	Jobs     []jobRecord
+}
+
+// handleViewerSearch lists the artifacts matching a full-text search:
+// GET /viewer?q=csv+export&repo=acme/widgets
+func (b *Bot) handleViewerSearch(w http.ResponseWriter, r *http.Request) {
+	session := b.requireViewer(w, r)
+	if session == nil {
+		return
+	}
+	artifacts, err := b.loadArtifacts()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := viewerPage{User: session, Query: r.URL.Query().Get("q"), Repo: r.URL.Query().Get("repo")}
+	page.Hits = searchArtifacts(artifacts, page.Query, page.Repo)
+	renderViewer(w, "search", page)
+}
+
+// handleViewerArtifact shows an artifact:
+// GET /viewer/artifacts/{owner}/{repo}/{number}/{kind}
+func (b *Bot) handleViewerArtifact(w http.ResponseWriter, r *http.Request) {
+	session := b.requireViewer(w, r)
+	if session == nil {
+		return
+	}
+	issueNum, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		http.Error(w, "Invalid issue number", http.StatusBadRequest)
+		return
+	}
+	artifact, err := b.loadArtifact(r.PathValue("owner"), r.PathValue("repo"), issueNum, r.PathValue("kind"))
+	if err != nil {
+		http.Error(w, "Error loading artifact", http.StatusInternalServerError)
+		return
+	}
+	if artifact == nil {
+		http.Error(w, "Artifact not found", http.StatusNotFound)
+		return
+	}
+	renderViewer(w, "artifact", viewerPage{User: session, Artifact: artifact})
+}
+
+// handleViewerJobs lists the latest commands the bot ran:
+// GET /viewer/jobs?repo=acme/widgets
+func (b *Bot) handleViewerJobs(w http.ResponseWriter, r *http.Request) {
+	session := b.requireViewer(w, r)
+	if session == nil {
+		return
+	}
+	docs, err := b.store.List(bucketJobHistory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	page := viewerPage{User: session, Repo: r.URL.Query().Get("repo")}
+	for key, doc := range docs {
+		var job jobRecord
+		if err := json.Unmarshal(doc, &job); err != nil {
+			log.Printf("Skipping unreadable job %s: %v", key, err)
+			continue
+		}
+		if matchesRepo(job.Owner, job.Repo, page.Repo) {
+			page.Jobs = append(page.Jobs, job)
+		}
+	}
+	slices.SortFunc(page.Jobs, func(a, b jobRecord) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	page.Jobs = page.Jobs[:min(len(page.Jobs), maxViewerResults)]
+	renderViewer(w, "jobs", page)
+}
+
+func renderViewer(w http.ResponseWriter, name string, page viewerPage) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := viewerTemplates.ExecuteTemplate(w, name, page); err != nil {
+		log.Printf("Error rendering the %s viewer page: %v", name, err)
+	}
+}
+
+var viewerTemplates = template.Must(template.New("viewer").Funcs(template.FuncMap{
+	"duration": func(seconds float64) string {
+		return (time.Duration(seconds * float64(time.Second))).Round(100 * time.Millisecond).String()
+	},
+}).Parse(`{{define "header"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>PRD bot viewer</title>
+<style>
+body { font-family: -apple-system, BlinkMacSystemFont, "Segoe UI", sans-serif; margin: 2rem; color: #1f2328; max-width: 70rem; }
+nav { display: flex; gap: 1rem; align-items: center; margin-bottom: 1.5rem; }
+nav form { margin-left: auto; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d0d7de; padding: .4rem .8rem; text-align: left; }
+th { background: #f6f8fa; }
+.hit { margin-bottom: 1.2rem; }
+.meta, .snippet { color: #59636e; font-size: .9rem; }
+pre { white-space: pre-wrap; background: #f6f8fa; padding: 1rem; }
+.failed { color: #d1242f; }
+</style>
+</head>
+<body>
+<nav><a href="/viewer">Artifacts</a><a href="/viewer/jobs">Job history</a>
+<form method="post" action="/viewer/logout">{{.User.Email}} <button>Sign out</button></form></nav>
+{{end}}
+
+{{define "search"}}{{template "header" .}}
+<form method="get" action="/viewer">
+<input name="q" value="{{.Query}}" placeholder="Search PRDs, specs and plans" size="40">
+<input name="repo" value="{{.Repo}}" placeholder="owner or owner/repo">
+<button>Search</button>
+</form>
+{{if .Hits}}{{range .Hits}}
+<div class="hit"><a href="{{.Path}}">{{.Owner}}/{{.Repo}}#{{.Issue}} {{.Title}}</a>
+<div class="meta">{{.Kind}} · {{.CreatedAt.Format "2006-01-02 15:04"}}</div>
+{{with .Snippet}}<div class="snippet">{{.}}</div>{{end}}</div>
+{{end}}{{else}}<p>No artifacts found.</p>{{end}}
+</body></html>{{end}}
+
+{{define "artifact"}}{{template "header" .}}{{with .Artifact}}
+<h1>{{.Owner}}/{{.Repo}}#{{.Issue}} {{.Title}}</h1>
+<p class="meta">{{.Kind}} · {{.CreatedAt.Format "2006-01-02 15:04"}}{{with .CommentURL}} · <a href="{{.}}">View on GitHub</a>{{end}}</p>
+{{if .Markdown}}<pre>{{.Markdown}}</pre>{{else}}<p>The text of this artifact isn't stored on this server.</p>{{end}}
+{{end}}</body></html>{{end}}
+
+{{define "jobs"}}{{template "header" .}}
+<form method="get" action="/viewer/jobs">
+<input name="repo" value="{{.Repo}}" placeholder="owner or owner/repo">
+<button>Filter</button>
+</form>
+{{if .Jobs}}
+<table>
+<thead><tr><th>Started</th><th>Command</th><th>Issue</th><th>Requested by</th><th>Duration</th><th>Result</th></tr></thead>
+<tbody>
+{{range .Jobs}}<tr><td>{{.CreatedAt.Format "2006-01-02 15:04"}}</td><td>{{.Command}}</td><td>{{.Owner}}/{{.Repo}}#{{.Issue}}</td><td>{{.Sender}}</td><td>{{duration .Seconds}}</td><td>{{if .Failure}}<span class="failed">{{.Failure}}</span>{{else}}ok{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{else}}<p>No jobs recorded yet.</p>{{end}}
+</body></html>{{end}}
+`))
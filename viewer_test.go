@@ -0,0 +1,248 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+// fakeOIDCProvider is an OpenID Connect provider issuing ID tokens for
+// email, signed with key.
+type fakeOIDCProvider struct {
+	*httptest.Server
+	key   *rsa.PrivateKey
+	email string
+	nonce string // of the pending sign-in
+}
+
+func newFakeOIDCProvider(t *testing.T, email string) *fakeOIDCProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeOIDCProvider{key: key, email: email}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+			"jwks_uri":               p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "k1",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "viewer" || secret != "shh" || r.FormValue("code") != "c0de" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": p.idToken(t, map[string]any{
+			"iss": p.URL, "aud": "viewer", "exp": time.Now().Add(time.Hour).Unix(), "nonce": p.nonce, "email": p.email, "email_verified": true,
+		})})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeOIDCProvider) idToken(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(map[string]string{"alg": "RS256", "kid": "k1"}) + "." + segment(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// viewerClient sends requests to the viewer handlers, keeping its cookies.
+type viewerClient struct {
+	mux     *http.ServeMux
+	cookies map[string]*http.Cookie
+}
+
+func (c *viewerClient) get(t *testing.T, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	c.mux.ServeHTTP(rec, req)
+	for _, cookie := range rec.Result().Cookies() {
+		c.cookies[cookie.Name] = cookie
+	}
+	return rec
+}
+
+// signIn goes through the sign-in flow of the viewer and returns the
+// callback's response.
+func signIn(t *testing.T, c *viewerClient, provider *fakeOIDCProvider, target string) *httptest.ResponseRecorder {
+	t.Helper()
+	rec := c.get(t, target)
+	if rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/viewer/login?") {
+		t.Fatalf("an anonymous request should be sent to sign in, got %d %s", rec.Code, rec.Header().Get("Location"))
+	}
+	rec = c.get(t, rec.Header().Get("Location"))
+	authorize, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil || !strings.HasPrefix(authorize.String(), provider.URL+"/authorize?") {
+		t.Fatalf("the login should redirect to the provider, got %s", rec.Header().Get("Location"))
+	}
+	if got := authorize.Query().Get("redirect_uri"); got != "https://bot.example.com/viewer/callback" {
+		t.Errorf("unexpected redirect URI %s", got)
+	}
+	provider.nonce = authorize.Query().Get("nonce")
+	return c.get(t, "/viewer/callback?"+url.Values{"code": {"c0de"}, "state": {authorize.Query().Get("state")}}.Encode())
+}
+
+func newViewerEnv(t *testing.T, email, domains string) (*testEnv, *viewerClient, *fakeOIDCProvider) {
+	t.Helper()
+	env := newTestEnv(t)
+	provider := newFakeOIDCProvider(t, email)
+	auth, err := newOIDCAuth(provider.URL, "viewer", "shh", "https://bot.example.com/", domains, "session-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.bot.viewerAuth = auth
+	mux := http.NewServeMux()
+	env.bot.registerViewerHandlers(mux)
+	return env, &viewerClient{mux: mux, cookies: make(map[string]*http.Cookie)}, provider
+}
+
+func TestViewerSignInAndSearch(t *testing.T) {
+	env, client, provider := newViewerEnv(t, "pm@acme.com", "acme.com")
+	issue := &github.Issue{Number: github.Int(42), Title: github.String("Export reports as CSV")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", issue, "1.  **Requirements:** An export button on every report; UTF-8 encoded CSV output.", nil)
+	other := &github.Issue{Number: github.Int(7), Title: github.String("Dark mode")}
+	env.bot.saveArtifact(ArtifactPRD, "acme", "widgets", other, "1.  **Requirements:** A dark theme.", nil)
+
+	rec := signIn(t, client, provider, "/viewer?q=csv")
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/viewer?q=csv" {
+		t.Fatalf("the callback should return to the search, got %d %s: %s", rec.Code, rec.Header().Get("Location"), rec.Body.String())
+	}
+	rec = client.get(t, "/viewer?q=CSV+export")
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.Contains(body, `href="/viewer/artifacts/acme/widgets/42/prd"`) || !strings.Contains(body, "UTF-8 encoded CSV output") || strings.Contains(body, "Dark mode") {
+		t.Errorf("unexpected search results (%d):\n%s", rec.Code, body)
+	}
+	if !strings.Contains(body, "pm@acme.com") {
+		t.Error("the page should show who is signed in")
+	}
+	if rec = client.get(t, "/viewer/artifacts/acme/widgets/7/prd"); !strings.Contains(rec.Body.String(), "A dark theme.") {
+		t.Errorf("unexpected artifact page:\n%s", rec.Body.String())
+	}
+}
+
+func TestViewerJobHistory(t *testing.T) {
+	env, client, provider := newViewerEnv(t, "pm@acme.com", "")
+	env.comment(t, "@prd-bot need_sub_task")
+
+	signIn(t, client, provider, "/viewer/jobs")
+	body := client.get(t, "/viewer/jobs?repo=acme").Body.String()
+	if !strings.Contains(body, "<td>need_sub_task</td><td>acme/widgets#42</td><td>alice</td>") {
+		t.Errorf("the job history should list the command:\n%s", body)
+	}
+}
+
+func TestViewerRejectsOtherDomains(t *testing.T) {
+	_, client, provider := newViewerEnv(t, "someone@gmail.com", "acme.com")
+	if rec := signIn(t, client, provider, "/viewer"); rec.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for an account outside the allowed domains, got %d", rec.Code)
+	}
+	if rec := client.get(t, "/viewer"); rec.Code != http.StatusFound {
+		t.Errorf("no session should be created, got %d", rec.Code)
+	}
+}
+
+func TestViewerSessionSurvivesRestart(t *testing.T) {
+	env, client, provider := newViewerEnv(t, "pm@acme.com", "acme.com")
+	signIn(t, client, provider, "/viewer/jobs")
+
+	restarted, err := newOIDCAuth(provider.URL, "viewer", "shh", "https://bot.example.com/", "acme.com", "session-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.bot.viewerAuth = restarted
+	if rec := client.get(t, "/viewer/jobs"); rec.Code != http.StatusOK {
+		t.Errorf("a session should be accepted with the same secret, got %d", rec.Code)
+	}
+	env.bot.viewerAuth, _ = newOIDCAuth(provider.URL, "viewer", "shh", "https://bot.example.com/", "acme.com", "rotated-secret")
+	if rec := client.get(t, "/viewer/jobs"); rec.Code != http.StatusFound {
+		t.Errorf("a session signed with another secret shouldn't sign in, got %d", rec.Code)
+	}
+}
+
+func TestViewerRejectsLoginCookieAsSession(t *testing.T) {
+	env, client, _ := newViewerEnv(t, "pm@acme.com", "")
+	if rec := client.get(t, "/viewer/login?return=/viewer/jobs"); rec.Code != http.StatusFound {
+		t.Fatalf("the login should redirect to the provider, got %d", rec.Code)
+	}
+	login := client.cookies[viewerLoginCookie]
+	client.cookies[viewerSessionCookie] = &http.Cookie{Name: viewerSessionCookie, Value: login.Value}
+	if rec := client.get(t, "/viewer/jobs"); rec.Code != http.StatusFound || !strings.HasPrefix(rec.Header().Get("Location"), "/viewer/login?") {
+		t.Errorf("a replayed login cookie shouldn't sign in, got %d:\n%s", rec.Code, rec.Body.String())
+	}
+
+	anonymous, err := env.bot.viewerAuth.seal(viewerSessionCookie, viewerSession{Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.cookies[viewerSessionCookie] = &http.Cookie{Name: viewerSessionCookie, Value: anonymous}
+	if rec := client.get(t, "/viewer/jobs"); rec.Code != http.StatusFound {
+		t.Errorf("a session without an email shouldn't sign in, got %d", rec.Code)
+	}
+}
+
+func TestViewerRejectsForgedTokens(t *testing.T) {
+	_, _, provider := newViewerEnv(t, "pm@acme.com", "")
+	auth, _ := newOIDCAuth(provider.URL, "viewer", "shh", "https://bot.example.com", "", "session-secret")
+	valid := map[string]any{"iss": provider.URL, "aud": []string{"viewer"}, "exp": time.Now().Add(time.Hour).Unix(), "nonce": "n"}
+	if _, err := auth.verifyIDToken(t.Context(), provider.idToken(t, valid), "n"); err != nil {
+		t.Fatalf("a valid token was rejected: %v", err)
+	}
+	for name, change := range map[string]func(map[string]any){
+		"audience": func(c map[string]any) { c["aud"] = "other" },
+		"expired":  func(c map[string]any) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"nonce":    func(c map[string]any) { c["nonce"] = "replayed" },
+		"issuer":   func(c map[string]any) { c["iss"] = "https://evil.example.com" },
+	} {
+		claims := map[string]any{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		change(claims)
+		if _, err := auth.verifyIDToken(t.Context(), provider.idToken(t, claims), "n"); err == nil {
+			t.Errorf("a token with a wrong %s was accepted", name)
+		}
+	}
+	token := provider.idToken(t, valid)
+	tampered := token[:len(token)-4] + "AAAA"
+	if _, err := auth.verifyIDToken(t.Context(), tampered, "n"); err == nil {
+		t.Error("a token with a forged signature was accepted")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// WizardIdentifier marks the questions posted by the wizard command.
+	WizardIdentifier = "### PRD Wizard"
+
+	// bucketWizard holds the wizard sessions in progress, keyed by issueKey.
+	bucketWizard = "wizard"
+)
+
+// wizardQuestion is one step of the wizard interview.
+type wizardQuestion struct {
+	Topic    string
+	Question string
+}
+
+var wizardQuestions = []wizardQuestion{
+	{"Problem", "What problem are you trying to solve? Describe what happens today and why it is a problem."},
+	{"Audience", "Who has this problem? Describe the people who would use the feature and how often."},
+	{"Constraints", "Are there any constraints, such as deadlines, budgets, platforms, or things that must not change?"},
+	{"Success", "How will you know the feature is a success? Describe what would be different once it ships."},
+}
+
+// wizardSession is the conversation state of a wizard in progress.
+type wizardSession struct {
+	Requester string   `json:"requester"`
+	Answers   []string `json:"answers"`
+}
+
+// processWizard starts a guided interview which asks the requester one
+// question at a time and writes the PRD from their answers. `wizard cancel`
+// abandons an interview in progress.
+func (b *Bot) processWizard(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, _ int64, args []string) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	log.Printf("Processing '%s' for issue #%d in %s/%s", CommandWizard, issueNum, repoOwner, repoName)
+	sender := commandSender(ctx)
+	if sender == nil {
+		return
+	}
+	key := issueKey(repoOwner, repoName, issueNum)
+
+	b.wizardMu.Lock()
+	defer b.wizardMu.Unlock()
+	var session wizardSession
+	active, err := b.store.Get(bucketWizard, key, &session)
+	if err != nil {
+		log.Printf("Error loading wizard session %s: %v", key, err)
+	}
+
+	if len(args) > 0 && strings.EqualFold(args[0], "cancel") {
+		if !active {
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, "There is no wizard in progress on this issue.")
+			return
+		}
+		if err := b.store.Delete(bucketWizard, key); err != nil {
+			log.Printf("Error deleting wizard session %s: %v", key, err)
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "The wizard was cancelled. Run the command again to start over.")
+		return
+	}
+	if active {
+		msg := fmt.Sprintf("A wizard for @%s is already in progress on this issue. Answer the last question, or run `@%s %s cancel` to start over.", session.Requester, b.appName, CommandWizard)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, msg)
+		return
+	}
+
+	session = wizardSession{Requester: sender.GetLogin()}
+	if err := b.store.Put(bucketWizard, key, &session); err != nil {
+		log.Printf("Error saving wizard session %s: %v", key, err)
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "I couldn't start the wizard. Please try again later.")
+		return
+	}
+	b.postComment(ctx, client, repoOwner, repoName, issueNum, b.wizardQuestion(&session))
+}
+
+// handleWizardAnswer records a comment as the answer to the current wizard
+// question when the commenter is running a wizard on the issue. It reports
+// whether the comment was taken as an answer.
+func (b *Bot) handleWizardAnswer(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, installationID int64, author *github.User, answer string) bool {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	key := issueKey(repoOwner, repoName, issueNum)
+	var session wizardSession
+	if ok, _ := b.store.Get(bucketWizard, key, &session); !ok || !strings.EqualFold(session.Requester, author.GetLogin()) {
+		return false
+	}
+
+	b.dispatch(ctx, func() {
+		ctx := b.withInstallation(withSender(context.Background(), author), installationID)
+		b.wizardMu.Lock()
+		defer b.wizardMu.Unlock()
+		// Reload the session: another answer may have been recorded meanwhile.
+		var session wizardSession
+		if ok, err := b.store.Get(bucketWizard, key, &session); !ok || err != nil {
+			return
+		}
+		session.Answers = append(session.Answers, strings.TrimSpace(answer))
+		if len(session.Answers) < len(wizardQuestions) {
+			if err := b.store.Put(bucketWizard, key, &session); err != nil {
+				log.Printf("Error saving wizard session %s: %v", key, err)
+			}
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, b.wizardQuestion(&session))
+			return
+		}
+
+		// The last answer isn't saved, so it can be sent again once the
+		// budget allows.
+		if status, ok := b.checkBudget(ctx, installationID); !ok {
+			log.Printf("Installation %d is over its monthly budget. Not writing the wizard PRD of issue #%d in %s/%s.", installationID, issueNum, repoOwner, repoName)
+			b.postComment(ctx, client, repoOwner, repoName, issueNum, b.budgetExceededMessage(CommandWizard, status)+"\n\nThen reply with your last answer again.")
+			return
+		}
+		log.Printf("Wizard for issue #%d in %s/%s complete. Generating the PRD.", issueNum, repoOwner, repoName)
+		if err := b.store.Delete(bucketWizard, key); err != nil {
+			log.Printf("Error deleting wizard session %s: %v", key, err)
+		}
+		b.postComment(ctx, client, repoOwner, repoName, issueNum, "Thanks, that's everything I need! I'm writing the PRD now.")
+		b.writeWizardPRD(ctx, client, issue, repo, &session)
+	})
+	return true
+}
+
+// wizardActive reports whether a wizard is in progress on the issue.
+func (b *Bot) wizardActive(owner, repo string, issueNum int) bool {
+	var session wizardSession
+	ok, _ := b.store.Get(bucketWizard, issueKey(owner, repo, issueNum), &session)
+	return ok
+}
+
+// wizardQuestion formats the next unanswered question of session.
+func (b *Bot) wizardQuestion(session *wizardSession) string {
+	step := len(session.Answers)
+	q := wizardQuestions[step]
+	return fmt.Sprintf("%s\n\n**Question %d of %d: %s**\n\n@%s, %s\n\n_Reply with a comment. Run `@%s %s cancel` to stop._",
+		WizardIdentifier, step+1, len(wizardQuestions), q.Topic, session.Requester, q.Question, b.appName, CommandWizard)
+}
+
+// wizardTranscript renders the interview as question and answer pairs.
+func wizardTranscript(session *wizardSession) string {
+	var t strings.Builder
+	for i, answer := range session.Answers {
+		fmt.Fprintf(&t, "**%s:** %s\n**Answer:** %s\n\n", wizardQuestions[i].Topic, wizardQuestions[i].Question, answer)
+	}
+	return t.String()
+}
+
+func (b *Bot) writeWizardPRD(ctx context.Context, client *github.Client, issue *github.Issue, repo *github.Repository, session *wizardSession) {
+	repoOwner, repoName, issueNum := repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber()
+	fail := func(reason string, err error) {
+		b.reportFailure(ctx, client, repoOwner, repoName, issueNum, "generate a PRD", reason, err)
+	}
+
+	cfg := b.repoConfig(ctx, client, repo)
+	ctx, readmeContent, err := b.specContext(ctx, client, repo, cfg.LongContext)
+	if err != nil {
+		fail("Could not read the repository README", err)
+		return
+	}
+
+	// The interview, written by the requester, carries the detail the PRD is
+	// based on, so it also decides the translation language.
+	body := fmt.Sprintf("%s\n\n**Requester Interview:**\n%s", issue.GetBody(), wizardTranscript(session))
+	ctx = b.withFeedback(withPrompts(ctx, cfg, repo), cfg, repo)
+	start := time.Now()
+	related := b.findRelatedWork(ctx, client, repo, issue)
+	prior := b.findPriorPRDs(ctx, repo, issue, cfg.PriorPRDCount())
+	prdContent, err := generatePRD(ctx, b.llm, issue.GetTitle(), body, readmeContent, cfg.Language, related, prior)
+	if err != nil {
+		fail("Could not generate the PRD", err)
+		return
+	}
+	b.recordPRD(repoOwner, repoName, time.Since(start))
+	prdContent = b.addExecutiveSummary(ctx, prdContent, cfg.PRDLayout)
+	prdContent = b.addReviewersFooter(ctx, client, repo, issue, prdContent, cfg.Stakeholders)
+	prdContent = b.versionNewPRD(repoOwner, repoName, issueNum, prdContent)
+
+	comment := b.postArtifact(ctx, client, repoOwner, repoName, issueNum, ArtifactPRD, prdContent)
+	b.saveArtifact(ArtifactPRD, repoOwner, repoName, issue, prdContent, comment)
+	b.publishEvent(EventPRDCreated, repoOwner, repoName, issueNum, comment.GetHTMLURL(), map[string]any{"title": issue.GetTitle()})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWizardInterviewsRequesterAndWritesPRD(t *testing.T) {
+	env := newTestEnv(t)
+	env.github.addFile("acme", "widgets", "README.md", "# Widgets")
+	env.gemini.on("Create a Product Requirements Document", "1.  **Background:** Analysts re-type reports.")
+	env.gemini.on("Detect the primary language", "English")
+	env.gemini.on("Translate the following English PRD", "1.  **Background:** Analysts re-type reports.")
+	env.gemini.on("executive summary", "- Analysts can export reports as CSV.")
+
+	env.comment(t, "@prd-bot wizard")
+	answers := []string{"Analysts re-type reports", "Finance analysts, daily", "Must ship by Q3", "No more re-typing"}
+	for i, answer := range answers {
+		comments := env.github.issueComments("acme", "widgets", 42)
+		question := comments[len(comments)-1].GetBody()
+		if !strings.HasPrefix(question, WizardIdentifier) || !strings.Contains(question, wizardQuestions[i].Question) {
+			t.Fatalf("expected question %d, got:\n%s", i+1, question)
+		}
+		env.comment(t, answer)
+	}
+
+	prompt := env.gemini.receivedPrompts()[0]
+	for _, answer := range answers {
+		if !strings.Contains(prompt, answer) {
+			t.Errorf("PRD prompt should include the answer %q:\n%s", answer, prompt)
+		}
+	}
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if body := comments[len(comments)-1].GetBody(); !strings.HasPrefix(body, PRDIdentifier) {
+		t.Errorf("expected the PRD as the last comment, got:\n%s", body)
+	}
+	if env.bot.wizardActive("acme", "widgets", 42) {
+		t.Error("the wizard session should be removed once the PRD is written")
+	}
+}
+
+func TestWizardIgnoresOtherCommentersAndCancels(t *testing.T) {
+	env := newTestEnv(t)
+	env.comment(t, "@prd-bot wizard")
+	if err := env.bot.store.Put(bucketWizard, issueKey("acme", "widgets", 42), &wizardSession{Requester: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	env.comment(t, "I am not bob")
+	if n := len(env.github.issueComments("acme", "widgets", 42)); n != 1 {
+		t.Errorf("a comment from someone else should not be taken as an answer, got %d comments", n)
+	}
+
+	env.comment(t, "@prd-bot wizard cancel")
+	if env.bot.wizardActive("acme", "widgets", 42) {
+		t.Error("wizard cancel should remove the session")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// defaultWorkspaceDir is the directory under the system temp directory that
+// holds working directories when WORKSPACE_ROOT is not set.
+const defaultWorkspaceDir = "agent-prd-workspaces"
+
+// workdirManager allocates the working directories of jobs (clones, archives)
+// under one root, so their disk usage can be bounded and directories leaked
+// by crashed jobs removed.
+type workdirManager struct {
+	root     string // "" for the system temp directory, without quota or cleanup
+	quota    int64  // bytes all working directories may use; 0 for no limit
+	jobLimit int64  // bytes a single working directory may use; 0 for no limit
+
+	mu sync.Mutex // serializes allocations
+}
+
+// allocate creates a working directory named after pattern, as in
+// os.MkdirTemp, unless the working directories already use the whole quota.
+// The caller removes it when the job ends.
+func (m *workdirManager) allocate(pattern string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.root == "" {
+		return os.MkdirTemp("", pattern)
+	}
+	if m.quota > 0 {
+		used, err := dirSize(m.root)
+		if err != nil {
+			return "", fmt.Errorf("measuring %s: %w", m.root, err)
+		}
+		if used >= m.quota {
+			return "", fmt.Errorf("%w: working directories use %s of %s", ErrDiskQuota, formatBytes(used), formatBytes(m.quota))
+		}
+	}
+	if err := os.MkdirAll(m.root, 0o700); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(m.root, pattern)
+}
+
+// check reports when dir outgrew the per-job limit or the working
+// directories outgrew the quota, e.g. after a clone.
+func (m *workdirManager) check(dir string) error {
+	if m.jobLimit > 0 {
+		size, err := dirSize(dir)
+		if err != nil {
+			return fmt.Errorf("measuring %s: %w", dir, err)
+		}
+		if size > m.jobLimit {
+			return fmt.Errorf("%w: the working directory uses %s, over the %s limit", ErrWorkspaceTooLarge, formatBytes(size), formatBytes(m.jobLimit))
+		}
+	}
+	if m.root != "" && m.quota > 0 {
+		used, err := dirSize(m.root)
+		if err != nil {
+			return fmt.Errorf("measuring %s: %w", m.root, err)
+		}
+		if used > m.quota {
+			return fmt.Errorf("%w: working directories use %s of %s", ErrDiskQuota, formatBytes(used), formatBytes(m.quota))
+		}
+	}
+	return nil
+}
+
+// removeLeaked removes the working directories left under the root by jobs
+// of a previous run that crashed or were killed, and returns how many it
+// removed. It must run before any job starts: the root belongs to one bot
+// process.
+func (m *workdirManager) removeLeaked() (int, error) {
+	if m.root == "" {
+		return 0, nil
+	}
+	entries, err := os.ReadDir(m.root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	for _, entry := range entries {
+		if err := os.RemoveAll(filepath.Join(m.root, entry.Name())); err != nil {
+			return 0, err
+		}
+	}
+	return len(entries), nil
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files removed by a job while walking don't count.
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes such as "512MiB", "10G" or "1048576". An empty
+// string is 0, no limit.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if number, ok := strings.CutSuffix(strings.ToUpper(s), strings.ToUpper(u.suffix)); ok {
+			s, unit = strings.TrimSpace(number), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(unit)), nil
+}
+
+// formatBytes renders n bytes with a binary unit, e.g. "1.5 GiB".
+func formatBytes(n int64) string {
+	for _, u := range byteUnits[:4] {
+		if n >= u.size {
+			return strconv.FormatFloat(float64(n)/float64(u.size), 'f', 1, 64) + " " + u.suffix
+		}
+	}
+	return strconv.FormatInt(n, 10) + " B"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"":        0,
+		"1048576": 1 << 20,
+		"512MiB":  512 << 20,
+		"10G":     10 << 30,
+		"1.5 GiB": 3 << 29,
+		"2gb":     2e9,
+		"100 B":   100,
+	}
+	for in, want := range tests {
+		if got, err := parseByteSize(in); err != nil || got != want {
+			t.Errorf("parseByteSize(%q) = %d, %v, want %d", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"lots", "-1G", "G"} {
+		if _, err := parseByteSize(in); err == nil {
+			t.Errorf("parseByteSize(%q) should fail", in)
+		}
+	}
+}
+
+func writeBytes(t *testing.T, path string, n int) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", n)), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWorkdirQuota(t *testing.T) {
+	m := &workdirManager{root: filepath.Join(t.TempDir(), "workspaces"), quota: 100}
+	dir, err := m.allocate("repo-1-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(dir, m.root) {
+		t.Errorf("%s should be under %s", dir, m.root)
+	}
+	writeBytes(t, filepath.Join(dir, "big"), 150)
+
+	if err := m.check(dir); !errors.Is(err, ErrDiskQuota) {
+		t.Errorf("check = %v, want ErrDiskQuota", err)
+	}
+	if _, err := m.allocate("repo-2-*"); !errors.Is(err, ErrDiskQuota) {
+		t.Errorf("allocate over quota = %v, want ErrDiskQuota", err)
+	}
+	os.RemoveAll(dir)
+	if _, err := m.allocate("repo-2-*"); err != nil {
+		t.Errorf("allocate after release = %v", err)
+	}
+}
+
+func TestWorkdirJobLimit(t *testing.T) {
+	m := &workdirManager{root: t.TempDir(), jobLimit: 10}
+	dir, err := m.allocate("repo-1-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBytes(t, filepath.Join(dir, "small"), 10)
+	if err := m.check(dir); err != nil {
+		t.Errorf("check at the limit = %v", err)
+	}
+	writeBytes(t, filepath.Join(dir, "more"), 1)
+	if err := m.check(dir); !errors.Is(err, ErrWorkspaceTooLarge) {
+		t.Errorf("check = %v, want ErrWorkspaceTooLarge", err)
+	}
+}
+
+func TestRemoveLeakedWorkdirs(t *testing.T) {
+	m := &workdirManager{root: t.TempDir()}
+	for _, pattern := range []string{"repo-1-*", "rebase-2-*"} {
+		dir, err := m.allocate(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeBytes(t, filepath.Join(dir, "file"), 1)
+	}
+
+	if removed, err := m.removeLeaked(); err != nil || removed != 2 {
+		t.Errorf("removeLeaked = %d, %v, want 2", removed, err)
+	}
+	if entries, _ := os.ReadDir(m.root); len(entries) != 0 {
+		t.Errorf("%d entries left in the root", len(entries))
+	}
+	if removed, err := (&workdirManager{root: filepath.Join(m.root, "missing")}).removeLeaked(); err != nil || removed != 0 {
+		t.Errorf("removeLeaked without a root = %d, %v", removed, err)
+	}
+}
+
+func TestImplementFeatureRejectsOversizedClone(t *testing.T) {
+	env := newTestEnv(t)
+	env.bot.workdirs = &workdirManager{root: t.TempDir(), jobLimit: 1 << 10}
+	env.runner.effects = map[string]func(string){
+		"git clone": func(dir string) { writeBytes(t, filepath.Join(dir, "huge.bin"), 2<<10) },
+	}
+
+	env.deliver(t, "issue_comment", "issue_comment_implement_feature.json")
+
+	comments := env.github.issueComments("acme", "widgets", 42)
+	if last := comments[len(comments)-1].GetBody(); !strings.Contains(last, "**Error code:** `WORKSPACE_TOO_LARGE`") {
+		t.Errorf("unexpected failure comment:\n%s", last)
+	}
+	if entries, _ := os.ReadDir(env.bot.workdirs.root); len(entries) != 0 {
+		t.Errorf("the working directory should be removed, %d entries left", len(entries))
+	}
+	if pulls := env.github.pullRequests(); len(pulls) != 0 {
+		t.Errorf("no pull request should be opened, got %d", len(pulls))
+	}
+}
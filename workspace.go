@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/google/go-github/v58/github"
+)
+
+const (
+	// Values of COMMIT_BACKEND.
+	commitBackendGit = "git" // clone and push over HTTPS with the git binary
+	commitBackendAPI = "api" // download an archive and commit through the Git Data API
+)
+
+// workspace is a working copy of a repository's branch that
+// implement_feature edits and publishes as new branches off it.
+type workspace interface {
+	// dir is the directory holding the files.
+	dir() string
+	// branchExists reports whether the repository already has branch.
+	branchExists(branch string) bool
+	// changes returns the edits made in dir as a zero-context diff and
+	// per-file line counts. Either may be empty when it can't be computed.
+	changes() (diff string, stats []fileStat, err error)
+	// publish commits every change with message to a new branch, or, with
+	// a lease, to an existing branch whose commits it replaces as long as
+	// the branch still points at the lease. It returns the commit it pushed,
+	// fails with ErrNoChanges when nothing changed and with ErrBranchChanged
+	// when the branch moved away from the lease.
+	publish(branch, message, lease string) (string, error)
+	// publishFiles commits the changes of files with message to a new
+	// branch off the base branch.
+	publishFiles(branch, message string, files []string) error
+	// close removes the working copy.
+	close()
+}
+
+// openWorkspace checks out the base branch of repo with the configured
+// commit backend. With files, only those are fetched, through the Git Data
+// API whatever the backend.
+func (b *Bot) openWorkspace(ctx context.Context, client *github.Client, repo *github.Repository, base string, installationID int64, issueNum int, files []string) (workspace, error) {
+	tempDir, err := b.workdirs.allocate(fmt.Sprintf("repo-%d-*", issueNum))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Created temporary directory: %s", tempDir)
+	var ws workspace
+	if files != nil || b.commitBackend == commitBackendAPI {
+		ws, err = openAPIWorkspace(ctx, client, repo, base, tempDir, files)
+	} else {
+		ws, err = b.openGitWorkspace(ctx, repo, base, installationID, tempDir)
+	}
+	if err == nil {
+		err = b.workdirs.check(tempDir)
+	}
+	if err != nil {
+		os.RemoveAll(tempDir)
+		return nil, err
+	}
+	return ws, nil
+}
+
+// gitWorkspace is a clone of the repository, published with git push.
+type gitWorkspace struct {
+	b       *Bot
+	path    string
+	base    string // the branch the clone works on
+	staged  bool
+	sourced bool // the changes are committed on sourceBranch
+}
+
+// sourceBranch holds the full change locally while parts of it are published.
+const sourceBranch = "agent-prd-source"
+
+func (b *Bot) openGitWorkspace(ctx context.Context, repo *github.Repository, base string, installationID int64, dir string) (*gitWorkspace, error) {
+	token, err := b.clients.Token(ctx, installationID)
+	if err != nil {
+		return nil, fmt.Errorf("getting an installation token: %w", err)
+	}
+	cloneURL := fmt.Sprintf("https://x-access-token:%s@%s/%s/%s.git", token, b.gitHost, repo.GetOwner().GetLogin(), repo.GetName())
+	if out, err := b.runner(dir, "git", "clone", cloneURL, "."); err != nil {
+		return nil, gitError(ErrCloneFailed, out, err)
+	}
+	if base != repo.GetDefaultBranch() {
+		if out, err := b.runner(dir, "git", "checkout", base); err != nil {
+			return nil, gitError(ErrCloneFailed, out, err)
+		}
+	}
+	if err := b.configureGitIdentity(dir); err != nil {
+		return nil, err
+	}
+	return &gitWorkspace{b: b, path: dir, base: base}, nil
+}
+
+func (w *gitWorkspace) dir() string { return w.path }
+func (w *gitWorkspace) close()      { os.RemoveAll(w.path) }
+
+func (w *gitWorkspace) branchExists(branch string) bool {
+	out, err := w.b.runner(w.path, "git", "ls-remote", "--heads", "origin", branch)
+	return err == nil && strings.TrimSpace(out) != ""
+}
+
+func (w *gitWorkspace) stage() error {
+	if w.staged {
+		return nil
+	}
+	if out, err := w.b.runner(w.path, "git", "add", "."); err != nil {
+		return gitError(ErrGitFailed, out, err)
+	}
+	w.staged = true
+	return nil
+}
+
+func (w *gitWorkspace) changes() (string, []fileStat, error) {
+	if err := w.stage(); err != nil {
+		return "", nil, err
+	}
+	diff, err := w.b.runner(w.path, "git", "diff", "--cached", "--unified=0")
+	if err != nil {
+		log.Printf("Could not diff the changes in %s: %v", w.path, err)
+		diff = ""
+	}
+	numstat, err := w.b.runner(w.path, "git", "diff", "--cached", "--numstat", "--no-renames")
+	if err != nil {
+		log.Printf("Could not measure the changes in %s: %v", w.path, err)
+		numstat = ""
+	}
+	return diff, parseNumstat(numstat), nil
+}
+
+func (w *gitWorkspace) publish(branch, message, lease string) (string, error) {
+	if err := w.stage(); err != nil {
+		return "", err
+	}
+	if out, err := w.b.runner(w.path, "git", "checkout", "-b", branch); err != nil {
+		return "", gitError(ErrGitFailed, out, err)
+	}
+	if out, err := w.b.runner(w.path, "git", "commit", "-m", message); err != nil {
+		// Pushing an empty branch would fail later, as "No commits between"
+		// when opening the pull request.
+		if strings.Contains(out, "nothing to commit") {
+			return "", fmt.Errorf("%w: %s", ErrNoChanges, strings.TrimSpace(out))
+		}
+		return "", gitError(ErrGitFailed, out, err)
+	}
+	head, err := w.b.runner(w.path, "git", "rev-parse", "HEAD")
+	if err != nil {
+		return "", gitError(ErrGitFailed, head, err)
+	}
+	push := []string{"push", "origin", branch}
+	if lease != "" {
+		push = []string{"push", "--force-with-lease=" + branch + ":" + lease, "origin", branch}
+	}
+	if out, err := w.b.runner(w.path, "git", push...); err != nil {
+		if lease != "" && strings.Contains(out, "stale info") {
+			return "", fmt.Errorf("%w: %s is no longer at %s", ErrBranchChanged, branch, lease)
+		}
+		return "", gitError(ErrPushFailed, out, err)
+	}
+	return strings.TrimSpace(head), nil
+}
+
+func (w *gitWorkspace) publishFiles(branch, message string, files []string) error {
+	if !w.sourced {
+		if err := w.stage(); err != nil {
+			return err
+		}
+		if out, err := w.b.runner(w.path, "git", "checkout", "-b", sourceBranch); err != nil {
+			return gitError(ErrGitFailed, out, err)
+		}
+		if out, err := w.b.runner(w.path, "git", "commit", "-m", message); err != nil {
+			return gitError(ErrGitFailed, out, err)
+		}
+		w.sourced = true
+	}
+	if out, err := w.b.runner(w.path, "git", "checkout", "-b", branch, "origin/"+w.base); err != nil {
+		return gitError(ErrGitFailed, out, err)
+	}
+	restore := append([]string{"restore", "--source=" + sourceBranch, "--staged", "--worktree", "--"}, files...)
+	if out, err := w.b.runner(w.path, "git", restore...); err != nil {
+		return gitError(ErrGitFailed, out, err)
+	}
+	if out, err := w.b.runner(w.path, "git", "commit", "-m", message); err != nil {
+		return gitError(ErrGitFailed, out, err)
+	}
+	if out, err := w.b.runner(w.path, "git", "push", "origin", branch); err != nil {
+		return gitError(ErrPushFailed, out, err)
+	}
+	return nil
+}